@@ -0,0 +1,53 @@
+package api
+
+import (
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Version is the release version of the API.  Release builds set it with
+//
+//	go build -ldflags "-X github.com/adllev/voter-api/api.Version=1.2.0"
+var Version = "1.0.0"
+
+// apiVersions are the versions of the HTTP API this build serves
+var apiVersions = []string{apiVersionLegacy, apiVersionCanonical}
+
+// setFeature records an optional feature and its setting for /about
+func (td *VoterAPI) setFeature(name string, value any) {
+	td.features[name] = value
+}
+
+// implementation for GET /about
+// describes this deployment: version, build, the optional features that
+// are turned on and the storage in use, so client tooling can adapt
+func (td *VoterAPI) About(c *fiber.Ctx) error {
+	rsp := fiber.Map{
+		"version":      Version,
+		"git_sha":      "",
+		"build_time":   "",
+		"modified":     false,
+		"go_version":   "",
+		"features":     td.features,
+		"storage":      "memory",
+		"api_versions": apiVersions,
+	}
+
+	//The VCS stamp is only there when built from a checkout with go build
+	if info, ok := debug.ReadBuildInfo(); ok {
+		rsp["go_version"] = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				rsp["git_sha"] = setting.Value
+			case "vcs.time":
+				rsp["build_time"] = setting.Value
+			case "vcs.modified":
+				rsp["modified"] = setting.Value == "true"
+			}
+		}
+	}
+
+	return c.JSON(rsp)
+}
@@ -0,0 +1,464 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AnonymousRole is the role of requests that do not carry an API key
+const AnonymousRole = "anonymous"
+
+// allFields in a role's field list makes every voter field visible
+const allFields = "*"
+
+// APIKey is a key from the access config and the role it grants
+type APIKey struct {
+	Key        string
+	Name       string
+	Role       string
+	TOTPSecret string  //Base32 TOTP secret for step-up, empty if not enrolled
+	RateLimit  float64 //Requests per second, 0 for no limit
+	Burst      int     //Requests allowed at once, defaults to the rate
+	Bulk       bool    //Bulk integrator, requests over the limit are queued
+	Source     string  //Upstream the key writes for, e.g. state-feed, the key's Name if empty
+	//Jurisdictions the key is limited to, with everything inside them.
+	//Empty for the whole roll.
+	Jurisdictions []int
+	Scopes        []string  //db.ScopeRead, db.ScopeWrite or db.ScopeAdmin, empty for all
+	Expires       time.Time //Zero if the key does not expire
+}
+
+// AccessConfig is the access control configuration file.  Roles maps a
+// role to the voter fields it may see, for example
+//
+//	{
+//	  "Keys":  [{"Key": "s3cret", "Name": "county-registrar", "Role": "registrar"}],
+//	  "Roles": {"registrar": ["*"], "pollworker": ["Name", "PrecinctId"]}
+//	}
+//
+// VoterId is always visible.  A role that is not listed sees only VoterId.
+// A key with a TOTPSecret can step up for the most dangerous operations.
+// A key with a RateLimit is held to it, see RateLimit.  A key with
+// Jurisdictions only sees and changes the voters registered in them.  The
+// Source of a key is what db.ProvenanceRules name as the owner of a field.
+// The keys are loaded into /admin/apikeys when the server starts, where
+// they can be rotated and revoked like the keys created there.
+type AccessConfig struct {
+	Keys  []APIKey
+	Roles map[string][]string
+}
+
+// principal is the caller of a request as identified by its API key
+type principal struct {
+	Name   string
+	Role   string
+	Source string //Source of the caller's writes, see db.ProvenanceRules
+
+	totpSecret string
+	key        string       //API key that identified the caller, empty for others
+	token      bool         //Identified by a scoped token of the key, see token.go
+	scope      map[int]bool //Precincts the caller is limited to, nil for all
+	voters     map[int]bool //Voters the caller is limited to, nil for all
+}
+
+// LoadAccessConfig reads the access control configuration from a JSON file
+func LoadAccessConfig(path string) (*AccessConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg AccessConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, key := range cfg.Keys {
+		if key.Key == "" || key.Role == "" {
+			return nil, errors.New("every API key needs a key and a role")
+		}
+		if key.Name != "" && names[key.Name] {
+			return nil, errors.New("API key " + key.Name + " is listed twice")
+		}
+		names[key.Name] = true
+	}
+
+	return &cfg, nil
+}
+
+// EnableAccessControl turns on API keys and field level access control.
+// Without it every caller sees every field, with it callers without a key
+// only read.
+func (td *VoterAPI) EnableAccessControl(cfg *AccessConfig) {
+	td.access = cfg
+	for _, key := range cfg.Keys {
+		err := td.apiKeys.ImportKey(db.APIKey{
+			Name:          key.Name,
+			Role:          key.Role,
+			Scopes:        key.Scopes,
+			Jurisdictions: key.Jurisdictions,
+			Source:        key.Source,
+			RateLimit:     key.RateLimit,
+			Burst:         key.Burst,
+			Bulk:          key.Bulk,
+			Expires:       key.Expires,
+		}, key.Key, key.TOTPSecret)
+		if err != nil {
+			log.Println("Error loading API key: ", err)
+		}
+	}
+	td.setFeature("access_control", true)
+}
+
+// piiFields are the voter fields whose reads go to the access log
+var piiFields = []string{"Name", "Email", "Address", "AddressHistory",
+	"VoteHistory", "Preferences"}
+
+// AccessControl is the middleware that identifies the caller by the
+// X-API-Key header, a scoped token in an Authorization: Bearer header, or
+// the X-Session-Token of an admin who logged in with WebAuthn, and once
+// the handler is done, strips every voter field the caller's role is not
+// allowed to see from the JSON response.  Doing this on the serialized
+// response means every endpoint is covered, no matter how it builds its
+// output.  Every voter whose PII is left in the response is then written
+// to the access log, and any decoy in it trips an alert.
+func (td *VoterAPI) AccessControl(c *fiber.Ctx) error {
+	caller := principal{Name: AnonymousRole, Role: AnonymousRole, Source: AnonymousRole}
+	if secret := c.Get("X-API-Key"); td.access != nil && secret != "" {
+		k, err := td.apiKeys.Authenticate(secret)
+		if err != nil {
+			return fiber.NewError(http.StatusUnauthorized)
+		}
+		if err := checkKeyScopes(c, k); err != nil {
+			return err
+		}
+		caller = principal{Name: k.Name, Role: k.Role, Source: k.Source, totpSecret: k.TOTPSecret(),
+			key: k.Name, scope: td.jurisdictionScope(k)}
+		if caller.Source == "" {
+			caller.Source = k.Name
+		}
+	}
+	if token := bearerToken(c); td.access != nil && token != "" {
+		var err error
+		if caller, err = td.tokenPrincipal(c, token); err != nil {
+			return err
+		}
+	}
+	if token := c.Get("X-Session-Token"); td.webauthn != nil && token != "" {
+		user, err := td.users.Authenticate(token)
+		if err != nil {
+			return fiber.NewError(http.StatusUnauthorized)
+		}
+		caller = principal{Name: user.Name, Role: user.Role, Source: user.Name}
+	}
+	c.Locals("principal", caller)
+
+	if td.access != nil {
+		if err := checkAnonymous(c, caller); err != nil {
+			return err
+		}
+		if err := td.checkFieldWrites(c, caller); err != nil {
+			return err
+		}
+	}
+	if caller.scope != nil || caller.voters != nil {
+		if err := td.checkScope(c, caller); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return nil
+	}
+
+	var body any
+	if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+		log.Println("Error parsing response for access control: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	//Voters outside the caller's jurisdictions are dropped first, the
+	//lookup needs the VoterId before the fields are filtered
+	changed := false
+	if caller.scope != nil || caller.voters != nil {
+		body = td.scopeVoters(body, caller)
+		changed = true
+	}
+
+	//Device routes already return their own restricted views, they are
+	//logged under the device that made the request
+	if device, ok := c.Locals("device").(db.Device); ok {
+		caller = principal{Name: "device:" + device.Name, Role: device.Kind}
+	} else if allowed := td.visibleFields(caller.Role); allowed != nil {
+		body = filterVoterFields(body, allowed)
+		changed = true
+	}
+	if changed {
+		filtered, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		c.Response().SetBodyRaw(filtered)
+	}
+
+	td.logAccess(c, caller, body)
+	td.checkTripwires(c, caller, body)
+
+	return nil
+}
+
+// isRead reports whether a request only reads.  POST /graphql counts as
+// one, the mutations in it are checked as the REST requests that resolve
+// them, see graphql-handler.go.
+func isRead(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead || c.Path() == "/graphql"
+}
+
+// routePath is the path of a request the way the router matches it,
+// which ignores case and a trailing slash
+func routePath(c *fiber.Ctx) string {
+	path := strings.ToLower(c.Path())
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+// isAdmin reports whether a request is for one of the admin routes
+func isAdmin(c *fiber.Ctx) bool {
+	path := routePath(c)
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// publicWrites are the writes callers without an API key may make when
+// access control is on.  The device, kiosk and login routes check
+// credentials of their own, opt-out and survey are reached by voters from
+// the links they were sent and check the token signed into them, see
+// links.go.
+var publicWrites = regexp.MustCompile(`^/(auth/.+|devices/.+|kiosk/.+|precincts/\d+/queue-metrics|voters/\d+/opt-out|polls/\d+/survey)$`)
+
+// checkAnonymous refuses callers without an API key, a token or a
+// session the admin routes and every write that is not public
+func checkAnonymous(c *fiber.Ctx, caller principal) error {
+	if caller.Role != AnonymousRole {
+		return nil
+	}
+	if isAdmin(c) || !isRead(c) && !publicWrites.MatchString(routePath(c)) {
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, "an API key is required for this request")
+	}
+	return nil
+}
+
+// voterWritePath matches the routes whose body is a voter
+var voterWritePath = regexp.MustCompile(`^/voters(/\d+)?$`)
+
+// checkFieldWrites refuses voter writes that set fields the caller's role
+// may not see.  A new voter may leave those fields empty and a merge patch
+// may leave them out, a PUT replaces every field and needs a role that
+// sees all of them.
+func (td *VoterAPI) checkFieldWrites(c *fiber.Ctx, caller principal) error {
+	method := c.Method()
+	if method != fiber.MethodPost && method != fiber.MethodPut && method != fiber.MethodPatch ||
+		!voterWritePath.MatchString(routePath(c)) {
+		return nil
+	}
+	allowed := td.visibleFields(caller.Role)
+	if allowed == nil {
+		return nil
+	}
+	if method == fiber.MethodPut {
+		return apiError(http.StatusForbidden, client.CodeForbidden,
+			"the role "+caller.Role+" may not replace voters, PATCH the fields it may see")
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		//Not an object, the handler refuses it
+		return nil
+	}
+	for field, value := range body {
+		if method == fiber.MethodPost && isEmptyJSON(value) {
+			continue
+		}
+		visible := false
+		for name := range allowed {
+			visible = visible || strings.EqualFold(name, field)
+		}
+		if !visible {
+			return apiError(http.StatusForbidden, client.CodeForbidden,
+				fmt.Sprintf("the role %s may not write the field %s", caller.Role, field))
+		}
+	}
+	return nil
+}
+
+// isEmptyJSON reports whether a decoded JSON value is null, zero or empty,
+// the way a Go client sends the fields it did not set
+func isEmptyJSON(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == "" || v == "0001-01-01T00:00:00Z"
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		for _, member := range v {
+			if !isEmptyJSON(member) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// checkKeyScopes refuses the requests an API key is not scoped for.  The
+// admin routes need the admin scope, reads elsewhere the read scope and
+// every other request the write scope.
+func checkKeyScopes(c *fiber.Ctx, key db.APIKey) error {
+	scope := db.ScopeWrite
+	switch {
+	case isAdmin(c):
+		scope = db.ScopeAdmin
+	case isRead(c):
+		scope = db.ScopeRead
+	}
+
+	if !key.HasScope(scope) {
+		return apiError(http.StatusForbidden, client.CodeForbidden,
+			fmt.Sprintf("the API key is not scoped for %s requests", scope))
+	}
+	return nil
+}
+
+// visibleFields returns the set of voter fields a role may see, or nil
+// when it may see all of them
+func (td *VoterAPI) visibleFields(role string) map[string]bool {
+	if td.access == nil {
+		return nil
+	}
+
+	allowed := map[string]bool{"VoterId": true}
+	for _, field := range td.access.Roles[role] {
+		if field == allFields {
+			return nil
+		}
+		allowed[field] = true
+	}
+
+	return allowed
+}
+
+// logAccess writes an access log entry for every voter in the response
+// that still carries PII.  A voter that shows up more than once, say in a
+// data export, gets a single entry with all the fields that were shown.
+func (td *VoterAPI) logAccess(c *fiber.Ctx, caller principal, body any) {
+	//Fiber reuses the request buffers, so copy what outlives the request
+	td.recordAccess(caller, strings.Clone(c.Method()), strings.Clone(c.Path()), body)
+}
+
+// recordAccess writes the access log entries of logAccess for a body
+// shown to a caller outside of a response, e.g. a live event
+func (td *VoterAPI) recordAccess(caller principal, method, path string, body any) {
+	var ids []int
+	shown := make(map[int]map[string]bool)
+	walkVoters(body, func(voter map[string]any) {
+		id, ok := voter["VoterId"].(float64)
+		if !ok {
+			return
+		}
+
+		for _, field := range piiFields {
+			if _, ok := voter[field]; !ok {
+				continue
+			}
+			if shown[int(id)] == nil {
+				shown[int(id)] = make(map[string]bool)
+				ids = append(ids, int(id))
+			}
+			shown[int(id)][field] = true
+		}
+	})
+
+	for _, id := range ids {
+		var fields []string
+		for _, field := range piiFields {
+			if shown[id][field] {
+				fields = append(fields, field)
+			}
+		}
+
+		td.accessLog.Record(db.AccessEntry{
+			Principal: caller.Name,
+			Role:      caller.Role,
+			VoterId:   id,
+			Fields:    fields,
+			Method:    method,
+			Path:      path,
+		})
+	}
+}
+
+// walkVoters calls fn for every object in a decoded JSON value that
+// describes a voter, which is any object carrying a VoterId
+func walkVoters(value any, fn func(map[string]any)) {
+	switch v := value.(type) {
+	case map[string]any:
+		if _, isVoter := v["VoterId"]; isVoter {
+			fn(v)
+		}
+		for _, field := range v {
+			walkVoters(field, fn)
+		}
+	case []any:
+		for _, item := range v {
+			walkVoters(item, fn)
+		}
+	}
+}
+
+// filterVoterFields removes the fields that are not allowed from every
+// voter object in a decoded JSON value
+func filterVoterFields(value any, allowed map[string]bool) any {
+	walkVoters(value, func(voter map[string]any) {
+		for key := range voter {
+			if !allowed[key] {
+				delete(voter, key)
+			}
+		}
+	})
+
+	return value
+}
+
+// implementation for GET /voters/:id/access-log
+// returns who was shown the PII of a voter and when, oldest first
+func (td *VoterAPI) GetAccessLog(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	return c.JSON(td.accessLog.GetEntries(id))
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /admin/checksum
+// returns the root hash of the checksum tree over all voters.  Passing
+// ?depth=n also returns the node hashes at that depth, so two instances
+// can compare level by level and narrow down the buckets that differ.
+func (td *VoterAPI) GetChecksum(c *fiber.Ctx) error {
+	tree := td.db.Checksum()
+
+	rsp := fiber.Map{
+		"root":  tree.Root(),
+		"count": tree.Count(),
+		"depth": tree.Depth(),
+	}
+
+	if c.Query("depth") != "" {
+		depth := c.QueryInt("depth", -1)
+		nodes, err := tree.Level(depth)
+		if err != nil {
+			log.Println("Error getting checksum level: ", err)
+			return storeError(err)
+		}
+		rsp["nodes"] = nodes
+	}
+
+	return c.JSON(rsp)
+}
+
+// implementation for GET /admin/checksum/buckets/:bucket
+// returns the per record hashes of all voters that fall in a leaf bucket
+func (td *VoterAPI) GetChecksumBucket(c *fiber.Ctx) error {
+	bucket, err := c.ParamsInt("bucket")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	hashes, err := td.db.BucketChecksums(bucket)
+	if err != nil {
+		log.Println("Error getting bucket checksums: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(hashes)
+}
+
+// implementation for GET /admin/export/ballots
+// returns the sealed ballots with their hashes and chained tracking codes
+// for an external tally system.  Pass ?poll=n to export a single poll.
+func (td *VoterAPI) ExportBallots(c *fiber.Ctx) error {
+	pollID := c.QueryInt("poll", 0)
+	ballots := td.db.EncryptedBallots(pollID)
+	setExportRecords(c, len(ballots))
+
+	return c.JSON(fiber.Map{
+		"poll":    pollID,
+		"ballots": ballots,
+	})
+}
+
+// implementation for POST /admin/archive
+// moves every voter whose last vote was before the given date to the cold
+// store, for example {"Before": "2022-11-08"}.  Archived voters can still
+// be read by id, those responses are flagged with Archived.
+func (td *VoterAPI) ArchiveVoters(c *fiber.Ctx) error {
+	var req struct {
+		Before string
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	before, err := parseDate(req.Before)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, "Before must be a date")
+	}
+
+	defer td.voterLocks.lockAll()()
+	ids, err := td.db.ArchiveVoters(before)
+	if err != nil {
+		log.Println("Error archiving voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError, err.Error())
+	}
+	if err := td.persist(c.UserContext(), ids...); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"archived": ids,
+		"count":    len(ids),
+	})
+}
+
+// implementation for GET /admin/shadow
+// returns the shadow write and read counters and the recent mismatches
+// between the primary and the shadow store
+func (td *VoterAPI) GetShadowStats(c *fiber.Ctx) error {
+	return c.JSON(td.db.ShadowStats())
+}
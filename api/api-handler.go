@@ -1,17 +1,96 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"time"
 
+	"github.com/adllev/voter-api/client"
 	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/db/boltdb"
+	"github.com/adllev/voter-api/db/mongodb"
+	"github.com/adllev/voter-api/db/postgres"
+	"github.com/adllev/voter-api/graphql"
+	"github.com/adllev/voter-api/jobs"
+	"github.com/adllev/voter-api/notify"
+	"github.com/adllev/voter-api/publish"
+	"github.com/adllev/voter-api/replay"
+	"github.com/adllev/voter-api/sealed"
+	"github.com/adllev/voter-api/siem"
+	"github.com/adllev/voter-api/webhook"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/sync/singleflight"
 )
 
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type VoterAPI struct {
-	db *db.VoterList
+	store      db.VoterStore //Plain voter reads and writes
+	db         *db.VoterList //The in-memory list, for everything else
+	exclusions *db.ExclusionStore
+	reviews    *db.ReviewQueue
+	devices    *db.DeviceStore
+	checkIns   *db.CheckInLog
+	queues     *db.QueueMetrics
+	places     *db.PollingPlaceList
+	elections  *db.ElectionList
+	campaigns  *db.CampaignStore
+	templates  *db.TemplateStore
+	polls      *db.PollList
+	surveys    *db.SurveyStore
+	audit      *db.AuditLog
+	jobs       *jobs.Queue
+	segments   *db.SegmentStore
+	notifier   notify.Notifier
+	access     *AccessConfig
+	accessLog  *db.AccessLog
+	features   map[string]any
+
+	maintenance   maintenanceState
+	reads         singleflight.Group //Coalesces identical concurrent reads
+	partitions    *pollPartitions
+	voterLocks    voterLocks
+	scheduler     *scheduler
+	siem          *siem.Exporter
+	recorder      *replay.Recorder //Traffic recording, nil when it is off
+	corrections   *db.CorrectionLog
+	publisher     *publish.Publisher //nil when publishing is off
+	rehearsal     *rehearsal         //nil when rehearsal mode is off
+	stepUps       stepUps
+	tokens        scopedTokens
+	graphql       *graphql.Schema
+	routes        func() fasthttp.RequestHandler //The app's routes, for GraphQL resolvers
+	users         *db.UserStore
+	webauthn      *webauthn.WebAuthn
+	ceremonies    ceremonies
+	denyList      *db.DenyList
+	allowRules    []allowRule //Longest route group first
+	tripwires     *db.TripwireLog
+	uiActions     *db.UIActionLog
+	apiKeys       *db.APIKeyStore
+	webhooks      *db.WebhookStore
+	webhookSender *webhook.Sender
+	rateLimits    rateLimits
+	slow          slowRequests
+	slos          *db.SLOTracker //nil when no SLOs are configured
+	skew          *db.ClockSkew
+	historyQuota  *db.HistoryQuota
+	jurisdictions *db.JurisdictionTree
+	registration  registrationFreeze
+	sealer        *sealed.Sealer //Seals exports, nil when exports go out as they are
+	putCreates    bool           //PUT /voters/:id creates missing voters
+	immutable     bool           //Recorded votes are never changed or deleted, see SetHistoryImmutable
+	voteDates     db.VoteDateWindow
+	voteIds       *db.VoteIdSequence //Shared with the in-memory list
+	linkKey       []byte             //Signs the links voters are sent, see links.go
 }
 
 func New() (*VoterAPI, error) {
@@ -20,7 +99,179 @@ func New() (*VoterAPI, error) {
 		return nil, err
 	}
 
-	return &VoterAPI{db: dbHandler}, nil
+	td := &VoterAPI{
+		store:         dbHandler,
+		db:            dbHandler,
+		exclusions:    db.NewExclusionStore(),
+		reviews:       db.NewReviewQueue(),
+		devices:       db.NewDeviceStore(),
+		checkIns:      db.NewCheckInLog(),
+		queues:        db.NewQueueMetrics(),
+		places:        db.NewPollingPlaceList(),
+		elections:     db.NewElectionList(),
+		campaigns:     db.NewCampaignStore(),
+		templates:     db.NewTemplateStore(),
+		polls:         db.NewPollList(),
+		surveys:       db.NewSurveyStore(),
+		audit:         db.NewAuditLog(),
+		corrections:   db.NewCorrectionLog(),
+		accessLog:     db.NewAccessLog(),
+		users:         db.NewUserStore(),
+		denyList:      db.NewDenyList(),
+		tripwires:     db.NewTripwireLog(),
+		skew:          db.NewClockSkew(),
+		historyQuota:  db.NewHistoryQuota(),
+		jurisdictions: db.NewJurisdictionTree(),
+		uiActions:     db.NewUIActionLog(),
+		apiKeys:       db.NewAPIKeyStore(),
+		webhooks:      db.NewWebhookStore(),
+		webhookSender: newWebhookSender(),
+		features:      make(map[string]any),
+		slow:          slowRequests{threshold: DefaultSlowThreshold},
+		partitions:    newPollPartitions(),
+		scheduler:     newScheduler(DefaultCapacity),
+		jobs:          jobs.NewQueue(100),
+		segments:      db.NewSegmentStore(),
+		notifier:      notify.NewLogNotifier(),
+		registration:  registrationFreeze{elections: make(map[int]bool)},
+		voteDates:     db.DefaultVoteDateWindow,
+		voteIds:       db.NewVoteIdSequence(db.VoteIdsPerVoter),
+		linkKey:       newLinkKey(),
+	}
+	td.registerElectionHooks()
+	dbHandler.SetReviewQueue(td.reviews)
+	dbHandler.SetVoteIdSequence(td.voteIds)
+	go td.deliverWebhooks()
+
+	return td, nil
+}
+
+// EnableBallotEncryption loads the election public key from a PEM file and
+// switches the database to encrypted ballot storage
+func (td *VoterAPI) EnableBallotEncryption(keyFile string) error {
+	key, err := db.LoadBallotKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	td.db.SetBallotKey(key)
+	td.setFeature("ballot_encryption", true)
+	return nil
+}
+
+// EnableArchive turns on archiving of voters to a directory of JSON files
+func (td *VoterAPI) EnableArchive(dir string) error {
+	store, err := db.NewFileColdStore(dir)
+	if err != nil {
+		return err
+	}
+
+	td.db.SetColdStore(store)
+	td.setFeature("archive", "file")
+	return nil
+}
+
+// EnableShadow turns on shadow mode with a directory of JSON files as the
+// shadow store
+func (td *VoterAPI) EnableShadow(dir string) error {
+	if err := td.refuseInRehearsal("the shadow store"); err != nil {
+		return err
+	}
+	store, err := db.NewFileColdStore(dir)
+	if err != nil {
+		return err
+	}
+
+	td.db.SetShadow(store)
+	td.setFeature("shadow", "file")
+	return nil
+}
+
+// EnablePostgres switches plain voter reads and writes to a PostgreSQL
+// database, migrating its schema first.  The in-memory list is loaded from
+// it and kept as a copy for the features that need the whole roll.
+func (td *VoterAPI) EnablePostgres(cfg postgres.Config) error {
+	if err := td.refuseInRehearsal("the postgres store"); err != nil {
+		return err
+	}
+	if cfg.DSN == "" {
+		return errors.New("the postgres store needs a connection string, set -postgres")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	store, err := postgres.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if td.store, err = td.db.Mirror(store); err != nil {
+		return err
+	}
+	td.setFeature("store", "postgres")
+	return nil
+}
+
+// EnableBolt switches plain voter reads and writes to a bbolt file,
+// created if it does not exist
+func (td *VoterAPI) EnableBolt(path string) error {
+	if err := td.refuseInRehearsal("the bolt store"); err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("the bolt store needs a file, set -bolt")
+	}
+	store, err := boltdb.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if td.store, err = td.db.Mirror(store); err != nil {
+		return err
+	}
+	td.setFeature("store", "bolt")
+	return nil
+}
+
+// EnableMongo switches plain voter reads and writes to a MongoDB database
+func (td *VoterAPI) EnableMongo(uri string) error {
+	if err := td.refuseInRehearsal("the mongo store"); err != nil {
+		return err
+	}
+	if uri == "" {
+		return errors.New("the mongo store needs a connection URI, set -mongo or $MONGO_URI")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	store, err := mongodb.Open(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	if td.store, err = td.db.Mirror(store); err != nil {
+		return err
+	}
+	td.setFeature("store", "mongo")
+	return nil
+}
+
+// persist writes the voters a handler changed on the list directly to the
+// voter store, when the store is another backend that keeps a copy of it
+func (td *VoterAPI) persist(ctx context.Context, ids ...int) error {
+	store, ok := td.store.(db.PersistStore)
+	if !ok || len(ids) == 0 {
+		return nil
+	}
+	if bound, ok := td.store.(db.ContextStore); ok {
+		store = bound.WithContext(ctx).(db.PersistStore)
+	}
+	if err := store.Persist(ids...); err != nil {
+		log.Println("Error writing changes to the voter store: ", err)
+		return storeError(err)
+	}
+	return nil
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -35,26 +286,179 @@ func New() (*VoterAPI, error) {
 //	  done using the c.AbortWithStatus() function
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, ordered by VoterId unless ?sort= and ?order= ask for
+// another order.  ?limit= and ?offset= return one page, X-Total-Count has
+// the number of voters across all pages.
 func (td *VoterAPI) ListAllVoters(c *fiber.Ctx) error {
 
-	voterList, err := td.db.GetAllVoters()
+	filter, err := td.voterFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	return td.sendVoterPage(c, filter)
+}
+
+// voterSort reads the order of a voter list from ?sort= (name, email or
+// voterid) and ?order= (asc or desc), by VoterId ascending when not given
+func voterSort(c *fiber.Ctx) (db.VoterSort, error) {
+	order := db.VoterSort{Field: c.Query("sort")}
+	switch c.Query("order") {
+	case "", "asc":
+	case "desc":
+		order.Descending = true
+	default:
+		return db.VoterSort{}, errors.New("order must be asc or desc")
+	}
+
+	return order, order.Validate()
+}
+
+// implementation for GET /voters/search
+// finds voters by name, email or registration date without downloading
+// the whole roll.  It takes the filter parameters of GET /voters, at least
+// one of them has to be given, and pages the same way.
+func (td *VoterAPI) SearchVoters(c *fiber.Ctx) error {
+	filter, err := td.voterFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if reflect.DeepEqual(filter, db.VoterFilter{}) {
+		return fiber.NewError(http.StatusBadRequest, "at least one search parameter is required")
+	}
+
+	return td.sendVoterPage(c, filter)
+}
+
+// sendVoterPage responds with the page of voters matching the filter
+// asked for with ?limit= and ?offset=, sorted as asked for with ?sort=
+// and ?order=
+func (td *VoterAPI) sendVoterPage(c *fiber.Ctx, filter db.VoterFilter) error {
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	order, err := voterSort(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	//Voters outside the caller's jurisdictions are left out before paging,
+	//so pages are full and the total is what the caller can see
+	if caller, ok := c.Locals("principal").(principal); ok && caller.scope != nil {
+		filter.PrecinctIds = make([]int, 0, len(caller.scope))
+		for id := range caller.scope {
+			filter.PrecinctIds = append(filter.PrecinctIds, id)
+		}
+	}
+
+	voterList, total, err := td.db.SearchVoters(filter, order, offset, limit)
 	if err != nil {
 		log.Println("Error Getting All Voters: ", err)
 		return fiber.NewError(http.StatusNotFound,
 			"Error Getting All Voters")
 	}
-	//Note that the database returns a nil slice if there are no items
-	//in the database.  We need to convert this to an empty slice
-	//so that the JSON marshalling works correctly.  We want to return
-	//an empty slice, not a nil slice. This will result in the json being []
-	if voterList == nil {
-		voterList = make([]db.Voter, 0)
-	}
 
+	setPageHeaders(c, offset, limit, total)
 	return c.JSON(voterList)
 }
 
+// voterFilter builds a db.VoterFilter from the query string, supported
+// parameters are:
+//
+//	name              - voters whose name contains this text
+//	email             - the voter with this email address
+//	moved_since       - only voters that moved on or after this date
+//	registered_after  - only voters registered on or after this date
+//	registered_before - only voters registered before this date
+//	precinct          - only voters assigned to this precinct
+//	status            - only voters with this registration status
+//	tag               - only voters carrying this tag
+//	segment           - start from a saved segment, the other parameters
+//	                    narrow it further
+func (td *VoterAPI) voterFilter(c *fiber.Ctx) (db.VoterFilter, error) {
+	filter := db.VoterFilter{
+		Name:       c.Query("name"),
+		Email:      c.Query("email"),
+		PrecinctId: c.QueryInt("precinct", 0),
+		Status:     c.Query("status"),
+		Tag:        c.Query("tag"),
+	}
+
+	dates := []struct {
+		param string
+		field *time.Time
+	}{
+		{"moved_since", &filter.MovedSince},
+		{"registered_after", &filter.RegisteredAfter},
+		{"registered_before", &filter.RegisteredBefore},
+	}
+	for _, date := range dates {
+		value := c.Query(date.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseDate(value)
+		if err != nil {
+			return db.VoterFilter{}, err
+		}
+		*date.field = parsed
+	}
+
+	return td.segmentFilter(c.Query("segment"), filter)
+}
+
+// segmentFilter returns the filter of a saved segment with the set fields
+// of filter applied on top.  An empty segment name returns filter as is.
+func (td *VoterAPI) segmentFilter(name string, filter db.VoterFilter) (db.VoterFilter, error) {
+	if name == "" {
+		return filter, nil
+	}
+
+	segment, err := td.segments.GetSegment(name)
+	if err != nil {
+		return db.VoterFilter{}, err
+	}
+
+	return segment.Filter.Override(filter), nil
+}
+
+// parseDate accepts either a plain date (2006-01-02) or a full RFC3339
+// timestamp, which is what clients tend to send in query strings
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseAsOf parses an as_of parameter.  A plain date means the end of that
+// day, so ?as_of=2023-11-07 includes everything that happened on the 7th.
+func parseAsOf(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.Add(24*time.Hour - time.Nanosecond), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// implementation for GET /voters/count
+// returns the number of registered voters, now or with ?as_of= at a
+// point in the past
+func (td *VoterAPI) CountVoters(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if c.Query("as_of") != "" {
+		var err error
+		asOf, err = parseAsOf(c.Query("as_of"))
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	return c.JSON(voterCount{Count: td.db.CountAsOf(asOf), AsOf: asOf})
+}
+
 // implementation for GET /todo/:id
 // returns a single todo
 func (td *VoterAPI) GetVoter(c *fiber.Ctx) error {
@@ -67,21 +471,62 @@ func (td *VoterAPI) GetVoter(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
+	//With ?as_of= the voter is looked up in the revision history as it
+	//was at that time, instead of its current state
+	if c.Query("as_of") != "" {
+		asOf, err := parseAsOf(c.Query("as_of"))
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+
+		voter, err := td.db.GetVoterAsOf(id, asOf)
+		if err != nil {
+			log.Println("Voter not found: ", err)
+			return lookupError(err, client.CodeVoterNotFound, "voter not found")
+		}
+		return c.JSON(voter)
+	}
+
 	//Note that ParseInt always returns an int64, so we have to
 	//convert it to an int before we can use it.
-	voter, err := td.db.GetVoter(id)
+	voter, err := td.storeFor(c).GetVoter(id)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
+	//The ETag matches the one in the precinct sync manifest
+	c.Set(fiber.HeaderETag, db.VoterETag(voter))
+
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
 	return c.JSON(voter)
 }
 
+// implementation for GET /voters/by-email/:email
+// returns the voter registered with an email address.  The address is
+// looked up in the store's email index, compared trimmed and without
+// regard to case.
+func (td *VoterAPI) GetVoterByEmail(c *fiber.Ctx) error {
+	email, err := url.PathUnescape(c.Params("email"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	voter, err := td.storeFor(c).GetVoterByEmail(email)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	c.Set(fiber.HeaderETag, db.VoterETag(voter))
+	return c.JSON(voter)
+}
+
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo.  A voter sent without a VoterId is given the next free
+// id by the store and answered with a 201 and its Location, that is the
+// way to add voters when more than one client writes to the roll.
 func (td *VoterAPI) PostVoter(c *fiber.Ctx) error {
 	var voter db.Voter
 
@@ -100,74 +545,373 @@ func (td *VoterAPI) PostVoter(c *fiber.Ctx) error {
 		log.Println("Error binding JSON: ", err)
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	if err := validatePayload(voter); err != nil {
+		return err
+	}
 
-	if err := td.db.AddVoter(voter); err != nil {
-		log.Println("Error adding item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	assigned := voter.VoterId == 0
+	voter, err := td.createVoter(c, voter)
+	if err != nil {
+		return err
 	}
 
+	if assigned {
+		c.Location(fmt.Sprintf("/voters/%d", voter.VoterId))
+		return c.Status(http.StatusCreated).JSON(voter)
+	}
 	return c.JSON(voter)
 }
 
-// implementation for PUT /todo
-// Web api standards use PUT for Updates
+// createVoter adds a new voter for POST /voters and for PUT /voters/:id
+// when it creates, a voter without a VoterId is given the next free one.
+// It returns the voter as added.
+func (td *VoterAPI) createVoter(c *fiber.Ctx, voter db.Voter) (db.Voter, error) {
+	if err := td.validateHistory(voter.VoteHistory); err != nil {
+		return db.Voter{}, fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkVoteDates(voter.VoteHistory); err != nil {
+		return db.Voter{}, err
+	}
+	td.numberVotes(nil, &voter)
+	//Stamped here rather than by the store so the caller answers with it
+	if voter.Registered.IsZero() {
+		voter.Registered = time.Now()
+	}
+
+	if electionID := td.registration.frozenBy(); electionID != 0 {
+		return db.Voter{}, apiError(http.StatusConflict, client.CodeRegistrationFrozen,
+			fmt.Sprintf("registration is frozen while election %d is voting", electionID))
+	}
+
+	var err error
+	if voter.VoterId == 0 {
+		voter.VoterId, err = td.storeFor(c).CreateVoter(voter)
+	} else {
+		err = td.storeFor(c).AddVoter(voter)
+	}
+	if err != nil {
+		log.Println("Error adding item: ", err)
+		return db.Voter{}, storeError(err)
+	}
+
+	return voter, nil
+}
+
+// SetRevisionLimit sets how many voter revisions are kept for ?as_of=
+// queries, 0 keeps all.  The default is db.DefaultRevisionLimit.
+func (td *VoterAPI) SetRevisionLimit(limit int) {
+	td.db.SetRevisionLimit(limit)
+}
+
+// SetPutCreates makes PUT /voters/:id create the voter when it does not
+// exist yet instead of answering 404
+func (td *VoterAPI) SetPutCreates(creates bool) {
+	td.putCreates = creates
+	if creates {
+		td.setFeature("put_creates", true)
+	}
+}
+
+// implementation for PUT /voters/:id
+// replaces a voter.  The voter is the one named in the path, a body whose
+// VoterId names another voter is rejected so a mixed up request can not
+// overwrite the wrong record, a body without a VoterId is fine.  A voter
+// that does not exist is a 404, or is created with a 201 when PUT
+// creates are on (see SetPutCreates).  Fields owned by another source
+// keep their value, the change is flagged for review and the fields are
+// named in X-Provenance-Held.  If-Match takes the ETag of GET
+// /voters/:id, see etag.go.  With history immutability on, a body
+// without a VoteHistory keeps the stored one, and one that changes or
+// drops a recorded vote is a 409.
 func (td *VoterAPI) UpdateVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
 	var voter db.Voter
 	if err := c.BodyParser(&voter); err != nil {
 		log.Println("Error binding JSON: ", err)
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	if err := validatePayload(voter); err != nil {
+		return err
+	}
+	if voter.VoterId != 0 && voter.VoterId != id {
+		return apiError(http.StatusBadRequest, client.CodeIdMismatch,
+			fmt.Sprintf("the body is voter %d but the path names voter %d", voter.VoterId, id))
+	}
+	voter.VoterId = id
+
+	stored, err := td.storeFor(c).GetVoter(id)
+	if err != nil {
+		if td.putCreates && errors.Is(err, db.ErrNotFound) {
+			if err := checkPreconditions(c, ""); err != nil {
+				return err
+			}
+			created, err := td.createVoter(c, voter)
+			if err != nil {
+				return err
+			}
+			c.Location(fmt.Sprintf("/voters/%d", id))
+			return c.Status(http.StatusCreated).JSON(created)
+		}
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if err := checkPreconditions(c, db.VoterETag(stored)); err != nil {
+		return err
+	}
+	if td.immutable && voter.VoteHistory == nil {
+		voter.VoteHistory = append([]db.VoterHistory{}, stored.VoteHistory...)
+	}
+	if err := td.checkAppendOnly(stored.VoteHistory, voter.VoteHistory); err != nil {
+		return err
+	}
+
+	//Only check the entries that change, a device revoked since an entry
+	//was recorded must not block unrelated updates
+	if err := td.validateHistory(changedHistory(stored.VoteHistory, voter.VoteHistory)); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkVoteDates(changedDates(stored.VoteHistory, voter.VoteHistory)); err != nil {
+		return err
+	}
+	changed := changedPolls(stored.VoteHistory, voter.VoteHistory)
+	for _, pollID := range changed {
+		if err := td.checkPollLock(pollID); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		if err := td.checkHistoryQuota(c, id); err != nil {
+			return err
+		}
+	}
+
+	td.numberVotes(stored.VoteHistory, &voter)
+
+	voter, conflicts := td.db.ApplyProvenance(stored, voter, writeSource(c))
+	if err := td.storeFor(c).UpdateVoter(voter); err != nil {
+		log.Println("Error updating voter: ", err)
+		return storeError(err)
+	}
+	td.flagConflicts(c, conflicts)
+
+	//The body leaves out what the store keeps on its own, Registered
+	//among them, so the voter is answered as it was stored
+	updated, err := td.storeFor(c).GetVoter(id)
+	if err != nil {
+		log.Println("Error reading updated voter: ", err)
+		return storeError(err)
+	}
+
+	c.Set(fiber.HeaderETag, db.VoterETag(updated))
+	return c.JSON(updated)
+}
+
+// implementation for PATCH /voters/:id
+// updates only the fields in the body, a JSON Merge Patch (RFC 7396).
+// Fields left out keep their value, fields set to null are cleared, so
+// {"Email": "new@example.com"} changes the email and keeps the vote
+// history.  The VoterId can not be changed, fields owned by another
+// source are held back like they are by PUT.
+func (td *VoterAPI) PatchVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var patch any
+	if err := json.Unmarshal(c.Body(), &patch); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if _, ok := patch.(map[string]any); !ok {
+		return fiber.NewError(http.StatusBadRequest, "a patch has to be a JSON object")
+	}
 
-	if err := td.db.UpdateVoter(voter); err != nil {
+	stored, err := td.storeFor(c).GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if err := checkPreconditions(c, db.VoterETag(stored)); err != nil {
+		return err
+	}
+
+	voter, err := mergeVoter(stored, patch)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := validatePayload(voter); err != nil {
+		return err
+	}
+	if voter.VoterId != id {
+		return fiber.NewError(http.StatusBadRequest, "the VoterId can not be changed")
+	}
+	if err := td.checkAppendOnly(stored.VoteHistory, voter.VoteHistory); err != nil {
+		return err
+	}
+
+	if err := td.validateHistory(changedHistory(stored.VoteHistory, voter.VoteHistory)); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkVoteDates(changedDates(stored.VoteHistory, voter.VoteHistory)); err != nil {
+		return err
+	}
+	changed := changedPolls(stored.VoteHistory, voter.VoteHistory)
+	for _, pollID := range changed {
+		if err := td.checkPollLock(pollID); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		if err := td.checkHistoryQuota(c, id); err != nil {
+			return err
+		}
+	}
+
+	td.numberVotes(stored.VoteHistory, &voter)
+
+	voter, conflicts := td.db.ApplyProvenance(stored, voter, writeSource(c))
+	if err := td.storeFor(c).UpdateVoter(voter); err != nil {
 		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+		return storeError(err)
 	}
+	td.flagConflicts(c, conflicts)
 
+	c.Set(fiber.HeaderETag, db.VoterETag(voter))
 	return c.JSON(voter)
 }
 
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo, the voter as it was is returned to confirm what was
+// deleted.  With history immutability on, a voter who has voted can not be
+// deleted.
 func (td *VoterAPI) DeleteVoter(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := td.db.DeleteVoter(id); err != nil {
+	voter, err := td.storeFor(c).GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if err := checkPreconditions(c, db.VoterETag(voter)); err != nil {
+		return err
+	}
+	if td.immutable && len(voter.VoteHistory) > 0 {
+		return historyImmutable("a voter who has voted can not be deleted")
+	}
+
+	if err := td.storeFor(c).DeleteVoter(id); err != nil {
 		log.Println("Error deleting voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+		return storeError(err)
 	}
 
-	return c.Status(http.StatusOK).SendString("Delete OK")
+	return c.JSON(voter)
 }
 
 // implementation for DELETE /todo
-// deletes all todos
+// deletes all todos, which with history immutability on is only allowed
+// while no one has voted
 func (td *VoterAPI) DeleteAllVoters(c *fiber.Ctx) error {
+	if td.immutable {
+		voters, err := td.db.GetAllVoters()
+		if err != nil {
+			log.Println("Error reading voters: ", err)
+			return storeError(err)
+		}
+		for _, voter := range voters {
+			if len(voter.VoteHistory) > 0 {
+				return historyImmutable("voters who have voted can not be deleted")
+			}
+		}
+	}
 
-	if err := td.db.DeleteAll(); err != nil {
+	defer td.voterLocks.lockAll()()
+	if err := td.storeFor(c).DeleteAll(); err != nil {
 		log.Println("Error deleting all items: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+		return storeError(err)
 	}
 
 	return c.Status(http.StatusOK).SendString("Delete All OK")
 }
 
+// moveRequest is the body of POST /voters/:id/move
+type moveRequest struct {
+	Address       db.Address
+	PrecinctId    int
+	EffectiveDate time.Time
+}
+
+// implementation for POST /voters/:id/move
+// moves a voter to a new address, the old address is kept in the
+// voter's address history
+func (td *VoterAPI) MoveVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var move moveRequest
+	if err := c.BodyParser(&move); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	stored, err := td.storeFor(c).GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	//A move is a change of address and precinct, it is held back as a
+	//whole when either is owned by another source
+	moved := stored
+	moved.Address, moved.PrecinctId = move.Address, move.PrecinctId
+	if _, conflicts := td.db.ApplyProvenance(stored, moved, writeSource(c)); len(conflicts) > 0 {
+		td.flagConflicts(c, conflicts)
+		return apiError(http.StatusConflict, client.CodeProvenanceConflict,
+			"the address is owned by another source, the move was flagged for review")
+	}
+
+	voter, err := td.db.MoveVoter(id, move.Address, move.PrecinctId, move.EffectiveDate)
+	if err != nil {
+		log.Println("Error moving voter: ", err)
+		return storeError(err)
+	}
+	if err := td.persist(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.JSON(voter)
+}
+
 // implementation for GET /voters/:id/polls
+// returns the vote history of a voter, paged like GET /voters when
+// ?limit= is given
 func (td *VoterAPI) GetVoterPolls(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
 
-	voter, err := td.db.GetVoter(id)
+	voter, err := td.storeFor(c).GetVoter(id)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
-	return c.JSON(voter.VoteHistory)
+	setPageHeaders(c, offset, limit, len(voter.VoteHistory))
+	return c.JSON(pageOf(voter.VoteHistory, offset, limit))
 }
 
 // implementation for GET /voters/:id/polls/:pollid
@@ -182,10 +926,10 @@ func (td *VoterAPI) GetVoterPoll(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := td.db.GetVoter(voterID)
+	voter, err := td.storeFor(c).GetVoter(voterID)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
 	for _, history := range voter.VoteHistory {
@@ -194,10 +938,14 @@ func (td *VoterAPI) GetVoterPoll(c *fiber.Ctx) error {
 		}
 	}
 
-	return fiber.NewError(http.StatusNotFound)
+	return apiError(http.StatusNotFound, client.CodePollNotFound, "Poll not found for the voter")
 }
 
 // implementation for POST /voters/:id/polls/:pollid
+// records the vote of a voter in a poll.  A voter votes once per poll, a
+// second vote is a 409 unless ?override=true is given to correct the
+// stored one, which is then replaced.  Overrides are refused when history
+// immutability is on.
 func (td *VoterAPI) PostVoterPoll(c *fiber.Ctx) error {
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
@@ -214,26 +962,106 @@ func (td *VoterAPI) PostVoterPoll(c *fiber.Ctx) error {
 		log.Println("Error binding JSON: ", err)
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	if err := validatePayload(voterHistory); err != nil {
+		return err
+	}
+	if err := td.validateHistory([]db.VoterHistory{voterHistory}); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
 
-	voter, err := td.db.GetVoter(voterID)
+	voter, err := td.storeFor(c).GetVoter(voterID)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
+	index := len(voter.VoteHistory)
+	if err := db.AlreadyVoted(voter, pollID); err != nil {
+		if !c.QueryBool("override") {
+			return apiError(http.StatusConflict, client.CodeAlreadyVoted, err.Error())
+		}
+		if td.immutable {
+			return historyImmutable(fmt.Sprintf("the vote in poll %d can not be overridden", pollID))
+		}
+		for i, history := range voter.VoteHistory {
+			if history.PollId == pollID {
+				index = i
+				break
+			}
+		}
+	}
+
+	//The server clock is the authoritative vote time, the client time is
+	//kept alongside and used to track the skew of the device's clock
+	now := time.Now()
+	if !voterHistory.VoteDate.IsZero() {
+		deviceID := voterHistory.DeviceId
+		if device, ok := c.Locals("device").(db.Device); ok && deviceID == 0 {
+			deviceID = device.DeviceId
+		}
+		if err := td.skew.CheckSkew(deviceID, voterHistory.VoteDate, now); err != nil {
+			return apiError(http.StatusBadRequest, client.CodeClockSkew, err.Error())
+		}
+	}
 	voterHistory.PollId = pollID
-	voter.VoteHistory = append(voter.VoteHistory, voterHistory)
+	if err := td.checkVoteDates([]db.VoterHistory{voterHistory}); err != nil {
+		return err
+	}
+	voterHistory.ClientVoteDate = voterHistory.VoteDate
+	voterHistory.VoteDate = now
 
-	if err := td.db.UpdateVoter(voter); err != nil {
-		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	//Polls without a close time, or not set up at all, are always open.
+	//Votes recorded after the regular close are flagged with the extension
+	//that let them in.
+	if poll, err := td.polls.GetPoll(pollID); err == nil && !poll.Closes.IsZero() && now.After(poll.Closes) {
+		return apiError(http.StatusConflict, client.CodePollClosed, "poll is closed")
 	}
+	if err := td.checkPollLock(pollID); err != nil {
+		return err
+	}
+	voterHistory.ExtensionId = td.polls.ExtensionAt(pollID, now)
 
-	return c.JSON(voterHistory)
+	if err := td.checkHistoryQuota(c, voterID); err != nil {
+		return err
+	}
+
+	//VoteIds are handed out by the server, a corrected vote keeps its id.
+	//A new vote is added by the store in one step, so a vote in the poll
+	//that got in since the voter was read is still refused.
+	if index < len(voter.VoteHistory) {
+		voterHistory.VoteId = voter.VoteHistory[index].VoteId
+		voter.VoteHistory[index] = voterHistory
+		if err := td.storeFor(c).UpdateVoter(voter); err != nil {
+			log.Println("Error updating voter: ", err)
+			return storeError(err)
+		}
+		td.audit.Record(requestID(c), "vote.overridden", voterID, fmt.Sprintf("vote in poll %d replaced", pollID))
+
+		//Return the entry as it was stored, if ballot encryption is on the
+		//choice has been sealed and must not be echoed back in plain text
+		return c.JSON(voter.VoteHistory[index])
+	}
+
+	voterHistory.VoteId = td.voteIds.Next(voterID, voter.VoteHistory)
+	stored, err := td.storeFor(c).AddVoterPoll(voterID, pollID, voterHistory)
+	if errors.Is(err, db.ErrAlreadyExists) {
+		return apiError(http.StatusConflict, client.CodeAlreadyVoted, err.Error())
+	}
+	if err != nil {
+		log.Println("Error adding vote: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(stored)
 }
 
 // implementation for PUT /voters/:id/polls/:pollid
+// replaces a vote, refused when history immutability is on
 func (td *VoterAPI) UpdateVoterPoll(c *fiber.Ctx) error {
+	if td.immutable {
+		return historyImmutable("recorded votes can not be changed")
+	}
+
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
@@ -249,11 +1077,23 @@ func (td *VoterAPI) UpdateVoterPoll(c *fiber.Ctx) error {
 		log.Println("Error binding JSON: ", err)
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	if err := validatePayload(updatedHistory); err != nil {
+		return err
+	}
+	if err := td.validateHistory([]db.VoterHistory{updatedHistory}); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkVoteDates([]db.VoterHistory{updatedHistory}); err != nil {
+		return err
+	}
+	if err := td.checkPollLock(pollID); err != nil {
+		return err
+	}
 
-	voter, err := td.db.GetVoter(voterID)
+	voter, err := td.storeFor(c).GetVoter(voterID)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
 	// Find the index of the history with the given poll ID
@@ -266,22 +1106,33 @@ func (td *VoterAPI) UpdateVoterPoll(c *fiber.Ctx) error {
 	}
 
 	if index == -1 {
-		return fiber.NewError(http.StatusNotFound, "Poll not found for the voter")
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "Poll not found for the voter")
 	}
 
-	// Update the VoterHistory slice
+	// Update the VoterHistory slice, the VoteId and the extension flag are
+	// set by the server
+	updatedHistory.VoteId = voter.VoteHistory[index].VoteId
+	updatedHistory.ExtensionId = voter.VoteHistory[index].ExtensionId
 	voter.VoteHistory[index] = updatedHistory
 
-	if err := td.db.UpdateVoter(voter); err != nil {
+	if err := td.checkHistoryQuota(c, voterID); err != nil {
+		return err
+	}
+	if err := td.storeFor(c).UpdateVoter(voter); err != nil {
 		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+		return storeError(err)
 	}
 
-	return c.JSON(updatedHistory)
+	return c.JSON(voter.VoteHistory[index])
 }
 
 // implementation for DELETE /voters/:id/polls/:pollid
+// deletes a vote, refused when history immutability is on
 func (td *VoterAPI) DeleteVoterPoll(c *fiber.Ctx) error {
+	if td.immutable {
+		return historyImmutable("recorded votes can not be deleted")
+	}
+
 	voterID, err := c.ParamsInt("id")
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
@@ -291,25 +1142,191 @@ func (td *VoterAPI) DeleteVoterPoll(c *fiber.Ctx) error {
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
+	if err := td.checkPollLock(pollID); err != nil {
+		return err
+	}
 
-	voter, err := td.db.GetVoter(voterID)
+	voter, err := td.storeFor(c).GetVoter(voterID)
 	if err != nil {
 		log.Println("Voter not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
 	}
 
 	for i, history := range voter.VoteHistory {
 		if history.PollId == pollID {
+			if err := td.checkHistoryQuota(c, voterID); err != nil {
+				return err
+			}
 			voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
-			if err := td.db.UpdateVoter(voter); err != nil {
+			if err := td.storeFor(c).UpdateVoter(voter); err != nil {
 				log.Println("Error updating voter: ", err)
-				return fiber.NewError(http.StatusInternalServerError)
+				return storeError(err)
 			}
 			return c.Status(http.StatusOK).SendString("Delete OK")
 		}
 	}
 
-	return fiber.NewError(http.StatusNotFound)
+	return apiError(http.StatusNotFound, client.CodePollNotFound, "Poll not found for the voter")
+}
+
+// implementation for PATCH /voters/:id/polls
+// edits the vote history with a JSON Patch (RFC 6902), an array of
+// operations on the history as GET /voters/:id/polls returns it, e.g.
+// [{"op": "remove", "path": "/0"}].  Every operation is checked before
+// any is applied, and the patch is stored only when all of them apply.
+// With history immutability on, a patch may only add votes.
+func (td *VoterAPI) PatchVoterPolls(c *fiber.Ctx) error {
+	voterID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var ops []patchOperation
+	if err := json.Unmarshal(c.Body(), &ops); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest, "a patch has to be a JSON array of operations")
+	}
+	if err := validatePatch(ops); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	voter, err := td.storeFor(c).GetVoter(voterID)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	updated, err := patchHistory(voter.VoteHistory, ops)
+	if errors.Is(err, errPatchTest) {
+		return apiError(http.StatusConflict, client.CodeConflict, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkAppendOnly(voter.VoteHistory, updated); err != nil {
+		return err
+	}
+
+	if err := td.validateHistory(changedHistory(voter.VoteHistory, updated)); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := td.checkVoteDates(changedDates(voter.VoteHistory, updated)); err != nil {
+		return err
+	}
+	for _, pollID := range touchedPolls(voter.VoteHistory, updated) {
+		if err := td.checkPollLock(pollID); err != nil {
+			return err
+		}
+	}
+
+	//The extension flag is set by the server
+	for i := range updated {
+		updated[i].ExtensionId = 0
+		for _, stored := range voter.VoteHistory {
+			if stored.PollId == updated[i].PollId {
+				updated[i].ExtensionId = stored.ExtensionId
+				break
+			}
+		}
+	}
+
+	if err := td.checkHistoryQuota(c, voterID); err != nil {
+		return err
+	}
+	stored := voter.VoteHistory
+	voter.VoteHistory = updated
+	td.numberVotes(stored, &voter)
+	if err := td.storeFor(c).UpdateVoter(voter); err != nil {
+		log.Println("Error updating voter: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(voter.VoteHistory)
+}
+
+// implementation for POST /voters/:id/polls:sync
+// reconciles the history an offline app collected while disconnected.  The
+// body is the full list of history entries the app knows for the voter,
+// the response is the authoritative merged history along with the polls
+// that were added and the entries that conflict with the stored ones.
+func (td *VoterAPI) SyncVoterPolls(c *fiber.Ctx) error {
+	voterID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var known []db.VoterHistory
+	if err := c.BodyParser(&known); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if err := td.validateHistory(known); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	//The time an offline vote was recorded may be long past, but a time in
+	//the future can only come from a wrong device clock
+	for _, entry := range known {
+		if time.Until(entry.VoteDate) > db.MaxClockAhead {
+			return apiError(http.StatusBadRequest, client.CodeClockSkew,
+				fmt.Sprintf("vote time for poll %d is in the future, check the device clock", entry.PollId))
+		}
+	}
+	//The client time is only checked for being plausible, as it is for a
+	//single vote.  It decides nothing, the server clock is the
+	//authoritative vote time and picks the extension a vote got in with, a
+	//device can not backdate a vote into the regular hours of a poll.  The
+	//time the app recorded the vote is kept as the ClientVoteDate.
+	if err := td.checkVoteDates(known); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range known {
+		known[i].ClientVoteDate = known[i].VoteDate
+		known[i].VoteDate = now
+		known[i].ExtensionId = td.polls.ExtensionAt(known[i].PollId, now)
+	}
+
+	voter, err := td.storeFor(c).GetVoter(voterID)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	//A sync only adds the polls the stored history lacks, entries for the
+	//others are kept or reported as conflicts
+	for _, entry := range known {
+		if db.AlreadyVoted(voter, entry.PollId) != nil {
+			continue
+		}
+		if err := td.checkPollLock(entry.PollId); err != nil {
+			return err
+		}
+	}
+	if err := td.checkHistoryQuota(c, voterID); err != nil {
+		return err
+	}
+
+	voter, result, err := db.SyncHistory(voter, known, td.voteIds)
+	if err != nil {
+		log.Println("Error syncing voter polls: ", err)
+		return storeError(err)
+	}
+	if len(result.Added) > 0 {
+		if err := td.storeFor(c).UpdateVoter(voter); err != nil {
+			log.Println("Error updating voter: ", err)
+			return storeError(err)
+		}
+		//Reread so added choices come back sealed when encryption is on
+		if voter, err = td.storeFor(c).GetVoter(voterID); err != nil {
+			log.Println("Error reading synced voter: ", err)
+			return storeError(err)
+		}
+	}
+
+	result.History = voter.VoteHistory
+	if result.History == nil {
+		result.History = []db.VoterHistory{}
+	}
+	return c.JSON(result)
 }
 
 // implementation of GET /voters/health. It is a good practice to build in a
@@ -320,7 +1337,7 @@ func (td *VoterAPI) HealthCheck(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).
 		JSON(fiber.Map{
 			"status":             "ok",
-			"version":            "1.0.0",
+			"version":            Version,
 			"uptime":             100,
 			"users_processed":    1000,
 			"errors_encountered": 10,
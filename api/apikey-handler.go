@@ -0,0 +1,194 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// keyETag returns the ETag of an API key.  It leaves out when the key was
+// last used, using a key is not a change to it.
+func keyETag(key db.APIKey) string {
+	key.LastUsed = time.Time{}
+	return resourceETag(key)
+}
+
+// sendKey answers a write to an API key with the key and, when one was
+// issued, its secret, which is not shown again
+func sendKey(c *fiber.Ctx, status int, key db.APIKey, secret string) error {
+	c.Set(fiber.HeaderETag, keyETag(key))
+	body := fiber.Map{"api_key": key}
+	if secret != "" {
+		body["secret"] = secret
+	}
+	return c.Status(status).JSON(body)
+}
+
+// implementation for GET /admin/apikeys
+// returns all API keys with when they were last used, never their secrets
+func (td *VoterAPI) ListAPIKeys(c *fiber.Ctx) error {
+	return c.JSON(td.apiKeys.GetAllKeys())
+}
+
+// implementation for GET /admin/apikeys/:name
+func (td *VoterAPI) GetAPIKey(c *fiber.Ctx) error {
+	key, err := td.apiKeys.GetKey(c.Params("name"))
+	if err != nil {
+		log.Println("API key not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeAPIKeyNotFound, "API key not found")
+	}
+
+	return sendTagged(c, http.StatusOK, keyETag(key), key)
+}
+
+// implementation for POST /admin/apikeys
+// creates an API key, the response carries its secret which is not shown
+// again.  Repeating the POST of a key set up as it is stored is a 200
+// without the secret, a name taken by a key set up otherwise is a 409, see
+// etag.go.
+func (td *VoterAPI) PostAPIKey(c *fiber.Ctx) error {
+	var key db.APIKey
+	if err := c.BodyParser(&key); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.apiKeys.GetKey(key.Name); err == nil {
+		if resourceETag(key.Settings()) != resourceETag(stored.Settings()) {
+			return apiError(http.StatusConflict, client.CodeConflict,
+				fmt.Sprintf("API key %s already exists with other settings, PUT it to change it", key.Name))
+		}
+		return sendKey(c, http.StatusOK, stored, "")
+	}
+	if err := checkPreconditions(c, ""); err != nil {
+		return err
+	}
+
+	key, secret, err := td.apiKeys.CreateKey(key)
+	if err != nil {
+		log.Println("Error creating API key: ", err)
+		return storeError(err)
+	}
+	td.audit.Record(requestID(c), "apikey.created", 0, "API key "+key.Name)
+
+	c.Location("/admin/apikeys/" + key.Name)
+	return sendKey(c, http.StatusCreated, key, secret)
+}
+
+// implementation for PUT /admin/apikeys/:name
+// changes the role, scopes, limits and expiry of an API key and keeps its
+// secret, or creates the key with a 201 and its secret when there is none
+// with the name
+func (td *VoterAPI) UpdateAPIKey(c *fiber.Ctx) error {
+	var key db.APIKey
+	if err := c.BodyParser(&key); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	key.Name = strings.Clone(c.Params("name"))
+
+	stored, err := td.apiKeys.GetKey(key.Name)
+	created := err != nil
+	etag := ""
+	if !created {
+		etag = keyETag(stored)
+	}
+	if err := checkPreconditions(c, etag); err != nil {
+		return err
+	}
+
+	if created {
+		key, secret, err := td.apiKeys.CreateKey(key)
+		if err != nil {
+			log.Println("Error creating API key: ", err)
+			return storeError(err)
+		}
+		td.audit.Record(requestID(c), "apikey.created", 0, "API key "+key.Name)
+
+		c.Location(c.Path())
+		return sendKey(c, http.StatusCreated, key, secret)
+	}
+
+	key, err = td.apiKeys.UpdateKey(key)
+	if err != nil {
+		log.Println("Error updating API key: ", err)
+		return storeError(err)
+	}
+	if keyETag(key) != etag {
+		td.audit.Record(requestID(c), "apikey.updated", 0, "API key "+key.Name)
+	}
+
+	return sendKey(c, http.StatusOK, key, "")
+}
+
+// implementation for POST /admin/apikeys/:name/rotate
+// gives an API key a new secret.  With ?grace=, e.g. ?grace=24h, the old
+// secret keeps working that long so clients can be moved over without a
+// redeploy, without it the old secret stops working at once.
+func (td *VoterAPI) RotateAPIKey(c *fiber.Ctx) error {
+	var grace time.Duration
+	if c.Query("grace") != "" {
+		var err error
+		if grace, err = time.ParseDuration(c.Query("grace")); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "grace must be a duration, e.g. 24h")
+		}
+	}
+
+	name := strings.Clone(c.Params("name"))
+	if _, err := td.apiKeys.GetKey(name); err != nil {
+		log.Println("API key not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeAPIKeyNotFound, "API key not found")
+	}
+
+	key, secret, err := td.apiKeys.RotateKey(name, grace)
+	if err != nil {
+		log.Println("Error rotating API key: ", err)
+		return storeError(err)
+	}
+	td.audit.Record(requestID(c), "apikey.rotated", 0,
+		fmt.Sprintf("API key %s, old secret valid for %s", key.Name, grace))
+
+	return sendKey(c, http.StatusOK, key, secret)
+}
+
+// implementation for POST /admin/apikeys/:name/revoke
+// revokes an API key for good, its secrets stop working on the next
+// request.  The key stays in the list until it is deleted.
+func (td *VoterAPI) RevokeAPIKey(c *fiber.Ctx) error {
+	key, err := td.apiKeys.RevokeKey(c.Params("name"))
+	if err != nil {
+		log.Println("API key not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeAPIKeyNotFound, "API key not found")
+	}
+	td.audit.Record(requestID(c), "apikey.revoked", 0, "API key "+key.Name)
+
+	return sendKey(c, http.StatusOK, key, "")
+}
+
+// implementation for DELETE /admin/apikeys/:name
+func (td *VoterAPI) DeleteAPIKey(c *fiber.Ctx) error {
+	name := strings.Clone(c.Params("name"))
+	stored, err := td.apiKeys.GetKey(name)
+	if err != nil {
+		log.Println("API key not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeAPIKeyNotFound, "API key not found")
+	}
+	if err := checkPreconditions(c, keyETag(stored)); err != nil {
+		return err
+	}
+
+	if err := td.apiKeys.DeleteKey(name); err != nil {
+		log.Println("API key not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeAPIKeyNotFound, "API key not found")
+	}
+	td.rateLimits.drop(name)
+	td.audit.Record(requestID(c), "apikey.deleted", 0, "API key "+name)
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/adllev/voter-api/bundle"
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// backupVotersFile is the file of a backup bundle that holds the voters,
+// one JSON voter per line
+const backupVotersFile = "voters.jsonl"
+
+// setExportRecords records how many records an export holds, for the
+// manifest of its bundle
+func setExportRecords(c *fiber.Ctx, records int) {
+	c.Locals("export_records", records)
+}
+
+// bundleName is the file name of the bundle an export is packed into,
+// voter-labels.csv becomes voter-labels.tar
+func bundleName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".tar"
+}
+
+// BundleExports is the middleware that packs an export into a bundle
+// with a checksum manifest when it is asked for with ?bundle=true.  The
+// plain file stays the default, mail merge tools and subject access
+// requests want the file itself.  It runs inside SealExports, so a sealed
+// export seals the whole bundle.
+func (td *VoterAPI) BundleExports(c *fiber.Ctx) error {
+	if !c.QueryBool("bundle") {
+		return c.Next()
+	}
+
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	name, ok := exportFiles[c.Route().Path]
+	if !ok || c.Response().StatusCode() != http.StatusOK ||
+		string(c.Response().Header.ContentType()) == bundle.ContentType {
+		return nil
+	}
+
+	records, _ := c.Locals("export_records").(int)
+	var pkg bytes.Buffer
+	err := bundle.Write(&pkg, bundle.File{Name: name, Data: c.Response().Body(), Records: records})
+	if err != nil {
+		log.Println("Error bundling export: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentType, bundle.ContentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, bundleName(name)))
+	c.Response().SetBodyRaw(pkg.Bytes())
+
+	return nil
+}
+
+// implementation for GET /admin/export/voters
+// backs up the voter roll as a bundle holding voters.jsonl, one voter per
+// line ordered by VoterId, and a manifest with its checksum and voter
+// count.  POST /admin/import/voters restores it.
+func (td *VoterAPI) ExportVoterBackup(c *fiber.Ctx) error {
+	voters := td.db.BackupVoters()
+
+	data, err := encodeShards(voters, func(w io.Writer, shard []db.Voter) error {
+		enc := json.NewEncoder(w)
+		for _, voter := range shard {
+			if err := enc.Encode(voter); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error encoding voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentType, bundle.ContentType)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="voter-backup.tar"`)
+
+	return bundle.Write(c, bundle.File{Name: backupVotersFile, Data: data, Records: len(voters)})
+}
+
+// implementation for POST /admin/import/voters
+// imports the voters of a backup bundle.  The bundle is checked against
+// its manifest before any voter is read, a truncated or altered bundle is
+// rejected as a whole.  ?strategy= decides what happens to a voter whose
+// id is taken: skip (the default), overwrite, merge-fields or fail.
+// Changes to fields the caller's source does not own are held back and
+// flagged for review.  The report has the outcome of every voter.  A sealed backup has to be opened
+// with voterctl verify first.  With history immutability on, overwrite is
+// refused as it would replace recorded votes, merge-fields keeps them.
+func (td *VoterAPI) ImportVoters(c *fiber.Ctx) error {
+	strategy := c.Query("strategy", db.ImportSkip)
+	if err := db.ValidateImportStrategy(strategy); err != nil {
+		return storeError(err)
+	}
+	if td.immutable && strategy == db.ImportOverwrite {
+		return historyImmutable("an import can not overwrite recorded votes, use merge-fields")
+	}
+
+	manifest, files, err := bundle.Read(bytes.NewReader(c.Body()))
+	if err != nil {
+		log.Println("Error reading import bundle: ", err)
+		return apiError(http.StatusBadRequest, client.CodeBundleInvalid, err.Error())
+	}
+
+	entry, ok := manifest.Entry(backupVotersFile)
+	if !ok {
+		return apiError(http.StatusBadRequest, client.CodeBundleInvalid, "bundle has no "+backupVotersFile)
+	}
+
+	var voters []db.Voter
+	scanner := bufio.NewScanner(bytes.NewReader(files[backupVotersFile]))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var voter db.Voter
+		if err := json.Unmarshal(scanner.Bytes(), &voter); err != nil {
+			return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("%s line %d: %v", backupVotersFile, line, err))
+		}
+		voters = append(voters, voter)
+	}
+	if err := scanner.Err(); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if len(voters) != entry.Records {
+		return apiError(http.StatusBadRequest, client.CodeBundleInvalid,
+			fmt.Sprintf("%s holds %d voters, the manifest says %d", backupVotersFile, len(voters), entry.Records))
+	}
+
+	if electionID := td.registration.frozenBy(); electionID != 0 {
+		return apiError(http.StatusConflict, client.CodeRegistrationFrozen,
+			fmt.Sprintf("registration is frozen while election %d is voting", electionID))
+	}
+
+	defer td.voterLocks.lockAll()()
+	report, err := td.db.ImportVoters(voters, strategy, writeSource(c))
+	if err != nil {
+		log.Println("Error importing voters: ", err)
+		return storeError(err)
+	}
+	changed := make([]int, 0, len(report.Outcomes))
+	for _, outcome := range report.Outcomes {
+		switch outcome.Outcome {
+		case db.ImportAdded, db.ImportOverwritten, db.ImportMerged:
+			changed = append(changed, outcome.VoterId)
+		}
+	}
+	if err := td.persist(c.UserContext(), changed...); err != nil {
+		return err
+	}
+	td.audit.Record(requestID(c), "voters.imported", 0,
+		fmt.Sprintf("%s: %d added, %d skipped, %d overwritten, %d merged, %d failed, %d flagged from a bundle created %s",
+			strategy, report.Added, report.Skipped, report.Overwritten, report.Merged, report.Failed, report.Flagged,
+			manifest.Created.Format("2006-01-02T15:04:05Z")))
+
+	return c.JSON(report)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for POST /admin/boundary-changes
+// runs a precinct boundary change file as a job.  The body is the change
+// file, either as CSV (see db.ParseBoundaryFile) or as a JSON
+// db.BoundaryChange.  By default the job only previews how many voters
+// would move between which precincts, ?apply=true reassigns them, with an
+// audit log entry for every voter.  ?effective=2006-01-02 dates the change
+// in the voters' address history, the default is the time it is applied.
+func (td *VoterAPI) PostBoundaryChange(c *fiber.Ctx) error {
+	var change db.BoundaryChange
+	var err error
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		err = json.Unmarshal(c.Body(), &change)
+	} else {
+		change, err = db.ParseBoundaryFile(bytes.NewReader(c.Body()))
+	}
+	if err != nil {
+		log.Println("Error reading boundary change: ", err)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	if effective := c.Query("effective"); effective != "" {
+		date, err := time.Parse("2006-01-02", effective)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "effective must be a date, 2006-01-02")
+		}
+		change.EffectiveDate = date
+	}
+
+	//Fail fast on a bad file instead of queueing a job that fails
+	if _, err := td.db.PreviewBoundaryChange(change); err != nil {
+		return storeError(err)
+	}
+
+	if !c.QueryBool("apply") {
+		job := td.jobs.Submit("boundary.preview", func() (any, error) {
+			return td.db.PreviewBoundaryChange(change)
+		})
+		return c.Status(http.StatusAccepted).JSON(job)
+	}
+
+	reqID := requestID(c)
+	job := td.jobs.Submit("boundary.apply", func() (any, error) {
+		defer td.voterLocks.lockAll()()
+		result, err := td.db.ApplyBoundaryChange(change, func(voterID, from, to int) {
+			td.audit.Record(reqID, "precinct.reassigned", voterID,
+				fmt.Sprintf("precinct %d -> %d (boundary change)", from, to))
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := td.persist(context.Background(), result.VoterIds...); err != nil {
+			return nil, err
+		}
+		td.audit.Record(reqID, "boundary_change.applied", 0,
+			fmt.Sprintf("%d voters reassigned in %d moves", result.Affected, len(result.Moves)))
+
+		return result, nil
+	})
+
+	return c.Status(http.StatusAccepted).JSON(job)
+}
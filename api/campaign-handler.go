@@ -0,0 +1,298 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/notify"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for POST /admin/campaigns
+// creates a campaign for every voter matching the segment and filter in
+// the body and starts sending it in the background.  The response is
+// returned right away, delivery progress can be followed on
+// GET /admin/campaigns/:id.  A campaign naming a Template is sent the
+// latest version of it, rendered for every voter, instead of Subject and
+// Body.
+func (td *VoterAPI) PostCampaign(c *fiber.Ctx) error {
+	var campaign db.Campaign
+	if err := c.BodyParser(&campaign); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	campaign.Channel = notify.ChannelEmail
+
+	filter, err := td.segmentFilter(campaign.Segment, campaign.Filter)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	campaign.Filter = filter
+
+	recipients, err := td.db.FindVoters(campaign.Filter)
+	if err != nil {
+		log.Println("Error finding voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	var render db.Renderer
+	if campaign.Template != "" {
+		tmpl, err := td.templates.GetTemplate(campaign.Template, 0)
+		if err != nil {
+			log.Println("Template not found: ", err)
+			return apiError(http.StatusNotFound, client.CodeTemplateNotFound, "template not found")
+		}
+		campaign.TemplateVersion = tmpl.Version
+		render = td.templateRenderer(tmpl, campaign.Channel)
+	}
+
+	campaign, err = td.campaigns.AddCampaign(campaign, recipients, render)
+	if err != nil {
+		log.Println("Error adding campaign: ", err)
+		return storeError(err)
+	}
+
+	go td.dispatchCampaign(campaign)
+
+	return c.Status(http.StatusAccepted).JSON(campaign)
+}
+
+// dispatchCampaign sends a campaign to all of its pending recipients, each
+// the message picked or rendered for them, and records the outcome of every message
+func (td *VoterAPI) dispatchCampaign(campaign db.Campaign) {
+	for _, delivery := range campaign.Deliveries {
+		if delivery.Status != db.DeliveryPending {
+			continue
+		}
+
+		subject, body := campaign.Message(delivery)
+		err := td.notifier.Send(notify.Message{
+			Channel: campaign.Channel,
+			To:      delivery.Address,
+			Subject: subject,
+			Body:    body,
+		})
+
+		status := db.DeliverySent
+		if err != nil {
+			status = db.DeliveryFailed
+		}
+		if err := td.campaigns.SetDeliveryStatus(campaign.CampaignId, delivery.VoterId, status, err); err != nil {
+			log.Println("Error recording delivery: ", err)
+		}
+	}
+}
+
+// implementation for GET /admin/campaigns
+func (td *VoterAPI) ListCampaigns(c *fiber.Ctx) error {
+	return c.JSON(td.campaigns.GetAllCampaigns())
+}
+
+// implementation for GET /admin/campaigns/:id
+// returns a campaign with the delivery status of every recipient
+func (td *VoterAPI) GetCampaign(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	campaign, err := td.campaigns.GetCampaign(id)
+	if err != nil {
+		log.Println("Campaign not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeCampaignNotFound, "campaign not found")
+	}
+
+	return c.JSON(campaign)
+}
+
+// rejectedEvent is a delivery event that could not be recorded
+type rejectedEvent struct {
+	VoterId int
+	Error   string
+}
+
+// implementation for POST /admin/campaigns/:id/events
+// records what the provider reported about sent messages, the body is a
+// list of db.DeliveryEvent.  Events that do not apply are returned as
+// rejected, the others are recorded, so a provider retrying a batch does
+// not fail on one bad event.  A hard bounce marks the voter's email
+// address as invalid, later campaigns skip it.
+func (td *VoterAPI) PostCampaignEvents(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var events []db.DeliveryEvent
+	if err := c.BodyParser(&events); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.campaigns.GetCampaign(id); err != nil {
+		log.Println("Campaign not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeCampaignNotFound, "campaign not found")
+	}
+
+	recorded := 0
+	rejected := make([]rejectedEvent, 0)
+	for _, event := range events {
+		delivery, err := td.campaigns.RecordEvent(id, event)
+		if err != nil {
+			rejected = append(rejected, rejectedEvent{VoterId: event.VoterId, Error: err.Error()})
+			continue
+		}
+		recorded++
+
+		if event.Type == db.EventBounced && event.Bounce == db.BounceHard {
+			unlock := td.voterLocks.lock(delivery.VoterId)
+			marked, err := td.db.MarkEmailInvalid(delivery.VoterId, delivery.Address)
+			if err == nil && marked {
+				err = td.persist(c.UserContext(), delivery.VoterId)
+			}
+			unlock()
+			if err != nil {
+				log.Println("Email not marked invalid: ", err)
+			}
+			if marked {
+				td.audit.Record(requestID(c), "voter.email_invalid", delivery.VoterId,
+					fmt.Sprintf("hard bounce in campaign %d", id))
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"recorded": recorded,
+		"rejected": rejected,
+	})
+}
+
+// implementation for GET /admin/campaigns/:id/stats
+// returns how many messages of a campaign were sent, delivered, bounced
+// and opened
+func (td *VoterAPI) GetCampaignStats(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	stats, err := td.campaigns.GetCampaignStats(id)
+	if err != nil {
+		log.Println("Campaign not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeCampaignNotFound, "campaign not found")
+	}
+
+	return c.JSON(stats)
+}
+
+// implementation for GET /voters/:id/messages
+// returns every campaign message addressed to a voter with its delivery
+// state
+func (td *VoterAPI) GetVoterMessages(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.db.GetVoter(id); err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	return c.JSON(td.campaigns.GetVoterMessages(id))
+}
+
+// implementation for POST /voters/:id/opt-out?campaign=&token=
+// withdraws the voter's consent to email, this is where unsubscribe
+// links point to.  Callers without an API key need the token signed for
+// the voter and campaign, see GET /admin/voters/:id/links.
+func (td *VoterAPI) OptOutVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if err := td.checkLinkToken(c, linkOptOut, id, c.QueryInt("campaign", 0)); err != nil {
+		return err
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	prefs := voter.Preferences
+	prefs.EmailOk = false
+	if _, err := td.db.SetPreferences(id, prefs, "unsubscribe"); err != nil {
+		log.Println("Error updating preferences: ", err)
+		return storeError(err)
+	}
+	if err := td.persist(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusOK).SendString("Opt-out OK")
+}
+
+// implementation for GET /voters/:id/preferences
+func (td *VoterAPI) GetPreferences(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	return c.JSON(voter.Preferences)
+}
+
+// implementation for PUT /voters/:id/preferences
+// replaces the communication preferences of a voter, consent changes are
+// recorded in the voter's consent history
+func (td *VoterAPI) UpdatePreferences(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var prefs db.Preferences
+	if err := c.BodyParser(&prefs); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.db.SetPreferences(id, prefs, "admin")
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if err := td.persist(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.JSON(voter.Preferences)
+}
+
+// implementation for GET /voters/:id/preferences/history
+// returns the audit trail of consent changes for a voter
+func (td *VoterAPI) GetConsentHistory(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	history, err := td.db.GetConsentHistory(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	return c.JSON(history)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// coalescedHeaders are the response headers shared along with the body
+var coalescedHeaders = []string{fiber.HeaderETag, fiber.HeaderLink, "X-Total-Count"}
+
+// coalescedConditions are the conditional request headers, a 304 or 412
+// answer to one request must not be shared with a request without them
+var coalescedConditions = []string{fiber.HeaderIfNoneMatch, fiber.HeaderIfModifiedSince,
+	fiber.HeaderIfMatch, fiber.HeaderIfUnmodifiedSince}
+
+// coalescedResponse is a response shared between identical requests
+type coalescedResponse struct {
+	status      int
+	contentType string
+	headers     map[string]string
+	body        []byte
+}
+
+// Coalesce wraps a read handler so that identical requests that arrive
+// while one is in flight share its response instead of each doing the
+// lookup and serialization again.  Requests are identical when their URL,
+// query included, their API version and their conditional headers are the
+// same and their callers are limited to the same jurisdictions.  This
+// matters when a few voters or result pages are read by hundreds of
+// clients at once, for example at poll open.
+//
+// Only wrap handlers whose output depends on nothing but the URL and the
+// caller's jurisdiction scope.  Field filtering and access logging happen
+// in the AccessControl middleware, so they still run for each caller.
+func (td *VoterAPI) Coalesce(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := coalesceKey(c)
+
+		leader := false
+		v, err, _ := td.reads.Do(key, func() (any, error) {
+			leader = true
+			if err := handler(c); err != nil {
+				return nil, err
+			}
+
+			//Copy the body, fiber reuses the buffer after the request
+			rsp := coalescedResponse{
+				status:      c.Response().StatusCode(),
+				contentType: string(c.Response().Header.ContentType()),
+				headers:     make(map[string]string),
+				body:        append([]byte(nil), c.Response().Body()...),
+			}
+			for _, name := range coalescedHeaders {
+				if value := c.Response().Header.Peek(name); len(value) > 0 {
+					rsp.headers[name] = string(value)
+				}
+			}
+			return rsp, nil
+		})
+		if err != nil || leader {
+			return err
+		}
+
+		rsp := v.(coalescedResponse)
+		c.Status(rsp.status)
+		c.Set(fiber.HeaderContentType, rsp.contentType)
+		for name, value := range rsp.headers {
+			c.Set(name, value)
+		}
+		return c.Send(rsp.body)
+	}
+}
+
+// coalesceKey is the key requests that can share a response have in common
+func coalesceKey(c *fiber.Ctx) string {
+	var b strings.Builder
+	b.WriteString(c.Method() + " " + c.OriginalURL() + " " + apiVersion(c) + " " + scopeKey(c))
+	for _, name := range coalescedConditions {
+		//Quoted, so a header value can not pass for the next header
+		b.WriteString(" " + strconv.Quote(c.Get(name)))
+	}
+	return b.String()
+}
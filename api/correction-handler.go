@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// correctedVote is the response of GET /voters/:id/polls/:pollid/corrections
+type correctedVote struct {
+	Original    db.VoterHistory //The vote as it was recorded
+	Corrections []db.VoteCorrection
+	Effective   db.VoterHistory //The vote with every correction applied
+	Void        bool
+}
+
+// SetHistoryImmutable turns on history immutability.  Recorded votes can
+// then never be changed or deleted through the API, nor can a voter who
+// has voted.  New votes are still recorded, mistakes are fixed with a
+// correction at POST /voters/:id/polls/:pollid/corrections that
+// references the vote it corrects.
+func (td *VoterAPI) SetHistoryImmutable(immutable bool) {
+	td.immutable = immutable
+	if immutable {
+		td.setFeature("history_immutable", true)
+	}
+}
+
+// historyImmutable is the error for a request that would change or delete
+// a recorded vote
+func historyImmutable(message string) error {
+	return apiError(http.StatusConflict, client.CodeHistoryImmutable, message+", record a correction instead")
+}
+
+// checkAppendOnly rejects an updated history that changes or drops a
+// stored vote when history immutability is on.  New votes are fine.  The
+// VoteId and the extension flag are set by the server and not compared.
+func (td *VoterAPI) checkAppendOnly(stored, updated []db.VoterHistory) error {
+	if !td.immutable {
+		return nil
+	}
+
+	for _, old := range stored {
+		found := false
+		for _, history := range updated {
+			if history.PollId == old.PollId {
+				found = true
+				if !sameVote(old, history) {
+					return historyImmutable(fmt.Sprintf("the vote in poll %d can not be changed", old.PollId))
+				}
+				break
+			}
+		}
+		if !found {
+			return historyImmutable(fmt.Sprintf("the vote in poll %d can not be deleted", old.PollId))
+		}
+	}
+
+	return nil
+}
+
+// sameVote reports whether two entries record the same vote
+func sameVote(a, b db.VoterHistory) bool {
+	return a.VoteDate.Equal(b.VoteDate) && a.ClientVoteDate.Equal(b.ClientVoteDate) &&
+		a.Choice == b.Choice && bytes.Equal(a.EncryptedChoice, b.EncryptedChoice) &&
+		a.Channel == b.Channel && a.DeviceId == b.DeviceId && a.PrecinctId == b.PrecinctId
+}
+
+// storedVote returns a voter's vote in a poll
+func (td *VoterAPI) storedVote(c *fiber.Ctx) (db.VoterHistory, error) {
+	voterID, err := c.ParamsInt("id")
+	if err != nil {
+		return db.VoterHistory{}, fiber.NewError(http.StatusBadRequest)
+	}
+
+	pollID, err := c.ParamsInt("pollid")
+	if err != nil {
+		return db.VoterHistory{}, fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.storeFor(c).GetVoter(voterID)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return db.VoterHistory{}, lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			return history, nil
+		}
+	}
+
+	return db.VoterHistory{}, apiError(http.StatusNotFound, client.CodePollNotFound, "Poll not found for the voter")
+}
+
+// implementation for POST /voters/:id/polls/:pollid/corrections
+// records a correction to a voter's vote in a poll, the vote itself is
+// left as it was recorded.  The body holds a required Reason and either
+// Void, when the vote should never have been recorded, or the corrected
+// VoteDate, Channel, DeviceId or PrecinctId.  Corrections can not be
+// changed or deleted, a wrong one is fixed by another correction.
+func (td *VoterAPI) PostVoteCorrection(c *fiber.Ctx) error {
+	vote, err := td.storedVote(c)
+	if err != nil {
+		return err
+	}
+
+	var correction db.VoteCorrection
+	if err := c.BodyParser(&correction); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if err := validatePayload(correction); err != nil {
+		return err
+	}
+	if err := td.checkPollLock(vote.PollId); err != nil {
+		return err
+	}
+
+	correction.VoterId, _ = c.ParamsInt("id")
+	correction.PollId = vote.PollId
+	correction.VoteId = vote.VoteId
+	correction.RequestId = requestID(c)
+	correction, err = td.corrections.Record(correction)
+	if err != nil {
+		return storeError(err)
+	}
+	td.audit.Record(correction.RequestId, "vote.corrected", correction.VoterId,
+		fmt.Sprintf("correction %d to the vote in poll %d: %s", correction.CorrectionId, correction.PollId, correction.Reason))
+
+	c.Location(fmt.Sprintf("/voters/%d/polls/%d/corrections", correction.VoterId, correction.PollId))
+	return c.Status(http.StatusCreated).JSON(correction)
+}
+
+// implementation for GET /voters/:id/polls/:pollid/corrections
+// returns a voter's vote in a poll as it was recorded, its corrections
+// oldest first and the vote as they make it
+func (td *VoterAPI) GetVoteCorrections(c *fiber.Ctx) error {
+	vote, err := td.storedVote(c)
+	if err != nil {
+		return err
+	}
+
+	voterID, _ := c.ParamsInt("id")
+	corrections := td.corrections.GetCorrections(voterID, vote.PollId)
+	effective, void := db.ApplyCorrections(vote, corrections)
+
+	return c.JSON(correctedVote{
+		Original:    vote,
+		Corrections: corrections,
+		Effective:   effective,
+		Void:        void,
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// dataExport is everything held about a single voter, returned for a
+// subject access request
+type dataExport struct {
+	Generated      time.Time
+	Voter          db.Voter
+	Revisions      []db.Revision
+	ConsentHistory []db.ConsentChange
+	Messages       []db.VoterMessage
+	CheckIns       []db.CheckIn
+	SurveyPolls    []int //Polls whose survey the voter answered
+	AuditLog       []db.AuditEntry
+	AccessLog      []db.AccessEntry
+}
+
+// implementation for GET /voters/:id/data-export
+// bundles everything held about a voter into one document to answer a
+// subject access request.  Survey answers are stored without a link to the
+// voter, so only the polls they responded to can be included.
+func (td *VoterAPI) ExportVoterData(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	consent, err := td.db.GetConsentHistory(id)
+	if err != nil {
+		log.Println("Error getting consent history: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="voter-data.json"`)
+	setExportRecords(c, 1)
+
+	return c.JSON(dataExport{
+		Generated:      time.Now(),
+		Voter:          voter,
+		Revisions:      td.db.GetRevisions(id),
+		ConsentHistory: consent,
+		Messages:       td.campaigns.GetVoterMessages(id),
+		CheckIns:       td.checkIns.GetVoterCheckIns(id),
+		SurveyPolls:    td.surveys.RespondedPolls(id),
+		AuditLog:       td.audit.GetEntries(id),
+		AccessLog:      td.accessLog.GetEntries(id),
+	})
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// kioskVoter is the view of a voter a kiosk is allowed to see, poll
+// workers only need enough to find the right person and check them in
+type kioskVoter struct {
+	VoterId    int
+	Name       string
+	PrecinctId int
+}
+
+// enrollRequest is the body of POST /devices/enroll
+type enrollRequest struct {
+	EnrollmentToken string
+}
+
+// implementation for POST /admin/devices
+// registers a device, the response carries a one time enrollment token
+// which is not shown again
+func (td *VoterAPI) PostDevice(c *fiber.Ctx) error {
+	var device db.Device
+	if err := c.BodyParser(&device); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	device, token, err := td.devices.RegisterDevice(device)
+	if err != nil {
+		log.Println("Error registering device: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"device":           device,
+		"enrollment_token": token,
+	})
+}
+
+// implementation for GET /admin/devices
+// returns all registered devices with their last heartbeat
+func (td *VoterAPI) ListDevices(c *fiber.Ctx) error {
+	return c.JSON(td.devices.GetAllDevices())
+}
+
+// implementation for GET /admin/devices/:id
+func (td *VoterAPI) GetDevice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	device, err := td.devices.GetDevice(id)
+	if err != nil {
+		log.Println("Device not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeDeviceNotFound, "device not found")
+	}
+
+	return c.JSON(device)
+}
+
+// implementation for PUT /admin/devices/:id
+// updates the name, kind and precinct of a device
+func (td *VoterAPI) UpdateDevice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var device db.Device
+	if err := c.BodyParser(&device); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	device.DeviceId = id
+
+	if _, err := td.devices.GetDevice(id); err != nil {
+		log.Println("Device not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeDeviceNotFound, "device not found")
+	}
+
+	device, err = td.devices.UpdateDevice(device)
+	if err != nil {
+		log.Println("Error updating device: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(device)
+}
+
+// implementation for DELETE /admin/devices/:id
+func (td *VoterAPI) DeleteDevice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := td.devices.DeleteDevice(id); err != nil {
+		log.Println("Device not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeDeviceNotFound, "device not found")
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /admin/devices/:id/expire
+// drops the device credential right away, for lost or stolen devices.  A
+// new enrollment token is returned for when the device is recovered.
+func (td *VoterAPI) ExpireDevice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	device, token, err := td.devices.ExpireCredentials(id)
+	if err != nil {
+		log.Println("Device not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeDeviceNotFound, "device not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"device":           device,
+		"enrollment_token": token,
+	})
+}
+
+// implementation for POST /admin/devices/:id/revoke
+// revokes a device for good, it is locked out on its next request
+func (td *VoterAPI) RevokeDevice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	device, err := td.devices.RevokeDevice(id)
+	if err != nil {
+		log.Println("Device not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeDeviceNotFound, "device not found")
+	}
+
+	return c.JSON(device)
+}
+
+// implementation for POST /devices/enroll
+// trades a one time enrollment token for the device credential
+func (td *VoterAPI) EnrollDevice(c *fiber.Ctx) error {
+	var req enrollRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	device, token, err := td.devices.Enroll(req.EnrollmentToken)
+	if err != nil {
+		log.Println("Device enrollment failed: ", err)
+		return fiber.NewError(http.StatusUnauthorized)
+	}
+
+	return c.JSON(fiber.Map{
+		"device": device,
+		"token":  token,
+	})
+}
+
+// DeviceAuth is the middleware in front of the device routes.  It checks
+// the bearer token against the enrolled devices and records the request as
+// a heartbeat.
+func (td *VoterAPI) DeviceAuth(c *fiber.Ctx) error {
+	token, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !found || token == "" {
+		return fiber.NewError(http.StatusUnauthorized)
+	}
+
+	device, err := td.devices.Authenticate(token)
+	if err != nil {
+		log.Println("Device authentication failed: ", err)
+		return fiber.NewError(http.StatusUnauthorized)
+	}
+
+	if err := td.devices.Heartbeat(device.DeviceId, nil); err != nil {
+		log.Println("Error recording heartbeat: ", err)
+	}
+
+	c.Locals("device", device)
+	return c.Next()
+}
+
+// KioskOnly is the middleware in front of the /kiosk routes.  It must run
+// after DeviceAuth and rejects any device that is not a kiosk.
+func KioskOnly(c *fiber.Ctx) error {
+	device := c.Locals("device").(db.Device)
+	if device.Kind != db.DeviceKiosk {
+		return fiber.NewError(http.StatusForbidden)
+	}
+
+	return c.Next()
+}
+
+// implementation for POST /devices/heartbeat
+// records the status report of a device
+func (td *VoterAPI) DeviceHeartbeat(c *fiber.Ctx) error {
+	device := c.Locals("device").(db.Device)
+
+	var report db.DeviceReport
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&report); err != nil {
+			log.Println("Error binding JSON: ", err)
+			return fiber.NewError(http.StatusBadRequest)
+		}
+	}
+
+	if err := td.devices.Heartbeat(device.DeviceId, &report); err != nil {
+		log.Println("Error recording heartbeat: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).SendString("Heartbeat OK")
+}
+
+// implementation for GET /kiosk/voters?name=
+// searches by name within the kiosk's precinct
+func (td *VoterAPI) KioskSearch(c *fiber.Ctx) error {
+	device := c.Locals("device").(db.Device)
+
+	name := c.Query("name")
+	if name == "" {
+		return fiber.NewError(http.StatusBadRequest, "name is required")
+	}
+
+	voterList, err := td.db.FindVoters(db.VoterFilter{
+		Name:       name,
+		PrecinctId: device.PrecinctId,
+	})
+	if err != nil {
+		log.Println("Error finding voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	results := make([]kioskVoter, 0, len(voterList))
+	for _, voter := range voterList {
+		results = append(results, kioskVoter{
+			VoterId:    voter.VoterId,
+			Name:       voter.Name,
+			PrecinctId: voter.PrecinctId,
+		})
+	}
+
+	return c.JSON(results)
+}
+
+// implementation for POST /kiosk/voters/:id/checkin
+// checks a voter in, the voter has to belong to the kiosk's precinct
+func (td *VoterAPI) KioskCheckIn(c *fiber.Ctx) error {
+	device := c.Locals("device").(db.Device)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil || voter.PrecinctId != device.PrecinctId {
+		//Voters from other precincts are reported as not found, a kiosk
+		//should not be able to tell who is registered elsewhere
+		return apiError(http.StatusNotFound, client.CodeVoterNotFound, "")
+	}
+
+	checkIn, err := td.checkIns.AddCheckIn(db.CheckIn{
+		VoterId:    voter.VoterId,
+		DeviceId:   device.DeviceId,
+		PrecinctId: device.PrecinctId,
+	})
+	if err != nil {
+		log.Println("Error checking in voter: ", err)
+		return apiError(http.StatusConflict, client.CodeAlreadyCheckedIn, err.Error())
+	}
+
+	return c.JSON(checkIn)
+}
+
+// implementation for GET /admin/devices/skew
+// returns how far the clock of every device that recorded votes is off the
+// server clock, votes recorded without a device are listed as device 0
+func (td *VoterAPI) GetDeviceSkew(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"max_ahead":  db.MaxClockAhead.String(),
+		"max_behind": db.MaxClockBehind.String(),
+		"devices":    td.skew.GetSkews(),
+	})
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// electionView is an election along with the states it can move to next
+type electionView struct {
+	db.Election
+	NextStates []db.ElectionState
+}
+
+// sendElection responds with an election and the states it can move to
+func (td *VoterAPI) sendElection(c *fiber.Ctx, id int) error {
+	election, err := td.elections.GetElection(id)
+	if err != nil {
+		log.Println("Election not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeElectionNotFound, "election not found")
+	}
+
+	return c.JSON(electionView{Election: election, NextStates: db.NextStates(election.State)})
+}
+
+// transitionRequest is the body of POST /elections/:id/transition
+type transitionRequest struct {
+	State db.ElectionState
+}
+
+// registrationFreeze is the set of elections that have frozen voter
+// registration while they are voting
+type registrationFreeze struct {
+	mu        sync.Mutex
+	elections map[int]bool
+}
+
+func (f *registrationFreeze) freeze(electionID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.elections[electionID] = true
+}
+
+func (f *registrationFreeze) thaw(electionID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.elections, electionID)
+}
+
+// frozenBy returns the lowest id of an election that froze registration,
+// zero if registration is open
+func (f *registrationFreeze) frozenBy() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lowest := 0
+	for id := range f.elections {
+		if lowest == 0 || id < lowest {
+			lowest = id
+		}
+	}
+	return lowest
+}
+
+// registerElectionHooks sets up what happens as elections move through
+// their lifecycle.  Voting needs polls on the ballot, registration is
+// frozen while voting and the polls are locked once counting starts.
+func (td *VoterAPI) registerElectionHooks() {
+	td.elections.OnTransition(db.StateVoting, func(election db.Election, from db.ElectionState) error {
+		if len(election.PollIds) == 0 {
+			return fmt.Errorf("election %d has no polls to vote on", election.ElectionId)
+		}
+		td.registration.freeze(election.ElectionId)
+		return nil
+	})
+	td.elections.OnTransition(db.StateCounting, func(election db.Election, from db.ElectionState) error {
+		td.polls.LockPolls(election.ElectionId, election.PollIds)
+		td.registration.thaw(election.ElectionId)
+		return nil
+	})
+}
+
+// checkPollLock refuses changes to the votes of a poll that has been
+// locked by its election
+func (td *VoterAPI) checkPollLock(pollID int) error {
+	if electionID := td.polls.LockedBy(pollID); electionID != 0 {
+		return apiError(http.StatusConflict, client.CodePollLocked,
+			fmt.Sprintf("poll is locked, election %d is past voting", electionID))
+	}
+	return nil
+}
+
+// implementation for GET /elections
+// returns the elections, ?state= only returns those in a lifecycle state
+func (td *VoterAPI) ListElections(c *fiber.Ctx) error {
+	if state := c.Query("state"); state != "" {
+		return c.JSON(td.elections.InState(db.ElectionState(state)))
+	}
+	return c.JSON(td.elections.GetAllElections())
+}
+
+// implementation for GET /elections/:id
+func (td *VoterAPI) GetElection(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	return td.sendElection(c, id)
+}
+
+// implementation for POST /elections
+func (td *VoterAPI) PostElection(c *fiber.Ctx) error {
+	var election db.Election
+	if err := c.BodyParser(&election); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := td.elections.AddElection(election); err != nil {
+		log.Println("Error adding election: ", err)
+		return storeError(err)
+	}
+
+	return td.sendElection(c, election.ElectionId)
+}
+
+// implementation for PUT /elections/:id
+func (td *VoterAPI) UpdateElection(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var election db.Election
+	if err := c.BodyParser(&election); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	election.ElectionId = id
+
+	if _, err := td.elections.GetElection(id); err != nil {
+		log.Println("Election not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeElectionNotFound, "election not found")
+	}
+
+	if err := td.elections.UpdateElection(election); err != nil {
+		log.Println("Error updating election: ", err)
+		return storeError(err)
+	}
+
+	return td.sendElection(c, election.ElectionId)
+}
+
+// implementation for DELETE /elections/:id
+func (td *VoterAPI) DeleteElection(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.elections.GetElection(id); err != nil {
+		log.Println("Election not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeElectionNotFound, "election not found")
+	}
+
+	if err := td.elections.DeleteElection(id); err != nil {
+		log.Println("Error deleting election: ", err)
+		return apiError(http.StatusConflict, client.CodeInvalidTransition, err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /elections/:id/transition
+// moves an election to the next state of its lifecycle, setup,
+// registration-open, voting, counting, certified and archived.  The body
+// names the new state, the response is the election as it now stands.
+// Certifying needs a step-up token, see RequireStepUp.
+func (td *VoterAPI) TransitionElection(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req transitionRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.elections.GetElection(id); err != nil {
+		log.Println("Election not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeElectionNotFound, "election not found")
+	}
+	//Certifying makes the results final, it needs a second factor
+	if req.State == db.StateCertified {
+		if err := td.checkStepUp(c); err != nil {
+			return err
+		}
+	}
+
+	election, err := td.elections.Transition(id, req.State, callerName(c))
+	if err != nil {
+		log.Println("Error moving election: ", err)
+		return apiError(http.StatusConflict, client.CodeInvalidTransition, err.Error())
+	}
+	last := election.Transitions[len(election.Transitions)-1]
+	td.audit.Record(requestID(c), "election.transition", 0,
+		fmt.Sprintf("election %d moved from %s to %s", id, last.From, last.To))
+
+	return c.JSON(electionView{Election: election, NextStates: db.NextStates(election.State)})
+}
+
+// implementation for GET /elections/:id/calendar.ics
+// returns an iCalendar feed with the key dates of the election so it can
+// be subscribed to from any calendar app
+func (td *VoterAPI) GetElectionCalendar(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	election, err := td.elections.GetElection(id)
+	if err != nil {
+		log.Println("Election not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeElectionNotFound, "election not found")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	return c.SendString(electionCalendar(election, c.Hostname()))
+}
+
+// electionCalendar renders the dates of an election as an iCalendar
+// (RFC 5545) document.  All events are all-day events, DTEND is exclusive
+// so it is the day after the last day of the event.
+func electionCalendar(election db.Election, host string) string {
+	const dateFormat = "20060102"
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	event := func(kind, summary string, start, end time.Time) {
+		if start.IsZero() {
+			return
+		}
+		line("BEGIN:VEVENT")
+		line("UID:election-%d-%s@%s", election.ElectionId, kind, host)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART;VALUE=DATE:%s", start.Format(dateFormat))
+		line("DTEND;VALUE=DATE:%s", end.AddDate(0, 0, 1).Format(dateFormat))
+		line("SUMMARY:%s", icsEscape(summary))
+		line("END:VEVENT")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//voter-api//elections//EN")
+	line("X-WR-CALNAME:%s", icsEscape(election.Name))
+	event("registration", election.Name+" - registration deadline",
+		election.RegistrationDeadline, election.RegistrationDeadline)
+	event("early-voting", election.Name+" - early voting",
+		election.EarlyVotingStart, election.EarlyVotingEnd)
+	event("election-day", election.Name+" - election day",
+		election.ElectionDay, election.ElectionDay)
+	line("END:VCALENDAR")
+
+	return b.String()
+}
+
+// icsEscape escapes text values as required by RFC 5545
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// statusCodes are the error codes used for plain fiber errors, which do
+// not carry a code of their own
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          client.CodeInvalidRequest,
+	http.StatusUnauthorized:        client.CodeUnauthorized,
+	http.StatusForbidden:           client.CodeForbidden,
+	http.StatusNotFound:            client.CodeNotFound,
+	http.StatusMethodNotAllowed:    client.CodeInvalidRequest,
+	http.StatusConflict:            client.CodeConflict,
+	http.StatusTooManyRequests:     client.CodeRateLimited,
+	http.StatusInternalServerError: client.CodeInternal,
+	http.StatusServiceUnavailable:  client.CodeUnavailable,
+}
+
+// apiError returns an error response with a specific error code.  An
+// empty message uses the status text.
+func apiError(status int, code, message string) error {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &client.Error{Status: status, Code: code, Message: message}
+}
+
+// validatePayload checks a request payload against the validate tags of
+// its type.  A payload that breaks them is a 400 that lists every field
+// that is wrong.
+func validatePayload(payload any) error {
+	var errs validate.Errors
+	if err := validate.Struct(payload); !errors.As(err, &errs) {
+		return err
+	}
+
+	fields := make([]client.FieldError, len(errs))
+	for i, field := range errs {
+		fields[i] = client.FieldError{Field: field.Field, Rule: field.Rule, Message: field.Message}
+	}
+	return &client.Error{
+		Status:  http.StatusBadRequest,
+		Code:    client.CodeInvalidFields,
+		Message: errs.Error(),
+		Fields:  fields,
+	}
+}
+
+// storageRetryAfter is the Retry-After sent when the storage is down
+const storageRetryAfter = 30
+
+// lookupError returns the error response for a failed lookup.  It is a
+// not found error unless the storage could not be reached, in which case
+// the client is asked to retry later.
+func lookupError(err error, code string, message string) error {
+	if db.IsUnavailable(err) {
+		return &client.Error{
+			Status:     http.StatusServiceUnavailable,
+			Code:       client.CodeStorageUnavailable,
+			Message:    err.Error(),
+			RetryAfter: storageRetryAfter,
+		}
+	}
+	return apiError(http.StatusNotFound, code, message)
+}
+
+// storeError returns the error response for a failed store call.  The
+// kinds of error the db package returns are 404, 409 and 400, a voter
+// under legal hold is a conflict and storage that can not be reached asks
+// the client to retry later.  Anything else is an internal error.
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return apiError(http.StatusNotFound, client.CodeNotFound, err.Error())
+	case errors.Is(err, db.ErrAlreadyExists):
+		return apiError(http.StatusConflict, client.CodeConflict, err.Error())
+	case errors.Is(err, db.ErrInvalidInput):
+		return apiError(http.StatusBadRequest, client.CodeInvalidRequest, err.Error())
+	case db.IsLegalHold(err):
+		return apiError(http.StatusConflict, client.CodeLegalHold, err.Error())
+	case db.IsUnavailable(err):
+		return lookupError(err, "", "")
+	}
+	return fiber.NewError(http.StatusInternalServerError)
+}
+
+// ErrorHandler renders every error returned by a handler as a JSON body
+// with a machine readable code and a message
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	rsp := &client.Error{
+		Status:  http.StatusInternalServerError,
+		Code:    client.CodeInternal,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}
+
+	var coded *client.Error
+	var fiberErr *fiber.Error
+	if errors.As(err, &coded) {
+		rsp = coded
+	} else if errors.As(err, &fiberErr) {
+		rsp.Status = fiberErr.Code
+		rsp.Message = fiberErr.Message
+		rsp.Code = statusCodes[fiberErr.Code]
+		if rsp.Code == "" {
+			rsp.Code = client.CodeInvalidRequest
+			if fiberErr.Code >= http.StatusInternalServerError {
+				rsp.Code = client.CodeInternal
+			}
+		}
+	} else if errors.As(storeError(err), &coded) {
+		rsp = coded
+	} else {
+		log.Println("Unhandled error: ", err)
+	}
+
+	if rsp.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(rsp.RetryAfter))
+	}
+
+	return c.Status(rsp.Status).JSON(rsp)
+}
+
+// responseStatus returns the status a request is answered with, which for
+// a handler that returned an error is not yet set on the response
+func responseStatus(c *fiber.Ctx, err error) int {
+	var coded *client.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &coded):
+		return coded.Status
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code
+	case errors.As(storeError(err), &coded):
+		return coded.Status
+	case err != nil:
+		return http.StatusInternalServerError
+	}
+	return c.Response().StatusCode()
+}
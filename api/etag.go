@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Resources with a natural key, a poll, a polling place, an API key or a
+// webhook, are managed the way declarative tools like Terraform expect:
+//
+//	POST   201 when it creates, 200 when it repeats what is stored, 409
+//	       when the key is taken by a resource with other content
+//	PUT    201 when it creates, 200 when it replaces
+//	GET    the resource with its ETag, 304 for a matching If-None-Match
+//
+// Every write takes If-Match, to change only the version the client read,
+// and If-None-Match: *, to create only.  A condition that does not hold
+// is a 412.
+
+// resourceETag returns the strong ETag of a resource, a hash of its JSON
+// form.  It is the same on every server and changes with any field.
+func resourceETag(resource any) string {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// an ETag, * matches any resource that exists.  An empty ETag is a
+// resource that does not exist.
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions applies the If-Match and If-None-Match headers of a
+// write to the stored resource, etag is its ETag or empty when there is
+// none
+func checkPreconditions(c *fiber.Ctx, etag string) error {
+	if match := c.Get(fiber.HeaderIfMatch); match != "" && !etagMatches(match, etag) {
+		if etag == "" {
+			return apiError(http.StatusPreconditionFailed, client.CodePrecondition, "the resource does not exist")
+		}
+		return apiError(http.StatusPreconditionFailed, client.CodePrecondition, "the resource changed since it was read")
+	}
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return apiError(http.StatusPreconditionFailed, client.CodePrecondition, "the resource already exists")
+	}
+	return nil
+}
+
+// sendResource answers with a resource and its ETag, a GET whose
+// If-None-Match lists the ETag gets a 304 without a body
+func sendResource(c *fiber.Ctx, status int, resource any) error {
+	return sendTagged(c, status, resourceETag(resource), resource)
+}
+
+// sendTagged is sendResource for a resource whose ETag leaves out fields
+// that change without anyone writing to it, like when it was last used
+func sendTagged(c *fiber.Ctx, status int, etag string, resource any) error {
+	c.Set(fiber.HeaderETag, etag)
+	if c.Method() == fiber.MethodGet && etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(http.StatusNotModified)
+	}
+	return c.Status(status).JSON(resource)
+}
+
+// sendPut answers a PUT with the resource as stored, a 201 with its
+// Location when the PUT created it
+func sendPut(c *fiber.Ctx, created bool, resource any) error {
+	if created {
+		c.Location(c.Path())
+		return sendResource(c, http.StatusCreated, resource)
+	}
+	return sendResource(c, http.StatusOK, resource)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// matchRequest is the body of POST /admin/exclusions/:id/match
+type matchRequest struct {
+	Keys      []string
+	Threshold float64
+}
+
+// implementation for POST /admin/exclusions
+// uploads a new exclusion list
+func (td *VoterAPI) PostExclusionList(c *fiber.Ctx) error {
+	var list db.ExclusionList
+	if err := c.BodyParser(&list); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	list, err := td.exclusions.AddList(list)
+	if err != nil {
+		log.Println("Error adding exclusion list: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(list)
+}
+
+// implementation for POST /admin/exclusions/:id/match
+// matches the roll against an uploaded list and stores the report.  Voters
+// are only flagged as candidates for review, nobody is removed from the
+// roll.
+func (td *VoterAPI) MatchExclusionList(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	req := matchRequest{
+		Keys:      []string{"name"},
+		Threshold: db.DefaultExclusionThreshold,
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			log.Println("Error binding JSON: ", err)
+			return fiber.NewError(http.StatusBadRequest)
+		}
+	}
+
+	list, err := td.exclusions.GetList(id)
+	if err != nil {
+		log.Println("Exclusion list not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeExclusionNotFound, "exclusion list not found")
+	}
+
+	candidates, err := td.db.MatchExclusions(list, req.Keys, req.Threshold)
+	if err != nil {
+		log.Println("Error matching exclusion list: ", err)
+		return storeError(err)
+	}
+
+	report := td.exclusions.AddReport(db.ExclusionReport{
+		ListId:     list.Id,
+		Keys:       req.Keys,
+		Threshold:  req.Threshold,
+		Candidates: candidates,
+	})
+	for _, candidate := range candidates {
+		td.reviews.Flag(db.ReviewDuplicate, candidate.VoterId,
+			fmt.Sprintf("%s matches %s on exclusion list %d (score %.2f)",
+				candidate.Name, candidate.Entry.Name, list.Id, candidate.Score), candidate)
+	}
+
+	return c.JSON(report)
+}
+
+// implementation for GET /admin/exclusions/reports/:id
+// returns a stored match report for review
+func (td *VoterAPI) GetExclusionReport(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	report, err := td.exclusions.GetReport(id)
+	if err != nil {
+		log.Println("Exclusion report not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeExclusionNotFound, "exclusion report not found")
+	}
+
+	return c.JSON(report)
+}
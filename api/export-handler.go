@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportShardSize is the number of voters an export worker encodes at a
+// time
+const exportShardSize = 1000
+
+// encodeShards encodes voters with parallel workers and returns the
+// output as if they had been encoded one after the other.  The voters are
+// split into shards of consecutive voters, every worker encodes whole
+// shards into buffers of its own and the buffers are joined in shard
+// order, so an export comes out byte for byte the same on every run.
+func encodeShards(voters []db.Voter, encode func(w io.Writer, shard []db.Voter) error) ([]byte, error) {
+	shards := (len(voters) + exportShardSize - 1) / exportShardSize
+	out := make([]bytes.Buffer, shards)
+	errs := make([]error, shards)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), shards); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range next {
+				end := min((shard+1)*exportShardSize, len(voters))
+				errs[shard] = encode(&out[shard], voters[shard*exportShardSize:end])
+			}
+		}()
+	}
+	for shard := 0; shard < shards; shard++ {
+		next <- shard
+	}
+	close(next)
+	wg.Wait()
+
+	var data bytes.Buffer
+	for shard := range out {
+		if errs[shard] != nil {
+			return nil, errs[shard]
+		}
+		data.Write(out[shard].Bytes())
+	}
+	return data.Bytes(), nil
+}
+
+// labelHeader is the header row of the label export.  The column names are
+// the ones the Avery mail merge wizard picks up without any mapping.
+var labelHeader = []string{"Name", "Address Line 1", "City", "State", "ZIP"}
+
+// implementation for GET /voters/export
+// exports the voters matching the filter parameters (see voterFilter).
+// Only ?format=labels is supported right now, it produces a mail merge CSV
+// for printing mailing labels, voters without an address and voters
+// outside the caller's scope are left out.  A label needs the name and the
+// address, a caller whose role can not see both is refused.
+func (td *VoterAPI) ExportVoters(c *fiber.Ctx) error {
+	if format := c.Query("format", "labels"); format != "labels" {
+		return fiber.NewError(http.StatusBadRequest, "unsupported export format "+format)
+	}
+
+	//The CSV never passes through the JSON access control, so the checks
+	//it makes on voters are made here
+	caller, _ := c.Locals("principal").(principal)
+	if allowed := td.visibleFields(caller.Role); allowed != nil && (!allowed["Name"] || !allowed["Address"]) {
+		return apiError(http.StatusForbidden, client.CodeForbidden,
+			"the role "+caller.Role+" may not see the names and addresses of voters")
+	}
+
+	filter, err := td.voterFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	voterList, err := td.db.FindVoters(filter)
+	if err != nil {
+		log.Println("Error finding voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	//Sort by id so that two exports of the same data are identical
+	sort.Slice(voterList, func(i, j int) bool {
+		return voterList[i].VoterId < voterList[j].VoterId
+	})
+
+	labeled := make([]db.Voter, 0, len(voterList))
+	exported := make([]int, 0, len(voterList))
+	for _, voter := range voterList {
+		if voter.Address.Street == "" || !caller.sees(voter.VoterId, voter.PrecinctId) {
+			continue
+		}
+		labeled = append(labeled, voter)
+		exported = append(exported, voter.VoterId)
+	}
+
+	rows, err := encodeShards(labeled, func(out io.Writer, shard []db.Voter) error {
+		w := csv.NewWriter(out)
+		for _, voter := range shard {
+			err := w.Write([]string{
+				voter.Name,
+				voter.Address.Street,
+				voter.Address.City,
+				voter.Address.State,
+				voter.Address.Zip,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		log.Println("Error writing labels: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="voter-labels.csv"`)
+
+	w := csv.NewWriter(c)
+	if err := w.Write(labelHeader); err != nil {
+		return err
+	}
+	w.Flush()
+	if _, err := c.Write(rows); err != nil {
+		return err
+	}
+	setExportRecords(c, len(exported))
+
+	//Every label shows a name and an address, logged the way logAccess
+	//logs a JSON response
+	shown := make([]any, len(labeled))
+	for i, voter := range labeled {
+		shown[i] = map[string]any{"VoterId": float64(voter.VoterId), "Name": voter.Name, "Address": voter.Address}
+	}
+	td.logAccess(c, caller, shown)
+	td.tripwire(c, caller, exported)
+
+	return nil
+}
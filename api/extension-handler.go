@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// extensionRequest is the body of POST /polls/:id/extend
+type extensionRequest struct {
+	Closes        time.Time
+	Justification string
+}
+
+// extensionCaller returns the name of the operator behind a poll
+// extension request.  Dual approval needs the two operators told apart, so
+// extensions are refused when access control is off or the caller is
+// anonymous or a device.
+func (td *VoterAPI) extensionCaller(c *fiber.Ctx) (string, error) {
+	caller, _ := c.Locals("principal").(principal)
+	if td.access == nil || caller.Role == AnonymousRole || caller.Name == "" || c.Locals("device") != nil {
+		return "", apiError(http.StatusForbidden, client.CodeForbidden,
+			"poll extensions need access control so the requester and approver can be told apart")
+	}
+	return caller.Name, nil
+}
+
+// findExtension returns an extension of a poll by id
+func (td *VoterAPI) findExtension(pollID, extensionID int) (db.PollExtension, bool) {
+	for _, extension := range td.polls.GetExtensions(pollID) {
+		if extension.ExtensionId == extensionID {
+			return extension, true
+		}
+	}
+	return db.PollExtension{}, false
+}
+
+// implementation for POST /polls/:id/extend
+// requests a new close time for a poll, for example when a court orders
+// the polls to stay open.  The body carries the new close time and the
+// justification.  The extension is pending until a second operator
+// approves it with POST /polls/:id/extensions/:ext/approve.
+func (td *VoterAPI) ExtendPoll(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	by, err := td.extensionCaller(c)
+	if err != nil {
+		return err
+	}
+
+	var req extensionRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.polls.GetPoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	extension, err := td.polls.RequestExtension(id, req.Closes, req.Justification, by)
+	if err != nil {
+		log.Println("Error requesting poll extension: ", err)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	td.audit.Record(requestID(c), "poll_extension.requested", 0,
+		fmt.Sprintf("poll %d to close at %s: %s", id, extension.NewClose.Format(time.RFC3339), extension.Justification))
+
+	return c.Status(http.StatusAccepted).JSON(extension)
+}
+
+// implementation for GET /polls/:id/extensions
+func (td *VoterAPI) ListExtensions(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.polls.GetPoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	return c.JSON(td.polls.GetExtensions(id))
+}
+
+// implementation for POST /polls/:id/extensions/:ext/approve and
+// POST /polls/:id/extensions/:ext/reject
+func (td *VoterAPI) decideExtension(c *fiber.Ctx, approve bool) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	extensionID, err := c.ParamsInt("ext")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	by, err := td.extensionCaller(c)
+	if err != nil {
+		return err
+	}
+
+	pending, ok := td.findExtension(id, extensionID)
+	if !ok {
+		return apiError(http.StatusNotFound, client.CodeExtensionNotFound, "extension not found")
+	}
+	if pending.RequestedBy == by {
+		return apiError(http.StatusForbidden, client.CodeForbidden,
+			"an extension must be decided by someone other than the requester")
+	}
+
+	extension, err := td.polls.DecideExtension(id, extensionID, approve, by)
+	if err != nil {
+		log.Println("Error deciding poll extension: ", err)
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+	td.audit.Record(requestID(c), "poll_extension."+extension.Status, 0,
+		fmt.Sprintf("poll %d extension %d requested by %s", id, extensionID, extension.RequestedBy))
+
+	return c.JSON(extension)
+}
+
+// implementation for POST /polls/:id/extensions/:ext/approve
+// approves a pending extension, which moves the close time of the poll
+func (td *VoterAPI) ApproveExtension(c *fiber.Ctx) error {
+	return td.decideExtension(c, true)
+}
+
+// implementation for POST /polls/:id/extensions/:ext/reject
+func (td *VoterAPI) RejectExtension(c *fiber.Ctx) error {
+	return td.decideExtension(c, false)
+}
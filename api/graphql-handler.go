@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/graphql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// graphqlCtxKey carries the request of a GraphQL query to its resolvers
+type graphqlCtxKey struct{}
+
+// EnableGraphQL serves /graphql on top of the routes of app.  Every field
+// of a query or mutation is resolved by the REST route that serves it, run
+// with the caller's credentials, so API keys, roles, jurisdictions, rate
+// limits and the audit trail apply to GraphQL exactly as they do to REST.
+// Each of those requests counts against the caller's rate limit.
+func (td *VoterAPI) EnableGraphQL(app *fiber.App) {
+	td.routes = sync.OnceValue(app.Handler)
+	td.graphql = td.graphqlSchema()
+	td.setFeature("graphql", true)
+}
+
+// graphqlSchema builds the schema of /graphql, its types are the voter
+// structs as the REST routes return them
+func (td *VoterAPI) graphqlSchema() *graphql.Schema {
+	types := graphql.NewReflected()
+	voter := types.Object(reflect.TypeOf(db.Voter{}))
+	voter.Description = "A registered voter, fields the caller's role may not see are null"
+	history := types.Object(reflect.TypeOf(db.VoterHistory{}))
+	voterInput := types.Input(reflect.TypeOf(db.Voter{}))
+	historyInput := types.Input(reflect.TypeOf(db.VoterHistory{}))
+
+	//The vote history of a voter can be narrowed to one poll
+	voteHistory := voter.Field("voteHistory")
+	voteHistory.Args = []*graphql.Argument{{Name: "pollId", Type: graphql.Int}}
+	voteHistory.Resolve = func(p graphql.Params) (any, error) {
+		entries, _ := p.Source.(map[string]any)["VoteHistory"].([]any)
+		pollID, ok := p.Args["pollId"].(int)
+		if !ok {
+			return entries, nil
+		}
+		kept := make([]any, 0, 1)
+		for _, entry := range entries {
+			if id, _ := entry.(map[string]any)["PollId"].(float64); int(id) == pollID {
+				kept = append(kept, entry)
+			}
+		}
+		return kept, nil
+	}
+
+	sortField := &graphql.Enum{Name: "VoterSortField", Values: []string{"VOTERID", "NAME", "EMAIL"}}
+	sortOrder := &graphql.Enum{Name: "SortOrder", Values: []string{"ASC", "DESC"}}
+	requiredInt := &graphql.NonNull{Of: graphql.Int}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{
+			Name:        "voter",
+			Description: "A voter by id, as it is now or with asOf as it was then, null when there is none",
+			Type:        voter,
+			Args:        []*graphql.Argument{{Name: "id", Type: requiredInt}, {Name: "asOf", Type: graphql.DateTime}},
+			Resolve: func(p graphql.Params) (any, error) {
+				path := fmt.Sprintf("/voters/%d", p.Args["id"])
+				if asOf, ok := p.Args["asOf"].(string); ok {
+					path += "?as_of=" + url.QueryEscape(asOf)
+				}
+				return orNull(td.dispatch(p, fiber.MethodGet, path, nil))
+			},
+		},
+		{
+			Name:        "voterByEmail",
+			Description: "The voter registered with an email address, null when there is none",
+			Type:        voter,
+			Args:        []*graphql.Argument{{Name: "email", Type: &graphql.NonNull{Of: graphql.String}}},
+			Resolve: func(p graphql.Params) (any, error) {
+				return orNull(td.dispatch(p, fiber.MethodGet, "/voters/by-email/"+url.PathEscape(p.Args["email"].(string)), nil))
+			},
+		},
+		{
+			Name:        "voters",
+			Description: "A page of the voters matching the filters, see GET /voters",
+			Type:        &graphql.NonNull{Of: &graphql.List{Of: &graphql.NonNull{Of: voter}}},
+			Args: []*graphql.Argument{
+				{Name: "name", Key: "name", Type: graphql.String},
+				{Name: "email", Key: "email", Type: graphql.String},
+				{Name: "precinctId", Key: "precinct", Type: graphql.Int},
+				{Name: "status", Key: "status", Type: graphql.String},
+				{Name: "tag", Key: "tag", Type: graphql.String},
+				{Name: "segment", Key: "segment", Type: graphql.String},
+				{Name: "registeredAfter", Key: "registered_after", Type: graphql.DateTime},
+				{Name: "registeredBefore", Key: "registered_before", Type: graphql.DateTime},
+				{Name: "movedSince", Key: "moved_since", Type: graphql.DateTime},
+				{Name: "sort", Key: "sort", Type: sortField},
+				{Name: "order", Key: "order", Type: sortOrder},
+				{Name: "limit", Key: "limit", Type: graphql.Int},
+				{Name: "offset", Key: "offset", Type: graphql.Int},
+			},
+			Resolve: func(p graphql.Params) (any, error) {
+				query := url.Values{}
+				for key, value := range p.Args {
+					switch {
+					case value == nil:
+					case key == "sort" || key == "order":
+						query.Set(key, strings.ToLower(value.(string)))
+					default:
+						query.Set(key, fmt.Sprint(value))
+					}
+				}
+				return td.dispatch(p, fiber.MethodGet, "/voters?"+query.Encode(), nil)
+			},
+		},
+		{
+			Name:        "voterCount",
+			Description: "The number of registered voters, now or with asOf at a point in the past",
+			Type:        requiredInt,
+			Args:        []*graphql.Argument{{Name: "asOf", Type: graphql.DateTime}},
+			Resolve: func(p graphql.Params) (any, error) {
+				path := "/voters/count"
+				if asOf, ok := p.Args["asOf"].(string); ok {
+					path += "?as_of=" + url.QueryEscape(asOf)
+				}
+				count, err := td.dispatch(p, fiber.MethodGet, path, nil)
+				if err != nil {
+					return nil, err
+				}
+				return count.(map[string]any)["count"], nil
+			},
+		},
+	}}
+
+	voteArgs := []*graphql.Argument{{Name: "voterId", Type: requiredInt}, {Name: "pollId", Type: requiredInt}}
+	votePath := func(p graphql.Params) string {
+		return fmt.Sprintf("/voters/%d/polls/%d", p.Args["voterId"], p.Args["pollId"])
+	}
+
+	mutation := &graphql.Object{Name: "Mutation", Fields: []*graphql.Field{
+		{
+			Name:        "createVoter",
+			Description: "Adds a voter, one without a voterId is given the next free one",
+			Type:        &graphql.NonNull{Of: voter},
+			Args:        []*graphql.Argument{{Name: "voter", Type: &graphql.NonNull{Of: voterInput}}},
+			Resolve: func(p graphql.Params) (any, error) {
+				return td.dispatch(p, fiber.MethodPost, "/voters", p.Args["voter"])
+			},
+		},
+		{
+			Name:        "updateVoter",
+			Description: "Replaces a voter",
+			Type:        &graphql.NonNull{Of: voter},
+			Args:        []*graphql.Argument{{Name: "id", Type: requiredInt}, {Name: "voter", Type: &graphql.NonNull{Of: voterInput}}},
+			Resolve: func(p graphql.Params) (any, error) {
+				return td.dispatch(p, fiber.MethodPut, fmt.Sprintf("/voters/%d", p.Args["id"]), p.Args["voter"])
+			},
+		},
+		{
+			Name:        "patchVoter",
+			Description: "Changes the fields of a voter that are given, fields given as null are cleared",
+			Type:        &graphql.NonNull{Of: voter},
+			Args:        []*graphql.Argument{{Name: "id", Type: requiredInt}, {Name: "voter", Type: &graphql.NonNull{Of: voterInput}}},
+			Resolve: func(p graphql.Params) (any, error) {
+				return td.dispatch(p, fiber.MethodPatch, fmt.Sprintf("/voters/%d", p.Args["id"]), p.Args["voter"])
+			},
+		},
+		{
+			Name:        "deleteVoter",
+			Description: "Deletes a voter and returns it as it was",
+			Type:        voter,
+			Args:        []*graphql.Argument{{Name: "id", Type: requiredInt}},
+			Resolve: func(p graphql.Params) (any, error) {
+				return td.dispatch(p, fiber.MethodDelete, fmt.Sprintf("/voters/%d", p.Args["id"]), nil)
+			},
+		},
+		{
+			Name:        "recordVote",
+			Description: "Records the vote of a voter in a poll, override replaces a vote already recorded",
+			Type:        &graphql.NonNull{Of: history},
+			Args: append(voteArgs, &graphql.Argument{Name: "vote", Type: &graphql.NonNull{Of: historyInput}},
+				&graphql.Argument{Name: "override", Type: graphql.Boolean}),
+			Resolve: func(p graphql.Params) (any, error) {
+				path := votePath(p)
+				if override, _ := p.Args["override"].(bool); override {
+					path += "?override=true"
+				}
+				return td.dispatch(p, fiber.MethodPost, path, p.Args["vote"])
+			},
+		},
+		{
+			Name:        "updateVote",
+			Description: "Replaces the vote of a voter in a poll",
+			Type:        &graphql.NonNull{Of: history},
+			Args:        append(voteArgs, &graphql.Argument{Name: "vote", Type: &graphql.NonNull{Of: historyInput}}),
+			Resolve: func(p graphql.Params) (any, error) {
+				return td.dispatch(p, fiber.MethodPut, votePath(p), p.Args["vote"])
+			},
+		},
+		{
+			Name:        "deleteVote",
+			Description: "Deletes the vote of a voter in a poll",
+			Type:        &graphql.NonNull{Of: graphql.Boolean},
+			Args:        voteArgs,
+			Resolve: func(p graphql.Params) (any, error) {
+				if _, err := td.dispatch(p, fiber.MethodDelete, votePath(p), nil); err != nil {
+					return nil, err
+				}
+				return true, nil
+			},
+		},
+	}}
+
+	return &graphql.Schema{Query: query, Mutation: mutation}
+}
+
+// dispatch runs a request through the REST routes for a resolver, with
+// the headers of the GraphQL request, and returns its decoded JSON
+// answer.  An error answer is returned as a GraphQL error carrying the
+// error code and status.
+func (td *VoterAPI) dispatch(p graphql.Params, method, path string, body any) (any, error) {
+	c := p.Context.Value(graphqlCtxKey{}).(*fiber.Ctx)
+
+	var req fasthttp.Request
+	c.Request().Header.CopyTo(&req.Header)
+	for _, header := range []string{fiber.HeaderContentType, fiber.HeaderContentLength, fiber.HeaderAcceptEncoding,
+		fiber.HeaderIfMatch, fiber.HeaderIfNoneMatch} {
+		req.Header.Del(header)
+	}
+	req.Header.SetMethod(method)
+	req.Header.Set(fiber.HeaderXRequestID, requestID(c))
+	req.SetRequestURI(path)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.SetContentType(fiber.MIMEApplicationJSON)
+		req.SetBody(data)
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, c.Context().RemoteAddr(), nil)
+	td.routes()(&ctx)
+
+	status := ctx.Response.StatusCode()
+	if status >= http.StatusBadRequest {
+		var rsp client.Error
+		if err := json.Unmarshal(ctx.Response.Body(), &rsp); err != nil || rsp.Code == "" {
+			rsp.Code, rsp.Message = client.CodeInternal, string(ctx.Response.Body())
+		}
+		extensions := map[string]any{"code": rsp.Code, "status": status}
+		if len(rsp.Fields) > 0 {
+			extensions["fields"] = rsp.Fields
+		}
+		return nil, &graphql.Error{Message: rsp.Message, Extensions: extensions}
+	}
+
+	if !strings.HasPrefix(string(ctx.Response.Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return nil, nil
+	}
+	var result any
+	if err := json.Unmarshal(ctx.Response.Body(), &result); err != nil {
+		log.Println("Error parsing response for GraphQL: ", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// orNull answers a lookup that found nothing with null instead of an error
+func orNull(value any, err error) (any, error) {
+	if coded, ok := err.(*graphql.Error); ok && coded.Extensions["status"] == http.StatusNotFound {
+		return nil, nil
+	}
+	return value, err
+}
+
+// runGraphQL executes a request and sends the response, a request that
+// could not be run at all, e.g. one with a syntax error, is a 400
+func (td *VoterAPI) runGraphQL(c *fiber.Ctx, req graphql.Request) error {
+	ctx := context.WithValue(c.UserContext(), graphqlCtxKey{}, c)
+	rsp := td.graphql.Execute(ctx, req)
+	if rsp.Rejected() {
+		return c.Status(http.StatusBadRequest).JSON(rsp)
+	}
+	return c.JSON(rsp)
+}
+
+// implementation for POST /graphql
+// runs a GraphQL query or mutation, the body is {"query": ...,
+// "operationName": ..., "variables": {...}}.  The schema is at GET
+// /graphql/schema.
+func (td *VoterAPI) PostGraphQL(c *fiber.Ctx) error {
+	var req graphql.Request
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Query == "" {
+		return fiber.NewError(http.StatusBadRequest, "the body needs a query")
+	}
+
+	return td.runGraphQL(c, req)
+}
+
+// implementation for GET /graphql
+// runs a GraphQL query given in ?query=, with ?operationName= and
+// ?variables= as JSON.  Mutations have to be POSTed.
+func (td *VoterAPI) GetGraphQL(c *fiber.Ctx) error {
+	req := graphql.Request{Query: c.Query("query"), OperationName: c.Query("operationName")}
+	if req.Query == "" {
+		return fiber.NewError(http.StatusBadRequest, "query is required")
+	}
+	if variables := c.Query("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+			return fiber.NewError(http.StatusBadRequest, "variables must be a JSON object")
+		}
+	}
+	if kind, err := graphql.Operation(req); err == nil && kind == "mutation" {
+		c.Set(fiber.HeaderAllow, fiber.MethodPost)
+		return fiber.NewError(http.StatusMethodNotAllowed, "mutations have to be POSTed")
+	}
+
+	return td.runGraphQL(c, req)
+}
+
+// implementation for GET /graphql/schema
+// returns the schema of /graphql in the schema definition language
+func (td *VoterAPI) GetGraphQLSchema(c *fiber.Ctx) error {
+	return c.SendString(td.graphql.SDL())
+}
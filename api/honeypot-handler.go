@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/siem"
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkTripwires raises an alert when a decoded JSON response contains
+// any decoy voter
+func (td *VoterAPI) checkTripwires(c *fiber.Ctx, caller principal, body any) {
+	var ids []int
+	walkVoters(body, func(voter map[string]any) {
+		if id, ok := voter["VoterId"].(float64); ok {
+			ids = append(ids, int(id))
+		}
+	})
+
+	td.tripwire(c, caller, ids)
+}
+
+// tripwire raises an alert if any of the voters that were shown to the
+// caller is a decoy.  The alert goes to the log, the tripwire log and,
+// when it is configured, the SIEM.
+func (td *VoterAPI) tripwire(c *fiber.Ctx, caller principal, ids []int) {
+	var decoys []int
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if td.db.IsDecoy(id) && !seen[id] {
+			seen[id] = true
+			decoys = append(decoys, id)
+		}
+	}
+	if len(decoys) == 0 {
+		return
+	}
+
+	//Fiber reuses the request buffers, so copy what outlives the request
+	alert := td.tripwires.Record(db.TripwireAlert{
+		Time:      time.Now(),
+		DecoyIds:  decoys,
+		Principal: caller.Name,
+		Role:      caller.Role,
+		SourceIP:  strings.Clone(c.IP()),
+		UserAgent: strings.Clone(c.Get(fiber.HeaderUserAgent)),
+		Method:    strings.Clone(c.Method()),
+		URL:       strings.Clone(c.OriginalURL()),
+	})
+	log.Printf("TRIPWIRE: %s (%s) from %s read decoy voters %v with %s %s",
+		alert.Principal, alert.Role, alert.SourceIP, alert.DecoyIds, alert.Method, alert.URL)
+	td.reviews.Flag(db.ReviewAnomaly, 0,
+		fmt.Sprintf("%s from %s read decoy voters %v", alert.Principal, alert.SourceIP, alert.DecoyIds), alert)
+
+	if td.siem != nil {
+		for _, id := range decoys {
+			td.siem.Export(siem.Event{
+				Time:      alert.Time,
+				Kind:      siem.KindTripwire,
+				Action:    "decoy record read",
+				Outcome:   "success",
+				Principal: alert.Principal,
+				SourceIP:  alert.SourceIP,
+				Method:    alert.Method,
+				Path:      alert.URL,
+				Status:    c.Response().StatusCode(),
+				VoterId:   id,
+			})
+		}
+	}
+}
+
+// implementation for POST /admin/decoys
+// seeds honeypot voters, the body is a list of ordinary voter records.
+// They should look like the real roll, nothing in any response gives them
+// away.
+func (td *VoterAPI) PostDecoys(c *fiber.Ctx) error {
+	var voters []db.Voter
+	if err := c.BodyParser(&voters); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	//Decoys are kept in the list only, not in the voter store, which
+	//would load them as real voters on the next restart
+	defer td.voterLocks.lockAll()()
+	ids, err := td.db.SeedDecoys(voters)
+	if err != nil {
+		log.Println("Error seeding decoys: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(fiber.Map{"decoys": ids})
+}
+
+// implementation for GET /admin/decoys
+// returns the ids of the decoy voters, never the records themselves
+func (td *VoterAPI) ListDecoys(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"decoys": td.db.DecoyIds()})
+}
+
+// implementation for GET /admin/decoys/alerts
+// returns every tripwire alert, oldest first
+func (td *VoterAPI) GetTripwireAlerts(c *fiber.Ctx) error {
+	return c.JSON(td.tripwires.GetAlerts())
+}
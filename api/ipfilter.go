@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// IPRules is the network filter configuration file.  Allow maps a route
+// group (a path prefix) to the networks it may be reached from, Deny seeds
+// the denylist, for example
+//
+//	{
+//	  "Allow": {"/admin": ["10.20.0.0/16"], "/kiosk": ["10.30.0.0/16"]},
+//	  "Deny":  ["203.0.113.0/24"]
+//	}
+//
+// Route groups that are not listed can be reached from anywhere.  The
+// denylist can be changed at runtime under /admin/denylist.
+type IPRules struct {
+	Allow map[string][]string
+	Deny  []string
+}
+
+// allowRule is the parsed allowlist of one route group
+type allowRule struct {
+	group    string
+	prefixes []netip.Prefix
+}
+
+// LoadIPRules reads the network filter configuration from a JSON file
+func LoadIPRules(path string) (*IPRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules IPRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// EnableIPFilter turns on the per route group allowlists and seeds the
+// denylist.  The denylist is always enforced, this only adds to it.
+func (td *VoterAPI) EnableIPFilter(rules *IPRules) error {
+	allow := make([]allowRule, 0, len(rules.Allow))
+	for group, cidrs := range rules.Allow {
+		rule := allowRule{group: strings.TrimSuffix(group, "/")}
+		for _, cidr := range cidrs {
+			prefix, err := db.ParsePrefix(cidr)
+			if err != nil {
+				return err
+			}
+			rule.prefixes = append(rule.prefixes, prefix)
+		}
+		allow = append(allow, rule)
+	}
+
+	//Longest group first, so /admin/devices wins over /admin
+	sort.Slice(allow, func(i, j int) bool {
+		return len(allow[i].group) > len(allow[j].group)
+	})
+
+	for _, cidr := range rules.Deny {
+		if _, err := td.denyList.Add(db.DenyEntry{CIDR: cidr, Reason: "config"}); err != nil {
+			return err
+		}
+	}
+
+	td.allowRules = allow
+	td.setFeature("ip_allowlist", len(allow))
+	return nil
+}
+
+// allowedFrom reports whether the route group of a path may be reached
+// from an address
+func (td *VoterAPI) allowedFrom(path string, addr netip.Addr) bool {
+	for _, rule := range td.allowRules {
+		if path != rule.group && !strings.HasPrefix(path, rule.group+"/") {
+			continue
+		}
+		for _, prefix := range rule.prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// IPFilter is the middleware that refuses requests from denylisted
+// networks and from networks outside a route group's allowlist.  It runs
+// ahead of authentication so blocked callers never get as far as trying a
+// credential.
+func (td *VoterAPI) IPFilter(c *fiber.Ctx) error {
+	addr, err := netip.ParseAddr(c.IP())
+	if err != nil {
+		log.Println("Error parsing remote address: ", err)
+		return apiError(http.StatusForbidden, client.CodeNetworkBlocked, "")
+	}
+	addr = addr.Unmap()
+
+	if entry, ok := td.denyList.Match(addr); ok {
+		c.Locals("blocked", entry.CIDR)
+		return apiError(http.StatusForbidden, client.CodeNetworkBlocked, "")
+	}
+	if !td.allowedFrom(c.Path(), addr) {
+		c.Locals("blocked", "allowlist")
+		return apiError(http.StatusForbidden, client.CodeNetworkBlocked, "")
+	}
+
+	return c.Next()
+}
+
+// implementation for GET /admin/denylist
+func (td *VoterAPI) GetDenyList(c *fiber.Ctx) error {
+	return c.JSON(td.denyList.GetEntries())
+}
+
+// implementation for POST /admin/denylist
+// blocks a network, for example
+// {"CIDR": "203.0.113.0/24", "Reason": "credential stuffing", "TTL": "24h"}.
+// Without a TTL the entry stays until it is deleted.  An entry that would
+// block the caller is refused, so an admin can not lock themselves out.
+func (td *VoterAPI) PostDenyEntry(c *fiber.Ctx) error {
+	var req struct {
+		CIDR   string
+		Reason string
+		TTL    string
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	entry := db.DenyEntry{CIDR: req.CIDR, Reason: req.Reason}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return fiber.NewError(http.StatusBadRequest, "TTL must be a positive duration such as 24h")
+		}
+		entry.Expires = time.Now().Add(ttl)
+	}
+
+	prefix, err := db.ParsePrefix(req.CIDR)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if addr, err := netip.ParseAddr(c.IP()); err == nil && prefix.Contains(addr.Unmap()) {
+		return fiber.NewError(http.StatusBadRequest, "this entry would block your own address")
+	}
+
+	entry, err = td.denyList.Add(entry)
+	if err != nil {
+		log.Println("Error adding deny entry: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(entry)
+}
+
+// implementation for DELETE /admin/denylist/:id
+func (td *VoterAPI) DeleteDenyEntry(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := td.denyList.Remove(id); err != nil {
+		return apiError(http.StatusNotFound, client.CodeNotFound, err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// jurisdictionView is a jurisdiction along with where it sits in the tree
+type jurisdictionView struct {
+	db.Jurisdiction
+	Path     []db.Jurisdiction //From the state down to the jurisdiction itself
+	Children []db.Jurisdiction
+}
+
+// implementation for GET /jurisdictions
+func (td *VoterAPI) ListJurisdictions(c *fiber.Ctx) error {
+	return c.JSON(td.jurisdictions.GetAllJurisdictions())
+}
+
+// implementation for GET /jurisdictions/:id
+func (td *VoterAPI) GetJurisdiction(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	path, err := td.jurisdictions.GetPath(id)
+	if err != nil {
+		log.Println("Jurisdiction not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJurisdictionNotFound, "jurisdiction not found")
+	}
+
+	return c.JSON(jurisdictionView{
+		Jurisdiction: path[len(path)-1],
+		Path:         path,
+		Children:     td.jurisdictions.GetChildren(id),
+	})
+}
+
+// implementation for POST /jurisdictions
+func (td *VoterAPI) PostJurisdiction(c *fiber.Ctx) error {
+	var node db.Jurisdiction
+	if err := c.BodyParser(&node); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := td.jurisdictions.AddJurisdiction(node); err != nil {
+		log.Println("Error adding jurisdiction: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(node)
+}
+
+// implementation for PUT /jurisdictions/:id
+func (td *VoterAPI) UpdateJurisdiction(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var node db.Jurisdiction
+	if err := c.BodyParser(&node); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	node.JurisdictionId = id
+
+	if _, err := td.jurisdictions.GetJurisdiction(id); err != nil {
+		log.Println("Jurisdiction not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJurisdictionNotFound, "jurisdiction not found")
+	}
+
+	if err := td.jurisdictions.UpdateJurisdiction(node); err != nil {
+		log.Println("Error updating jurisdiction: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(node)
+}
+
+// implementation for DELETE /jurisdictions/:id
+func (td *VoterAPI) DeleteJurisdiction(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.jurisdictions.GetJurisdiction(id); err != nil {
+		log.Println("Jurisdiction not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJurisdictionNotFound, "jurisdiction not found")
+	}
+
+	if err := td.jurisdictions.DeleteJurisdiction(id); err != nil {
+		log.Println("Error deleting jurisdiction: ", err)
+		return fiber.NewError(http.StatusConflict, err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for GET /jurisdictions/:id/polls
+// returns the ids of the polls on the ballot in a jurisdiction
+func (td *VoterAPI) GetJurisdictionPolls(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	polls, err := td.jurisdictions.GetPolls(id)
+	if err != nil {
+		log.Println("Jurisdiction not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJurisdictionNotFound, "jurisdiction not found")
+	}
+
+	return c.JSON(polls)
+}
+
+// implementation for GET /jurisdictions/:id/stats
+// rolls up voter counts and turnout over every precinct in a
+// jurisdiction, pass ?poll=n for the turnout of a single poll
+func (td *VoterAPI) GetJurisdictionStats(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		log.Println("Error getting voters: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	stats, err := td.jurisdictions.Stats(id, voters, c.QueryInt("poll", 0))
+	if err != nil {
+		log.Println("Jurisdiction not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJurisdictionNotFound, "jurisdiction not found")
+	}
+
+	return c.JSON(stats)
+}
+
+// jurisdictionScope returns the precincts an API key is limited to, nil
+// when the key is not limited to any jurisdiction
+func (td *VoterAPI) jurisdictionScope(key db.APIKey) map[int]bool {
+	if len(key.Jurisdictions) == 0 {
+		return nil
+	}
+	return td.jurisdictions.Precincts(key.Jurisdictions...)
+}
+
+// checkScope refuses the requests of a caller limited to some
+// jurisdictions, or by a scoped token to some voters, that reach outside
+// them.  Voters outside the scope look like they do not exist, voters can
+// not be created in or moved to a precinct outside it.  The admin routes,
+// which act on the whole roll, and changes to the jurisdiction tree, which
+// could widen the scope, are off limits.
+func (td *VoterAPI) checkScope(c *fiber.Ctx, caller principal) error {
+	path := routePath(c)
+	method := c.Method()
+
+	if isAdmin(c) ||
+		strings.HasPrefix(path, "/jurisdictions") && method != fiber.MethodGet ||
+		path == "/voters" && method == fiber.MethodDelete {
+		return apiError(http.StatusForbidden, client.CodeForbidden, "not allowed for a key limited to jurisdictions")
+	}
+
+	if m := voterPath.FindStringSubmatch(path); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		if caller.voters != nil && !caller.voters[id] {
+			return apiError(http.StatusNotFound, client.CodeVoterNotFound, "voter not found")
+		}
+		if voter, err := td.db.GetVoter(id); err == nil && caller.scope != nil && !caller.scope[voter.PrecinctId] {
+			return apiError(http.StatusNotFound, client.CodeVoterNotFound, "voter not found")
+		}
+	}
+	if caller.voters != nil && path == "/voters" && method == fiber.MethodPost {
+		return apiError(http.StatusForbidden, client.CodeForbidden, "the token is limited to existing voters")
+	}
+	if caller.scope == nil {
+		return nil
+	}
+
+	//The precinct of a new voter, or the one a voter is moved to, must be
+	//in scope.  A plain voter update replaces the whole voter.
+	createOrReplace := path == "/voters" && method == fiber.MethodPost ||
+		voterPath.FindString(path) == path && method == fiber.MethodPut
+	if createOrReplace {
+		var body struct {
+			PrecinctId int
+		}
+		if err := json.Unmarshal(c.Body(), &body); err == nil && !caller.scope[body.PrecinctId] {
+			return apiError(http.StatusForbidden, client.CodeForbidden, "precinct is outside your jurisdictions")
+		}
+	}
+
+	//A merge patch only moves the voter when it names a precinct
+	if voterPath.FindString(path) == path && method == fiber.MethodPatch {
+		var body struct {
+			PrecinctId *int
+		}
+		if err := json.Unmarshal(c.Body(), &body); err == nil && body.PrecinctId != nil && !caller.scope[*body.PrecinctId] {
+			return apiError(http.StatusForbidden, client.CodeForbidden, "precinct is outside your jurisdictions")
+		}
+	}
+
+	return nil
+}
+
+// scopeVoters drops every voter outside a caller's scope from the lists of
+// a decoded JSON response
+func (td *VoterAPI) scopeVoters(value any, caller principal) any {
+	inScope := func(item any) bool {
+		voter, ok := item.(map[string]any)
+		if !ok {
+			return true
+		}
+		id, isVoter := voter["VoterId"].(float64)
+		if !isVoter {
+			return true
+		}
+		if caller.voters != nil && !caller.voters[int(id)] {
+			return false
+		}
+		if caller.scope == nil {
+			return true
+		}
+		stored, err := td.db.GetVoter(int(id))
+		return err == nil && caller.scope[stored.PrecinctId]
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			v[key] = td.scopeVoters(field, caller)
+		}
+	case []any:
+		kept := make([]any, 0, len(v))
+		for _, item := range v {
+			if inScope(item) {
+				kept = append(kept, td.scopeVoters(item, caller))
+			}
+		}
+		return kept
+	}
+
+	return value
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// legalHoldRequest is the body of the legal hold endpoints.  Filter is only
+// used by POST /admin/legal-holds.
+type legalHoldRequest struct {
+	Hold   bool
+	Reason string
+	Filter db.VoterFilter
+}
+
+// holdAction returns the audit action for placing or lifting a hold
+func holdAction(hold bool) string {
+	if hold {
+		return "legal_hold.placed"
+	}
+	return "legal_hold.lifted"
+}
+
+// implementation for PUT /voters/:id/legal-hold
+// places or lifts a legal hold on a single voter
+func (td *VoterAPI) SetLegalHold(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req legalHoldRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Hold && req.Reason == "" {
+		return fiber.NewError(http.StatusBadRequest, "a reason is required to place a legal hold")
+	}
+
+	voter, err := td.db.SetLegalHold(id, req.Hold, req.Reason)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if err := td.persist(c.UserContext(), id); err != nil {
+		return err
+	}
+	td.audit.Record(requestID(c), holdAction(req.Hold), id, req.Reason)
+
+	return c.JSON(voter)
+}
+
+// implementation for POST /admin/legal-holds
+// places or lifts a legal hold on every voter matching the filter
+func (td *VoterAPI) SetLegalHoldByFilter(c *fiber.Ctx) error {
+	var req legalHoldRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Hold && req.Reason == "" {
+		return fiber.NewError(http.StatusBadRequest, "a reason is required to place a legal hold")
+	}
+
+	defer td.voterLocks.lockAll()()
+	ids, err := td.db.SetLegalHoldByFilter(req.Filter, req.Hold, req.Reason)
+	if err != nil {
+		log.Println("Error setting legal holds: ", err)
+		return storeError(err)
+	}
+	if err := td.persist(c.UserContext(), ids...); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		td.audit.Record(requestID(c), holdAction(req.Hold), id, fmt.Sprintf("%s (by filter)", req.Reason))
+	}
+
+	return c.JSON(fiber.Map{
+		"hold":      req.Hold,
+		"voter_ids": ids,
+	})
+}
+
+// implementation for GET /admin/audit
+// returns the audit log oldest first, ?voter=n limits it to one voter.  It
+// is paged like GET /voters when ?limit= is given.
+func (td *VoterAPI) GetAuditLog(c *fiber.Ctx) error {
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	entries := td.audit.GetEntries(c.QueryInt("voter", 0))
+	setPageHeaders(c, offset, limit, len(entries))
+	return c.JSON(pageOf(entries, offset, limit))
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Purposes a voter link token is issued for, a token for one does not
+// open the other
+const (
+	linkOptOut = "opt-out"
+	linkSurvey = "survey"
+)
+
+// voterLinks are the links a voter is sent, each signed for that voter
+type voterLinks struct {
+	OptOut string `json:",omitempty"`
+	Survey string `json:",omitempty"`
+}
+
+// newLinkKey returns a random key for link tokens, links signed with it
+// stop working when the server restarts unless SetLinkSecret is used
+func newLinkKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Println("Error creating link key: ", err)
+	}
+	return key
+}
+
+// SetLinkSecret sets the key voter links are signed with, so links that
+// were sent out keep working across restarts and on every replica.  An
+// empty secret keeps the random key made at startup.
+func (td *VoterAPI) SetLinkSecret(secret string) {
+	if secret != "" {
+		td.linkKey = []byte(secret)
+	}
+}
+
+// linkToken signs a voter link, the HMAC of the purpose, the voter and
+// the campaign or poll the link was sent for
+func (td *VoterAPI) linkToken(purpose string, voterID, subjectID int) string {
+	mac := hmac.New(sha256.New, td.linkKey)
+	fmt.Fprintf(mac, "%s:%d:%d", purpose, voterID, subjectID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fromLink reports whether a request comes from a voter following a link,
+// an anonymous caller while access control is on
+func (td *VoterAPI) fromLink(c *fiber.Ctx) bool {
+	caller, _ := c.Locals("principal").(principal)
+	return td.access != nil && caller.Role == AnonymousRole
+}
+
+// checkLinkToken refuses anonymous callers whose ?token= is not the one
+// signed for the voter and the campaign or poll.  Callers with an API key,
+// a token or a session do not need one, neither does anyone when access
+// control is off.
+func (td *VoterAPI) checkLinkToken(c *fiber.Ctx, purpose string, voterID, subjectID int) error {
+	if !td.fromLink(c) {
+		return nil
+	}
+
+	want := td.linkToken(purpose, voterID, subjectID)
+	if !hmac.Equal([]byte(c.Query("token")), []byte(want)) {
+		return apiError(http.StatusForbidden, client.CodeForbidden, "this link is not valid")
+	}
+	return nil
+}
+
+// implementation for GET /admin/voters/:id/links?campaign=&poll=
+// returns the signed opt-out link of a campaign and survey link of a poll
+// for a voter, to put in the messages sent to them
+func (td *VoterAPI) GetVoterLinks(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.db.GetVoter(id); err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	var links voterLinks
+	if campaign := c.QueryInt("campaign", 0); campaign != 0 {
+		links.OptOut = fmt.Sprintf("/voters/%d/opt-out?campaign=%d&token=%s",
+			id, campaign, td.linkToken(linkOptOut, id, campaign))
+	}
+	if poll := c.QueryInt("poll", 0); poll != 0 {
+		links.Survey = fmt.Sprintf("/polls/%d/survey?token=%s", poll, td.linkToken(linkSurvey, id, poll))
+	}
+
+	return c.JSON(links)
+}
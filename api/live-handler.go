@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// liveBuffer is the number of changes a live client may fall behind
+// before it is disconnected
+const liveBuffer = 256
+
+// livePingInterval is how often live connections are pinged, so proxies
+// keep idle ones open and clients that vanished are noticed
+const livePingInterval = 30 * time.Second
+
+// sseRetry is how long an SSE client waits before it reconnects
+const sseRetry = 3 * time.Second
+
+// LiveEvent is a change pushed to the clients of /voters/ws and
+// /voters/events.  Voter holds
+// what the caller may see of the voter: all of it for voter.created and
+// voter.updated, the VoterId and the vote for vote events, and only the
+// VoterId for voter.deleted.
+type LiveEvent struct {
+	Seq     int64 //Counts the events of a WebSocket from 1, for SSE it is the Seq of the change
+	Event   string
+	Time    time.Time
+	VoterId int
+	PollId  int //Set for vote events
+	Voter   map[string]any
+}
+
+// implementation for GET /voters/ws
+// upgrades to a WebSocket that pushes a LiveEvent for every change to the
+// roll, ?events= narrows it to a comma separated list of events, e.g.
+// voter.created,vote.recorded.  The caller sees the voters and fields it
+// could read through GET /voters/:id, and every event that shows PII is
+// written to the access log.  A client that falls behind is closed with
+// 1013 and should reload what it shows before it reconnects.
+func (td *VoterAPI) WatchVoters(c *fiber.Ctx) error {
+	if !websocket.IsUpgrade(c.Context()) {
+		c.Set(fiber.HeaderUpgrade, "websocket")
+		return apiError(http.StatusUpgradeRequired, client.CodeInvalidRequest, "GET /voters/ws needs a WebSocket handshake")
+	}
+
+	events, err := liveEventFilter(c)
+	if err != nil {
+		return err
+	}
+
+	caller, _ := c.Locals("principal").(principal)
+	allowed := td.visibleFields(caller.Role)
+	method, path := strings.Clone(c.Method()), strings.Clone(c.Path())
+	version := apiVersion(c)
+
+	changes, stop := td.db.Watch(liveBuffer)
+	err = websocket.Upgrade(c.Context(), func(conn *websocket.Conn) {
+		defer stop()
+
+		//Messages from the client are not expected, reading is how a
+		//close from the client is noticed
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(livePingInterval)
+		defer ping.Stop()
+
+		var seq int64
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					conn.Close(websocket.CloseTryAgainLater, "fell behind the change feed")
+					return
+				}
+				if !events[db.AllEvents] && !events[change.Event] || !caller.sees(change.VoterId, change.Voter.PrecinctId) {
+					continue
+				}
+				seq++
+				event := td.liveEvent(change, seq, allowed)
+				data, err := encodeVersioned(version, event)
+				if err != nil {
+					log.Println("Error encoding live event: ", err)
+					return
+				}
+				td.recordAccess(caller, method, path, event.Voter)
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.Ping(); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+	if err != nil {
+		stop()
+		return apiError(http.StatusBadRequest, client.CodeInvalidRequest, err.Error())
+	}
+
+	return nil
+}
+
+// implementation for GET /voters/events
+// streams a LiveEvent for every change to the roll as Server-Sent Events,
+// for clients that can not use the WebSocket at /voters/ws.  The SSE id of
+// an event is its Seq, which counts every change to the roll, so a client
+// that reconnects with Last-Event-ID (or ?lastEventId=) gets the changes
+// it missed.  When those are no longer kept, or the id is from before a
+// restart, a reset event comes first and the client should reload what it
+// shows.  ?events= and what the caller sees work as for /voters/ws.
+func (td *VoterAPI) StreamVoterEvents(c *fiber.Ctx) error {
+	events, err := liveEventFilter(c)
+	if err != nil {
+		return err
+	}
+
+	after := int64(-1)
+	if lastID := c.Get("Last-Event-ID", c.Query("lastEventId")); lastID != "" {
+		after, err = strconv.ParseInt(lastID, 10, 64)
+		if err != nil || after < 0 {
+			return apiError(http.StatusBadRequest, client.CodeInvalidRequest, "Last-Event-ID has to be the id of an event")
+		}
+	}
+
+	caller, _ := c.Locals("principal").(principal)
+	allowed := td.visibleFields(caller.Role)
+	method, path := strings.Clone(c.Method()), strings.Clone(c.Path())
+	version := apiVersion(c)
+
+	missed, complete, changes, stop := td.db.WatchFrom(after, liveBuffer)
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set("X-Accel-Buffering", "no") //Keeps nginx from holding the stream back
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer stop()
+
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+		if !complete {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+
+		//Changes the client is not sent still move its Last-Event-ID on,
+		//with an event without data that it does not dispatch
+		var skipped, sent int64
+		send := func(change db.Change) error {
+			if !events[db.AllEvents] && !events[change.Event] || !caller.sees(change.VoterId, change.Voter.PrecinctId) {
+				skipped = change.Seq
+				return nil
+			}
+			event := td.liveEvent(change, change.Seq, allowed)
+			data, err := encodeVersioned(version, event)
+			if err != nil {
+				log.Println("Error encoding live event: ", err)
+				return err
+			}
+			td.recordAccess(caller, method, path, event.Voter)
+			sent = change.Seq
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Event, data)
+			return w.Flush()
+		}
+
+		for _, change := range missed {
+			if err := send(change); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		ping := time.NewTicker(livePingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case change, ok := <-changes:
+				//A client that fell behind reconnects and resumes
+				if !ok {
+					return
+				}
+				if err := send(change); err != nil {
+					return
+				}
+			case <-ping.C:
+				if skipped > sent {
+					sent = skipped
+					fmt.Fprintf(w, "id: %d\n\n", skipped)
+				} else {
+					fmt.Fprint(w, ": ping\n\n")
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// liveEventFilter returns the events asked for with ?events=, a comma
+// separated list, or AllEvents when there is none
+func liveEventFilter(c *fiber.Ctx) (map[string]bool, error) {
+	list := c.Query("events")
+	if list == "" {
+		return map[string]bool{db.AllEvents: true}, nil
+	}
+
+	events := make(map[string]bool)
+	for _, event := range strings.Split(list, ",") {
+		if !db.ValidEvent(event) {
+			return nil, apiError(http.StatusBadRequest, client.CodeInvalidRequest, "unknown event "+event)
+		}
+		events[event] = true
+	}
+	return events, nil
+}
+
+// sees reports whether a voter is within the voters and the precincts of
+// the jurisdictions a caller is limited to
+func (p principal) sees(voterID, precinctID int) bool {
+	if p.voters != nil && !p.voters[voterID] {
+		return false
+	}
+	return p.scope == nil || p.scope[precinctID]
+}
+
+// liveEvent builds the event pushed for a change, with the voter fields
+// the caller may not see left out
+func (td *VoterAPI) liveEvent(change db.Change, seq int64, allowed map[string]bool) LiveEvent {
+	event := LiveEvent{
+		Seq:     seq,
+		Event:   change.Event,
+		Time:    change.Time,
+		VoterId: change.VoterId,
+		PollId:  change.PollId,
+		Voter:   map[string]any{"VoterId": change.VoterId},
+	}
+
+	var shown any
+	switch change.Event {
+	case db.EventVoterCreated, db.EventVoterUpdated:
+		shown = change.Voter
+	case db.EventVoteRecorded, db.EventVoteUpdated, db.EventVoteDeleted:
+		shown = map[string]any{"VoterId": change.VoterId, "VoteHistory": []db.VoterHistory{change.Vote}}
+	default:
+		return event
+	}
+
+	//Decoded the way a response is, so the same field filter applies
+	data, err := json.Marshal(shown)
+	if err == nil {
+		err = json.Unmarshal(data, &event.Voter)
+	}
+	if err != nil {
+		log.Println("Error encoding live event: ", err)
+		return event
+	}
+	if allowed != nil {
+		filterVoterFields(event.Voter, allowed)
+	}
+
+	return event
+}
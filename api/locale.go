@@ -0,0 +1,65 @@
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// wantedLocales returns the locales a request asks for, in order of
+// preference.  The language preference of the voter named by ?voter=
+// comes first, then the Accept-Language header.
+func (td *VoterAPI) wantedLocales(c *fiber.Ctx) []string {
+	var wanted []string
+	if id := c.QueryInt("voter", 0); id != 0 {
+		if voter, err := td.db.GetVoter(id); err == nil && voter.Preferences.Language != "" {
+			wanted = append(wanted, voter.Preferences.Language)
+		}
+	}
+
+	return append(wanted, acceptLanguages(c.Get(fiber.HeaderAcceptLanguage))...)
+}
+
+// acceptLanguages parses an Accept-Language header (RFC 9110) into its
+// language tags, highest quality first.  The wildcard and tags refused
+// with q=0 are left out.
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].quality > tags[j].quality
+	})
+
+	languages := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		languages = append(languages, tag.tag)
+	}
+	return languages
+}
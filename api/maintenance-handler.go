@@ -0,0 +1,134 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Maintenance modes
+const (
+	ModeOff         = "off"
+	ModeReadOnly    = "read-only"   //Reads are served, writes get a 503
+	ModeMaintenance = "maintenance" //Everything but admin routes gets a 503
+)
+
+// defaultRetryAfter is the Retry-After used when none is configured
+const defaultRetryAfter = 60
+
+// maintenanceState is the current maintenance mode of the service
+type maintenanceState struct {
+	mu         sync.Mutex
+	Mode       string
+	Reason     string
+	RetryAfter int //Seconds clients should wait before retrying
+	Since      time.Time
+}
+
+// get returns a copy of the state
+func (m *maintenanceState) get() maintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return maintenanceState{
+		Mode:       m.Mode,
+		Reason:     m.Reason,
+		RetryAfter: m.RetryAfter,
+		Since:      m.Since,
+	}
+}
+
+// Maintenance is the middleware that turns requests away with a 503 and a
+// Retry-After while the service is in maintenance or read-only mode.  The
+// admin routes stay available so the mode can be turned off again, and so
+// do the health check and /about.
+func (td *VoterAPI) Maintenance(c *fiber.Ctx) error {
+	state := td.maintenance.get()
+	if state.Mode == ModeOff || state.Mode == "" {
+		return c.Next()
+	}
+
+	path := c.Path()
+	if strings.HasPrefix(path, "/admin/") || path == "/voters/health" || path == "/about" {
+		return c.Next()
+	}
+
+	code := client.CodeMaintenance
+	if state.Mode == ModeReadOnly {
+		if isRead(c) {
+			return c.Next()
+		}
+		code = client.CodeReadOnly
+	}
+
+	message := state.Reason
+	if message == "" {
+		message = "service is in " + state.Mode + " mode"
+	}
+
+	return &client.Error{
+		Status:     http.StatusServiceUnavailable,
+		Code:       code,
+		Message:    message,
+		RetryAfter: state.RetryAfter,
+	}
+}
+
+// implementation for GET /admin/maintenance
+// returns the current maintenance mode
+func (td *VoterAPI) GetMaintenance(c *fiber.Ctx) error {
+	state := td.maintenance.get()
+	if state.Mode == "" {
+		state.Mode = ModeOff
+	}
+
+	return c.JSON(fiber.Map{
+		"mode":        state.Mode,
+		"reason":      state.Reason,
+		"retry_after": state.RetryAfter,
+		"since":       state.Since,
+	})
+}
+
+// implementation for PUT /admin/maintenance
+// sets the maintenance mode, for example
+// {"Mode": "read-only", "Reason": "roll import", "RetryAfter": 300}
+func (td *VoterAPI) SetMaintenance(c *fiber.Ctx) error {
+	var req struct {
+		Mode       string
+		Reason     string
+		RetryAfter int
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	switch req.Mode {
+	case ModeOff, ModeReadOnly, ModeMaintenance:
+	default:
+		return fiber.NewError(http.StatusBadRequest, "Mode must be off, read-only or maintenance")
+	}
+	if req.RetryAfter < 0 {
+		return fiber.NewError(http.StatusBadRequest, "RetryAfter can not be negative")
+	}
+	if req.RetryAfter == 0 {
+		req.RetryAfter = defaultRetryAfter
+	}
+
+	td.maintenance.mu.Lock()
+	td.maintenance.Mode = req.Mode
+	td.maintenance.Reason = req.Reason
+	td.maintenance.RetryAfter = req.RetryAfter
+	td.maintenance.Since = time.Now()
+	td.maintenance.mu.Unlock()
+
+	log.Println("Maintenance mode set to ", req.Mode)
+
+	return td.GetMaintenance(c)
+}
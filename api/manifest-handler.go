@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for GET /precincts/:id/manifest
+// returns the ETag of every voter in a precinct, keyed by VoterId, so a
+// device can fetch only the records that changed since its last sync.  The manifest
+// itself carries an ETag, a device that sends it back in If-None-Match
+// gets a 304 when nothing in the precinct has changed.
+func (td *VoterAPI) GetPrecinctManifest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	etags, etag := td.db.PrecinctManifest(id)
+	c.Set(fiber.HeaderETag, etag)
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(http.StatusNotModified)
+	}
+
+	return c.JSON(fiber.Map{
+		"precinct": id,
+		"count":    len(etags),
+		"voters":   etags,
+	})
+}
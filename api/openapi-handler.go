@@ -0,0 +1,368 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// docRoute is a route described in the OpenAPI document.  Path parameters
+// are taken from the path, they are all integer ids.
+type docRoute struct {
+	method  string
+	path    string //OpenAPI form, /voters/{id}
+	id      string //operationId, what generated clients name the call
+	tag     string
+	summary string
+	query   []docParam
+	body    any            //Zero value of the request body, nil for none
+	schema  map[string]any //Request body schema when it is not a Go type
+	result  any            //Zero value of the response body, a string for plain text
+	success []int          //Statuses of a success, 200 when not set
+	errors  []int          //Statuses of the errors the route answers with
+}
+
+// docParam is a query parameter, kind is its JSON schema type
+type docParam struct {
+	name        string
+	kind        string
+	format      string
+	description string
+}
+
+// pageQuery are the paging parameters of every list, see pageParams
+var pageQuery = []docParam{
+	{"limit", "integer", "", "Items per page, at most 1000, every item from the offset on when not given"},
+	{"offset", "integer", "", "Items to skip"},
+}
+
+// voterQuery are the filters and sort order of GET /voters, see voterFilter
+var voterQuery = append([]docParam{
+	{"name", "string", "", "Voters whose name contains this text"},
+	{"email", "string", "email", "The voter with this email address"},
+	{"precinct", "integer", "", "Only voters assigned to this precinct"},
+	{"status", "string", "", "Only voters with this registration status"},
+	{"tag", "string", "", "Only voters carrying this tag"},
+	{"segment", "string", "", "Start from a saved segment, the other filters narrow it further"},
+	{"moved_since", "string", "date", "Only voters that moved on or after this date"},
+	{"registered_after", "string", "date", "Only voters registered on or after this date"},
+	{"registered_before", "string", "date", "Only voters registered before this date"},
+	{"sort", "string", "", "Order by " + db.SortVoterId + ", " + db.SortName + " or " + db.SortEmail},
+	{"order", "string", "", "asc or desc"},
+}, pageQuery...)
+
+// patchSchema is a JSON Patch (RFC 6902), the body of PATCH /voters/:id/polls
+var patchSchema = map[string]any{
+	"type": "array",
+	"items": map[string]any{
+		"type":     "object",
+		"required": []string{"op", "path"},
+		"properties": map[string]any{
+			"op":    map[string]any{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+			"path":  map[string]any{"type": "string"},
+			"from":  map[string]any{"type": "string"},
+			"value": map[string]any{},
+		},
+	},
+}
+
+// docRoutes are the voter and vote history routes, in the order of main.go
+var docRoutes = []docRoute{
+	{method: "get", path: "/voters", id: "listVoters", tag: "Voters", summary: "List voters, ordered by VoterId unless sort says otherwise",
+		query: voterQuery, result: []db.Voter{}, errors: []int{400}},
+	{method: "get", path: "/voters/search", id: "searchVoters", tag: "Voters", summary: "Find voters, at least one filter is required",
+		query: voterQuery, result: []db.Voter{}, errors: []int{400}},
+	{method: "get", path: "/voters/count", id: "countVoters", tag: "Voters", summary: "Count the registered voters, now or as of a point in the past",
+		query:  []docParam{{"as_of", "string", "date-time", "Count as of this time, a plain date means the end of that day"}},
+		result: voterCount{}, errors: []int{400}},
+	{method: "get", path: "/voters/{id}", id: "getVoter", tag: "Voters", summary: "Get a voter",
+		query:  []docParam{{"as_of", "string", "date-time", "The voter as it was at this time"}},
+		result: db.Voter{}, errors: []int{400, 404}},
+	{method: "post", path: "/voters", id: "createVoter", tag: "Voters", summary: "Add a voter, a voter without a VoterId is given the next free one and answered with a 201",
+		body: db.Voter{}, result: db.Voter{}, success: []int{200, 201}, errors: []int{400, 409}},
+	{method: "put", path: "/voters/{id}", id: "updateVoter", tag: "Voters", summary: "Replace a voter, or create it with a 201 when the server allows PUT creates",
+		body: db.Voter{}, result: db.Voter{}, success: []int{200, 201}, errors: []int{400, 404, 409}},
+	{method: "patch", path: "/voters/{id}", id: "patchVoter", tag: "Voters", summary: "Change the fields in the body, a JSON Merge Patch (RFC 7396)",
+		schema: map[string]any{"type": "object"}, result: db.Voter{}, errors: []int{400, 404, 409}},
+	{method: "delete", path: "/voters/{id}", id: "deleteVoter", tag: "Voters", summary: "Delete a voter, the voter as it was is returned",
+		result: db.Voter{}, errors: []int{404, 409}},
+	{method: "get", path: "/voters/{id}/polls", id: "listVotes", tag: "Vote history", summary: "List the vote history of a voter",
+		query: pageQuery, result: []db.VoterHistory{}, errors: []int{400, 404}},
+	{method: "patch", path: "/voters/{id}/polls", id: "patchVotes", tag: "Vote history", summary: "Edit the vote history with a JSON Patch (RFC 6902)",
+		schema: patchSchema, result: []db.VoterHistory{}, errors: []int{400, 404, 409, 429}},
+	{method: "post", path: "/voters/{id}/polls:sync", id: "syncVotes", tag: "Vote history", summary: "Reconcile the history an offline app collected with the stored one",
+		body: []db.VoterHistory{}, result: db.HistorySync{}, errors: []int{400, 404, 429}},
+	{method: "get", path: "/voters/{id}/polls/{pollid}", id: "getVote", tag: "Vote history", summary: "Get the vote of a voter in a poll",
+		result: db.VoterHistory{}, errors: []int{400, 404}},
+	{method: "post", path: "/voters/{id}/polls/{pollid}", id: "addVote", tag: "Vote history", summary: "Record the vote of a voter in a poll, a voter votes once per poll",
+		query: []docParam{{"override", "boolean", "", "Replace the vote the voter already has in the poll"}},
+		body:  db.VoterHistory{}, result: db.VoterHistory{}, errors: []int{400, 404, 409, 429}},
+	{method: "put", path: "/voters/{id}/polls/{pollid}", id: "updateVote", tag: "Vote history", summary: "Replace the vote of a voter in a poll",
+		body: db.VoterHistory{}, result: db.VoterHistory{}, errors: []int{400, 404, 409, 429}},
+	{method: "delete", path: "/voters/{id}/polls/{pollid}", id: "deleteVote", tag: "Vote history", summary: "Delete the vote of a voter in a poll",
+		result: "Delete OK", errors: []int{400, 404, 409, 429}},
+}
+
+// voterCount is the body of GET /voters/count
+type voterCount struct {
+	AsOf  time.Time `json:"as_of"`
+	Count int       `json:"count"`
+}
+
+// openAPIDocument is built once, the routes it describes do not change
+// while the server runs
+var openAPIDocument = sync.OnceValue(func() []byte {
+	doc, err := json.Marshal(buildOpenAPI(docRoutes))
+	if err != nil {
+		panic(err)
+	}
+	return doc
+})
+
+// implementation for GET /voters/openapi.json
+// returns the OpenAPI 3 document of the voter and vote history routes
+func (td *VoterAPI) GetOpenAPI(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(openAPIDocument())
+}
+
+// swaggerUI is the page of GET /voters/docs, the UI itself is loaded from
+// the swagger-ui-dist package on a CDN
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Voter API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "/voters/openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// implementation for GET /voters/docs
+// serves Swagger UI on the OpenAPI document, to read about the API and
+// try it from a browser
+func (td *VoterAPI) GetDocs(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(swaggerUI)
+}
+
+// pathParam matches the parameters of an OpenAPI path
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// buildOpenAPI returns the OpenAPI document of routes
+func buildOpenAPI(routes []docRoute) map[string]any {
+	schemas := schemaSet{}
+	paths := map[string]any{}
+
+	for _, route := range routes {
+		params := []any{}
+		for _, match := range pathParam.FindAllStringSubmatch(route.path, -1) {
+			params = append(params, map[string]any{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "integer"},
+			})
+		}
+		for _, param := range route.query {
+			schema := map[string]any{"type": param.kind}
+			if param.format != "" {
+				schema["format"] = param.format
+			}
+			params = append(params, map[string]any{
+				"name": param.name, "in": "query", "description": param.description, "schema": schema,
+			})
+		}
+
+		success := route.success
+		if len(success) == 0 {
+			success = []int{http.StatusOK}
+		}
+		responses := map[string]any{}
+		for _, code := range success {
+			if text, ok := route.result.(string); ok {
+				responses[strconv.Itoa(code)] = map[string]any{
+					"description": http.StatusText(code),
+					"content":     map[string]any{fiber.MIMETextPlain: map[string]any{"example": text}},
+				}
+				continue
+			}
+			responses[strconv.Itoa(code)] = jsonContent(http.StatusText(code), schemas.of(reflect.TypeOf(route.result)))
+		}
+		for _, code := range route.errors {
+			responses[strconv.Itoa(code)] = map[string]any{"$ref": "#/components/responses/" + strconv.Itoa(code)}
+		}
+
+		op := map[string]any{
+			"operationId": route.id,
+			"tags":        []string{route.tag},
+			"summary":     route.summary,
+			"parameters":  params,
+			"responses":   responses,
+		}
+		switch {
+		case route.schema != nil:
+			op["requestBody"] = jsonContent("", route.schema)
+		case route.body != nil:
+			op["requestBody"] = jsonContent("", schemas.of(reflect.TypeOf(route.body)))
+		}
+		if op["requestBody"] != nil {
+			op["requestBody"].(map[string]any)["required"] = true
+		}
+
+		item, ok := paths[route.path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[route.path] = item
+		}
+		item[route.method] = op
+	}
+
+	errorRef := schemas.of(reflect.TypeOf(client.Error{}))
+	responses := map[string]any{}
+	for _, code := range []int{400, 404, 409, 429} {
+		responses[strconv.Itoa(code)] = jsonContent(http.StatusText(code), errorRef)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Voter API",
+			"version": Version,
+		},
+		"tags": []any{
+			map[string]any{"name": "Voters", "description": "Registered voters"},
+			map[string]any{"name": "Vote history", "description": "The polls a voter voted in, one vote per poll"},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas":   schemas,
+			"responses": responses,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		//A key is only needed when access control is turned on
+		"security": []any{map[string]any{}, map[string]any{"apiKey": []string{}}},
+	}
+}
+
+// jsonContent is a request body or response with a JSON schema
+func jsonContent(description string, schema map[string]any) map[string]any {
+	content := map[string]any{
+		"content": map[string]any{
+			fiber.MIMEApplicationJSON: map[string]any{"schema": schema},
+		},
+	}
+	if description != "" {
+		content["description"] = description
+	}
+	return content
+}
+
+// schemaSet are the component schemas of the structs in the document, by
+// name
+type schemaSet map[string]any
+
+// timeType is described as a date-time string, the way it is marshalled
+var timeType = reflect.TypeOf(time.Time{})
+
+// of returns the schema of a Go type as encoding/json marshals it.  A
+// struct is added to the set and referred to by name.
+func (s schemaSet) of(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return s.of(t.Elem())
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		if _, ok := s[t.Name()]; !ok {
+			s[t.Name()] = map[string]any{} //Placeholder for types that refer to themselves
+			s[t.Name()] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	}
+	return map[string]any{}
+}
+
+// object returns the schema of a struct, with the rules of its validate
+// tags
+func (s schemaSet) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := s.of(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			key, arg, _ := strings.Cut(rule, "=")
+			bound, _ := strconv.Atoi(arg)
+			switch {
+			case key == "required":
+				required = append(required, name)
+				if schema["type"] == "string" {
+					schema["minLength"] = 1
+				}
+			case key == "email":
+				schema["format"] = "email"
+			case (key == "min" || key == "max") && schema["type"] != nil:
+				schema[boundKeyword(key, schema["type"].(string))] = bound
+			}
+		}
+		properties[name] = schema
+	}
+
+	object := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// boundKeyword is the JSON schema keyword of a min or max rule, which
+// bounds a number, the length of a string or the length of a list
+func boundKeyword(rule, kind string) string {
+	switch kind {
+	case "string":
+		return rule + "Length"
+	case "array":
+		return rule + "Items"
+	}
+	return rule + "imum"
+}
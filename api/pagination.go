@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPageSize is the largest ?limit= a list endpoint accepts
+const maxPageSize = 1000
+
+// pageParams reads ?limit= and ?offset= from the query string.  A limit of
+// 0 means no limit was asked for, every item from the offset on is
+// returned, which is what clients that predate paging expect.
+func pageParams(c *fiber.Ctx) (offset, limit int, err error) {
+	if offset, err = queryCount(c, "offset"); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = queryCount(c, "limit"); err != nil {
+		return 0, 0, err
+	}
+	if c.Query("limit") != "" && limit == 0 {
+		return 0, 0, errors.New("limit must be at least 1")
+	}
+	if limit > maxPageSize {
+		return 0, 0, fmt.Errorf("limit must be at most %d", maxPageSize)
+	}
+
+	return offset, limit, nil
+}
+
+// queryCount reads a query parameter that has to be a whole number, 0
+// when it is not set
+func queryCount(c *fiber.Ctx, name string) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a whole number", name)
+	}
+	return n, nil
+}
+
+// setPageHeaders reports the size of the whole list in X-Total-Count and,
+// when a limit was given, links the next and previous pages in a Link
+// header (RFC 8288)
+func setPageHeaders(c *fiber.Ctx, offset, limit, total int) {
+	c.Set("X-Total-Count", strconv.Itoa(total))
+	if limit == 0 {
+		return
+	}
+
+	links := make([]string, 0, 2)
+	if offset+limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(c, offset+limit, limit)))
+	}
+	if offset > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(c, max(offset-limit, 0), limit)))
+	}
+	if len(links) > 0 {
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+	}
+}
+
+// pageURL is the URL of the request with the paging parameters replaced,
+// the other query parameters are kept so filters carry over
+func pageURL(c *fiber.Ctx, offset, limit int) string {
+	query := url.Values{}
+	for key, value := range c.Queries() {
+		query.Set(key, value)
+	}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+
+	return c.Path() + "?" + query.Encode()
+}
+
+// pageOf returns the items of a list on the page asked for with
+// pageParams, a list that is not paged is returned as it is
+func pageOf[T any](items []T, offset, limit int) []T {
+	if offset == 0 && limit == 0 {
+		return items
+	}
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// scopeKey identifies the precincts and voters a caller is limited to,
+// empty for callers that see every voter
+func scopeKey(c *fiber.Ctx) string {
+	caller, ok := c.Locals("principal").(principal)
+	if !ok || caller.scope == nil && caller.voters == nil {
+		return ""
+	}
+
+	sorted := func(set map[int]bool) []int {
+		ids := make([]int, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+	if caller.voters == nil {
+		return fmt.Sprint(sorted(caller.scope))
+	}
+	return fmt.Sprint(sorted(caller.scope), sorted(caller.voters))
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPollBacklog is the number of writes that may wait on one poll before
+// further writes for that poll are turned away with a 503
+const maxPollBacklog = 500
+
+// pollBacklogRetryAfter is the Retry-After sent when a poll is backed up
+const pollBacklogRetryAfter = 1
+
+// PartitionStats are the backlog metrics of one poll partition
+type PartitionStats struct {
+	PollId     int
+	Backlog    int //Writes waiting or running right now
+	MaxBacklog int //Largest backlog seen
+	Processed  int64
+	Rejected   int64
+	Busy       time.Duration //Total time spent holding the partition
+}
+
+// pollPartition serializes the vote history writes of one poll
+type pollPartition struct {
+	write sync.Mutex
+	stats PartitionStats
+}
+
+// pollPartitions holds a partition per poll.  Votes for one poll only
+// queue behind other votes for the same poll, a flood on a big poll does
+// not hold up registrations or votes in other polls.
+type pollPartitions struct {
+	mu    sync.Mutex
+	polls map[int]*pollPartition
+}
+
+// constructor for pollPartitions struct
+func newPollPartitions() *pollPartitions {
+	return &pollPartitions{polls: make(map[int]*pollPartition)}
+}
+
+// enter joins the backlog of a poll's partition, it returns nil when the
+// backlog is full
+func (p *pollPartitions) enter(pollID int) *pollPartition {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	part, ok := p.polls[pollID]
+	if !ok {
+		part = &pollPartition{stats: PartitionStats{PollId: pollID}}
+		p.polls[pollID] = part
+	}
+
+	if part.stats.Backlog >= maxPollBacklog {
+		part.stats.Rejected++
+		return nil
+	}
+
+	part.stats.Backlog++
+	part.stats.MaxBacklog = max(part.stats.MaxBacklog, part.stats.Backlog)
+	return part
+}
+
+// leave takes a finished write off the backlog of its partition
+func (p *pollPartitions) leave(part *pollPartition, busy time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	part.stats.Backlog--
+	part.stats.Processed++
+	part.stats.Busy += busy
+}
+
+// stats returns the metrics of every partition ordered by poll id
+func (p *pollPartitions) stats() []PartitionStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]PartitionStats, 0, len(p.polls))
+	for _, part := range p.polls {
+		stats = append(stats, part.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].PollId < stats[j].PollId
+	})
+
+	return stats
+}
+
+// PollPartition is the middleware for vote history writes.  It runs the
+// rest of the request inside the partition of the poll in the :pollid
+// parameter, so writes to one poll are handled one at a time.
+func (td *VoterAPI) PollPartition(c *fiber.Ctx) error {
+	pollID, err := c.ParamsInt("pollid")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	part := td.partitions.enter(pollID)
+	if part == nil {
+		return &client.Error{
+			Status:     http.StatusServiceUnavailable,
+			Code:       client.CodePollBusy,
+			Message:    fmt.Sprintf("too many writes waiting on poll %d", pollID),
+			RetryAfter: pollBacklogRetryAfter,
+		}
+	}
+
+	part.write.Lock()
+	start := time.Now()
+	defer func() {
+		part.write.Unlock()
+		td.partitions.leave(part, time.Since(start))
+	}()
+
+	return c.Next()
+}
+
+// voterLocks serializes the writes to each voter.  Handlers read a voter,
+// change it and write it back, two of them changing the same voter at
+// once would lose one of the changes.  A write to one voter holds that
+// voter's lock, the writes that change voters all over the roll hold the
+// roll lock, which keeps out every write to a single voter.
+type voterLocks struct {
+	roll   sync.RWMutex
+	mu     sync.Mutex
+	voters map[int]*voterLock
+}
+
+// voterLock is the lock of one voter, it is dropped once nobody holds or
+// waits for it
+type voterLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock takes the lock of a voter and returns the function that releases it
+func (l *voterLocks) lock(id int) func() {
+	l.roll.RLock()
+
+	l.mu.Lock()
+	if l.voters == nil {
+		l.voters = make(map[int]*voterLock)
+	}
+	lock, ok := l.voters[id]
+	if !ok {
+		lock = &voterLock{}
+		l.voters[id] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(l.voters, id)
+		}
+		l.mu.Unlock()
+		l.roll.RUnlock()
+	}
+}
+
+// lockAll waits until no voter is being written and keeps every write out
+// until the returned function is called
+func (l *voterLocks) lockAll() func() {
+	l.roll.Lock()
+	return l.roll.Unlock
+}
+
+// SerializeVoter is the middleware for the writes to one voter.  It runs
+// the rest of the request holding the lock of the voter in the :id
+// parameter, so a voter's record is changed by one request at a time.
+// Vote writes take it after their poll partition.
+func (td *VoterAPI) SerializeVoter(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	defer td.voterLocks.lock(id)()
+	return c.Next()
+}
+
+// implementation for GET /admin/partitions
+// returns the backlog metrics of the per poll write partitions
+func (td *VoterAPI) GetPartitions(c *fiber.Ctx) error {
+	return c.JSON(td.partitions.stats())
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to a decoded JSON
+// document.  Members of an object patch replace the matching members of
+// the target, null removes them, and any other patch replaces the target
+// as a whole.
+func mergePatch(target, patch any) any {
+	members, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = make(map[string]any)
+	}
+	for key, value := range members {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = mergePatch(object[key], value)
+	}
+
+	return object
+}
+
+// mergeVoter applies a merge patch to a voter.  The voter goes through
+// its JSON form, so the patch names fields the way GET /voters/:id shows
+// them.
+func mergeVoter(voter db.Voter, patch any) (db.Voter, error) {
+	encoded, err := json.Marshal(voter)
+	if err != nil {
+		return db.Voter{}, err
+	}
+	var document any
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return db.Voter{}, err
+	}
+
+	encoded, err = json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return db.Voter{}, err
+	}
+	var patched db.Voter
+	if err := json.Unmarshal(encoded, &patched); err != nil {
+		return db.Voter{}, err
+	}
+
+	return patched, nil
+}
+
+// patchOperation is one operation of a JSON Patch (RFC 6902).  Value is
+// kept raw so an explicit null can be told apart from a missing value.
+type patchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value json.RawMessage
+}
+
+// validatePatch checks every operation of a JSON Patch before any of them
+// is applied, so a malformed patch is refused as a whole
+func validatePatch(ops []patchOperation) error {
+	if len(ops) == 0 {
+		return errors.New("patch has no operations")
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				err = errors.New("value is missing")
+			}
+		case "remove":
+		case "move", "copy":
+			_, err = parsePointer(op.From)
+			if err == nil && op.Op == "move" && op.Path != op.From && strings.HasPrefix(op.Path+"/", op.From+"/") {
+				err = errors.New("can not move a value into itself")
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err == nil {
+			_, err = parsePointer(op.Path)
+		}
+		if err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// errPatchTest is returned when a test operation of a patch fails
+var errPatchTest = errors.New("test failed")
+
+// applyPatch applies the operations of a validated JSON Patch to a decoded
+// JSON document in order.  The document is not changed, the patched copy
+// is returned, so a patch either applies in full or not at all.
+func applyPatch(document any, ops []patchOperation) (any, error) {
+	document = copyJSON(document)
+
+	for i, op := range ops {
+		path, _ := parsePointer(op.Path)
+
+		var value any
+		if op.Value != nil {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+
+		var err error
+		switch op.Op {
+		case "add":
+			document, err = pointerAdd(document, path, value)
+		case "remove":
+			document, err = pointerRemove(document, path)
+		case "replace":
+			if len(path) == 0 {
+				document = value
+				break
+			}
+			if document, err = pointerRemove(document, path); err == nil {
+				document, err = pointerAdd(document, path, value)
+			}
+		case "move", "copy":
+			from, _ := parsePointer(op.From)
+			if value, err = pointerGet(document, from); err != nil {
+				break
+			}
+			if op.Op == "move" {
+				if document, err = pointerRemove(document, from); err != nil {
+					break
+				}
+			}
+			document, err = pointerAdd(document, path, copyJSON(value))
+		case "test":
+			var found any
+			if found, err = pointerGet(document, path); err == nil && !reflect.DeepEqual(found, value) {
+				err = fmt.Errorf("%w, %s does not have the expected value", errPatchTest, op.Path)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return document, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens, the empty pointer is the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q does not start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = legacyName(strings.NewReplacer("~1", "/", "~0", "~").Replace(token))
+	}
+	return tokens, nil
+}
+
+// arrayIndex reads an array index token, end allows the index just past
+// the last element
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || token != strconv.Itoa(index) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if index > length || index == length && !end {
+		return 0, fmt.Errorf("index %d is out of range", index)
+	}
+	return index, nil
+}
+
+// pointerGet returns the value a pointer refers to
+func pointerGet(document any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := document.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			document = value
+		case []any:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("%q can not be looked up in a value", token)
+		}
+	}
+
+	return document, nil
+}
+
+// pointerChange applies change to the container holding the last token of
+// path and returns the document with the changed container in place
+func pointerChange(document any, path []string, change func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return change(document, path[0])
+	}
+
+	child, err := pointerGet(document, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerChange(child, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := document.(type) {
+	case map[string]any:
+		node[path[0]] = child
+	case []any:
+		index, _ := arrayIndex(path[0], len(node), false)
+		node[index] = child
+	}
+	return document, nil
+}
+
+// pointerAdd adds a value, members are set and array elements inserted
+func pointerAdd(document any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return pointerChange(document, path, func(container any, token string) (any, error) {
+		switch node := container.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("%q can not be added to a value", token)
+	})
+}
+
+// pointerRemove removes the value a pointer refers to, which has to exist
+func pointerRemove(document any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("the whole document can not be removed")
+	}
+
+	return pointerChange(document, path, func(container any, token string) (any, error) {
+		switch node := container.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("%q can not be removed from a value", token)
+	})
+}
+
+// copyJSON deep copies a decoded JSON document
+func copyJSON(document any) any {
+	switch node := document.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(node))
+		for key, value := range node {
+			copied[key] = copyJSON(value)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(node))
+		for i, value := range node {
+			copied[i] = copyJSON(value)
+		}
+		return copied
+	}
+	return document
+}
+
+// patchHistory applies a JSON Patch to a vote history.  The history goes
+// through its JSON form, so paths name fields the way
+// GET /voters/:id/polls shows them.
+func patchHistory(history []db.VoterHistory, ops []patchOperation) ([]db.VoterHistory, error) {
+	if history == nil {
+		history = []db.VoterHistory{}
+	}
+
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	var document any
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return nil, err
+	}
+
+	patched, err := applyPatch(document, ops)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := patched.([]any); !ok {
+		return nil, errors.New("the patched history has to be an array")
+	}
+
+	encoded, err = json.Marshal(patched)
+	if err != nil {
+		return nil, err
+	}
+	var updated []db.VoterHistory
+	if err := json.Unmarshal(encoded, &updated); err != nil {
+		return nil, fmt.Errorf("the patched history is not valid: %w", err)
+	}
+
+	return updated, nil
+}
+
+// touchedPolls returns the polls whose history entries differ between two
+// versions of a history, entries that were added, removed or changed
+func touchedPolls(stored, updated []db.VoterHistory) []int {
+	encode := func(history []db.VoterHistory) map[string]int {
+		entries := make(map[string]int, len(history))
+		for _, entry := range history {
+			encoded, _ := json.Marshal(entry)
+			entries[string(encoded)] = entry.PollId
+		}
+		return entries
+	}
+	before, after := encode(stored), encode(updated)
+
+	seen := make(map[int]bool)
+	var polls []int
+	touch := func(entries, other map[string]int) {
+		for encoded, pollID := range entries {
+			if _, ok := other[encoded]; !ok && !seen[pollID] {
+				seen[pollID] = true
+				polls = append(polls, pollID)
+			}
+		}
+	}
+	touch(before, after)
+	touch(after, before)
+	sort.Ints(polls)
+
+	return polls
+}
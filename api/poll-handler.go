@@ -0,0 +1,289 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// surveyRequest is the body of POST /polls/:id/survey.  The voter id is
+// only used to check that the voter took part in the poll, it is not
+// stored with the answers.
+type surveyRequest struct {
+	VoterId int
+	Answers []db.SurveyAnswer
+}
+
+// localizePolls puts polls in the locale the request asks for, see
+// wantedLocales.  Polls without a matching translation keep their
+// original text.
+func (td *VoterAPI) localizePolls(c *fiber.Ctx, polls ...db.Poll) []db.Poll {
+	c.Vary(fiber.HeaderAcceptLanguage)
+
+	wanted := td.wantedLocales(c)
+	localized := make([]db.Poll, 0, len(polls))
+	for _, poll := range polls {
+		localized = append(localized, poll.Localize(wanted...))
+	}
+	return localized
+}
+
+// implementation for GET /polls
+// returns the polls, in the voter's language when ?voter= is given or the
+// best language of Accept-Language otherwise
+func (td *VoterAPI) ListPolls(c *fiber.Ctx) error {
+	return c.JSON(td.localizePolls(c, td.polls.GetAllPolls()...))
+}
+
+// implementation for GET /polls/:id
+func (td *VoterAPI) GetPoll(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	poll, err := td.polls.GetPoll(id)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	poll = td.localizePolls(c, poll)[0]
+	if poll.Locale != "" {
+		c.Set(fiber.HeaderContentLanguage, poll.Locale)
+	}
+
+	return sendResource(c, http.StatusOK, poll)
+}
+
+// implementation for POST /polls
+// adds a poll under the PollId of the body.  Repeating the POST of a poll
+// that is stored as sent is a 200, a PollId taken by a poll with other
+// content is a 409, see etag.go.
+func (td *VoterAPI) PostPoll(c *fiber.Ctx) error {
+	var poll db.Poll
+	if err := c.BodyParser(&poll); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.polls.GetPoll(poll.PollId); err == nil {
+		normalized, err := db.NormalizePoll(poll)
+		if err != nil {
+			return storeError(err)
+		}
+		normalized.LockedBy = stored.LockedBy
+		if resourceETag(normalized) != resourceETag(stored) {
+			return apiError(http.StatusConflict, client.CodeConflict,
+				fmt.Sprintf("poll %d already exists with other content, PUT it to change it", poll.PollId))
+		}
+		return sendResource(c, http.StatusOK, stored)
+	}
+	if err := checkPreconditions(c, ""); err != nil {
+		return err
+	}
+
+	if err := td.polls.AddPoll(poll); err != nil {
+		log.Println("Error adding poll: ", err)
+		return storeError(err)
+	}
+
+	//Reread, the locales of the translations have been normalized
+	poll, err := td.polls.GetPoll(poll.PollId)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	c.Location(fmt.Sprintf("/polls/%d", poll.PollId))
+	return sendResource(c, http.StatusCreated, poll)
+}
+
+// implementation for PUT /polls/:id
+// replaces a poll, or adds it with a 201 when there is none with the id
+func (td *VoterAPI) UpdatePoll(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var poll db.Poll
+	if err := c.BodyParser(&poll); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	poll.PollId = id
+
+	stored, err := td.polls.GetPoll(id)
+	created := err != nil
+	etag := ""
+	if !created {
+		etag = resourceETag(stored)
+	}
+	if err := checkPreconditions(c, etag); err != nil {
+		return err
+	}
+
+	if created {
+		err = td.polls.AddPoll(poll)
+	} else {
+		err = td.polls.UpdatePoll(poll)
+	}
+	if err != nil {
+		log.Println("Error updating poll: ", err)
+		return storeError(err)
+	}
+
+	//Reread, the update keeps the close time and translations when it
+	//leaves them out
+	if poll, err = td.polls.GetPoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	return sendPut(c, created, poll)
+}
+
+// implementation for DELETE /polls/:id
+func (td *VoterAPI) DeletePoll(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.polls.GetPoll(id); err == nil {
+		if err := checkPreconditions(c, resourceETag(stored)); err != nil {
+			return err
+		}
+	}
+
+	if err := td.polls.DeletePoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for PUT /polls/:id/translations/:locale
+// adds or replaces the text of a poll in one locale, the body is a
+// db.PollTranslation
+func (td *VoterAPI) PutPollTranslation(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var translation db.PollTranslation
+	if err := c.BodyParser(&translation); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.polls.GetPoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	poll, err := td.polls.SetTranslation(id, c.Params("locale"), translation)
+	if err != nil {
+		log.Println("Error setting poll translation: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(poll)
+}
+
+// implementation for DELETE /polls/:id/translations/:locale
+func (td *VoterAPI) DeletePollTranslation(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if _, err := td.polls.GetPoll(id); err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	if err := td.polls.DeleteTranslation(id, c.Params("locale")); err != nil {
+		log.Println("Error deleting poll translation: ", err)
+		return apiError(http.StatusNotFound, client.CodeNotFound, err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /polls/:id/survey?token=
+// records an anonymous survey response from a voter that took part in
+// the poll.  Callers without an API key need the token signed for the
+// voter and poll, see GET /admin/voters/:id/links, and are answered the
+// same whether the voter voted or not.
+func (td *VoterAPI) PostSurveyResponse(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req surveyRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if err := td.checkLinkToken(c, linkSurvey, req.VoterId, id); err != nil {
+		return err
+	}
+
+	poll, err := td.polls.GetPoll(id)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	if err := db.CheckAnswers(poll, req.Answers); err != nil {
+		return storeError(err)
+	}
+
+	//A voter following a link gets the same answer whether or not they
+	//voted or already responded, anything else would tell whoever holds
+	//the link whether the voter turned out
+	anonymous := td.fromLink(c)
+	if _, err := td.db.GetVoterPoll(req.VoterId, id); err != nil {
+		if anonymous {
+			return c.Status(http.StatusOK).SendString("Response OK")
+		}
+		return apiError(http.StatusForbidden, client.CodeNotVotedInPoll, "voter has not voted in this poll")
+	}
+
+	if err := td.surveys.AddResponse(poll, req.VoterId, req.Answers); err != nil {
+		if anonymous && errors.Is(err, db.ErrAlreadyExists) {
+			return c.Status(http.StatusOK).SendString("Response OK")
+		}
+		log.Println("Error adding survey response: ", err)
+		return storeError(err)
+	}
+
+	return c.Status(http.StatusOK).SendString("Response OK")
+}
+
+// implementation for GET /polls/:id/survey/results
+// returns the aggregated survey results of a poll
+func (td *VoterAPI) GetSurveyResults(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	poll, err := td.polls.GetPoll(id)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	return c.JSON(td.surveys.GetResults(poll))
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// voterPollingPlace is the response of GET /voters/:id/polling-place
+type voterPollingPlace struct {
+	db.PollingPlace
+	Missing []string //Features asked for with ?needs= that the place lacks
+}
+
+// implementation for GET /polling-places
+// public list of the polling places, ?accessibility= narrows it to the
+// places with all of a comma separated list of features, e.g.
+// wheelchair,curbside
+func (td *VoterAPI) ListPollingPlaces(c *fiber.Ctx) error {
+	features, err := accessibilityQuery(c, "accessibility")
+	if err != nil {
+		return err
+	}
+
+	places := []db.PollingPlace{}
+	for _, place := range td.places.GetAllPollingPlaces() {
+		if place.HasAccessibility(features...) {
+			places = append(places, place)
+		}
+	}
+
+	return c.JSON(places)
+}
+
+// accessibilityQuery returns the accessibility features of a comma
+// separated query parameter, none when it is not given
+func accessibilityQuery(c *fiber.Ctx, param string) ([]string, error) {
+	list := c.Query(param)
+	if list == "" {
+		return nil, nil
+	}
+
+	var features []string
+	for _, feature := range strings.Split(list, ",") {
+		if !db.ValidAccessibility(feature) {
+			return nil, apiError(http.StatusBadRequest, client.CodeInvalidRequest, "unknown accessibility feature "+feature)
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// implementation for GET /polling-places/:id
+func (td *VoterAPI) GetPollingPlace(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	place, err := td.places.GetPollingPlace(id)
+	if err != nil {
+		log.Println("Polling place not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollingPlaceNotFound, "polling place not found")
+	}
+
+	return sendResource(c, http.StatusOK, place)
+}
+
+// implementation for POST /polling-places
+// adds a polling place under the PollingPlaceId of the body.  Repeating
+// the POST of a place that is stored as sent is a 200, an id taken by a
+// place with other content is a 409, see etag.go.
+func (td *VoterAPI) PostPollingPlace(c *fiber.Ctx) error {
+	var place db.PollingPlace
+	if err := c.BodyParser(&place); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.places.GetPollingPlace(place.PollingPlaceId); err == nil {
+		if resourceETag(place) != resourceETag(stored) {
+			return apiError(http.StatusConflict, client.CodeConflict,
+				fmt.Sprintf("polling place %d already exists with other content, PUT it to change it", place.PollingPlaceId))
+		}
+		return sendResource(c, http.StatusOK, stored)
+	}
+	if err := checkPreconditions(c, ""); err != nil {
+		return err
+	}
+
+	if err := td.places.AddPollingPlace(place); err != nil {
+		log.Println("Error adding polling place: ", err)
+		return storeError(err)
+	}
+
+	c.Location(fmt.Sprintf("/polling-places/%d", place.PollingPlaceId))
+	return sendResource(c, http.StatusCreated, place)
+}
+
+// implementation for PUT /polling-places/:id
+// replaces a polling place, or adds it with a 201 when there is none with
+// the id
+func (td *VoterAPI) UpdatePollingPlace(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var place db.PollingPlace
+	if err := c.BodyParser(&place); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	place.PollingPlaceId = id
+
+	stored, err := td.places.GetPollingPlace(id)
+	created := err != nil
+	etag := ""
+	if !created {
+		etag = resourceETag(stored)
+	}
+	if err := checkPreconditions(c, etag); err != nil {
+		return err
+	}
+
+	if created {
+		err = td.places.AddPollingPlace(place)
+	} else {
+		err = td.places.UpdatePollingPlace(place)
+	}
+	if err != nil {
+		log.Println("Error updating polling place: ", err)
+		return storeError(err)
+	}
+
+	return sendPut(c, created, place)
+}
+
+// implementation for DELETE /polling-places/:id
+func (td *VoterAPI) DeletePollingPlace(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.places.GetPollingPlace(id); err == nil {
+		if err := checkPreconditions(c, resourceETag(stored)); err != nil {
+			return err
+		}
+	}
+
+	if err := td.places.DeletePollingPlace(id); err != nil {
+		log.Println("Polling place not found: ", err)
+		return apiError(http.StatusNotFound, client.CodePollingPlaceNotFound, "polling place not found")
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for GET /voters/:id/polling-place
+// resolves the polling place of the voter's assigned precinct, with its
+// accessibility features.  A voter who needs some, e.g.
+// ?needs=wheelchair,audio-ballot, finds those the place lacks in Missing.
+func (td *VoterAPI) GetVoterPollingPlace(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	needs, err := accessibilityQuery(c, "needs")
+	if err != nil {
+		return err
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+	if voter.PrecinctId == 0 {
+		return fiber.NewError(http.StatusNotFound, "voter is not assigned to a precinct")
+	}
+
+	place, err := td.places.FindByPrecinct(voter.PrecinctId)
+	if err != nil {
+		return fiber.NewError(http.StatusNotFound, err.Error())
+	}
+
+	return c.JSON(voterPollingPlace{PollingPlace: place, Missing: place.MissingAccessibility(needs...)})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Priority classes of requests
+const (
+	PriorityHigh   = "high"   //Health checks, check-ins, voter profiles and vote recording
+	PriorityNormal = "normal" //Everything not listed elsewhere
+	PriorityLow    = "low"    //Exports, reports and bulk operations
+)
+
+// DefaultCapacity is the number of requests handled at the same time
+// unless SetCapacity is called
+const DefaultCapacity = 256
+
+// Share of the capacity each class may fill.  Low priority work is shed
+// first, normal work next, high priority work may use every slot and waits
+// a little for one to free up before it is shed.
+const (
+	lowPriorityShare    = 0.5
+	normalPriorityShare = 0.9
+	highPriorityWait    = time.Second
+)
+
+// voteWritePath matches the vote recording routes
+var voteWritePath = regexp.MustCompile(`^/voters/\d+/polls/\d+$`)
+
+// requestPriority returns the priority class of a request
+func requestPriority(method, path string) string {
+	switch {
+	case path == "/voters/health",
+		strings.HasPrefix(path, "/kiosk/"),
+		strings.HasPrefix(path, "/devices/"),
+		strings.HasSuffix(path, "/profile"),
+		method != fiber.MethodGet && voteWritePath.MatchString(path):
+		return PriorityHigh
+	case path == "/voters/export",
+		path == "/voters/tags",
+		strings.HasSuffix(path, "/data-export"),
+		strings.HasSuffix(path, "/counts"),
+		strings.HasSuffix(path, "/turnout"),
+		strings.HasSuffix(path, "/stats") && strings.HasPrefix(path, "/jurisdictions/"),
+		strings.HasPrefix(path, "/admin/export/"),
+		strings.HasPrefix(path, "/admin/exclusions"),
+		path == "/admin/archive",
+		path == "/admin/legal-holds",
+		path == "/admin/boundary-changes":
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// PriorityStats are the load metrics of one priority class
+type PriorityStats struct {
+	Class    string
+	InFlight int
+	Served   int64
+	Shed     int64
+}
+
+// scheduler admits requests by priority class once the server is busy
+type scheduler struct {
+	slots chan struct{}
+
+	mu    sync.Mutex
+	stats map[string]*PriorityStats
+}
+
+// constructor for scheduler struct, capacity is the number of requests
+// that may be handled at the same time
+func newScheduler(capacity int) *scheduler {
+	s := &scheduler{
+		slots: make(chan struct{}, capacity),
+		stats: make(map[string]*PriorityStats),
+	}
+	for _, class := range []string{PriorityHigh, PriorityNormal, PriorityLow} {
+		s.stats[class] = &PriorityStats{Class: class}
+	}
+
+	return s
+}
+
+// admit takes a slot for a request of the given class, it returns false
+// if the request has to be shed
+func (s *scheduler) admit(class string) bool {
+	capacity := float64(cap(s.slots))
+
+	admitted := false
+	switch class {
+	case PriorityHigh:
+		select {
+		case s.slots <- struct{}{}:
+			admitted = true
+		case <-time.After(highPriorityWait):
+		}
+	default:
+		share := normalPriorityShare
+		if class == PriorityLow {
+			share = lowPriorityShare
+		}
+		if float64(len(s.slots)) < capacity*share {
+			select {
+			case s.slots <- struct{}{}:
+				admitted = true
+			default:
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if admitted {
+		s.stats[class].InFlight++
+		s.stats[class].Served++
+	} else {
+		s.stats[class].Shed++
+	}
+
+	return admitted
+}
+
+// release gives the slot of a finished request back
+func (s *scheduler) release(class string) {
+	<-s.slots
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[class].InFlight--
+}
+
+// SetCapacity sets the number of requests handled at the same time, the
+// default is DefaultCapacity.  It must be called before the server starts.
+func (td *VoterAPI) SetCapacity(capacity int) {
+	td.scheduler = newScheduler(capacity)
+}
+
+// Prioritize is the middleware that admits requests by priority class.
+// While the server has spare capacity everything is admitted, as it fills
+// up low priority work is shed with a 503 first, then normal work, so
+// check-ins and votes keep being served.  Requests from bulk integrator
+// keys count as low priority.
+func (td *VoterAPI) Prioritize(c *fiber.Ctx) error {
+	class := requestPriority(c.Method(), c.Path())
+	if bulk, _ := c.Locals("bulk").(bool); bulk && class == PriorityNormal {
+		class = PriorityLow
+	}
+	if !td.scheduler.admit(class) {
+		return &client.Error{
+			Status:     http.StatusServiceUnavailable,
+			Code:       client.CodeOverloaded,
+			Message:    "server is busy, " + class + " priority requests are being shed",
+			RetryAfter: 1,
+		}
+	}
+	defer td.scheduler.release(class)
+
+	return c.Next()
+}
+
+// implementation for GET /admin/load
+// returns the in flight, served and shed counts of each priority class
+func (td *VoterAPI) GetLoad(c *fiber.Ctx) error {
+	td.scheduler.mu.Lock()
+	defer td.scheduler.mu.Unlock()
+
+	classes := make([]PriorityStats, 0, len(td.scheduler.stats))
+	for _, class := range []string{PriorityHigh, PriorityNormal, PriorityLow} {
+		classes = append(classes, *td.scheduler.stats[class])
+	}
+
+	return c.JSON(fiber.Map{
+		"capacity": cap(td.scheduler.slots),
+		"classes":  classes,
+	})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// voterProfile is everything the check-in app needs about a voter.
+// PollingPlace is nil when the voter has no precinct or the precinct has
+// no place, Eligibility is only filled in when a poll is asked for.
+type voterProfile struct {
+	Voter        db.Voter
+	Status       string
+	PrecinctId   int
+	PollingPlace *db.PollingPlace
+	Eligibility  *db.Eligibility
+	History      db.HistorySummary
+}
+
+// implementation for GET /voters/:id/profile
+// returns the voter, their polling place, registration status and a
+// summary of their history in one response.  Passing ?poll=n adds the
+// eligibility evaluation for that poll.
+func (td *VoterAPI) GetVoterProfile(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	profile := voterProfile{
+		Voter:      voter,
+		Status:     voter.Status,
+		PrecinctId: voter.PrecinctId,
+		History:    db.SummarizeHistory(voter),
+	}
+	if profile.Status == "" {
+		profile.Status = "active"
+	}
+
+	if voter.PrecinctId != 0 {
+		if place, err := td.places.FindByPrecinct(voter.PrecinctId); err == nil {
+			profile.PollingPlace = &place
+		}
+	}
+
+	if pollID := c.QueryInt("poll", 0); pollID != 0 {
+		eligibility, err := td.evaluateEligibility(voter, pollID)
+		if err != nil {
+			return err
+		}
+		profile.Eligibility = &eligibility
+	}
+
+	return c.JSON(profile)
+}
+
+// evaluateEligibility checks a voter against the rules for a poll, along
+// with the rules that depend on the state of the poll itself
+func (td *VoterAPI) evaluateEligibility(voter db.Voter, pollID int) (db.Eligibility, error) {
+	poll, err := td.polls.GetPoll(pollID)
+	if err != nil {
+		return db.Eligibility{}, apiError(http.StatusNotFound, client.CodePollNotFound, "poll not found")
+	}
+
+	eligibility := db.EvaluateEligibility(voter, pollID)
+	if !poll.Closes.IsZero() && time.Now().After(poll.Closes) {
+		eligibility.Fail(db.RulePollOpen)
+	}
+	if poll.LockedBy != 0 {
+		eligibility.Fail(db.RulePollNotLocked)
+	}
+
+	return eligibility, nil
+}
+
+// implementation for GET /voters/:id/eligibility?poll=
+// returns whether the voter can vote in the poll right now, the rules that
+// fail, the precinct the voter votes in and what has to be done before
+// they can vote.  Nothing is recorded, front-ends call it to guide the
+// voter before they try to vote.
+func (td *VoterAPI) GetVoterEligibility(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	pollID := c.QueryInt("poll", 0)
+	if pollID == 0 {
+		return fiber.NewError(http.StatusBadRequest, "poll is required")
+	}
+
+	voter, err := td.db.GetVoter(id)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	eligibility, err := td.evaluateEligibility(voter, pollID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(eligibility)
+}
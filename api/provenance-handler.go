@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// writeSource is the source the writes of a request come from, which the
+// provenance rules are checked against
+func writeSource(c *fiber.Ctx) string {
+	if caller, ok := c.Locals("principal").(principal); ok {
+		return caller.Source
+	}
+	return AnonymousRole
+}
+
+// flagConflicts records the changes a write had held back by the
+// provenance rules and names the fields in the X-Provenance-Held header,
+// so the caller can tell its write did not go through in full
+func (td *VoterAPI) flagConflicts(c *fiber.Ctx, conflicts []db.ProvenanceConflict) {
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fields := make([]string, 0, len(conflicts))
+	for _, conflict := range td.db.FlagConflicts(conflicts) {
+		fields = append(fields, conflict.Field)
+		td.audit.Record(requestID(c), "provenance.flagged", conflict.VoterId,
+			fmt.Sprintf("%s owned by %s, change from %s held for review", conflict.Field, conflict.Owner, conflict.Source))
+	}
+	c.Set("X-Provenance-Held", strings.Join(fields, ", "))
+}
+
+// implementation for GET /admin/provenance
+// returns the provenance rules, which source owns which voter field
+func (td *VoterAPI) GetProvenanceRules(c *fiber.Ctx) error {
+	return c.JSON(td.db.GetProvenanceRules())
+}
+
+// implementation for PUT /admin/provenance
+// replaces the provenance rules, an empty object lets every source change
+// every field
+func (td *VoterAPI) SetProvenanceRules(c *fiber.Ctx) error {
+	var rules db.ProvenanceRules
+	if err := c.BodyParser(&rules); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := td.db.SetProvenanceRules(rules); err != nil {
+		return storeError(err)
+	}
+	td.audit.Record(requestID(c), "provenance.updated", 0, fmt.Sprintf("%d fields owned", len(rules)))
+
+	return c.JSON(td.db.GetProvenanceRules())
+}
+
+// implementation for GET /admin/provenance/conflicts
+// returns the changes held back by the provenance rules, oldest first
+func (td *VoterAPI) GetProvenanceConflicts(c *fiber.Ctx) error {
+	return c.JSON(td.db.GetProvenanceConflicts())
+}
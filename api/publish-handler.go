@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/publish"
+	"github.com/gofiber/fiber/v2"
+)
+
+// publishBuffer is the number of changes that may wait to be published
+const publishBuffer = 10000
+
+// EnablePublisher starts publishing every change of the roll to a NATS
+// subject or a Kafka topic, see package publish.  A message is the
+// LiveEvent of the change with all of the voter's fields, the Seq of the
+// change lets a consumer drop the duplicates a retry may cause.
+func (td *VoterAPI) EnablePublisher(dest string) error {
+	if err := td.refuseInRehearsal("publishing"); err != nil {
+		return err
+	}
+	publisher, err := publish.NewPublisher(dest, publishBuffer)
+	if err != nil {
+		return err
+	}
+
+	td.publisher = publisher
+	td.setFeature("publish", publisher.Broker())
+	go td.publishChanges()
+	return nil
+}
+
+// publishChanges hands every change of the roll to the publisher, for as
+// long as the process runs.  Should it fall behind the change feed it
+// picks up again from the feed's backlog.
+func (td *VoterAPI) publishChanges() {
+	last := int64(-1)
+	for {
+		missed, complete, changes, _ := td.db.WatchFrom(last, liveBuffer)
+		if !complete {
+			log.Println("Error publishing changes: changes since", last, "are no longer kept, they were not published")
+		}
+		for _, change := range missed {
+			td.publishChange(change)
+			last = change.Seq
+		}
+		for change := range changes {
+			td.publishChange(change)
+			last = change.Seq
+		}
+	}
+}
+
+// publishChange queues a change for the broker.  What is published is
+// written to the access log, under the name publisher.
+func (td *VoterAPI) publishChange(change db.Change) {
+	event := td.liveEvent(change, change.Seq, nil)
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Error encoding published change: ", err)
+		return
+	}
+
+	td.publisher.Publish(publish.Message{
+		Event: change.Event,
+		Key:   strconv.Itoa(change.VoterId),
+		Body:  body,
+	})
+	td.recordAccess(principal{Name: "publisher"}, http.MethodPost, td.publisher.Broker(), event.Voter)
+}
+
+// implementation for GET /admin/publisher
+// returns the queued, published, retried and dropped counts of the change
+// publisher
+func (td *VoterAPI) GetPublisherStats(c *fiber.Ctx) error {
+	if td.publisher == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled": true,
+		"broker":  td.publisher.Broker(),
+		"stats":   td.publisher.Stats(),
+	})
+}
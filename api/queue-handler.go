@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// implementation for POST /precincts/:id/queue-metrics
+// accepts a throughput report from a device assigned to the precinct
+func (td *VoterAPI) PostQueueMetrics(c *fiber.Ctx) error {
+	device := c.Locals("device").(db.Device)
+
+	precinctID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if device.PrecinctId != precinctID {
+		return fiber.NewError(http.StatusForbidden)
+	}
+
+	var report db.QueueReport
+	if err := c.BodyParser(&report); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	report.PrecinctId = precinctID
+	report.DeviceId = device.DeviceId
+
+	report, err = td.queues.AddReport(report)
+	if err != nil {
+		log.Println("Error adding queue report: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(report)
+}
+
+// implementation for GET /precincts/:id/wait-time
+// public wait time estimate for a polling place
+func (td *VoterAPI) GetWaitTime(c *fiber.Ctx) error {
+	precinctID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	est, err := td.queues.GetEstimate(precinctID)
+	if err != nil {
+		return fiber.NewError(http.StatusNotFound, err.Error())
+	}
+
+	return c.JSON(est)
+}
+
+// implementation for GET /precincts/wait-times
+// public wait time estimates for every polling place that reported lately
+func (td *VoterAPI) ListWaitTimes(c *fiber.Ctx) error {
+	return c.JSON(td.queues.GetAllEstimates())
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// historyQuotaView is the body of GET /admin/history-quota
+type historyQuotaView struct {
+	Limit         int
+	WindowSeconds int
+	Breaches      []db.QuotaBreach
+}
+
+// SetHistoryQuota sets how many changes to the vote history of one voter
+// are allowed within the window, a limit of 0 turns the quota off.  The
+// default is db.DefaultHistoryQuota changes per db.DefaultHistoryQuotaWindow.
+func (td *VoterAPI) SetHistoryQuota(limit int, window time.Duration) {
+	td.historyQuota.SetLimit(limit, window)
+}
+
+// checkHistoryQuota counts a change to the vote history of a voter against
+// the soft quota and refuses it with a 429 when the voter is over it.  The
+// first change refused raises an alert in the log and the review queue, a
+// stream of changes to one voter is most likely an integration stuck in a
+// retry loop and someone should look at it rather than the API absorbing
+// the churn.
+func (td *VoterAPI) checkHistoryQuota(c *fiber.Ctx, voterID int) error {
+	breach, retry, first, ok := td.historyQuota.Take(voterID, time.Now())
+	if ok {
+		return nil
+	}
+
+	limit, window := td.historyQuota.Limit()
+	if first {
+		log.Printf("HISTORY QUOTA: voter %d had %d vote history changes within %s, further changes are refused",
+			voterID, breach.Changes, window)
+		td.reviews.Flag(db.ReviewAnomaly, voterID,
+			fmt.Sprintf("vote history of voter %d changed %d times within %s", voterID, breach.Changes, window), breach)
+		td.audit.Record(requestID(c), "history.quota_exceeded", voterID,
+			fmt.Sprintf("%d changes within %s", breach.Changes, window))
+	}
+
+	return &client.Error{
+		Status:     http.StatusTooManyRequests,
+		Code:       client.CodeHistoryQuota,
+		Message:    fmt.Sprintf("the vote history of voter %d changed more than %d times within %s", voterID, limit, window),
+		RetryAfter: int(math.Ceil(retry.Seconds())),
+	}
+}
+
+// implementation for GET /admin/history-quota
+// returns the history quota and the voters that are over it
+func (td *VoterAPI) GetHistoryQuota(c *fiber.Ctx) error {
+	limit, window := td.historyQuota.Limit()
+	return c.JSON(historyQuotaView{
+		Limit:         limit,
+		WindowSeconds: int(window.Seconds()),
+		Breaches:      td.historyQuota.GetBreaches(time.Now()),
+	})
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bounds on the queueing of bulk integrator requests.  A request that
+// would have to wait longer than bulkMaxDelay, or that finds bulkMaxQueued
+// requests of the same key already waiting, is refused after all.
+const (
+	bulkMaxDelay  = 10 * time.Second
+	bulkMaxQueued = 64
+)
+
+// RateLimitStats are the counters of one API key's rate limit
+type RateLimitStats struct {
+	Key      string
+	Rate     float64
+	Burst    int
+	Bulk     bool
+	Allowed  int64 //Requests let straight through
+	Queued   int64 //Requests that were delayed, then let through
+	Rejected int64
+	Waiting  int
+	Delay    time.Duration //Total time requests spent queued
+}
+
+// bucket is the token bucket of one API key
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  RateLimitStats
+}
+
+// reserve takes a token and returns how long the caller has to wait for
+// it.  The bucket may go into debt for bulk keys, a debt means the token
+// is handed out once it has been refilled.  ok is false when the request
+// has to be refused, in which case nothing is taken.
+func (b *bucket) reserve(now time.Time) (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rate, burst := b.stats.Rate, float64(b.stats.Burst)
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.stats.Allowed++
+		return 0, true
+	}
+
+	wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	if !b.stats.Bulk || wait > bulkMaxDelay || b.stats.Waiting >= bulkMaxQueued {
+		b.stats.Rejected++
+		return wait, false
+	}
+
+	b.tokens--
+	b.stats.Queued++
+	b.stats.Waiting++
+	b.stats.Delay += wait
+	return wait, true
+}
+
+// done marks a queued request as no longer waiting
+func (b *bucket) done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Waiting--
+}
+
+// rateLimits holds the buckets of the rate limited API keys by key name
+type rateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucketFor returns the bucket of a key, nil when the key has no rate
+// limit.  A bucket is made on the key's first request and made again when
+// the limit of the key has been changed since.
+func (r *rateLimits) bucketFor(key db.APIKey) *bucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key.RateLimit <= 0 {
+		delete(r.buckets, key.Name)
+		return nil
+	}
+
+	burst := key.Burst
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(key.RateLimit)))
+	}
+	if b, ok := r.buckets[key.Name]; ok && b.stats.Rate == key.RateLimit && b.stats.Burst == burst && b.stats.Bulk == key.Bulk {
+		return b
+	}
+
+	if r.buckets == nil {
+		r.buckets = make(map[string]*bucket)
+	}
+	b := &bucket{
+		tokens: float64(burst),
+		last:   time.Now(),
+		stats: RateLimitStats{
+			Key:   key.Name,
+			Rate:  key.RateLimit,
+			Burst: burst,
+			Bulk:  key.Bulk,
+		},
+	}
+	r.buckets[key.Name] = b
+
+	return b
+}
+
+// drop forgets the bucket of a key that has been deleted
+func (r *rateLimits) drop(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.buckets, name)
+}
+
+// limitedKey returns the API key a request is charged to, the key that
+// minted its bearer token or else the key in X-API-Key, the same one
+// AccessControl identifies the caller by
+func (td *VoterAPI) limitedKey(c *fiber.Ctx) (db.APIKey, bool) {
+	if token := bearerToken(c); token != "" {
+		grant, ok := td.tokens.lookup(token)
+		if !ok {
+			return db.APIKey{}, false
+		}
+		key, err := td.apiKeys.GetKey(grant.key)
+		return key, err == nil
+	}
+	if secret := c.Get("X-API-Key"); secret != "" {
+		return td.apiKeys.Identify(secret)
+	}
+	return db.APIKey{}, false
+}
+
+// RateLimit is the middleware that holds every API key to its request
+// rate.  Interactive keys that go over it are refused with a 429 right
+// away.  Keys flagged as bulk integrators have their excess requests held
+// back until their turn comes, up to a bounded delay, so a nightly sync
+// burst is smoothed out instead of failing.  Requests made with a scoped
+// token count against the key that minted it, so minting tokens does not
+// get around the limit.  It runs ahead of the scheduler so a waiting
+// request does not hold a slot, and bulk requests are scheduled as low
+// priority so they never crowd out interactive work.
+func (td *VoterAPI) RateLimit(c *fiber.Ctx) error {
+	if td.access == nil {
+		return c.Next()
+	}
+	key, ok := td.limitedKey(c)
+	if !ok {
+		return c.Next()
+	}
+	b := td.rateLimits.bucketFor(key)
+	if b == nil {
+		return c.Next()
+	}
+	if b.stats.Bulk {
+		c.Locals("bulk", true)
+	}
+
+	wait, ok := b.reserve(time.Now())
+	if !ok {
+		return &client.Error{
+			Status:     http.StatusTooManyRequests,
+			Code:       client.CodeRateLimited,
+			Message:    "rate limit exceeded",
+			RetryAfter: int(math.Ceil(wait.Seconds())),
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+		b.done()
+	}
+
+	return c.Next()
+}
+
+// implementation for GET /admin/ratelimits
+// returns the rate limit counters of every limited API key
+func (td *VoterAPI) GetRateLimits(c *fiber.Ctx) error {
+	td.rateLimits.mu.Lock()
+	stats := make([]RateLimitStats, 0, len(td.rateLimits.buckets))
+	for _, b := range td.rateLimits.buckets {
+		b.mu.Lock()
+		stats = append(stats, b.stats)
+		b.mu.Unlock()
+	}
+	td.rateLimits.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+
+	return c.JSON(stats)
+}
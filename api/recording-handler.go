@@ -0,0 +1,97 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/replay"
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordingBuffer is the number of recorded requests that may wait to be
+// written
+const recordingBuffer = 10000
+
+// unrecordedPrefixes are the paths whose requests are never recorded,
+// they carry credentials or are operator actions no capacity test should
+// repeat, or, like the /voters/ws and /voters/events streams, can not be
+// replayed as a single request.
+// GraphQL requests are recorded as the REST requests their fields run as.
+var unrecordedPrefixes = []string{"/admin", "/auth", "/devices/enroll", "/graphql", "/voters/ws", "/voters/events"}
+
+// safeBodyFields are the JSON body fields that never carry PII, every
+// other string of a recorded body is pseudonymized
+var safeBodyFields = map[string]bool{
+	"Channel": true, "Locale": true, "Status": true,
+}
+
+// EnableRecording starts recording the shape of the served traffic into a
+// replayable file, see the replay package.  sample is the share of
+// requests recorded.
+func (td *VoterAPI) EnableRecording(path string, sample float64) error {
+	rules := replay.Rules{SafeQuery: safeQueryParams, SafeFields: safeBodyFields}
+	recorder, err := replay.NewRecorder(path, rules, sample, recordingBuffer)
+	if err != nil {
+		return err
+	}
+
+	td.recorder = recorder
+	td.setFeature("traffic_recording", sample)
+	return nil
+}
+
+// RecordTraffic is the middleware that hands sampled requests to the
+// traffic recorder.  It runs first so the recorded duration covers every
+// other middleware, e.g. rate limiting.
+func (td *VoterAPI) RecordTraffic(c *fiber.Ctx) error {
+	if td.recorder == nil || !td.recorder.Sampled() {
+		return c.Next()
+	}
+	for _, prefix := range unrecordedPrefixes {
+		if strings.HasPrefix(c.Path(), prefix) {
+			return c.Next()
+		}
+	}
+
+	start := time.Now()
+	err := c.Next()
+	duration := time.Since(start)
+
+	//Requests that matched no route end on the last middleware, there
+	//is no route to replay them on
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		return err
+	}
+
+	capture := replay.Capture{
+		Time:        start,
+		Method:      c.Method(),
+		Route:       route,
+		Params:      c.AllParams(),
+		Query:       c.Queries(),
+		ContentType: c.Get(fiber.HeaderContentType),
+		Accept:      c.Get(fiber.HeaderAccept),
+		Body:        c.Body(),
+		Status:      responseStatus(c, err),
+		Duration:    duration,
+	}
+	td.recorder.Record(capture)
+
+	return err
+}
+
+// implementation for GET /admin/recording
+// returns the file and the recorded, dropped and failed counts of the
+// traffic recording
+func (td *VoterAPI) GetRecordingStats(c *fiber.Ctx) error {
+	if td.recorder == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled": true,
+		"file":    td.recorder.Path(),
+		"stats":   td.recorder.Stats(),
+	})
+}
@@ -0,0 +1,410 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Bounds of a rehearsal
+const (
+	maxSeedVoters  = 100000
+	maxTrafficRate = 5000 //Votes per second
+	trafficTick    = 10 * time.Millisecond
+)
+
+// rehearsalHeader marks every response of a rehearsal, so a client can
+// never take it for the real roll
+const rehearsalHeader = "X-Rehearsal"
+
+// Seeded voters are named from these lists, simulated votes are cast
+// through these channels
+var (
+	seedFirstNames = []string{"Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Riley", "Jamie", "Avery", "Quinn"}
+	seedLastNames  = []string{"Smith", "Johnson", "Lee", "Garcia", "Brown", "Davis", "Miller", "Wilson", "Moore", "Clark"}
+	seedChannels   = []string{db.ChannelInPerson, db.ChannelMail, db.ChannelOnline}
+)
+
+// seedRequest is the body of POST /admin/rehearsal/seed
+type seedRequest struct {
+	Voters    int
+	Precincts int //Voters are spread over precincts 1 to Precincts, 0 leaves them without one
+}
+
+// trafficRequest is the body of POST /admin/rehearsal/traffic
+type trafficRequest struct {
+	Rate            float64 //Votes per second
+	DurationSeconds int     //0 runs until every voter voted in every poll or the traffic is stopped
+	Polls           []int   //Every voter votes in each of them, in random order
+}
+
+// trafficStats are the counters of a simulated traffic run
+type trafficStats struct {
+	Running  bool
+	Rate     float64
+	Polls    []int
+	Started  time.Time
+	Stopped  time.Time //Zero while running
+	Sent     int64
+	Recorded int64 //Answered with a 2xx
+	Rejected int64 //Answered with a 4xx, e.g. a rate limit or a closed poll
+	Failed   int64 //Answered with a 5xx
+}
+
+// rehearsalDashboard is the response of GET /admin/rehearsal
+type rehearsalDashboard struct {
+	Enabled  bool
+	Voters   int
+	Seeded   int
+	Votes    int
+	Turnout  map[int]int    //Votes by poll
+	Channels map[string]int //Votes by channel
+	Traffic  *trafficStats  //The running or last traffic run, nil before the first
+}
+
+// rehearsal is the state of rehearsal mode
+type rehearsal struct {
+	mu      sync.Mutex
+	seeded  int
+	traffic *trafficStats
+	stop    chan struct{} //Closed to stop the running traffic, nil when none runs
+}
+
+// EnableRehearsal turns this process into a sandbox for election
+// rehearsals.  Voters are then only ever kept in memory: the persistent
+// backends, the shadow store and the change publisher refuse to start, no
+// webhook is delivered and every response carries X-Rehearsal: true, so
+// rehearsal data can never reach the real roll or the systems fed from
+// it.  Staff seed voters, drive simulated vote traffic and watch it at
+// /admin/rehearsal and /voters/events, and wipe everything with one POST
+// to /admin/rehearsal/wipe.  It has to be called before any backend is
+// enabled.
+func (td *VoterAPI) EnableRehearsal() error {
+	if td.store != td.db || td.publisher != nil || td.db.ShadowStats().Enabled {
+		return errors.New("rehearsal mode keeps voters in memory, it can not be combined with a storage backend, a shadow store or -publish")
+	}
+
+	td.rehearsal = &rehearsal{}
+	td.setFeature("rehearsal", true)
+	return nil
+}
+
+// refuseInRehearsal is the error of enabling something that would carry
+// data out of a rehearsal
+func (td *VoterAPI) refuseInRehearsal(what string) error {
+	if td.rehearsal == nil {
+		return nil
+	}
+	return fmt.Errorf("%s can not be used in rehearsal mode, rehearsal voters are only kept in memory", what)
+}
+
+// MarkRehearsal is the middleware that marks every response of a
+// rehearsal with X-Rehearsal: true
+func (td *VoterAPI) MarkRehearsal(c *fiber.Ctx) error {
+	if td.rehearsal != nil {
+		c.Set(rehearsalHeader, "true")
+	}
+	return c.Next()
+}
+
+// rehearsalOff is the error of a rehearsal route on a server that is not
+// in rehearsal mode
+func rehearsalOff() error {
+	return apiError(http.StatusNotFound, client.CodeNotFound, "rehearsal mode is off, start the server with -rehearsal")
+}
+
+// implementation for POST /admin/rehearsal/seed
+// adds Voters made up voters, numbered on from the highest voter id, with
+// names from a fixed list and spread over Precincts precincts
+func (td *VoterAPI) SeedRehearsal(c *fiber.Ctx) error {
+	if td.rehearsal == nil {
+		return rehearsalOff()
+	}
+
+	var seed seedRequest
+	if err := c.BodyParser(&seed); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if seed.Voters <= 0 || seed.Voters > maxSeedVoters || seed.Precincts < 0 {
+		return apiError(http.StatusBadRequest, client.CodeInvalidRequest,
+			fmt.Sprintf("seed between 1 and %d voters over 0 or more precincts", maxSeedVoters))
+	}
+
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		return storeError(err)
+	}
+	first := 1
+	for _, voter := range voters {
+		first = max(first, voter.VoterId+1)
+	}
+
+	for i := 0; i < seed.Voters; i++ {
+		id := first + i
+		voter := db.Voter{
+			VoterId: id,
+			Name:    seedFirstNames[id%len(seedFirstNames)] + " " + seedLastNames[(id/len(seedFirstNames))%len(seedLastNames)],
+			Email:   fmt.Sprintf("voter%d@rehearsal.invalid", id),
+			Status:  "active",
+		}
+		if seed.Precincts > 0 {
+			voter.PrecinctId = 1 + i%seed.Precincts
+		}
+		if err := td.store.AddVoter(voter); err != nil {
+			log.Println("Error seeding voter: ", err)
+			return storeError(err)
+		}
+	}
+
+	td.rehearsal.mu.Lock()
+	td.rehearsal.seeded += seed.Voters
+	td.rehearsal.mu.Unlock()
+	td.audit.Record(requestID(c), "rehearsal.seeded", 0, fmt.Sprintf("%d voters from id %d", seed.Voters, first))
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"seeded": seed.Voters,
+		"first":  first,
+		"last":   first + seed.Voters - 1,
+	})
+}
+
+// implementation for POST /admin/rehearsal/traffic
+// starts simulated vote traffic at Rate votes per second.  Each vote is a
+// POST /voters/:id/polls/:pollid for a voter who has not voted in the
+// poll yet, sent through every route and middleware with the headers of
+// this request, so it is authorized, rate limited and fed to the live
+// streams like a vote from a device.  Only one run goes at a time.
+func (td *VoterAPI) StartRehearsalTraffic(c *fiber.Ctx) error {
+	if td.rehearsal == nil {
+		return rehearsalOff()
+	}
+
+	var req trafficRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Rate <= 0 || req.Rate > maxTrafficRate || req.DurationSeconds < 0 || len(req.Polls) == 0 {
+		return apiError(http.StatusBadRequest, client.CodeInvalidRequest,
+			fmt.Sprintf("traffic needs a Rate up to %d votes per second and at least one poll", maxTrafficRate))
+	}
+	for _, poll := range req.Polls {
+		if poll <= 0 {
+			return apiError(http.StatusBadRequest, client.CodeInvalidRequest, "poll ids are positive")
+		}
+	}
+
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		return storeError(err)
+	}
+	var pending [][2]int //Voter and poll of the votes still to send
+	for _, voter := range voters {
+		for _, poll := range req.Polls {
+			voted := false
+			for _, history := range voter.VoteHistory {
+				voted = voted || history.PollId == poll
+			}
+			if !voted {
+				pending = append(pending, [2]int{voter.VoterId, poll})
+			}
+		}
+	}
+	rand.Shuffle(len(pending), func(i, j int) { pending[i], pending[j] = pending[j], pending[i] })
+
+	//The votes go out with the caller's credentials, from the caller's
+	//address
+	var header fasthttp.RequestHeader
+	c.Request().Header.CopyTo(&header)
+	for _, name := range []string{fiber.HeaderContentType, fiber.HeaderContentLength, fiber.HeaderAcceptEncoding,
+		fiber.HeaderIfMatch, fiber.HeaderIfNoneMatch, fiber.HeaderXRequestID} {
+		header.Del(name)
+	}
+	addr := c.Context().RemoteAddr()
+
+	r := td.rehearsal
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return apiError(http.StatusConflict, client.CodeConflict, "traffic is already running, DELETE /admin/rehearsal/traffic first")
+	}
+	r.traffic = &trafficStats{Running: true, Rate: req.Rate, Polls: req.Polls, Started: time.Now()}
+	r.stop = make(chan struct{})
+	go td.runTraffic(r.traffic, r.stop, pending, &header, addr, time.Duration(req.DurationSeconds)*time.Second)
+	td.audit.Record(requestID(c), "rehearsal.traffic", 0, fmt.Sprintf("%g votes per second to polls %v", req.Rate, req.Polls))
+
+	return c.Status(http.StatusAccepted).JSON(*r.traffic)
+}
+
+// implementation for DELETE /admin/rehearsal/traffic
+// stops the simulated vote traffic
+func (td *VoterAPI) StopRehearsalTraffic(c *fiber.Ctx) error {
+	if td.rehearsal == nil {
+		return rehearsalOff()
+	}
+
+	td.rehearsal.stopTraffic()
+	return c.Status(http.StatusOK).SendString("Traffic stopped")
+}
+
+// stopTraffic stops the running traffic, if any
+func (r *rehearsal) stopTraffic() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// runTraffic sends the pending votes at the rate of a run until they are
+// all sent, the duration is over or the run is stopped
+func (td *VoterAPI) runTraffic(stats *trafficStats, stop chan struct{}, pending [][2]int,
+	header *fasthttp.RequestHeader, addr net.Addr, duration time.Duration) {
+	r := td.rehearsal
+	ticker := time.NewTicker(trafficTick)
+	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if duration > 0 {
+		deadline = time.After(duration)
+	}
+
+	sent := 0
+loop:
+	for sent < len(pending) {
+		select {
+		case <-stop:
+			break loop
+		case <-deadline:
+			break loop
+		case now := <-ticker.C:
+			due := min(int(stats.Rate*now.Sub(stats.Started).Seconds()), len(pending))
+			for ; sent < due; sent++ {
+				status := td.sendVote(header, addr, pending[sent][0], pending[sent][1])
+				r.mu.Lock()
+				stats.Sent++
+				switch {
+				case status < 300:
+					stats.Recorded++
+				case status < 500:
+					stats.Rejected++
+				default:
+					stats.Failed++
+				}
+				r.mu.Unlock()
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats.Running = false
+	stats.Stopped = time.Now()
+	if r.stop == stop {
+		r.stop = nil
+	}
+}
+
+// sendVote records a simulated vote through the routes and returns the
+// status it was answered with
+func (td *VoterAPI) sendVote(header *fasthttp.RequestHeader, addr net.Addr, voterID, pollID int) int {
+	vote := db.VoterHistory{VoteDate: time.Now(), Channel: seedChannels[rand.Intn(len(seedChannels))]}
+	data, err := json.Marshal(vote)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+
+	var req fasthttp.Request
+	header.CopyTo(&req.Header)
+	req.Header.SetMethod(http.MethodPost)
+	req.SetRequestURI(fmt.Sprintf("/voters/%d/polls/%d", voterID, pollID))
+	req.Header.SetContentType(fiber.MIMEApplicationJSON)
+	req.SetBody(data)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, addr, nil)
+	td.routes()(&ctx)
+	return ctx.Response.StatusCode()
+}
+
+// implementation for GET /admin/rehearsal
+// returns the rehearsal dashboard: the voters, how many of them were
+// seeded, the votes by poll and by channel and the traffic run.  Refresh
+// it, or follow /voters/events, to watch a rehearsal live.
+func (td *VoterAPI) GetRehearsal(c *fiber.Ctx) error {
+	if td.rehearsal == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		return storeError(err)
+	}
+	dashboard := rehearsalDashboard{
+		Enabled:  true,
+		Voters:   len(voters),
+		Turnout:  make(map[int]int),
+		Channels: make(map[string]int),
+	}
+	for _, voter := range voters {
+		for _, history := range voter.VoteHistory {
+			dashboard.Votes++
+			dashboard.Turnout[history.PollId]++
+			if history.Channel != "" {
+				dashboard.Channels[history.Channel]++
+			}
+		}
+	}
+
+	r := td.rehearsal
+	r.mu.Lock()
+	dashboard.Seeded = r.seeded
+	if r.traffic != nil {
+		traffic := *r.traffic
+		dashboard.Traffic = &traffic
+	}
+	r.mu.Unlock()
+
+	return c.JSON(dashboard)
+}
+
+// implementation for POST /admin/rehearsal/wipe
+// stops the traffic and deletes every voter of the rehearsal, votes
+// included, whatever -immutable-history says.  Voters under legal hold
+// stop the wipe, as they stop DELETE /voters.
+func (td *VoterAPI) WipeRehearsal(c *fiber.Ctx) error {
+	if td.rehearsal == nil {
+		return rehearsalOff()
+	}
+
+	td.rehearsal.stopTraffic()
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		return storeError(err)
+	}
+	if err := td.db.DeleteAll(); err != nil {
+		log.Println("Error wiping the rehearsal: ", err)
+		return storeError(err)
+	}
+
+	td.rehearsal.mu.Lock()
+	td.rehearsal.seeded = 0
+	td.rehearsal.traffic = nil
+	td.rehearsal.mu.Unlock()
+	td.audit.Record(requestID(c), "rehearsal.wiped", 0, fmt.Sprintf("%d voters", len(voters)))
+
+	return c.JSON(fiber.Map{"wiped": len(voters)})
+}
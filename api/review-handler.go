@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// reviewAssignment is the body of POST /admin/reviews/:id/assign
+type reviewAssignment struct {
+	Assignee string //Defaults to the caller
+}
+
+// reviewResolution is the body of POST /admin/reviews/:id/resolve
+type reviewResolution struct {
+	Resolution string //accept or reject
+	Note       string
+}
+
+// SetReviewSLA sets how long a flagged item may wait for a resolution
+// before it counts as overdue.  The default is db.DefaultReviewSLA.
+func (td *VoterAPI) SetReviewSLA(sla time.Duration) {
+	td.reviews.SetSLA(sla)
+}
+
+// reviewError returns the error response for a failed review queue call
+func reviewError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return apiError(http.StatusNotFound, client.CodeReviewNotFound, "review item not found")
+	case errors.Is(err, db.ErrAlreadyExists):
+		return apiError(http.StatusConflict, client.CodeReviewResolved, err.Error())
+	}
+	return storeError(err)
+}
+
+// callerName is the name of the principal making the request, empty when
+// access control is off
+func callerName(c *fiber.Ctx) string {
+	if caller, ok := c.Locals("principal").(principal); ok {
+		return caller.Name
+	}
+	return ""
+}
+
+// applyHeldChange writes the change a provenance conflict held back, as
+// the reviewer accepted it over the rules.  The field is replaced as a
+// whole, the way the write that was held back would have replaced it.
+func (td *VoterAPI) applyHeldChange(c *fiber.Ctx, item db.ReviewItem) error {
+	var conflict db.ProvenanceConflict
+	if err := json.Unmarshal(item.Detail, &conflict); err != nil {
+		log.Println("Error reading provenance conflict: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	defer td.voterLocks.lock(conflict.VoterId)()
+	voter, err := td.storeFor(c).GetVoter(conflict.VoterId)
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		return lookupError(err, client.CodeVoterNotFound, "voter not found")
+	}
+
+	history := voter.VoteHistory
+	field := reflect.ValueOf(&voter).Elem().FieldByName(conflict.Field)
+	if !field.IsValid() {
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+	field.SetZero()
+	if err := json.Unmarshal(conflict.Proposed, field.Addr().Interface()); err != nil {
+		log.Println("Error reading held back change: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+	if err := validatePayload(voter); err != nil {
+		return err
+	}
+	if err := td.checkAppendOnly(history, voter.VoteHistory); err != nil {
+		return err
+	}
+
+	if err := td.storeFor(c).UpdateVoter(voter); err != nil {
+		log.Println("Error updating voter: ", err)
+		return storeError(err)
+	}
+	td.audit.Record(requestID(c), "provenance.accepted", voter.VoterId,
+		fmt.Sprintf("%s change from %s accepted over %s in review %d", conflict.Field, conflict.Source, conflict.Owner, item.Id))
+
+	return nil
+}
+
+// implementation for GET /admin/reviews
+// returns the review queue oldest first, ?status= (open, assigned or
+// resolved) and ?kind= narrow it down.  Open includes assigned items.
+func (td *VoterAPI) GetReviews(c *fiber.Ctx) error {
+	return c.JSON(td.reviews.GetItems(c.Query("status"), c.Query("kind")))
+}
+
+// implementation for GET /admin/reviews/metrics
+// returns the SLA metrics of the review queue
+func (td *VoterAPI) GetReviewMetrics(c *fiber.Ctx) error {
+	return c.JSON(td.reviews.Metrics())
+}
+
+// implementation for GET /admin/reviews/:id
+// returns a review item with the record that was flagged
+func (td *VoterAPI) GetReview(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	item, err := td.reviews.GetItem(id)
+	if err != nil {
+		log.Println("Review item not found: ", err)
+		return reviewError(err)
+	}
+
+	return c.JSON(item)
+}
+
+// implementation for POST /admin/reviews/:id/assign
+// hands a review item to a reviewer, the caller when the body names
+// nobody
+func (td *VoterAPI) AssignReview(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req reviewAssignment
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			log.Println("Error binding JSON: ", err)
+			return fiber.NewError(http.StatusBadRequest)
+		}
+	}
+	if req.Assignee == "" {
+		req.Assignee = callerName(c)
+	}
+
+	item, err := td.reviews.Assign(id, req.Assignee)
+	if err != nil {
+		log.Println("Error assigning review item: ", err)
+		return reviewError(err)
+	}
+	td.audit.Record(requestID(c), "review.assigned", item.VoterId,
+		fmt.Sprintf("%s review %d assigned to %s", item.Kind, item.Id, item.Assignee))
+
+	return c.JSON(item)
+}
+
+// implementation for POST /admin/reviews/:id/resolve
+// adjudicates a review item, the body has the resolution (accept or
+// reject) and a note.  Accepting a provenance item writes the change that
+// was held back, if the write fails the item stays open.
+func (td *VoterAPI) ResolveReview(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	var req reviewResolution
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Resolution != db.ReviewAccept && req.Resolution != db.ReviewReject {
+		return fiber.NewError(http.StatusBadRequest,
+			fmt.Sprintf("resolution has to be %s or %s", db.ReviewAccept, db.ReviewReject))
+	}
+
+	item, err := td.reviews.GetItem(id)
+	if err != nil {
+		log.Println("Review item not found: ", err)
+		return reviewError(err)
+	}
+	if item.Status == db.ReviewResolved {
+		return apiError(http.StatusConflict, client.CodeReviewResolved,
+			fmt.Sprintf("review item %d was already resolved (%s)", id, item.Resolution))
+	}
+
+	if item.Kind == db.ReviewProvenance && req.Resolution == db.ReviewAccept {
+		if err := td.applyHeldChange(c, item); err != nil {
+			return err
+		}
+	}
+
+	item, err = td.reviews.Resolve(id, req.Resolution, req.Note, callerName(c))
+	if err != nil {
+		log.Println("Error resolving review item: ", err)
+		return reviewError(err)
+	}
+	td.audit.Record(requestID(c), "review.resolved", item.VoterId,
+		fmt.Sprintf("%s review %d resolved: %s", item.Kind, item.Id, item.Resolution))
+
+	return c.JSON(item)
+}
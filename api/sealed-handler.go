@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/bundle"
+	"github.com/adllev/voter-api/sealed"
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportFiles are the routes that serve exports, with the file name the
+// export is packaged under when it is sealed or bundled
+var exportFiles = map[string]string{
+	"/voters/export":               "voter-labels.csv",
+	"/voters/:id<int>/data-export": "voter-data.json",
+	"/admin/export/ballots":        "ballots.json",
+	"/admin/export/voters":         "voter-backup.tar",
+}
+
+// EnableExportSealing turns on sealing of exports, see package sealed.
+// Either file may be "" to only sign or only encrypt.
+func (td *VoterAPI) EnableExportSealing(recipientsFile, signingKeyFile string, passphrase []byte) error {
+	sealer, err := sealed.LoadSealer(recipientsFile, signingKeyFile, passphrase)
+	if err != nil {
+		return err
+	}
+
+	td.sealer = sealer
+	mode := "signed"
+	if sealer.Encrypted() {
+		mode = "encrypted"
+	}
+	td.setFeature("export_sealing", mode)
+	return nil
+}
+
+// SealExports is the middleware that turns export responses into sealed
+// packages.  It runs outside of access control, so what gets sealed is
+// the export as the caller is allowed to see it.
+func (td *VoterAPI) SealExports(c *fiber.Ctx) error {
+	if td.sealer == nil {
+		return c.Next()
+	}
+
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	name, ok := exportFiles[c.Route().Path]
+	if !ok || c.Response().StatusCode() != http.StatusOK {
+		return nil
+	}
+	if string(c.Response().Header.ContentType()) == bundle.ContentType {
+		name = bundleName(name)
+	}
+
+	var pkg bytes.Buffer
+	if err := td.sealer.Seal(&pkg, name, c.Response().Body()); err != nil {
+		log.Println("Error sealing export: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	contentType := "application/pgp-signature"
+	if td.sealer.Encrypted() {
+		contentType = "application/pgp-encrypted"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.asc"`, name))
+	c.Response().SetBodyRaw(pkg.Bytes())
+
+	return nil
+}
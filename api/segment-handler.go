@@ -0,0 +1,199 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxSegmentCounts limits how many points GET /segments/:name/counts returns
+const maxSegmentCounts = 366
+
+// implementation for POST /segments
+// saves a filter as a named segment
+func (td *VoterAPI) PostSegment(c *fiber.Ctx) error {
+	var segment db.Segment
+	if err := c.BodyParser(&segment); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	segment, err := td.segments.AddSegment(segment)
+	if err != nil {
+		log.Println("Error adding segment: ", err)
+		return storeError(err)
+	}
+	td.refreshSegment(segment)
+
+	return c.JSON(segment)
+}
+
+// implementation for GET /segments
+func (td *VoterAPI) ListSegments(c *fiber.Ctx) error {
+	return c.JSON(td.segments.GetAllSegments())
+}
+
+// implementation for GET /segments/:name
+func (td *VoterAPI) GetSegment(c *fiber.Ctx) error {
+	segment, err := td.segments.GetSegment(c.Params("name"))
+	if err != nil {
+		log.Println("Segment not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeSegmentNotFound, "segment not found")
+	}
+
+	return c.JSON(segment)
+}
+
+// implementation for DELETE /segments/:name
+func (td *VoterAPI) DeleteSegment(c *fiber.Ctx) error {
+	if err := td.segments.DeleteSegment(c.Params("name")); err != nil {
+		log.Println("Segment not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeSegmentNotFound, "segment not found")
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// RefreshSegments recomputes the membership of every saved segment whose
+// view is missing or older than the roll
+func (td *VoterAPI) RefreshSegments() {
+	version := td.db.Version()
+	for _, segment := range td.segments.GetAllSegments() {
+		if view, err := td.segments.GetView(segment.Name); err == nil && view.Version == version {
+			continue
+		}
+		td.refreshSegment(segment)
+	}
+}
+
+// refreshSegment recomputes and stores the membership of one segment
+func (td *VoterAPI) refreshSegment(segment db.Segment) (db.SegmentView, error) {
+	view, err := td.db.MaterializeSegment(segment)
+	if err != nil {
+		log.Println("Error refreshing segment: ", err)
+		return db.SegmentView{}, err
+	}
+	if err := td.segments.SetView(view); err != nil {
+		//The segment was deleted while it was being computed
+		return db.SegmentView{}, err
+	}
+
+	return view, nil
+}
+
+// StartSegmentRefresh refreshes the segment views in the background every
+// interval, so segment queries can be answered without scanning the roll
+func (td *VoterAPI) StartSegmentRefresh(interval time.Duration) {
+	td.setFeature("segment_refresh", interval.String())
+	go func() {
+		for range time.Tick(interval) {
+			td.RefreshSegments()
+		}
+	}()
+}
+
+// segmentView returns the stored view of a segment, or a freshly computed
+// one when ?fresh=true is passed or the segment has not been computed yet.
+// The staleness of the view is reported in the response headers.
+func (td *VoterAPI) segmentView(c *fiber.Ctx, segment db.Segment) (db.SegmentView, error) {
+	view, err := td.segments.GetView(segment.Name)
+	if err != nil || c.QueryBool("fresh") {
+		view, err = td.refreshSegment(segment)
+		if err != nil {
+			return db.SegmentView{}, err
+		}
+	}
+
+	stale := view.Version != td.db.Version()
+	c.Set("X-Segment-Refreshed-At", view.RefreshedAt.UTC().Format(time.RFC3339))
+	c.Set("X-Segment-Stale", strconv.FormatBool(stale))
+
+	return view, nil
+}
+
+// implementation for GET /segments/:name/count
+// returns the precomputed size of the segment with its staleness
+func (td *VoterAPI) GetSegmentCount(c *fiber.Ctx) error {
+	segment, err := td.segments.GetSegment(c.Params("name"))
+	if err != nil {
+		log.Println("Segment not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeSegmentNotFound, "segment not found")
+	}
+
+	view, err := td.segmentView(c, segment)
+	if err != nil {
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{
+		"name":         view.Name,
+		"count":        view.Count,
+		"refreshed_at": view.RefreshedAt,
+		"age_seconds":  int(time.Since(view.RefreshedAt).Seconds()),
+		"stale":        view.Version != td.db.Version(),
+	})
+}
+
+// implementation for GET /segments/:name/voters
+// returns the voters in the precomputed segment, see segmentView.  Voters
+// deleted since the last refresh are left out.
+func (td *VoterAPI) ListSegmentVoters(c *fiber.Ctx) error {
+	segment, err := td.segments.GetSegment(c.Params("name"))
+	if err != nil {
+		log.Println("Segment not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeSegmentNotFound, "segment not found")
+	}
+
+	view, err := td.segmentView(c, segment)
+	if err != nil {
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	voterList := make([]db.Voter, 0, len(view.VoterIds))
+	for _, id := range view.VoterIds {
+		if voter, err := td.db.GetVoter(id); err == nil {
+			voterList = append(voterList, voter)
+		}
+	}
+
+	return c.JSON(voterList)
+}
+
+// implementation for GET /segments/:name/counts?from=&to=
+// returns the size of the segment at the end of every day between from
+// and to, both default to today
+func (td *VoterAPI) GetSegmentCounts(c *fiber.Ctx) error {
+	segment, err := td.segments.GetSegment(c.Params("name"))
+	if err != nil {
+		log.Println("Segment not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeSegmentNotFound, "segment not found")
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	from, err := parseAsOf(c.Query("from", today))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	to, err := parseAsOf(c.Query("to", today))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	if to.Before(from) {
+		return fiber.NewError(http.StatusBadRequest, "to must not be before from")
+	}
+
+	var times []time.Time
+	for at := from; !at.After(to); at = at.AddDate(0, 0, 1) {
+		if len(times) == maxSegmentCounts {
+			return fiber.NewError(http.StatusBadRequest, "date range is too long")
+		}
+		times = append(times, at)
+	}
+
+	return c.JSON(td.db.CountsOverTime(segment.Filter, times))
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// selfTestVoterId is the reserved voter id used by the self test, see
+// db.SelfTestVoterId
+const selfTestVoterId = db.SelfTestVoterId
+
+// selfTestJobTimeout is how long the self test waits for its job to run
+const selfTestJobTimeout = 5 * time.Second
+
+// errNotConfigured is returned by a check whose component is turned off
+var errNotConfigured = errors.New("not configured")
+
+// SelfTestResult is the outcome of the self test of one component
+type SelfTestResult struct {
+	Component string
+	Passed    bool
+	Skipped   bool //The component is not configured
+	Error     string
+	Duration  time.Duration
+}
+
+// SelfTest runs a write, read and delete cycle against every configured
+// component and reports the result of each
+func (td *VoterAPI) SelfTest() []SelfTestResult {
+	checks := []struct {
+		component string
+		check     func() error
+	}{
+		{"store", td.selfTestStore},
+		{"coldstore", td.selfTestColdStore},
+		{"jobs", td.selfTestJobs},
+	}
+
+	results := make([]SelfTestResult, 0, len(checks))
+	for _, check := range checks {
+		start := time.Now()
+		err := check.check()
+		result := SelfTestResult{
+			Component: check.component,
+			Passed:    err == nil,
+			Duration:  time.Since(start),
+		}
+		if errors.Is(err, errNotConfigured) {
+			result.Passed, result.Skipped = true, true
+		} else if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// selfTestStore adds, reads and deletes the reserved voter.  A run that
+// stopped half way may have left the voter behind, it is deleted first.
+func (td *VoterAPI) selfTestStore() error {
+	if err := td.db.DeleteVoter(selfTestVoterId); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	voter := db.Voter{VoterId: selfTestVoterId, Name: "Self Test"}
+	if err := td.db.AddVoter(voter); err != nil {
+		return err
+	}
+
+	stored, err := td.db.GetVoter(selfTestVoterId)
+	if err == nil && stored.Name != voter.Name {
+		err = errors.New("read back a different voter")
+	}
+
+	if delErr := td.db.DeleteVoter(selfTestVoterId); err == nil {
+		err = delErr
+	}
+
+	return err
+}
+
+// selfTestColdStore writes, reads and deletes the reserved voter directly
+// in the cold store
+func (td *VoterAPI) selfTestColdStore() error {
+	store := td.db.ColdStore()
+	if store == nil {
+		return errNotConfigured
+	}
+
+	voter := db.Voter{VoterId: selfTestVoterId, Name: "Self Test"}
+	if err := store.Put(voter); err != nil {
+		return err
+	}
+
+	stored, ok, err := store.Get(selfTestVoterId)
+	if err == nil && (!ok || stored.Name != voter.Name) {
+		err = errors.New("read back a different voter")
+	}
+
+	if delErr := store.Delete(selfTestVoterId); err == nil {
+		err = delErr
+	}
+
+	return err
+}
+
+// selfTestJobs runs an empty job through the background queue
+func (td *VoterAPI) selfTestJobs() error {
+	ran := make(chan struct{})
+	td.jobs.Submit("selftest", func() (any, error) {
+		close(ran)
+		return nil, nil
+	})
+
+	select {
+	case <-ran:
+		return nil
+	case <-time.After(selfTestJobTimeout):
+		return errors.New("job did not run in time, the queue may be busy")
+	}
+}
+
+// implementation for POST /admin/selftest
+// runs the self test and reports pass or fail for every component.  It
+// returns 503 if any component failed, so it can gate a deployment.
+func (td *VoterAPI) RunSelfTest(c *fiber.Ctx) error {
+	results := td.SelfTest()
+
+	status := http.StatusOK
+	for _, result := range results {
+		if !result.Passed {
+			log.Println("Self test failed: ", result.Component, result.Error)
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"passed":  status == http.StatusOK,
+		"results": results,
+	})
+}
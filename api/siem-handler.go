@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/siem"
+	"github.com/gofiber/fiber/v2"
+)
+
+// siemBuffer is the number of security events that may wait to be sent
+const siemBuffer = 10000
+
+// voterPath picks the voter id out of a request path
+var voterPath = regexp.MustCompile(`^(?:/kiosk)?/voters/(\d+)`)
+
+// EnableSIEM starts exporting mutation and authentication events to a SIEM
+func (td *VoterAPI) EnableSIEM(dest, format string) error {
+	exporter, err := siem.NewExporter(dest, format, siemBuffer)
+	if err != nil {
+		return err
+	}
+
+	td.siem = exporter
+	td.setFeature("siem", format)
+	return nil
+}
+
+// SecurityEvents is the middleware that reports every mutation and every
+// authentication event to the SIEM.  Rejected credentials and device
+// enrollments are auth events, any other request that is not a plain read
+// is a mutation.  Reads of PII go to the access log instead.
+func (td *VoterAPI) SecurityEvents(c *fiber.Ctx) error {
+	if td.siem == nil {
+		return c.Next()
+	}
+
+	err := c.Next()
+	status := responseStatus(c, err)
+
+	method, path := c.Method(), c.Path()
+	event := siem.Event{
+		Time:     time.Now(),
+		Kind:     siem.KindMutation,
+		Action:   method + " " + path,
+		Outcome:  "success",
+		SourceIP: c.IP(),
+		Method:   method,
+		Path:     path,
+		Status:   status,
+	}
+	if status >= http.StatusBadRequest {
+		event.Outcome = "failure"
+	}
+
+	switch {
+	case c.Locals("blocked") != nil:
+		event.Kind = siem.KindAuth
+		event.Action = "network blocked"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		event.Kind = siem.KindAuth
+		event.Action = "credentials rejected"
+	case path == "/devices/enroll":
+		event.Kind = siem.KindAuth
+		event.Action = "device enrollment"
+	case method == fiber.MethodGet || method == fiber.MethodHead || method == fiber.MethodOptions:
+		return err
+	}
+
+	if device, ok := c.Locals("device").(db.Device); ok {
+		event.Principal = "device:" + device.Name
+	} else if caller, ok := c.Locals("principal").(principal); ok {
+		event.Principal = caller.Name
+	}
+	if m := voterPath.FindStringSubmatch(path); m != nil {
+		event.VoterId, _ = strconv.Atoi(m[1])
+	}
+
+	//Copy what outlives the request, fiber reuses its buffers
+	event.Action = strings.Clone(event.Action)
+	event.Method, event.Path = strings.Clone(method), strings.Clone(path)
+	event.SourceIP = strings.Clone(event.SourceIP)
+
+	td.siem.Export(event)
+
+	return err
+}
+
+// implementation for GET /admin/siem
+// returns the queued, sent, dropped and failed counts of the SIEM export
+func (td *VoterAPI) GetSIEMStats(c *fiber.Ctx) error {
+	if td.siem == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled": true,
+		"stats":   td.siem.Stats(),
+	})
+}
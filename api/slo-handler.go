@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// sloEvaluateInterval is how often the burn rate alerts are evaluated
+// when nobody looks at /admin/slo
+const sloEvaluateInterval = 30 * time.Second
+
+// SLOConfig is the SLO configuration file, for example
+//
+//	{
+//	  "SLOs": [
+//	    {"Name": "voter-reads", "Method": "GET", "Route": "/voters/:id", "Objective": 99.9, "Latency": "50ms"},
+//	    {"Name": "vote-writes", "Method": "POST", "Route": "/voters/:id/polls/:pollid", "Objective": 99.5, "Window": "168h"}
+//	  ]
+//	}
+//
+// An SLO without a Latency only counts server errors as bad requests, one
+// without a Window is measured over 30 days.
+type SLOConfig struct {
+	SLOs []SLOSpec
+}
+
+// SLOSpec is one SLO of the configuration file, durations are written
+// like 50ms or 168h
+type SLOSpec struct {
+	Name      string
+	Method    string
+	Route     string
+	Objective float64
+	Latency   string
+	Window    string
+}
+
+// LoadSLOConfig reads the SLO configuration from a JSON file
+func LoadSLOConfig(path string) (*SLOConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg SLOConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// EnableSLOs starts tracking the configured SLOs and evaluating their burn
+// rate alerts in the background
+func (td *VoterAPI) EnableSLOs(cfg *SLOConfig) error {
+	slos := make([]db.SLO, 0, len(cfg.SLOs))
+	for _, spec := range cfg.SLOs {
+		slo := db.SLO{Name: spec.Name, Method: spec.Method, Route: spec.Route, Objective: spec.Objective}
+		for _, d := range []struct {
+			text  string
+			value *time.Duration
+		}{{spec.Latency, &slo.Latency}, {spec.Window, &slo.Window}} {
+			if d.text == "" {
+				continue
+			}
+			parsed, err := time.ParseDuration(d.text)
+			if err != nil {
+				return fmt.Errorf("SLO %s: %w", spec.Name, err)
+			}
+			*d.value = parsed
+		}
+		slos = append(slos, slo)
+	}
+
+	tracker, err := db.NewSLOTracker(slos)
+	if err != nil {
+		return err
+	}
+
+	td.slos = tracker
+	td.setFeature("slo", len(slos))
+	go func() {
+		for range time.Tick(sloEvaluateInterval) {
+			td.evaluateSLOs()
+		}
+	}()
+	return nil
+}
+
+// evaluateSLOs fires and resolves the burn rate alerts, logging the ones
+// that start firing, and returns the status of every SLO
+func (td *VoterAPI) evaluateSLOs() []db.SLOStatus {
+	statuses, fired := td.slos.Evaluate(time.Now())
+	for _, alert := range fired {
+		log.Printf("SLO alert (%s): %s is burning its error budget %.1fx over %s", alert.Severity, alert.SLO, alert.BurnRate, alert.Window)
+	}
+	return statuses
+}
+
+// TrackSLOs is the middleware that counts every request against the SLOs
+// of its route
+func (td *VoterAPI) TrackSLOs(c *fiber.Ctx) error {
+	if td.slos == nil {
+		return c.Next()
+	}
+
+	start := time.Now()
+	err := c.Next()
+	duration := time.Since(start)
+
+	//Requests that matched no route end on the last middleware
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		return err
+	}
+	td.slos.Observe(c.Method(), route, responseStatus(c, err), duration, start)
+
+	return err
+}
+
+// implementation for GET /admin/slo
+// returns the compliance, remaining error budget, burn rates and firing
+// alerts of every SLO, and the recently resolved alerts
+func (td *VoterAPI) GetSLOs(c *fiber.Ctx) error {
+	if td.slos == nil {
+		return c.JSON(fiber.Map{"enabled": false})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled":  true,
+		"slos":     td.evaluateSLOs(),
+		"resolved": td.slos.ResolvedAlerts(),
+	})
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultSlowThreshold is the latency above which a request is captured
+// unless SetSlowThreshold is called
+const DefaultSlowThreshold = 500 * time.Millisecond
+
+// slowRequestsKept is the size of the slow request ring buffer
+const slowRequestsKept = 200
+
+// maskedValue replaces query values that may hold PII
+const maskedValue = "***"
+
+// safeQueryParams are the query parameters that never carry PII, every
+// other value is masked before a slow request is kept
+var safeQueryParams = map[string]bool{
+	"as_of": true, "depth": true, "format": true, "limit": true,
+	"moved_since": true, "offset": true, "order": true, "poll": true,
+	"precinct": true, "registered_after": true, "registered_before": true,
+	"segment": true, "sort": true, "status": true, "tag": true, "voter": true,
+}
+
+// StoreCall is the timing of one voter store operation
+type StoreCall struct {
+	Op       string
+	Duration time.Duration
+}
+
+// SlowRequest is a request that took longer than the slow threshold
+type SlowRequest struct {
+	Time       time.Time
+	TraceId    string
+	Principal  string
+	Method     string
+	Route      string //Route pattern, e.g. /voters/:id<int>
+	Params     map[string]string
+	Query      map[string]string //PII masked
+	Status     int
+	Duration   time.Duration
+	StoreTime  time.Duration
+	StoreCalls []StoreCall
+}
+
+// slowRequests is the ring buffer of captured slow requests
+type slowRequests struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []SlowRequest
+	next      int
+}
+
+// add keeps a slow request, overwriting the oldest once the buffer is full
+func (s *slowRequests) add(entry SlowRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) < slowRequestsKept {
+		s.entries = append(s.entries, entry)
+		return
+	}
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % slowRequestsKept
+}
+
+// list returns the kept slow requests, newest first
+func (s *slowRequests) list() []SlowRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]SlowRequest, len(s.entries))
+	copy(entries, s.entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+
+	return entries
+}
+
+// timedStore is a VoterStore that records how long each call of one
+// request takes
+type timedStore struct {
+	db.VoterStore
+	calls *[]StoreCall
+}
+
+func (s timedStore) time(op string, start time.Time) {
+	*s.calls = append(*s.calls, StoreCall{Op: op, Duration: time.Since(start)})
+}
+
+func (s timedStore) AddVoter(voter db.Voter) error {
+	defer s.time("AddVoter", time.Now())
+	return s.VoterStore.AddVoter(voter)
+}
+
+func (s timedStore) CreateVoter(voter db.Voter) (int, error) {
+	defer s.time("CreateVoter", time.Now())
+	return s.VoterStore.CreateVoter(voter)
+}
+
+func (s timedStore) GetVoter(id int) (db.Voter, error) {
+	defer s.time("GetVoter", time.Now())
+	return s.VoterStore.GetVoter(id)
+}
+
+func (s timedStore) GetVoterByEmail(email string) (db.Voter, error) {
+	defer s.time("GetVoterByEmail", time.Now())
+	return s.VoterStore.GetVoterByEmail(email)
+}
+
+func (s timedStore) GetAllVoters() ([]db.Voter, error) {
+	defer s.time("GetAllVoters", time.Now())
+	return s.VoterStore.GetAllVoters()
+}
+
+func (s timedStore) UpdateVoter(voter db.Voter) error {
+	defer s.time("UpdateVoter", time.Now())
+	return s.VoterStore.UpdateVoter(voter)
+}
+
+func (s timedStore) DeleteVoter(id int) error {
+	defer s.time("DeleteVoter", time.Now())
+	return s.VoterStore.DeleteVoter(id)
+}
+
+func (s timedStore) DeleteAll() error {
+	defer s.time("DeleteAll", time.Now())
+	return s.VoterStore.DeleteAll()
+}
+
+func (s timedStore) GetVoterPolls(voterID int) ([]db.VoterHistory, error) {
+	defer s.time("GetVoterPolls", time.Now())
+	return s.VoterStore.GetVoterPolls(voterID)
+}
+
+func (s timedStore) GetVoterPoll(voterID, pollID int) (db.VoterHistory, error) {
+	defer s.time("GetVoterPoll", time.Now())
+	return s.VoterStore.GetVoterPoll(voterID, pollID)
+}
+
+func (s timedStore) AddVoterPoll(voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	defer s.time("AddVoterPoll", time.Now())
+	return s.VoterStore.AddVoterPoll(voterID, pollID, vote)
+}
+
+func (s timedStore) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error {
+	defer s.time("UpdateVoterPoll", time.Now())
+	return s.VoterStore.UpdateVoterPoll(voterID, pollID, newVoteDate)
+}
+
+func (s timedStore) DeleteVoterPoll(voterID, pollID int) error {
+	defer s.time("DeleteVoterPoll", time.Now())
+	return s.VoterStore.DeleteVoterPoll(voterID, pollID)
+}
+
+// storeFor returns the voter store to use for a request, bound to the
+// request context when the backend supports it.  It times every call when
+// slow request capture is on.
+func (td *VoterAPI) storeFor(c *fiber.Ctx) db.VoterStore {
+	store := td.store
+	if bound, ok := store.(db.ContextStore); ok {
+		store = bound.WithContext(c.UserContext())
+	}
+	if calls, ok := c.Locals("store_calls").(*[]StoreCall); ok {
+		return timedStore{VoterStore: store, calls: calls}
+	}
+	return store
+}
+
+// SetSlowThreshold sets the latency above which requests are captured, 0
+// turns capturing off.  The default is DefaultSlowThreshold.
+func (td *VoterAPI) SetSlowThreshold(threshold time.Duration) {
+	td.slow.mu.Lock()
+	defer td.slow.mu.Unlock()
+	td.slow.threshold = threshold
+}
+
+// SlowRequests is the middleware that times every request and keeps the
+// ones slower than the threshold in a ring buffer, with the route, the
+// parameters with PII masked, the time spent in the voter store and the
+// trace id that is also sent back as X-Request-ID.
+func (td *VoterAPI) SlowRequests(c *fiber.Ctx) error {
+	td.slow.mu.Lock()
+	threshold := td.slow.threshold
+	td.slow.mu.Unlock()
+	if threshold <= 0 {
+		return c.Next()
+	}
+
+	calls := []StoreCall{}
+	c.Locals("store_calls", &calls)
+	start := time.Now()
+
+	err := c.Next()
+
+	duration := time.Since(start)
+	if duration < threshold {
+		return err
+	}
+
+	//Fiber reuses the request buffers, so copy what outlives the request
+	entry := SlowRequest{
+		Time:       start,
+		TraceId:    requestID(c),
+		Method:     strings.Clone(c.Method()),
+		Route:      strings.Clone(c.Route().Path),
+		Params:     make(map[string]string),
+		Query:      make(map[string]string),
+		Status:     responseStatus(c, err),
+		Duration:   duration,
+		StoreCalls: calls,
+	}
+	if caller, ok := c.Locals("principal").(principal); ok {
+		entry.Principal = caller.Name
+	}
+	for key, value := range c.AllParams() {
+		entry.Params[strings.Clone(key)] = strings.Clone(value)
+	}
+	for key, value := range c.Queries() {
+		if !safeQueryParams[key] {
+			value = maskedValue
+		}
+		entry.Query[strings.Clone(key)] = strings.Clone(value)
+	}
+	for _, call := range calls {
+		entry.StoreTime += call.Duration
+	}
+
+	td.slow.add(entry)
+
+	return err
+}
+
+// implementation for GET /admin/slow-requests
+// returns the captured slow requests, newest first
+func (td *VoterAPI) GetSlowRequests(c *fiber.Ctx) error {
+	td.slow.mu.Lock()
+	threshold := td.slow.threshold
+	td.slow.mu.Unlock()
+
+	return c.JSON(fiber.Map{
+		"threshold": threshold.String(),
+		"requests":  td.slow.list(),
+	})
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// stepUpLifetime is how long a step-up token is good for
+const stepUpLifetime = 5 * time.Minute
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app uses
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 //Periods either side of now that are accepted
+)
+
+// stepUpGrant is an issued step-up token
+type stepUpGrant struct {
+	principal string
+	expires   time.Time
+}
+
+// stepUps holds the step-up tokens that have been issued
+type stepUps struct {
+	mu     sync.Mutex
+	grants map[string]stepUpGrant
+}
+
+// issue creates a step-up token for a principal
+func (s *stepUps) issue(name string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expires := time.Now().Add(stepUpLifetime)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.grants == nil {
+		s.grants = make(map[string]stepUpGrant)
+	}
+	for t, grant := range s.grants {
+		if time.Now().After(grant.expires) {
+			delete(s.grants, t)
+		}
+	}
+	s.grants[token] = stepUpGrant{principal: name, expires: expires}
+
+	return token, expires, nil
+}
+
+// valid reports whether a token was issued to the principal and has not
+// expired
+func (s *stepUps) valid(token, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[token]
+	return ok && grant.principal == name && time.Now().Before(grant.expires)
+}
+
+// totpCode returns the TOTP code of a base32 secret for a time step
+func totpCode(secret string, step int64) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP checks a code against a secret, allowing for a little clock
+// drift between the server and the authenticator
+func verifyTOTP(secret, code string, now time.Time) bool {
+	step := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		want, err := totpCode(secret, step+int64(i))
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// implementation for POST /auth/step-up
+// exchanges a TOTP code from the caller's authenticator, for example
+// {"Code": "123456"}, for a short lived step-up token.  The token goes in
+// the X-Step-Up header of the dangerous operation.
+func (td *VoterAPI) StepUp(c *fiber.Ctx) error {
+	caller, _ := c.Locals("principal").(principal)
+	if td.access == nil || caller.Role == AnonymousRole || caller.Name == "" {
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, "an API key is required")
+	}
+	if caller.totpSecret == "" {
+		return apiError(http.StatusForbidden, client.CodeStepUpRequired, "no second factor is enrolled for this key")
+	}
+
+	var req struct {
+		Code string
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if !verifyTOTP(caller.totpSecret, req.Code, time.Now()) {
+		log.Println("Step-up failed for ", caller.Name)
+		return apiError(http.StatusUnauthorized, client.CodeStepUpRequired, "invalid code")
+	}
+
+	token, expires, err := td.stepUps.issue(caller.Name)
+	if err != nil {
+		log.Println("Error issuing step-up token: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{
+		"token":   token,
+		"expires": expires,
+	})
+}
+
+// RequireStepUp is the middleware for the most dangerous operations.  Even
+// with a valid API key the caller must present a step-up token obtained
+// with a second factor, a TOTP code or a security key, in the X-Step-Up
+// header.  Without access control
+// there are no keys and no second factors, so nothing is enforced.
+func (td *VoterAPI) RequireStepUp(c *fiber.Ctx) error {
+	if err := td.checkStepUp(c); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+// checkStepUp fails the request unless it carries a step-up token issued
+// to the caller, for handlers where only some requests are dangerous
+func (td *VoterAPI) checkStepUp(c *fiber.Ctx) error {
+	if td.access == nil {
+		return nil
+	}
+
+	caller, _ := c.Locals("principal").(principal)
+	if !td.stepUps.valid(c.Get("X-Step-Up"), caller.Name) {
+		return apiError(http.StatusUnauthorized, client.CodeStepUpRequired,
+			"this operation needs a step-up token from POST /auth/step-up or /auth/step-up/webauthn/finish")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// tagRequest is the body of POST /voters/tags.  The tag is applied to the
+// voters in VoterIds, or if no ids are given to every voter matching Filter,
+// applied on top of the saved Segment if one is named.
+type tagRequest struct {
+	Tag      string
+	Action   string //add or remove
+	VoterIds []int
+	Segment  string
+	Filter   db.VoterFilter
+}
+
+// implementation for POST /voters/tags
+// adds or removes a tag on many voters at once.  The work is done by the
+// job queue, the response is the queued job which can be followed on
+// GET /jobs/:id
+func (td *VoterAPI) BulkTagVoters(c *fiber.Ctx) error {
+	var req tagRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Tag == "" {
+		return fiber.NewError(http.StatusBadRequest, "tag is required")
+	}
+	if req.Action != "add" && req.Action != "remove" {
+		return fiber.NewError(http.StatusBadRequest, "action must be add or remove")
+	}
+
+	filter, err := td.segmentFilter(req.Segment, req.Filter)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	job := td.jobs.Submit("voters.tag", func() (any, error) {
+		ids := req.VoterIds
+		if len(ids) == 0 {
+			voterList, err := td.db.FindVoters(filter)
+			if err != nil {
+				return nil, err
+			}
+			for _, voter := range voterList {
+				ids = append(ids, voter.VoterId)
+			}
+		}
+
+		defer td.voterLocks.lockAll()()
+		result, err := td.db.TagVoters(ids, req.Tag, req.Action == "add")
+		if err != nil {
+			return nil, err
+		}
+
+		missing := make(map[int]bool, len(result.Missing))
+		for _, id := range result.Missing {
+			missing[id] = true
+		}
+		tagged := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if !missing[id] {
+				tagged = append(tagged, id)
+			}
+		}
+		if err := td.persist(context.Background(), tagged...); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	})
+
+	return c.Status(http.StatusAccepted).JSON(job)
+}
+
+// implementation for GET /jobs
+func (td *VoterAPI) ListJobs(c *fiber.Ctx) error {
+	return c.JSON(td.jobs.GetAll())
+}
+
+// implementation for GET /jobs/:id
+// returns the state of a background job and its result once it is done
+func (td *VoterAPI) GetJob(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	job, err := td.jobs.Get(id)
+	if err != nil {
+		log.Println("Job not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeJobNotFound, "job not found")
+	}
+
+	return c.JSON(job)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// previewRequest is the body of POST /admin/templates/:name/preview
+type previewRequest struct {
+	Channel string
+	VoterId int //Voter to render for, a sample voter when 0
+	Version int //Template version, the latest when 0
+}
+
+// sampleVoter is who a template preview is rendered for when no voter is
+// given
+var sampleVoter = db.Voter{
+	VoterId: 1,
+	Name:    "Jane Smith",
+	Email:   "jane@example.com",
+	Address: db.Address{Street: "1 Main St", City: "Springfield", State: "IL", Zip: "62701"},
+}
+
+// templateData collects the values a template is rendered with for a
+// voter, including the polling place of the voter's precinct
+func (td *VoterAPI) templateData(voter db.Voter) db.TemplateData {
+	place, _ := td.places.FindByPrecinct(voter.PrecinctId)
+	return db.TemplateData{
+		Voter:        voter,
+		PollingPlace: place,
+		Now:          time.Now(),
+	}
+}
+
+// templateRenderer renders one channel of a template for campaign
+// recipients
+func (td *VoterAPI) templateRenderer(tmpl db.Template, channel string) db.Renderer {
+	return func(voter db.Voter) (db.RenderedMessage, error) {
+		return tmpl.Render(channel, td.templateData(voter))
+	}
+}
+
+// implementation for POST /admin/templates
+// saves the first version of a template
+func (td *VoterAPI) PostTemplate(c *fiber.Ctx) error {
+	var tmpl db.Template
+	if err := c.BodyParser(&tmpl); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	tmpl, err := td.templates.AddTemplate(tmpl)
+	if err != nil {
+		log.Println("Error adding template: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(tmpl)
+}
+
+// implementation for GET /admin/templates
+// returns the latest version of every template
+func (td *VoterAPI) ListTemplates(c *fiber.Ctx) error {
+	return c.JSON(td.templates.GetAllTemplates())
+}
+
+// implementation for GET /admin/templates/:name?version=
+// returns a template, the latest version unless ?version= asks for an
+// earlier one
+func (td *VoterAPI) GetTemplate(c *fiber.Ctx) error {
+	tmpl, err := td.templates.GetTemplate(c.Params("name"), c.QueryInt("version", 0))
+	if err != nil {
+		log.Println("Template not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeTemplateNotFound, err.Error())
+	}
+
+	return c.JSON(tmpl)
+}
+
+// implementation for PUT /admin/templates/:name
+// saves a new version of a template, campaigns already created keep the
+// version they were sent
+func (td *VoterAPI) UpdateTemplate(c *fiber.Ctx) error {
+	var tmpl db.Template
+	if err := c.BodyParser(&tmpl); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	tmpl.Name = c.Params("name")
+
+	if _, err := td.templates.GetTemplate(tmpl.Name, 0); err != nil {
+		log.Println("Template not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeTemplateNotFound, "template not found")
+	}
+
+	tmpl, err := td.templates.UpdateTemplate(tmpl)
+	if err != nil {
+		log.Println("Error updating template: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(tmpl)
+}
+
+// implementation for DELETE /admin/templates/:name
+func (td *VoterAPI) DeleteTemplate(c *fiber.Ctx) error {
+	if err := td.templates.DeleteTemplate(c.Params("name")); err != nil {
+		log.Println("Template not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeTemplateNotFound, "template not found")
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for GET /admin/templates/:name/versions
+// returns every version of a template, oldest first
+func (td *VoterAPI) GetTemplateVersions(c *fiber.Ctx) error {
+	versions, err := td.templates.GetTemplateVersions(c.Params("name"))
+	if err != nil {
+		log.Println("Template not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeTemplateNotFound, "template not found")
+	}
+
+	return c.JSON(versions)
+}
+
+// implementation for POST /admin/templates/:name/preview
+// renders a template for a voter, or for a sample voter, without sending
+// anything
+func (td *VoterAPI) PreviewTemplate(c *fiber.Ctx) error {
+	var req previewRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if req.Channel == "" {
+		req.Channel = db.TemplateEmail
+	}
+
+	tmpl, err := td.templates.GetTemplate(c.Params("name"), req.Version)
+	if err != nil {
+		log.Println("Template not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeTemplateNotFound, err.Error())
+	}
+
+	voter := sampleVoter
+	if req.VoterId != 0 {
+		voter, err = td.db.GetVoter(req.VoterId)
+		if err != nil {
+			log.Println("Voter not found: ", err)
+			return lookupError(err, client.CodeVoterNotFound, "voter not found")
+		}
+	}
+
+	message, err := tmpl.Render(req.Channel, td.templateData(voter))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(message)
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bounds on the lifetime of a scoped token
+const (
+	defaultTokenLifetime = 15 * time.Minute
+	maxTokenLifetime     = 12 * time.Hour //A field device's shift
+)
+
+// tokenRequest is the body of POST /auth/token.  Every limit narrows the
+// token further than the key that mints it, none can widen it.
+type tokenRequest struct {
+	VoterIds    []int  //Voters the token may see and change, empty for any
+	PrecinctIds []int  //Precincts the token is limited to, empty for those of the key
+	ReadOnly    bool   //Only GET and HEAD requests
+	TTL         string //Lifetime, e.g. 30m, 15m if empty
+}
+
+// tokenGrant is an issued scoped token
+type tokenGrant struct {
+	key       string       //Name of the API key that minted the token
+	voters    map[int]bool //nil for any voter
+	precincts map[int]bool //nil for the precincts of the key
+	readOnly  bool
+	expires   time.Time
+}
+
+// scopedTokens holds the scoped tokens that have been issued
+type scopedTokens struct {
+	mu     sync.Mutex
+	grants map[string]tokenGrant
+}
+
+// issue creates a token for a grant
+func (s *scopedTokens) issue(grant tokenGrant) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.grants == nil {
+		s.grants = make(map[string]tokenGrant)
+	}
+	for t, g := range s.grants {
+		if time.Now().After(g.expires) {
+			delete(s.grants, t)
+		}
+	}
+	s.grants[token] = grant
+
+	return token, nil
+}
+
+// lookup returns the grant of a token that has not expired
+func (s *scopedTokens) lookup(token string) (tokenGrant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[token]
+	if !ok || time.Now().After(grant.expires) {
+		return tokenGrant{}, false
+	}
+	return grant, true
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// tokenPrincipal returns the caller of a request made with a scoped
+// token.  The token acts as the key that minted it, within its limits, and
+// stops working as soon as that key is revoked, expires or is deleted.
+func (td *VoterAPI) tokenPrincipal(c *fiber.Ctx, token string) (principal, error) {
+	grant, ok := td.tokens.lookup(token)
+	if !ok {
+		return principal{}, fiber.NewError(http.StatusUnauthorized)
+	}
+	key, err := td.apiKeys.GetKey(grant.key)
+	if err != nil || key.Usable(time.Now()) != nil {
+		return principal{}, fiber.NewError(http.StatusUnauthorized)
+	}
+	if err := checkKeyScopes(c, key); err != nil {
+		return principal{}, err
+	}
+	if grant.readOnly && !isRead(c) {
+		return principal{}, apiError(http.StatusForbidden, client.CodeForbidden, "the token is read only")
+	}
+
+	caller := principal{Name: key.Name, Role: key.Role, Source: key.Source, key: key.Name, token: true,
+		scope: td.jurisdictionScope(key), voters: grant.voters}
+	if caller.Source == "" {
+		caller.Source = key.Name
+	}
+	if grant.precincts != nil {
+		scope := make(map[int]bool)
+		for precinct := range grant.precincts {
+			if caller.scope == nil || caller.scope[precinct] {
+				scope[precinct] = true
+			}
+		}
+		caller.scope = scope
+	}
+
+	return caller, nil
+}
+
+// implementation for POST /auth/token
+// mints a short lived token from the caller's API key, for example
+// {"VoterIds": [12], "ReadOnly": true, "TTL": "30m"}, to hand to a
+// browser session or a field device instead of the key.  The token goes in
+// an Authorization: Bearer header and can do no more than the key, a token
+// can not mint other tokens.
+func (td *VoterAPI) PostToken(c *fiber.Ctx) error {
+	caller, _ := c.Locals("principal").(principal)
+	if td.access == nil || caller.key == "" {
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, "an API key is required")
+	}
+	if caller.token {
+		return apiError(http.StatusForbidden, client.CodeForbidden, "a token can not mint tokens")
+	}
+
+	var req tokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	lifetime := defaultTokenLifetime
+	if req.TTL != "" {
+		var err error
+		if lifetime, err = time.ParseDuration(req.TTL); err != nil || lifetime <= 0 {
+			return fiber.NewError(http.StatusBadRequest, "TTL must be a duration, e.g. 30m")
+		}
+	}
+	if lifetime > maxTokenLifetime {
+		return fiber.NewError(http.StatusBadRequest, fmt.Sprintf("a token lives at most %s", maxTokenLifetime))
+	}
+
+	grant := tokenGrant{key: caller.key, readOnly: req.ReadOnly, expires: time.Now().Add(lifetime)}
+	if len(req.VoterIds) > 0 {
+		grant.voters = make(map[int]bool)
+		for _, id := range req.VoterIds {
+			grant.voters[id] = true
+		}
+	}
+	if len(req.PrecinctIds) > 0 {
+		grant.precincts = make(map[int]bool)
+		for _, precinct := range req.PrecinctIds {
+			if caller.scope != nil && !caller.scope[precinct] {
+				return apiError(http.StatusForbidden, client.CodeForbidden,
+					fmt.Sprintf("precinct %d is outside your jurisdictions", precinct))
+			}
+			grant.precincts[precinct] = true
+		}
+	}
+
+	token, err := td.tokens.issue(grant)
+	if err != nil {
+		log.Println("Error issuing token: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+	td.audit.Record(requestID(c), "token.issued", 0,
+		fmt.Sprintf("token of API key %s until %s", caller.key, grant.expires.Format(time.RFC3339)))
+
+	return c.JSON(fiber.Map{
+		"token":   token,
+		"expires": grant.expires,
+	})
+}
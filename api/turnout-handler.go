@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// validateHistory checks the optional metadata of history entries.  The
+// channel must be a known one, a device must be registered and not
+// revoked, and a precinct must be served by a polling place.  A kiosk is
+// tied to its precinct, so the two have to agree when both are given.
+func (td *VoterAPI) validateHistory(histories []db.VoterHistory) error {
+	for _, history := range histories {
+		if err := db.ValidChannel(history.Channel); err != nil {
+			return err
+		}
+
+		if history.PrecinctId != 0 {
+			if _, err := td.places.FindByPrecinct(history.PrecinctId); err != nil {
+				return fmt.Errorf("precinct %d is not served by any polling place", history.PrecinctId)
+			}
+		}
+
+		if history.DeviceId != 0 {
+			device, err := td.devices.GetDevice(history.DeviceId)
+			if err != nil || device.Revoked {
+				return fmt.Errorf("device %d is not a registered device", history.DeviceId)
+			}
+			if device.Kind == db.DeviceKiosk && history.PrecinctId != 0 &&
+				device.PrecinctId != history.PrecinctId {
+				return fmt.Errorf("device %d is assigned to precinct %d", device.DeviceId, device.PrecinctId)
+			}
+		}
+	}
+
+	return nil
+}
+
+// changedHistory returns the entries of updated whose metadata is not
+// already stored for the same poll
+func changedHistory(stored, updated []db.VoterHistory) []db.VoterHistory {
+	var changed []db.VoterHistory
+	for _, history := range updated {
+		found := false
+		for _, old := range stored {
+			if old.PollId == history.PollId && old.Channel == history.Channel &&
+				old.DeviceId == history.DeviceId && old.PrecinctId == history.PrecinctId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			changed = append(changed, history)
+		}
+	}
+
+	return changed
+}
+
+// changedDates returns the entries of updated whose vote date is not
+// already stored for the same poll, entries stored before the vote date
+// window was enforced must not block unrelated updates
+func changedDates(stored, updated []db.VoterHistory) []db.VoterHistory {
+	var changed []db.VoterHistory
+	for _, history := range updated {
+		found := false
+		for _, old := range stored {
+			if old.PollId == history.PollId && old.VoteDate.Equal(history.VoteDate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			changed = append(changed, history)
+		}
+	}
+
+	return changed
+}
+
+// changedPolls returns the polls whose vote an update of a history adds,
+// removes or changes, in order
+func changedPolls(stored, updated []db.VoterHistory) []int {
+	seen := make(map[int]bool)
+	var polls []int
+	for _, entries := range [][]db.VoterHistory{
+		changedHistory(stored, updated),
+		changedDates(stored, updated),
+		changedHistory(updated, stored), //The entries that are removed
+	} {
+		for _, entry := range entries {
+			if !seen[entry.PollId] {
+				seen[entry.PollId] = true
+				polls = append(polls, entry.PollId)
+			}
+		}
+	}
+	sort.Ints(polls)
+
+	return polls
+}
+
+// SetVoteDateWindow sets the range of vote dates history entries may
+// carry.  The default is db.DefaultVoteDateWindow.
+func (td *VoterAPI) SetVoteDateWindow(window db.VoteDateWindow) {
+	td.voteDates = window
+}
+
+// checkVoteDates rejects history entries without a vote date or with one
+// outside the vote date window
+func (td *VoterAPI) checkVoteDates(histories []db.VoterHistory) error {
+	now := time.Now()
+	for _, history := range histories {
+		if err := td.voteDates.Check(history.VoteDate, now); err != nil {
+			return apiError(http.StatusBadRequest, client.CodeInvalidVoteDate,
+				fmt.Sprintf("poll %d: %v", history.PollId, err))
+		}
+	}
+
+	return nil
+}
+
+// implementation for GET /polls/:id/turnout
+// returns the votes cast in a poll broken down by channel and precinct
+func (td *VoterAPI) GetTurnout(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	return c.JSON(td.db.GetTurnout(id))
+}
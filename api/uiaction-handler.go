@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxUIContext is the largest X-UI-Context header that is kept
+const maxUIContext = 4096
+
+// requestID returns the id of the request, set by the requestid
+// middleware from the X-Request-ID header or generated
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return strings.Clone(id)
+}
+
+// uiActionView is a UI action along with the audit entries it wrote
+type uiActionView struct {
+	db.UIAction
+	Audit []db.AuditEntry
+}
+
+// UIActions is the middleware that records every request made by the
+// admin UI.  The UI marks its requests with X-UI-Screen, naming the screen
+// the operator was on, X-UI-Session, naming the browser tab, and
+// X-UI-Context, a JSON object with the filters and selection that led to
+// the request.  Requests without X-UI-Screen are not recorded.
+func (td *VoterAPI) UIActions(c *fiber.Ctx) error {
+	screen := c.Get("X-UI-Screen")
+	if screen == "" {
+		return c.Next()
+	}
+
+	action := db.UIAction{
+		Time:      time.Now(),
+		RequestId: requestID(c),
+		Session:   strings.Clone(c.Get("X-UI-Session")),
+		Screen:    strings.Clone(screen),
+		Method:    strings.Clone(c.Method()),
+		URL:       strings.Clone(c.OriginalURL()),
+	}
+	if caller, ok := c.Locals("principal").(principal); ok {
+		action.Principal = caller.Name
+	}
+
+	//A bad context header must never fail the operation itself, the
+	//action is recorded without it
+	if raw := c.Get("X-UI-Context"); raw != "" {
+		if len(raw) > maxUIContext {
+			log.Println("UI context too large, dropped: ", len(raw))
+		} else if err := json.Unmarshal([]byte(raw), &action.Context); err != nil {
+			log.Println("Error parsing UI context: ", err)
+		}
+	}
+
+	err := c.Next()
+	action.Status = responseStatus(c, err)
+	td.uiActions.Record(action)
+
+	return err
+}
+
+// implementation for GET /admin/ui-actions
+// returns the admin UI actions, oldest first, each with the audit log
+// entries it wrote.  Filter with ?session= or ?principal=.
+func (td *VoterAPI) GetUIActions(c *fiber.Ctx) error {
+	actions := td.uiActions.GetActions(c.Query("session"), c.Query("principal"))
+
+	views := make([]uiActionView, 0, len(actions))
+	for _, action := range actions {
+		views = append(views, uiActionView{
+			UIAction: action,
+			Audit:    td.audit.GetRequestEntries(action.RequestId),
+		})
+	}
+
+	return c.JSON(views)
+}
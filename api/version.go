@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The API versions differ in how JSON bodies name their fields.  Version
+// 1, the default, uses the Go field names (VoterId, VoteHistory), version
+// 2 the canonical snake case names (voter_id, vote_history).  Handlers
+// only deal in version 1 names, NegotiateVersion renames the fields of
+// version 2 bodies on the way in and out.
+const (
+	apiVersionLegacy    = "1"
+	apiVersionCanonical = "2"
+)
+
+// versionHeader is the request header a client asks for an API version
+// with, the response carries the version it was served in
+const versionHeader = "API-Version"
+
+// unversionedPrefixes are the paths whose bodies keep their names in every
+// version, GraphQL has names of its own
+var unversionedPrefixes = []string{"/graphql"}
+
+// fieldAcronyms are the acronyms field names spell in capitals, so APIKey
+// is api_key in version 2 and turns back into APIKey
+var fieldAcronyms = map[string]string{
+	"api": "API", "cidr": "CIDR", "ip": "IP", "sla": "SLA", "slo": "SLO", "totp": "TOTP", "ttl": "TTL", "url": "URL",
+}
+
+var (
+	legacyNamePattern    = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+	canonicalNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+)
+
+// canonicalName returns the version 2 name of a field, e.g. voter_id for
+// VoterId.  Keys that are not Go field names are left alone.
+func canonicalName(name string) string {
+	if !legacyNamePattern.MatchString(name) {
+		return name
+	}
+
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			//A word starts after a lower case letter or a digit, or at the
+			//last capital of an acronym followed by lower case, APIKey
+			prev := runes[i-1]
+			if !unicode.IsUpper(prev) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// legacyName returns the version 1 name of a field, e.g. VoterId for
+// voter_id.  Keys that are not canonical names are left alone, so a
+// version 2 body may still use the version 1 names.
+func legacyName(name string) string {
+	if !canonicalNamePattern.MatchString(name) {
+		return name
+	}
+
+	var b strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		if acronym, ok := fieldAcronyms[word]; ok {
+			b.WriteString(acronym)
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// renameJSON renames the object keys of a JSON document, values and the
+// order of the members are kept as they are
+func renameJSON(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	//The open objects and arrays, with the number of tokens written to
+	//each, keys and values alternate in an object
+	type container struct {
+		object bool
+		tokens int
+	}
+	var open []container
+	var out bytes.Buffer
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			out.WriteRune(rune(delim))
+			open = open[:len(open)-1]
+			continue
+		}
+
+		isKey := false
+		if n := len(open); n > 0 {
+			top := &open[n-1]
+			isKey = top.object && top.tokens%2 == 0
+			switch {
+			case top.object && !isKey:
+				out.WriteByte(':')
+			case top.tokens > 0:
+				out.WriteByte(',')
+			}
+			top.tokens++
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			out.WriteRune(rune(value))
+			open = append(open, container{object: value == '{'})
+		case json.Number:
+			out.WriteString(value.String())
+		case string:
+			if isKey {
+				value = rename(value)
+			}
+			encoded, _ := json.Marshal(value)
+			out.Write(encoded)
+		default:
+			encoded, _ := json.Marshal(value)
+			out.Write(encoded)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// isJSON reports whether a content type is JSON, including the +json
+// types such as application/merge-patch+json
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == fiber.MIMEApplicationJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// apiVersion returns the API version a request is served in
+func apiVersion(c *fiber.Ctx) string {
+	if version, ok := c.Locals("apiversion").(string); ok {
+		return version
+	}
+	return apiVersionLegacy
+}
+
+// encodeVersioned encodes a value as JSON with the field names of an API
+// version, for bodies that do not go through NegotiateVersion, e.g. the
+// messages of the live streams
+func encodeVersioned(version string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || version != apiVersionCanonical {
+		return data, err
+	}
+	return renameJSON(data, canonicalName)
+}
+
+// NegotiateVersion is the middleware that serves the API version a client
+// asks for with the API-Version header, version 1 when it asks for none.
+// It runs ahead of every other middleware, so they and the handlers only
+// see version 1 bodies.  Exports that are sealed or bundled keep their
+// file formats in every version.
+func (td *VoterAPI) NegotiateVersion(c *fiber.Ctx) error {
+	version := c.Get(versionHeader, apiVersionLegacy)
+	if !slices.Contains(apiVersions, version) {
+		return apiError(http.StatusBadRequest, client.CodeInvalidRequest,
+			fmt.Sprintf("unknown API version %s, this server serves %s", version, strings.Join(apiVersions, ", ")))
+	}
+	c.Set(versionHeader, version)
+	c.Vary(versionHeader)
+	if version == apiVersionLegacy {
+		return c.Next()
+	}
+	for _, prefix := range unversionedPrefixes {
+		if strings.HasPrefix(c.Path(), prefix) {
+			return c.Next()
+		}
+	}
+	c.Locals("apiversion", version)
+
+	//A body that is not valid JSON is passed on as it is, for the
+	//handler to refuse
+	if body := c.Body(); len(body) > 0 && isJSON(c.Get(fiber.HeaderContentType)) {
+		if renamed, err := renameJSON(body, legacyName); err == nil {
+			c.Request().SetBody(renamed)
+		}
+	}
+
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	rsp := c.Response()
+	if rsp.StatusCode() == http.StatusSwitchingProtocols || rsp.IsBodyStream() || !isJSON(string(rsp.Header.ContentType())) {
+		return nil
+	}
+	renamed, err := renameJSON(rsp.Body(), canonicalName)
+	if err != nil {
+		log.Println("Error renaming response fields: ", err)
+		return nil
+	}
+	rsp.SetBodyRaw(renamed)
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package api
+
+import "github.com/adllev/voter-api/db"
+
+// SetVoteIdScope sets what VoteIds are unique within, the votes of one
+// voter or all votes.  The default is db.VoteIdsPerVoter.
+func (td *VoterAPI) SetVoteIdScope(scope db.VoteIdScope) {
+	td.voteIds.SetScope(scope)
+	td.setFeature("vote_ids", string(scope))
+}
+
+// numberVotes gives the entries of a voter's new history their VoteIds.
+// An entry for a poll the stored history has keeps the stored id, any
+// other entry is given the next id of the sequence, whatever id the
+// client sent, so ids are unique and never change once handed out.
+func (td *VoterAPI) numberVotes(stored []db.VoterHistory, voter *db.Voter) {
+	td.voteIds.Observe(voter.VoterId, stored)
+
+	for i := range voter.VoteHistory {
+		voter.VoteHistory[i].VoteId = 0
+		for _, old := range stored {
+			if old.PollId == voter.VoteHistory[i].PollId {
+				voter.VoteHistory[i].VoteId = old.VoteId
+				break
+			}
+		}
+	}
+	td.voteIds.Assign(voter)
+}
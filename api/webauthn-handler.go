@@ -0,0 +1,433 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ceremonyLifetime is how long the browser has to answer a registration or
+// login challenge
+const ceremonyLifetime = 5 * time.Minute
+
+// webauthnUser adapts an admin user to the webauthn.User interface
+type webauthnUser struct {
+	db.AdminUser
+}
+
+func (u webauthnUser) WebAuthnID() []byte          { return u.Handle }
+func (u webauthnUser) WebAuthnName() string        { return u.Name }
+func (u webauthnUser) WebAuthnDisplayName() string { return u.DisplayName }
+func (u webauthnUser) WebAuthnIcon() string        { return "" }
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.Credentials))
+	for _, c := range u.Credentials {
+		creds = append(creds, c.Credential)
+	}
+	return creds
+}
+
+// ceremony is a registration or login that has been started but not
+// finished, the session data holds the challenge the browser must sign
+type ceremony struct {
+	session webauthn.SessionData
+	label   string
+}
+
+// ceremonies holds the outstanding ceremonies, at most one of each kind
+// per user.  Starting a new one replaces the old challenge.
+type ceremonies struct {
+	mu      sync.Mutex
+	pending map[string]ceremony
+}
+
+// put stores a ceremony under a key such as "register:3"
+func (s *ceremonies) put(key string, cer ceremony) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == nil {
+		s.pending = make(map[string]ceremony)
+	}
+	s.pending[key] = cer
+}
+
+// take removes a ceremony and returns it, challenges are single use
+func (s *ceremonies) take(key string) (ceremony, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cer, ok := s.pending[key]
+	delete(s.pending, key)
+	if ok && time.Now().After(cer.session.Expires) {
+		return ceremony{}, false
+	}
+	return cer, ok
+}
+
+// EnableWebAuthn turns on passwordless login for admin users.  rpID is the
+// domain the admin UI is served from and origins are the full origins the
+// browser will report, for example https://admin.county.gov.
+func (td *VoterAPI) EnableWebAuthn(rpID string, origins []string) error {
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Voter API",
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyLifetime},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyLifetime},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	td.webauthn = wa
+	td.setFeature("webauthn", rpID)
+	return nil
+}
+
+// requireWebAuthn fails the request when WebAuthn is not configured
+func (td *VoterAPI) requireWebAuthn() error {
+	if td.webauthn == nil {
+		return apiError(http.StatusNotFound, client.CodeNotFound, "WebAuthn is not enabled")
+	}
+	return nil
+}
+
+// userParam returns the admin user named by the :id route parameter
+func (td *VoterAPI) userParam(c *fiber.Ctx) (db.AdminUser, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return db.AdminUser{}, fiber.NewError(http.StatusBadRequest)
+	}
+
+	user, err := td.users.GetUser(id)
+	if err != nil {
+		return db.AdminUser{}, apiError(http.StatusNotFound, client.CodeUserNotFound, err.Error())
+	}
+
+	return user, nil
+}
+
+// implementation for POST /admin/users
+// creates an admin user, for example {"Name": "jdoe", "Role": "registrar"}.
+// The user can not log in until a credential has been registered.
+func (td *VoterAPI) PostUser(c *fiber.Ctx) error {
+	var user db.AdminUser
+	if err := c.BodyParser(&user); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	user, err := td.users.AddUser(user)
+	if err != nil {
+		log.Println("Error adding admin user: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(user)
+}
+
+// implementation for GET /admin/users
+func (td *VoterAPI) ListUsers(c *fiber.Ctx) error {
+	return c.JSON(td.users.GetAllUsers())
+}
+
+// implementation for GET /admin/users/:id
+func (td *VoterAPI) GetUser(c *fiber.Ctx) error {
+	user, err := td.userParam(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(user)
+}
+
+// implementation for GET /admin/users/:id/credentials
+func (td *VoterAPI) ListCredentials(c *fiber.Ctx) error {
+	user, err := td.userParam(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(user.Credentials)
+}
+
+// implementation for POST /admin/users/:id/credentials/begin
+// starts registering a security key or passkey.  The optional body gives
+// the credential a label, for example {"Label": "yubikey"}.  The response
+// is the PublicKeyCredentialCreationOptions to pass to
+// navigator.credentials.create().
+func (td *VoterAPI) BeginCredential(c *fiber.Ctx) error {
+	if err := td.requireWebAuthn(); err != nil {
+		return err
+	}
+	user, err := td.userParam(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Label string
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			log.Println("Error binding body: ", err)
+			return fiber.NewError(http.StatusBadRequest)
+		}
+	}
+
+	//Keys the user already has are excluded so the same key can not be
+	//registered twice
+	exclude := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+	for _, cred := range user.Credentials {
+		exclude = append(exclude, cred.Credential.Descriptor())
+	}
+
+	options, session, err := td.webauthn.BeginRegistration(webauthnUser{user},
+		webauthn.WithExclusions(exclude))
+	if err != nil {
+		log.Println("Error starting WebAuthn registration: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	td.ceremonies.put("register:"+c.Params("id"), ceremony{session: *session, label: req.Label})
+
+	return c.JSON(options)
+}
+
+// implementation for POST /admin/users/:id/credentials/finish
+// completes the registration, the body is the PublicKeyCredential returned
+// by navigator.credentials.create()
+func (td *VoterAPI) FinishCredential(c *fiber.Ctx) error {
+	if err := td.requireWebAuthn(); err != nil {
+		return err
+	}
+	user, err := td.userParam(c)
+	if err != nil {
+		return err
+	}
+
+	cer, ok := td.ceremonies.take("register:" + c.Params("id"))
+	if !ok {
+		return fiber.NewError(http.StatusBadRequest, "no registration in progress")
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(c.Body()))
+	if err != nil {
+		log.Println("Error parsing WebAuthn credential: ", err)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	cred, err := td.webauthn.CreateCredential(webauthnUser{user}, cer.session, parsed)
+	if err != nil {
+		log.Println("Error verifying WebAuthn credential: ", err)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	ac, err := td.users.AddCredential(user.UserId, cer.label, *cred)
+	if err != nil {
+		log.Println("Error adding credential: ", err)
+		return storeError(err)
+	}
+
+	return c.JSON(ac)
+}
+
+// implementation for DELETE /admin/users/:id/credentials/:credid
+func (td *VoterAPI) DeleteCredential(c *fiber.Ctx) error {
+	user, err := td.userParam(c)
+	if err != nil {
+		return err
+	}
+
+	if err := td.users.RemoveCredential(user.UserId, c.Params("credid")); err != nil {
+		return apiError(http.StatusNotFound, client.CodeCredentialNotFound, err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /auth/webauthn/login/begin
+// starts a login for an admin user, for example {"Name": "jdoe"}.  The
+// response is the PublicKeyCredentialRequestOptions to pass to
+// navigator.credentials.get().
+func (td *VoterAPI) BeginLogin(c *fiber.Ctx) error {
+	if err := td.requireWebAuthn(); err != nil {
+		return err
+	}
+
+	var req struct {
+		Name string
+	}
+	if err := c.BodyParser(&req); err != nil {
+		log.Println("Error binding body: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	//Unknown users and users without keys get the same answer so the
+	//endpoint can not be used to find out who has an account
+	user, err := td.users.GetUserByName(req.Name)
+	if err != nil || len(user.Credentials) == 0 {
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, "no credentials registered")
+	}
+
+	options, session, err := td.webauthn.BeginLogin(webauthnUser{user})
+	if err != nil {
+		log.Println("Error starting WebAuthn login: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	td.ceremonies.put("login:"+user.Name, ceremony{session: *session})
+
+	return c.JSON(options)
+}
+
+// implementation for POST /auth/webauthn/login/finish?name=jdoe
+// completes the login, the body is the PublicKeyCredential returned by
+// navigator.credentials.get().  The response carries a session token that
+// goes in the X-Session-Token header of later requests.
+func (td *VoterAPI) FinishLogin(c *fiber.Ctx) error {
+	if err := td.requireWebAuthn(); err != nil {
+		return err
+	}
+
+	user, err := td.users.GetUserByName(c.Query("name"))
+	if err != nil {
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, "login failed")
+	}
+
+	cer, ok := td.ceremonies.take("login:" + user.Name)
+	if !ok {
+		return fiber.NewError(http.StatusBadRequest, "no login in progress")
+	}
+
+	if err := td.verifyAssertion(c, user, cer, "login failed"); err != nil {
+		return err
+	}
+
+	token, expires, err := td.users.StartSession(user.UserId)
+	if err != nil {
+		log.Println("Error starting session: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{
+		"token":   token,
+		"expires": expires,
+		"user":    user.Name,
+		"role":    user.Role,
+	})
+}
+
+// verifyAssertion checks the PublicKeyCredential in the body against the
+// challenge of a ceremony and records the use of the credential.  failed
+// is the message the caller gets when the key is not accepted.
+func (td *VoterAPI) verifyAssertion(c *fiber.Ctx, user db.AdminUser, cer ceremony, failed string) error {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(c.Body()))
+	if err != nil {
+		log.Println("Error parsing WebAuthn assertion: ", err)
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	cred, err := td.webauthn.ValidateLogin(webauthnUser{user}, cer.session, parsed)
+	if err != nil {
+		log.Println("WebAuthn assertion failed for ", user.Name, ": ", err)
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, failed)
+	}
+
+	//A signature counter that went backwards means the key may have been
+	//cloned, refuse it and leave it to an admin to look into
+	if cred.Authenticator.CloneWarning {
+		log.Println("WebAuthn clone warning for ", user.Name, " credential ", db.CredentialId(cred.ID))
+		return apiError(http.StatusUnauthorized, client.CodeUnauthorized, failed)
+	}
+
+	if err := td.users.UseCredential(user.UserId, *cred); err != nil {
+		log.Println("Error updating credential: ", err)
+		return storeError(err)
+	}
+	return nil
+}
+
+// stepUpUser returns the admin user a caller steps up as, the one with
+// the caller's name, which must have a security key registered
+func (td *VoterAPI) stepUpUser(c *fiber.Ctx) (principal, db.AdminUser, error) {
+	if err := td.requireWebAuthn(); err != nil {
+		return principal{}, db.AdminUser{}, err
+	}
+	caller, _ := c.Locals("principal").(principal)
+	if td.access == nil || caller.Role == AnonymousRole || caller.Name == "" {
+		return principal{}, db.AdminUser{}, apiError(http.StatusUnauthorized, client.CodeUnauthorized, "an API key is required")
+	}
+
+	user, err := td.users.GetUserByName(caller.Name)
+	if err != nil || len(user.Credentials) == 0 {
+		return principal{}, db.AdminUser{}, apiError(http.StatusForbidden, client.CodeStepUpRequired,
+			"no security key is registered for this caller")
+	}
+	return caller, user, nil
+}
+
+// implementation for POST /auth/step-up/webauthn/begin
+// starts a step-up with a security key instead of a TOTP code.  The caller
+// must have the name of an admin user with a registered key, the response
+// is the PublicKeyCredentialRequestOptions to pass to
+// navigator.credentials.get().
+func (td *VoterAPI) BeginStepUp(c *fiber.Ctx) error {
+	_, user, err := td.stepUpUser(c)
+	if err != nil {
+		return err
+	}
+
+	options, session, err := td.webauthn.BeginLogin(webauthnUser{user})
+	if err != nil {
+		log.Println("Error starting WebAuthn step-up: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	td.ceremonies.put("step-up:"+user.Name, ceremony{session: *session})
+
+	return c.JSON(options)
+}
+
+// implementation for POST /auth/step-up/webauthn/finish
+// completes a step-up, the body is the PublicKeyCredential returned by
+// navigator.credentials.get().  The response is a step-up token like the
+// one from POST /auth/step-up.
+func (td *VoterAPI) FinishStepUp(c *fiber.Ctx) error {
+	caller, user, err := td.stepUpUser(c)
+	if err != nil {
+		return err
+	}
+
+	cer, ok := td.ceremonies.take("step-up:" + user.Name)
+	if !ok {
+		return fiber.NewError(http.StatusBadRequest, "no step-up in progress")
+	}
+	if err := td.verifyAssertion(c, user, cer, "invalid security key"); err != nil {
+		log.Println("Step-up failed for ", caller.Name)
+		return err
+	}
+
+	token, expires, err := td.stepUps.issue(caller.Name)
+	if err != nil {
+		log.Println("Error issuing step-up token: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(fiber.Map{
+		"token":   token,
+		"expires": expires,
+	})
+}
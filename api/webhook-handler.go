@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/webhook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Webhook delivery settings, see package webhook
+const (
+	webhookWorkers  = 4
+	webhookBuffer   = 1000
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookTimeout  = 10 * time.Second
+)
+
+// WebhookPayload is the body POSTed to a webhook for every change it
+// subscribes to, the LiveEvent of the change with all of the voter's
+// fields.  Seq counts the changes of the roll, a receiver can tell from a
+// gap that it missed one.
+type WebhookPayload struct {
+	Webhook string
+	LiveEvent
+}
+
+// webhookSecret is a webhook along with its secret, which is only shown
+// when the webhook is created or the secret rotated
+type webhookSecret struct {
+	db.Webhook
+	Secret string
+}
+
+// sendWebhookSecret answers with a webhook and its secret, the ETag is
+// the webhook's alone
+func sendWebhookSecret(c *fiber.Ctx, status int, hook db.Webhook) error {
+	return sendTagged(c, status, resourceETag(hook), webhookSecret{Webhook: hook, Secret: hook.Secret()})
+}
+
+// newWebhookSender returns the sender webhooks are delivered with
+func newWebhookSender() *webhook.Sender {
+	client := &http.Client{Timeout: webhookTimeout}
+	return webhook.NewSender(client, webhookWorkers, webhookBuffer, webhookAttempts, webhookBackoff)
+}
+
+// deliverWebhooks sends every change of the roll to the webhooks that
+// subscribe to it, for as long as the process runs.  Should it fall behind
+// the change feed it picks up again from the feed's backlog.
+func (td *VoterAPI) deliverWebhooks() {
+	last := int64(-1)
+	for {
+		missed, complete, changes, _ := td.db.WatchFrom(last, liveBuffer)
+		if !complete {
+			log.Println("Error delivering webhooks: changes since", last, "are no longer kept, they were not delivered")
+		}
+		for _, change := range missed {
+			td.dispatchWebhooks(change)
+			last = change.Seq
+		}
+		for change := range changes {
+			td.dispatchWebhooks(change)
+			last = change.Seq
+		}
+	}
+}
+
+// dispatchWebhooks queues a change for delivery to every webhook that
+// subscribes to it.  What the webhooks are sent is written to the access
+// log, under the name of the webhook.  A rehearsal sends nothing.
+func (td *VoterAPI) dispatchWebhooks(change db.Change) {
+	if td.rehearsal != nil {
+		return
+	}
+	for _, hook := range td.webhooks.GetAllWebhooks() {
+		if !hook.Wants(change.Event) {
+			continue
+		}
+
+		payload := WebhookPayload{Webhook: hook.Name, LiveEvent: td.liveEvent(change, change.Seq, nil)}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Println("Error encoding webhook payload: ", err)
+			continue
+		}
+		td.webhookSender.Send(webhook.Delivery{
+			Webhook: hook.Name,
+			URL:     hook.URL,
+			Secret:  hook.Secret(),
+			Event:   change.Event,
+			Body:    body,
+		})
+		td.recordAccess(principal{Name: "webhook:" + hook.Name}, http.MethodPost, hook.URL, payload.Voter)
+	}
+}
+
+// implementation for GET /admin/webhooks
+// returns all webhooks ordered by name
+func (td *VoterAPI) ListWebhooks(c *fiber.Ctx) error {
+	return c.JSON(td.webhooks.GetAllWebhooks())
+}
+
+// implementation for GET /admin/webhooks/:name
+func (td *VoterAPI) GetWebhook(c *fiber.Ctx) error {
+	webhook, err := td.webhooks.GetWebhook(c.Params("name"))
+	if err != nil {
+		log.Println("Webhook not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeWebhookNotFound, "webhook not found")
+	}
+
+	return sendResource(c, http.StatusOK, webhook)
+}
+
+// implementation for POST /admin/webhooks
+// adds a webhook under the Name of the body.  The response carries the
+// Secret deliveries are signed with, it is not shown again.  Repeating the
+// POST of a webhook that is stored as sent is a 200, a name taken by a
+// webhook with other content is a 409, see etag.go.
+func (td *VoterAPI) PostWebhook(c *fiber.Ctx) error {
+	var webhook db.Webhook
+	if err := c.BodyParser(&webhook); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if stored, err := td.webhooks.GetWebhook(webhook.Name); err == nil {
+		normalized, err := db.NormalizeWebhook(webhook)
+		if err != nil {
+			return storeError(err)
+		}
+		normalized.Created, normalized.Updated = stored.Created, stored.Updated
+		if resourceETag(normalized) != resourceETag(stored) {
+			return apiError(http.StatusConflict, client.CodeConflict,
+				fmt.Sprintf("webhook %s already exists with other content, PUT it to change it", webhook.Name))
+		}
+		return sendResource(c, http.StatusOK, stored)
+	}
+	if err := checkPreconditions(c, ""); err != nil {
+		return err
+	}
+
+	webhook, err := td.webhooks.AddWebhook(webhook)
+	if err != nil {
+		log.Println("Error adding webhook: ", err)
+		return storeError(err)
+	}
+	td.audit.Record(requestID(c), "webhook.created", 0, fmt.Sprintf("webhook %s to %s", webhook.Name, webhook.URL))
+
+	c.Location("/admin/webhooks/" + webhook.Name)
+	return sendWebhookSecret(c, http.StatusCreated, webhook)
+}
+
+// implementation for PUT /admin/webhooks/:name
+// replaces a webhook, or adds it with a 201 and its Secret when there is
+// none with the name
+func (td *VoterAPI) UpdateWebhook(c *fiber.Ctx) error {
+	var webhook db.Webhook
+	if err := c.BodyParser(&webhook); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	webhook.Name = strings.Clone(c.Params("name"))
+
+	stored, err := td.webhooks.GetWebhook(webhook.Name)
+	created := err != nil
+	etag := ""
+	if !created {
+		etag = resourceETag(stored)
+	}
+	if err := checkPreconditions(c, etag); err != nil {
+		return err
+	}
+
+	action := "webhook.updated"
+	if created {
+		action = "webhook.created"
+		webhook, err = td.webhooks.AddWebhook(webhook)
+	} else {
+		webhook, err = td.webhooks.UpdateWebhook(webhook)
+	}
+	if err != nil {
+		log.Println("Error updating webhook: ", err)
+		return storeError(err)
+	}
+	if resourceETag(webhook) != etag {
+		td.audit.Record(requestID(c), action, 0, fmt.Sprintf("webhook %s to %s", webhook.Name, webhook.URL))
+	}
+
+	if created {
+		c.Location(c.Path())
+		return sendWebhookSecret(c, http.StatusCreated, webhook)
+	}
+	return sendResource(c, http.StatusOK, webhook)
+}
+
+// implementation for DELETE /admin/webhooks/:name
+func (td *VoterAPI) DeleteWebhook(c *fiber.Ctx) error {
+	name := strings.Clone(c.Params("name"))
+	stored, err := td.webhooks.GetWebhook(name)
+	if err != nil {
+		log.Println("Webhook not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeWebhookNotFound, "webhook not found")
+	}
+	if err := checkPreconditions(c, resourceETag(stored)); err != nil {
+		return err
+	}
+
+	if err := td.webhooks.DeleteWebhook(name); err != nil {
+		log.Println("Webhook not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeWebhookNotFound, "webhook not found")
+	}
+	td.audit.Record(requestID(c), "webhook.deleted", 0, "webhook "+name)
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation for POST /admin/webhooks/:name/rotate
+// gives a webhook a new secret, the response carries it.  Deliveries that
+// are already waiting for a retry keep the old one.
+func (td *VoterAPI) RotateWebhookSecret(c *fiber.Ctx) error {
+	hook, err := td.webhooks.RotateSecret(c.Params("name"))
+	if err != nil {
+		log.Println("Webhook not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeWebhookNotFound, "webhook not found")
+	}
+	td.audit.Record(requestID(c), "webhook.rotated", 0, "webhook "+hook.Name)
+
+	return sendWebhookSecret(c, http.StatusOK, hook)
+}
+
+// implementation for GET /admin/webhooks/:name/deliveries
+// returns the latest delivery attempts to a webhook, newest first, along
+// with the counters of all deliveries
+func (td *VoterAPI) GetWebhookDeliveries(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if _, err := td.webhooks.GetWebhook(name); err != nil {
+		log.Println("Webhook not found: ", err)
+		return apiError(http.StatusNotFound, client.CodeWebhookNotFound, "webhook not found")
+	}
+
+	attempts := make([]webhook.Attempt, 0)
+	for _, attempt := range td.webhookSender.Attempts() {
+		if attempt.Webhook == name {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"attempts": attempts,
+		"stats":    td.webhookSender.Stats(),
+	})
+}
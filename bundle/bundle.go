@@ -0,0 +1,165 @@
+// Package bundle packs export files into a tar archive together with a
+// manifest.  The manifest lists the size, SHA-256 checksum and record
+// count of every file, so whoever imports the bundle can tell a complete
+// transfer from a truncated or altered one before reading any record.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ManifestName is the name of the manifest inside a bundle, it is always
+// the first entry
+const ManifestName = "manifest.json"
+
+// ContentType is the media type of a bundle
+const ContentType = "application/x-tar"
+
+// maxFileSize is the largest file Read accepts, a guard against archives
+// that claim more than an export can hold
+const maxFileSize = 1 << 30
+
+// File is a file to pack into a bundle
+type File struct {
+	Name    string
+	Data    []byte
+	Records int //Number of records in the file, e.g. rows or voters
+}
+
+// Entry describes one file of a bundle in its manifest
+type Entry struct {
+	Name    string
+	Size    int64
+	Records int
+	SHA256  string //Hex encoded
+}
+
+// Manifest lists the files of a bundle
+type Manifest struct {
+	Created time.Time
+	Files   []Entry
+}
+
+// Entry returns the manifest entry of a file
+func (m Manifest) Entry(name string) (Entry, bool) {
+	for _, entry := range m.Files {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// checksum returns the hex encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Write packs files into a bundle written to w, the manifest first and
+// then the files in the order given
+func Write(w io.Writer, files ...File) error {
+	manifest := Manifest{Created: time.Now().UTC(), Files: make([]Entry, 0, len(files))}
+	for _, file := range files {
+		if file.Name == ManifestName {
+			return errors.New(ManifestName + " is reserved for the manifest")
+		}
+		manifest.Files = append(manifest.Files, Entry{
+			Name:    file.Name,
+			Size:    int64(len(file.Data)),
+			Records: file.Records,
+			SHA256:  checksum(file.Data),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, file := range append([]File{{Name: ManifestName, Data: data}}, files...) {
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    0o600,
+			Size:    int64(len(file.Data)),
+			ModTime: manifest.Created,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Read unpacks a bundle and checks every file against the manifest.  A
+// file that is missing, not in the manifest, or whose size or checksum
+// differs is an error, so the files returned are exactly the ones the
+// bundle was written with.  The record counts can only be checked by the
+// caller once it has parsed the files.
+func Read(r io.Reader) (Manifest, map[string][]byte, error) {
+	tr := tar.NewReader(r)
+
+	header, err := tr.Next()
+	if err != nil {
+		return Manifest{}, nil, fmt.Errorf("not a bundle: %w", err)
+	}
+	if header.Name != ManifestName {
+		return Manifest{}, nil, fmt.Errorf("not a bundle: the first file is %s, not %s", header.Name, ManifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, maxFileSize)).Decode(&manifest); err != nil {
+		return Manifest{}, nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+
+	files := make(map[string][]byte, len(manifest.Files))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, nil, fmt.Errorf("bundle is truncated: %w", err)
+		}
+
+		entry, ok := manifest.Entry(header.Name)
+		if !ok {
+			return Manifest{}, nil, fmt.Errorf("%s is not in the manifest", header.Name)
+		}
+		if _, ok := files[header.Name]; ok {
+			return Manifest{}, nil, fmt.Errorf("%s is in the bundle twice", header.Name)
+		}
+		if header.Size != entry.Size || header.Size > maxFileSize {
+			return Manifest{}, nil, fmt.Errorf("%s is %d bytes, the manifest says %d", header.Name, header.Size, entry.Size)
+		}
+
+		var data bytes.Buffer
+		if _, err := io.Copy(&data, tr); err != nil {
+			return Manifest{}, nil, fmt.Errorf("%s is truncated: %w", header.Name, err)
+		}
+		if checksum(data.Bytes()) != entry.SHA256 {
+			return Manifest{}, nil, fmt.Errorf("%s does not match its checksum", header.Name)
+		}
+		files[header.Name] = data.Bytes()
+	}
+
+	for _, entry := range manifest.Files {
+		if _, ok := files[entry.Name]; !ok {
+			return Manifest{}, nil, fmt.Errorf("%s is missing from the bundle", entry.Name)
+		}
+	}
+
+	return manifest, files, nil
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// DefaultPageSize is how many items an iterator asks for per page
+const DefaultPageSize = 100
+
+// RetryPolicy decides how requests the server refused for now are
+// retried, that is rate limited requests (429) and requests made while the
+// server was unavailable (503).  Those were not carried out, so they are
+// safe to repeat whatever their method.  The wait is the Retry-After the
+// server sent, or an exponential backoff from MinBackoff when it sent none.
+type RetryPolicy struct {
+	MaxAttempts int           //Attempts per request, 1 turns retries off
+	MinBackoff  time.Duration //First wait when the server gave no Retry-After
+	MaxBackoff  time.Duration //Longest wait, a request asked to wait longer fails at once
+
+	//OnRetry is called before every wait when it is set, e.g. to log
+	//or count how often the client is rate limited
+	OnRetry func(err *Error, attempt int, wait time.Duration)
+}
+
+// DefaultRetryPolicy is the retry policy of a new client
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+}
+
+// API is what a client of the voter API can do.  Programs that depend on
+// it rather than on *Client can run their tests against a Fake, or a
+// Client pointed at NewFakeServer, without a live server.
+type API interface {
+	GetVoter(ctx context.Context, id int) (db.Voter, error)
+	CreateVoter(ctx context.Context, voter db.Voter) (db.Voter, error)
+	UpdateVoter(ctx context.Context, voter db.Voter) (db.Voter, error)
+	DeleteVoter(ctx context.Context, id int) error
+	Voters(ctx context.Context, query url.Values) *Iterator[db.Voter]
+
+	VoterHistory(ctx context.Context, voterID int) *Iterator[db.VoterHistory]
+	GetVote(ctx context.Context, voterID, pollID int) (db.VoterHistory, error)
+	AddVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error)
+	UpdateVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error)
+	DeleteVote(ctx context.Context, voterID, pollID int) error
+
+	Health(ctx context.Context) (Health, error)
+}
+
+// Client is the API served over HTTP
+var _ API = (*Client)(nil)
+
+// Client talks to a voter API server.  The fields can be changed after New
+// and before the client is first used.
+type Client struct {
+	BaseURL  string //Where the API is served, e.g. http://localhost:1080
+	APIKey   string //Sent as X-API-Key when set
+	HTTP     *http.Client
+	Retry    RetryPolicy
+	PageSize int //Items per page when iterating
+}
+
+// New returns a client of the API served at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		HTTP:     http.DefaultClient,
+		Retry:    DefaultRetryPolicy,
+		PageSize: DefaultPageSize,
+	}
+}
+
+// do sends a request to path, which is relative to the base URL and may
+// carry a query string, and decodes the JSON response into out.  An error
+// response is returned as an *Error.  Requests the server refused for now
+// are retried as the retry policy says.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) (http.Header, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.APIKey != "" {
+			req.Header.Set("X-API-Key", c.APIKey)
+		}
+
+		rsp, err := c.HTTP.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if rsp.StatusCode < 300 {
+			if out != nil && len(data) > 0 {
+				if err := json.Unmarshal(data, out); err != nil {
+					return nil, fmt.Errorf("decoding %s %s: %w", method, path, err)
+				}
+			}
+			return rsp.Header, nil
+		}
+
+		apiErr := decodeError(rsp, data)
+		wait, ok := c.retryWait(apiErr, attempt)
+		if !ok {
+			return rsp.Header, apiErr
+		}
+		if c.Retry.OnRetry != nil {
+			c.Retry.OnRetry(apiErr, attempt, wait)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeError reads an error response, a body that is not an API error
+// still gives an *Error with the status
+func decodeError(rsp *http.Response, data []byte) *Error {
+	apiErr := &Error{}
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = &Error{Code: CodeInternal, Message: http.StatusText(rsp.StatusCode)}
+	}
+	apiErr.Status = rsp.StatusCode
+	if apiErr.RetryAfter == 0 {
+		apiErr.RetryAfter, _ = strconv.Atoi(rsp.Header.Get("Retry-After"))
+	}
+	return apiErr
+}
+
+// retryWait returns how long to wait before the next attempt of a request
+// that failed with err, ok is false when it should not be retried
+func (c *Client) retryWait(err *Error, attempt int) (wait time.Duration, ok bool) {
+	if err.Status != http.StatusTooManyRequests && err.Status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if attempt >= c.Retry.MaxAttempts {
+		return 0, false
+	}
+
+	wait = time.Duration(err.RetryAfter) * time.Second
+	if err.RetryAfter == 0 {
+		wait = c.Retry.MinBackoff << (attempt - 1)
+	}
+	if wait > c.Retry.MaxBackoff {
+		return 0, false
+	}
+	return wait, true
+}
+
+// sleep waits, or returns the error of the context when it is done first
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,88 @@
+// Package client holds what Go programs need to talk to the voter API.
+package client
+
+import "fmt"
+
+// Error codes returned in the "code" field of every error response.  They
+// are stable, integrators should branch on them rather than on the message.
+const (
+	//Generic codes, used when nothing more specific applies
+	CodeInvalidRequest = "INVALID_REQUEST"
+	CodeInvalidFields  = "INVALID_FIELDS" //Comes with an entry in Fields for each field
+	CodeUnauthorized   = "UNAUTHORIZED"
+	CodeForbidden      = "FORBIDDEN"
+	CodeNotFound       = "NOT_FOUND"
+	CodeConflict       = "CONFLICT"
+	CodePrecondition   = "PRECONDITION_FAILED" //An If-Match or If-None-Match header did not hold, comes with a 412
+	CodeInternal       = "INTERNAL_ERROR"
+	CodeUnavailable    = "SERVICE_UNAVAILABLE"
+	CodeStepUpRequired = "STEP_UP_REQUIRED"
+	CodeNetworkBlocked = "NETWORK_BLOCKED"
+	CodeRateLimited    = "RATE_LIMITED"  //Comes with a 429 and a Retry-After header
+	CodeHistoryQuota   = "HISTORY_QUOTA" //Too many changes to one vote history, comes with a 429 and a Retry-After header
+
+	//Transient failures, these come with a 503 and a Retry-After header
+	CodeMaintenance        = "MAINTENANCE"
+	CodeReadOnly           = "READ_ONLY"
+	CodeStorageUnavailable = "STORAGE_UNAVAILABLE"
+	CodePollBusy           = "POLL_BUSY"
+	CodeOverloaded         = "OVERLOADED"
+
+	//Voters
+	CodeVoterNotFound      = "VOTER_NOT_FOUND"
+	CodeLegalHold          = "LEGAL_HOLD"
+	CodePollNotFound       = "POLL_NOT_FOUND"
+	CodeNotVotedInPoll     = "NOT_VOTED_IN_POLL"
+	CodeAlreadyVoted       = "ALREADY_VOTED" //The voter has a vote in the poll, POST with ?override=true to correct it
+	CodeAlreadyCheckedIn   = "ALREADY_CHECKED_IN"
+	CodeClockSkew          = "CLOCK_SKEW"
+	CodeInvalidVoteDate    = "INVALID_VOTE_DATE" //No vote date, or one outside the accepted window
+	CodePollClosed         = "POLL_CLOSED"
+	CodePollLocked         = "POLL_LOCKED"
+	CodeRegistrationFrozen = "REGISTRATION_FROZEN"
+	CodeIdMismatch         = "ID_MISMATCH"         //The body names another voter than the path
+	CodeProvenanceConflict = "PROVENANCE_CONFLICT" //The change is to fields another source owns, it was flagged for review
+	CodeHistoryImmutable   = "HISTORY_IMMUTABLE"   //Recorded votes can not be changed or deleted, record a correction instead
+
+	//Other resources
+	CodeDeviceNotFound       = "DEVICE_NOT_FOUND"
+	CodeElectionNotFound     = "ELECTION_NOT_FOUND"
+	CodePollingPlaceNotFound = "POLLING_PLACE_NOT_FOUND"
+	CodeCampaignNotFound     = "CAMPAIGN_NOT_FOUND"
+	CodeSegmentNotFound      = "SEGMENT_NOT_FOUND"
+	CodeExclusionNotFound    = "EXCLUSION_NOT_FOUND"
+	CodeJobNotFound          = "JOB_NOT_FOUND"
+	CodeUserNotFound         = "USER_NOT_FOUND"
+	CodeCredentialNotFound   = "CREDENTIAL_NOT_FOUND"
+	CodeExtensionNotFound    = "EXTENSION_NOT_FOUND"
+	CodeJurisdictionNotFound = "JURISDICTION_NOT_FOUND"
+	CodeTemplateNotFound     = "TEMPLATE_NOT_FOUND"
+	CodeReviewNotFound       = "REVIEW_NOT_FOUND"
+	CodeAPIKeyNotFound       = "API_KEY_NOT_FOUND"
+	CodeWebhookNotFound      = "WEBHOOK_NOT_FOUND"
+	CodeInvalidTransition    = "INVALID_TRANSITION"
+	CodeBundleInvalid        = "BUNDLE_INVALID"  //An import failed its manifest check
+	CodeReviewResolved       = "REVIEW_RESOLVED" //The review item was already adjudicated
+)
+
+// Error is the body of an error response
+type Error struct {
+	Status     int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	RetryAfter int          `json:"retry_after,omitempty"` //Seconds to wait before retrying
+	Fields     []FieldError `json:"fields,omitempty"`      //The fields of an INVALID_FIELDS request
+}
+
+// FieldError is a field of a request that broke one of its rules, rule is
+// the rule it broke, e.g. required, max or email
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error returns the code and message of the error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
@@ -0,0 +1,346 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/validate"
+)
+
+// Fake is an in-memory API for the tests of programs that use the client.
+// It keeps its voters in a db.VoterList, the store the server runs on by
+// default, and answers the way the server does: payloads are checked
+// against the same rules, voter ids and VoteIds are handed out the same
+// way and failures are an *Error with the status and code the server
+// would send.  Devices, precincts and the other resources a vote may
+// refer to do not exist in a fake, so those references are not checked.
+//
+//	fake := client.NewFake()
+//	fake.CreateVoter(ctx, db.Voter{Name: "Jane Smith"})
+//	runMyCode(fake)
+type Fake struct {
+	voters *db.VoterList
+}
+
+// Fake answers like the server does
+var _ API = (*Fake)(nil)
+
+// NewFake returns a fake without any voters
+func NewFake() *Fake {
+	voters, _ := db.NewVoterList()
+	return &Fake{voters: voters}
+}
+
+// GetVoter returns a voter by id
+func (f *Fake) GetVoter(ctx context.Context, id int) (db.Voter, error) {
+	if err := ctx.Err(); err != nil {
+		return db.Voter{}, err
+	}
+
+	voter, err := f.voters.GetVoter(id)
+	if err != nil {
+		return db.Voter{}, fakeError(http.StatusNotFound, CodeVoterNotFound, "voter not found")
+	}
+	return voter, nil
+}
+
+// CreateVoter adds a voter, a voter without a VoterId is given the next
+// free one
+func (f *Fake) CreateVoter(ctx context.Context, voter db.Voter) (db.Voter, error) {
+	if err := ctx.Err(); err != nil {
+		return db.Voter{}, err
+	}
+	if err := fakeValidate(voter); err != nil {
+		return db.Voter{}, err
+	}
+	if err := fakeVoteDates(voter.VoteHistory); err != nil {
+		return db.Voter{}, err
+	}
+
+	//VoteIds are handed out by the store, never taken from the client
+	voter.VoteHistory = append([]db.VoterHistory(nil), voter.VoteHistory...)
+	for i := range voter.VoteHistory {
+		voter.VoteHistory[i].VoteId = 0
+	}
+
+	var err error
+	if voter.VoterId == 0 {
+		voter.VoterId, err = f.voters.CreateVoter(voter)
+	} else {
+		err = f.voters.AddVoter(voter)
+	}
+	if err != nil {
+		return db.Voter{}, fakeStoreError(err)
+	}
+
+	return f.voters.GetVoter(voter.VoterId)
+}
+
+// UpdateVoter replaces a voter, votes in polls the stored history has keep
+// their VoteId
+func (f *Fake) UpdateVoter(ctx context.Context, voter db.Voter) (db.Voter, error) {
+	stored, err := f.GetVoter(ctx, voter.VoterId)
+	if err != nil {
+		return db.Voter{}, err
+	}
+	if err := fakeValidate(voter); err != nil {
+		return db.Voter{}, err
+	}
+	if err := fakeVoteDates(changedVotes(stored.VoteHistory, voter.VoteHistory)); err != nil {
+		return db.Voter{}, err
+	}
+
+	voter.VoteHistory = append([]db.VoterHistory(nil), voter.VoteHistory...)
+	for i := range voter.VoteHistory {
+		voter.VoteHistory[i].VoteId = 0
+		for _, old := range stored.VoteHistory {
+			if old.PollId == voter.VoteHistory[i].PollId {
+				voter.VoteHistory[i].VoteId = old.VoteId
+			}
+		}
+	}
+
+	if err := f.voters.UpdateVoter(voter); err != nil {
+		return db.Voter{}, fakeStoreError(err)
+	}
+	return f.voters.GetVoter(voter.VoterId)
+}
+
+// changedVotes returns the entries of a new history that are not in the
+// stored one as they are, the server only checks the dates of those
+func changedVotes(stored, history []db.VoterHistory) []db.VoterHistory {
+	var changed []db.VoterHistory
+	for _, entry := range history {
+		unchanged := false
+		for _, old := range stored {
+			if old.PollId == entry.PollId && old.VoteDate.Equal(entry.VoteDate) {
+				unchanged = true
+			}
+		}
+		if !unchanged {
+			changed = append(changed, entry)
+		}
+	}
+	return changed
+}
+
+// DeleteVoter deletes a voter
+func (f *Fake) DeleteVoter(ctx context.Context, id int) error {
+	if _, err := f.GetVoter(ctx, id); err != nil {
+		return err
+	}
+	if err := f.voters.DeleteVoter(id); err != nil {
+		return fakeStoreError(err)
+	}
+	return nil
+}
+
+// Voters iterates over the voters.  The query takes the name, email,
+// precinct, status and tag filters and the sort and order parameters of
+// GET /voters, other parameters are ignored.
+func (f *Fake) Voters(ctx context.Context, query url.Values) *Iterator[db.Voter] {
+	voters, _, err := f.searchVoters(ctx, query, 0, 0)
+	return listIterator(ctx, voters, err)
+}
+
+// searchVoters returns the page of voters matching a GET /voters query
+// and the number of voters across all pages
+func (f *Fake) searchVoters(ctx context.Context, query url.Values, offset, limit int) ([]db.Voter, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	precinct, _ := strconv.Atoi(query.Get("precinct"))
+	filter := db.VoterFilter{
+		Name:       query.Get("name"),
+		Email:      query.Get("email"),
+		PrecinctId: precinct,
+		Status:     query.Get("status"),
+		Tag:        query.Get("tag"),
+	}
+
+	order := db.VoterSort{Field: query.Get("sort")}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		order.Descending = true
+	default:
+		return nil, 0, fakeError(http.StatusBadRequest, CodeInvalidRequest, "order must be asc or desc")
+	}
+
+	voters, total, err := f.voters.SearchVoters(filter, order, offset, limit)
+	if err != nil {
+		return nil, 0, fakeError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+	return voters, total, nil
+}
+
+// VoterHistory iterates over the vote history of a voter
+func (f *Fake) VoterHistory(ctx context.Context, voterID int) *Iterator[db.VoterHistory] {
+	voter, err := f.GetVoter(ctx, voterID)
+	if err == nil && voter.VoteHistory == nil {
+		voter.VoteHistory = []db.VoterHistory{}
+	}
+	return listIterator(ctx, voter.VoteHistory, err)
+}
+
+// GetVote returns the vote of a voter in a poll
+func (f *Fake) GetVote(ctx context.Context, voterID, pollID int) (db.VoterHistory, error) {
+	voter, err := f.GetVoter(ctx, voterID)
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			return history, nil
+		}
+	}
+	return db.VoterHistory{}, fakeError(http.StatusNotFound, CodePollNotFound, "Poll not found for the voter")
+}
+
+// AddVote records the vote of a voter in a poll, stamped with the time of
+// the call
+func (f *Fake) AddVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	if err := fakeValidate(vote); err != nil {
+		return db.VoterHistory{}, err
+	}
+	if err := db.ValidChannel(vote.Channel); err != nil {
+		return db.VoterHistory{}, fakeError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+
+	voter, err := f.GetVoter(ctx, voterID)
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+	if err := db.AlreadyVoted(voter, pollID); err != nil {
+		return db.VoterHistory{}, fakeError(http.StatusConflict, CodeAlreadyVoted, err.Error())
+	}
+
+	vote.PollId = pollID
+	if err := fakeVoteDates([]db.VoterHistory{vote}); err != nil {
+		return db.VoterHistory{}, err
+	}
+	vote.ClientVoteDate = vote.VoteDate
+	vote.VoteDate = time.Now()
+	vote.VoteId = 0
+	vote.ExtensionId = 0
+
+	voter.VoteHistory = append(voter.VoteHistory, vote)
+	if err := f.voters.UpdateVoter(voter); err != nil {
+		return db.VoterHistory{}, fakeStoreError(err)
+	}
+
+	return f.GetVote(ctx, voterID, pollID)
+}
+
+// UpdateVote replaces the vote of a voter in a poll, it keeps its VoteId
+func (f *Fake) UpdateVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	if err := fakeValidate(vote); err != nil {
+		return db.VoterHistory{}, err
+	}
+	if err := db.ValidChannel(vote.Channel); err != nil {
+		return db.VoterHistory{}, fakeError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+	vote.PollId = pollID
+	if err := fakeVoteDates([]db.VoterHistory{vote}); err != nil {
+		return db.VoterHistory{}, err
+	}
+
+	voter, err := f.GetVoter(ctx, voterID)
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+	for i, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			vote.VoteId = history.VoteId
+			vote.ExtensionId = history.ExtensionId
+			voter.VoteHistory[i] = vote
+			if err := f.voters.UpdateVoter(voter); err != nil {
+				return db.VoterHistory{}, fakeStoreError(err)
+			}
+			return f.GetVote(ctx, voterID, pollID)
+		}
+	}
+	return db.VoterHistory{}, fakeError(http.StatusNotFound, CodePollNotFound, "Poll not found for the voter")
+}
+
+// DeleteVote deletes the vote of a voter in a poll
+func (f *Fake) DeleteVote(ctx context.Context, voterID, pollID int) error {
+	voter, err := f.GetVoter(ctx, voterID)
+	if err != nil {
+		return err
+	}
+	for i, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
+			if err := f.voters.UpdateVoter(voter); err != nil {
+				return fakeStoreError(err)
+			}
+			return nil
+		}
+	}
+	return fakeError(http.StatusNotFound, CodePollNotFound, "Poll not found for the voter")
+}
+
+// Health reports a fake as up
+func (f *Fake) Health(ctx context.Context) (Health, error) {
+	if err := ctx.Err(); err != nil {
+		return Health{}, err
+	}
+	return Health{Status: "ok", Version: "fake"}, nil
+}
+
+// fakeError is an error response as the server sends it
+func fakeError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// fakeValidate checks a payload against its validate tags like the server
+// does, a payload that breaks them is a 400 listing the fields
+func fakeValidate(payload any) error {
+	var errs validate.Errors
+	if err := validate.Struct(payload); !errors.As(err, &errs) {
+		return err
+	}
+
+	apiErr := fakeError(http.StatusBadRequest, CodeInvalidFields, errs.Error())
+	for _, field := range errs {
+		apiErr.Fields = append(apiErr.Fields, FieldError{Field: field.Field, Rule: field.Rule, Message: field.Message})
+	}
+	return apiErr
+}
+
+// fakeVoteDates rejects history entries without a vote date or with one
+// outside the default vote date window
+func fakeVoteDates(histories []db.VoterHistory) error {
+	now := time.Now()
+	for _, history := range histories {
+		if err := db.DefaultVoteDateWindow.Check(history.VoteDate, now); err != nil {
+			return fakeError(http.StatusBadRequest, CodeInvalidVoteDate, fmt.Sprintf("poll %d: %v", history.PollId, err))
+		}
+	}
+	return nil
+}
+
+// fakeStoreError is the error response for a failed store call, the kinds
+// of error the db package returns map to the statuses the server uses
+func fakeStoreError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return fakeError(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, db.ErrAlreadyExists):
+		return fakeError(http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, db.ErrInvalidInput):
+		return fakeError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case db.IsLegalHold(err):
+		return fakeError(http.StatusConflict, CodeLegalHold, err.Error())
+	}
+	return fakeError(http.StatusInternalServerError, CodeInternal, err.Error())
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// NewFakeServer starts an HTTP server that serves the voters of a fake on
+// the routes of the API, so tests can point a Client, or a program that
+// does not use this package, at its URL.  It answers the routes API covers
+// with the bodies, status codes, error codes and paging headers of the
+// server.  Close it when the test is done.
+func NewFakeServer(fake *Fake) *httptest.Server {
+	return httptest.NewServer(fakeHandler{fake: fake})
+}
+
+// fakeHandler serves a fake over HTTP
+type fakeHandler struct {
+	fake *Fake
+}
+
+// ServeHTTP routes a request to the fake.  The paths are those of the
+// server: /voters, /voters/:id, /voters/:id/polls,
+// /voters/:id/polls/:pollid and /voters/health.
+func (h fakeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "voters" && parts[1] == "health" {
+		health, err := h.fake.Health(r.Context())
+		writeFakeResult(w, health, err)
+		return
+	}
+	if parts[0] != "voters" || len(parts) > 4 || (len(parts) > 2 && parts[2] != "polls") {
+		writeFakeError(w, fakeError(http.StatusNotFound, CodeNotFound, "Cannot "+r.Method+" "+r.URL.Path))
+		return
+	}
+
+	ids := make([]int, 0, 2)
+	for _, part := range []string{partAt(parts, 1), partAt(parts, 3)} {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			writeFakeError(w, fakeError(http.StatusNotFound, CodeNotFound, "Cannot "+r.Method+" "+r.URL.Path))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	route := "/voters"
+	switch len(parts) {
+	case 2:
+		route = "/voters/:id"
+	case 3:
+		route = "/voters/:id/polls"
+	case 4:
+		route = "/voters/:id/polls/:pollid"
+	}
+
+	ctx := r.Context()
+	switch r.Method + " " + route {
+	case "GET /voters":
+		offset, limit, err := fakePageParams(r.URL.Query())
+		if err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		voters, total, err := h.fake.searchVoters(ctx, r.URL.Query(), offset, limit)
+		if err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		setFakePageHeaders(w, r.URL, offset, limit, total)
+		writeFakeJSON(w, http.StatusOK, voters)
+
+	case "POST /voters":
+		var voter db.Voter
+		if err := json.NewDecoder(r.Body).Decode(&voter); err != nil {
+			writeFakeError(w, fakeError(http.StatusBadRequest, CodeInvalidRequest, http.StatusText(http.StatusBadRequest)))
+			return
+		}
+		created, err := h.fake.CreateVoter(ctx, voter)
+		if err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		if voter.VoterId == 0 {
+			w.Header().Set("Location", fmt.Sprintf("/voters/%d", created.VoterId))
+			writeFakeJSON(w, http.StatusCreated, created)
+			return
+		}
+		writeFakeJSON(w, http.StatusOK, created)
+
+	case "GET /voters/:id":
+		voter, err := h.fake.GetVoter(ctx, ids[0])
+		writeFakeResult(w, voter, err)
+
+	case "PUT /voters/:id":
+		var voter db.Voter
+		if err := json.NewDecoder(r.Body).Decode(&voter); err != nil {
+			writeFakeError(w, fakeError(http.StatusBadRequest, CodeInvalidRequest, http.StatusText(http.StatusBadRequest)))
+			return
+		}
+		if voter.VoterId != 0 && voter.VoterId != ids[0] {
+			writeFakeError(w, fakeError(http.StatusBadRequest, CodeIdMismatch,
+				fmt.Sprintf("the body is voter %d but the path names voter %d", voter.VoterId, ids[0])))
+			return
+		}
+		voter.VoterId = ids[0]
+		updated, err := h.fake.UpdateVoter(ctx, voter)
+		writeFakeResult(w, updated, err)
+
+	case "DELETE /voters/:id":
+		voter, err := h.fake.GetVoter(ctx, ids[0])
+		if err == nil {
+			err = h.fake.DeleteVoter(ctx, ids[0])
+		}
+		writeFakeResult(w, voter, err)
+
+	case "GET /voters/:id/polls":
+		offset, limit, err := fakePageParams(r.URL.Query())
+		if err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		history, err := h.fake.VoterHistory(ctx, ids[0]).All()
+		if err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		setFakePageHeaders(w, r.URL, offset, limit, len(history))
+		writeFakeJSON(w, http.StatusOK, fakePage(history, offset, limit))
+
+	case "GET /voters/:id/polls/:pollid":
+		vote, err := h.fake.GetVote(ctx, ids[0], ids[1])
+		writeFakeResult(w, vote, err)
+
+	case "POST /voters/:id/polls/:pollid":
+		var vote db.VoterHistory
+		if err := json.NewDecoder(r.Body).Decode(&vote); err != nil {
+			writeFakeError(w, fakeError(http.StatusBadRequest, CodeInvalidRequest, http.StatusText(http.StatusBadRequest)))
+			return
+		}
+		stored, err := h.fake.AddVote(ctx, ids[0], ids[1], vote)
+		writeFakeResult(w, stored, err)
+
+	case "PUT /voters/:id/polls/:pollid":
+		var vote db.VoterHistory
+		if err := json.NewDecoder(r.Body).Decode(&vote); err != nil {
+			writeFakeError(w, fakeError(http.StatusBadRequest, CodeInvalidRequest, http.StatusText(http.StatusBadRequest)))
+			return
+		}
+		stored, err := h.fake.UpdateVote(ctx, ids[0], ids[1], vote)
+		writeFakeResult(w, stored, err)
+
+	case "DELETE /voters/:id/polls/:pollid":
+		if err := h.fake.DeleteVote(ctx, ids[0], ids[1]); err != nil {
+			writeFakeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "Delete OK")
+
+	default:
+		writeFakeError(w, fakeError(http.StatusMethodNotAllowed, CodeInvalidRequest, http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// partAt returns a part of a split path, empty past its end
+func partAt(parts []string, i int) string {
+	if i < len(parts) {
+		return parts[i]
+	}
+	return ""
+}
+
+// fakePageParams reads ?limit= and ?offset= the way the server does, a
+// limit of 0 when none was asked for
+func fakePageParams(query url.Values) (offset, limit int, err error) {
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"offset", &offset}, {"limit", &limit}} {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(query.Get(param.name))
+		if err != nil || n < 0 {
+			return 0, 0, fakeError(http.StatusBadRequest, CodeInvalidRequest, param.name+" must be a whole number")
+		}
+		*param.value = n
+	}
+	if query.Get("limit") != "" && limit == 0 {
+		return 0, 0, fakeError(http.StatusBadRequest, CodeInvalidRequest, "limit must be at least 1")
+	}
+	if limit > 1000 {
+		return 0, 0, fakeError(http.StatusBadRequest, CodeInvalidRequest, "limit must be at most 1000")
+	}
+
+	return offset, limit, nil
+}
+
+// fakePage returns the items on the page asked for with ?limit= and
+// ?offset=, a list that is not paged is returned as it is
+func fakePage[T any](items []T, offset, limit int) []T {
+	if offset == 0 && limit == 0 {
+		return items
+	}
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// setFakePageHeaders sets X-Total-Count and, when a limit was given, the
+// Link header with the next and previous pages
+func setFakePageHeaders(w http.ResponseWriter, u *url.URL, offset, limit, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if limit == 0 {
+		return
+	}
+
+	pageURL := func(offset int) string {
+		query := u.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		query.Set("limit", strconv.Itoa(limit))
+		return u.Path + "?" + query.Encode()
+	}
+
+	links := make([]string, 0, 2)
+	if offset+limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(offset+limit)))
+	}
+	if offset > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(max(offset-limit, 0))))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// writeFakeResult writes the outcome of a call to the fake
+func writeFakeResult(w http.ResponseWriter, body any, err error) {
+	if err != nil {
+		writeFakeError(w, err)
+		return
+	}
+	writeFakeJSON(w, http.StatusOK, body)
+}
+
+// writeFakeError writes an error response, an error that is not an *Error
+// is an internal error
+func writeFakeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*Error)
+	if !ok {
+		apiErr = fakeError(http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	writeFakeJSON(w, apiErr.Status, apiErr)
+}
+
+// writeFakeJSON writes a JSON response
+func writeFakeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Health is the answer of GET /voters/health
+type Health struct {
+	Status            string `json:"status"` //ok when the server is up
+	Version           string `json:"version"`
+	Uptime            int    `json:"uptime"`
+	UsersProcessed    int    `json:"users_processed"`
+	ErrorsEncountered int    `json:"errors_encountered"`
+}
+
+// Health asks the server whether it is up
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var health Health
+	_, err := c.do(ctx, http.MethodGet, "/voters/health", nil, &health)
+	return health, err
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// Iterator walks a list the API returns in pages.  It asks for the first
+// page when Next is first called and follows the rel="next" link of each
+// page until there is none, so callers never deal with offsets.  It is
+// used like bufio.Scanner:
+//
+//	voters := cli.Voters(ctx, url.Values{"tag": {"volunteer"}})
+//	for voters.Next() {
+//		fmt.Println(voters.Item().Name)
+//	}
+//	if err := voters.Err(); err != nil {
+//		...
+//	}
+//
+// A rate limited page is retried as the client's RetryPolicy says, and
+// canceling the context stops the iteration with the context's error.
+type Iterator[T any] struct {
+	ctx    context.Context
+	client *Client
+	next   string //Path and query of the next page, empty after the last one
+	page   []T    //Items of the current page not handed out yet
+	item   T
+	total  int
+	err    error
+}
+
+// newIterator returns an iterator over the list at path, query holds the
+// filters of the list
+func newIterator[T any](ctx context.Context, c *Client, path string, query url.Values) *Iterator[T] {
+	paged := url.Values{}
+	for key, values := range query {
+		paged[key] = values
+	}
+	if paged.Get("limit") == "" {
+		paged.Set("limit", strconv.Itoa(c.PageSize))
+	}
+	paged.Del("offset")
+
+	return &Iterator[T]{ctx: ctx, client: c, next: path + "?" + paged.Encode(), total: -1}
+}
+
+// listIterator returns an iterator over a list that is at hand as a
+// whole, or one that stops at once with err
+func listIterator[T any](ctx context.Context, items []T, err error) *Iterator[T] {
+	if err != nil {
+		return &Iterator[T]{ctx: ctx, err: err, total: -1}
+	}
+	return &Iterator[T]{ctx: ctx, page: items, total: len(items)}
+}
+
+// Next moves to the next item, fetching the next page when needed.  It
+// returns false at the end of the list or on an error, see Err.
+func (it *Iterator[T]) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || it.next == "" {
+			return false
+		}
+		if it.err = it.ctx.Err(); it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+
+	it.item, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Item returns the item Next moved to
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Total returns the number of items in the whole list as the last page
+// reported it, -1 before the first page was fetched
+func (it *Iterator[T]) Total() int {
+	return it.total
+}
+
+// Err returns the error that ended the iteration, nil at the end of the
+// list
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// All returns the items not handed out yet
+func (it *Iterator[T]) All() ([]T, error) {
+	var items []T
+	for it.Next() {
+		items = append(items, it.item)
+	}
+	return items, it.err
+}
+
+// fetch reads the next page
+func (it *Iterator[T]) fetch() {
+	var page []T
+	header, err := it.client.do(it.ctx, http.MethodGet, it.next, nil, &page)
+	if err != nil {
+		it.err = err
+		return
+	}
+
+	it.page = page
+	it.next = nextLink(header.Get("Link"))
+	if total, err := strconv.Atoi(header.Get("X-Total-Count")); err == nil {
+		it.total = total
+	}
+}
+
+// nextLink returns the target of the rel="next" link of a Link header
+// (RFC 8288), empty when there is none
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.ReplaceAll(strings.TrimSpace(param), `"`, "") == "rel=next" {
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
+
+// Voters iterates over the voters, query holds the filters and sort order
+// GET /voters takes, e.g. tag, precinct or sort, nil for every voter
+func (c *Client) Voters(ctx context.Context, query url.Values) *Iterator[db.Voter] {
+	return newIterator[db.Voter](ctx, c, "/voters", query)
+}
+
+// VoterHistory iterates over the vote history of a voter
+func (c *Client) VoterHistory(ctx context.Context, voterID int) *Iterator[db.VoterHistory] {
+	return newIterator[db.VoterHistory](ctx, c, fmt.Sprintf("/voters/%d/polls", voterID), nil)
+}
+
+// AuditEntries iterates over the audit log oldest first, a voter id of 0
+// for the entries about every voter
+func (c *Client) AuditEntries(ctx context.Context, voterID int) *Iterator[db.AuditEntry] {
+	query := url.Values{}
+	if voterID != 0 {
+		query.Set("voter", strconv.Itoa(voterID))
+	}
+	return newIterator[db.AuditEntry](ctx, c, "/admin/audit", query)
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// GetVoter returns a voter by id
+func (c *Client) GetVoter(ctx context.Context, id int) (db.Voter, error) {
+	var voter db.Voter
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/voters/%d", id), nil, &voter)
+	return voter, err
+}
+
+// CreateVoter adds a voter and returns it as it was added.  A voter
+// without a VoterId is given the next free one, the VoteIds of its
+// history are handed out by the server.
+func (c *Client) CreateVoter(ctx context.Context, voter db.Voter) (db.Voter, error) {
+	var created db.Voter
+	_, err := c.do(ctx, http.MethodPost, "/voters", voter, &created)
+	return created, err
+}
+
+// UpdateVoter replaces the voter with the VoterId of voter and returns it
+// as it was stored.  Votes in polls the stored history has keep their
+// VoteId, new ones are given one by the server.
+func (c *Client) UpdateVoter(ctx context.Context, voter db.Voter) (db.Voter, error) {
+	var updated db.Voter
+	_, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/voters/%d", voter.VoterId), voter, &updated)
+	return updated, err
+}
+
+// PatchVoter changes only the fields of a voter that are in patch, a JSON
+// Merge Patch: fields left out keep their value and fields set to nil are
+// cleared.  It returns the voter as it was stored.
+func (c *Client) PatchVoter(ctx context.Context, id int, patch map[string]any) (db.Voter, error) {
+	var patched db.Voter
+	_, err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/voters/%d", id), patch, &patched)
+	return patched, err
+}
+
+// DeleteVoter deletes a voter
+func (c *Client) DeleteVoter(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/voters/%d", id), nil, nil)
+	return err
+}
+
+// DeleteAllVoters deletes every voter.  A server that asks for step-up
+// authentication refuses it with CodeStepUpRequired.
+func (c *Client) DeleteAllVoters(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/voters", nil, nil)
+	return err
+}
+
+// GetVote returns the vote of a voter in a poll
+func (c *Client) GetVote(ctx context.Context, voterID, pollID int) (db.VoterHistory, error) {
+	var vote db.VoterHistory
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/voters/%d/polls/%d", voterID, pollID), nil, &vote)
+	return vote, err
+}
+
+// AddVote records the vote of a voter in a poll and returns it as it was
+// stored.  The VoteDate is the time the client recorded the vote, the
+// server keeps it as the ClientVoteDate and stamps its own time.  A second
+// vote in the same poll is an error with CodeAlreadyVoted.
+func (c *Client) AddVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	var stored db.VoterHistory
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/voters/%d/polls/%d", voterID, pollID), vote, &stored)
+	return stored, err
+}
+
+// UpdateVote replaces the vote of a voter in a poll and returns it as it
+// was stored, the VoteId stays the one handed out when the vote was added
+func (c *Client) UpdateVote(ctx context.Context, voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	vote.PollId = pollID
+
+	var stored db.VoterHistory
+	_, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/voters/%d/polls/%d", voterID, pollID), vote, &stored)
+	return stored, err
+}
+
+// DeleteVote deletes the vote of a voter in a poll
+func (c *Client) DeleteVote(ctx context.Context, voterID, pollID int) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/voters/%d/polls/%d", voterID, pollID), nil, nil)
+	return err
+}
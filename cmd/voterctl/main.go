@@ -0,0 +1,261 @@
+// voterctl is the command line companion of the voter API.
+//
+//	voterctl verify -keyring keys.asc [-out file] package.asc
+//
+// checks the signature of a sealed export and, when the keyring holds a
+// recipient's private key, decrypts it.  The keyring files hold the
+// signer's public key and the recipient's private key, armored.  With
+// -out the export is written to a file, - for stdout.  A bundled export
+// is also checked against its manifest.
+//
+//	voterctl replay -target https://staging.example.com [-speed 1] [-concurrency 0] [-key key] traffic.jsonl
+//
+// drives a traffic recording, taken with the API's -record flag, against
+// another instance at the recorded pace times -speed, 0 for as fast as
+// possible, and compares the latency of each route with the recorded
+// one.  The key is sent as X-API-Key with every request, it defaults to
+// $VOTER_API_KEY.
+//
+//	voterctl rehearsal -target url [-key key] [-voters 1000] [-precincts 0] [-rate 10] [-polls 1] [-duration 0] seed|traffic|stop|status|wipe
+//
+// runs an election rehearsal on an instance started with -rehearsal: seed
+// adds -voters made up voters, traffic sends simulated votes at -rate per
+// second to the comma separated -polls for -duration, 0 until everyone
+// voted, stop ends the traffic, status prints the dashboard and wipe
+// deletes everything the rehearsal made.  An instance that is not in
+// rehearsal mode refuses all of them.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adllev/voter-api/bundle"
+	"github.com/adllev/voter-api/replay"
+	"github.com/adllev/voter-api/sealed"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "verify":
+		err = verify(os.Args[2:])
+	case "replay":
+		err = replayTraffic(os.Args[2:])
+	case "rehearsal":
+		err = rehearsal(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "voterctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: voterctl verify -keyring keys.asc [-out file] package.asc")
+	fmt.Fprintln(os.Stderr, "       voterctl replay -target url [-speed 1] [-concurrency 0] [-key key] recording.jsonl")
+	fmt.Fprintln(os.Stderr, "       voterctl rehearsal -target url [-key key] [-voters n] [-precincts n] [-rate n] [-polls 1,2] [-duration d] seed|traffic|stop|status|wipe")
+	os.Exit(2)
+}
+
+// verify opens a sealed export and reports who signed it
+func verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyringFlag := flags.String("keyring", "", "Comma separated armored key files, the signer's public key and the recipient's private key")
+	outFlag := flags.String("out", "", "Write the export to this file, - for stdout")
+	allowUnsignedFlag := flags.Bool("allow-unsigned", false, "Accept packages that are only encrypted")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *keyringFlag == "" {
+		usage()
+	}
+
+	keyring, err := sealed.ReadKeyring(strings.Split(*keyringFlag, ",")...)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pkg, err := sealed.Open(f, keyring)
+	if err != nil {
+		return err
+	}
+	if !pkg.Signed && !*allowUnsignedFlag {
+		return fmt.Errorf("%s is not signed", flags.Arg(0))
+	}
+
+	if pkg.Signed {
+		fmt.Fprintf(os.Stderr, "Good signature from %s (key %s)\n", pkg.SignedBy, pkg.KeyId)
+	}
+	fmt.Fprintf(os.Stderr, "%s, %d bytes, encrypted: %t\n", pkg.Name, len(pkg.Data), pkg.Encrypted)
+
+	if strings.HasSuffix(pkg.Name, ".tar") {
+		manifest, _, err := bundle.Read(bytes.NewReader(pkg.Data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		for _, entry := range manifest.Files {
+			fmt.Fprintf(os.Stderr, "  %s, %d bytes, %d records, checksum OK\n", entry.Name, entry.Size, entry.Records)
+		}
+	}
+
+	switch *outFlag {
+	case "":
+		return nil
+	case "-":
+		_, err = os.Stdout.Write(pkg.Data)
+		return err
+	default:
+		return os.WriteFile(*outFlag, pkg.Data, 0600)
+	}
+}
+
+// replayTraffic drives a traffic recording against a target instance and
+// prints how each route kept up
+func replayTraffic(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	targetFlag := flags.String("target", "", "Base URL of the instance to replay against")
+	speedFlag := flags.Float64("speed", 1, "Pace relative to the recording, 2 for twice as fast, 0 for as fast as possible")
+	concurrencyFlag := flags.Int("concurrency", 0, "Requests in flight at most, 0 for no bound")
+	keyFlag := flags.String("key", os.Getenv("VOTER_API_KEY"), "API key sent with every request")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *targetFlag == "" {
+		usage()
+	}
+
+	header, requests, err := replay.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %d requests recorded from %s\n", len(requests), header.Started.Format("2006-01-02 15:04:05"))
+
+	opts := replay.Options{
+		Target:      *targetFlag,
+		Speed:       *speedFlag,
+		Concurrency: *concurrencyFlag,
+		Header:      http.Header{},
+	}
+	if *keyFlag != "" {
+		opts.Header.Set("X-API-Key", *keyFlag)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := replay.Run(ctx, requests, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tCOUNT\tFAILED\tMISMATCH\tRECORDED P50/P95\tREPLAYED P50/P95\tREPLAYED MAX")
+	for _, route := range report.Routes {
+		fmt.Fprintf(w, "%s %s\t%d\t%d\t%d\t%v/%v\t%v/%v\t%v\n", route.Method, route.Route, route.Count, route.Failed, route.Mismatch,
+			route.Recorded.P50, route.Recorded.P95, route.Replayed.P50, route.Replayed.P95, route.Replayed.Max)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "%d requests in %v, %d failed, %d answered with another status class, at most %v behind schedule\n",
+		report.Requests, report.Elapsed.Round(time.Millisecond), report.Failed, report.Mismatch, report.Lag.Round(time.Millisecond))
+
+	return nil
+}
+
+// rehearsal drives the rehearsal routes of an instance and prints what it
+// answers
+func rehearsal(args []string) error {
+	flags := flag.NewFlagSet("rehearsal", flag.ExitOnError)
+	targetFlag := flags.String("target", "", "Base URL of the rehearsal instance")
+	keyFlag := flags.String("key", os.Getenv("VOTER_API_KEY"), "API key sent with every request")
+	votersFlag := flags.Int("voters", 1000, "Voters to seed")
+	precinctsFlag := flags.Int("precincts", 0, "Precincts the seeded voters are spread over")
+	rateFlag := flags.Float64("rate", 10, "Simulated votes per second")
+	pollsFlag := flags.String("polls", "1", "Comma separated polls the simulated votes go to")
+	durationFlag := flags.Duration("duration", 0, "How long the traffic runs, 0 until every voter voted")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *targetFlag == "" {
+		usage()
+	}
+
+	method, path := http.MethodPost, "/admin/rehearsal/"+flags.Arg(0)
+	var body any
+	switch flags.Arg(0) {
+	case "seed":
+		body = map[string]int{"Voters": *votersFlag, "Precincts": *precinctsFlag}
+	case "traffic":
+		var polls []int
+		for _, field := range strings.Split(*pollsFlag, ",") {
+			poll, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return fmt.Errorf("-polls: %w", err)
+			}
+			polls = append(polls, poll)
+		}
+		body = map[string]any{"Rate": *rateFlag, "Polls": polls, "DurationSeconds": int(durationFlag.Seconds())}
+	case "stop":
+		method, path = http.MethodDelete, "/admin/rehearsal/traffic"
+	case "status":
+		method, path = http.MethodGet, "/admin/rehearsal"
+	case "wipe":
+	default:
+		usage()
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*targetFlag, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *keyFlag != "" {
+		req.Header.Set("X-API-Key", *keyFlag)
+	}
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	answer, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s %s", method, path, rsp.Status, bytes.TrimSpace(answer))
+	}
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, answer, "", "  ") == nil {
+		answer = indented.Bytes()
+	}
+	fmt.Println(string(bytes.TrimSpace(answer)))
+	return nil
+}
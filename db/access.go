@@ -0,0 +1,54 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// AccessEntry records that a principal was shown PII of a voter
+type AccessEntry struct {
+	Time      time.Time
+	Principal string
+	Role      string
+	VoterId   int
+	Fields    []string
+	Method    string
+	Path      string
+}
+
+// AccessLog is an append only log of PII reads.  It is kept apart from the
+// audit log, which records changes, and answers "who looked at my record".
+type AccessLog struct {
+	mu      sync.Mutex
+	entries []AccessEntry
+}
+
+// constructor for AccessLog struct
+func NewAccessLog() *AccessLog {
+	return &AccessLog{}
+}
+
+// Record appends an entry to the log
+func (l *AccessLog) Record(entry AccessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Time = time.Now()
+	l.entries = append(l.entries, entry)
+}
+
+// GetEntries returns the reads of a voter's PII, oldest first.  A voter id
+// of 0 returns all entries.
+func (l *AccessLog) GetEntries(voterID int) []AccessEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]AccessEntry, 0)
+	for _, entry := range l.entries {
+		if voterID == 0 || entry.VoterId == voterID {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"time"
+)
+
+// Address is the residential address of a voter
+type Address struct {
+	Street string `validate:"max=200"`
+	City   string `validate:"max=100"`
+	State  string `validate:"max=50"`
+	Zip    string `validate:"max=10"`
+}
+
+// AddressChange records an address a voter lived at before a move, along
+// with the precinct it belonged to and the date the move took effect
+type AddressChange struct {
+	Address       Address
+	PrecinctId    int `validate:"min=0"`
+	EffectiveDate time.Time
+}
+
+// MoveVoter moves a voter to a new address as of the effective date.  The
+// old address and precinct are kept in the voter's address history and the
+// voter is assigned to the new precinct, a precinct of 0 leaves the voter
+// unassigned until the new precinct is known.
+func (t *VoterList) MoveVoter(voterID int, address Address, precinctID int, effectiveDate time.Time) (Voter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
+	if err != nil {
+		return Voter{}, err
+	}
+
+	if address.Street == "" {
+		return Voter{}, InvalidInput("new address is required")
+	}
+	if effectiveDate.IsZero() {
+		effectiveDate = time.Now()
+	}
+
+	voter.AddressHistory = append(voter.AddressHistory, AddressChange{
+		Address:       voter.Address,
+		PrecinctId:    voter.PrecinctId,
+		EffectiveDate: effectiveDate,
+	})
+	voter.Address = address
+	voter.PrecinctId = precinctID
+	voter.MovedDate = effectiveDate
+
+	if err := t.updateVoter(voter); err != nil {
+		return Voter{}, err
+	}
+
+	return voter, nil
+}
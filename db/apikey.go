@@ -0,0 +1,403 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can be limited to.  A key without scopes may do
+// everything its role allows.
+const (
+	ScopeRead  = "read"  //GET requests outside /admin
+	ScopeWrite = "write" //Any request outside /admin
+	ScopeAdmin = "admin" //Requests to /admin
+)
+
+// keyName is the allowed form of an API key name, it is used in URLs
+var keyName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// keyPrefixLength is how much of a secret is kept to tell keys apart
+const keyPrefixLength = 8
+
+// APIKey is an API key managed at /admin/apikeys, or loaded from the
+// access config.  The store keeps a hash of the secret, never the secret
+// itself, it is shown once when the key is created or rotated.
+type APIKey struct {
+	Name          string
+	Role          string
+	Scopes        []string  //Empty for every request the role allows
+	Jurisdictions []int     //Jurisdictions the key is limited to, empty for the whole roll
+	Source        string    //Upstream the key writes for, the key's Name if empty
+	RateLimit     float64   //Requests per second, 0 for no limit
+	Burst         int       //Requests allowed at once, defaults to the rate
+	Bulk          bool      //Bulk integrator, requests over the limit are queued
+	Expires       time.Time //Zero if the key does not expire
+	Prefix        string    //Start of the secret, empty for keys from the access config.  Set by the store
+	Created       time.Time //Set by the store
+	Rotated       time.Time //Last rotation, zero if never.  Set by the store
+	Revoked       time.Time //Zero unless revoked.  Set by the store
+	LastUsed      time.Time //Set by the store
+	Managed       bool      //Created through the API rather than the access config.  Set by the store
+
+	totpSecret string
+}
+
+// TOTPSecret returns the base32 TOTP secret of a key loaded from the
+// access config, empty if it has none
+func (k APIKey) TOTPSecret() string {
+	return k.totpSecret
+}
+
+// Settings returns the key with only the fields an admin sets, for
+// telling whether two keys are set up the same
+func (k APIKey) Settings() APIKey {
+	return APIKey{
+		Name:          k.Name,
+		Role:          k.Role,
+		Scopes:        k.Scopes,
+		Jurisdictions: k.Jurisdictions,
+		Source:        k.Source,
+		RateLimit:     k.RateLimit,
+		Burst:         k.Burst,
+		Bulk:          k.Bulk,
+		Expires:       k.Expires,
+	}
+}
+
+// Usable returns why a key can not be used at a time, nil if it can
+func (k APIKey) Usable(now time.Time) error {
+	if !k.Revoked.IsZero() {
+		return errors.New("API key has been revoked")
+	}
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return errors.New("API key has expired")
+	}
+	return nil
+}
+
+// HasScope reports whether a key may make requests of a scope
+func (k APIKey) HasScope(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeWrite && scope == ScopeRead {
+			return true
+		}
+	}
+	return false
+}
+
+// retiredSecret is a secret replaced by a rotation that keeps working
+// until the grace period is over
+type retiredSecret struct {
+	name  string
+	until time.Time
+}
+
+// APIKeyStore holds the API keys by name and the hashes of their secrets
+type APIKeyStore struct {
+	mu      sync.Mutex
+	keys    map[string]APIKey
+	secrets map[string]string        //secret hash -> key name
+	retired map[string]retiredSecret //secret hash -> rotated out key
+}
+
+// constructor for APIKeyStore struct
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{
+		keys:    make(map[string]APIKey),
+		secrets: make(map[string]string),
+		retired: make(map[string]retiredSecret),
+	}
+}
+
+// validateKey checks the fields an admin can set on a key
+func validateKey(key APIKey) error {
+	if !keyName.MatchString(key.Name) {
+		return InvalidInput("a key name is lower case letters, digits, - and _")
+	}
+	if key.Role == "" {
+		return InvalidInput("every API key needs a role")
+	}
+	for _, scope := range key.Scopes {
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+		default:
+			return InvalidInput("unknown scope " + scope)
+		}
+	}
+	if key.RateLimit < 0 || key.Burst < 0 {
+		return InvalidInput("rate limits can not be negative")
+	}
+
+	return nil
+}
+
+// settle copies the fields the store manages from a stored key, the
+// caller must hold the lock
+func (s *APIKeyStore) settle(key APIKey, stored APIKey) APIKey {
+	key.Prefix = stored.Prefix
+	key.Created = stored.Created
+	key.Rotated = stored.Rotated
+	key.Revoked = stored.Revoked
+	key.LastUsed = stored.LastUsed
+	key.Managed = stored.Managed
+	key.totpSecret = stored.totpSecret
+	return key
+}
+
+// issue stores a new secret for a key, the caller must hold the lock.
+// Only secrets the store made are long enough to show their start.
+func (s *APIKeyStore) issue(key *APIKey, secret string, generated bool) {
+	key.Prefix = ""
+	if generated {
+		key.Prefix = secret[:keyPrefixLength]
+	}
+	s.secrets[hashToken(secret)] = key.Name
+}
+
+// ImportKey adds a key of the access config with the secret and TOTP
+// secret the config gives it
+func (s *APIKeyStore) ImportKey(key APIKey, secret, totpSecret string) error {
+	if secret == "" || key.Role == "" {
+		return InvalidInput("every API key needs a key and a role")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key.Name == "" {
+		key.Name = "key-" + hashToken(secret)[:keyPrefixLength]
+	}
+	if _, ok := s.keys[key.Name]; ok {
+		return AlreadyExists(fmt.Sprintf("API key %s already exists", key.Name))
+	}
+
+	key = s.settle(key, APIKey{Created: time.Now(), totpSecret: totpSecret})
+	s.issue(&key, secret, false)
+	s.keys[key.Name] = key
+
+	return nil
+}
+
+// CreateKey adds a key and returns it along with its secret.  The secret
+// can not be recovered later, if it is lost the key has to be rotated.
+func (s *APIKeyStore) CreateKey(key APIKey) (APIKey, string, error) {
+	if err := validateKey(key); err != nil {
+		return APIKey{}, "", err
+	}
+	secret, err := newToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.Name]; ok {
+		return APIKey{}, "", AlreadyExists(fmt.Sprintf("API key %s already exists", key.Name))
+	}
+
+	key = s.settle(key, APIKey{Created: time.Now(), Managed: true})
+	s.issue(&key, secret, true)
+	s.keys[key.Name] = key
+
+	return key, secret, nil
+}
+
+// UpdateKey changes the role, scopes, limits and expiry of a key, its
+// secret is kept
+func (s *APIKeyStore) UpdateKey(key APIKey) (APIKey, error) {
+	if err := validateKey(key); err != nil {
+		return APIKey{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.keys[key.Name]
+	if !ok {
+		return APIKey{}, NotFound("API key does not exist")
+	}
+
+	key = s.settle(key, stored)
+	s.keys[key.Name] = key
+
+	return key, nil
+}
+
+// dropSecrets removes the secret of a key and any secret it was rotated
+// from, the caller must hold the lock
+func (s *APIKeyStore) dropSecrets(name string) {
+	for hash, owner := range s.secrets {
+		if owner == name {
+			delete(s.secrets, hash)
+		}
+	}
+	for hash, old := range s.retired {
+		if old.name == name {
+			delete(s.retired, hash)
+		}
+	}
+}
+
+// RotateKey gives a key a new secret and returns it.  The old secret keeps
+// working for the grace period so clients can be moved over without an
+// outage, a grace of zero drops it at once.
+func (s *APIKeyStore) RotateKey(name string, grace time.Duration) (APIKey, string, error) {
+	if grace < 0 {
+		return APIKey{}, "", InvalidInput("the grace period can not be negative")
+	}
+	secret, err := newToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return APIKey{}, "", NotFound("API key does not exist")
+	}
+	if !key.Revoked.IsZero() {
+		return APIKey{}, "", InvalidInput("a revoked API key can not be rotated")
+	}
+
+	now := time.Now()
+	for hash, owner := range s.secrets {
+		if owner == name {
+			delete(s.secrets, hash)
+			if grace > 0 {
+				s.retired[hash] = retiredSecret{name: name, until: now.Add(grace)}
+			}
+		}
+	}
+
+	key.Rotated = now
+	s.issue(&key, secret, true)
+	s.keys[name] = key
+
+	return key, secret, nil
+}
+
+// RevokeKey blocks a key for good, its secrets stop working immediately.
+// The key is kept so it still shows in the list.
+func (s *APIKeyStore) RevokeKey(name string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return APIKey{}, NotFound("API key does not exist")
+	}
+
+	s.dropSecrets(name)
+	if key.Revoked.IsZero() {
+		key.Revoked = time.Now()
+	}
+	s.keys[name] = key
+
+	return key, nil
+}
+
+// DeleteKey removes a key and its secrets
+func (s *APIKeyStore) DeleteKey(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[name]; !ok {
+		return NotFound("API key does not exist")
+	}
+
+	s.dropSecrets(name)
+	delete(s.keys, name)
+
+	return nil
+}
+
+// GetKey returns a key by name
+func (s *APIKeyStore) GetKey(name string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[name]
+	if !ok {
+		return APIKey{}, NotFound("API key does not exist")
+	}
+
+	return key, nil
+}
+
+// GetAllKeys returns all keys ordered by name
+func (s *APIKeyStore) GetAllKeys() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+
+	return keys
+}
+
+// lookup returns the name of the key a secret belongs to, the caller
+// must hold the lock
+func (s *APIKeyStore) lookup(secret string, now time.Time) (string, bool) {
+	hash := hashToken(secret)
+	if name, ok := s.secrets[hash]; ok {
+		return name, true
+	}
+	if old, ok := s.retired[hash]; ok {
+		if now.Before(old.until) {
+			return old.name, true
+		}
+		delete(s.retired, hash)
+	}
+	return "", false
+}
+
+// Identify returns the key a secret belongs to without counting it as a
+// use, for middleware that runs before the caller is authenticated
+func (s *APIKeyStore) Identify(secret string) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, ok := s.lookup(secret, time.Now())
+	if !ok {
+		return APIKey{}, false
+	}
+	return s.keys[name], true
+}
+
+// Authenticate returns the key a secret belongs to and marks it as used.
+// Revoked and expired keys are rejected.
+func (s *APIKeyStore) Authenticate(secret string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	name, ok := s.lookup(secret, now)
+	if !ok {
+		return APIKey{}, errors.New("unknown API key")
+	}
+
+	key := s.keys[name]
+	if err := key.Usable(now); err != nil {
+		return APIKey{}, err
+	}
+
+	key.LastUsed = now
+	s.keys[name] = key
+
+	return key, nil
+}
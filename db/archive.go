@@ -0,0 +1,198 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColdStore holds archived voters outside of the in memory roll.  Voters
+// from past elections are moved there to keep the hot set small, reads of
+// an archived voter are served from the cold store on demand.
+type ColdStore interface {
+	Put(voter Voter) error
+	Get(id int) (Voter, bool, error)
+	Delete(id int) error
+	IDs() ([]int, error)
+}
+
+// FileColdStore is a ColdStore that keeps one JSON file per voter in a
+// directory
+type FileColdStore struct {
+	dir string
+}
+
+// constructor for FileColdStore struct
+func NewFileColdStore(dir string) (*FileColdStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileColdStore{dir: dir}, nil
+}
+
+// path returns the file an archived voter is kept in
+func (s *FileColdStore) path(id int) string {
+	return filepath.Join(s.dir, strconv.Itoa(id)+".json")
+}
+
+// Put writes a voter to the store, replacing any earlier copy
+func (s *FileColdStore) Put(voter Voter) error {
+	data, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+
+	//Write to a temporary file first so a crash never leaves half a record
+	tmp := s.path(voter.VoterId) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path(voter.VoterId))
+}
+
+// Get reads a voter from the store, the bool is false if it is not there
+func (s *FileColdStore) Get(id int) (Voter, bool, error) {
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Voter{}, false, nil
+	}
+	if err != nil {
+		return Voter{}, false, err
+	}
+
+	var voter Voter
+	if err := json.Unmarshal(data, &voter); err != nil {
+		return Voter{}, false, err
+	}
+
+	return voter, true, nil
+}
+
+// Delete removes a voter from the store, it is not an error if the voter
+// is not there
+func (s *FileColdStore) Delete(id int) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// IDs returns the ids of all archived voters in ascending order
+func (s *FileColdStore) IDs() ([]int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.Atoi(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// errUnavailable is returned when the cold store can not be reached
+var errUnavailable = errors.New("storage is temporarily unavailable")
+
+// IsUnavailable reports whether an error was caused by storage that could
+// not be reached, such errors are worth retrying later
+func IsUnavailable(err error) bool {
+	return errors.Is(err, errUnavailable)
+}
+
+// Unavailable marks an error of a storage backend as caused by storage
+// that could not be reached
+func Unavailable(err error) error {
+	return fmt.Errorf("%w: %v", errUnavailable, err)
+}
+
+// SetColdStore turns on archiving to the given store
+func (t *VoterList) SetColdStore(store ColdStore) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.coldStore = store
+}
+
+// getArchived reads a voter from the cold store and flags it as archived
+func (t *VoterList) getArchived(id int) (Voter, error) {
+	if t.coldStore == nil {
+		return Voter{}, NotFound("voter does not exist")
+	}
+
+	voter, ok, err := t.coldStore.Get(id)
+	if err != nil {
+		return Voter{}, fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	if !ok {
+		return Voter{}, NotFound("voter does not exist")
+	}
+
+	voter.Archived = true
+	return voter, nil
+}
+
+// ArchiveVoters moves every voter whose last vote was before the given time
+// to the cold store.  Voters that never voted stay in the hot set, they are
+// usually new registrations.  Archiving does not change the roll, so no
+// revision is recorded, the voter is just no longer kept in memory.  Any
+// write to an archived voter brings it back into the hot set.
+func (t *VoterList) ArchiveVoters(before time.Time) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.coldStore == nil {
+		return nil, errors.New("no cold store is configured")
+	}
+
+	ids := make([]int, 0)
+	for id, voter := range t.Voters {
+		if len(voter.VoteHistory) == 0 || !lastVote(voter).Before(before) {
+			continue
+		}
+
+		if err := t.coldStore.Put(voter); err != nil {
+			return ids, err
+		}
+		delete(t.Voters, id)
+		t.checksum = nil
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// lastVote returns the date of the most recent vote of a voter
+func lastVote(voter Voter) time.Time {
+	var last time.Time
+	for _, history := range voter.VoteHistory {
+		if history.VoteDate.After(last) {
+			last = history.VoteDate
+		}
+	}
+	return last
+}
+
+// ColdStore returns the configured cold store, or nil when archiving is off
+func (t *VoterList) ColdStore() ColdStore {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.coldStore
+}
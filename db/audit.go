@@ -0,0 +1,71 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single entry in the audit log
+type AuditEntry struct {
+	Time      time.Time
+	RequestId string //Request that made the change, links to the UI action log
+	Action    string
+	VoterId   int //0 when the action is not about a single voter
+	Detail    string
+}
+
+// AuditLog is an append only log of sensitive actions
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+// constructor for AuditLog struct
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record appends an entry to the log
+func (l *AuditLog) Record(requestID, action string, voterID int, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, AuditEntry{
+		Time:      time.Now(),
+		RequestId: requestID,
+		Action:    action,
+		VoterId:   voterID,
+		Detail:    detail,
+	})
+}
+
+// GetEntries returns the entries of the log, oldest first.  A voter id of 0
+// returns all entries, otherwise only the entries about that voter.
+func (l *AuditLog) GetEntries(voterID int) []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	for _, entry := range l.entries {
+		if voterID == 0 || entry.VoterId == voterID {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// GetRequestEntries returns the entries made by a single request
+func (l *AuditLog) GetRequestEntries(requestID string) []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	for _, entry := range l.entries {
+		if requestID != "" && entry.RequestId == requestID {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
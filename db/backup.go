@@ -0,0 +1,179 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Strategies for an imported voter whose id is already taken, set per
+// import so feeds can be trusted as far as they deserve
+const (
+	ImportSkip        = "skip"         //Keep the stored voter as it is
+	ImportOverwrite   = "overwrite"    //Replace the stored voter with the imported one
+	ImportMergeFields = "merge-fields" //Copy over only the fields the imported voter has set
+	ImportFail        = "fail"         //Count the voter as failed
+)
+
+// Outcomes of importing a single voter
+const (
+	ImportAdded       = "added"
+	ImportSkipped     = "skipped" //A voter with the same id already exists
+	ImportOverwritten = "overwritten"
+	ImportMerged      = "merged"
+	ImportFailed      = "failed"
+)
+
+// ImportOutcome is what happened to one voter of an import
+type ImportOutcome struct {
+	VoterId int
+	Outcome string
+	Error   string   //Why the voter could not be added
+	Held    []string //Owned fields the import could not change, flagged for review
+}
+
+// ImportReport sums up an import, with the outcome of every voter in the
+// order they were given
+type ImportReport struct {
+	Strategy    string
+	Added       int
+	Skipped     int
+	Overwritten int
+	Merged      int
+	Failed      int
+	Flagged     int //Voters with changes held back by the provenance rules
+	Outcomes    []ImportOutcome
+}
+
+// BackupVoters returns every voter of the roll ordered by id, the form a
+// backup is written in.  Decoys are left out, restored they would be
+// ordinary voters.  Archived voters are already kept in the cold store.
+func (t *VoterList) BackupVoters() []Voter {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	voters := make([]Voter, 0, len(t.Voters))
+	for id, voter := range t.Voters {
+		if t.decoys[id] {
+			continue
+		}
+		voters = append(voters, cloneVoter(voter))
+	}
+	sort.Slice(voters, func(i, j int) bool {
+		return voters[i].VoterId < voters[j].VoterId
+	})
+
+	return voters
+}
+
+// ValidateImportStrategy returns an error if strategy is not one of the
+// import strategies
+func ValidateImportStrategy(strategy string) error {
+	switch strategy {
+	case ImportSkip, ImportOverwrite, ImportMergeFields, ImportFail:
+		return nil
+	}
+	return InvalidInput(fmt.Sprintf("strategy must be %s, %s, %s or %s, not %q",
+		ImportSkip, ImportOverwrite, ImportMergeFields, ImportFail, strategy))
+}
+
+// ImportVoters adds voters from a backup or another roll.  What happens to
+// a voter whose id is already taken is up to the strategy, see
+// ImportSkip and the others, changes to fields source does not own are
+// held back by the provenance rules.  A voter that can not be imported
+// does not stop the others, the report has the outcome of each.
+func (t *VoterList) ImportVoters(voters []Voter, strategy, source string) (ImportReport, error) {
+	if err := ValidateImportStrategy(strategy); err != nil {
+		return ImportReport{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := ImportReport{Strategy: strategy, Outcomes: make([]ImportOutcome, 0, len(voters))}
+	for _, voter := range voters {
+		outcome := t.importVoter(voter, strategy, source)
+		if len(outcome.Held) > 0 {
+			report.Flagged++
+		}
+		switch outcome.Outcome {
+		case ImportAdded:
+			report.Added++
+		case ImportSkipped:
+			report.Skipped++
+		case ImportOverwritten:
+			report.Overwritten++
+		case ImportMerged:
+			report.Merged++
+		case ImportFailed:
+			report.Failed++
+		}
+		report.Outcomes = append(report.Outcomes, outcome)
+	}
+
+	return report, nil
+}
+
+// importVoter imports one voter with the strategy for a taken id
+func (t *VoterList) importVoter(voter Voter, strategy, source string) ImportOutcome {
+	outcome := ImportOutcome{VoterId: voter.VoterId}
+
+	stored, err := t.getVoter(voter.VoterId)
+	switch {
+	case err != nil:
+		outcome.Outcome, err = ImportAdded, t.addVoter(voter)
+	case strategy == ImportSkip:
+		outcome.Outcome = ImportSkipped
+	case strategy == ImportOverwrite, strategy == ImportMergeFields:
+		outcome.Outcome = ImportOverwritten
+		if strategy == ImportMergeFields {
+			outcome.Outcome, voter = ImportMerged, mergeFields(stored, voter)
+		}
+
+		var conflicts []ProvenanceConflict
+		voter, conflicts = t.applyProvenance(stored, voter, source)
+		for _, conflict := range conflicts {
+			outcome.Held = append(outcome.Held, conflict.Field)
+		}
+		if err = t.updateVoter(voter); err == nil {
+			t.flagConflicts(conflicts)
+		}
+	default:
+		err = AlreadyExists(fmt.Sprintf("voter %d already exists", voter.VoterId))
+	}
+
+	if err != nil {
+		outcome.Outcome = ImportFailed
+		outcome.Error = err.Error()
+	}
+	return outcome
+}
+
+// mergeFields returns the stored voter with every field the imported voter
+// has set copied over, fields left empty in the import keep their stored
+// value.  The vote history is combined, a poll the stored voter already
+// has an entry for keeps the stored entry.
+func mergeFields(stored, imported Voter) Voter {
+	merged := cloneVoter(stored)
+
+	from := reflect.ValueOf(imported)
+	to := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < from.NumField(); i++ {
+		if !from.Field(i).IsZero() {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+
+	voted := make(map[int]bool, len(stored.VoteHistory))
+	merged.VoteHistory = append([]VoterHistory(nil), stored.VoteHistory...)
+	for _, history := range stored.VoteHistory {
+		voted[history.PollId] = true
+	}
+	for _, history := range imported.VoteHistory {
+		if !voted[history.PollId] {
+			merged.VoteHistory = append(merged.VoteHistory, history)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// LoadBallotKey reads the election public key from a PEM file.  Both PKIX
+// ("PUBLIC KEY") and PKCS1 ("RSA PUBLIC KEY") encodings are accepted.
+func LoadBallotKey(path string) (*rsa.PublicKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found in ballot key file")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("ballot key must be an RSA public key")
+	}
+
+	return rsaKey, nil
+}
+
+// SetBallotKey turns on encrypted ballot storage.  From now on any ballot
+// choice written to the list is encrypted with the election public key and
+// the plain text is dropped before the record is stored.  The matching
+// private key is never given to the API, it is held by the election
+// trustees and only released (or reassembled from their shares) once the
+// polls close, so choices stay unreadable by the people running the API.
+func (t *VoterList) SetBallotKey(key *rsa.PublicKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ballotKey = key
+}
+
+// sealChoices encrypts the plain text choice of every history entry of the
+// voter when encrypted ballot storage is on.  Entries that are already
+// sealed are left alone.
+func (t *VoterList) sealChoices(voter *Voter) error {
+	if t.ballotKey == nil {
+		return nil
+	}
+
+	for i, history := range voter.VoteHistory {
+		if history.Choice == "" {
+			continue
+		}
+
+		sealed, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, t.ballotKey,
+			[]byte(history.Choice), nil)
+		if err != nil {
+			return err
+		}
+
+		voter.VoteHistory[i].EncryptedChoice = sealed
+		voter.VoteHistory[i].Choice = ""
+	}
+
+	return nil
+}
+
+// EncryptedBallot is a sealed ballot in the shape used by external
+// cryptographic tally systems.  The field names follow the ElectionGuard
+// submitted ballot JSON, which is why this struct carries json tags.
+// Nothing in it identifies the voter.
+type EncryptedBallot struct {
+	ObjectId   string             `json:"object_id"`
+	StyleId    string             `json:"style_id"`
+	CodeSeed   string             `json:"code_seed"`
+	Code       string             `json:"code"`
+	CryptoHash string             `json:"crypto_hash"`
+	Timestamp  int64              `json:"timestamp"`
+	Contests   []EncryptedContest `json:"contests"`
+}
+
+// EncryptedContest holds the sealed selection for one poll
+type EncryptedContest struct {
+	ObjectId   string `json:"object_id"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedBallots returns all sealed ballots, optionally limited to one
+// poll (pass 0 for all polls).  Ballots are ordered by vote date and each
+// tracking code is chained to the one before it, so anyone holding the
+// export can check that no ballot was dropped or reordered.  The first
+// code is seeded with the hash of the poll filter.
+func (t *VoterList) EncryptedBallots(pollID int) []EncryptedBallot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var histories []VoterHistory
+	for _, voter := range t.Voters {
+		for _, history := range voter.VoteHistory {
+			if len(history.EncryptedChoice) == 0 {
+				continue
+			}
+			if pollID != 0 && history.PollId != pollID {
+				continue
+			}
+			histories = append(histories, history)
+		}
+	}
+
+	//Sort on the ciphertext as a tie breaker, that keeps the order stable
+	//between exports without leaking anything about the voter
+	sort.Slice(histories, func(i, j int) bool {
+		if !histories[i].VoteDate.Equal(histories[j].VoteDate) {
+			return histories[i].VoteDate.Before(histories[j].VoteDate)
+		}
+		return bytes.Compare(histories[i].EncryptedChoice, histories[j].EncryptedChoice) < 0
+	})
+
+	seed := sha256.Sum256([]byte(fmt.Sprintf("poll-%d", pollID)))
+	code := hex.EncodeToString(seed[:])
+
+	ballots := make([]EncryptedBallot, 0, len(histories))
+	for _, history := range histories {
+		ballotHash := sha256.Sum256(history.EncryptedChoice)
+		cryptoHash := hex.EncodeToString(ballotHash[:])
+		nextCode := hashPair(code, cryptoHash)
+
+		ballots = append(ballots, EncryptedBallot{
+			ObjectId:   "ballot-" + cryptoHash[:16],
+			StyleId:    fmt.Sprintf("poll-%d", history.PollId),
+			CodeSeed:   code,
+			Code:       nextCode,
+			CryptoHash: cryptoHash,
+			Timestamp:  history.VoteDate.Unix(),
+			Contests: []EncryptedContest{{
+				ObjectId:   fmt.Sprintf("poll-%d", history.PollId),
+				Ciphertext: history.EncryptedChoice,
+			}},
+		})
+		code = nextCode
+	}
+
+	return ballots
+}
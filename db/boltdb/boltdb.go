@@ -0,0 +1,499 @@
+// Package boltdb is a db.VoterStore kept in a single bbolt file, for
+// deployments that want persistent, transactional storage without
+// running a database server.  Every voter has a bucket of its own under
+// the voters bucket, holding the voter record and a nested bucket with
+// its vote history:
+//
+//	voters/
+//	  <voter id>/
+//	    record -> voter JSON, without the vote history
+//	    polls/
+//	      <position> -> history entry JSON
+//	emails/
+//	  <email key> -> voter id
+//	vote_ids/
+//	  <voter id> -> highest VoteId handed out
+//
+// The emails bucket is the unique index of email addresses, keyed by
+// db.EmailKey.  The vote_ids bucket holds the marks of the VoteId
+// sequence, see db.VoteIdStore.
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket and key names
+var (
+	votersBucket  = []byte("voters")
+	emailsBucket  = []byte("emails")
+	voteIdsBucket = []byte("vote_ids")
+	pollsBucket   = []byte("polls")
+	recordKey     = []byte("record")
+)
+
+// DefaultOpenTimeout is how long Open waits for the file lock, another
+// process holding the file fails the open instead of hanging
+const DefaultOpenTimeout = 5 * time.Second
+
+// Store is the bbolt voter store.  bbolt serializes writers and lets
+// readers run alongside them, so it is safe for concurrent use.
+type Store struct {
+	bolt *bolt.DB
+}
+
+// The bolt store is a VoterStore
+var (
+	_ db.HoldStore   = (*Store)(nil)
+	_ db.VoteIdStore = (*Store)(nil)
+)
+
+// Open opens or creates the store file
+func Open(path string) (*Store, error) {
+	boltDB, err := bolt.Open(path, 0600, &bolt.Options{Timeout: DefaultOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(votersBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(voteIdsBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(emailsBucket) != nil {
+			return nil
+		}
+		return indexEmails(tx)
+	})
+	if err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+
+	return &Store{bolt: boltDB}, nil
+}
+
+// Close closes the store file
+func (s *Store) Close() error {
+	return s.bolt.Close()
+}
+
+// itob encodes an id as a key, big endian so keys sort by id
+func itob(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// indexEmails builds the email index of a file written before there was
+// one
+func indexEmails(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucket(emailsBucket); err != nil {
+		return err
+	}
+
+	voters := tx.Bucket(votersBucket)
+	return voters.ForEach(func(key, _ []byte) error {
+		voter, err := readVoter(voters.Bucket(key))
+		if err != nil {
+			return err
+		}
+		return indexEmail(tx, voter.VoterId, "", voter.Email)
+	})
+}
+
+// indexEmail moves a voter's entry in the email index from its old address
+// to its new one.  An address registered to another voter is an error.
+func indexEmail(tx *bolt.Tx, id int, old, email string) error {
+	emails := tx.Bucket(emailsBucket)
+
+	key := []byte(db.EmailKey(email))
+	if len(key) > 0 {
+		if owner := emails.Get(key); owner != nil && int(binary.BigEndian.Uint64(owner)) != id {
+			return db.EmailTaken(email)
+		}
+	}
+	if oldKey := db.EmailKey(old); oldKey != "" {
+		if err := emails.Delete([]byte(oldKey)); err != nil {
+			return err
+		}
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	return emails.Put(key, itob(id))
+}
+
+// readVoter decodes the voter in a voter bucket along with its history
+func readVoter(bucket *bolt.Bucket) (db.Voter, error) {
+	var voter db.Voter
+	if err := json.Unmarshal(bucket.Get(recordKey), &voter); err != nil {
+		return db.Voter{}, err
+	}
+
+	err := bucket.Bucket(pollsBucket).ForEach(func(_, record []byte) error {
+		var history db.VoterHistory
+		if err := json.Unmarshal(record, &history); err != nil {
+			return err
+		}
+		voter.VoteHistory = append(voter.VoteHistory, history)
+		return nil
+	})
+	if err != nil {
+		return db.Voter{}, err
+	}
+
+	return voter, nil
+}
+
+// getVoter reads a voter in a transaction
+func getVoter(tx *bolt.Tx, id int) (db.Voter, error) {
+	bucket := tx.Bucket(votersBucket).Bucket(itob(id))
+	if bucket == nil {
+		return db.Voter{}, db.NotFound("item does not exist")
+	}
+	return readVoter(bucket)
+}
+
+// putVoter writes a voter and replaces its vote history
+func putVoter(tx *bolt.Tx, voter db.Voter) error {
+	voters := tx.Bucket(votersBucket)
+	key := itob(voter.VoterId)
+	var old db.Voter
+	if bucket := voters.Bucket(key); bucket != nil {
+		if err := json.Unmarshal(bucket.Get(recordKey), &old); err != nil {
+			return err
+		}
+		if err := voters.DeleteBucket(key); err != nil {
+			return err
+		}
+	}
+	if err := indexEmail(tx, voter.VoterId, old.Email, voter.Email); err != nil {
+		return err
+	}
+	bucket, err := voters.CreateBucket(key)
+	if err != nil {
+		return err
+	}
+
+	history := voter.VoteHistory
+	voter.VoteHistory = nil
+	record, err := json.Marshal(voter)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(recordKey, record); err != nil {
+		return err
+	}
+
+	polls, err := bucket.CreateBucket(pollsBucket)
+	if err != nil {
+		return err
+	}
+	for i, entry := range history {
+		record, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := polls.Put(itob(i), record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update reads a voter in a write transaction, lets change modify it and
+// writes it back
+func (s *Store) update(id int, change func(voter *db.Voter) error) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		voter, err := getVoter(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(&voter); err != nil {
+			return err
+		}
+		return putVoter(tx, voter)
+	})
+}
+
+// AddVoter adds a voter, the id must not be in use
+func (s *Store) AddVoter(voter db.Voter) error {
+	//Legal holds can only be placed through SetLegalHold
+	voter.LegalHold = false
+	voter.LegalHoldReason = ""
+
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(votersBucket).Bucket(itob(voter.VoterId)) != nil {
+			return db.AlreadyExists("item already exists")
+		}
+		return putVoter(tx, voter)
+	})
+}
+
+// CreateVoter adds a voter under the next free id and returns the id.
+// Bolt has a single writer, so the id can not be taken in between.
+func (s *Store) CreateVoter(voter db.Voter) (int, error) {
+	//Legal holds can only be placed through SetLegalHold
+	voter.LegalHold = false
+	voter.LegalHoldReason = ""
+
+	err := s.bolt.Update(func(tx *bolt.Tx) error {
+		//Keys are big endian, so the highest id is the last key that
+		//is not a negative id like the self test voter's
+		voter.VoterId = 1
+		cursor := tx.Bucket(votersBucket).Cursor()
+		for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+			if id := int(binary.BigEndian.Uint64(key)); id > 0 {
+				voter.VoterId = id + 1
+				break
+			}
+		}
+		return putVoter(tx, voter)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return voter.VoterId, nil
+}
+
+// GetVoter returns a voter by id
+func (s *Store) GetVoter(id int) (db.Voter, error) {
+	var voter db.Voter
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		var err error
+		voter, err = getVoter(tx, id)
+		return err
+	})
+
+	return voter, err
+}
+
+// GetVoterByEmail returns the voter registered with an email address,
+// found through the emails bucket
+func (s *Store) GetVoterByEmail(email string) (db.Voter, error) {
+	var voter db.Voter
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(emailsBucket).Get([]byte(db.EmailKey(email)))
+		if id == nil {
+			return db.NotFound("no voter is registered with this email")
+		}
+		var err error
+		voter, err = getVoter(tx, int(binary.BigEndian.Uint64(id)))
+		return err
+	})
+
+	return voter, err
+}
+
+// GetAllVoters returns every voter ordered by id
+func (s *Store) GetAllVoters() ([]db.Voter, error) {
+	var voters []db.Voter
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		voterBuckets := tx.Bucket(votersBucket)
+		return voterBuckets.ForEach(func(key, _ []byte) error {
+			voter, err := readVoter(voterBuckets.Bucket(key))
+			if err != nil {
+				return err
+			}
+			voters = append(voters, voter)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return voters, nil
+}
+
+// UpdateVoter replaces an existing voter.  A plain update can not place
+// or lift a legal hold.
+func (s *Store) UpdateVoter(voter db.Voter) error {
+	return s.update(voter.VoterId, func(existing *db.Voter) error {
+		voter.LegalHold = existing.LegalHold
+		voter.LegalHoldReason = existing.LegalHoldReason
+		*existing = voter
+		return nil
+	})
+}
+
+// SetLegalHold places or lifts a legal hold on a voter
+func (s *Store) SetLegalHold(id int, hold bool, reason string) error {
+	return s.update(id, func(voter *db.Voter) error {
+		voter.LegalHold = hold
+		voter.LegalHoldReason = reason
+		if !hold {
+			voter.LegalHoldReason = ""
+		}
+		return nil
+	})
+}
+
+// DeleteVoter removes a voter and its history, voters under legal hold
+// can not be deleted.  Like the in-memory list, deleting a voter that does
+// not exist is an ErrNotFound.
+func (s *Store) DeleteVoter(id int) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(votersBucket).Bucket(itob(id)) == nil {
+			return db.NotFound("item does not exist")
+		}
+		voter, err := getVoter(tx, id)
+		if err != nil {
+			return err
+		}
+		if voter.LegalHold {
+			return db.LegalHold(fmt.Sprintf("voter %d is under legal hold", id))
+		}
+		if err := indexEmail(tx, id, voter.Email, ""); err != nil {
+			return err
+		}
+		return tx.Bucket(votersBucket).DeleteBucket(itob(id))
+	})
+}
+
+// DeleteAll removes every voter, nothing is deleted if any voter is under
+// legal hold
+func (s *Store) DeleteAll() error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		voters := tx.Bucket(votersBucket)
+		held := 0
+		err := voters.ForEach(func(key, _ []byte) error {
+			voter, err := readVoter(voters.Bucket(key))
+			if err != nil {
+				return err
+			}
+			if voter.LegalHold {
+				held++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if held > 0 {
+			return db.LegalHold(fmt.Sprintf("%d voters are under legal hold", held))
+		}
+
+		for _, name := range [][]byte{votersBucket, emailsBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetVoterPolls returns the vote history of a voter
+func (s *Store) GetVoterPolls(voterID int) ([]db.VoterHistory, error) {
+	voter, err := s.GetVoter(voterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return voter.VoteHistory, nil
+}
+
+// GetVoterPoll returns the history entry of a voter for a poll
+func (s *Store) GetVoterPoll(voterID, pollID int) (db.VoterHistory, error) {
+	voter, err := s.GetVoter(voterID)
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			return history, nil
+		}
+	}
+
+	return db.VoterHistory{}, db.NotFound("poll not found for this voter")
+}
+
+// AddVoterPoll adds a history entry for a voter in one step, a second
+// vote in the same poll is an ErrAlreadyExists.  An entry without a VoteId
+// gets the one after the highest of the history.
+func (s *Store) AddVoterPoll(voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	vote.PollId = pollID
+	err := s.update(voterID, func(voter *db.Voter) error {
+		if err := db.AlreadyVoted(*voter, pollID); err != nil {
+			return err
+		}
+		if vote.VoteId == 0 {
+			vote.VoteId = db.NextVoteId(voter.VoteHistory)
+		}
+		voter.VoteHistory = append(voter.VoteHistory, vote)
+		return nil
+	})
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+	return vote, nil
+}
+
+// UpdateVoterPoll changes the vote date of a voter's history entry
+func (s *Store) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error {
+	return s.update(voterID, func(voter *db.Voter) error {
+		for i, history := range voter.VoteHistory {
+			if history.PollId == pollID {
+				voter.VoteHistory[i].VoteDate = newVoteDate
+				return nil
+			}
+		}
+		return db.NotFound("poll not found for this voter")
+	})
+}
+
+// DeleteVoterPoll removes a voter's history entry for a poll
+func (s *Store) DeleteVoterPoll(voterID, pollID int) error {
+	return s.update(voterID, func(voter *db.Voter) error {
+		for i, history := range voter.VoteHistory {
+			if history.PollId == pollID {
+				voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
+				return nil
+			}
+		}
+		return db.NotFound("poll not found for this voter")
+	})
+}
+
+// VoteIdMarks returns the marks of the VoteId sequence by voter id
+func (s *Store) VoteIdMarks() (map[int]int, error) {
+	marks := make(map[int]int)
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(voteIdsBucket).ForEach(func(key, value []byte) error {
+			marks[int(binary.BigEndian.Uint64(key))] = int(binary.BigEndian.Uint64(value))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marks, nil
+}
+
+// SetVoteIdMark raises the mark of a voter to voteID, a lower id leaves
+// it as it is
+func (s *Store) SetVoteIdMark(voterID, voteID int) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		marks := tx.Bucket(voteIdsBucket)
+		if mark := marks.Get(itob(voterID)); mark != nil && int(binary.BigEndian.Uint64(mark)) >= voteID {
+			return nil
+		}
+		return marks.Put(itob(voterID), itob(voteID))
+	})
+}
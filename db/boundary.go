@@ -0,0 +1,287 @@
+package db
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrecinctMapping moves every voter of one precinct to another
+type PrecinctMapping struct {
+	From int
+	To   int
+}
+
+// AddressRangeMove moves the voters living on a stretch of a street to a
+// precinct.  House numbers are taken from the start of the street line,
+// Parity is "even", "odd" or empty for both sides of the street.
+type AddressRangeMove struct {
+	Street     string //Street name without the house number, e.g. "Main St"
+	Zip        string //Optional, narrows the street to one zip code
+	FromNumber int
+	ToNumber   int
+	Parity     string
+	PrecinctId int
+}
+
+// BoundaryChange is a redistricting change file.  Address ranges are
+// more specific than precinct mappings and win when both match a voter.
+type BoundaryChange struct {
+	EffectiveDate time.Time
+	Mappings      []PrecinctMapping
+	Ranges        []AddressRangeMove
+}
+
+// PrecinctMove counts the voters moving from one precinct to another
+type PrecinctMove struct {
+	From   int
+	To     int
+	Voters int
+}
+
+// BoundaryResult is the outcome of a boundary change, or what it would be
+// when it is only previewed
+type BoundaryResult struct {
+	Applied  bool
+	Affected int
+	Moves    []PrecinctMove
+	VoterIds []int //Voters reassigned, only filled in once applied
+}
+
+// ParseBoundaryFile reads a change file in CSV form.  The first column
+// names the kind of row:
+//
+//	precinct,<from precinct>,<to precinct>
+//	range,<street>,<zip>,<from number>,<to number>,<parity>,<to precinct>
+//
+// Blank lines and lines starting with # are skipped.
+func ParseBoundaryFile(r io.Reader) (BoundaryChange, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var change BoundaryChange
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return BoundaryChange{}, err
+		}
+
+		switch strings.ToLower(row[0]) {
+		case "precinct":
+			if len(row) != 3 {
+				return BoundaryChange{}, InvalidInput(fmt.Sprintf("line %d: a precinct row has 3 columns", line))
+			}
+			ids, err := atois(row[1], row[2])
+			if err != nil {
+				return BoundaryChange{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			change.Mappings = append(change.Mappings, PrecinctMapping{From: ids[0], To: ids[1]})
+		case "range":
+			if len(row) != 7 {
+				return BoundaryChange{}, InvalidInput(fmt.Sprintf("line %d: a range row has 7 columns", line))
+			}
+			ids, err := atois(row[3], row[4], row[6])
+			if err != nil {
+				return BoundaryChange{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			change.Ranges = append(change.Ranges, AddressRangeMove{
+				Street: row[1], Zip: row[2], FromNumber: ids[0], ToNumber: ids[1],
+				Parity: strings.ToLower(row[5]), PrecinctId: ids[2],
+			})
+		default:
+			return BoundaryChange{}, InvalidInput(fmt.Sprintf("line %d: unknown row kind %q", line, row[0]))
+		}
+	}
+
+	return change, nil
+}
+
+// atois converts numeric columns
+func atois(values ...string) ([]int, error) {
+	ids := make([]int, len(values))
+	for i, value := range values {
+		id, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, InvalidInput(fmt.Sprintf("%q is not a number", value))
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// validate checks a change before any voter is looked at
+func (change BoundaryChange) validate() error {
+	if len(change.Mappings) == 0 && len(change.Ranges) == 0 {
+		return InvalidInput("the change file has no mappings or ranges")
+	}
+
+	from := make(map[int]bool)
+	for _, mapping := range change.Mappings {
+		if mapping.From <= 0 || mapping.To <= 0 {
+			return InvalidInput("precinct ids must be positive")
+		}
+		if from[mapping.From] {
+			return InvalidInput(fmt.Sprintf("precinct %d is mapped more than once", mapping.From))
+		}
+		from[mapping.From] = true
+	}
+	for _, move := range change.Ranges {
+		if move.Street == "" {
+			return InvalidInput("an address range needs a street")
+		}
+		if move.FromNumber > move.ToNumber {
+			return InvalidInput(fmt.Sprintf("range %d-%d on %s is backwards", move.FromNumber, move.ToNumber, move.Street))
+		}
+		if move.Parity != "" && move.Parity != "even" && move.Parity != "odd" {
+			return InvalidInput(fmt.Sprintf("parity must be even, odd or empty, not %q", move.Parity))
+		}
+		if move.PrecinctId <= 0 {
+			return InvalidInput("precinct ids must be positive")
+		}
+	}
+
+	return nil
+}
+
+// splitStreet splits "123 Main St" into 123 and "main st"
+func splitStreet(street string) (int, string, bool) {
+	number, name, ok := strings.Cut(strings.TrimSpace(street), " ")
+	if !ok {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, "", false
+	}
+	return n, strings.ToLower(strings.TrimSpace(name)), true
+}
+
+// matches reports whether a voter lives in the address range
+func (move AddressRangeMove) matches(address Address) bool {
+	number, street, ok := splitStreet(address.Street)
+	if !ok || street != strings.ToLower(strings.TrimSpace(move.Street)) {
+		return false
+	}
+	if move.Zip != "" && move.Zip != address.Zip {
+		return false
+	}
+	if number < move.FromNumber || number > move.ToNumber {
+		return false
+	}
+	switch move.Parity {
+	case "even":
+		return number%2 == 0
+	case "odd":
+		return number%2 == 1
+	}
+	return true
+}
+
+// planBoundaryChange works out the new precinct of every voter the change
+// touches, voter id -> new precinct
+func (t *VoterList) planBoundaryChange(change BoundaryChange) map[int]int {
+	to := make(map[int]int)
+	for _, mapping := range change.Mappings {
+		to[mapping.From] = mapping.To
+	}
+
+	plan := make(map[int]int)
+	for id, voter := range t.Voters {
+		precinct, ok := to[voter.PrecinctId]
+		for _, move := range change.Ranges {
+			if move.matches(voter.Address) {
+				precinct, ok = move.PrecinctId, true
+			}
+		}
+		if ok && precinct != voter.PrecinctId {
+			plan[id] = precinct
+		}
+	}
+
+	return plan
+}
+
+// summarize counts a plan by the precincts voters move between
+func (t *VoterList) summarize(plan map[int]int) BoundaryResult {
+	counts := make(map[PrecinctMove]int)
+	for id, precinct := range plan {
+		counts[PrecinctMove{From: t.Voters[id].PrecinctId, To: precinct}]++
+	}
+
+	result := BoundaryResult{Affected: len(plan), Moves: make([]PrecinctMove, 0, len(counts))}
+	for move, voters := range counts {
+		move.Voters = voters
+		result.Moves = append(result.Moves, move)
+	}
+	sort.Slice(result.Moves, func(i, j int) bool {
+		a, b := result.Moves[i], result.Moves[j]
+		return a.From < b.From || a.From == b.From && a.To < b.To
+	})
+
+	return result
+}
+
+// PreviewBoundaryChange returns how many voters a change would move
+// between which precincts, without changing anything
+func (t *VoterList) PreviewBoundaryChange(change BoundaryChange) (BoundaryResult, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if err := change.validate(); err != nil {
+		return BoundaryResult{}, err
+	}
+
+	return t.summarize(t.planBoundaryChange(change)), nil
+}
+
+// ApplyBoundaryChange reassigns every voter the change touches.  The
+// whole change is worked out before any voter is touched, so a bad file
+// changes nothing.  The old precinct goes to each voter's address
+// history, the address itself and MovedDate are left alone since the
+// voter did not move.  moved is called for every voter reassigned, with
+// the list locked, so it must not call back into the list.
+func (t *VoterList) ApplyBoundaryChange(change BoundaryChange, moved func(voterID, from, to int)) (BoundaryResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := change.validate(); err != nil {
+		return BoundaryResult{}, err
+	}
+
+	effectiveDate := change.EffectiveDate
+	if effectiveDate.IsZero() {
+		effectiveDate = time.Now()
+	}
+
+	plan := t.planBoundaryChange(change)
+	result := t.summarize(plan)
+	result.Applied = true
+	result.VoterIds = make([]int, 0, len(plan))
+	for id := range plan {
+		result.VoterIds = append(result.VoterIds, id)
+	}
+	sort.Ints(result.VoterIds)
+
+	for _, id := range result.VoterIds {
+		voter := cloneVoter(t.Voters[id])
+		voter.AddressHistory = append(voter.AddressHistory, AddressChange{
+			Address:       voter.Address,
+			PrecinctId:    voter.PrecinctId,
+			EffectiveDate: effectiveDate,
+		})
+		voter.PrecinctId = plan[id]
+		t.putVoter(voter)
+		moved(id, voter.AddressHistory[len(voter.AddressHistory)-1].PrecinctId, voter.PrecinctId)
+	}
+
+	return result, nil
+}
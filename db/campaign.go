@@ -0,0 +1,426 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Delivery states of a campaign recipient.  Sent messages move on to
+// delivered or bounced when the provider reports back.
+const (
+	DeliveryPending        = "pending"
+	DeliverySent           = "sent"
+	DeliveryDelivered      = "delivered"
+	DeliveryBounced        = "bounced"
+	DeliveryFailed         = "failed"
+	DeliveryOptedOut       = "opted_out"
+	DeliveryNoAddress      = "no_address"
+	DeliveryInvalidAddress = "invalid_address"
+)
+
+// Events a provider reports for a sent message
+const (
+	EventDelivered = "delivered"
+	EventBounced   = "bounced"
+	EventOpened    = "opened"
+)
+
+// Kinds of bounces, a hard bounce means the address does not work
+const (
+	BounceHard = "hard"
+	BounceSoft = "soft"
+)
+
+// Delivery is the delivery state of a campaign message to one voter
+type Delivery struct {
+	VoterId int
+	Address string
+	Status  string
+	Error   string
+	Locale  string //Translation sent to the voter, "" for the original text
+	Updated time.Time
+
+	//The message rendered for the voter when the campaign uses a template
+	Subject string
+	Body    string
+
+	//What the provider reported, opens are only known for providers that
+	//track them
+	Delivered time.Time
+	Opened    time.Time
+	Bounce    string //hard or soft
+}
+
+// sent reports whether the message was handed to the provider
+func (d Delivery) sent() bool {
+	return d.Status == DeliverySent || d.Status == DeliveryDelivered || d.Status == DeliveryBounced
+}
+
+// DeliveryEvent is a report from the provider about one sent message
+type DeliveryEvent struct {
+	VoterId int
+	Type    string //delivered, bounced or opened
+	Bounce  string //hard or soft, for bounces
+	Time    time.Time
+}
+
+// MessageTranslation is the subject and body of a campaign in one locale
+type MessageTranslation struct {
+	Subject string
+	Body    string
+}
+
+// Campaign is a message sent to every voter matching a filter
+type Campaign struct {
+	CampaignId int
+	Subject    string
+	Body       string
+	Channel    string
+	Segment    string //Saved segment the campaign targets, if any
+	Filter     VoterFilter
+	Created    time.Time
+	Deliveries []Delivery
+
+	//Translations of the subject and body keyed by locale, each voter is
+	//sent the one matching their language preference
+	Translations map[string]MessageTranslation
+
+	//Template the messages are rendered from instead of Subject and Body,
+	//and the version of it that was used
+	Template        string
+	TemplateVersion int
+}
+
+// Message returns the subject and body sent with a delivery: the message
+// rendered for the voter from the template, the translation picked for
+// the voter, or the original text
+func (c Campaign) Message(delivery Delivery) (subject, body string) {
+	if c.Template != "" {
+		return delivery.Subject, delivery.Body
+	}
+	if t, ok := c.Translations[delivery.Locale]; ok {
+		return t.Subject, t.Body
+	}
+	return c.Subject, c.Body
+}
+
+// Renderer renders the message of a template campaign for one voter
+type Renderer func(voter Voter) (RenderedMessage, error)
+
+// CampaignStore holds the campaigns
+type CampaignStore struct {
+	mu        sync.Mutex
+	campaigns map[int]Campaign
+	nextId    int
+}
+
+// constructor for CampaignStore struct
+func NewCampaignStore() *CampaignStore {
+	return &CampaignStore{
+		campaigns: make(map[int]Campaign),
+		nextId:    1,
+	}
+}
+
+// AddCampaign stores a new campaign for the given recipients.  Every
+// recipient starts out pending, except voters whose preferences do not
+// allow the campaign channel or that have no address, they are recorded as
+// such and are never sent anything.  A campaign with a template has its
+// message rendered for every pending recipient by render, a recipient the
+// template fails for is recorded as failed.
+func (s *CampaignStore) AddCampaign(campaign Campaign, recipients []Voter, render Renderer) (Campaign, error) {
+	switch {
+	case campaign.Template != "" && render == nil:
+		return Campaign{}, InvalidInput("campaign template can not be rendered")
+	case campaign.Template != "" && len(campaign.Translations) > 0:
+		return Campaign{}, InvalidInput("a campaign with a template has no translations")
+	case campaign.Template == "" && (campaign.Subject == "" || campaign.Body == ""):
+		return Campaign{}, InvalidInput("campaign needs a subject and a body")
+	}
+
+	translations, err := normalizeKeys(campaign.Translations)
+	if err != nil {
+		return Campaign{}, err
+	}
+	for locale, t := range translations {
+		if t.Subject == "" || t.Body == "" {
+			return Campaign{}, InvalidInput("translation " + locale + " needs a subject and a body")
+		}
+	}
+	campaign.Translations = translations
+	locales := localesOf(translations)
+
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].VoterId < recipients[j].VoterId
+	})
+
+	now := time.Now()
+	campaign.Deliveries = make([]Delivery, 0, len(recipients))
+	for _, voter := range recipients {
+		delivery := Delivery{
+			VoterId: voter.VoterId,
+			Address: voter.Email,
+			Status:  DeliveryPending,
+			Locale:  MatchLocale(locales, voter.Preferences.Language),
+			Updated: now,
+		}
+		switch {
+		case !voter.Preferences.Allows(campaign.Channel):
+			delivery.Status = DeliveryOptedOut
+		case voter.Email == "":
+			delivery.Status = DeliveryNoAddress
+		case voter.EmailInvalid:
+			delivery.Status = DeliveryInvalidAddress
+		case campaign.Template != "":
+			message, err := render(voter)
+			if err != nil {
+				delivery.Status = DeliveryFailed
+				delivery.Error = err.Error()
+				break
+			}
+			delivery.Subject, delivery.Body = message.Subject, message.Body
+		}
+		campaign.Deliveries = append(campaign.Deliveries, delivery)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaign.CampaignId = s.nextId
+	campaign.Created = now
+	s.nextId++
+	s.campaigns[campaign.CampaignId] = campaign
+
+	//Hand back a copy, the stored deliveries are updated while sending
+	campaign.Deliveries = append([]Delivery(nil), campaign.Deliveries...)
+	return campaign, nil
+}
+
+// SetDeliveryStatus records the outcome of sending to one recipient
+func (s *CampaignStore) SetDeliveryStatus(campaignID, voterID int, status string, sendErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaign, ok := s.campaigns[campaignID]
+	if !ok {
+		return NotFound("campaign does not exist")
+	}
+
+	for i, delivery := range campaign.Deliveries {
+		if delivery.VoterId == voterID {
+			campaign.Deliveries[i].Status = status
+			campaign.Deliveries[i].Updated = time.Now()
+			if sendErr != nil {
+				campaign.Deliveries[i].Error = sendErr.Error()
+			}
+			return nil
+		}
+	}
+
+	return NotFound("voter is not a recipient of the campaign")
+}
+
+// RecordEvent applies a provider report to the delivery of a sent message
+// and returns the delivery as it is now.  An open also proves delivery,
+// and a bounce reported after a delivery overrides it.
+func (s *CampaignStore) RecordEvent(campaignID int, event DeliveryEvent) (Delivery, error) {
+	switch event.Type {
+	case EventDelivered, EventOpened:
+	case EventBounced:
+		if event.Bounce != BounceHard && event.Bounce != BounceSoft {
+			return Delivery{}, InvalidInput(fmt.Sprintf("a bounce is %s or %s", BounceHard, BounceSoft))
+		}
+	default:
+		return Delivery{}, InvalidInput(fmt.Sprintf("unknown event %q, use %s, %s or %s", event.Type, EventDelivered, EventBounced, EventOpened))
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaign, ok := s.campaigns[campaignID]
+	if !ok {
+		return Delivery{}, NotFound("campaign does not exist")
+	}
+
+	for i := range campaign.Deliveries {
+		delivery := &campaign.Deliveries[i]
+		if delivery.VoterId != event.VoterId {
+			continue
+		}
+		if !delivery.sent() {
+			return Delivery{}, errors.New("no message was sent to the voter")
+		}
+
+		switch event.Type {
+		case EventDelivered:
+			if delivery.Status == DeliverySent {
+				delivery.Status = DeliveryDelivered
+				delivery.Delivered = event.Time
+			}
+		case EventOpened:
+			if delivery.Status == DeliverySent {
+				delivery.Status = DeliveryDelivered
+				delivery.Delivered = event.Time
+			}
+			if delivery.Opened.IsZero() {
+				delivery.Opened = event.Time
+			}
+		case EventBounced:
+			delivery.Status = DeliveryBounced
+			delivery.Bounce = event.Bounce
+		}
+		delivery.Updated = time.Now()
+
+		return *delivery, nil
+	}
+
+	return Delivery{}, NotFound("voter is not a recipient of the campaign")
+}
+
+// CampaignStats are the delivery counts of a campaign
+type CampaignStats struct {
+	CampaignId     int
+	Recipients     int
+	Pending        int
+	Sent           int //Handed to the provider, delivered and bounced included
+	Delivered      int
+	Bounced        int
+	HardBounced    int
+	Opened         int
+	Failed         int
+	OptedOut       int
+	NoAddress      int
+	InvalidAddress int
+	DeliveryRate   float64 //Delivered out of sent
+	OpenRate       float64 //Opened out of delivered
+}
+
+// GetCampaignStats counts the deliveries of a campaign by outcome
+func (s *CampaignStore) GetCampaignStats(id int) (CampaignStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaign, ok := s.campaigns[id]
+	if !ok {
+		return CampaignStats{}, NotFound("campaign does not exist")
+	}
+
+	stats := CampaignStats{CampaignId: id, Recipients: len(campaign.Deliveries)}
+	for _, delivery := range campaign.Deliveries {
+		if delivery.sent() {
+			stats.Sent++
+		}
+		if !delivery.Opened.IsZero() {
+			stats.Opened++
+		}
+
+		switch delivery.Status {
+		case DeliveryPending:
+			stats.Pending++
+		case DeliveryDelivered:
+			stats.Delivered++
+		case DeliveryBounced:
+			stats.Bounced++
+			if delivery.Bounce == BounceHard {
+				stats.HardBounced++
+			}
+		case DeliveryFailed:
+			stats.Failed++
+		case DeliveryOptedOut:
+			stats.OptedOut++
+		case DeliveryNoAddress:
+			stats.NoAddress++
+		case DeliveryInvalidAddress:
+			stats.InvalidAddress++
+		}
+	}
+
+	if stats.Sent > 0 {
+		stats.DeliveryRate = float64(stats.Delivered) / float64(stats.Sent)
+	}
+	if stats.Delivered > 0 {
+		stats.OpenRate = float64(stats.Opened) / float64(stats.Delivered)
+	}
+
+	return stats, nil
+}
+
+// GetCampaign returns a campaign by id
+func (s *CampaignStore) GetCampaign(id int) (Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaign, ok := s.campaigns[id]
+	if !ok {
+		return Campaign{}, NotFound("campaign does not exist")
+	}
+
+	//Copy the deliveries, the dispatcher keeps updating the stored slice
+	campaign.Deliveries = append([]Delivery(nil), campaign.Deliveries...)
+	return campaign, nil
+}
+
+// GetAllCampaigns returns all campaigns ordered by id, without their
+// per recipient deliveries
+func (s *CampaignStore) GetAllCampaigns() []Campaign {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	campaigns := make([]Campaign, 0, len(s.campaigns))
+	for _, campaign := range s.campaigns {
+		campaign.Deliveries = nil
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].CampaignId < campaigns[j].CampaignId
+	})
+
+	return campaigns
+}
+
+// VoterMessage is a campaign message as it was addressed to one voter, in
+// the locale it was sent in
+type VoterMessage struct {
+	CampaignId int
+	Subject    string
+	Body       string
+	Channel    string
+	Created    time.Time
+	Delivery   Delivery
+}
+
+// GetVoterMessages returns every campaign message addressed to a voter,
+// ordered by campaign id
+func (s *CampaignStore) GetVoterMessages(voterID int) []VoterMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]VoterMessage, 0)
+	for _, campaign := range s.campaigns {
+		for _, delivery := range campaign.Deliveries {
+			if delivery.VoterId != voterID {
+				continue
+			}
+			subject, body := campaign.Message(delivery)
+			messages = append(messages, VoterMessage{
+				CampaignId: campaign.CampaignId,
+				Subject:    subject,
+				Body:       body,
+				Channel:    campaign.Channel,
+				Created:    campaign.Created,
+				Delivery:   delivery,
+			})
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CampaignId < messages[j].CampaignId
+	})
+
+	return messages
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"reflect"
+	"time"
+)
+
+// SelfTestVoterId is the reserved voter id the self test writes and
+// deletes again.  It is negative so it can never clash with a real
+// registration, and its changes are never reported.
+const SelfTestVoterId = -1
+
+// ChangeBacklog is the number of recent changes a VoterList keeps at the
+// least, so a watcher that reconnects can pick up where it left off, see
+// WatchFrom
+const ChangeBacklog = 1024
+
+// Change is a change to the roll, as seen by the watchers of a VoterList
+type Change struct {
+	Seq     int64  //Numbers the changes of the list from 1, without gaps
+	Event   string //One of the Event constants, e.g. voter.created
+	Time    time.Time
+	VoterId int
+	PollId  int          //Poll of a vote event
+	Voter   Voter        //The voter after the change, before it for voter.deleted
+	Vote    VoterHistory //The vote of a vote event, before it for vote.deleted
+}
+
+// ValidEvent reports whether an event name is one of the Event constants
+// or AllEvents
+func ValidEvent(event string) bool {
+	return webhookEvents[event]
+}
+
+// Watch returns a channel that gets every change to the roll, whatever
+// backend or route made it, and a function that stops watching.  A watcher
+// that falls more than buffer changes behind is dropped, its channel is
+// closed so it can start over from a fresh read.  Decoys and the self
+// test voter are never reported.
+func (t *VoterList) Watch(buffer int) (<-chan Change, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.watch(buffer)
+}
+
+// WatchFrom is Watch for a watcher that already saw the changes up to
+// Seq after.  The changes since then that are still kept are returned
+// first, ahead of the channel, and complete is false when some of them are
+// no longer kept, or when after is from before a restart, in which case
+// the watcher has to start over from a fresh read.  A negative after
+// watches from now on, like Watch.
+func (t *VoterList) WatchFrom(after int64, buffer int) (missed []Change, complete bool, changes <-chan Change, stop func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if after < 0 {
+		after = t.changeSeq
+	}
+
+	complete = after <= t.changeSeq && (len(t.recent) == 0 || after >= t.recent[0].Seq-1)
+	for _, change := range t.recent {
+		if change.Seq > after {
+			missed = append(missed, change)
+		}
+	}
+
+	changes, stop = t.watch(buffer)
+	return missed, complete, changes, stop
+}
+
+// watch adds a watcher, the caller holds mu
+func (t *VoterList) watch(buffer int) (<-chan Change, func()) {
+	ch := make(chan Change, buffer)
+	if t.watchers == nil {
+		t.watchers = make(map[chan Change]bool)
+	}
+	t.watchers[ch] = true
+
+	stop := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.watchers[ch] {
+			delete(t.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, stop
+}
+
+// reported reports whether the changes of a voter go to the watchers,
+// which they do for everyone but decoys and the self test voter.  The
+// caller holds mu.
+func (t *VoterList) reported(id int) bool {
+	return !t.decoys[id] && id != SelfTestVoterId
+}
+
+// notify numbers changes, keeps them in the backlog and hands them to the
+// watchers.  The caller holds mu.
+func (t *VoterList) notify(changes []Change) {
+	for i := range changes {
+		t.changeSeq++
+		changes[i].Seq = t.changeSeq
+	}
+	//Trimmed once it holds twice the backlog, not on every change
+	t.recent = append(t.recent, changes...)
+	if len(t.recent) >= 2*ChangeBacklog {
+		t.recent = append([]Change(nil), t.recent[len(t.recent)-ChangeBacklog:]...)
+	}
+
+	for ch := range t.watchers {
+		for _, change := range changes {
+			select {
+			case ch <- change:
+				continue
+			default:
+			}
+			delete(t.watchers, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// voterChanges describes how a voter changed as events, the voter's own
+// fields first and then each vote.  existed is false for a new voter.
+func voterChanges(old Voter, existed bool, voter Voter, now time.Time) []Change {
+	if !existed {
+		return []Change{{Event: EventVoterCreated, Time: now, VoterId: voter.VoterId, Voter: cloneVoter(voter)}}
+	}
+
+	var changes []Change
+	before, after := old, voter
+	before.VoteHistory, after.VoteHistory = nil, nil
+	before.Archived, after.Archived = false, false
+	if !reflect.DeepEqual(before, after) {
+		changes = append(changes, Change{Event: EventVoterUpdated, Time: now, VoterId: voter.VoterId, Voter: cloneVoter(voter)})
+	}
+
+	votes := make(map[int]VoterHistory, len(old.VoteHistory))
+	for _, vote := range old.VoteHistory {
+		votes[vote.PollId] = vote
+	}
+	for _, vote := range voter.VoteHistory {
+		prior, voted := votes[vote.PollId]
+		delete(votes, vote.PollId)
+		event := EventVoteRecorded
+		if voted {
+			if reflect.DeepEqual(prior, vote) {
+				continue
+			}
+			event = EventVoteUpdated
+		}
+		changes = append(changes, Change{Event: event, Time: now, VoterId: voter.VoterId, PollId: vote.PollId,
+			Voter: cloneVoter(voter), Vote: vote})
+	}
+	for _, vote := range old.VoteHistory {
+		if _, deleted := votes[vote.PollId]; deleted {
+			changes = append(changes, Change{Event: EventVoteDeleted, Time: now, VoterId: voter.VoterId, PollId: vote.PollId,
+				Voter: cloneVoter(voter), Vote: vote})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// CheckIn records a voter being checked in at a polling place
+type CheckIn struct {
+	VoterId    int
+	DeviceId   int
+	PrecinctId int
+	Time       time.Time
+}
+
+// CheckInLog holds all check-ins in the order they happened
+type CheckInLog struct {
+	mu       sync.Mutex
+	checkIns []CheckIn
+}
+
+// constructor for CheckInLog struct
+func NewCheckInLog() *CheckInLog {
+	return &CheckInLog{}
+}
+
+// AddCheckIn records a check-in, a voter can only be checked in once
+func (l *CheckInLog) AddCheckIn(checkIn CheckIn) (CheckIn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, existing := range l.checkIns {
+		if existing.VoterId == checkIn.VoterId {
+			return CheckIn{}, AlreadyExists("voter is already checked in")
+		}
+	}
+
+	checkIn.Time = time.Now()
+	l.checkIns = append(l.checkIns, checkIn)
+
+	return checkIn, nil
+}
+
+// GetVoterCheckIns returns the check-ins of a voter
+func (l *CheckInLog) GetVoterCheckIns(voterID int) []CheckIn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	checkIns := make([]CheckIn, 0)
+	for _, checkIn := range l.checkIns {
+		if checkIn.VoterId == voterID {
+			checkIns = append(checkIns, checkIn)
+		}
+	}
+
+	return checkIns
+}
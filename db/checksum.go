@@ -0,0 +1,175 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// checksumBuckets is the number of leaves in the checksum tree.  Voters are
+// placed in a bucket by VoterId so that every instance builds a tree with
+// the same shape no matter which records it holds, that way two trees can
+// be compared node by node.  It must be a power of two.
+const checksumBuckets = 256
+
+// ChecksumTree is a Merkle tree built over the voter records.  Each leaf is
+// the hash of the records in one bucket, and each inner node is the hash of
+// its two children.  If the roots of two instances match they hold the same
+// data, if not the caller can walk down the levels to find the buckets that
+// differ and only compare the records in those buckets.
+type ChecksumTree struct {
+	levels [][]string //levels[0] holds the root, the last level the leaves
+	count  int
+}
+
+// checksumBucket returns the leaf bucket that a voter id belongs to
+func checksumBucket(voterID int) int {
+	bucket := voterID % checksumBuckets
+	if bucket < 0 {
+		bucket += checksumBuckets
+	}
+	return bucket
+}
+
+// hashVoter returns the hex encoded SHA-256 of the JSON form of a voter
+func hashVoter(voter Voter) string {
+	jsonBytes, _ := json.Marshal(voter)
+	sum := sha256.Sum256(jsonBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashPair combines two child hashes into the hash of their parent
+func hashPair(left, right string) string {
+	sum := sha256.Sum256([]byte(left + right))
+	return hex.EncodeToString(sum[:])
+}
+
+// buildChecksumTree builds the tree bottom up from the voters in the list
+func buildChecksumTree(voters map[int]Voter) *ChecksumTree {
+
+	//Collect the record hashes for each bucket, they get sorted by
+	//VoterId so the leaf hash does not depend on map iteration order
+	ids := make([]int, 0, len(voters))
+	for id := range voters {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	buckets := make([][]byte, checksumBuckets)
+	for _, id := range ids {
+		bucket := checksumBucket(id)
+		buckets[bucket] = append(buckets[bucket], hashVoter(voters[id])...)
+	}
+
+	leaves := make([]string, checksumBuckets)
+	for i, data := range buckets {
+		sum := sha256.Sum256(data)
+		leaves[i] = hex.EncodeToString(sum[:])
+	}
+
+	//Now fold the levels together until we reach the root
+	levels := [][]string{leaves}
+	for len(levels[0]) > 1 {
+		children := levels[0]
+		parents := make([]string, len(children)/2)
+		for i := range parents {
+			parents[i] = hashPair(children[2*i], children[2*i+1])
+		}
+		levels = append([][]string{parents}, levels...)
+	}
+
+	return &ChecksumTree{levels: levels, count: len(voters)}
+}
+
+// Root returns the root hash of the tree
+func (c *ChecksumTree) Root() string {
+	return c.levels[0][0]
+}
+
+// Count returns the number of voters covered by the tree
+func (c *ChecksumTree) Count() int {
+	return c.count
+}
+
+// Depth returns the depth of the leaf level, the root is at depth 0
+func (c *ChecksumTree) Depth() int {
+	return len(c.levels) - 1
+}
+
+// Level returns the node hashes at the given depth, ordered left to right.
+// Node i at depth d covers the buckets i*2^(Depth-d) up to (i+1)*2^(Depth-d)
+func (c *ChecksumTree) Level(depth int) ([]string, error) {
+	if depth < 0 || depth > c.Depth() {
+		return nil, InvalidInput("depth out of range")
+	}
+
+	return c.levels[depth], nil
+}
+
+// Checksum returns the checksum tree for the voter list.  The tree is kept
+// between calls and is only rebuilt after the list has been changed.
+func (t *VoterList) Checksum() *ChecksumTree {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.checksum == nil {
+		t.checksum = buildChecksumTree(t.Voters)
+	}
+
+	return t.checksum
+}
+
+// BucketChecksums returns the record hash of every voter in a leaf bucket
+// keyed by VoterId.  This is the last step of a reconciliation, once the
+// differing buckets are known only their records have to be compared.
+func (t *VoterList) BucketChecksums(bucket int) (map[int]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if bucket < 0 || bucket >= checksumBuckets {
+		return nil, InvalidInput("bucket out of range")
+	}
+
+	hashes := make(map[int]string)
+	for id, voter := range t.Voters {
+		if checksumBucket(id) == bucket {
+			hashes[id] = hashVoter(voter)
+		}
+	}
+
+	return hashes, nil
+}
+
+// VoterETag returns the strong ETag of a voter record, it changes
+// whenever any field of the record changes
+func VoterETag(voter Voter) string {
+	return `"` + hashVoter(voter) + `"`
+}
+
+// PrecinctManifest returns the ETag of every voter in a precinct keyed by
+// VoterId, along with an ETag over the whole manifest.  A device keeps the
+// ETags of the records it holds and only fetches the ones that are new or
+// differ, records missing from the manifest have left the precinct.
+func (t *VoterList) PrecinctManifest(precinctID int) (map[int]string, string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	etags := make(map[int]string)
+	ids := make([]int, 0)
+	for id, voter := range t.Voters {
+		if voter.PrecinctId == precinctID {
+			etags[id] = VoterETag(voter)
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	all := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(all, "%d=%s;", id, etags[id])
+	}
+
+	return etags, `"` + hex.EncodeToString(all.Sum(nil)) + `"`
+}
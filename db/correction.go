@@ -0,0 +1,105 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// VoteCorrection is an append only correction to a recorded vote.  The
+// vote itself is left as it was recorded, the correction references it by
+// VoteId and holds what it should have been.  Fields left zero are not
+// corrected.  A choice is never corrected, it may be sealed and is not
+// something an operator can know better than the voter.
+type VoteCorrection struct {
+	CorrectionId int
+	VoterId      int
+	PollId       int
+	VoteId       int //The vote the correction references
+	Time         time.Time
+	RequestId    string //Request that recorded the correction, links to the audit log
+	Reason       string `validate:"required,max=500"`
+	Void         bool   //The vote should never have been recorded and no longer counts
+	VoteDate     time.Time
+	Channel      string `validate:"max=32"`
+	DeviceId     int    `validate:"min=0"`
+	PrecinctId   int    `validate:"min=0"`
+}
+
+// CorrectionLog is the append only log of vote corrections, corrections
+// are never changed or removed once recorded
+type CorrectionLog struct {
+	mu          sync.Mutex
+	corrections []VoteCorrection
+}
+
+// constructor for CorrectionLog struct
+func NewCorrectionLog() *CorrectionLog {
+	return &CorrectionLog{}
+}
+
+// Record appends a correction to the log, it is numbered and timestamped
+// by the log
+func (l *CorrectionLog) Record(correction VoteCorrection) (VoteCorrection, error) {
+	if correction.Reason == "" {
+		return VoteCorrection{}, InvalidInput("a correction needs a reason")
+	}
+	if !correction.Void && correction.VoteDate.IsZero() && correction.Channel == "" &&
+		correction.DeviceId == 0 && correction.PrecinctId == 0 {
+		return VoteCorrection{}, InvalidInput("a correction has to void the vote or correct one of its fields")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	correction.CorrectionId = len(l.corrections) + 1
+	correction.Time = time.Now()
+	l.corrections = append(l.corrections, correction)
+	return correction, nil
+}
+
+// GetCorrections returns the corrections to a voter's vote in a poll,
+// oldest first.  A poll id of 0 returns the corrections to all of the
+// voter's votes.
+func (l *CorrectionLog) GetCorrections(voterID, pollID int) []VoteCorrection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	corrections := make([]VoteCorrection, 0)
+	for _, correction := range l.corrections {
+		if correction.VoterId == voterID && (pollID == 0 || correction.PollId == pollID) {
+			corrections = append(corrections, correction)
+		}
+	}
+
+	return corrections
+}
+
+// ApplyCorrections returns a vote as its corrections make it and whether
+// one of them voided it.  Corrections are applied oldest first, so a
+// later one wins, and corrections that reference another vote than the
+// one given are skipped.
+func ApplyCorrections(vote VoterHistory, corrections []VoteCorrection) (VoterHistory, bool) {
+	void := false
+	for _, correction := range corrections {
+		if correction.VoteId != vote.VoteId || correction.PollId != vote.PollId {
+			continue
+		}
+		if correction.Void {
+			void = true
+		}
+		if !correction.VoteDate.IsZero() {
+			vote.VoteDate = correction.VoteDate
+		}
+		if correction.Channel != "" {
+			vote.Channel = correction.Channel
+		}
+		if correction.DeviceId != 0 {
+			vote.DeviceId = correction.DeviceId
+		}
+		if correction.PrecinctId != 0 {
+			vote.PrecinctId = correction.PrecinctId
+		}
+	}
+
+	return vote, void
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DenyEntry is a network that is refused service.  A zero Expires never
+// expires.
+type DenyEntry struct {
+	Id      int
+	CIDR    string
+	Reason  string
+	Added   time.Time
+	Expires time.Time
+
+	prefix netip.Prefix
+}
+
+// DenyList is the dynamic list of blocked networks
+type DenyList struct {
+	mu      sync.Mutex
+	entries map[int]DenyEntry
+	nextId  int
+}
+
+// constructor for DenyList struct
+func NewDenyList() *DenyList {
+	return &DenyList{
+		entries: make(map[int]DenyEntry),
+		nextId:  1,
+	}
+}
+
+// ParsePrefix parses a CIDR such as 10.20.0.0/16.  A bare address is taken
+// to be a single host.
+func ParsePrefix(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, InvalidInput("invalid address " + cidr)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, InvalidInput("invalid CIDR " + cidr)
+	}
+	return prefix.Masked(), nil
+}
+
+// Add blocks a network and returns the entry with its assigned id
+func (d *DenyList) Add(entry DenyEntry) (DenyEntry, error) {
+	prefix, err := ParsePrefix(entry.CIDR)
+	if err != nil {
+		return DenyEntry{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry.Id = d.nextId
+	entry.CIDR = prefix.String()
+	entry.Added = time.Now()
+	entry.prefix = prefix
+	d.nextId++
+	d.entries[entry.Id] = entry
+
+	return entry, nil
+}
+
+// Remove unblocks a network by entry id
+func (d *DenyList) Remove(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[id]; !ok {
+		return NotFound("deny entry does not exist")
+	}
+	delete(d.entries, id)
+
+	return nil
+}
+
+// GetEntries returns the entries that have not expired, ordered by id
+func (d *DenyList) GetEntries() []DenyEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]DenyEntry, 0, len(d.entries))
+	for id, entry := range d.entries {
+		if !entry.Expires.IsZero() && now.After(entry.Expires) {
+			delete(d.entries, id)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Id < entries[j].Id
+	})
+
+	return entries
+}
+
+// Match returns the entry that blocks an address, if any
+func (d *DenyList) Match(addr netip.Addr) (DenyEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	addr = addr.Unmap()
+	now := time.Now()
+	for _, entry := range d.entries {
+		if !entry.Expires.IsZero() && now.After(entry.Expires) {
+			continue
+		}
+		if entry.prefix.Contains(addr) {
+			return entry, true
+		}
+	}
+
+	return DenyEntry{}, false
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device kinds that can be registered
+const (
+	DeviceKiosk  = "kiosk"  //Poll worker check-in kiosk
+	DeviceTablet = "tablet" //Voter registration tablet
+)
+
+// EnrollmentTTL is how long an enrollment token can be used
+const EnrollmentTTL = 24 * time.Hour
+
+// DeviceReport is the status a device sends with its heartbeat
+type DeviceReport struct {
+	AppVersion string
+	Battery    int
+}
+
+// Device is a registered field device such as a check-in kiosk.  A device
+// is registered by an admin, which hands out a one time enrollment token.
+// The device trades that token for its credential on first start.  The
+// store only keeps hashes of both tokens, never the tokens themselves.
+type Device struct {
+	DeviceId   int
+	Name       string
+	Kind       string
+	PrecinctId int
+	Registered time.Time
+	Enrolled   time.Time
+	LastSeen   time.Time
+	LastReport DeviceReport
+	Revoked    bool
+}
+
+// enrollment is an outstanding enrollment token
+type enrollment struct {
+	deviceId int
+	expires  time.Time
+}
+
+// DeviceStore holds the registered devices and their token hashes
+type DeviceStore struct {
+	mu          sync.Mutex
+	devices     map[int]Device
+	tokens      map[string]int        //credential hash -> DeviceId
+	enrollments map[string]enrollment //enrollment token hash -> enrollment
+	nextId      int
+}
+
+// constructor for DeviceStore struct
+func NewDeviceStore() *DeviceStore {
+	return &DeviceStore{
+		devices:     make(map[int]Device),
+		tokens:      make(map[string]int),
+		enrollments: make(map[string]enrollment),
+		nextId:      1,
+	}
+}
+
+// hashToken returns the hex SHA-256 of a device token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a new random token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validateDevice checks the fields an admin can set on a device
+func validateDevice(device Device) error {
+	switch device.Kind {
+	case DeviceKiosk:
+		if device.PrecinctId == 0 {
+			return InvalidInput("kiosk must be assigned to a precinct")
+		}
+	case DeviceTablet:
+	default:
+		return InvalidInput("unknown device kind " + device.Kind)
+	}
+
+	return nil
+}
+
+// issueEnrollment creates an enrollment token for a device, the caller
+// must hold the lock
+func (s *DeviceStore) issueEnrollment(id int) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.enrollments[hashToken(token)] = enrollment{
+		deviceId: id,
+		expires:  time.Now().Add(EnrollmentTTL),
+	}
+
+	return token, nil
+}
+
+// dropTokens removes every credential and enrollment token of a device,
+// the caller must hold the lock
+func (s *DeviceStore) dropTokens(id int) {
+	for hash, deviceId := range s.tokens {
+		if deviceId == id {
+			delete(s.tokens, hash)
+		}
+	}
+	for hash, e := range s.enrollments {
+		if e.deviceId == id {
+			delete(s.enrollments, hash)
+		}
+	}
+}
+
+// RegisterDevice adds a device and returns it along with an enrollment
+// token.  The token can not be recovered later, if it is lost the device
+// credentials have to be expired to get a new one.
+func (s *DeviceStore) RegisterDevice(device Device) (Device, string, error) {
+	if err := validateDevice(device); err != nil {
+		return Device{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device.DeviceId = s.nextId
+	device.Registered = time.Now()
+	device.Enrolled = time.Time{}
+	device.LastSeen = time.Time{}
+	device.LastReport = DeviceReport{}
+	device.Revoked = false
+
+	token, err := s.issueEnrollment(device.DeviceId)
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	s.nextId++
+	s.devices[device.DeviceId] = device
+
+	return device, token, nil
+}
+
+// Enroll trades an enrollment token for the device credential.  Each
+// enrollment token works only once.
+func (s *DeviceStore) Enroll(enrollmentToken string) (Device, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(enrollmentToken)
+	e, ok := s.enrollments[hash]
+	if !ok {
+		return Device{}, "", errors.New("unknown enrollment token")
+	}
+	delete(s.enrollments, hash)
+
+	if time.Now().After(e.expires) {
+		return Device{}, "", errors.New("enrollment token has expired")
+	}
+
+	device, ok := s.devices[e.deviceId]
+	if !ok || device.Revoked {
+		return Device{}, "", errors.New("device is not active")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	device.Enrolled = time.Now()
+	s.devices[device.DeviceId] = device
+	s.tokens[hashToken(token)] = device.DeviceId
+
+	return device, token, nil
+}
+
+// Authenticate returns the device a credential belongs to.  Revoked
+// devices are rejected.
+func (s *DeviceStore) Authenticate(token string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.tokens[hashToken(token)]
+	if !ok {
+		return Device{}, errors.New("unknown device token")
+	}
+
+	device := s.devices[id]
+	if device.Revoked {
+		return Device{}, errors.New("device has been revoked")
+	}
+
+	return device, nil
+}
+
+// Heartbeat marks a device as seen now.  A nil report only updates the
+// last seen time, used when a device is seen through a normal request.
+func (s *DeviceStore) Heartbeat(id int, report *DeviceReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return NotFound("device does not exist")
+	}
+
+	device.LastSeen = time.Now()
+	if report != nil {
+		device.LastReport = *report
+	}
+	s.devices[id] = device
+
+	return nil
+}
+
+// ExpireCredentials drops the credential of a device immediately, for
+// example when it has been lost or stolen.  A new enrollment token is
+// returned so the device can be enrolled again once it is recovered.
+func (s *DeviceStore) ExpireCredentials(id int) (Device, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return Device{}, "", NotFound("device does not exist")
+	}
+
+	s.dropTokens(id)
+	device.Enrolled = time.Time{}
+	s.devices[id] = device
+
+	token, err := s.issueEnrollment(id)
+	if err != nil {
+		return Device{}, "", err
+	}
+
+	return device, token, nil
+}
+
+// RevokeDevice blocks a device for good, its tokens stop working immediately
+func (s *DeviceStore) RevokeDevice(id int) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return Device{}, NotFound("device does not exist")
+	}
+
+	s.dropTokens(id)
+	device.Revoked = true
+	s.devices[id] = device
+
+	return device, nil
+}
+
+// GetDevice returns a device by id
+func (s *DeviceStore) GetDevice(id int) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return Device{}, NotFound("device does not exist")
+	}
+
+	return device, nil
+}
+
+// UpdateDevice changes the name, kind and precinct of a device, the
+// enrollment and heartbeat data is managed by the store
+func (s *DeviceStore) UpdateDevice(update Device) (Device, error) {
+	if err := validateDevice(update); err != nil {
+		return Device{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[update.DeviceId]
+	if !ok {
+		return Device{}, NotFound("device does not exist")
+	}
+
+	device.Name = update.Name
+	device.Kind = update.Kind
+	device.PrecinctId = update.PrecinctId
+	s.devices[device.DeviceId] = device
+
+	return device, nil
+}
+
+// DeleteDevice removes a device and all of its tokens
+func (s *DeviceStore) DeleteDevice(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.devices[id]; !ok {
+		return NotFound("device does not exist")
+	}
+
+	s.dropTokens(id)
+	delete(s.devices, id)
+
+	return nil
+}
+
+// GetAllDevices returns all registered devices ordered by id
+func (s *DeviceStore) GetAllDevices() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make([]Device, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceId < devices[j].DeviceId
+	})
+
+	return devices
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ElectionState is where an election is in its lifecycle
+type ElectionState string
+
+// The lifecycle of an election, in order
+const (
+	StateSetup            ElectionState = "setup"
+	StateRegistrationOpen ElectionState = "registration-open"
+	StateVoting           ElectionState = "voting"
+	StateCounting         ElectionState = "counting"
+	StateCertified        ElectionState = "certified"
+	StateArchived         ElectionState = "archived"
+)
+
+// electionTransitions are the states an election can move to from each
+// state.  Registration can be closed again while still in setup, from
+// voting on an election only moves forward.
+var electionTransitions = map[ElectionState][]ElectionState{
+	StateSetup:            {StateRegistrationOpen},
+	StateRegistrationOpen: {StateSetup, StateVoting},
+	StateVoting:           {StateCounting},
+	StateCounting:         {StateCertified},
+	StateCertified:        {StateArchived},
+}
+
+// ElectionTransition is one change of state of an election
+type ElectionTransition struct {
+	From ElectionState
+	To   ElectionState
+	By   string
+	Time time.Time
+}
+
+// ElectionHook is called when an election moves to a new state, before
+// the move is kept.  Returning an error refuses the transition.
+type ElectionHook func(election Election, from ElectionState) error
+
+// Election is a single election with its key dates.  Dates with a zero
+// value are not set for the election.
+type Election struct {
+	ElectionId           int
+	Name                 string
+	RegistrationDeadline time.Time
+	EarlyVotingStart     time.Time
+	EarlyVotingEnd       time.Time
+	ElectionDay          time.Time
+	PollIds              []int                //Polls on the ballot in this election
+	State                ElectionState        //Set by the server, changed with Transition
+	Transitions          []ElectionTransition //Set by the server
+}
+
+// ElectionList holds the elections
+type ElectionList struct {
+	mu        sync.Mutex
+	elections map[int]Election
+	hooks     map[ElectionState][]ElectionHook
+}
+
+// constructor for ElectionList struct
+func NewElectionList() *ElectionList {
+	return &ElectionList{
+		elections: make(map[int]Election),
+		hooks:     make(map[ElectionState][]ElectionHook),
+	}
+}
+
+// NextStates returns the states an election in the given state can move to
+func NextStates(state ElectionState) []ElectionState {
+	next := electionTransitions[state]
+	if next == nil {
+		return []ElectionState{}
+	}
+	return next
+}
+
+// OnTransition registers a hook that is called whenever an election moves
+// to the given state.  Hooks run in the order they were registered.
+func (l *ElectionList) OnTransition(to ElectionState, hook ElectionHook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks[to] = append(l.hooks[to], hook)
+}
+
+// validateElection checks that the dates of an election make sense
+func validateElection(election Election) error {
+	if election.Name == "" {
+		return InvalidInput("election name is required")
+	}
+	if election.ElectionDay.IsZero() {
+		return InvalidInput("election day is required")
+	}
+	if !election.RegistrationDeadline.IsZero() && election.RegistrationDeadline.After(election.ElectionDay) {
+		return InvalidInput("registration deadline must be before election day")
+	}
+	if election.EarlyVotingStart.IsZero() != election.EarlyVotingEnd.IsZero() {
+		return InvalidInput("early voting needs both a start and an end")
+	}
+	if election.EarlyVotingEnd.Before(election.EarlyVotingStart) {
+		return InvalidInput("early voting must end after it starts")
+	}
+
+	return nil
+}
+
+// AddElection adds an election, the id must not be in use
+func (l *ElectionList) AddElection(election Election) error {
+	if err := validateElection(election); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.elections[election.ElectionId]; ok {
+		return AlreadyExists("election already exists")
+	}
+	if election.State != "" && election.State != StateSetup {
+		return InvalidInput("a new election starts in setup")
+	}
+	election.State = StateSetup
+	election.Transitions = []ElectionTransition{}
+
+	l.elections[election.ElectionId] = election
+	return nil
+}
+
+// UpdateElection replaces an existing election
+func (l *ElectionList) UpdateElection(election Election) error {
+	if err := validateElection(election); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, ok := l.elections[election.ElectionId]
+	if !ok {
+		return NotFound("election does not exist")
+	}
+	//The state only moves through a transition, so its hooks always run
+	if election.State != "" && election.State != existing.State {
+		return InvalidInput("the state of an election can only be changed by a transition")
+	}
+	if existing.State != StateSetup && existing.State != StateRegistrationOpen &&
+		!sameInts(election.PollIds, existing.PollIds) {
+		return InvalidInput("the polls of an election can not change once voting has started")
+	}
+	election.State = existing.State
+	election.Transitions = existing.Transitions
+
+	l.elections[election.ElectionId] = election
+	return nil
+}
+
+// Transition moves an election to a new state.  The move has to be one of
+// the allowed transitions, and every hook registered for the new state has
+// to accept it.
+func (l *ElectionList) Transition(id int, to ElectionState, by string) (Election, error) {
+	l.mu.Lock()
+	election, ok := l.elections[id]
+	hooks := l.hooks[to]
+	l.mu.Unlock()
+
+	if !ok {
+		return Election{}, NotFound("election does not exist")
+	}
+	from := election.State
+	if !canTransition(from, to) {
+		return Election{}, fmt.Errorf("an election can not move from %s to %s, allowed: %s",
+			from, to, statesString(NextStates(from)))
+	}
+
+	//Hooks run without the lock so they may look up elections themselves
+	election.State = to
+	for _, hook := range hooks {
+		if err := hook(election, from); err != nil {
+			return Election{}, err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.elections[id]
+	if !ok {
+		return Election{}, NotFound("election does not exist")
+	}
+	if current.State != from {
+		return Election{}, errors.New("the election changed state, try again")
+	}
+	current.State = to
+	current.Transitions = append(current.Transitions, ElectionTransition{
+		From: from, To: to, By: by, Time: time.Now(),
+	})
+	l.elections[id] = current
+
+	return current, nil
+}
+
+// InState returns the elections currently in one of the given states
+func (l *ElectionList) InState(states ...ElectionState) []Election {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elections := make([]Election, 0)
+	for _, election := range l.elections {
+		for _, state := range states {
+			if election.State == state {
+				elections = append(elections, election)
+				break
+			}
+		}
+	}
+	sort.Slice(elections, func(i, j int) bool {
+		return elections[i].ElectionId < elections[j].ElectionId
+	})
+
+	return elections
+}
+
+// canTransition reports whether an election may move between two states
+func canTransition(from, to ElectionState) bool {
+	for _, next := range electionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statesString lists states for an error message
+func statesString(states []ElectionState) string {
+	if len(states) == 0 {
+		return "none"
+	}
+	names := make([]string, len(states))
+	for i, state := range states {
+		names[i] = string(state)
+	}
+	return strings.Join(names, ", ")
+}
+
+// sameInts reports whether two id lists hold the same ids in the same order
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteElection removes an election.  Only elections that have not
+// opened registration yet, or that have been archived, can be removed.
+func (l *ElectionList) DeleteElection(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	election, ok := l.elections[id]
+	if !ok {
+		return NotFound("election does not exist")
+	}
+	if election.State != StateSetup && election.State != StateArchived {
+		return fmt.Errorf("an election in %s can not be deleted", election.State)
+	}
+
+	delete(l.elections, id)
+	return nil
+}
+
+// GetElection returns an election by id
+func (l *ElectionList) GetElection(id int) (Election, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	election, ok := l.elections[id]
+	if !ok {
+		return Election{}, NotFound("election does not exist")
+	}
+
+	return election, nil
+}
+
+// GetAllElections returns all elections ordered by election day
+func (l *ElectionList) GetAllElections() []Election {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elections := make([]Election, 0, len(l.elections))
+	for _, election := range l.elections {
+		elections = append(elections, election)
+	}
+	sort.Slice(elections, func(i, j int) bool {
+		return elections[i].ElectionDay.Before(elections[j].ElectionDay)
+	})
+
+	return elections
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Eligibility rules, a voter is eligible for a poll when none of them fail
+const (
+	RuleActiveRegistration = "active_registration" //Status is empty or active
+	RuleNotArchived        = "not_archived"        //Archived voters must be reactivated first
+	RuleAssignedPrecinct   = "assigned_precinct"   //The voter must have a precinct
+	RuleNotVoted           = "not_already_voted"   //No history entry for the poll yet
+	RulePollOpen           = "poll_open"           //The poll has not closed
+	RulePollNotLocked      = "poll_not_locked"     //The poll's election is still voting
+)
+
+// Actions that clear a failed rule.  Rules missing from ruleActions can not
+// be cleared by anything the voter or a clerk does.
+const (
+	ActionReactivateRegistration = "reactivate_registration"
+	ActionRestoreRecord          = "restore_record"
+	ActionAssignPrecinct         = "assign_precinct"
+)
+
+var ruleActions = map[string]string{
+	RuleActiveRegistration: ActionReactivateRegistration,
+	RuleNotArchived:        ActionRestoreRecord,
+	RuleAssignedPrecinct:   ActionAssignPrecinct,
+}
+
+// Eligibility is the outcome of checking a voter against a poll.
+// EffectivePrecinct is the precinct the voter votes in today, which is
+// the old one while a move has not taken effect yet.
+type Eligibility struct {
+	PollId            int
+	Eligible          bool
+	FailedRules       []string
+	EffectivePrecinct int
+	RequiredActions   []string
+}
+
+// Fail records a failed rule along with the action that clears it
+func (e *Eligibility) Fail(rule string) {
+	e.FailedRules = append(e.FailedRules, rule)
+	if action, ok := ruleActions[rule]; ok {
+		e.RequiredActions = append(e.RequiredActions, action)
+	}
+	e.Eligible = false
+}
+
+// HistorySummary counts the history of a voter
+type HistorySummary struct {
+	Total        int
+	ByChannel    map[string]int
+	LastVoteDate time.Time
+}
+
+// EvaluateEligibility checks a voter against the eligibility rules for a
+// poll.  It only reads, nothing is recorded.
+func EvaluateEligibility(voter Voter, pollID int) Eligibility {
+	result := Eligibility{
+		PollId:            pollID,
+		Eligible:          true,
+		FailedRules:       []string{},
+		EffectivePrecinct: EffectivePrecinct(voter, time.Now()),
+		RequiredActions:   []string{},
+	}
+
+	if status := strings.ToLower(voter.Status); status != "" && status != "active" {
+		result.Fail(RuleActiveRegistration)
+	}
+	if voter.Archived {
+		result.Fail(RuleNotArchived)
+	}
+	if result.EffectivePrecinct == 0 {
+		result.Fail(RuleAssignedPrecinct)
+	}
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			result.Fail(RuleNotVoted)
+			break
+		}
+	}
+
+	return result
+}
+
+// EffectivePrecinct returns the precinct a voter belongs to at a time.  A
+// move or boundary change dated in the future has not taken effect yet,
+// until then the voter stays in the precinct they are leaving.
+func EffectivePrecinct(voter Voter, at time.Time) int {
+	precinct := voter.PrecinctId
+	for i := len(voter.AddressHistory) - 1; i >= 0; i-- {
+		change := voter.AddressHistory[i]
+		if !change.EffectiveDate.After(at) {
+			break
+		}
+		precinct = change.PrecinctId
+	}
+	return precinct
+}
+
+// SummarizeHistory counts the history entries of a voter by channel
+func SummarizeHistory(voter Voter) HistorySummary {
+	summary := HistorySummary{ByChannel: make(map[string]int)}
+	for _, history := range voter.VoteHistory {
+		channel := history.Channel
+		if channel == "" {
+			channel = channelUnknown
+		}
+		summary.Total++
+		summary.ByChannel[channel]++
+		if history.VoteDate.After(summary.LastVoteDate) {
+			summary.LastVoteDate = history.VoteDate
+		}
+	}
+
+	return summary
+}
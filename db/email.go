@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EmailKey is the form an email address is indexed under.  Addresses are
+// compared trimmed and without regard to case, the way sameEmail compares
+// them, so Jane@Example.com and jane@example.com are the same address.
+// An empty key is never indexed, any number of voters may have no email.
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailTaken returns the error for a voter whose email address is
+// registered to another voter, backends that enforce the index themselves
+// return it too
+func EmailTaken(email string) error {
+	return AlreadyExists(fmt.Sprintf("email %s is already registered to another voter", strings.TrimSpace(email)))
+}
+
+// emailIndex maps email addresses to the voter registered with them.  It
+// is kept up to date by putVoter and removeVoter, archived voters stay in
+// it since archiving does not take them off the roll.
+type emailIndex struct {
+	ids    map[string]int //Voter id by EmailKey
+	emails map[int]string //EmailKey by voter id, to drop the old key on a change
+}
+
+// lookup returns the id of the voter registered with an email address
+func (x *emailIndex) lookup(email string) (int, bool) {
+	key := EmailKey(email)
+	if key == "" {
+		return 0, false
+	}
+	id, ok := x.ids[key]
+	return id, ok
+}
+
+// set records the email address of a voter, replacing the one it had
+func (x *emailIndex) set(id int, email string) {
+	x.remove(id)
+
+	key := EmailKey(email)
+	if key == "" {
+		return
+	}
+	if x.ids == nil {
+		x.ids = make(map[string]int)
+		x.emails = make(map[int]string)
+	}
+	x.ids[key] = id
+	x.emails[id] = key
+}
+
+// remove drops the email address of a voter
+func (x *emailIndex) remove(id int) {
+	key, ok := x.emails[id]
+	if !ok {
+		return
+	}
+	delete(x.emails, id)
+	if x.ids[key] == id {
+		delete(x.ids, key)
+	}
+}
+
+// checkEmail returns an error if the email address of the voter is
+// registered to another voter.  The caller holds mu.
+func (t *VoterList) checkEmail(voter Voter) error {
+	if id, ok := t.emails.lookup(voter.Email); ok && id != voter.VoterId {
+		return EmailTaken(voter.Email)
+	}
+	return nil
+}
+
+// GetVoterByEmail returns the voter registered with an email address,
+// looked up in the email index instead of scanning the roll
+func (t *VoterList) GetVoterByEmail(email string) (Voter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	id, ok := t.emails.lookup(email)
+	if !ok {
+		return Voter{}, NotFound("no voter is registered with this email")
+	}
+	return t.getVoter(id)
+}
@@ -0,0 +1,41 @@
+package db
+
+import "errors"
+
+// The kinds of error a store returns.  The errors carry messages of their
+// own, test for the kind with errors.Is, e.g. errors.Is(err, ErrNotFound).
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrInvalidInput  = errors.New("invalid input")
+)
+
+// kindError is an error with its own message that is one of the kinds
+// above
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// NotFound returns an ErrNotFound with the given message
+func NotFound(message string) error {
+	return &kindError{kind: ErrNotFound, message: message}
+}
+
+// AlreadyExists returns an ErrAlreadyExists with the given message
+func AlreadyExists(message string) error {
+	return &kindError{kind: ErrAlreadyExists, message: message}
+}
+
+// InvalidInput returns an ErrInvalidInput with the given message
+func InvalidInput(message string) error {
+	return &kindError{kind: ErrInvalidInput, message: message}
+}
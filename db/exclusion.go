@@ -0,0 +1,223 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExclusionEntry is a single person on an uploaded exclusion list, for
+// example a death record or a felony conviction from the state
+type ExclusionEntry struct {
+	Name   string
+	Email  string
+	Reason string
+}
+
+// ExclusionList is an uploaded exclusion list
+type ExclusionList struct {
+	Id       int
+	Name     string
+	Uploaded time.Time
+	Entries  []ExclusionEntry
+}
+
+// ExclusionCandidate is a voter that matched an exclusion entry.  Matches
+// are only flagged for review, the voter record is never changed by a match
+type ExclusionCandidate struct {
+	VoterId int
+	Name    string
+	Email   string
+	Entry   ExclusionEntry
+	Score   float64
+}
+
+// ExclusionReport is the result of matching the roll against a list
+type ExclusionReport struct {
+	Id         int
+	ListId     int
+	Keys       []string
+	Threshold  float64
+	Created    time.Time
+	Candidates []ExclusionCandidate
+}
+
+// exclusionKeys are the voter fields that can be used to match a list
+var exclusionKeys = map[string]bool{"name": true, "email": true}
+
+// DefaultExclusionThreshold is the match score used when none is given
+const DefaultExclusionThreshold = 0.9
+
+// ExclusionStore holds the uploaded exclusion lists and the match reports
+type ExclusionStore struct {
+	mu           sync.Mutex
+	lists        map[int]ExclusionList
+	reports      map[int]ExclusionReport
+	nextListId   int
+	nextReportId int
+}
+
+// constructor for ExclusionStore struct
+func NewExclusionStore() *ExclusionStore {
+	return &ExclusionStore{
+		lists:        make(map[int]ExclusionList),
+		reports:      make(map[int]ExclusionReport),
+		nextListId:   1,
+		nextReportId: 1,
+	}
+}
+
+// AddList stores an uploaded list and returns it with its assigned id
+func (s *ExclusionStore) AddList(list ExclusionList) (ExclusionList, error) {
+	if len(list.Entries) == 0 {
+		return ExclusionList{}, InvalidInput("exclusion list has no entries")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list.Id = s.nextListId
+	list.Uploaded = time.Now()
+	s.nextListId++
+	s.lists[list.Id] = list
+
+	return list, nil
+}
+
+// GetList returns an uploaded list by id
+func (s *ExclusionStore) GetList(id int) (ExclusionList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, ok := s.lists[id]
+	if !ok {
+		return ExclusionList{}, NotFound("exclusion list does not exist")
+	}
+
+	return list, nil
+}
+
+// AddReport stores a match report and returns it with its assigned id
+func (s *ExclusionStore) AddReport(report ExclusionReport) ExclusionReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report.Id = s.nextReportId
+	report.Created = time.Now()
+	s.nextReportId++
+	s.reports[report.Id] = report
+
+	return report
+}
+
+// GetReport returns a match report by id
+func (s *ExclusionStore) GetReport(id int) (ExclusionReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, ok := s.reports[id]
+	if !ok {
+		return ExclusionReport{}, NotFound("exclusion report does not exist")
+	}
+
+	return report, nil
+}
+
+// MatchExclusions compares every voter against the entries of an exclusion
+// list.  Each key ("name", "email") is scored between 0 and 1 by edit
+// distance and the scores are averaged, voters scoring at or above the
+// threshold are returned as candidates, best match first.
+func (t *VoterList) MatchExclusions(list ExclusionList, keys []string, threshold float64) ([]ExclusionCandidate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil, InvalidInput("at least one match key is required")
+	}
+	for _, key := range keys {
+		if !exclusionKeys[key] {
+			return nil, InvalidInput("unknown match key " + key)
+		}
+	}
+	if threshold <= 0 || threshold > 1 {
+		return nil, InvalidInput("threshold must be between 0 and 1")
+	}
+
+	candidates := make([]ExclusionCandidate, 0)
+	for _, voter := range t.Voters {
+		for _, entry := range list.Entries {
+			var total float64
+			for _, key := range keys {
+				switch key {
+				case "name":
+					total += similarity(voter.Name, entry.Name)
+				case "email":
+					total += similarity(voter.Email, entry.Email)
+				}
+			}
+
+			score := total / float64(len(keys))
+			if score >= threshold {
+				candidates = append(candidates, ExclusionCandidate{
+					VoterId: voter.VoterId,
+					Name:    voter.Name,
+					Email:   voter.Email,
+					Entry:   entry,
+					Score:   score,
+				})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].VoterId < candidates[j].VoterId
+	})
+
+	return candidates, nil
+}
+
+// similarity returns 1 for identical strings and falls towards 0 as the
+// edit distance between them grows.  Case and outer spaces are ignored and
+// an empty value never matches.
+func similarity(a, b string) float64 {
+	a = strings.ToLower(strings.TrimSpace(a))
+	b = strings.ToLower(strings.TrimSpace(b))
+	if a == "" || b == "" {
+		return 0
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// Poll extension states
+const (
+	ExtensionPending  = "pending"
+	ExtensionApproved = "approved"
+	ExtensionRejected = "rejected"
+)
+
+// PollExtension moves the close time of a poll, usually on a court order.
+// It is requested by one operator and only takes effect once a second
+// operator approves it.
+type PollExtension struct {
+	ExtensionId   int
+	PollId        int
+	Status        string
+	PreviousClose time.Time
+	NewClose      time.Time
+	Justification string
+	RequestedBy   string
+	Requested     time.Time
+	DecidedBy     string //Operator that approved or rejected the extension
+	Decided       time.Time
+}
+
+// RequestExtension records a pending extension of a poll.  A poll can
+// only have one pending extension at a time.
+func (l *PollList) RequestExtension(pollID int, newClose time.Time, justification, by string) (PollExtension, error) {
+	if justification == "" {
+		return PollExtension{}, InvalidInput("a justification is required to extend a poll")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	poll, ok := l.polls[pollID]
+	if !ok {
+		return PollExtension{}, NotFound("poll does not exist")
+	}
+	if poll.Closes.IsZero() {
+		return PollExtension{}, InvalidInput("poll has no close time to extend")
+	}
+	if l.locked[pollID] != 0 {
+		return PollExtension{}, errors.New("poll has been locked by its election")
+	}
+	if !newClose.After(poll.Closes) || !newClose.After(time.Now()) {
+		return PollExtension{}, InvalidInput("the new close time must be after the current close time and in the future")
+	}
+	for _, extension := range l.extensions {
+		if extension.PollId == pollID && extension.Status == ExtensionPending {
+			return PollExtension{}, errors.New("poll already has a pending extension")
+		}
+	}
+
+	extension := PollExtension{
+		ExtensionId:   len(l.extensions) + 1,
+		PollId:        pollID,
+		Status:        ExtensionPending,
+		PreviousClose: poll.Closes,
+		NewClose:      newClose,
+		Justification: justification,
+		RequestedBy:   by,
+		Requested:     time.Now(),
+	}
+	l.extensions = append(l.extensions, extension)
+
+	return extension, nil
+}
+
+// DecideExtension approves or rejects a pending extension.  The operator
+// deciding must not be the one that requested it.  An approved extension
+// moves the close time of the poll.
+func (l *PollList) DecideExtension(pollID, extensionID int, approve bool, by string) (PollExtension, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	index := extensionID - 1
+	if index < 0 || index >= len(l.extensions) || l.extensions[index].PollId != pollID {
+		return PollExtension{}, NotFound("extension does not exist")
+	}
+	extension := l.extensions[index]
+	if extension.Status != ExtensionPending {
+		return PollExtension{}, errors.New("extension has already been " + extension.Status)
+	}
+	if extension.RequestedBy == by {
+		return PollExtension{}, errors.New("an extension must be decided by someone other than the requester")
+	}
+
+	poll, ok := l.polls[pollID]
+	if !ok {
+		return PollExtension{}, NotFound("poll does not exist")
+	}
+
+	extension.Status = ExtensionRejected
+	if approve {
+		//The close time may have moved since the request, never shorten it
+		if !extension.NewClose.After(poll.Closes) {
+			return PollExtension{}, errors.New("the poll already closes after the requested time")
+		}
+		extension.Status = ExtensionApproved
+		extension.PreviousClose = poll.Closes
+		poll.Closes = extension.NewClose
+		l.polls[pollID] = poll
+	}
+	extension.DecidedBy = by
+	extension.Decided = time.Now()
+	l.extensions[index] = extension
+
+	return extension, nil
+}
+
+// GetExtensions returns the extensions of a poll, oldest first
+func (l *PollList) GetExtensions(pollID int) []PollExtension {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	extensions := make([]PollExtension, 0)
+	for _, extension := range l.extensions {
+		if extension.PollId == pollID {
+			extensions = append(extensions, extension)
+		}
+	}
+
+	return extensions
+}
+
+// ExtensionAt returns the approved extension of a poll that covers the
+// given time, that is a time after the poll would have closed without it.
+// The id is 0 when the time is within the regular hours of the poll.
+func (l *PollList) ExtensionAt(pollID int, t time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, extension := range l.extensions {
+		if extension.PollId == pollID && extension.Status == ExtensionApproved &&
+			t.After(extension.PreviousClose) && !t.After(extension.NewClose) {
+			return extension.ExtensionId
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// VoterFilter selects voters for queries and exports.  Zero valued fields
+// are ignored, so an empty filter matches every voter.
+type VoterFilter struct {
+	Name             string //Case insensitive substring of the voter name
+	Email            string //Case insensitive, the whole address has to match
+	MovedSince       time.Time
+	RegisteredAfter  time.Time //Registered on or after
+	RegisteredBefore time.Time //Registered before
+	PrecinctId       int
+	PrecinctIds      []int //Any of these precincts, e.g. a caller's jurisdictions
+	Status           string
+	Tag              string
+}
+
+// Override returns the filter with every field that is set in other
+// replacing the matching field of f
+func (f VoterFilter) Override(other VoterFilter) VoterFilter {
+	if other.Name != "" {
+		f.Name = other.Name
+	}
+	if other.Email != "" {
+		f.Email = other.Email
+	}
+	if !other.MovedSince.IsZero() {
+		f.MovedSince = other.MovedSince
+	}
+	if !other.RegisteredAfter.IsZero() {
+		f.RegisteredAfter = other.RegisteredAfter
+	}
+	if !other.RegisteredBefore.IsZero() {
+		f.RegisteredBefore = other.RegisteredBefore
+	}
+	if other.PrecinctId != 0 {
+		f.PrecinctId = other.PrecinctId
+	}
+	if other.PrecinctIds != nil {
+		f.PrecinctIds = other.PrecinctIds
+	}
+	if other.Status != "" {
+		f.Status = other.Status
+	}
+	if other.Tag != "" {
+		f.Tag = other.Tag
+	}
+
+	return f
+}
+
+// matches reports whether a voter passes the filter
+func (f VoterFilter) matches(voter Voter) bool {
+	if f.Name != "" && !strings.Contains(strings.ToLower(voter.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if f.Email != "" && !sameEmail(voter.Email, f.Email) {
+		return false
+	}
+	if !f.MovedSince.IsZero() && voter.MovedDate.Before(f.MovedSince) {
+		return false
+	}
+	if !f.RegisteredAfter.IsZero() && voter.Registered.Before(f.RegisteredAfter) {
+		return false
+	}
+	if !f.RegisteredBefore.IsZero() && !voter.Registered.Before(f.RegisteredBefore) {
+		return false
+	}
+	if f.PrecinctId != 0 && voter.PrecinctId != f.PrecinctId {
+		return false
+	}
+	if f.PrecinctIds != nil && !containsInt(f.PrecinctIds, voter.PrecinctId) {
+		return false
+	}
+	if f.Status != "" && voter.Status != f.Status {
+		return false
+	}
+	if f.Tag != "" && !hasTag(voter, f.Tag) {
+		return false
+	}
+
+	return true
+}
+
+// containsInt reports whether a list holds a value
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields voter lists can be sorted by
+const (
+	SortVoterId = "voterid"
+	SortName    = "name"
+	SortEmail   = "email"
+)
+
+// VoterSort is the order of a voter list.  The zero value orders by
+// VoterId, lowest first.
+type VoterSort struct {
+	Field      string
+	Descending bool
+}
+
+// Validate checks that the sort field is one voters can be sorted by
+func (s VoterSort) Validate() error {
+	switch s.Field {
+	case "", SortVoterId, SortName, SortEmail:
+		return nil
+	}
+	return InvalidInput("voters can be sorted by " + SortName + ", " + SortEmail + " or " + SortVoterId)
+}
+
+// less reports whether voter a goes before voter b.  Names and emails
+// compare case insensitively, ties are broken by VoterId so the order is
+// the same on every call and pages do not overlap.
+func (s VoterSort) less(a, b Voter) bool {
+	var ka, kb string
+	switch s.Field {
+	case SortName:
+		ka, kb = strings.ToLower(a.Name), strings.ToLower(b.Name)
+	case SortEmail:
+		ka, kb = strings.ToLower(strings.TrimSpace(a.Email)), strings.ToLower(strings.TrimSpace(b.Email))
+	}
+
+	if ka == kb {
+		if s.Descending {
+			return a.VoterId > b.VoterId
+		}
+		return a.VoterId < b.VoterId
+	}
+	if s.Descending {
+		return ka > kb
+	}
+	return ka < kb
+}
+
+// hasTag reports whether the voter carries a tag
+func hasTag(voter Voter, tag string) bool {
+	for _, t := range voter.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// FindVoters returns all voters that match the filter
+func (t *VoterList) FindVoters(filter VoterFilter) ([]Voter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.findVoters(filter)
+}
+
+// findVoters returns copies of the voters that match, the caller holds mu
+func (t *VoterList) findVoters(filter VoterFilter) ([]Voter, error) {
+	var voterList []Voter
+
+	for _, voter := range t.Voters {
+		if filter.matches(voter) {
+			voterList = append(voterList, cloneVoter(voter))
+		}
+	}
+
+	return voterList, nil
+}
+
+// SearchVoters returns one page of the voters that match the filter, in
+// the given order, along with the number of matches across all pages.  A
+// limit of 0 returns every match from the offset on.  Only the voters on
+// the page are copied, so paging through a large roll stays cheap.
+func (t *VoterList) SearchVoters(filter VoterFilter, order VoterSort, offset, limit int) ([]Voter, int, error) {
+	if err := order.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]int, 0)
+	for id, voter := range t.Voters {
+		if filter.matches(voter) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return order.less(t.Voters[ids[i]], t.Voters[ids[j]])
+	})
+
+	start := min(offset, len(ids))
+	end := len(ids)
+	if limit > 0 {
+		end = min(start+limit, len(ids))
+	}
+
+	page := make([]Voter, 0, end-start)
+	for _, id := range ids[start:end] {
+		page = append(page, cloneVoter(t.Voters[id]))
+	}
+
+	return page, len(ids), nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SeedDecoys adds honeypot voters to the list.  Decoys are ordinary voter
+// records as far as every response is concerned, only the list knows they
+// are fake.  No legitimate task ever needs to read one, so a read is a
+// strong sign that someone is walking the roll who should not be.
+func (t *VoterList) SeedDecoys(voters []Voter) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(voters) == 0 {
+		return nil, InvalidInput("no decoy voters given")
+	}
+	for _, voter := range voters {
+		if _, err := t.getVoter(voter.VoterId); err == nil {
+			return nil, AlreadyExists("voter already exists")
+		}
+	}
+
+	if t.decoys == nil {
+		t.decoys = make(map[int]bool)
+	}
+
+	ids := make([]int, 0, len(voters))
+	for _, voter := range voters {
+		//Marked first, so watchers never hear of the decoy
+		t.decoys[voter.VoterId] = true
+		if err := t.addVoter(voter); err != nil {
+			delete(t.decoys, voter.VoterId)
+			return ids, err
+		}
+		ids = append(ids, voter.VoterId)
+	}
+
+	return ids, nil
+}
+
+// IsDecoy reports whether a voter id is a honeypot record
+func (t *VoterList) IsDecoy(id int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.decoys[id]
+}
+
+// DecoyIds returns the ids of the honeypot records in ascending order
+func (t *VoterList) DecoyIds() []int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]int, 0, len(t.decoys))
+	for id := range t.decoys {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// TripwireAlert is raised when a response touched one or more decoys.  It
+// carries everything known about who made the request.
+type TripwireAlert struct {
+	Id        int
+	Time      time.Time
+	DecoyIds  []int
+	Principal string
+	Role      string
+	SourceIP  string
+	UserAgent string
+	Method    string
+	URL       string //Path and query string, so the filters used are kept
+}
+
+// TripwireLog holds the alerts that have been raised
+type TripwireLog struct {
+	mu     sync.Mutex
+	alerts []TripwireAlert
+	nextId int
+}
+
+// constructor for TripwireLog struct
+func NewTripwireLog() *TripwireLog {
+	return &TripwireLog{nextId: 1}
+}
+
+// Record stores an alert and returns it with its assigned id
+func (l *TripwireLog) Record(alert TripwireAlert) TripwireAlert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	alert.Id = l.nextId
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	l.nextId++
+	l.alerts = append(l.alerts, alert)
+
+	return alert
+}
+
+// GetAlerts returns every alert, oldest first
+func (l *TripwireLog) GetAlerts() []TripwireAlert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	alerts := make([]TripwireAlert, len(l.alerts))
+	copy(alerts, l.alerts)
+
+	return alerts
+}
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Jurisdiction levels, from the root of the tree down
+const (
+	LevelState        = "state"
+	LevelCounty       = "county"
+	LevelMunicipality = "municipality"
+	LevelPrecinct     = "precinct"
+)
+
+// levels orders the jurisdiction levels, every node sits one level below
+// its parent
+var levels = []string{LevelState, LevelCounty, LevelMunicipality, LevelPrecinct}
+
+// levelIndex returns the depth of a level, -1 for an unknown level
+func levelIndex(level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Jurisdiction is a node of the state > county > municipality > precinct
+// tree.  Voters attach to the precinct nodes through their PrecinctId,
+// polls attach to the node whose voters get them on the ballot, so a
+// statewide poll sits on the state and a school board poll on the
+// municipality.
+type Jurisdiction struct {
+	JurisdictionId int
+	Name           string
+	Level          string
+	ParentId       int   //0 for a state
+	PrecinctId     int   //Voter PrecinctId the node stands for, precinct level only
+	PollIds        []int //Polls held across this jurisdiction
+}
+
+// JurisdictionStats are the numbers of a jurisdiction rolled up from every
+// precinct below it
+type JurisdictionStats struct {
+	JurisdictionId int
+	Level          string
+	Precincts      int
+	Voters         int
+	ByStatus       map[string]int //Voters by registration status
+	Voted          int            //Voters with a vote, in the poll if one was asked for
+	Turnout        float64        //Voted / Voters
+}
+
+// JurisdictionTree holds the jurisdictions
+type JurisdictionTree struct {
+	mu    sync.Mutex
+	nodes map[int]Jurisdiction
+}
+
+// constructor for JurisdictionTree struct
+func NewJurisdictionTree() *JurisdictionTree {
+	return &JurisdictionTree{
+		nodes: make(map[int]Jurisdiction),
+	}
+}
+
+// validate checks a jurisdiction against the rest of the tree, the caller
+// must hold the lock
+func (t *JurisdictionTree) validate(node Jurisdiction) error {
+	if node.Name == "" {
+		return InvalidInput("jurisdiction name is required")
+	}
+	level := levelIndex(node.Level)
+	if level == -1 {
+		return InvalidInput(fmt.Sprintf("level must be one of %s", strings.Join(levels, ", ")))
+	}
+
+	if level == 0 {
+		if node.ParentId != 0 {
+			return InvalidInput("a state has no parent")
+		}
+	} else {
+		parent, ok := t.nodes[node.ParentId]
+		if !ok {
+			return InvalidInput("parent jurisdiction does not exist")
+		}
+		if levelIndex(parent.Level) != level-1 {
+			return InvalidInput(fmt.Sprintf("a %s must be inside a %s", node.Level, levels[level-1]))
+		}
+	}
+
+	if node.Level != LevelPrecinct {
+		if node.PrecinctId != 0 {
+			return InvalidInput("only precincts have a PrecinctId")
+		}
+		return nil
+	}
+	if node.PrecinctId == 0 {
+		return InvalidInput("a precinct needs a PrecinctId")
+	}
+	for _, other := range t.nodes {
+		if other.JurisdictionId != node.JurisdictionId && other.PrecinctId == node.PrecinctId {
+			return AlreadyExists(fmt.Sprintf("precinct %d is already jurisdiction %d", node.PrecinctId, other.JurisdictionId))
+		}
+	}
+
+	return nil
+}
+
+// AddJurisdiction adds a jurisdiction, the id must not be in use
+func (t *JurisdictionTree) AddJurisdiction(node Jurisdiction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.nodes[node.JurisdictionId]; ok {
+		return AlreadyExists("jurisdiction already exists")
+	}
+	if err := t.validate(node); err != nil {
+		return err
+	}
+
+	t.nodes[node.JurisdictionId] = node
+	return nil
+}
+
+// UpdateJurisdiction replaces an existing jurisdiction.  Its level can not
+// change, the nodes below it would no longer fit.
+func (t *JurisdictionTree) UpdateJurisdiction(node Jurisdiction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	existing, ok := t.nodes[node.JurisdictionId]
+	if !ok {
+		return NotFound("jurisdiction does not exist")
+	}
+	if existing.Level != node.Level {
+		return InvalidInput("the level of a jurisdiction can not change")
+	}
+	if err := t.validate(node); err != nil {
+		return err
+	}
+
+	t.nodes[node.JurisdictionId] = node
+	return nil
+}
+
+// DeleteJurisdiction removes a jurisdiction that has nothing below it
+func (t *JurisdictionTree) DeleteJurisdiction(id int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.nodes[id]; !ok {
+		return NotFound("jurisdiction does not exist")
+	}
+	for _, node := range t.nodes {
+		if node.ParentId == id {
+			return errors.New("jurisdiction still has jurisdictions inside it")
+		}
+	}
+
+	delete(t.nodes, id)
+	return nil
+}
+
+// GetJurisdiction returns a jurisdiction by id
+func (t *JurisdictionTree) GetJurisdiction(id int) (Jurisdiction, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node, ok := t.nodes[id]
+	if !ok {
+		return Jurisdiction{}, NotFound("jurisdiction does not exist")
+	}
+
+	return node, nil
+}
+
+// GetAllJurisdictions returns every jurisdiction ordered by id
+func (t *JurisdictionTree) GetAllJurisdictions() []Jurisdiction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nodes := make([]Jurisdiction, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	sortJurisdictions(nodes)
+
+	return nodes
+}
+
+func sortJurisdictions(nodes []Jurisdiction) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].JurisdictionId < nodes[j].JurisdictionId
+	})
+}
+
+// GetChildren returns the jurisdictions directly inside one, ordered by id
+func (t *JurisdictionTree) GetChildren(id int) []Jurisdiction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	children := make([]Jurisdiction, 0)
+	for _, node := range t.nodes {
+		if node.ParentId == id {
+			children = append(children, node)
+		}
+	}
+	sortJurisdictions(children)
+
+	return children
+}
+
+// GetPath returns a jurisdiction and the ones it is inside, from the state
+// down
+func (t *JurisdictionTree) GetPath(id int) ([]Jurisdiction, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var path []Jurisdiction
+	for id != 0 {
+		node, ok := t.nodes[id]
+		if !ok {
+			return nil, NotFound("jurisdiction does not exist")
+		}
+		path = append([]Jurisdiction{node}, path...)
+		id = node.ParentId
+	}
+
+	return path, nil
+}
+
+// Precincts returns the voter PrecinctIds of every precinct inside the
+// given jurisdictions
+func (t *JurisdictionTree) Precincts(ids ...int) map[int]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	roots := make(map[int]bool)
+	for _, id := range ids {
+		roots[id] = true
+	}
+
+	precincts := make(map[int]bool)
+	for _, node := range t.nodes {
+		if node.Level != LevelPrecinct {
+			continue
+		}
+		//Walk up from every precinct, the tree is at most four deep
+		for id := node.JurisdictionId; id != 0; id = t.nodes[id].ParentId {
+			if roots[id] {
+				precincts[node.PrecinctId] = true
+				break
+			}
+		}
+	}
+
+	return precincts
+}
+
+// GetPolls returns the polls on the ballot in a jurisdiction, those held
+// across it and across every jurisdiction it is inside
+func (t *JurisdictionTree) GetPolls(id int) ([]int, error) {
+	path, err := t.GetPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	polls := make([]int, 0)
+	for _, node := range path {
+		polls = append(polls, node.PollIds...)
+	}
+	sort.Ints(polls)
+
+	return polls, nil
+}
+
+// Stats rolls up the voters of every precinct inside a jurisdiction.  A
+// poll id other than 0 counts the voters that voted in that poll, 0 counts
+// the voters that voted in anything.
+func (t *JurisdictionTree) Stats(id int, voters []Voter, pollID int) (JurisdictionStats, error) {
+	node, err := t.GetJurisdiction(id)
+	if err != nil {
+		return JurisdictionStats{}, err
+	}
+	precincts := t.Precincts(id)
+
+	stats := JurisdictionStats{
+		JurisdictionId: id,
+		Level:          node.Level,
+		Precincts:      len(precincts),
+		ByStatus:       make(map[string]int),
+	}
+	for _, voter := range voters {
+		if !precincts[voter.PrecinctId] {
+			continue
+		}
+		stats.Voters++
+		status := strings.ToLower(voter.Status)
+		if status == "" {
+			status = "active"
+		}
+		stats.ByStatus[status]++
+
+		for _, history := range voter.VoteHistory {
+			if pollID == 0 || history.PollId == pollID {
+				stats.Voted++
+				break
+			}
+		}
+	}
+	if stats.Voters > 0 {
+		stats.Turnout = float64(stats.Voted) / float64(stats.Voters)
+	}
+
+	return stats, nil
+}
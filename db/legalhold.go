@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"sort"
+)
+
+// errLegalHold is returned when an operation would purge a held voter
+var errLegalHold = errors.New("voter is under legal hold")
+
+// IsLegalHold reports whether an error was caused by a legal hold
+func IsLegalHold(err error) bool {
+	return errors.Is(err, errLegalHold)
+}
+
+// LegalHold returns a legal hold error with the given message, for the
+// voter stores that keep holds of their own
+func LegalHold(message string) error {
+	return &kindError{kind: errLegalHold, message: message}
+}
+
+// checkHold returns an error if the voter is under legal hold.  Anything
+// that purges, anonymizes or merges voter records must call it first.  The
+// caller holds mu.
+func (t *VoterList) checkHold(id int) error {
+	if voter, err := t.getVoter(id); err == nil && voter.LegalHold {
+		return errLegalHold
+	}
+	return nil
+}
+
+// SetLegalHold places or lifts a legal hold on a voter
+func (t *VoterList) SetLegalHold(id int, hold bool, reason string) (Voter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.setLegalHold(id, hold, reason)
+}
+
+// setLegalHold places or lifts a legal hold, the caller holds mu
+func (t *VoterList) setLegalHold(id int, hold bool, reason string) (Voter, error) {
+	voter, err := t.getVoter(id)
+	if err != nil {
+		return Voter{}, err
+	}
+
+	voter.LegalHold = hold
+	voter.LegalHoldReason = reason
+	if !hold {
+		voter.LegalHoldReason = ""
+	}
+	t.putVoter(voter)
+
+	return voter, nil
+}
+
+// SetLegalHoldByFilter places or lifts a legal hold on every voter that
+// matches the filter and returns the ids of the voters that changed
+func (t *VoterList) SetLegalHoldByFilter(filter VoterFilter, hold bool, reason string) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voterList, err := t.findVoters(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(voterList))
+	for _, voter := range voterList {
+		if voter.LegalHold == hold && voter.LegalHoldReason == reason {
+			continue
+		}
+		if _, err := t.setLegalHold(voter.VoterId, hold, reason); err != nil {
+			return nil, err
+		}
+		ids = append(ids, voter.VoterId)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// localePattern matches the BCP 47 tags translations are keyed by, e.g.
+// es, es-MX or zh-Hant-TW
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// NormalizeLocale returns a locale in its usual spelling, language in
+// lower case and region in upper case, e.g. es_mx becomes es-MX
+func NormalizeLocale(locale string) (string, error) {
+	if !localePattern.MatchString(locale) {
+		return "", InvalidInput("invalid locale " + locale)
+	}
+
+	parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' })
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		}
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// MatchLocale picks the available locale that best serves the wanted
+// ones, which are in order of preference.  A wanted locale matches itself
+// first and then any locale of the same language, so es-MX is served es
+// and es is served es-MX.  It returns "" when nothing matches.
+func MatchLocale(available []string, wanted ...string) string {
+	for _, want := range wanted {
+		want, err := NormalizeLocale(want)
+		if err != nil {
+			continue
+		}
+		for _, locale := range available {
+			if locale == want {
+				return locale
+			}
+		}
+
+		language := baseLanguage(want)
+		for _, locale := range available {
+			if locale == language {
+				return locale
+			}
+		}
+		for _, locale := range available {
+			if baseLanguage(locale) == language {
+				return locale
+			}
+		}
+	}
+
+	return ""
+}
+
+// baseLanguage returns the language part of a locale
+func baseLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return language
+}
+
+// normalizeKeys rewrites the locale keys of a translation map in their
+// usual spelling
+func normalizeKeys[T any](translations map[string]T) (map[string]T, error) {
+	if translations == nil {
+		return nil, nil
+	}
+
+	normalized := make(map[string]T, len(translations))
+	for locale, translation := range translations {
+		key, err := NormalizeLocale(locale)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := normalized[key]; ok {
+			return nil, InvalidInput("locale " + key + " is given twice")
+		}
+		normalized[key] = translation
+	}
+
+	return normalized, nil
+}
+
+// localesOf returns the locales of a translation map in order, so
+// matching does not depend on map order
+func localesOf[T any](translations map[string]T) []string {
+	locales := make([]string, 0, len(translations))
+	for locale := range translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// mirror is a VoterStore kept in another backend, with every write also
+// applied to the in-memory list.  The backend is the source of truth and
+// serves the reads, the list keeps a copy so reports, checksums, filters
+// and the other whole roll features see the same voters.
+type mirror struct {
+	VoterStore
+	list *VoterList
+}
+
+var (
+	_ ContextStore = mirror{}
+	_ PersistStore = mirror{}
+)
+
+// Mirror loads every voter of a backend into the list and returns the
+// store the API should use.  The VoteId sequence of the list counts on
+// from the loaded histories and, for a VoteIdStore, from its marks.  Ballot choices are sealed before they reach
+// the backend and voters under legal hold can not be deleted from it,
+// since both are enforced by the list.
+func (t *VoterList) Mirror(backend VoterStore) (VoterStore, error) {
+	voters, err := backend.GetAllVoters()
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	for _, voter := range voters {
+		t.putVoter(voter)
+		t.voteIds.Observe(voter.VoterId, voter.VoteHistory)
+	}
+	t.mu.Unlock()
+
+	//The VoteIds of deleted votes are only known to the marks
+	if store, ok := backend.(VoteIdStore); ok {
+		if err := t.voteIds.Load(store); err != nil {
+			return nil, err
+		}
+	}
+
+	return mirror{VoterStore: backend, list: t}, nil
+}
+
+// WithContext binds the backend to a context when it supports it
+func (m mirror) WithContext(ctx context.Context) VoterStore {
+	if backend, ok := m.VoterStore.(ContextStore); ok {
+		return mirror{VoterStore: backend.WithContext(ctx), list: m.list}
+	}
+	return m
+}
+
+// copied logs a write that reached the backend but not the list, the list
+// catches up on the next restart
+func copied(op string, id int, err error) {
+	if err != nil {
+		log.Printf("Error mirroring %s of voter %d: %v", op, id, err)
+	}
+}
+
+// seal seals the ballot choices of a voter with the list's ballot key
+func (m mirror) seal(voter *Voter) error {
+	m.list.mu.RLock()
+	defer m.list.mu.RUnlock()
+	return m.list.sealChoices(voter)
+}
+
+func (m mirror) AddVoter(voter Voter) error {
+	if err := m.seal(&voter); err != nil {
+		return err
+	}
+	if voter.Registered.IsZero() {
+		voter.Registered = time.Now()
+	}
+	if err := m.VoterStore.AddVoter(voter); err != nil {
+		return err
+	}
+	copied("add", voter.VoterId, m.list.AddVoter(voter))
+	return nil
+}
+
+func (m mirror) CreateVoter(voter Voter) (int, error) {
+	if err := m.seal(&voter); err != nil {
+		return 0, err
+	}
+	if voter.Registered.IsZero() {
+		voter.Registered = time.Now()
+	}
+	id, err := m.VoterStore.CreateVoter(voter)
+	if err != nil {
+		return 0, err
+	}
+	voter.VoterId = id
+	copied("add", id, m.list.AddVoter(voter))
+	return id, nil
+}
+
+func (m mirror) UpdateVoter(voter Voter) error {
+	if err := m.seal(&voter); err != nil {
+		return err
+	}
+	if existing, err := m.list.GetVoter(voter.VoterId); err == nil && voter.Registered.IsZero() {
+		voter.Registered = existing.Registered
+	}
+	if err := m.VoterStore.UpdateVoter(voter); err != nil {
+		return err
+	}
+	copied("update", voter.VoterId, m.list.UpdateVoter(voter))
+	return nil
+}
+
+func (m mirror) DeleteVoter(id int) error {
+	if voter, err := m.list.GetVoter(id); err == nil && voter.LegalHold {
+		return errLegalHold
+	}
+	if err := m.VoterStore.DeleteVoter(id); err != nil {
+		return err
+	}
+	copied("delete", id, m.list.DeleteVoter(id))
+	return nil
+}
+
+func (m mirror) DeleteAll() error {
+	voters, err := m.list.GetAllVoters()
+	if err != nil {
+		return err
+	}
+	for _, voter := range voters {
+		if voter.LegalHold {
+			return errLegalHold
+		}
+	}
+	if err := m.VoterStore.DeleteAll(); err != nil {
+		return err
+	}
+	copied("delete", 0, m.list.DeleteAll())
+	return nil
+}
+
+func (m mirror) AddVoterPoll(voterID, pollID int, vote VoterHistory) (VoterHistory, error) {
+	sealed := Voter{VoteHistory: []VoterHistory{vote}}
+	if err := m.seal(&sealed); err != nil {
+		return VoterHistory{}, err
+	}
+	stored, err := m.VoterStore.AddVoterPoll(voterID, pollID, sealed.VoteHistory[0])
+	if err != nil {
+		return VoterHistory{}, err
+	}
+	_, err = m.list.AddVoterPoll(voterID, pollID, stored)
+	copied("poll add", voterID, err)
+	return stored, nil
+}
+
+func (m mirror) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error {
+	if err := m.VoterStore.UpdateVoterPoll(voterID, pollID, newVoteDate); err != nil {
+		return err
+	}
+	copied("poll update", voterID, m.list.UpdateVoterPoll(voterID, pollID, newVoteDate))
+	return nil
+}
+
+func (m mirror) DeleteVoterPoll(voterID, pollID int) error {
+	if err := m.VoterStore.DeleteVoterPoll(voterID, pollID); err != nil {
+		return err
+	}
+	copied("poll delete", voterID, m.list.DeleteVoterPoll(voterID, pollID))
+	return nil
+}
+
+// GetVoter reads a voter from the backend.  Decoys are only kept in the
+// list, so they are read from there and still trip the wire.
+func (m mirror) GetVoter(id int) (Voter, error) {
+	if m.list.IsDecoy(id) {
+		return m.list.GetVoter(id)
+	}
+	return m.VoterStore.GetVoter(id)
+}
+
+// Persist writes voters the list changed on its own to the backend.  A
+// voter the list no longer has is deleted and an archived one is flagged,
+// so the backend serves what the list would.  Decoys are never written,
+// the backend would load them as real voters on the next restart.
+func (m mirror) Persist(ids ...int) error {
+	for _, id := range ids {
+		if err := m.persist(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persist writes one voter of the list to the backend
+func (m mirror) persist(id int) error {
+	m.list.mu.RLock()
+	voter, live := m.list.Voters[id]
+	decoy := m.list.decoys[id]
+	var err error
+	if live {
+		voter = cloneVoter(voter)
+	} else {
+		voter, err = m.list.getArchived(id)
+	}
+	m.list.mu.RUnlock()
+
+	switch {
+	case decoy:
+		return nil
+	case errors.Is(err, ErrNotFound):
+		if err := m.VoterStore.DeleteVoter(id); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	case err != nil:
+		return err
+	}
+
+	stored, err := m.VoterStore.GetVoter(id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		err = m.VoterStore.AddVoter(voter)
+	case err == nil:
+		err = m.VoterStore.UpdateVoter(voter)
+	}
+	if err != nil {
+		return err
+	}
+
+	if stored.LegalHold == voter.LegalHold && stored.LegalHoldReason == voter.LegalHoldReason {
+		return nil
+	}
+	backend, ok := m.VoterStore.(HoldStore)
+	if !ok {
+		return errors.New("the voter store can not keep legal holds")
+	}
+	return backend.SetLegalHold(id, voter.LegalHold, voter.LegalHoldReason)
+}
@@ -0,0 +1,483 @@
+// Package mongodb is a MongoDB backed db.VoterStore.  Every voter is one
+// document in the voters collection, keyed by VoterId, with its vote
+// history embedded.  The marks of the VoteId sequence are documents of the
+// vote_ids collection, keyed by voter id.  MongoDB keeps times to the millisecond, so finer
+// vote times come back rounded.
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// DefaultDatabase is the database used when the URI does not name one
+const DefaultDatabase = "voters"
+
+// DefaultQueryTimeout bounds calls made through the plain VoterStore
+// methods, which carry no context of their own
+const DefaultQueryTimeout = 5 * time.Second
+
+// maxRetries bounds the retries of an update that lost a race with
+// another write to the same voter
+const maxRetries = 5
+
+// emailIndex is the unique index on the email address of voters
+const emailIndex = "voters_email"
+
+// voterDoc is the stored form of a voter.  Version is bumped on every
+// write so read, modify, write cycles can tell they raced another write.
+// EmailKey is the indexed form of the email address, left out for voters
+// without one so they do not collide in the unique index.
+type voterDoc struct {
+	Id       int    `bson:"_id"`
+	Version  int    `bson:"_version"`
+	EmailKey string `bson:"_email,omitempty"`
+	db.Voter `bson:",inline"`
+}
+
+// newDoc returns the stored form of a new voter
+func newDoc(voter db.Voter) voterDoc {
+	return voterDoc{Id: voter.VoterId, EmailKey: db.EmailKey(voter.Email), Voter: voter}
+}
+
+// isEmailConflict reports whether a write failed on the email index
+func isEmailConflict(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), emailIndex)
+}
+
+// writeError turns a write that broke the email index into the conflict
+// the API reports, other errors go through storeError
+func writeError(err error, voter db.Voter) error {
+	if isEmailConflict(err) {
+		return db.EmailTaken(voter.Email)
+	}
+	return storeError(err)
+}
+
+// Store is the MongoDB voter store, safe for concurrent use
+type Store struct {
+	client  *mongo.Client
+	voters  *mongo.Collection
+	voteIds *mongo.Collection
+	ctx     context.Context
+	timeout time.Duration
+}
+
+// The mongo store is a VoterStore whose calls can be bound to a request
+var (
+	_ db.ContextStore = (*Store)(nil)
+	_ db.HoldStore    = (*Store)(nil)
+	_ db.VoteIdStore  = (*Store)(nil)
+)
+
+// Open connects to MongoDB, checks the connection and creates the email
+// index.  The database is the one named in the URI, DefaultDatabase if it
+// names none.
+func Open(ctx context.Context, uri string) (*Store, error) {
+	opts := options.Client().ApplyURI(uri)
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	database := DefaultDatabase
+	if cs, err := connstring.Parse(uri); err == nil && cs.Database != "" {
+		database = cs.Database
+	}
+
+	voters := client.Database(database).Collection("voters")
+	_, err = voters.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"_email": 1},
+		Options: options.Index().SetName(emailIndex).SetUnique(true).
+			SetPartialFilterExpression(bson.M{"_email": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return &Store{
+		client:  client,
+		voters:  voters,
+		voteIds: client.Database(database).Collection("vote_ids"),
+		ctx:     context.Background(),
+		timeout: DefaultQueryTimeout,
+	}, nil
+}
+
+// Close disconnects from MongoDB
+func (s *Store) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// WithContext returns a view of the store whose calls run under ctx, so
+// they are cancelled along with the request they serve
+func (s *Store) WithContext(ctx context.Context) db.VoterStore {
+	bound := *s
+	bound.ctx = ctx
+	return &bound
+}
+
+// callContext returns the context of a call, bounded by the query timeout
+// unless the caller set a deadline
+func (s *Store) callContext() (context.Context, context.CancelFunc) {
+	if _, ok := s.ctx.Deadline(); ok {
+		return context.WithCancel(s.ctx)
+	}
+	return context.WithTimeout(s.ctx, s.timeout)
+}
+
+// storeError marks errors that come from an unreachable server as
+// unavailable, so the API asks the client to retry
+func storeError(err error) error {
+	var selectErr topology.ServerSelectionError
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.As(err, &selectErr) {
+		return db.Unavailable(err)
+	}
+	return err
+}
+
+// getDoc reads the stored document of a voter
+func (s *Store) getDoc(ctx context.Context, id int) (voterDoc, error) {
+	var doc voterDoc
+	err := s.voters.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return voterDoc{}, db.NotFound("item does not exist")
+	}
+	if err != nil {
+		return voterDoc{}, storeError(err)
+	}
+
+	return doc, nil
+}
+
+// update reads a voter, lets change modify it and writes it back.  The
+// write only goes through if nobody wrote the voter in between, otherwise
+// the cycle is retried.
+func (s *Store) update(id int, change func(voter *db.Voter) error) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		doc, err := s.getDoc(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(&doc.Voter); err != nil {
+			return err
+		}
+		doc.VoterId = id
+		doc.EmailKey = db.EmailKey(doc.Email)
+
+		version := doc.Version
+		doc.Version++
+		result, err := s.voters.ReplaceOne(ctx, bson.M{"_id": id, "_version": version}, doc)
+		if err != nil {
+			return writeError(err, doc.Voter)
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("voter %d is being changed by other requests, try again", id)
+}
+
+// AddVoter adds a voter, the id must not be in use
+func (s *Store) AddVoter(voter db.Voter) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	//Legal holds can only be placed through SetLegalHold
+	voter.LegalHold = false
+	voter.LegalHoldReason = ""
+
+	_, err := s.voters.InsertOne(ctx, newDoc(voter))
+	if mongo.IsDuplicateKeyError(err) && !isEmailConflict(err) {
+		return db.AlreadyExists("item already exists")
+	}
+
+	return writeError(err, voter)
+}
+
+// CreateVoter adds a voter under the next free id and returns the id.  An
+// insert that lost the race for the id to another write is retried with
+// the id after it.
+func (s *Store) CreateVoter(voter db.Voter) (int, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	//Legal holds can only be placed through SetLegalHold
+	voter.LegalHold = false
+	voter.LegalHoldReason = ""
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		var last voterDoc
+		err := s.voters.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"_id": -1})).Decode(&last)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, storeError(err)
+		}
+
+		voter.VoterId = last.Id + 1
+		_, err = s.voters.InsertOne(ctx, newDoc(voter))
+		if mongo.IsDuplicateKeyError(err) && !isEmailConflict(err) {
+			continue
+		}
+		if err != nil {
+			return 0, writeError(err, voter)
+		}
+		return voter.VoterId, nil
+	}
+
+	return 0, errors.New("voter ids are being taken by other requests, try again")
+}
+
+// GetVoter returns a voter by id
+func (s *Store) GetVoter(id int) (db.Voter, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	doc, err := s.getDoc(ctx, id)
+	if err != nil {
+		return db.Voter{}, err
+	}
+
+	return doc.Voter, nil
+}
+
+// GetVoterByEmail returns the voter registered with an email address,
+// found through the email index
+func (s *Store) GetVoterByEmail(email string) (db.Voter, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	key := db.EmailKey(email)
+	if key == "" {
+		return db.Voter{}, db.NotFound("no voter is registered with this email")
+	}
+
+	var doc voterDoc
+	err := s.voters.FindOne(ctx, bson.M{"_email": key}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return db.Voter{}, db.NotFound("no voter is registered with this email")
+	}
+	if err != nil {
+		return db.Voter{}, storeError(err)
+	}
+
+	return doc.Voter, nil
+}
+
+// GetAllVoters returns every voter ordered by id
+func (s *Store) GetAllVoters() ([]db.Voter, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	cursor, err := s.voters.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	var docs []voterDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, storeError(err)
+	}
+
+	voters := make([]db.Voter, 0, len(docs))
+	for _, doc := range docs {
+		voters = append(voters, doc.Voter)
+	}
+
+	return voters, nil
+}
+
+// UpdateVoter replaces an existing voter.  A plain update can not place
+// or lift a legal hold.
+func (s *Store) UpdateVoter(voter db.Voter) error {
+	return s.update(voter.VoterId, func(existing *db.Voter) error {
+		voter.LegalHold = existing.LegalHold
+		voter.LegalHoldReason = existing.LegalHoldReason
+		*existing = voter
+		return nil
+	})
+}
+
+// SetLegalHold places or lifts a legal hold on a voter
+func (s *Store) SetLegalHold(id int, hold bool, reason string) error {
+	return s.update(id, func(voter *db.Voter) error {
+		voter.LegalHold = hold
+		voter.LegalHoldReason = reason
+		if !hold {
+			voter.LegalHoldReason = ""
+		}
+		return nil
+	})
+}
+
+// DeleteVoter removes a voter, voters under legal hold can not be
+// deleted.  Like the in-memory list, deleting a voter that does not exist
+// is an ErrNotFound.
+func (s *Store) DeleteVoter(id int) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	result, err := s.voters.DeleteOne(ctx, bson.M{"_id": id, "legalhold": bson.M{"$ne": true}})
+	if err != nil {
+		return storeError(err)
+	}
+	if result.DeletedCount == 0 {
+		held, err := s.voters.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return storeError(err)
+		}
+		if held > 0 {
+			return db.LegalHold(fmt.Sprintf("voter %d is under legal hold", id))
+		}
+		return db.NotFound("item does not exist")
+	}
+
+	return nil
+}
+
+// DeleteAll removes every voter, nothing is deleted if any voter is under
+// legal hold
+func (s *Store) DeleteAll() error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	held, err := s.voters.CountDocuments(ctx, bson.M{"legalhold": true})
+	if err != nil {
+		return storeError(err)
+	}
+	if held > 0 {
+		return db.LegalHold(fmt.Sprintf("%d voters are under legal hold", held))
+	}
+
+	_, err = s.voters.DeleteMany(ctx, bson.M{"legalhold": bson.M{"$ne": true}})
+	return storeError(err)
+}
+
+// GetVoterPolls returns the vote history of a voter
+func (s *Store) GetVoterPolls(voterID int) ([]db.VoterHistory, error) {
+	voter, err := s.GetVoter(voterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return voter.VoteHistory, nil
+}
+
+// GetVoterPoll returns the history entry of a voter for a poll
+func (s *Store) GetVoterPoll(voterID, pollID int) (db.VoterHistory, error) {
+	voter, err := s.GetVoter(voterID)
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			return history, nil
+		}
+	}
+
+	return db.VoterHistory{}, db.NotFound("poll not found for this voter")
+}
+
+// AddVoterPoll adds a history entry for a voter in one step, a second
+// vote in the same poll is an ErrAlreadyExists.  An entry without a VoteId
+// gets the one after the highest of the history.
+func (s *Store) AddVoterPoll(voterID, pollID int, vote db.VoterHistory) (db.VoterHistory, error) {
+	vote.PollId = pollID
+	err := s.update(voterID, func(voter *db.Voter) error {
+		if err := db.AlreadyVoted(*voter, pollID); err != nil {
+			return err
+		}
+		if vote.VoteId == 0 {
+			vote.VoteId = db.NextVoteId(voter.VoteHistory)
+		}
+		voter.VoteHistory = append(voter.VoteHistory, vote)
+		return nil
+	})
+	if err != nil {
+		return db.VoterHistory{}, err
+	}
+	return vote, nil
+}
+
+// UpdateVoterPoll changes the vote date of a voter's history entry
+func (s *Store) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error {
+	return s.update(voterID, func(voter *db.Voter) error {
+		for i, history := range voter.VoteHistory {
+			if history.PollId == pollID {
+				voter.VoteHistory[i].VoteDate = newVoteDate
+				return nil
+			}
+		}
+		return db.NotFound("poll not found for this voter")
+	})
+}
+
+// DeleteVoterPoll removes a voter's history entry for a poll
+func (s *Store) DeleteVoterPoll(voterID, pollID int) error {
+	return s.update(voterID, func(voter *db.Voter) error {
+		for i, history := range voter.VoteHistory {
+			if history.PollId == pollID {
+				voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
+				return nil
+			}
+		}
+		return db.NotFound("poll not found for this voter")
+	})
+}
+
+// voteIdMark is the stored form of a mark of the VoteId sequence
+type voteIdMark struct {
+	VoterId int `bson:"_id"`
+	VoteId  int `bson:"voteid"`
+}
+
+// VoteIdMarks returns the marks of the VoteId sequence by voter id
+func (s *Store) VoteIdMarks() (map[int]int, error) {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	cursor, err := s.voteIds.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	var docs []voteIdMark
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, storeError(err)
+	}
+
+	marks := make(map[int]int, len(docs))
+	for _, doc := range docs {
+		marks[doc.VoterId] = doc.VoteId
+	}
+	return marks, nil
+}
+
+// SetVoteIdMark raises the mark of a voter to voteID, a lower id leaves
+// it as it is
+func (s *Store) SetVoteIdMark(voterID, voteID int) error {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	_, err := s.voteIds.UpdateOne(ctx, bson.M{"_id": voterID}, bson.M{"$max": bson.M{"voteid": voteID}},
+		options.Update().SetUpsert(true))
+	return storeError(err)
+}
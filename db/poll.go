@@ -0,0 +1,300 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SurveyQuestion is an optional question asked after a vote.  A question
+// with choices is multiple choice, one without is free text.
+type SurveyQuestion struct {
+	QuestionId int
+	Text       string
+	Choices    []string
+}
+
+// PollTranslation is the voter-facing text of a poll in one locale.
+// Options line up with the options of the poll, votes always record the
+// untranslated option.
+type PollTranslation struct {
+	Title   string
+	Options []string
+}
+
+// Poll is a single question on the ballot, with an optional post-vote survey
+type Poll struct {
+	PollId       int
+	Title        string
+	Options      []string
+	Questions    []SurveyQuestion
+	Closes       time.Time                  //Zero if the poll does not close
+	LockedBy     int                        //Election that locked the poll, zero if open.  Set by the server
+	Translations map[string]PollTranslation //Keyed by BCP 47 locale, e.g. es-MX
+	Locale       string                     //Locale Title and Options are in, empty for the original.  Set by the server
+}
+
+// Localize returns the poll with its title and options in the best
+// locale for the wanted ones, in order of preference.  The poll is
+// returned as is when it has no matching translation.
+func (p Poll) Localize(wanted ...string) Poll {
+	locale := MatchLocale(localesOf(p.Translations), wanted...)
+	if locale == "" {
+		return p
+	}
+
+	translation := p.Translations[locale]
+	if translation.Title != "" {
+		p.Title = translation.Title
+	}
+	if len(translation.Options) > 0 {
+		p.Options = translation.Options
+	}
+	p.Locale = locale
+	return p
+}
+
+// PollList holds the polls
+type PollList struct {
+	mu         sync.Mutex
+	polls      map[int]Poll
+	extensions []PollExtension
+	locked     map[int]int //Poll id to the election that locked it
+}
+
+// constructor for PollList struct
+func NewPollList() *PollList {
+	return &PollList{
+		polls:  make(map[int]Poll),
+		locked: make(map[int]int),
+	}
+}
+
+// LockPolls locks polls for an election, no more votes are taken in a
+// locked poll.  Polls that are not set up can be locked too.
+func (l *PollList) LockPolls(electionID int, pollIDs []int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range pollIDs {
+		l.locked[id] = electionID
+	}
+}
+
+// LockedBy returns the election that locked a poll, zero if it is open
+func (l *PollList) LockedBy(pollID int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.locked[pollID]
+}
+
+// NormalizePoll checks a poll and returns it the way AddPoll would store
+// it, so a poll can be compared with the stored one
+func NormalizePoll(poll Poll) (Poll, error) {
+	err := validatePoll(&poll)
+	return poll, err
+}
+
+// validatePoll checks the poll, its translations and its survey
+// questions.  The locales of the translations are normalized in place.
+func validatePoll(poll *Poll) error {
+	if poll.Title == "" {
+		return InvalidInput("poll title is required")
+	}
+
+	translations, err := normalizeKeys(poll.Translations)
+	if err != nil {
+		return err
+	}
+	for locale, translation := range translations {
+		if err := validatePollTranslation(*poll, translation); err != nil {
+			return fmt.Errorf("%s translation: %w", locale, err)
+		}
+	}
+	poll.Translations = translations
+	poll.Locale = ""
+
+	seen := make(map[int]bool)
+	for _, question := range poll.Questions {
+		if question.Text == "" {
+			return InvalidInput("survey question text is required")
+		}
+		if seen[question.QuestionId] {
+			return InvalidInput("survey question ids must be unique")
+		}
+		seen[question.QuestionId] = true
+	}
+
+	return nil
+}
+
+// validatePollTranslation checks that a translation can stand in for the
+// poll text
+func validatePollTranslation(poll Poll, translation PollTranslation) error {
+	if translation.Title == "" && len(translation.Options) == 0 {
+		return InvalidInput("translation is empty")
+	}
+	if len(translation.Options) > 0 && len(translation.Options) != len(poll.Options) {
+		return InvalidInput("a translation needs one option for every option of the poll")
+	}
+	return nil
+}
+
+// SetTranslation adds or replaces the translation of a poll for a locale
+func (l *PollList) SetTranslation(pollID int, locale string, translation PollTranslation) (Poll, error) {
+	locale, err := NormalizeLocale(locale)
+	if err != nil {
+		return Poll{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	poll, ok := l.polls[pollID]
+	if !ok {
+		return Poll{}, NotFound("poll does not exist")
+	}
+	if err := validatePollTranslation(poll, translation); err != nil {
+		return Poll{}, err
+	}
+
+	translations := make(map[string]PollTranslation, len(poll.Translations)+1)
+	for key, value := range poll.Translations {
+		translations[key] = value
+	}
+	translations[locale] = translation
+	poll.Translations = translations
+	l.polls[pollID] = poll
+
+	poll.LockedBy = l.locked[pollID]
+	return poll, nil
+}
+
+// DeleteTranslation removes the translation of a poll for a locale
+func (l *PollList) DeleteTranslation(pollID int, locale string) error {
+	locale, err := NormalizeLocale(locale)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	poll, ok := l.polls[pollID]
+	if !ok {
+		return NotFound("poll does not exist")
+	}
+	if _, ok := poll.Translations[locale]; !ok {
+		return NotFound("translation does not exist")
+	}
+
+	translations := make(map[string]PollTranslation, len(poll.Translations))
+	for key, value := range poll.Translations {
+		if key != locale {
+			translations[key] = value
+		}
+	}
+	poll.Translations = translations
+	l.polls[pollID] = poll
+
+	return nil
+}
+
+// AddPoll adds a poll, the id must not be in use
+func (l *PollList) AddPoll(poll Poll) error {
+	if err := validatePoll(&poll); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.polls[poll.PollId]; ok {
+		return AlreadyExists("poll already exists")
+	}
+
+	l.polls[poll.PollId] = poll
+	return nil
+}
+
+// UpdatePoll replaces an existing poll
+func (l *PollList) UpdatePoll(poll Poll) error {
+	if err := validatePoll(&poll); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, ok := l.polls[poll.PollId]
+	if !ok {
+		return NotFound("poll does not exist")
+	}
+	//Once set the close time only moves through an approved extension, an
+	//update that leaves it out keeps it
+	if !existing.Closes.IsZero() {
+		if poll.Closes.IsZero() {
+			poll.Closes = existing.Closes
+		} else if !poll.Closes.Equal(existing.Closes) {
+			return InvalidInput("the close time of a poll can only be changed by an extension")
+		}
+	}
+	//Translations are managed on their own, an update that leaves them out
+	//keeps them, as long as they still fit the options
+	if poll.Translations == nil {
+		for locale, translation := range existing.Translations {
+			if err := validatePollTranslation(poll, translation); err != nil {
+				return fmt.Errorf("%s translation: %w", locale, err)
+			}
+		}
+		poll.Translations = existing.Translations
+	}
+
+	l.polls[poll.PollId] = poll
+	return nil
+}
+
+// DeletePoll removes a poll
+func (l *PollList) DeletePoll(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.polls[id]; !ok {
+		return NotFound("poll does not exist")
+	}
+
+	delete(l.polls, id)
+	return nil
+}
+
+// GetPoll returns a poll by id
+func (l *PollList) GetPoll(id int) (Poll, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	poll, ok := l.polls[id]
+	if !ok {
+		return Poll{}, NotFound("poll does not exist")
+	}
+	poll.LockedBy = l.locked[id]
+
+	return poll, nil
+}
+
+// GetAllPolls returns all polls ordered by id
+func (l *PollList) GetAllPolls() []Poll {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	polls := make([]Poll, 0, len(l.polls))
+	for _, poll := range l.polls {
+		poll.LockedBy = l.locked[poll.PollId]
+		polls = append(polls, poll)
+	}
+	sort.Slice(polls, func(i, j int) bool {
+		return polls[i].PollId < polls[j].PollId
+	})
+
+	return polls
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PollingHours are the opening hours of a polling place on one day.  Day is
+// a date (2006-01-02) and the times are local 24 hour times (15:04).
+type PollingHours struct {
+	Day    string
+	Opens  string
+	Closes string
+}
+
+// PollingPlace is a location where voters of one or more precincts vote
+type PollingPlace struct {
+	PollingPlaceId int
+	Name           string
+	Address        Address
+	Hours          []PollingHours
+	Capacity       int      //Voters that can be served at the same time
+	Accessibility  []string //Accessibility features, the Access constants
+	PrecinctIds    []int    //Precincts assigned to this place
+}
+
+// Accessibility features of a polling place, the accessible ballot formats
+// it offers included
+const (
+	AccessWheelchair  = "wheelchair"   //Step free entrance and voting booths
+	AccessCurbside    = "curbside"     //Voters can vote from their car
+	AccessAudioBallot = "audio-ballot" //Ballots can be listened to and marked with a keypad
+	AccessLargePrint  = "large-print"  //Large print ballots
+	AccessBraille     = "braille"      //Braille ballots
+)
+
+var accessFeatures = map[string]bool{
+	AccessWheelchair:  true,
+	AccessCurbside:    true,
+	AccessAudioBallot: true,
+	AccessLargePrint:  true,
+	AccessBraille:     true,
+}
+
+// ValidAccessibility reports whether a feature is one of the Access
+// constants
+func ValidAccessibility(feature string) bool {
+	return accessFeatures[feature]
+}
+
+// HasAccessibility reports whether the place has all of the features
+func (p PollingPlace) HasAccessibility(features ...string) bool {
+	for _, feature := range features {
+		if !slices.Contains(p.Accessibility, feature) {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingAccessibility returns those of the features the place lacks
+func (p PollingPlace) MissingAccessibility(features ...string) []string {
+	missing := []string{}
+	for _, feature := range features {
+		if !slices.Contains(p.Accessibility, feature) {
+			missing = append(missing, feature)
+		}
+	}
+	return missing
+}
+
+// PollingPlaceList holds the polling places
+type PollingPlaceList struct {
+	mu     sync.Mutex
+	places map[int]PollingPlace
+}
+
+// constructor for PollingPlaceList struct
+func NewPollingPlaceList() *PollingPlaceList {
+	return &PollingPlaceList{
+		places: make(map[int]PollingPlace),
+	}
+}
+
+// validate checks a polling place and makes sure none of its precincts is
+// already assigned to another place, the caller must hold the lock
+func (l *PollingPlaceList) validate(place PollingPlace) error {
+	if place.Name == "" {
+		return InvalidInput("polling place name is required")
+	}
+	if place.Capacity < 0 {
+		return InvalidInput("capacity can not be negative")
+	}
+	for i, feature := range place.Accessibility {
+		if !ValidAccessibility(feature) {
+			return InvalidInput(fmt.Sprintf("unknown accessibility feature %q", feature))
+		}
+		if slices.Contains(place.Accessibility[:i], feature) {
+			return InvalidInput(fmt.Sprintf("accessibility feature %q is listed twice", feature))
+		}
+	}
+
+	for _, hours := range place.Hours {
+		if _, err := time.Parse("2006-01-02", hours.Day); err != nil {
+			return InvalidInput(fmt.Sprintf("invalid day %q", hours.Day))
+		}
+		opens, err := time.Parse("15:04", hours.Opens)
+		if err != nil {
+			return InvalidInput(fmt.Sprintf("invalid opening time %q", hours.Opens))
+		}
+		closes, err := time.Parse("15:04", hours.Closes)
+		if err != nil {
+			return InvalidInput(fmt.Sprintf("invalid closing time %q", hours.Closes))
+		}
+		if !closes.After(opens) {
+			return InvalidInput(fmt.Sprintf("closing time must be after opening time on %s", hours.Day))
+		}
+	}
+
+	for _, other := range l.places {
+		if other.PollingPlaceId == place.PollingPlaceId {
+			continue
+		}
+		for _, precinct := range place.PrecinctIds {
+			for _, taken := range other.PrecinctIds {
+				if precinct == taken {
+					return AlreadyExists(fmt.Sprintf("precinct %d is already assigned to polling place %d",
+						precinct, other.PollingPlaceId))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// AddPollingPlace adds a polling place, the id must not be in use
+func (l *PollingPlaceList) AddPollingPlace(place PollingPlace) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.places[place.PollingPlaceId]; ok {
+		return AlreadyExists("polling place already exists")
+	}
+	if err := l.validate(place); err != nil {
+		return err
+	}
+
+	l.places[place.PollingPlaceId] = place
+	return nil
+}
+
+// UpdatePollingPlace replaces an existing polling place
+func (l *PollingPlaceList) UpdatePollingPlace(place PollingPlace) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.places[place.PollingPlaceId]; !ok {
+		return NotFound("polling place does not exist")
+	}
+	if err := l.validate(place); err != nil {
+		return err
+	}
+
+	l.places[place.PollingPlaceId] = place
+	return nil
+}
+
+// DeletePollingPlace removes a polling place
+func (l *PollingPlaceList) DeletePollingPlace(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.places[id]; !ok {
+		return NotFound("polling place does not exist")
+	}
+
+	delete(l.places, id)
+	return nil
+}
+
+// GetPollingPlace returns a polling place by id
+func (l *PollingPlaceList) GetPollingPlace(id int) (PollingPlace, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	place, ok := l.places[id]
+	if !ok {
+		return PollingPlace{}, NotFound("polling place does not exist")
+	}
+
+	return place, nil
+}
+
+// GetAllPollingPlaces returns all polling places ordered by id
+func (l *PollingPlaceList) GetAllPollingPlaces() []PollingPlace {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	places := make([]PollingPlace, 0, len(l.places))
+	for _, place := range l.places {
+		places = append(places, place)
+	}
+	sort.Slice(places, func(i, j int) bool {
+		return places[i].PollingPlaceId < places[j].PollingPlaceId
+	})
+
+	return places
+}
+
+// FindByPrecinct returns the polling place a precinct is assigned to
+func (l *PollingPlaceList) FindByPrecinct(precinctID int) (PollingPlace, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, place := range l.places {
+		for _, id := range place.PrecinctIds {
+			if id == precinctID {
+				return place, nil
+			}
+		}
+	}
+
+	return PollingPlace{}, NotFound("no polling place for precinct")
+}
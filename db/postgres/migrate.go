@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations are the schema changes, named NNNN_description.up.sql in the
+// style of golang-migrate.  A released migration must never be edited,
+// the schema evolves by adding the next one.
+//
+//go:embed migrations/*.up.sql
+var migrations embed.FS
+
+// migrationLock is the advisory lock that keeps two servers starting at
+// once from migrating the same database
+const migrationLock = 7305541
+
+// Migration is a single schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrations, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Migration, 0, len(files))
+	seen := make(map[int]bool)
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".up.sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a version", file)
+		}
+		if seen[version] {
+			return nil, fmt.Errorf("migration version %d is used twice", version)
+		}
+		seen[version] = true
+
+		sql, err := migrations.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: name, SQL: string(sql)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// Migrate brings the schema up to the latest migration.  Every migration
+// runs in its own transaction together with the row that records it in
+// schema_migrations, so a failed migration leaves nothing behind.  It
+// returns the versions that were applied.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) ([]int, error) {
+	list, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock); err != nil {
+			log.Println("Error releasing the migration lock: ", err)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	latest := 0
+	for _, version := range applied {
+		done[version] = true
+		latest = max(latest, version)
+	}
+
+	var ran []int
+	for _, migration := range list {
+		if done[migration.Version] {
+			continue
+		}
+		//A gap means the database was migrated by a different build
+		if migration.Version < latest {
+			return ran, fmt.Errorf("migration %s is missing from a database at version %d", migration.Name, latest)
+		}
+
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+				migration.Version, migration.Name)
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %s: %w", migration.Name, err)
+		}
+		log.Println("Applied migration ", migration.Name)
+		ran = append(ran, migration.Version)
+	}
+
+	return ran, nil
+}
@@ -0,0 +1,21 @@
+-- Voters are kept as JSON documents without their vote history, the
+-- history has a table of its own so it can be queried by poll.
+CREATE TABLE voters (
+	voter_id   integer PRIMARY KEY,
+	record     jsonb NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- position keeps the order of the history, a voter holds at most one
+-- entry per poll, see 0003.
+CREATE TABLE voter_polls (
+	voter_id  integer NOT NULL REFERENCES voters ON DELETE CASCADE,
+	position  integer NOT NULL,
+	poll_id   integer NOT NULL,
+	vote_id   integer NOT NULL,
+	vote_date timestamptz NOT NULL,
+	record    jsonb NOT NULL,
+	PRIMARY KEY (voter_id, position)
+);
+
+CREATE INDEX voter_polls_poll_id ON voter_polls (poll_id);
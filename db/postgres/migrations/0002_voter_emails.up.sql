@@ -0,0 +1,9 @@
+-- No two voters may share an email address.  email holds the address the
+-- way db.EmailKey indexes it, trimmed and in lower case, and is NULL for
+-- voters without one.  A roll that already holds a shared address has to
+-- be cleaned up before this migration can run.
+ALTER TABLE voters ADD COLUMN email text;
+
+UPDATE voters SET email = nullif(lower(btrim(record->>'Email')), '');
+
+CREATE UNIQUE INDEX voters_email ON voters (email);
@@ -0,0 +1,11 @@
+-- Ids handed out by CreateVoter come from a sequence, so the id of a
+-- deleted voter is never given to the next one.  It starts after the
+-- highest id in use, AddVoter moves it past ids given explicitly.
+CREATE SEQUENCE voter_ids OWNED BY voters.voter_id;
+
+SELECT setval('voter_ids', COALESCE(MAX(voter_id), 0) + 1, false) FROM voters;
+
+-- A voter holds at most one entry per poll, the API has always refused a
+-- second vote.  A roll that already holds one has to be cleaned up before
+-- this migration can run.
+ALTER TABLE voter_polls ADD CONSTRAINT voter_polls_voter_poll UNIQUE (voter_id, poll_id);
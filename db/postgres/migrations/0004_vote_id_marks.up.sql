@@ -0,0 +1,7 @@
+-- The highest VoteId handed out to each voter, and under voter_id 0
+-- across all voters, see db.VoteIdStore.  Marks are not tied to a voter
+-- row, they have to outlive the votes and voters they were handed out to.
+CREATE TABLE vote_id_marks (
+	voter_id integer PRIMARY KEY,
+	vote_id  integer NOT NULL
+);
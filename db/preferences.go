@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Preferences are the communication choices of a voter.  Contact is opt-in,
+// a voter only gets messages on a channel they agreed to, and DoNotContact
+// overrides everything else.
+type Preferences struct {
+	EmailOk      bool
+	SmsOk        bool
+	Language     string `validate:"max=35"` //BCP 47 tag, e.g. en or es-MX
+	DoNotContact bool
+}
+
+// Allows reports whether a voter can be contacted on a channel
+func (p Preferences) Allows(channel string) bool {
+	if p.DoNotContact {
+		return false
+	}
+
+	switch channel {
+	case "email":
+		return p.EmailOk
+	case "sms":
+		return p.SmsOk
+	}
+
+	return false
+}
+
+// ConsentChange records a change to one of the consent fields of a voter
+type ConsentChange struct {
+	VoterId int
+	Field   string
+	Old     string
+	New     string
+	Source  string //Who made the change, e.g. admin or unsubscribe
+	Time    time.Time
+}
+
+// logConsent appends a ConsentChange for every consent field that differs
+// between the old and new preferences
+func (t *VoterList) logConsent(voterID int, old, new Preferences, source string) {
+	changed := func(field string, oldValue, newValue bool) {
+		if oldValue != newValue {
+			t.consentLog = append(t.consentLog, ConsentChange{
+				VoterId: voterID,
+				Field:   field,
+				Old:     fmt.Sprint(oldValue),
+				New:     fmt.Sprint(newValue),
+				Source:  source,
+				Time:    time.Now(),
+			})
+		}
+	}
+
+	changed("EmailOk", old.EmailOk, new.EmailOk)
+	changed("SmsOk", old.SmsOk, new.SmsOk)
+	changed("DoNotContact", old.DoNotContact, new.DoNotContact)
+}
+
+// SetPreferences replaces the preferences of a voter, recording any consent
+// change along with its source
+func (t *VoterList) SetPreferences(voterID int, prefs Preferences, source string) (Voter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
+	if err != nil {
+		return Voter{}, err
+	}
+
+	t.logConsent(voterID, voter.Preferences, prefs, source)
+	voter.Preferences = prefs
+	t.putVoter(voter)
+
+	return voter, nil
+}
+
+// GetConsentHistory returns the consent changes of a voter, oldest first
+func (t *VoterList) GetConsentHistory(voterID int) ([]ConsentChange, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if _, err := t.getVoter(voterID); err != nil {
+		return nil, err
+	}
+
+	history := make([]ConsentChange, 0)
+	for _, change := range t.consentLog {
+		if change.VoterId == voterID {
+			history = append(history, change)
+		}
+	}
+
+	return history, nil
+}
+
+// sameEmail reports whether two email addresses are the same address
+func sameEmail(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// MarkEmailInvalid flags the email address of a voter as undeliverable
+// after it hard bounced.  The bounce is ignored when the voter has changed
+// their address since the message was sent.  It reports whether the voter
+// was flagged.
+func (t *VoterList) MarkEmailInvalid(voterID int, address string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
+	if err != nil {
+		return false, err
+	}
+	if address == "" || !sameEmail(voter.Email, address) {
+		return false, errors.New("the voter's email address has changed")
+	}
+	if voter.EmailInvalid {
+		return false, nil
+	}
+
+	voter.EmailInvalid = true
+	t.putVoter(voter)
+
+	return true, nil
+}
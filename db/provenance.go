@@ -0,0 +1,178 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ProvenanceRules names the source of truth of voter fields, the field
+// name mapped to the one source that may change it, for example
+//
+//	{"Address": "state-feed", "Email": "self-service"}
+//
+// A write from another source leaves an owned field as it is and the
+// change it wanted is flagged for review.  Fields without a rule can be
+// changed by any source.
+type ProvenanceRules map[string]string
+
+// Validate returns an error if a rule names a field voters do not have, or
+// no source
+func (r ProvenanceRules) Validate() error {
+	fields := reflect.TypeOf(Voter{})
+	for field, source := range r {
+		if _, ok := fields.FieldByName(field); !ok || field == "VoterId" {
+			return InvalidInput(fmt.Sprintf("%q is not a voter field that can be owned", field))
+		}
+		if source == "" {
+			return InvalidInput(fmt.Sprintf("the rule for %s names no source", field))
+		}
+	}
+	return nil
+}
+
+// ProvenanceConflict is a change to an owned field that was held back
+// because it came from a source that does not own the field
+type ProvenanceConflict struct {
+	Id       int
+	VoterId  int
+	Field    string
+	Owner    string          //Source that owns the field
+	Source   string          //Source whose change was held back
+	Proposed json.RawMessage //The value the change wanted
+	Time     time.Time
+}
+
+// SetProvenanceRules replaces the provenance rules, nil or empty rules let
+// every source change every field
+func (t *VoterList) SetProvenanceRules(rules ProvenanceRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.provenance = make(ProvenanceRules, len(rules))
+	for field, source := range rules {
+		t.provenance[field] = source
+	}
+	return nil
+}
+
+// GetProvenanceRules returns the provenance rules in force
+func (t *VoterList) GetProvenanceRules() ProvenanceRules {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rules := make(ProvenanceRules, len(t.provenance))
+	for field, source := range t.provenance {
+		rules[field] = source
+	}
+	return rules
+}
+
+// GetProvenanceConflicts returns every change that was held back, oldest
+// first
+func (t *VoterList) GetProvenanceConflicts() []ProvenanceConflict {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return append([]ProvenanceConflict{}, t.conflicts...)
+}
+
+// ApplyProvenance checks a write of source that replaces the stored voter
+// with voter against the provenance rules.  It returns the voter to write,
+// with every owned field source may not change put back to its stored
+// value, and the conflicts for those fields.  Once the write went through
+// the conflicts are handed to FlagConflicts.
+func (t *VoterList) ApplyProvenance(stored, voter Voter, source string) (Voter, []ProvenanceConflict) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.applyProvenance(stored, voter, source)
+}
+
+// applyProvenance is ApplyProvenance for callers that hold mu
+func (t *VoterList) applyProvenance(stored, voter Voter, source string) (Voter, []ProvenanceConflict) {
+	if len(t.provenance) == 0 {
+		return voter, nil
+	}
+
+	fields := make([]string, 0, len(t.provenance))
+	for field := range t.provenance {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var conflicts []ProvenanceConflict
+	from := reflect.ValueOf(stored)
+	to := reflect.ValueOf(&voter).Elem()
+	for _, field := range fields {
+		owner := t.provenance[field]
+		if owner == source || sameField(from.FieldByName(field), to.FieldByName(field)) {
+			continue
+		}
+
+		proposed, _ := json.Marshal(to.FieldByName(field).Interface())
+		conflicts = append(conflicts, ProvenanceConflict{
+			VoterId:  stored.VoterId,
+			Field:    field,
+			Owner:    owner,
+			Source:   source,
+			Proposed: proposed,
+			Time:     time.Now(),
+		})
+
+		to.FieldByName(field).Set(from.FieldByName(field))
+	}
+
+	return voter, conflicts
+}
+
+// SetReviewQueue sends every conflict that is flagged from now on to a
+// review queue, where accepting it applies the change that was held back
+func (t *VoterList) SetReviewQueue(queue *ReviewQueue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reviews = queue
+}
+
+// FlagConflicts records conflicts for review and returns them with their
+// ids
+func (t *VoterList) FlagConflicts(conflicts []ProvenanceConflict) []ProvenanceConflict {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.flagConflicts(conflicts)
+}
+
+// flagConflicts is FlagConflicts for callers that hold mu
+func (t *VoterList) flagConflicts(conflicts []ProvenanceConflict) []ProvenanceConflict {
+	for i := range conflicts {
+		conflicts[i].Id = len(t.conflicts) + 1
+		t.conflicts = append(t.conflicts, conflicts[i])
+		if t.reviews != nil {
+			t.reviews.Flag(ReviewProvenance, conflicts[i].VoterId,
+				fmt.Sprintf("%s owned by %s, change from %s held back", conflicts[i].Field, conflicts[i].Owner, conflicts[i].Source),
+				conflicts[i])
+		}
+	}
+	return conflicts
+}
+
+// sameField reports whether two values of a voter field are the same as
+// far as the API can tell, compared in their JSON form so a time read back
+// from a request matches the stored one.  Empty and missing lists are the
+// same.
+func sameField(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	x, errX := json.Marshal(a.Interface())
+	y, errY := json.Marshal(b.Interface())
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// QueueWindow is how far back queue reports are used for wait estimates,
+// older reports are dropped
+const QueueWindow = 30 * time.Minute
+
+// QueueReport is a throughput report sent by a check-in device.  It says
+// how many voters were checked in over the last PeriodSeconds and how
+// many people were waiting in line when the report was sent.
+type QueueReport struct {
+	PrecinctId    int
+	DeviceId      int
+	CheckIns      int
+	PeriodSeconds int
+	QueueLength   int
+	Reported      time.Time
+}
+
+// WaitEstimate is the public wait time estimate for a polling place
+type WaitEstimate struct {
+	PrecinctId        int
+	QueueLength       int
+	CheckInsPerHour   float64
+	EstimatedWaitMins int
+	Updated           time.Time
+}
+
+// QueueMetrics holds the recent queue reports per precinct
+type QueueMetrics struct {
+	mu      sync.Mutex
+	reports map[int][]QueueReport
+}
+
+// constructor for QueueMetrics struct
+func NewQueueMetrics() *QueueMetrics {
+	return &QueueMetrics{
+		reports: make(map[int][]QueueReport),
+	}
+}
+
+// AddReport stores a queue report and drops reports that have left the
+// estimation window
+func (q *QueueMetrics) AddReport(report QueueReport) (QueueReport, error) {
+	if report.CheckIns < 0 || report.QueueLength < 0 {
+		return QueueReport{}, InvalidInput("counts can not be negative")
+	}
+	if report.PeriodSeconds <= 0 {
+		return QueueReport{}, InvalidInput("period must be positive")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	report.Reported = time.Now()
+	q.reports[report.PrecinctId] = append(q.prune(report.PrecinctId), report)
+
+	return report, nil
+}
+
+// prune drops the reports of a precinct that are older than the window,
+// the caller must hold the lock
+func (q *QueueMetrics) prune(precinctID int) []QueueReport {
+	cutoff := time.Now().Add(-QueueWindow)
+
+	var recent []QueueReport
+	for _, report := range q.reports[precinctID] {
+		if report.Reported.After(cutoff) {
+			recent = append(recent, report)
+		}
+	}
+	q.reports[precinctID] = recent
+
+	return recent
+}
+
+// estimate works out the wait time from a set of reports.  Throughput is
+// the total check-ins over the total reported time, and the line length is
+// taken from the latest report of each device added together.
+func estimate(precinctID int, reports []QueueReport) WaitEstimate {
+	var checkIns, seconds int
+	latest := make(map[int]QueueReport)
+	for _, report := range reports {
+		checkIns += report.CheckIns
+		seconds += report.PeriodSeconds
+		if report.Reported.After(latest[report.DeviceId].Reported) {
+			latest[report.DeviceId] = report
+		}
+	}
+
+	est := WaitEstimate{PrecinctId: precinctID}
+	for _, report := range latest {
+		est.QueueLength += report.QueueLength
+		if report.Reported.After(est.Updated) {
+			est.Updated = report.Reported
+		}
+	}
+
+	if seconds > 0 {
+		//Devices report in parallel, so the rate of each device adds up
+		est.CheckInsPerHour = float64(checkIns) / float64(seconds) * 3600 * float64(len(latest))
+	}
+	if est.CheckInsPerHour > 0 {
+		est.EstimatedWaitMins = int(float64(est.QueueLength) / est.CheckInsPerHour * 60)
+	}
+
+	return est
+}
+
+// GetEstimate returns the wait estimate for a precinct
+func (q *QueueMetrics) GetEstimate(precinctID int) (WaitEstimate, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	reports := q.prune(precinctID)
+	if len(reports) == 0 {
+		return WaitEstimate{}, NotFound("no recent queue reports for precinct")
+	}
+
+	return estimate(precinctID, reports), nil
+}
+
+// GetAllEstimates returns the wait estimate of every precinct with recent
+// reports, ordered by precinct
+func (q *QueueMetrics) GetAllEstimates() []WaitEstimate {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	estimates := make([]WaitEstimate, 0)
+	for precinctID := range q.reports {
+		if reports := q.prune(precinctID); len(reports) > 0 {
+			estimates = append(estimates, estimate(precinctID, reports))
+		}
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		return estimates[i].PrecinctId < estimates[j].PrecinctId
+	})
+
+	return estimates
+}
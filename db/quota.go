@@ -0,0 +1,135 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The default soft quota on vote history changes, a voter votes in a
+// handful of polls a year so dozens of changes to one history within an
+// hour are an integration stuck in a loop, not real activity
+const (
+	DefaultHistoryQuota       = 20
+	DefaultHistoryQuotaWindow = time.Hour
+)
+
+// QuotaBreach is a voter whose vote history changed more often than the
+// quota allows.  Further changes are refused until the oldest ones fall
+// out of the window.
+type QuotaBreach struct {
+	VoterId  int
+	Changes  int       //Changes within the window
+	Rejected int       //Changes refused since the quota was hit
+	Since    time.Time //When the quota was hit
+}
+
+// HistoryQuota is a soft limit on the changes to the vote history of each
+// voter within a sliding window.  It is soft in that the voter can be
+// changed again once the window has moved on, nothing has to be reset.
+type HistoryQuota struct {
+	mu       sync.Mutex
+	limit    int //0 turns the quota off
+	window   time.Duration
+	changes  map[int][]time.Time //Change times per voter within the window, oldest first
+	breaches map[int]QuotaBreach //Voters over the quota
+}
+
+// constructor for HistoryQuota struct
+func NewHistoryQuota() *HistoryQuota {
+	return &HistoryQuota{
+		limit:    DefaultHistoryQuota,
+		window:   DefaultHistoryQuotaWindow,
+		changes:  make(map[int][]time.Time),
+		breaches: make(map[int]QuotaBreach),
+	}
+}
+
+// SetLimit sets how many changes to one history are allowed within the
+// window, a limit of 0 turns the quota off
+func (q *HistoryQuota) SetLimit(limit int, window time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = limit
+	q.window = window
+}
+
+// Limit returns the number of changes allowed and the window they are
+// counted in
+func (q *HistoryQuota) Limit() (int, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit, q.window
+}
+
+// Take counts a change to the history of a voter.  When the voter is over
+// the quota the change is not counted, ok is false and retry is how long
+// until it would be allowed.  first is set on the first change refused,
+// the one that should raise an alert, later refusals only count against
+// the breach.
+func (q *HistoryQuota) Take(voterID int, now time.Time) (breach QuotaBreach, retry time.Duration, first, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.limit <= 0 {
+		return QuotaBreach{}, 0, false, true
+	}
+
+	recent := q.recent(voterID, now)
+	if len(recent) < q.limit {
+		q.changes[voterID] = append(recent, now)
+		delete(q.breaches, voterID)
+		return QuotaBreach{}, 0, false, true
+	}
+
+	breach, found := q.breaches[voterID]
+	if !found {
+		breach = QuotaBreach{VoterId: voterID, Since: now}
+	}
+	breach.Changes = len(recent)
+	breach.Rejected++
+	q.breaches[voterID] = breach
+
+	return breach, recent[0].Add(q.window).Sub(now), !found, false
+}
+
+// recent drops the change times of a voter that fell out of the window
+// and returns the rest.  The caller holds mu.
+func (q *HistoryQuota) recent(voterID int, now time.Time) []time.Time {
+	recent := q.changes[voterID]
+	for len(recent) > 0 && now.Sub(recent[0]) >= q.window {
+		recent = recent[1:]
+	}
+	if len(recent) == 0 {
+		delete(q.changes, voterID)
+		return nil
+	}
+	q.changes[voterID] = recent
+	return recent
+}
+
+// GetBreaches returns the voters over the quota ordered by voter id.
+// Voters the window has moved on from are dropped, along with the change
+// times of voters that were left alone for a whole window.
+func (q *HistoryQuota) GetBreaches(now time.Time) []QuotaBreach {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id := range q.changes {
+		q.recent(id, now)
+	}
+
+	breaches := []QuotaBreach{}
+	for id, breach := range q.breaches {
+		if len(q.changes[id]) < q.limit {
+			delete(q.breaches, id)
+			continue
+		}
+		breaches = append(breaches, breach)
+	}
+	sort.Slice(breaches, func(i, j int) bool {
+		return breaches[i].VoterId < breaches[j].VoterId
+	})
+
+	return breaches
+}
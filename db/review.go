@@ -0,0 +1,238 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The kinds of item that land in the review queue
+const (
+	ReviewProvenance      = "provenance"       //A change held back by the provenance rules, Detail is the ProvenanceConflict
+	ReviewAnomaly         = "anomaly"          //Activity that looks wrong, e.g. a decoy voter was read, Detail is the TripwireAlert
+	ReviewProvisionalVote = "provisional_vote" //A vote that only counts once the voter's eligibility is confirmed
+	ReviewDuplicate       = "duplicate"        //A voter that may be someone else, Detail is the ExclusionCandidate
+)
+
+// The states of a review item, open items move to assigned when someone
+// takes them and to resolved once they are adjudicated
+const (
+	ReviewOpen     = "open"
+	ReviewAssigned = "assigned"
+	ReviewResolved = "resolved"
+)
+
+// The resolutions of a review item.  Accepting a provenance item applies
+// the change that was held back, every other resolution is only recorded.
+const (
+	ReviewAccept = "accept"
+	ReviewReject = "reject"
+)
+
+// DefaultReviewSLA is how long an item may wait for a resolution before it
+// counts as overdue
+const DefaultReviewSLA = 48 * time.Hour
+
+// ReviewItem is a flagged operation waiting for a person to adjudicate it
+type ReviewItem struct {
+	Id         int
+	Kind       string
+	VoterId    int             //0 when the item is not about one voter
+	Summary    string          //One line for the queue
+	Detail     json.RawMessage //The flagged record, its type depends on Kind
+	Status     string
+	Assignee   string
+	Resolution string
+	Note       string //Why it was resolved the way it was
+	ResolvedBy string
+	Created    time.Time
+	Assigned   time.Time
+	Resolved   time.Time
+}
+
+// ReviewMetrics are the SLA metrics of the review queue.  Items count as
+// overdue once they waited longer than the SLA for a resolution.
+type ReviewMetrics struct {
+	SLASeconds         int
+	Open               int //Items not resolved yet, assigned or not
+	Unassigned         int //Open items nobody has taken
+	Overdue            int //Open items waiting longer than the SLA
+	Resolved           int
+	ResolvedLate       int            //Items resolved after the SLA ran out
+	OldestOpenSeconds  int            //Wait of the oldest open item
+	MeanResolveSeconds int            //Mean time from flagged to resolved
+	OpenByKind         map[string]int //Open items by kind
+}
+
+// ReviewQueue holds the flagged operations, open and resolved
+type ReviewQueue struct {
+	mu     sync.Mutex
+	items  []ReviewItem //By id, item n is at n-1
+	sla    time.Duration
+	nextId int
+}
+
+// constructor for ReviewQueue struct
+func NewReviewQueue() *ReviewQueue {
+	return &ReviewQueue{sla: DefaultReviewSLA, nextId: 1}
+}
+
+// SetSLA sets how long an item may wait for a resolution
+func (q *ReviewQueue) SetSLA(sla time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sla = sla
+}
+
+// Flag adds an item to the queue and returns it with its assigned id.
+// detail is the flagged record, it is kept in its JSON form so the queue
+// does not need to know every kind.
+func (q *ReviewQueue) Flag(kind string, voterID int, summary string, detail any) ReviewItem {
+	data, _ := json.Marshal(detail)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := ReviewItem{
+		Id:      q.nextId,
+		Kind:    kind,
+		VoterId: voterID,
+		Summary: summary,
+		Detail:  data,
+		Status:  ReviewOpen,
+		Created: time.Now(),
+	}
+	q.nextId++
+	q.items = append(q.items, item)
+
+	return item
+}
+
+// GetItem returns a review item by id
+func (q *ReviewQueue) GetItem(id int) (ReviewItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id < 1 || id > len(q.items) {
+		return ReviewItem{}, NotFound("review item does not exist")
+	}
+	return q.items[id-1], nil
+}
+
+// GetItems returns the items with the given status and kind, oldest first.
+// An empty status or kind matches every item, status "open" matches
+// assigned items too since they are not resolved yet.
+func (q *ReviewQueue) GetItems(status, kind string) []ReviewItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := []ReviewItem{}
+	for _, item := range q.items {
+		if kind != "" && item.Kind != kind {
+			continue
+		}
+		if status != "" && item.Status != status &&
+			!(status == ReviewOpen && item.Status == ReviewAssigned) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Assign hands an item to a reviewer, an item that is already assigned
+// moves to the new reviewer.  A resolved item can not be assigned.
+func (q *ReviewQueue) Assign(id int, assignee string) (ReviewItem, error) {
+	if assignee == "" {
+		return ReviewItem{}, InvalidInput("an item has to be assigned to someone")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, err := q.openItem(id)
+	if err != nil {
+		return ReviewItem{}, err
+	}
+	item.Status = ReviewAssigned
+	item.Assignee = assignee
+	item.Assigned = time.Now()
+
+	return *item, nil
+}
+
+// Resolve records the resolution of an item, which is final
+func (q *ReviewQueue) Resolve(id int, resolution, note, by string) (ReviewItem, error) {
+	if resolution != ReviewAccept && resolution != ReviewReject {
+		return ReviewItem{}, InvalidInput(fmt.Sprintf("resolution %q is not one of %s, %s", resolution, ReviewAccept, ReviewReject))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, err := q.openItem(id)
+	if err != nil {
+		return ReviewItem{}, err
+	}
+	item.Status = ReviewResolved
+	item.Resolution = resolution
+	item.Note = note
+	item.ResolvedBy = by
+	item.Resolved = time.Now()
+
+	return *item, nil
+}
+
+// openItem returns the item to change, which must not be resolved yet.
+// The caller holds mu.
+func (q *ReviewQueue) openItem(id int) (*ReviewItem, error) {
+	if id < 1 || id > len(q.items) {
+		return nil, NotFound("review item does not exist")
+	}
+	item := &q.items[id-1]
+	if item.Status == ReviewResolved {
+		return nil, AlreadyExists(fmt.Sprintf("review item %d was already resolved (%s)", id, item.Resolution))
+	}
+	return item, nil
+}
+
+// Metrics returns the SLA metrics of the queue as of now
+func (q *ReviewQueue) Metrics() ReviewMetrics {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	metrics := ReviewMetrics{
+		SLASeconds: int(q.sla.Seconds()),
+		OpenByKind: make(map[string]int),
+	}
+	var resolving time.Duration
+	for _, item := range q.items {
+		if item.Status == ReviewResolved {
+			took := item.Resolved.Sub(item.Created)
+			metrics.Resolved++
+			resolving += took
+			if took > q.sla {
+				metrics.ResolvedLate++
+			}
+			continue
+		}
+
+		waiting := now.Sub(item.Created)
+		metrics.Open++
+		metrics.OpenByKind[item.Kind]++
+		if item.Status == ReviewOpen {
+			metrics.Unassigned++
+		}
+		if waiting > q.sla {
+			metrics.Overdue++
+		}
+		metrics.OldestOpenSeconds = max(metrics.OldestOpenSeconds, int(waiting.Seconds()))
+	}
+	if metrics.Resolved > 0 {
+		metrics.MeanResolveSeconds = int(resolving.Seconds()) / metrics.Resolved
+	}
+
+	return metrics
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"time"
+)
+
+// DefaultRevisionLimit is how many revisions are kept in memory unless
+// SetRevisionLimit says otherwise, a full snapshot of a voter each
+const DefaultRevisionLimit = 500000
+
+// Revision is a snapshot of a voter taken every time the voter changes.
+// Together the revisions make up the history of the roll, which is what
+// as-of queries are answered from.  The history is kept in memory only, it
+// starts when the server does and its oldest part is dropped once it
+// outgrows the limit.  Before that, a voter is taken as it was first
+// recorded, from the time it was registered.
+type Revision struct {
+	VoterId int
+	Time    time.Time
+	Voter   Voter
+	Deleted bool
+}
+
+// cloneVoter returns a copy of the voter that shares no slices with it, so
+// a snapshot can not be changed through the voter it was taken from
+func cloneVoter(voter Voter) Voter {
+	voter.VoteHistory = append([]VoterHistory(nil), voter.VoteHistory...)
+	voter.AddressHistory = append([]AddressChange(nil), voter.AddressHistory...)
+	voter.Tags = append([]string(nil), voter.Tags...)
+	return voter
+}
+
+// putVoter stores a voter and records a revision for it.  All writes to the
+// map must go through putVoter or removeVoter so the history stays complete.
+// Writing an archived voter brings it back into the hot set, where it
+// shadows the copy in the cold store.
+func (t *VoterList) putVoter(voter Voter) {
+	voter.Archived = false
+	if t.reported(voter.VoterId) {
+		old, existed := t.Voters[voter.VoterId]
+		if !existed && t.coldStore != nil {
+			//An archived voter being written is an update, not a new voter
+			old, existed, _ = t.coldStore.Get(voter.VoterId)
+		}
+		t.notify(voterChanges(old, existed, voter, time.Now()))
+	}
+	t.Voters[voter.VoterId] = cloneVoter(voter)
+	t.lastId = max(t.lastId, voter.VoterId)
+	t.emails.set(voter.VoterId, voter.Email)
+	t.checksum = nil
+	t.addRevision(Revision{
+		VoterId: voter.VoterId,
+		Time:    time.Now(),
+		Voter:   cloneVoter(voter),
+	})
+	t.shadowWrite(voter)
+}
+
+// removeVoter deletes a voter, including any archived copy, and records the
+// deletion as a revision
+func (t *VoterList) removeVoter(id int) error {
+	if t.coldStore != nil {
+		if err := t.coldStore.Delete(id); err != nil {
+			return err
+		}
+	}
+
+	if t.reported(id) {
+		old := t.Voters[id]
+		t.notify([]Change{{Event: EventVoterDeleted, Time: time.Now(), VoterId: id, Voter: old}})
+	}
+	delete(t.Voters, id)
+	t.emails.remove(id)
+	t.checksum = nil
+	t.addRevision(Revision{
+		VoterId: id,
+		Time:    time.Now(),
+		Deleted: true,
+	})
+	t.shadowDelete(id)
+	delete(t.decoys, id)
+
+	return nil
+}
+
+// SetRevisionLimit sets how many revisions are kept in memory, 0 keeps
+// every one.  The default is DefaultRevisionLimit.
+func (t *VoterList) SetRevisionLimit(limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.revLimit = limit
+	t.trimRevisions()
+}
+
+// addRevision records a revision, the caller holds mu
+func (t *VoterList) addRevision(rev Revision) {
+	t.revisions = append(t.revisions, rev)
+	t.trimRevisions()
+}
+
+// trimRevisions drops the oldest quarter of the history once it is over
+// the limit, so the copying is not done on every write.  The caller holds
+// mu.
+func (t *VoterList) trimRevisions() {
+	if t.revLimit <= 0 || len(t.revisions) <= t.revLimit {
+		return
+	}
+
+	drop := len(t.revisions) - t.revLimit*3/4
+	t.revisions = append([]Revision(nil), t.revisions[drop:]...)
+	t.revBase += drop
+}
+
+// GetVoterAsOf returns the voter as it was at the given time.  It returns
+// an error if the voter was not registered at that time.
+func (t *VoterList) GetVoterAsOf(id int, asOf time.Time) (Voter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for i := len(t.revisions) - 1; i >= 0; i-- {
+		rev := t.revisions[i]
+		if rev.VoterId != id || rev.Time.After(asOf) {
+			continue
+		}
+		if rev.Deleted {
+			return Voter{}, NotFound("voter was not registered at that time")
+		}
+		return cloneVoter(rev.Voter), nil
+	}
+
+	if voter, ok := t.firstRecorded(id); ok && registeredBy(voter, asOf) {
+		return cloneVoter(voter), nil
+	}
+	return Voter{}, NotFound("voter was not registered at that time")
+}
+
+// firstRecorded returns the oldest state of a voter the list still knows,
+// its first revision or, with none left, the voter as it is now.  A voter
+// deleted in its first revision is not returned.  The caller holds mu.
+func (t *VoterList) firstRecorded(id int) (Voter, bool) {
+	for _, rev := range t.revisions {
+		if rev.VoterId == id {
+			return rev.Voter, !rev.Deleted
+		}
+	}
+	voter, ok := t.Voters[id]
+	return voter, ok
+}
+
+// registeredBy reports whether a voter from before the history began was
+// already registered at the given time
+func registeredBy(voter Voter, asOf time.Time) bool {
+	return !voter.Registered.IsZero() && !voter.Registered.After(asOf)
+}
+
+// GetRevisions returns the revisions of a voter still kept, oldest first
+func (t *VoterList) GetRevisions(id int) []Revision {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	revisions := make([]Revision, 0)
+	for _, rev := range t.revisions {
+		if rev.VoterId == id {
+			revisions = append(revisions, rev)
+		}
+	}
+
+	return revisions
+}
+
+// CountAsOf returns the number of voters registered at the given time
+func (t *VoterList) CountAsOf(asOf time.Time) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	registered := make(map[int]bool)
+	for _, rev := range t.revisions {
+		if rev.Time.After(asOf) {
+			//Voters first seen after asOf may be older than the history
+			if _, seen := registered[rev.VoterId]; !seen {
+				registered[rev.VoterId] = !rev.Deleted && registeredBy(rev.Voter, asOf)
+			}
+			continue
+		}
+		registered[rev.VoterId] = !rev.Deleted
+	}
+	for id, voter := range t.Voters {
+		if _, seen := registered[id]; !seen {
+			registered[id] = registeredBy(voter, asOf)
+		}
+	}
+
+	count := 0
+	for _, ok := range registered {
+		if ok {
+			count++
+		}
+	}
+
+	return count
+}
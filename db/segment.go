@@ -0,0 +1,219 @@
+package db
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// segmentName is the allowed form of a segment name, it is used in URLs
+var segmentName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Segment is a saved voter filter that can be referred to by name
+type Segment struct {
+	Name    string
+	Filter  VoterFilter
+	Created time.Time
+}
+
+// SegmentCount is the size of a segment at a point in time
+type SegmentCount struct {
+	Time  time.Time
+	Count int
+}
+
+// SegmentStore holds the saved segments
+type SegmentStore struct {
+	mu       sync.Mutex
+	segments map[string]Segment
+	views    map[string]SegmentView
+}
+
+// constructor for SegmentStore struct
+func NewSegmentStore() *SegmentStore {
+	return &SegmentStore{
+		segments: make(map[string]Segment),
+		views:    make(map[string]SegmentView),
+	}
+}
+
+// AddSegment saves a segment, the name must not be in use
+func (s *SegmentStore) AddSegment(segment Segment) (Segment, error) {
+	if !segmentName.MatchString(segment.Name) {
+		return Segment{}, InvalidInput("segment name must be lower case letters, digits, - or _")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.segments[segment.Name]; ok {
+		return Segment{}, AlreadyExists("segment already exists")
+	}
+
+	segment.Created = time.Now()
+	s.segments[segment.Name] = segment
+
+	return segment, nil
+}
+
+// GetSegment returns a segment by name
+func (s *SegmentStore) GetSegment(name string) (Segment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segment, ok := s.segments[name]
+	if !ok {
+		return Segment{}, NotFound("segment does not exist")
+	}
+
+	return segment, nil
+}
+
+// DeleteSegment removes a segment
+func (s *SegmentStore) DeleteSegment(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.segments[name]; !ok {
+		return NotFound("segment does not exist")
+	}
+
+	delete(s.segments, name)
+	delete(s.views, name)
+	return nil
+}
+
+// GetAllSegments returns all segments ordered by name
+func (s *SegmentStore) GetAllSegments() []Segment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := make([]Segment, 0, len(s.segments))
+	for _, segment := range s.segments {
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name < segments[j].Name
+	})
+
+	return segments
+}
+
+// CountsOverTime returns how many voters matched the filter at each of the
+// given times, which must be in ascending order.  The counts are worked out
+// by replaying the revision history once.
+func (t *VoterList) CountsOverTime(filter VoterFilter, times []time.Time) []SegmentCount {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	counts := make([]SegmentCount, 0, len(times))
+	matching := make(map[int]bool)
+
+	//Voters first recorded after a time may be older than the history,
+	//they count from when they were registered
+	first := make(map[int]Revision)
+	for _, rev := range t.revisions {
+		if _, ok := first[rev.VoterId]; !ok {
+			first[rev.VoterId] = rev
+		}
+	}
+	for id, voter := range t.Voters {
+		if _, ok := first[id]; !ok {
+			first[id] = Revision{VoterId: id, Voter: voter}
+		}
+	}
+
+	next := 0
+	for _, at := range times {
+		for ; next < len(t.revisions) && !t.revisions[next].Time.After(at); next++ {
+			rev := t.revisions[next]
+			matching[rev.VoterId] = !rev.Deleted && filter.matches(rev.Voter)
+		}
+
+		count := 0
+		for id, rev := range first {
+			ok, seen := matching[id]
+			if !seen {
+				ok = !rev.Deleted && registeredBy(rev.Voter, at) && filter.matches(rev.Voter)
+			}
+			if ok {
+				count++
+			}
+		}
+		counts = append(counts, SegmentCount{Time: at, Count: count})
+	}
+
+	return counts
+}
+
+// SegmentView is the precomputed membership of a segment.  It is refreshed
+// on a schedule, Version is the roll version it was computed at so callers
+// can tell whether the roll has changed since.
+type SegmentView struct {
+	Name        string
+	VoterIds    []int
+	Count       int
+	RefreshedAt time.Time
+	Version     int
+}
+
+// SetView stores the precomputed membership of a segment
+func (s *SegmentStore) SetView(view SegmentView) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.segments[view.Name]; !ok {
+		return NotFound("segment does not exist")
+	}
+
+	s.views[view.Name] = view
+	return nil
+}
+
+// GetView returns the precomputed membership of a segment
+func (s *SegmentStore) GetView(name string) (SegmentView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	view, ok := s.views[name]
+	if !ok {
+		return SegmentView{}, errors.New("segment has not been computed yet")
+	}
+
+	return view, nil
+}
+
+// Version returns a number that grows with every change to the roll, two
+// equal versions mean nothing has changed in between
+func (t *VoterList) Version() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.revBase + len(t.revisions)
+}
+
+// MaterializeSegment computes the membership of a segment
+func (t *VoterList) MaterializeSegment(segment Segment) (SegmentView, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	voterList, err := t.findVoters(segment.Filter)
+	if err != nil {
+		return SegmentView{}, err
+	}
+
+	ids := make([]int, 0, len(voterList))
+	for _, voter := range voterList {
+		ids = append(ids, voter.VoterId)
+	}
+	sort.Ints(ids)
+
+	return SegmentView{
+		Name:        segment.Name,
+		VoterIds:    ids,
+		Count:       len(ids),
+		RefreshedAt: time.Now(),
+		Version:     t.revBase + len(t.revisions),
+	}, nil
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxShadowMismatches is the number of recent mismatches kept for review
+const maxShadowMismatches = 100
+
+// ShadowStore is a second storage backend that is being migrated to.  In
+// shadow mode every write also goes to the shadow store and every read is
+// compared against it.  The shadow never changes what the API returns, it
+// only reports where the two backends disagree.  FileColdStore can be
+// used as a shadow.
+type ShadowStore interface {
+	Put(voter Voter) error
+	Get(id int) (Voter, bool, error)
+	Delete(id int) error
+}
+
+// ShadowMismatch is a read where the shadow store disagreed
+type ShadowMismatch struct {
+	VoterId int
+	Time    time.Time
+	Reason  string
+}
+
+// ShadowStats counts the shadow traffic and its divergences
+type ShadowStats struct {
+	Enabled     bool
+	Writes      int64
+	WriteErrors int64
+	Reads       int64
+	ReadErrors  int64
+	Mismatches  int64
+	Recent      []ShadowMismatch //Most recent last
+}
+
+// shadow is the shadow store together with its stats
+type shadow struct {
+	mu    sync.Mutex
+	store ShadowStore
+	stats ShadowStats
+}
+
+// SetShadow turns on shadow mode with the given store.  The store should
+// start out empty, voters already in the list are not copied to it.
+func (t *VoterList) SetShadow(store ShadowStore) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shadow = &shadow{store: store, stats: ShadowStats{Enabled: true}}
+}
+
+// ShadowStats returns the shadow traffic counters
+func (t *VoterList) ShadowStats() ShadowStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.shadow == nil {
+		return ShadowStats{}
+	}
+
+	t.shadow.mu.Lock()
+	defer t.shadow.mu.Unlock()
+
+	stats := t.shadow.stats
+	stats.Recent = append([]ShadowMismatch(nil), stats.Recent...)
+	return stats
+}
+
+// shadowWrite copies a write to the shadow store, failures are counted
+// and logged but never fail the write
+func (t *VoterList) shadowWrite(voter Voter) {
+	if t.shadow == nil {
+		return
+	}
+
+	err := t.shadow.store.Put(voter)
+
+	t.shadow.mu.Lock()
+	defer t.shadow.mu.Unlock()
+	t.shadow.stats.Writes++
+	if err != nil {
+		log.Println("Shadow write failed: ", err)
+		t.shadow.stats.WriteErrors++
+	}
+}
+
+// shadowDelete copies a delete to the shadow store
+func (t *VoterList) shadowDelete(id int) {
+	if t.shadow == nil {
+		return
+	}
+
+	err := t.shadow.store.Delete(id)
+
+	t.shadow.mu.Lock()
+	defer t.shadow.mu.Unlock()
+	t.shadow.stats.Writes++
+	if err != nil {
+		log.Println("Shadow delete failed: ", err)
+		t.shadow.stats.WriteErrors++
+	}
+}
+
+// shadowRead reads a voter from the shadow store and compares it with what
+// the primary store returned
+func (t *VoterList) shadowRead(id int, voter Voter, found bool) {
+	if t.shadow == nil {
+		return
+	}
+
+	shadowVoter, shadowFound, err := t.shadow.store.Get(id)
+
+	reason := ""
+	switch {
+	case err != nil:
+	case found && !shadowFound:
+		reason = "missing from shadow"
+	case !found && shadowFound:
+		reason = "only in shadow"
+	case found:
+		voter.Archived = false
+		if hashVoter(voter) != hashVoter(shadowVoter) {
+			reason = "records differ"
+		}
+	}
+
+	t.shadow.mu.Lock()
+	defer t.shadow.mu.Unlock()
+	t.shadow.stats.Reads++
+	if err != nil {
+		log.Println("Shadow read failed: ", err)
+		t.shadow.stats.ReadErrors++
+		return
+	}
+	if reason == "" {
+		return
+	}
+
+	log.Println("Shadow mismatch for voter ", id, ": ", reason)
+	t.shadow.stats.Mismatches++
+	t.shadow.stats.Recent = append(t.shadow.stats.Recent, ShadowMismatch{
+		VoterId: id,
+		Time:    time.Now(),
+		Reason:  reason,
+	})
+	if len(t.shadow.stats.Recent) > maxShadowMismatches {
+		t.shadow.stats.Recent = t.shadow.stats.Recent[1:]
+	}
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How far a client supplied vote time may be from the server clock.  A
+// tablet with a wrong clock is refused rather than let it corrupt the
+// turnout time series.
+const (
+	MaxClockAhead  = 5 * time.Minute
+	MaxClockBehind = time.Hour
+)
+
+// VoteDateWindow is the range of vote dates a history entry may carry,
+// relative to the server clock.  A vote dated before the window is a typo
+// or a placeholder like 0001-01-01 rather than an old election, one dated
+// after it has not happened yet.
+type VoteDateWindow struct {
+	MaxAge   time.Duration //How long ago a vote may have been cast
+	MaxAhead time.Duration //How far ahead of the server a device clock may run
+}
+
+// DefaultVoteDateWindow takes votes from the last twenty years, which
+// covers every election a roll still carries history for
+var DefaultVoteDateWindow = VoteDateWindow{MaxAge: 20 * 365 * 24 * time.Hour, MaxAhead: MaxClockAhead}
+
+// Check returns an error if a vote date is missing or outside the window
+func (w VoteDateWindow) Check(date, now time.Time) error {
+	switch {
+	case date.IsZero():
+		return InvalidInput("vote date is required")
+	case date.Before(now.Add(-w.MaxAge)):
+		return InvalidInput(fmt.Sprintf("vote date %s is before %s, the earliest date accepted",
+			date.Format(time.DateOnly), now.Add(-w.MaxAge).Format(time.DateOnly)))
+	case date.After(now.Add(w.MaxAhead)):
+		return InvalidInput(fmt.Sprintf("vote date %s is in the future", date.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// DeviceSkew is the clock skew seen from one device, positive skews mean
+// the device clock runs ahead of the server.  Device 0 collects the votes
+// that were recorded without a device.
+type DeviceSkew struct {
+	DeviceId int
+	Samples  int
+	Rejected int
+	Last     time.Duration
+	Mean     time.Duration
+	MaxAbs   time.Duration
+	Updated  time.Time
+}
+
+// ClockSkew holds the skew metrics of every device that recorded votes
+type ClockSkew struct {
+	mu      sync.Mutex
+	devices map[int]*DeviceSkew
+}
+
+// constructor for ClockSkew struct
+func NewClockSkew() *ClockSkew {
+	return &ClockSkew{
+		devices: make(map[int]*DeviceSkew),
+	}
+}
+
+// CheckSkew measures the skew of a client supplied time against the
+// server time, records it for the device and returns an error if the
+// client time is outside the accepted window
+func (s *ClockSkew) CheckSkew(deviceID int, client, server time.Time) error {
+	skew := client.Sub(server)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.devices[deviceID]
+	if !ok {
+		stats = &DeviceSkew{DeviceId: deviceID}
+		s.devices[deviceID] = stats
+	}
+
+	stats.Samples++
+	stats.Mean += (skew - stats.Mean) / time.Duration(stats.Samples)
+	stats.Last = skew
+	stats.MaxAbs = max(stats.MaxAbs, skew, -skew)
+	stats.Updated = server
+
+	if skew > MaxClockAhead || skew < -MaxClockBehind {
+		stats.Rejected++
+		return InvalidInput(fmt.Sprintf("vote time is %s off the server clock, check the device clock", skew.Round(time.Second)))
+	}
+
+	return nil
+}
+
+// GetSkews returns the skew metrics of every device ordered by device id
+func (s *ClockSkew) GetSkews() []DeviceSkew {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	skews := make([]DeviceSkew, 0, len(s.devices))
+	for _, stats := range s.devices {
+		skews = append(skews, *stats)
+	}
+	sort.Slice(skews, func(i, j int) bool {
+		return skews[i].DeviceId < skews[j].DeviceId
+	})
+
+	return skews
+}
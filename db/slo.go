@@ -0,0 +1,261 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSLOWindow is the compliance window of an SLO that names none
+const DefaultSLOWindow = 30 * 24 * time.Hour
+
+// sloBucket is the width of the buckets requests are counted in
+const sloBucket = time.Minute
+
+// sloAlertsKept is the number of resolved alerts kept for the dashboard
+const sloAlertsKept = 100
+
+// Alert severities
+const (
+	SeverityPage   = "page"
+	SeverityTicket = "ticket"
+)
+
+// burnRule is a multiwindow burn rate alert: it fires while the error
+// budget burns faster than Rate over both the long and the short window.
+// The short window makes the alert resolve soon after the burn stops.
+type burnRule struct {
+	severity string
+	long     time.Duration
+	short    time.Duration
+	rate     float64
+}
+
+// burnRules are the alerts of every SLO, tuned for a 30 day window: the
+// pages fire when 2% of the budget is gone within an hour or 5% within
+// six hours, the ticket when 10% is gone within three days.  Rules whose
+// long window is longer than the SLO window are skipped.
+var burnRules = []burnRule{
+	{severity: SeverityPage, long: time.Hour, short: 5 * time.Minute, rate: 14.4},
+	{severity: SeverityPage, long: 6 * time.Hour, short: 30 * time.Minute, rate: 6},
+	{severity: SeverityTicket, long: 3 * 24 * time.Hour, short: 6 * time.Hour, rate: 1},
+}
+
+// burnWindows are the windows burn rates are reported over
+var burnWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 3 * 24 * time.Hour}
+
+// routeConstraint matches the constraint of a route parameter, e.g. <int>
+var routeConstraint = regexp.MustCompile(`<[^>]*>`)
+
+// SLO is a service level objective for one route: Objective percent of its
+// requests are good.  A request is bad when it fails with a server error
+// or, when Latency is set, takes longer than Latency.
+type SLO struct {
+	Name      string
+	Method    string        //Empty for any method
+	Route     string        //Route pattern, e.g. /voters/:id, parameter constraints may be left out
+	Objective float64       //e.g. 99.9
+	Latency   time.Duration //0 when only server errors count
+	Window    time.Duration //Compliance window, DefaultSLOWindow when 0
+}
+
+// SLOAlert is a burn rate alert of an SLO
+type SLOAlert struct {
+	SLO      string
+	Severity string
+	Window   string  //Long window of the rule, e.g. 1h0m0s
+	BurnRate float64 //Over the long window when the alert fired
+	Since    time.Time
+	Resolved *time.Time //nil while the alert fires
+}
+
+// SLOStatus is how an SLO is doing over its window
+type SLOStatus struct {
+	SLO
+	Requests        int64
+	Bad             int64
+	Compliance      float64            //Percent of good requests, 100 without requests
+	BudgetRemaining float64            //Share of the error budget left, negative once it is spent
+	BurnRates       map[string]float64 //Budget burn rate by window, 1 spends the budget exactly over the SLO window
+	Alerts          []SLOAlert         //Firing alerts
+}
+
+// sloCount is the number of requests to an SLO's route within a bucket
+type sloCount struct {
+	start int64 //Bucket number, buckets are reused once the window has moved on
+	total int64
+	bad   int64
+}
+
+// sloState is an SLO with its counts
+type sloState struct {
+	slo     SLO
+	route   string //Route without parameter constraints
+	buckets []sloCount
+	firing  map[burnRule]*SLOAlert
+}
+
+// SLOTracker counts good and bad requests against the configured SLOs and
+// raises burn rate alerts
+type SLOTracker struct {
+	mu       sync.Mutex
+	slos     []*sloState
+	resolved []SLOAlert //Newest last
+}
+
+// constructor for SLOTracker struct
+func NewSLOTracker(slos []SLO) (*SLOTracker, error) {
+	t := &SLOTracker{}
+	names := make(map[string]bool)
+	for _, slo := range slos {
+		if slo.Name == "" || slo.Route == "" {
+			return nil, InvalidInput("an SLO needs a name and a route")
+		}
+		if names[slo.Name] {
+			return nil, AlreadyExists(fmt.Sprintf("SLO %s is defined twice", slo.Name))
+		}
+		if slo.Objective <= 0 || slo.Objective >= 100 {
+			return nil, InvalidInput(fmt.Sprintf("SLO %s: objective must be between 0 and 100", slo.Name))
+		}
+		if slo.Latency < 0 || slo.Window < 0 {
+			return nil, InvalidInput(fmt.Sprintf("SLO %s: latency and window can not be negative", slo.Name))
+		}
+		if slo.Window == 0 {
+			slo.Window = DefaultSLOWindow
+		}
+		if slo.Window < sloBucket {
+			return nil, InvalidInput(fmt.Sprintf("SLO %s: window must be at least %v", slo.Name, sloBucket))
+		}
+		names[slo.Name] = true
+
+		t.slos = append(t.slos, &sloState{
+			slo:     slo,
+			route:   routeConstraint.ReplaceAllString(slo.Route, ""),
+			buckets: make([]sloCount, slo.Window/sloBucket),
+			firing:  make(map[burnRule]*SLOAlert),
+		})
+	}
+	return t, nil
+}
+
+// Observe counts a request against the SLOs of its route
+func (t *SLOTracker) Observe(method, route string, status int, duration time.Duration, now time.Time) {
+	route = routeConstraint.ReplaceAllString(route, "")
+	n := now.UnixNano() / int64(sloBucket)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.slos {
+		if s.route != route || (s.slo.Method != "" && s.slo.Method != method) {
+			continue
+		}
+		b := &s.buckets[n%int64(len(s.buckets))]
+		if b.start != n {
+			*b = sloCount{start: n}
+		}
+		b.total++
+		if status >= 500 || (s.slo.Latency > 0 && duration > s.slo.Latency) {
+			b.bad++
+		}
+	}
+}
+
+// counts sums the buckets of the last window.  The caller holds mu.
+func (s *sloState) counts(window time.Duration, now time.Time) (total, bad int64) {
+	n := now.UnixNano() / int64(sloBucket)
+	oldest := n - int64(window/sloBucket) + 1
+	for _, b := range s.buckets {
+		if b.start >= oldest && b.start <= n {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	return total, bad
+}
+
+// burnRate returns how fast the error budget burned over a window.  The
+// caller holds mu.
+func (s *sloState) burnRate(window time.Duration, now time.Time) float64 {
+	total, bad := s.counts(window, now)
+	if total == 0 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - s.slo.Objective/100)
+}
+
+// Evaluate fires and resolves the burn rate alerts and returns the status
+// of every SLO.  fired are the alerts that started firing with this call.
+func (t *SLOTracker) Evaluate(now time.Time) (statuses []SLOStatus, fired []SLOAlert) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.slos {
+		for _, rule := range burnRules {
+			if rule.long > s.slo.Window {
+				continue
+			}
+			rate := s.burnRate(rule.long, now)
+			burning := rate > rule.rate && s.burnRate(rule.short, now) > rule.rate
+
+			alert, firing := s.firing[rule]
+			switch {
+			case burning && !firing:
+				alert = &SLOAlert{SLO: s.slo.Name, Severity: rule.severity, Window: rule.long.String(), BurnRate: rate, Since: now}
+				s.firing[rule] = alert
+				fired = append(fired, *alert)
+			case !burning && firing:
+				resolved := now
+				alert.Resolved = &resolved
+				delete(s.firing, rule)
+				t.resolved = append(t.resolved, *alert)
+				if len(t.resolved) > sloAlertsKept {
+					t.resolved = t.resolved[len(t.resolved)-sloAlertsKept:]
+				}
+			}
+		}
+
+		total, bad := s.counts(s.slo.Window, now)
+		status := SLOStatus{
+			SLO:             s.slo,
+			Requests:        total,
+			Bad:             bad,
+			Compliance:      100,
+			BudgetRemaining: 1,
+			BurnRates:       make(map[string]float64),
+			Alerts:          []SLOAlert{},
+		}
+		if total > 0 {
+			status.Compliance = 100 * float64(total-bad) / float64(total)
+			status.BudgetRemaining = 1 - s.burnRate(s.slo.Window, now)
+		}
+		for _, window := range burnWindows {
+			if window <= s.slo.Window {
+				status.BurnRates[window.String()] = s.burnRate(window, now)
+			}
+		}
+		for _, alert := range s.firing {
+			status.Alerts = append(status.Alerts, *alert)
+		}
+		sort.Slice(status.Alerts, func(i, j int) bool {
+			return status.Alerts[i].Since.Before(status.Alerts[j].Since)
+		})
+		statuses = append(statuses, status)
+	}
+
+	return statuses, fired
+}
+
+// ResolvedAlerts returns the most recently resolved alerts, newest first
+func (t *SLOTracker) ResolvedAlerts() []SLOAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alerts := make([]SLOAlert, len(t.resolved))
+	for i, alert := range t.resolved {
+		alerts[len(alerts)-1-i] = alert
+	}
+	return alerts
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// VoterStore is the storage of voter records and their vote history.  The
+// API uses it for every plain read and write of a voter, so the in-memory
+// VoterList can be swapped for another backend (Redis, SQL, files)
+// without touching the handlers.  AddVoter takes the id the client chose,
+// CreateVoter assigns the next free one so any number of writers can add
+// voters without agreeing on ids first.  No two voters may share an email
+// address, every store keeps an index of them, and AddVoterPoll adds a
+// vote in one step that refuses a second vote in a poll the voter already
+// voted in.  Reports, checksums, archiving and the other features that
+// need the whole roll at hand still work on the VoterList.
+type VoterStore interface {
+	AddVoter(voter Voter) error
+	CreateVoter(voter Voter) (int, error)
+	GetVoter(id int) (Voter, error)
+	GetVoterByEmail(email string) (Voter, error)
+	GetAllVoters() ([]Voter, error)
+	UpdateVoter(voter Voter) error
+	DeleteVoter(id int) error
+	DeleteAll() error
+
+	GetVoterPolls(voterID int) ([]VoterHistory, error)
+	GetVoterPoll(voterID, pollID int) (VoterHistory, error)
+	AddVoterPoll(voterID, pollID int, vote VoterHistory) (VoterHistory, error)
+	UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error
+	DeleteVoterPoll(voterID, pollID int) error
+}
+
+// ContextStore is a VoterStore whose calls can be bound to a context, so a
+// backend that does I/O stops working on a request that was abandoned
+type ContextStore interface {
+	VoterStore
+	WithContext(ctx context.Context) VoterStore
+}
+
+// HoldStore is a VoterStore that can place or lift a legal hold, which
+// its UpdateVoter keeps as it was
+type HoldStore interface {
+	VoterStore
+	SetLegalHold(id int, hold bool, reason string) error
+}
+
+// PersistStore is a VoterStore that keeps a copy of the in-memory list.
+// The features that change the list directly, the bulk changes and the
+// ones that need the whole roll at hand, persist the voters they changed
+// through it afterwards.
+type PersistStore interface {
+	VoterStore
+	Persist(ids ...int) error
+}
+
+// VoteIdStore is a VoterStore that keeps the marks of a VoteIdSequence,
+// the highest VoteId handed out to each voter and, under voter id 0,
+// across all voters.  SetVoteIdMark only ever raises a mark and the marks
+// are kept when votes and voters are deleted, so ids are not handed out
+// again after a restart.
+type VoteIdStore interface {
+	VoterStore
+	VoteIdMarks() (map[int]int, error)
+	SetVoteIdMark(voterID, voteID int) error
+}
+
+// VoterList is the in-memory VoterStore, see Mirror for using it as a copy
+// of another backend
+var _ VoterStore = (*VoterList)(nil)
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SurveyAnswer is the answer to one survey question
+type SurveyAnswer struct {
+	QuestionId int
+	Answer     string
+}
+
+// QuestionResult is the aggregated result of one survey question.  Choice
+// questions are counted per choice, free text answers are listed as given.
+type QuestionResult struct {
+	QuestionId int
+	Text       string
+	Responses  int
+	Counts     map[string]int
+	Answers    []string
+}
+
+// SurveyStore holds survey responses.  Responses are stored without any
+// reference to the voter, the only thing kept about the voter is that they
+// have responded to a poll's survey, and that is kept apart from the answers.
+type SurveyStore struct {
+	mu          sync.Mutex
+	responses   map[int][][]SurveyAnswer //PollId -> responses
+	respondents map[int]map[int]bool     //PollId -> VoterIds that responded
+}
+
+// constructor for SurveyStore struct
+func NewSurveyStore() *SurveyStore {
+	return &SurveyStore{
+		responses:   make(map[int][][]SurveyAnswer),
+		respondents: make(map[int]map[int]bool),
+	}
+}
+
+// CheckAnswers checks a survey response against a poll without recording
+// it, every answer must match a question of the poll
+func CheckAnswers(poll Poll, answers []SurveyAnswer) error {
+	if len(answers) == 0 {
+		return InvalidInput("response has no answers")
+	}
+
+	questions := make(map[int]SurveyQuestion)
+	for _, question := range poll.Questions {
+		questions[question.QuestionId] = question
+	}
+	for _, answer := range answers {
+		question, ok := questions[answer.QuestionId]
+		if !ok {
+			return InvalidInput(fmt.Sprintf("poll has no survey question %d", answer.QuestionId))
+		}
+		if len(question.Choices) > 0 && !contains(question.Choices, answer.Answer) {
+			return InvalidInput(fmt.Sprintf("%q is not a choice of question %d", answer.Answer, answer.QuestionId))
+		}
+	}
+	return nil
+}
+
+// AddResponse records a voter's answers to a poll's survey.  Each voter can
+// respond once per poll and every answer must match a question of the poll.
+func (s *SurveyStore) AddResponse(poll Poll, voterID int, answers []SurveyAnswer) error {
+	if err := CheckAnswers(poll, answers); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.respondents[poll.PollId] == nil {
+		s.respondents[poll.PollId] = make(map[int]bool)
+	}
+	if s.respondents[poll.PollId][voterID] {
+		return AlreadyExists("voter has already responded to this survey")
+	}
+
+	s.respondents[poll.PollId][voterID] = true
+	s.responses[poll.PollId] = append(s.responses[poll.PollId], answers)
+
+	return nil
+}
+
+// GetResults aggregates the responses to a poll's survey
+func (s *SurveyStore) GetResults(poll Poll) []QuestionResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]QuestionResult, 0, len(poll.Questions))
+	index := make(map[int]int)
+	for i, question := range poll.Questions {
+		results = append(results, QuestionResult{
+			QuestionId: question.QuestionId,
+			Text:       question.Text,
+			Counts:     make(map[string]int),
+			Answers:    make([]string, 0),
+		})
+		index[question.QuestionId] = i
+	}
+
+	for _, response := range s.responses[poll.PollId] {
+		for _, answer := range response {
+			i, ok := index[answer.QuestionId]
+			if !ok {
+				//The question was removed from the poll after the response
+				continue
+			}
+			results[i].Responses++
+			if len(poll.Questions[i].Choices) > 0 {
+				results[i].Counts[answer.Answer]++
+			} else {
+				results[i].Answers = append(results[i].Answers, answer.Answer)
+			}
+		}
+	}
+
+	return results
+}
+
+// RespondedPolls returns the ids of the polls whose survey the voter has
+// responded to.  The answers themselves can not be traced back to the voter.
+func (s *SurveyStore) RespondedPolls(voterID int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	polls := make([]int, 0)
+	for pollID, respondents := range s.respondents {
+		if respondents[voterID] {
+			polls = append(polls, pollID)
+		}
+	}
+	sort.Ints(polls)
+
+	return polls
+}
+
+// contains reports whether a string is in a slice
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
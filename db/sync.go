@@ -0,0 +1,125 @@
+package db
+
+import "time"
+
+// HistoryConflict is a client history entry that disagrees with the
+// stored entry for the same poll.  The stored entry always wins, the
+// conflict is reported so the client can tell its user.
+type HistoryConflict struct {
+	PollId int
+	Client VoterHistory
+	Server VoterHistory
+	Reason string
+}
+
+// HistorySync is the outcome of reconciling a client's history with the
+// stored one.  History is the authoritative merged history the client
+// should replace its local copy with.
+type HistorySync struct {
+	History   []VoterHistory
+	Added     []int //Polls taken from the client
+	Unchanged []int //Polls the client already had right
+	Conflicts []HistoryConflict
+}
+
+// SyncVoterPolls reconciles the full history an offline client knows for
+// a voter with the stored history, see SyncHistory
+func (t *VoterList) SyncVoterPolls(voterID int, known []VoterHistory) (HistorySync, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
+	if err != nil {
+		return HistorySync{}, err
+	}
+
+	voter, result, err := SyncHistory(voter, known, t.voteIds)
+	if err != nil {
+		return HistorySync{}, err
+	}
+	if len(result.Added) > 0 {
+		if err := t.updateVoter(voter); err != nil {
+			return HistorySync{}, err
+		}
+		//Reread so added choices come back sealed when encryption is on
+		if voter, err = t.getVoter(voterID); err != nil {
+			return HistorySync{}, err
+		}
+	}
+
+	result.History = voter.VoteHistory
+	if result.History == nil {
+		result.History = []VoterHistory{}
+	}
+
+	return result, nil
+}
+
+// SyncHistory reconciles the full history an offline client knows for a
+// voter with the voter's stored history and returns the voter with the
+// merged history, which the caller stores when polls were added.  Entries
+// for polls the voter does not have yet are added with a new VoteId,
+// entries that match the stored ones are left alone and entries that
+// disagree are reported as conflicts.  Stored entries the client is
+// missing are kept, so the merge never loses history.  The entries come
+// stamped with the server time, the dates compared are the ClientVoteDate
+// the client recorded them with.  The History of the result is left for
+// the caller to fill in from the stored voter.
+func SyncHistory(voter Voter, known []VoterHistory, voteIds *VoteIdSequence) (Voter, HistorySync, error) {
+	result := HistorySync{
+		Added:     []int{},
+		Unchanged: []int{},
+		Conflicts: []HistoryConflict{},
+	}
+
+	voter.VoteHistory = append([]VoterHistory(nil), voter.VoteHistory...)
+	for _, entry := range known {
+		if entry.PollId == 0 {
+			return Voter{}, HistorySync{}, InvalidInput("every history entry needs a PollId")
+		}
+
+		index := -1
+		for i, history := range voter.VoteHistory {
+			if history.PollId == entry.PollId {
+				index = i
+				break
+			}
+		}
+
+		if index == -1 {
+			entry.VoteId = voteIds.Next(voter.VoterId, voter.VoteHistory)
+			entry.EncryptedChoice = nil
+			voter.VoteHistory = append(voter.VoteHistory, entry)
+			result.Added = append(result.Added, entry.PollId)
+			continue
+		}
+
+		stored := voter.VoteHistory[index]
+		switch {
+		case !entry.ClientVoteDate.IsZero() && !entry.ClientVoteDate.Equal(recordedAt(stored)):
+			result.Conflicts = append(result.Conflicts, HistoryConflict{
+				PollId: entry.PollId, Client: entry, Server: stored,
+				Reason: "vote date differs",
+			})
+		//A sealed choice can not be compared, only plain ones can clash
+		case entry.Choice != "" && stored.Choice != "" && entry.Choice != stored.Choice:
+			result.Conflicts = append(result.Conflicts, HistoryConflict{
+				PollId: entry.PollId, Client: entry, Server: stored,
+				Reason: "choice differs",
+			})
+		default:
+			result.Unchanged = append(result.Unchanged, entry.PollId)
+		}
+	}
+
+	return voter, result, nil
+}
+
+// recordedAt is the time the client recorded a stored entry, entries
+// written without a client time, by an import say, only have the VoteDate
+func recordedAt(entry VoterHistory) time.Time {
+	if entry.ClientVoteDate.IsZero() {
+		return entry.VoteDate
+	}
+	return entry.ClientVoteDate
+}
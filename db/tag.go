@@ -0,0 +1,49 @@
+package db
+
+// TagResult is the outcome of a bulk tag operation
+type TagResult struct {
+	Matched int   //Voters the operation was applied to
+	Changed int   //Voters that actually gained or lost the tag
+	Missing []int //Requested ids that do not exist
+}
+
+// TagVoters adds a tag to, or removes it from, every voter in the id list
+func (t *VoterList) TagVoters(ids []int, tag string, add bool) (TagResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tag == "" {
+		return TagResult{}, InvalidInput("tag is required")
+	}
+
+	result := TagResult{Missing: make([]int, 0)}
+	for _, id := range ids {
+		voter, err := t.getVoter(id)
+		if err != nil {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+		result.Matched++
+
+		has := hasTag(voter, tag)
+		if has == add {
+			continue
+		}
+
+		if add {
+			voter.Tags = append(append([]string(nil), voter.Tags...), tag)
+		} else {
+			tags := make([]string, 0, len(voter.Tags))
+			for _, t := range voter.Tags {
+				if t != tag {
+					tags = append(tags, t)
+				}
+			}
+			voter.Tags = tags
+		}
+		t.putVoter(voter)
+		result.Changed++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"sort"
+	"sync"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Channels a template can have a variant for
+const (
+	TemplateEmail = "email"
+	TemplateSMS   = "sms"
+)
+
+// maxSMSLength is the longest SMS a template may render, one message
+// segment
+const maxSMSLength = 160
+
+// templateName is the allowed form of a template name, it is used in URLs
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// templateFuncs are the functions templates can call besides the built in
+// ones.  date formats a time, by default as e.g. November 3, 2026.
+var templateFuncs = map[string]any{
+	"date": func(t time.Time, layout ...string) string {
+		if len(layout) > 0 {
+			return t.Format(layout[0])
+		}
+		return t.Format("January 2, 2006")
+	},
+}
+
+// TemplateVariant is the content of a template for one channel, written
+// in Go template syntax, e.g. "Hello {{.Voter.Name}}".  Email bodies are
+// HTML and have values escaped, SMS has no subject.
+type TemplateVariant struct {
+	Subject string
+	Body    string
+}
+
+// Template is one version of a notification template.  Saving a template
+// again stores a new version, campaigns keep using the version they were
+// created with.
+type Template struct {
+	Name     string
+	Version  int
+	Variants map[string]TemplateVariant //Keyed by channel
+	Created  time.Time                  //When this version was saved
+}
+
+// TemplateData are the values a template is rendered with
+type TemplateData struct {
+	Voter        Voter
+	PollingPlace PollingPlace //The voter's polling place, if one is assigned
+	Now          time.Time
+}
+
+// RenderedMessage is a template rendered for one voter on one channel
+type RenderedMessage struct {
+	Channel string
+	Subject string
+	Body    string
+}
+
+// Render fills in a variant of the template
+func (t Template) Render(channel string, data TemplateData) (RenderedMessage, error) {
+	variant, ok := t.Variants[channel]
+	if !ok {
+		return RenderedMessage{}, InvalidInput(fmt.Sprintf("template %s has no %s variant", t.Name, channel))
+	}
+
+	subject, err := renderText(variant.Subject, data)
+	if err != nil {
+		return RenderedMessage{}, fmt.Errorf("subject: %w", err)
+	}
+
+	var body string
+	if channel == TemplateEmail {
+		body, err = renderHTML(variant.Body, data)
+	} else {
+		body, err = renderText(variant.Body, data)
+	}
+	if err != nil {
+		return RenderedMessage{}, fmt.Errorf("body: %w", err)
+	}
+
+	if channel == TemplateSMS && utf8.RuneCountInString(body) > maxSMSLength {
+		return RenderedMessage{}, InvalidInput(fmt.Sprintf("sms is %d characters, at most %d fit in one message",
+			utf8.RuneCountInString(body), maxSMSLength))
+	}
+
+	return RenderedMessage{Channel: channel, Subject: subject, Body: body}, nil
+}
+
+// renderText renders a plain text template
+func renderText(source string, data TemplateData) (string, error) {
+	tmpl, err := texttemplate.New("").Funcs(templateFuncs).Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderHTML renders an HTML template, values are escaped for their
+// context
+func renderHTML(source string, data TemplateData) (string, error) {
+	tmpl, err := htmltemplate.New("").Funcs(templateFuncs).Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// validateTemplate checks a template before it is saved.  Every variant is
+// rendered once with empty values, so syntax errors and unknown variables
+// are found now rather than when a campaign is sent.
+func validateTemplate(t Template) error {
+	if !templateName.MatchString(t.Name) {
+		return InvalidInput("template name must be lower case letters, digits, - or _")
+	}
+	if len(t.Variants) == 0 {
+		return InvalidInput("template needs at least one channel variant")
+	}
+
+	for channel, variant := range t.Variants {
+		switch channel {
+		case TemplateEmail:
+			if variant.Subject == "" || variant.Body == "" {
+				return InvalidInput("email variant needs a subject and a body")
+			}
+		case TemplateSMS:
+			if variant.Subject != "" {
+				return InvalidInput("sms variant has no subject")
+			}
+			if variant.Body == "" {
+				return InvalidInput("sms variant needs a body")
+			}
+		default:
+			return InvalidInput(fmt.Sprintf("unknown channel %s, use %s or %s", channel, TemplateEmail, TemplateSMS))
+		}
+
+		if _, err := t.Render(channel, TemplateData{}); err != nil {
+			return InvalidInput(fmt.Sprintf("%s variant: %v", channel, err))
+		}
+	}
+
+	return nil
+}
+
+// TemplateStore holds every version of the notification templates
+type TemplateStore struct {
+	mu       sync.Mutex
+	versions map[string][]Template //Oldest version first
+}
+
+// constructor for TemplateStore struct
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{
+		versions: make(map[string][]Template),
+	}
+}
+
+// AddTemplate saves the first version of a new template
+func (s *TemplateStore) AddTemplate(t Template) (Template, error) {
+	if err := validateTemplate(t); err != nil {
+		return Template{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.versions[t.Name]; ok {
+		return Template{}, AlreadyExists("template already exists")
+	}
+
+	t.Version = 1
+	t.Created = time.Now()
+	s.versions[t.Name] = []Template{t}
+
+	return t, nil
+}
+
+// UpdateTemplate saves a new version of an existing template, the earlier
+// versions are kept
+func (s *TemplateStore) UpdateTemplate(t Template) (Template, error) {
+	if err := validateTemplate(t); err != nil {
+		return Template{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, ok := s.versions[t.Name]
+	if !ok {
+		return Template{}, NotFound("template does not exist")
+	}
+
+	t.Version = versions[len(versions)-1].Version + 1
+	t.Created = time.Now()
+	s.versions[t.Name] = append(versions, t)
+
+	return t, nil
+}
+
+// GetTemplate returns a version of a template, the latest one for
+// version 0
+func (s *TemplateStore) GetTemplate(name string, version int) (Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, ok := s.versions[name]
+	if !ok {
+		return Template{}, NotFound("template does not exist")
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	if version < 0 || version > len(versions) {
+		return Template{}, NotFound("template version does not exist")
+	}
+
+	return versions[version-1], nil
+}
+
+// GetTemplateVersions returns every version of a template, oldest first
+func (s *TemplateStore) GetTemplateVersions(name string) ([]Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, ok := s.versions[name]
+	if !ok {
+		return nil, NotFound("template does not exist")
+	}
+
+	return append([]Template(nil), versions...), nil
+}
+
+// GetAllTemplates returns the latest version of every template ordered by
+// name
+func (s *TemplateStore) GetAllTemplates() []Template {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	templates := make([]Template, 0, len(s.versions))
+	for _, versions := range s.versions {
+		templates = append(templates, versions[len(versions)-1])
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates
+}
+
+// DeleteTemplate removes a template with all of its versions.  Campaigns
+// already created from it keep the messages rendered for them.
+func (s *TemplateStore) DeleteTemplate(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.versions[name]; !ok {
+		return NotFound("template does not exist")
+	}
+
+	delete(s.versions, name)
+	return nil
+}
@@ -0,0 +1,61 @@
+package db
+
+// Channels a vote can be cast through
+const (
+	ChannelInPerson = "in-person"
+	ChannelMail     = "mail"
+	ChannelOnline   = "online"
+)
+
+// channelUnknown is the turnout bucket of votes recorded without a channel
+const channelUnknown = "unknown"
+
+// ValidChannel reports whether a history channel is known, an empty
+// channel is allowed because the field is optional
+func ValidChannel(channel string) error {
+	switch channel {
+	case "", ChannelInPerson, ChannelMail, ChannelOnline:
+		return nil
+	}
+	return InvalidInput("unknown channel " + channel + ", use in-person, mail or online")
+}
+
+// Turnout is the number of votes cast in a poll broken down by channel and
+// by precinct.  Votes recorded without a channel are counted as "unknown",
+// votes without a precinct under precinct 0.
+type Turnout struct {
+	PollId     int
+	Total      int
+	ByChannel  map[string]int
+	ByPrecinct map[int]int
+}
+
+// GetTurnout counts the votes cast in a poll
+func (t *VoterList) GetTurnout(pollID int) Turnout {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	turnout := Turnout{
+		PollId:     pollID,
+		ByChannel:  make(map[string]int),
+		ByPrecinct: make(map[int]int),
+	}
+
+	for _, voter := range t.Voters {
+		for _, history := range voter.VoteHistory {
+			if history.PollId != pollID {
+				continue
+			}
+
+			channel := history.Channel
+			if channel == "" {
+				channel = channelUnknown
+			}
+			turnout.Total++
+			turnout.ByChannel[channel]++
+			turnout.ByPrecinct[history.PrecinctId]++
+		}
+	}
+
+	return turnout
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// UIAction is one operation the admin UI made on behalf of an operator,
+// along with what the operator was looking at when they made it.  It is a
+// structured record, not a screen capture.  RequestId links it to any
+// audit log entries the operation wrote.
+type UIAction struct {
+	Id        int
+	Time      time.Time
+	RequestId string
+	Principal string
+	Session   string         //UI session, one per browser tab
+	Screen    string         //e.g. "voters/list"
+	Context   map[string]any //Filters, selection and other UI state
+	Method    string
+	URL       string
+	Status    int
+}
+
+// UIActionLog is an append only log of admin UI actions
+type UIActionLog struct {
+	mu      sync.Mutex
+	actions []UIAction
+	nextId  int
+}
+
+// constructor for UIActionLog struct
+func NewUIActionLog() *UIActionLog {
+	return &UIActionLog{nextId: 1}
+}
+
+// Record appends an action to the log
+func (l *UIActionLog) Record(action UIAction) UIAction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	action.Id = l.nextId
+	l.nextId++
+	l.actions = append(l.actions, action)
+
+	return action
+}
+
+// GetActions returns the actions of a UI session or a principal, oldest
+// first.  Empty arguments match everything.
+func (l *UIActionLog) GetActions(session, principal string) []UIAction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	actions := make([]UIAction, 0)
+	for _, action := range l.actions {
+		if session != "" && action.Session != session {
+			continue
+		}
+		if principal != "" && action.Principal != principal {
+			continue
+		}
+		actions = append(actions, action)
+	}
+
+	return actions
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// SessionTTL is how long an admin session lasts after a WebAuthn login
+const SessionTTL = 12 * time.Hour
+
+// AdminCredential is a hardware security key or passkey registered to an
+// admin user.  Credential holds what the authenticator gave us at
+// registration, the public key and the signature counter, never a secret.
+type AdminCredential struct {
+	Id         string //Base64url of the WebAuthn credential id
+	Label      string
+	Registered time.Time
+	LastUsed   time.Time
+	Credential webauthn.Credential
+}
+
+// AdminUser is an account for the embedded admin UI.  Admin users have no
+// password, they log in with one of their WebAuthn credentials.  Handle is
+// the random WebAuthn user handle, it is not derived from the name so it
+// gives nothing away to the authenticator.
+type AdminUser struct {
+	UserId      int
+	Name        string
+	DisplayName string
+	Role        string
+	Handle      []byte
+	Created     time.Time
+	Credentials []AdminCredential
+}
+
+// adminSession is an outstanding login session
+type adminSession struct {
+	userId  int
+	expires time.Time
+}
+
+// UserStore holds the admin users, their credentials and their sessions.
+// Like the device store it only keeps hashes of the session tokens.
+type UserStore struct {
+	mu       sync.Mutex
+	users    map[int]AdminUser
+	sessions map[string]adminSession //session token hash -> session
+	nextId   int
+}
+
+// constructor for UserStore struct
+func NewUserStore() *UserStore {
+	return &UserStore{
+		users:    make(map[int]AdminUser),
+		sessions: make(map[string]adminSession),
+		nextId:   1,
+	}
+}
+
+// CredentialId returns the id a WebAuthn credential is listed under
+func CredentialId(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// AddUser creates an admin user with no credentials
+func (s *UserStore) AddUser(user AdminUser) (AdminUser, error) {
+	if user.Name == "" || user.Role == "" {
+		return AdminUser{}, InvalidInput("admin user needs a name and a role")
+	}
+
+	handle := make([]byte, 32)
+	if _, err := rand.Read(handle); err != nil {
+		return AdminUser{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Name == user.Name {
+			return AdminUser{}, AlreadyExists("admin user already exists")
+		}
+	}
+
+	user.UserId = s.nextId
+	user.Handle = handle
+	user.Created = time.Now()
+	user.Credentials = []AdminCredential{}
+	if user.DisplayName == "" {
+		user.DisplayName = user.Name
+	}
+
+	s.nextId++
+	s.users[user.UserId] = user
+
+	return user, nil
+}
+
+// GetUser returns an admin user by id
+func (s *UserStore) GetUser(id int) (AdminUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return AdminUser{}, NotFound("admin user does not exist")
+	}
+
+	return user, nil
+}
+
+// GetUserByName returns an admin user by login name
+func (s *UserStore) GetUserByName(name string) (AdminUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Name == name {
+			return user, nil
+		}
+	}
+
+	return AdminUser{}, NotFound("admin user does not exist")
+}
+
+// GetAllUsers returns every admin user ordered by id
+func (s *UserStore) GetAllUsers() []AdminUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]AdminUser, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserId < users[j].UserId
+	})
+
+	return users
+}
+
+// AddCredential registers a new credential to a user.  A credential id can
+// only belong to one user.
+func (s *UserStore) AddCredential(userId int, label string, cred webauthn.Credential) (AdminCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return AdminCredential{}, NotFound("admin user does not exist")
+	}
+	for _, u := range s.users {
+		for _, existing := range u.Credentials {
+			if bytes.Equal(existing.Credential.ID, cred.ID) {
+				return AdminCredential{}, AlreadyExists("credential is already registered")
+			}
+		}
+	}
+
+	ac := AdminCredential{
+		Id:         CredentialId(cred.ID),
+		Label:      label,
+		Registered: time.Now(),
+		Credential: cred,
+	}
+	user.Credentials = append(user.Credentials, ac)
+	s.users[userId] = user
+
+	return ac, nil
+}
+
+// UseCredential stores the updated signature counter of a credential after
+// a login and records when it was used
+func (s *UserStore) UseCredential(userId int, cred webauthn.Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return NotFound("admin user does not exist")
+	}
+
+	for i, existing := range user.Credentials {
+		if bytes.Equal(existing.Credential.ID, cred.ID) {
+			user.Credentials[i].Credential.Authenticator = cred.Authenticator
+			user.Credentials[i].LastUsed = time.Now()
+			return nil
+		}
+	}
+
+	return NotFound("credential does not exist")
+}
+
+// RemoveCredential deletes a credential from a user.  Sessions that were
+// opened with it stay valid until they expire or the user is logged out.
+func (s *UserStore) RemoveCredential(userId int, credId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return NotFound("admin user does not exist")
+	}
+
+	for i, existing := range user.Credentials {
+		if existing.Id == credId {
+			user.Credentials = append(user.Credentials[:i:i], user.Credentials[i+1:]...)
+			s.users[userId] = user
+			return nil
+		}
+	}
+
+	return NotFound("credential does not exist")
+}
+
+// StartSession opens a session for a user and returns its token
+func (s *UserStore) StartSession(userId int) (string, time.Time, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(SessionTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, session := range s.sessions {
+		if time.Now().After(session.expires) {
+			delete(s.sessions, hash)
+		}
+	}
+	s.sessions[hashToken(token)] = adminSession{userId: userId, expires: expires}
+
+	return token, expires, nil
+}
+
+// Authenticate returns the user a session token belongs to
+func (s *UserStore) Authenticate(token string) (AdminUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[hashToken(token)]
+	if !ok || time.Now().After(session.expires) {
+		return AdminUser{}, errors.New("unknown or expired session")
+	}
+
+	user, ok := s.users[session.userId]
+	if !ok {
+		return AdminUser{}, NotFound("admin user does not exist")
+	}
+
+	return user, nil
+}
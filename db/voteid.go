@@ -0,0 +1,217 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// VoteIdScope says what a VoteId is unique within
+type VoteIdScope string
+
+const (
+	VoteIdsPerVoter VoteIdScope = "voter"  //Each voter's votes are numbered 1, 2, 3...
+	VoteIdsGlobal   VoteIdScope = "global" //One sequence across all voters, a VoteId names a single vote
+)
+
+// ParseVoteIdScope reads a VoteId scope as given on the command line
+func ParseVoteIdScope(scope string) (VoteIdScope, error) {
+	switch VoteIdScope(scope) {
+	case VoteIdsPerVoter, VoteIdsGlobal:
+		return VoteIdScope(scope), nil
+	}
+	return "", InvalidInput(fmt.Sprintf("unknown VoteId scope %q, use %q or %q", scope, VoteIdsPerVoter, VoteIdsGlobal))
+}
+
+// VoteIdSequence hands out VoteIds.  Ids only ever go up, so an id is not
+// handed out twice, not even after the vote that held it was deleted, and
+// a vote keeps its id for good.  The sequence also counts on from every id
+// it is shown, so ids that came from a snapshot, an import or another
+// backend are not handed out again either.  A sequence loaded from a
+// VoteIdStore saves its marks there and carries on from them after a
+// restart.
+type VoteIdSequence struct {
+	mu      sync.Mutex
+	scope   VoteIdScope
+	last    map[int]int //Highest id per voter
+	global  int         //Highest id of any voter
+	store   VoteIdStore //Where the marks are saved, nil to keep them in memory only
+	unsaved []voteIdMark
+}
+
+// voteIdMark is a mark that moved and has yet to be saved, VoterId is 0
+// for the mark of the global scope
+type voteIdMark struct {
+	VoterId int
+	VoteId  int
+}
+
+// constructor for VoteIdSequence struct
+func NewVoteIdSequence(scope VoteIdScope) *VoteIdSequence {
+	return &VoteIdSequence{
+		scope: scope,
+		last:  make(map[int]int),
+	}
+}
+
+// SetScope changes what VoteIds are unique within.  It is meant for
+// start up, ids handed out before keep their value.
+func (s *VoteIdSequence) SetScope(scope VoteIdScope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scope = scope
+}
+
+// Scope returns what VoteIds are unique within
+func (s *VoteIdSequence) Scope() VoteIdScope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scope
+}
+
+// Load counts on from the marks kept in a store and saves the marks to it
+// from then on.  The marks outlive the votes they were handed out to, so
+// the id of a vote deleted before a restart is not handed out again.
+func (s *VoteIdSequence) Load(store VoteIdStore) error {
+	marks, err := store.VoteIdMarks()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for voterID, id := range marks {
+		s.global = max(s.global, id)
+		if voterID != 0 {
+			s.last[voterID] = max(s.last[voterID], id)
+		}
+	}
+	s.store = store
+	return nil
+}
+
+// Observe records the VoteIds of a voter's history so the sequence counts
+// on from them
+func (s *VoteIdSequence) Observe(voterID int, history []VoterHistory) {
+	s.mu.Lock()
+	s.observe(voterID, history)
+	store, marks := s.takeUnsaved()
+	s.mu.Unlock()
+
+	save(store, marks)
+}
+
+// Next returns the VoteId for a new vote of a voter, history is the
+// voter's history as it is now
+func (s *VoteIdSequence) Next(voterID int, history []VoterHistory) int {
+	s.mu.Lock()
+	id := s.next(voterID, history)
+	store, marks := s.takeUnsaved()
+	s.mu.Unlock()
+
+	save(store, marks)
+	return id
+}
+
+// Assign gives every entry of a voter's history without a VoteId the next
+// one, entries that have one keep it
+func (s *VoteIdSequence) Assign(voter *Voter) {
+	s.mu.Lock()
+	s.observe(voter.VoterId, voter.VoteHistory)
+	for i := range voter.VoteHistory {
+		if voter.VoteHistory[i].VoteId == 0 {
+			voter.VoteHistory[i].VoteId = s.next(voter.VoterId, voter.VoteHistory)
+		}
+	}
+	store, marks := s.takeUnsaved()
+	s.mu.Unlock()
+
+	save(store, marks)
+}
+
+// takeUnsaved returns the store and the marks that moved since the last
+// call, for a caller that holds mu.  The marks are saved after mu is
+// released so the store does not hold up other callers of the sequence.
+func (s *VoteIdSequence) takeUnsaved() (VoteIdStore, []voteIdMark) {
+	marks := s.unsaved
+	s.unsaved = nil
+	return s.store, marks
+}
+
+// save writes marks to a store.  A store only ever raises a mark, so
+// marks saved out of order still leave the highest one.  A mark that
+// could not be saved is logged, the next id handed out saves a higher one.
+func save(store VoteIdStore, marks []voteIdMark) {
+	if store == nil {
+		return
+	}
+	for _, mark := range marks {
+		if err := store.SetVoteIdMark(mark.VoterId, mark.VoteId); err != nil {
+			log.Printf("Error saving the VoteId mark of voter %d: %v", mark.VoterId, err)
+		}
+	}
+}
+
+// observe is Observe for a caller that holds mu.  A voter without an id is
+// one that is about to be created, nothing has been handed out for it yet
+// and its ids are recorded once it is stored under its id.
+func (s *VoteIdSequence) observe(voterID int, history []VoterHistory) {
+	for _, entry := range history {
+		s.raise(voterID, entry.VoteId)
+	}
+}
+
+// raise moves the marks of a voter and of all voters up to id.  Both are
+// kept whatever the scope, so the scope can be set after the roll was
+// loaded, but only the mark of the scope in use is saved.
+func (s *VoteIdSequence) raise(voterID, id int) {
+	if id > s.global {
+		s.global = id
+		if s.scope == VoteIdsGlobal {
+			s.unsaved = append(s.unsaved, voteIdMark{VoteId: id})
+		}
+	}
+	if voterID != 0 && id > s.last[voterID] {
+		s.last[voterID] = id
+		if s.scope == VoteIdsPerVoter {
+			s.unsaved = append(s.unsaved, voteIdMark{VoterId: voterID, VoteId: id})
+		}
+	}
+}
+
+// next is Next for a caller that holds mu
+func (s *VoteIdSequence) next(voterID int, history []VoterHistory) int {
+	s.observe(voterID, history)
+
+	id := s.last[voterID] + 1
+	if s.scope == VoteIdsGlobal {
+		id = s.global + 1
+	}
+	for _, entry := range history {
+		id = max(id, entry.VoteId+1)
+	}
+	s.raise(voterID, id)
+	return id
+}
+
+// NextVoteId returns the VoteId after the highest one of a history, for
+// the backends that keep no sequence of their own.  Unlike a sequence it
+// hands the id of the latest vote out again once that vote was deleted,
+// the API numbers the votes it records itself so this only concerns
+// direct callers of the backend.
+func NextVoteId(history []VoterHistory) int {
+	id := 1
+	for _, entry := range history {
+		id = max(id, entry.VoteId+1)
+	}
+	return id
+}
+
+// SetVoteIdSequence makes the list take new VoteIds from a sequence it
+// shares with the API, by default it has one of its own numbering the
+// votes of each voter
+func (t *VoterList) SetVoteIdSequence(sequence *VoteIdSequence) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.voteIds = sequence
+}
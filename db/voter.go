@@ -1,29 +1,79 @@
 package db
 
 import (
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
 // VoterHistory is the struct that represents a single VoterHistory item
 type VoterHistory struct{
-	PollId int
-	VoteId int
-	VoteDate time.Time
+	PollId int `validate:"min=0"`
+	VoteId int `validate:"min=0"`
+	VoteDate time.Time //Server time the vote was recorded, authoritative
+	ClientVoteDate time.Time //Time the client said it recorded the vote, zero if it sent none
+	Choice string `validate:"max=200"` //Ballot choice, only kept in plain text when encryption is off
+	EncryptedChoice []byte //Ballot choice sealed with the election public key
+	Channel string `validate:"max=32"` //How the vote was cast, see the Channel constants, optional
+	DeviceId int `validate:"min=0"` //Registered device that recorded the vote, optional
+	PrecinctId int `validate:"min=0"` //Precinct the vote was cast in, optional
+	ExtensionId int `validate:"min=0"` //Poll extension the vote was recorded under, 0 in regular hours
 }
 
-// Voter is the struct that represents a single Voter item
+// Voter is the struct that represents a single Voter item.  The validate
+// tags are the rules a voter sent to the API has to meet, see the validate
+// package.
 type Voter struct{
-	VoterId int
-	Name string
-	Email string
+	VoterId int `validate:"min=0"`
+	Name string `validate:"required,max=100"`
+	Email string `validate:"omitempty,email,max=254"`
 	VoteHistory []VoterHistory
+	Address Address
+	PrecinctId int `validate:"min=0"`
+	AddressHistory []AddressChange //Prior addresses, oldest first
+	MovedDate time.Time //Effective date of the last move
+	Status string `validate:"max=32"` //Registration status, e.g. active or inactive
+	Tags []string `validate:"max=50"`
+	Preferences Preferences
+	LegalHold bool //Blocks purges, anonymization and merges until lifted
+	LegalHoldReason string `validate:"max=500"`
+	Archived bool //Set on reads served from the cold store
+	Registered time.Time //When the voter was first added, defaults to the time of the add
+	EmailInvalid bool //Set when email to the address hard bounced, cleared when the address changes
 }
 
+// VoterList is safe for concurrent use.  Every exported method takes mu,
+// the unexported helpers expect the caller to hold it.  Stored voters are
+// never changed in place, readers get a copy so they can not change them
+// either.
 type VoterList struct {
-	Voters map[int]Voter //A map of VoterIDs as keys and Voter structs as values
+	mu     sync.RWMutex
+	Voters map[int]Voter //A map of VoterIDs as keys and Voter structs as values, guarded by mu
+
+	checksum  *ChecksumTree  //Cached checksum tree, nil when it needs a rebuild
+	ballotKey *rsa.PublicKey //Election public key, nil when ballots are stored in plain text
+
+	consentLog []ConsentChange //Every consent change ever made, oldest first
+	revisions  []Revision      //Changes to voters, oldest first, see SetRevisionLimit
+	revBase    int             //Revisions dropped to stay under revLimit
+	revLimit   int             //Most revisions kept, 0 for no limit
+
+	coldStore ColdStore    //Archived voters, nil when archiving is off
+	shadow    *shadow      //Backend being migrated to, nil when shadow mode is off
+	decoys    map[int]bool //Honeypot voter ids, never exposed in responses
+	lastId    int          //Highest VoterId ever stored, CreateVoter counts on from it
+	emails    emailIndex   //Voter ids by email address, addresses are unique
+
+	provenance ProvenanceRules      //Source of truth of voter fields, see SetProvenanceRules
+	conflicts  []ProvenanceConflict //Changes held back by the provenance rules, oldest first
+	reviews    *ReviewQueue         //Where conflicts are flagged for review, nil when there is none
+	voteIds    *VoteIdSequence      //Where new VoteIds come from, see SetVoteIdSequence
+	watchers   map[chan Change]bool //Change feeds, see Watch
+	changeSeq  int64                //Seq of the last change
+	recent     []Change             //The last ChangeBacklog changes, oldest first
 }
 
 //constructor for VoterList struct
@@ -32,7 +82,9 @@ func NewVoterList() (*VoterList, error) {
 	//Now that we know the file exists, at at the minimum we have
 	//a valid empty DB, lets create the ToDo struct
 	voterList := &VoterList{
-		Voters: make(map[int]Voter),
+		Voters:   make(map[int]Voter),
+		voteIds:  NewVoteIdSequence(VoteIdsPerVoter),
+		revLimit: DefaultRevisionLimit,
 	}
 
 	// We should be all set here, the ToDo struct is ready to go
@@ -62,16 +114,75 @@ func NewVoterList() (*VoterList, error) {
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
 func (t *VoterList) AddVoter(voter Voter) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.addVoter(voter)
+}
+
+// CreateVoter adds a voter under the next free id, whatever VoterId it
+// carries, and returns the id.  Ids are never handed out twice, not even
+// the id of a voter that was deleted since.
+func (t *VoterList) CreateVoter(voter Voter) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id, err := t.nextVoterId()
+	if err != nil {
+		return 0, err
+	}
+	voter.VoterId = id
+
+	return id, t.addVoter(voter)
+}
+
+// nextVoterId returns the next id for CreateVoter, skipping ids that
+// archived voters hold.  The caller holds mu.
+func (t *VoterList) nextVoterId() (int, error) {
+	for id := t.lastId + 1; ; id++ {
+		if _, ok := t.Voters[id]; ok {
+			continue
+		}
+		if _, err := t.getArchived(id); !errors.Is(err, ErrNotFound) {
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		return id, nil
+	}
+}
+
+// addVoter adds a voter, the caller holds mu
+func (t *VoterList) addVoter(voter Voter) error {
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
-	_, ok := t.Voters[voter.VoterId]
-	if ok {
-		return errors.New("item already exists")
+	if _, err := t.getVoter(voter.VoterId); err == nil {
+		return AlreadyExists("item already exists")
+	}
+	if err := t.checkEmail(voter); err != nil {
+		return err
+	}
+
+	if err := t.sealChoices(&voter); err != nil {
+		return err
+	}
+	t.voteIds.Assign(&voter)
+
+	//Legal holds can only be placed through SetLegalHold
+	voter.LegalHold = false
+	voter.LegalHoldReason = ""
+	voter.EmailInvalid = false
+
+	//Imports may carry the original registration date
+	if voter.Registered.IsZero() {
+		voter.Registered = time.Now()
 	}
 
 	//Now that we know the item doesn't exist, lets add it to our map
-	t.Voters[voter.VoterId] = voter
+	t.logConsent(voter.VoterId, Preferences{}, voter.Preferences, "registration")
+	t.putVoter(voter)
 
 	//If everything is ok, return nil for the error
 	return nil
@@ -91,25 +202,62 @@ func (t *VoterList) AddVoter(voter Voter) error {
 //		(2) The DB file will be saved with the item removed
 //		(3) If there is an error, it will be returned
 func (t *VoterList) DeleteVoter(id int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
 	// we should if item exists before trying to delete it
 	// this is a good practice, return an error if the
 	// item does not exist
+	if _, err := t.getVoter(id); err != nil {
+		return err
+	}
+
+	//Voters under legal hold can not be deleted
+	if err := t.checkHold(id); err != nil {
+		return err
+	}
 
 	//Now lets use the built-in go delete() function to remove
 	//the item from our map
-	delete(t.Voters, id)
-
-	return nil
+	return t.removeVoter(id)
 }
 
 // DeleteAll removes all items from the DB.
 // It will be exposed via a DELETE /todo endpoint
 func (t *VoterList) DeleteAll() error {
-	//To delete everything, we can just create a new map
-	//and assign it to our existing map.  The garbage collector
-	//will clean up the old map for us
-	t.Voters = make(map[int]Voter)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]int, 0, len(t.Voters))
+	for id := range t.Voters {
+		ids = append(ids, id)
+	}
+	if t.coldStore != nil {
+		archived, err := t.coldStore.IDs()
+		if err != nil {
+			return err
+		}
+		for _, id := range archived {
+			if _, hot := t.Voters[id]; !hot {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	//Nothing is deleted if any voter is under legal hold
+	for _, id := range ids {
+		if err := t.checkHold(id); err != nil {
+			return err
+		}
+	}
+
+	//Every voter is removed one by one so that the deletions end up
+	//in the revision history
+	for _, id := range ids {
+		if err := t.removeVoter(id); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -128,17 +276,45 @@ func (t *VoterList) DeleteAll() error {
 //		(2) The DB file will be saved with the item updated
 //		(3) If there is an error, it will be returned
 func (t *VoterList) UpdateVoter(voter Voter) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.updateVoter(voter)
+}
+
+// updateVoter replaces a voter, the caller holds mu
+func (t *VoterList) updateVoter(voter Voter) error {
 
 	// Check if item exists before trying to update it
 	// this is a good practice, return an error if the
 	// item does not exist
-	_, ok := t.Voters[voter.VoterId]
-	if !ok {
-		return errors.New("item does not exist")
+	existing, err := t.getVoter(voter.VoterId)
+	if err != nil {
+		if IsUnavailable(err) {
+			return err
+		}
+		return NotFound("item does not exist")
 	}
+	if err := t.checkEmail(voter); err != nil {
+		return err
+	}
+
+	if err := t.sealChoices(&voter); err != nil {
+		return err
+	}
+	t.voteIds.Assign(&voter)
+
+	//A plain update can not place or lift a legal hold
+	voter.LegalHold = existing.LegalHold
+	voter.LegalHoldReason = existing.LegalHoldReason
+	if voter.Registered.IsZero() {
+		voter.Registered = existing.Registered
+	}
+	voter.EmailInvalid = existing.EmailInvalid && sameEmail(voter.Email, existing.Email)
 
 	//Now that we know the item exists, lets update it
-	t.Voters[voter.VoterId] = voter
+	t.logConsent(voter.VoterId, existing.Preferences, voter.Preferences, "update")
+	t.putVoter(voter)
 
 	return nil
 }
@@ -158,16 +334,28 @@ func (t *VoterList) UpdateVoter(voter Voter) error {
 //			along with an empty ToDoItem
 //		(3) The database file will not be modified
 func (t *VoterList) GetVoter(id int) (Voter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.getVoter(id)
+}
+
+// getVoter returns a copy of a voter, the caller holds mu
+func (t *VoterList) getVoter(id int) (Voter, error) {
 
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
 	// item does not exist
 	item, ok := t.Voters[id]
 	if !ok {
-		return Voter{}, errors.New("voter does not exist")
+		//Fall back to the cold store for archived voters
+		archived, err := t.getArchived(id)
+		t.shadowRead(id, archived, err == nil)
+		return archived, err
 	}
 
-	return item, nil
+	t.shadowRead(id, item, true)
+	return cloneVoter(item), nil
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -181,13 +369,15 @@ func (t *VoterList) GetVoter(id int) (Voter, error) {
 //			along with an empty slice
 //		(3) The database file will not be modified
 func (t *VoterList) GetAllVoters() ([]Voter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 
 	//Now that we have the DB loaded, lets crate a slice
 	var voterList []Voter
 
 	//Now lets iterate over our map and add each item to our slice
 	for _, voter := range t.Voters {
-		voterList = append(voterList, voter)
+		voterList = append(voterList, cloneVoter(voter))
 	}
 
 	//Now that we have all of our items in a slice, return it
@@ -219,37 +409,57 @@ func (t *VoterList) GetVoterPoll(voterID, pollID int) (VoterHistory, error) {
 		}
 	}
 
-	return VoterHistory{}, errors.New("poll not found for this voter")
+	return VoterHistory{}, NotFound("poll not found for this voter")
+}
+
+// AlreadyVoted returns an ErrAlreadyExists if the voter has a vote in the
+// poll, a voter votes once per poll.  Stores check it before they add a
+// history entry.
+func AlreadyVoted(voter Voter, pollID int) error {
+	for _, history := range voter.VoteHistory {
+		if history.PollId == pollID {
+			return AlreadyExists(fmt.Sprintf("voter %d already voted in poll %d", voter.VoterId, pollID))
+		}
+	}
+	return nil
 }
 
 // AddVoterPoll adds a new voting record for a voter.
-// It takes voter ID, poll ID, and vote date as input and adds the record to the corresponding voter.
-func (t *VoterList) AddVoterPoll(voterID, pollID int, voteDate time.Time) error {
-	voter, err := t.GetVoter(voterID)
+// It takes voter ID, poll ID, and the record as input and adds the record to the corresponding voter
+// in one step, a voter that already voted in the poll is an ErrAlreadyExists.
+// A record without a VoteId gets the next one, the record is returned as it was stored.
+func (t *VoterList) AddVoterPoll(voterID, pollID int, vote VoterHistory) (VoterHistory, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
 	if err != nil {
-		return err
+		return VoterHistory{}, err
 	}
-
-	newVoterHistory := VoterHistory{
-		PollId:   pollID,
-		VoteId:   len(voter.VoteHistory) + 1, // Assuming vote ID increments linearly
-		VoteDate: voteDate,
+	if err := AlreadyVoted(voter, pollID); err != nil {
+		return VoterHistory{}, err
 	}
 
-	voter.VoteHistory = append(voter.VoteHistory, newVoterHistory)
+	vote.PollId = pollID
+	voter.VoteHistory = append(voter.VoteHistory, vote)
 
-	err = t.UpdateVoter(voter)
+	err = t.updateVoter(voter)
 	if err != nil {
-		return err
+		return VoterHistory{}, err
 	}
 
-	return nil
+	//The choice is sealed and the VoteId handed out on the way in
+	stored := t.Voters[voterID].VoteHistory
+	return stored[len(stored)-1], nil
 }
 
 // UpdateVoterPoll updates a voting record for a voter.
 // It takes voter ID, poll ID, and new vote date as input and updates the corresponding record.
 func (t *VoterList) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time) error {
-	voter, err := t.GetVoter(voterID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
 	if err != nil {
 		return err
 	}
@@ -257,7 +467,7 @@ func (t *VoterList) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time)
 	for i, history := range voter.VoteHistory {
 		if history.PollId == pollID {
 			voter.VoteHistory[i].VoteDate = newVoteDate
-			err := t.UpdateVoter(voter)
+			err := t.updateVoter(voter)
 			if err != nil {
 				return err
 			}
@@ -265,13 +475,16 @@ func (t *VoterList) UpdateVoterPoll(voterID, pollID int, newVoteDate time.Time)
 		}
 	}
 
-	return errors.New("poll not found for this voter")
+	return NotFound("poll not found for this voter")
 }
 
 // DeleteVoterPoll deletes a voting record for a voter.
 // It takes voter ID and poll ID as input and removes the corresponding record.
 func (t *VoterList) DeleteVoterPoll(voterID, pollID int) error {
-	voter, err := t.GetVoter(voterID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	voter, err := t.getVoter(voterID)
 	if err != nil {
 		return err
 	}
@@ -279,7 +492,7 @@ func (t *VoterList) DeleteVoterPoll(voterID, pollID int) error {
 	for i, history := range voter.VoteHistory {
 		if history.PollId == pollID {
 			voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
-			err := t.UpdateVoter(voter)
+			err := t.updateVoter(voter)
 			if err != nil {
 				return err
 			}
@@ -287,7 +500,7 @@ func (t *VoterList) DeleteVoterPoll(voterID, pollID int) error {
 		}
 	}
 
-	return errors.New("poll not found for this voter")
+	return NotFound("poll not found for this voter")
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Events a webhook can subscribe to, AllEvents subscribes to every one
+const (
+	EventVoterCreated = "voter.created"
+	EventVoterUpdated = "voter.updated"
+	EventVoterDeleted = "voter.deleted"
+	EventVoteRecorded = "vote.recorded"
+	EventVoteUpdated  = "vote.updated"
+	EventVoteDeleted  = "vote.deleted"
+
+	AllEvents = "*"
+)
+
+// webhookEvents are the events a webhook can name
+var webhookEvents = map[string]bool{
+	EventVoterCreated: true,
+	EventVoterUpdated: true,
+	EventVoterDeleted: true,
+	EventVoteRecorded: true,
+	EventVoteUpdated:  true,
+	EventVoteDeleted:  true,
+	AllEvents:         true,
+}
+
+// webhookName is the allowed form of a webhook name, it is used in URLs
+var webhookName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Webhook is a subscription of an outside system to changes of the roll,
+// managed at /admin/webhooks.  Deliveries are signed with a secret the
+// store makes, it is shown once when the webhook is created or its secret
+// rotated.
+type Webhook struct {
+	Name     string
+	URL      string
+	Events   []string //Events to send, e.g. voter.created, or * for all
+	Disabled bool
+	Created  time.Time //Set by the store
+	Updated  time.Time //Set by the store
+
+	secret string
+}
+
+// Secret returns the secret deliveries to the webhook are signed with
+func (w Webhook) Secret() string {
+	return w.secret
+}
+
+// Wants reports whether a webhook subscribes to an event
+func (w Webhook) Wants(event string) bool {
+	if w.Disabled {
+		return false
+	}
+	for _, e := range w.Events {
+		if e == event || e == AllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookStore holds the webhooks by name
+type WebhookStore struct {
+	mu       sync.Mutex
+	webhooks map[string]Webhook
+}
+
+// constructor for WebhookStore struct
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{webhooks: make(map[string]Webhook)}
+}
+
+// NormalizeWebhook checks the fields an admin can set on a webhook and
+// returns it as the store keeps it, with its events sorted
+func NormalizeWebhook(webhook Webhook) (Webhook, error) {
+	if !webhookName.MatchString(webhook.Name) {
+		return Webhook{}, InvalidInput("a webhook name is lower case letters, digits, - and _")
+	}
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return Webhook{}, InvalidInput("a webhook needs an http or https URL")
+	}
+	if len(webhook.Events) == 0 {
+		return Webhook{}, InvalidInput("a webhook needs at least one event")
+	}
+	for _, event := range webhook.Events {
+		if !webhookEvents[event] {
+			return Webhook{}, InvalidInput("unknown event " + event)
+		}
+	}
+
+	webhook.Events = append([]string(nil), webhook.Events...)
+	sort.Strings(webhook.Events)
+	return webhook, nil
+}
+
+// AddWebhook adds a webhook
+func (s *WebhookStore) AddWebhook(webhook Webhook) (Webhook, error) {
+	webhook, err := NormalizeWebhook(webhook)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[webhook.Name]; ok {
+		return Webhook{}, AlreadyExists(fmt.Sprintf("webhook %s already exists", webhook.Name))
+	}
+	if webhook.secret, err = newToken(); err != nil {
+		return Webhook{}, err
+	}
+
+	webhook.Created = time.Now()
+	webhook.Updated = webhook.Created
+	s.webhooks[webhook.Name] = webhook
+
+	return webhook, nil
+}
+
+// UpdateWebhook replaces the URL, events and state of a webhook, an update
+// that changes nothing keeps the time it was last updated
+func (s *WebhookStore) UpdateWebhook(webhook Webhook) (Webhook, error) {
+	webhook, err := NormalizeWebhook(webhook)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.webhooks[webhook.Name]
+	if !ok {
+		return Webhook{}, NotFound("webhook does not exist")
+	}
+
+	webhook.Created = stored.Created
+	webhook.Updated = stored.Updated
+	webhook.secret = stored.secret
+	if !reflect.DeepEqual(webhook, stored) {
+		webhook.Updated = time.Now()
+	}
+	s.webhooks[webhook.Name] = webhook
+
+	return webhook, nil
+}
+
+// RotateSecret gives a webhook a new secret, deliveries are signed with it
+// from then on
+func (s *WebhookStore) RotateSecret(name string) (Webhook, error) {
+	secret, err := newToken()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[name]
+	if !ok {
+		return Webhook{}, NotFound("webhook does not exist")
+	}
+
+	webhook.secret = secret
+	s.webhooks[name] = webhook
+
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook
+func (s *WebhookStore) DeleteWebhook(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[name]; !ok {
+		return NotFound("webhook does not exist")
+	}
+
+	delete(s.webhooks, name)
+	return nil
+}
+
+// GetWebhook returns a webhook by name
+func (s *WebhookStore) GetWebhook(name string) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[name]
+	if !ok {
+		return Webhook{}, NotFound("webhook does not exist")
+	}
+
+	return webhook, nil
+}
+
+// GetAllWebhooks returns all webhooks ordered by name
+func (s *WebhookStore) GetAllWebhooks() []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+
+	return webhooks
+}
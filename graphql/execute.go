@@ -0,0 +1,821 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Request is the body of a GraphQL request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Response is the answer to a request.  Data is left out when the request
+// failed before it was executed, e.g. on a syntax error.
+type Response struct {
+	Data   any
+	Errors []*Error
+
+	executed bool
+}
+
+// Rejected reports whether the request failed before it was executed
+func (r Response) Rejected() bool {
+	return !r.executed
+}
+
+// MarshalJSON writes the response as the GraphQL spec lays it out
+func (r Response) MarshalJSON() ([]byte, error) {
+	body := make(map[string]any)
+	if r.executed {
+		body["data"] = r.Data
+	}
+	if len(r.Errors) > 0 {
+		body["errors"] = r.Errors
+	}
+	return json.Marshal(body)
+}
+
+// Error is an error of a request.  Resolvers can return one to add
+// extensions, e.g. an error code, other errors are reported with their
+// message.
+type Error struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Location is the line and column of the selection an error is about
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Params are what a resolver gets, the value of the parent object and the
+// arguments of the field
+type Params struct {
+	Context context.Context
+	Source  any
+	Args    map[string]any
+}
+
+// Operation returns the kind of the operation of a request, query or
+// mutation, so a transport can refuse mutations where they do not belong,
+// e.g. in GET requests
+func Operation(req Request) (string, error) {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return "", err
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return "", err
+	}
+	return op.kind, nil
+}
+
+// operation picks the operation of a document to run
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("the document has more than one operation, operationName must name one")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %s", name)
+}
+
+// executor runs one operation
+type executor struct {
+	ctx       context.Context
+	schema    *Schema
+	doc       *document
+	types     map[string]Type
+	defined   map[string]bool
+	variables map[string]any
+	errors    []*Error
+}
+
+// Execute runs a request against the schema.  Fields are resolved one at
+// a time in the order of the request, for queries as well as mutations.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	root := s.Query
+	if op.kind == "mutation" {
+		if s.Mutation == nil {
+			return Response{Errors: []*Error{{Message: "the schema has no mutations"}}}
+		}
+		root = s.Mutation
+	}
+
+	e := &executor{ctx: ctx, schema: s, doc: doc, types: s.named(),
+		defined: make(map[string]bool), variables: make(map[string]any)}
+	e.coerceVariables(op, req.Variables)
+	if len(e.errors) == 0 {
+		e.validate(root, op.selections, make(map[string]bool))
+	}
+	if len(e.errors) > 0 {
+		return Response{Errors: e.errors}
+	}
+
+	data, ok := e.executeSet(root, nil, op.selections, nil)
+	if !ok {
+		data = nil
+	}
+	return Response{Data: data, Errors: e.errors, executed: true}
+}
+
+// fail records an error of a field
+func (e *executor) fail(path []any, sel *selection, message string, extensions map[string]any) {
+	err := &Error{Message: message, Extensions: extensions}
+	if path != nil {
+		err.Path = append([]any(nil), path...)
+	}
+	if sel != nil {
+		err.Locations = []Location{{Line: sel.line, Column: sel.column}}
+	}
+	e.errors = append(e.errors, err)
+}
+
+// coerceVariables checks the variables of a request against the
+// definitions of the operation
+func (e *executor) coerceVariables(op *operation, given map[string]any) {
+	for _, def := range op.variables {
+		e.defined[def.name] = true
+		t, err := e.inputType(def.typ)
+		if err != nil {
+			e.fail(nil, nil, err.Error(), nil)
+			continue
+		}
+
+		raw, ok := given[def.name]
+		switch {
+		case ok:
+			v, err := coerceJSON(t, raw)
+			if err != nil {
+				e.fail(nil, nil, fmt.Sprintf("variable $%s: %s", def.name, err), nil)
+				continue
+			}
+			e.variables[def.name] = v
+		case def.value != nil:
+			v, err := e.coerceLiteral(t, *def.value)
+			if err != nil {
+				e.fail(nil, nil, fmt.Sprintf("variable $%s: %s", def.name, err), nil)
+				continue
+			}
+			e.variables[def.name] = v
+		case def.typ.nonNull:
+			e.fail(nil, nil, fmt.Sprintf("variable $%s of type %s is required", def.name, def.typ), nil)
+		}
+	}
+}
+
+// inputType returns the schema type of a variable type
+func (e *executor) inputType(ref typeRef) (Type, error) {
+	var t Type
+	if ref.of != nil {
+		of, err := e.inputType(*ref.of)
+		if err != nil {
+			return nil, err
+		}
+		t = &List{of}
+	} else {
+		switch named := e.types[ref.name].(type) {
+		case *Scalar, *Enum, *InputObject:
+			t = named
+		default:
+			return nil, fmt.Errorf("unknown input type %s", ref.name)
+		}
+	}
+	if ref.nonNull {
+		t = &NonNull{t}
+	}
+	return t, nil
+}
+
+// validate checks that every field of a selection set exists with the
+// arguments it is given, before anything is resolved
+func (e *executor) validate(obj *Object, sels []selection, fragments map[string]bool) {
+	for i := range sels {
+		sel := &sels[i]
+		for _, d := range sel.directives {
+			if d.name != "skip" && d.name != "include" {
+				e.fail(nil, sel, fmt.Sprintf("unknown directive @%s", d.name), nil)
+			}
+			if _, ok := d.arguments["if"]; !ok || len(d.arguments) != 1 {
+				e.fail(nil, sel, fmt.Sprintf("@%s takes one argument, if", d.name), nil)
+			}
+			e.validateVariables(sel, d.arguments)
+		}
+
+		switch {
+		case sel.spread:
+			f, ok := e.doc.fragments[sel.name]
+			if !ok {
+				e.fail(nil, sel, fmt.Sprintf("unknown fragment %s", sel.name), nil)
+				continue
+			}
+			if fragments[sel.name] {
+				e.fail(nil, sel, fmt.Sprintf("fragment %s spreads itself", sel.name), nil)
+				continue
+			}
+			fragments[sel.name] = true
+			e.validate(obj, f.selections, fragments)
+			delete(fragments, sel.name)
+			continue
+		case sel.inline:
+			e.validate(obj, sel.selections, fragments)
+			continue
+		case sel.name == "__typename":
+			if sel.selections != nil || sel.arguments != nil {
+				e.fail(nil, sel, "__typename takes no arguments or selections", nil)
+			}
+			continue
+		}
+
+		field := obj.Field(sel.name)
+		if field == nil {
+			e.fail(nil, sel, fmt.Sprintf("cannot query field %s on type %s", sel.name, obj.Name), nil)
+			continue
+		}
+		for name := range sel.arguments {
+			if argument(field.Args, name) == nil {
+				e.fail(nil, sel, fmt.Sprintf("unknown argument %s of field %s", name, sel.name), nil)
+			}
+		}
+		for _, arg := range field.Args {
+			if _, given := sel.arguments[arg.Name]; !given && arg.Default == nil && isNonNull(arg.Type) {
+				e.fail(nil, sel, fmt.Sprintf("argument %s of type %s is required", arg.Name, arg.Type), nil)
+			}
+		}
+		e.validateVariables(sel, sel.arguments)
+
+		if obj, ok := namedType(field.Type).(*Object); ok {
+			if sel.selections == nil {
+				e.fail(nil, sel, fmt.Sprintf("field %s of type %s needs a selection of its fields", sel.name, field.Type), nil)
+				continue
+			}
+			e.validate(obj, sel.selections, fragments)
+		} else if sel.selections != nil {
+			e.fail(nil, sel, fmt.Sprintf("field %s of type %s has no fields to select", sel.name, field.Type), nil)
+		}
+	}
+}
+
+// validateVariables checks that the variables in argument values are
+// defined by the operation
+func (e *executor) validateVariables(sel *selection, args map[string]value) {
+	var walk func(v value)
+	walk = func(v value) {
+		switch v.kind {
+		case variableValue:
+			if !e.defined[v.raw] {
+				e.fail(nil, sel, fmt.Sprintf("variable $%s is not defined", v.raw), nil)
+			}
+		case listValue:
+			for _, item := range v.list {
+				walk(item)
+			}
+		case objectValue:
+			for _, item := range v.object {
+				walk(item)
+			}
+		}
+	}
+	for _, v := range args {
+		walk(v)
+	}
+}
+
+func argument(args []*Argument, name string) *Argument {
+	for _, arg := range args {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+func isNonNull(t Type) bool {
+	_, ok := t.(*NonNull)
+	return ok
+}
+
+// namedType strips the list and non null wrappers of a type
+func namedType(t Type) Type {
+	for {
+		switch wrapper := t.(type) {
+		case *NonNull:
+			t = wrapper.Of
+		case *List:
+			t = wrapper.Of
+		default:
+			return t
+		}
+	}
+}
+
+// fieldValue is a field of a result object
+type fieldValue struct {
+	key   string
+	value any
+}
+
+// resultObject is a result object, its fields are written in the order
+// they were selected
+type resultObject []fieldValue
+
+func (r resultObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// collected is the selections of a field under one response key
+type collected struct {
+	key        string
+	selections []*selection
+}
+
+// collect gathers the fields of a selection set, following fragments and
+// leaving out those skipped by @skip and @include
+func (e *executor) collect(sels []selection, fields []collected) []collected {
+	for i := range sels {
+		sel := &sels[i]
+		if !e.included(sel) {
+			continue
+		}
+		switch {
+		case sel.spread:
+			fields = e.collect(e.doc.fragments[sel.name].selections, fields)
+		case sel.inline:
+			fields = e.collect(sel.selections, fields)
+		default:
+			merged := false
+			for j := range fields {
+				if fields[j].key == sel.responseKey() {
+					fields[j].selections = append(fields[j].selections, sel)
+					merged = true
+				}
+			}
+			if !merged {
+				fields = append(fields, collected{key: sel.responseKey(), selections: []*selection{sel}})
+			}
+		}
+	}
+	return fields
+}
+
+// included evaluates the @skip and @include directives of a selection
+func (e *executor) included(sel *selection) bool {
+	for _, d := range sel.directives {
+		v, err := e.coerceLiteral(&NonNull{Boolean}, d.arguments["if"])
+		if err != nil {
+			continue
+		}
+		if d.name == "skip" && v.(bool) || d.name == "include" && !v.(bool) {
+			return false
+		}
+	}
+	return true
+}
+
+// executeSet resolves the fields of an object.  It is false when a non
+// null field is null, which makes the object null.
+func (e *executor) executeSet(obj *Object, source any, sels []selection, path []any) (any, bool) {
+	result := resultObject{}
+	for _, field := range e.collect(sels, nil) {
+		value, ok := e.executeField(obj, source, field, append(path, field.key))
+		if !ok {
+			return nil, false
+		}
+		result = append(result, fieldValue{key: field.key, value: value})
+	}
+	return result, true
+}
+
+func (e *executor) executeField(obj *Object, source any, field collected, path []any) (any, bool) {
+	sel := field.selections[0]
+	if sel.name == "__typename" {
+		return obj.Name, true
+	}
+	def := obj.Field(sel.name)
+
+	args, err := e.coerceArguments(def.Args, sel.arguments)
+	if err != nil {
+		e.fail(path, sel, err.Error(), nil)
+		return nil, !isNonNull(def.Type)
+	}
+
+	var value any
+	if def.Resolve != nil {
+		value, err = def.Resolve(Params{Context: e.ctx, Source: source, Args: args})
+	} else if m, ok := source.(map[string]any); ok {
+		key := def.Key
+		if key == "" {
+			key = def.Name
+		}
+		value = m[key]
+	}
+	if err != nil {
+		var coded *Error
+		if errors.As(err, &coded) {
+			e.fail(path, sel, coded.Message, coded.Extensions)
+		} else {
+			e.fail(path, sel, err.Error(), nil)
+		}
+		return nil, !isNonNull(def.Type)
+	}
+
+	var sub []selection
+	for _, s := range field.selections {
+		sub = append(sub, s.selections...)
+	}
+	return e.complete(def.Type, sel, sub, value, path)
+}
+
+// complete turns a resolved value into the result of its type.  It is
+// false when the value is null where the type does not allow it.
+func (e *executor) complete(t Type, sel *selection, sub []selection, value any, path []any) (any, bool) {
+	if nonNull, ok := t.(*NonNull); ok {
+		result, ok := e.completeValue(nonNull.Of, sel, sub, value, path)
+		if !ok {
+			return nil, false
+		}
+		if result == nil {
+			e.fail(path, sel, fmt.Sprintf("%s of type %s is null", sel.name, t), nil)
+			return nil, false
+		}
+		return result, true
+	}
+
+	result, ok := e.completeValue(t, sel, sub, value, path)
+	if !ok {
+		return nil, true
+	}
+	return result, true
+}
+
+func (e *executor) completeValue(t Type, sel *selection, sub []selection, value any, path []any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	if v := reflect.ValueOf(value); (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil() {
+		return nil, true
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			e.fail(path, sel, fmt.Sprintf("%s is not a list", sel.name), nil)
+			return nil, false
+		}
+		result := make([]any, items.Len())
+		for i := range result {
+			item, ok := e.complete(t.Of, sel, sub, items.Index(i).Interface(), append(path, i))
+			if !ok {
+				return nil, false
+			}
+			result[i] = item
+		}
+		return result, true
+	case *Object:
+		return e.executeSet(t, value, sub, path)
+	case *Enum:
+		return fmt.Sprint(value), true
+	case *Scalar:
+		result, err := serialize(t, value)
+		if err != nil {
+			e.fail(path, sel, err.Error(), nil)
+			return nil, false
+		}
+		return result, true
+	}
+
+	e.fail(path, sel, fmt.Sprintf("%s has no output type", sel.name), nil)
+	return nil, false
+}
+
+// serialize returns the result value of a scalar
+func serialize(s *Scalar, value any) (any, error) {
+	switch s {
+	case Int:
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+				return int(v), nil
+			}
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		}
+	case Float:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		}
+	case Boolean:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case DateTime:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		}
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case int:
+			return strconv.Itoa(v), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not a valid %s", value, s.Name)
+}
+
+// coerceArguments returns the arguments of a field as Resolve gets them
+func (e *executor) coerceArguments(defs []*Argument, given map[string]value) (map[string]any, error) {
+	args := make(map[string]any)
+	for _, def := range defs {
+		v, ok := given[def.Name]
+		if ok && v.kind == variableValue {
+			_, ok = e.variables[v.raw]
+		}
+		if !ok {
+			if def.Default != nil {
+				args[def.key()] = def.Default
+			} else if isNonNull(def.Type) {
+				return nil, fmt.Errorf("argument %s of type %s is required", def.Name, def.Type)
+			}
+			continue
+		}
+
+		coerced, err := e.coerceLiteral(def.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %s", def.Name, err)
+		}
+		args[def.key()] = coerced
+	}
+	return args, nil
+}
+
+// coerceLiteral returns the value of an argument as written in the request
+func (e *executor) coerceLiteral(t Type, v value) (any, error) {
+	if v.kind == variableValue {
+		value, ok := e.variables[v.raw]
+		if !ok || value == nil {
+			if isNonNull(t) {
+				return nil, fmt.Errorf("$%s is null", v.raw)
+			}
+			return nil, nil
+		}
+		return value, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if v.kind == nullValue {
+			return nil, fmt.Errorf("null at %s where %s is expected", v.position, t)
+		}
+		return e.coerceLiteral(nonNull.Of, v)
+	}
+	if v.kind == nullValue {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if v.kind != listValue {
+			item, err := e.coerceLiteral(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		items := make([]any, len(v.list))
+		for i, item := range v.list {
+			coerced, err := e.coerceLiteral(t.Of, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = coerced
+		}
+		return items, nil
+	case *InputObject:
+		if v.kind != objectValue {
+			return nil, fmt.Errorf("%s expected at %s", t.Name, v.position)
+		}
+		for name := range v.object {
+			if argument(t.Fields, name) == nil {
+				return nil, fmt.Errorf("%s has no field %s", t.Name, name)
+			}
+		}
+		fields := make(map[string]value)
+		for name, item := range v.object {
+			if item.kind != variableValue {
+				fields[name] = item
+			} else if _, ok := e.variables[item.raw]; ok {
+				fields[name] = item
+			}
+		}
+		return coerceObject(t, fields, e.coerceLiteral)
+	case *Enum:
+		if v.kind != enumValue || !contains(t.Values, v.raw) {
+			return nil, fmt.Errorf("%s at %s is not a value of %s", v.raw, v.position, t.Name)
+		}
+		return v.raw, nil
+	case *Scalar:
+		return coerceScalar(t, v)
+	}
+
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceObject checks the fields of an input object
+func coerceObject[V any](t *InputObject, given map[string]V, coerce func(Type, V) (any, error)) (any, error) {
+	result := make(map[string]any)
+	for _, field := range t.Fields {
+		v, ok := given[field.Name]
+		if !ok {
+			if field.Default != nil {
+				result[field.key()] = field.Default
+			} else if isNonNull(field.Type) {
+				return nil, fmt.Errorf("field %s of %s is required", field.Name, t.Name)
+			}
+			continue
+		}
+		coerced, err := coerce(field.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", t.Name, field.Name, err)
+		}
+		result[field.key()] = coerced
+	}
+	return result, nil
+}
+
+// coerceScalar returns the value of a scalar literal
+func coerceScalar(s *Scalar, v value) (any, error) {
+	switch {
+	case s == Int && v.kind == intValue:
+		n, err := strconv.ParseInt(v.raw, 10, 32)
+		if err == nil {
+			return int(n), nil
+		}
+	case s == Float && (v.kind == intValue || v.kind == floatValue):
+		return strconv.ParseFloat(v.raw, 64)
+	case s == Boolean && v.kind == booleanValue:
+		return v.raw == "true", nil
+	case s == ID && v.kind == intValue:
+		return v.raw, nil
+	case s == DateTime && v.kind == stringValue:
+		return parseDateTime(v.raw)
+	case s != Int && s != Float && s != Boolean && s != DateTime && v.kind == stringValue:
+		return v.raw, nil
+	}
+	return nil, fmt.Errorf("%s at %s is not a valid %s", v.raw, v.position, s.Name)
+}
+
+// coerceJSON returns the value of a variable as sent in the JSON of the
+// request
+func coerceJSON(t Type, v any) (any, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("null where %s is expected", t)
+		}
+		return coerceJSON(nonNull.Of, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		list, ok := v.([]any)
+		if !ok {
+			item, err := coerceJSON(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		items := make([]any, len(list))
+		for i, item := range list {
+			coerced, err := coerceJSON(t.Of, item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = coerced
+		}
+		return items, nil
+	case *InputObject:
+		fields, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s expected", t.Name)
+		}
+		for name := range fields {
+			if argument(t.Fields, name) == nil {
+				return nil, fmt.Errorf("%s has no field %s", t.Name, name)
+			}
+		}
+		return coerceObject(t, fields, coerceJSON)
+	case *Enum:
+		if s, ok := v.(string); ok && contains(t.Values, s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%v is not a value of %s", v, t.Name)
+	case *Scalar:
+		switch value := v.(type) {
+		case float64:
+			if t == Int && value == math.Trunc(value) && math.Abs(value) <= math.MaxInt32 {
+				return int(value), nil
+			}
+			if t == Float {
+				return value, nil
+			}
+			if t == ID && value == math.Trunc(value) {
+				return strconv.FormatFloat(value, 'f', -1, 64), nil
+			}
+		case bool:
+			if t == Boolean {
+				return value, nil
+			}
+		case string:
+			if t == DateTime {
+				return parseDateTime(value)
+			}
+			if t == String || t == ID {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("%v is not a valid %s", v, t.Name)
+	}
+
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// parseDateTime checks a DateTime, which is passed on as the string it was
+// sent as
+func parseDateTime(s string) (any, error) {
+	if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+		return nil, fmt.Errorf("%q is not an RFC 3339 timestamp", s)
+	}
+	return s, nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
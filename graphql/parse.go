@@ -0,0 +1,577 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request, its operations and fragments
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is a query or a mutation
+type operation struct {
+	kind       string //query or mutation
+	name       string
+	variables  []variableDef
+	selections []selection
+}
+
+// variableDef declares a variable of an operation, e.g. $id: Int!
+type variableDef struct {
+	name  string
+	typ   typeRef
+	value *value //Default value, nil when there is none
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string //Named type, empty for a list
+	of      *typeRef
+	nonNull bool
+}
+
+func (t typeRef) String() string {
+	s := t.name
+	if t.of != nil {
+		s = "[" + t.of.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// fragment is a named fragment, its type condition is not checked as every
+// selection has a single possible type
+type fragment struct {
+	name       string
+	selections []selection
+}
+
+// selection is a field, a fragment spread or an inline fragment
+type selection struct {
+	line, column int
+
+	alias      string
+	name       string //Field name, or the fragment name of a spread
+	arguments  map[string]value
+	directives []directive
+	selections []selection
+
+	spread bool //A ...Name spread
+	inline bool //A ... on Type { } fragment
+}
+
+// responseKey is the name the field is returned under
+func (s selection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// directive is an @include or @skip on a selection
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+// value is an argument value as written, a variable reference or a
+// literal
+type value struct {
+	kind     valueKind
+	raw      string           //Name of a variable or enum, text of a scalar
+	list     []value          //Items of a list
+	object   map[string]value //Fields of an input object
+	position string           //Line and column, for errors
+}
+
+type valueKind int
+
+const (
+	variableValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	booleanValue
+	nullValue
+	enumValue
+	listValue
+	objectValue
+)
+
+// token kinds of the lexer
+const (
+	tokenEOF = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind         int
+	text         string
+	line, column int
+}
+
+// parser reads a request with one token of look ahead
+type parser struct {
+	src       string
+	pos       int
+	line, col int
+	tok       token
+}
+
+// parse parses the text of a request
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: strings.TrimPrefix(src, "\ufeff"), line: 1, col: 1}
+	defer func() {
+		if r := recover(); r != nil {
+			syntax, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntax
+		}
+	}()
+
+	p.next()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: p.selectionSet()})
+		case p.peek("query"), p.peek("mutation"), p.peek("subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peek("fragment"):
+			f := p.fragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.fail("fragment %s is defined twice", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			p.fail("unexpected %q", p.tok.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, syntaxError("the document has no operation")
+	}
+
+	return doc, nil
+}
+
+// syntaxError is a request that could not be parsed
+type syntaxError string
+
+func (e syntaxError) Error() string { return string(e) }
+
+func (p *parser) fail(format string, args ...any) {
+	panic(syntaxError(fmt.Sprintf("syntax error at %d:%d: ", p.tok.line, p.tok.column) + fmt.Sprintf(format, args...)))
+}
+
+// peek reports whether the current token is a punctuator or name
+func (p *parser) peek(text string) bool {
+	return (p.tok.kind == tokenPunct || p.tok.kind == tokenName) && p.tok.text == text
+}
+
+// expect consumes a punctuator or keyword
+func (p *parser) expect(text string) {
+	if !p.peek(text) {
+		p.fail("expected %q, found %q", text, p.tok.text)
+	}
+	p.next()
+}
+
+// skip consumes a punctuator or keyword when it is the current token
+func (p *parser) skip(text string) bool {
+	if p.peek(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokenName {
+		p.fail("expected a name, found %q", p.tok.text)
+	}
+	name := p.tok.text
+	p.next()
+	return name
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.name()}
+	if op.kind == "subscription" {
+		p.fail("subscriptions are not supported")
+	}
+	if p.tok.kind == tokenName {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			def := variableDef{name: p.name()}
+			p.expect(":")
+			def.typ = p.typeRef()
+			if p.skip("=") {
+				v := p.value(true)
+				def.value = &v
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	if p.peek("@") {
+		p.fail("directives on operations are not supported")
+	}
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *parser) typeRef() typeRef {
+	var t typeRef
+	if p.skip("[") {
+		of := p.typeRef()
+		t.of = &of
+		p.expect("]")
+	} else {
+		t.name = p.name()
+	}
+	t.nonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) fragment() *fragment {
+	p.expect("fragment")
+	f := &fragment{name: p.name()}
+	if f.name == "on" {
+		p.fail("a fragment can not be named on")
+	}
+	p.expect("on")
+	p.name()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	var selections []selection
+	for !p.skip("}") {
+		if p.tok.kind == tokenEOF {
+			p.fail("unterminated selection set")
+		}
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) selection() selection {
+	s := selection{line: p.tok.line, column: p.tok.column}
+	if p.skip("...") {
+		if p.tok.kind == tokenName && p.tok.text != "on" {
+			s.spread = true
+			s.name = p.name()
+			s.directives = p.directives()
+			return s
+		}
+		s.inline = true
+		if p.skip("on") {
+			p.name()
+		}
+		s.directives = p.directives()
+		s.selections = p.selectionSet()
+		return s
+	}
+
+	s.name = p.name()
+	if p.skip(":") {
+		s.alias, s.name = s.name, p.name()
+	}
+	s.arguments = p.arguments(false)
+	s.directives = p.directives()
+	if p.peek("{") {
+		s.selections = p.selectionSet()
+	}
+	return s
+}
+
+func (p *parser) arguments(constant bool) map[string]value {
+	if !p.skip("(") {
+		return nil
+	}
+	args := make(map[string]value)
+	for !p.skip(")") {
+		name := p.name()
+		if _, ok := args[name]; ok {
+			p.fail("argument %s is given twice", name)
+		}
+		p.expect(":")
+		args[name] = p.value(constant)
+	}
+	return args
+}
+
+func (p *parser) directives() []directive {
+	var directives []directive
+	for p.skip("@") {
+		directives = append(directives, directive{name: p.name(), arguments: p.arguments(false)})
+	}
+	return directives
+}
+
+// value parses a value, a constant one can not reference variables
+func (p *parser) value(constant bool) value {
+	v := value{raw: p.tok.text, position: fmt.Sprintf("%d:%d", p.tok.line, p.tok.column)}
+	switch {
+	case p.tok.kind == tokenInt:
+		v.kind = intValue
+	case p.tok.kind == tokenFloat:
+		v.kind = floatValue
+	case p.tok.kind == tokenString:
+		v.kind = stringValue
+	case p.tok.kind == tokenName:
+		switch p.tok.text {
+		case "true", "false":
+			v.kind = booleanValue
+		case "null":
+			v.kind = nullValue
+		default:
+			v.kind = enumValue
+		}
+	case p.skip("$"):
+		if constant {
+			p.fail("a default value can not use a variable")
+		}
+		v.kind = variableValue
+		v.raw = p.name()
+		return v
+	case p.skip("["):
+		v.kind = listValue
+		for !p.skip("]") {
+			v.list = append(v.list, p.value(constant))
+		}
+		return v
+	case p.skip("{"):
+		v.kind = objectValue
+		v.object = make(map[string]value)
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			v.object[name] = p.value(constant)
+		}
+		return v
+	default:
+		p.fail("expected a value, found %q", p.tok.text)
+	}
+	p.next()
+	return v
+}
+
+// next reads the next token, skipping white space, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		if ch == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance(1)
+			}
+			continue
+		}
+		if ch != ' ' && ch != '\t' && ch != '\n' && ch != '\r' && ch != ',' {
+			break
+		}
+		p.advance(1)
+	}
+
+	p.tok = token{line: p.line, column: p.col}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokenEOF
+		return
+	}
+
+	rest := p.src[p.pos:]
+	ch := rest[0]
+	switch {
+	case strings.HasPrefix(rest, "..."):
+		p.tok.kind, p.tok.text = tokenPunct, "..."
+		p.advance(3)
+	case strings.ContainsRune("!$():=@[]{}|&", rune(ch)):
+		p.tok.kind, p.tok.text = tokenPunct, string(ch)
+		p.advance(1)
+	case ch == '_' || isLetter(ch):
+		end := 1
+		for end < len(rest) && (rest[end] == '_' || isLetter(rest[end]) || isDigit(rest[end])) {
+			end++
+		}
+		p.tok.kind, p.tok.text = tokenName, rest[:end]
+		p.advance(end)
+	case ch == '-' || isDigit(ch):
+		p.number(rest)
+	case strings.HasPrefix(rest, `"""`):
+		p.blockString(rest)
+	case ch == '"':
+		p.string(rest)
+	default:
+		r, _ := utf8.DecodeRuneInString(rest)
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) advance(n int) {
+	for _, ch := range p.src[p.pos : p.pos+n] {
+		if ch == '\n' {
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
+		}
+	}
+	p.pos += n
+}
+
+func (p *parser) number(rest string) {
+	end := 0
+	if rest[end] == '-' {
+		end++
+	}
+	digits := end
+	for end < len(rest) && isDigit(rest[end]) {
+		end++
+	}
+	if end == digits || rest[digits] == '0' && end-digits > 1 {
+		p.fail("invalid number")
+	}
+
+	p.tok.kind = tokenInt
+	if end < len(rest) && rest[end] == '.' {
+		p.tok.kind = tokenFloat
+		end++
+		start := end
+		for end < len(rest) && isDigit(rest[end]) {
+			end++
+		}
+		if end == start {
+			p.fail("invalid number")
+		}
+	}
+	if end < len(rest) && (rest[end] == 'e' || rest[end] == 'E') {
+		p.tok.kind = tokenFloat
+		end++
+		if end < len(rest) && (rest[end] == '+' || rest[end] == '-') {
+			end++
+		}
+		start := end
+		for end < len(rest) && isDigit(rest[end]) {
+			end++
+		}
+		if end == start {
+			p.fail("invalid number")
+		}
+	}
+	if end < len(rest) && (rest[end] == '_' || rest[end] == '.' || isLetter(rest[end])) {
+		p.fail("invalid number")
+	}
+
+	p.tok.text = rest[:end]
+	p.advance(end)
+}
+
+func (p *parser) string(rest string) {
+	var b strings.Builder
+	i := 1
+	for {
+		if i >= len(rest) || rest[i] == '\n' || rest[i] == '\r' {
+			p.fail("unterminated string")
+		}
+		ch := rest[i]
+		if ch == '"' {
+			i++
+			break
+		}
+		if ch != '\\' {
+			b.WriteByte(ch)
+			i++
+			continue
+		}
+		if i+1 >= len(rest) {
+			p.fail("unterminated string")
+		}
+		switch rest[i+1] {
+		case '"', '\\', '/':
+			b.WriteByte(rest[i+1])
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if i+6 > len(rest) {
+				p.fail("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(rest[i+2:i+6], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			b.WriteRune(rune(code))
+			i += 4
+		default:
+			p.fail("invalid escape \\%c", rest[i+1])
+		}
+		i += 2
+	}
+
+	p.tok.kind, p.tok.text = tokenString, b.String()
+	p.advance(i)
+}
+
+// blockString reads a """ string, its common indentation and leading and
+// trailing blank lines are removed
+func (p *parser) blockString(rest string) {
+	end := strings.Index(strings.ReplaceAll(rest[3:], `\"""`, "xxxx"), `"""`)
+	if end < 0 {
+		p.fail("unterminated string")
+	}
+	raw := strings.ReplaceAll(rest[3:3+end], `\"""`, `"""`)
+
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	p.tok.kind, p.tok.text = tokenString, strings.Join(lines, "\n")
+	p.advance(3 + end + 3)
+}
+
+func isLetter(ch byte) bool { return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' }
+
+func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
@@ -0,0 +1,390 @@
+// Package graphql runs GraphQL queries and mutations against a schema of
+// objects whose values are maps, such as decoded JSON, for example
+//
+//	schema := &graphql.Schema{Query: &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+//		{Name: "voter", Type: voterType, Args: []*graphql.Argument{{Name: "id", Type: &graphql.NonNull{Of: graphql.Int}}},
+//			Resolve: func(p graphql.Params) (any, error) { ... }},
+//	}}}
+//	response := schema.Execute(ctx, graphql.Request{Query: `{ voter(id: 1) { name } }`})
+//
+// It covers the executable part of the language: operations with
+// variables, aliases, fragments, inline fragments and the @skip and
+// @include directives.  Introspection is not supported beyond
+// __typename, the schema is published with SDL instead.  Object and input
+// types can be built from Go structs with Reflected.
+package graphql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Type is an output or input type of a schema
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type.  Values of the built in scalars are JSON values,
+// DateTime is an RFC 3339 timestamp sent as a string.
+type Scalar struct {
+	Name        string
+	Description string
+}
+
+// The scalars of a schema
+var (
+	Int      = &Scalar{Name: "Int"}
+	Float    = &Scalar{Name: "Float"}
+	String   = &Scalar{Name: "String"}
+	Boolean  = &Scalar{Name: "Boolean"}
+	ID       = &Scalar{Name: "ID"}
+	DateTime = &Scalar{Name: "DateTime", Description: "An RFC 3339 timestamp, e.g. 2024-11-05T07:00:00Z"}
+)
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is a type with fields, the value of an object is a
+// map[string]any such as a decoded JSON object
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+// Field looks up a field of an object by name
+func (o *Object) Field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Field is a field of an object.  A field without a Resolve function
+// returns the value of its Key in the parent object, or of its Name when
+// Key is empty.
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Argument
+	Key         string
+	Resolve     func(p Params) (any, error)
+}
+
+// Argument is an argument of a field, or a field of an input object
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	Key         string //Key of the field in the value passed to Resolve, Name when empty
+	Default     any    //Used when the argument is not given, nil for none
+}
+
+func (a *Argument) key() string {
+	if a.Key != "" {
+		return a.Key
+	}
+	return a.Name
+}
+
+// InputObject is an argument type with fields.  It is passed to Resolve as
+// a map[string]any keyed by the Key of each field, holding only the fields
+// that were given, so explicit nulls can be told apart from missing fields.
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      []*Argument
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+// Enum is a type with a fixed set of values, passed to Resolve and
+// returned as a string
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+func (e *Enum) String() string { return e.Name }
+
+// List is a list of a type
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NonNull is a type that is never null
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// Schema is the query and mutation root of an API
+type Schema struct {
+	Query    *Object
+	Mutation *Object //nil when the schema has no mutations
+}
+
+// named returns the named types reachable from the roots, by name
+func (s *Schema) named() map[string]Type {
+	types := make(map[string]Type)
+	var walk func(t Type)
+	walk = func(t Type) {
+		switch t := t.(type) {
+		case *NonNull:
+			walk(t.Of)
+		case *List:
+			walk(t.Of)
+		case *Object:
+			if types[t.Name] != nil {
+				return
+			}
+			types[t.Name] = t
+			for _, f := range t.Fields {
+				walk(f.Type)
+				for _, arg := range f.Args {
+					walk(arg.Type)
+				}
+			}
+		case *InputObject:
+			if types[t.Name] != nil {
+				return
+			}
+			types[t.Name] = t
+			for _, f := range t.Fields {
+				walk(f.Type)
+			}
+		case *Scalar:
+			types[t.Name] = t
+		case *Enum:
+			types[t.Name] = t
+		}
+	}
+	walk(s.Query)
+	if s.Mutation != nil {
+		walk(s.Mutation)
+	}
+	return types
+}
+
+// SDL returns the schema in the GraphQL schema definition language, for
+// code generators and documentation
+func (s *Schema) SDL() string {
+	types := s.named()
+	names := make([]string, 0, len(types))
+	for name, t := range types {
+		if t != Int && t != Float && t != String && t != Boolean && t != ID {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.Query.Name + "\n")
+	if s.Mutation != nil {
+		b.WriteString("  mutation: " + s.Mutation.Name + "\n")
+	}
+	b.WriteString("}\n")
+
+	description := func(indent, text string) {
+		if text != "" {
+			b.WriteString(indent + `"""` + text + `"""` + "\n")
+		}
+	}
+	arguments := func(args []*Argument) string {
+		if len(args) == 0 {
+			return ""
+		}
+		list := make([]string, len(args))
+		for i, arg := range args {
+			list[i] = arg.Name + ": " + arg.Type.String()
+		}
+		return "(" + strings.Join(list, ", ") + ")"
+	}
+
+	for _, name := range names {
+		b.WriteString("\n")
+		switch t := types[name].(type) {
+		case *Scalar:
+			description("", t.Description)
+			b.WriteString("scalar " + t.Name + "\n")
+		case *Enum:
+			description("", t.Description)
+			b.WriteString("enum " + t.Name + " {\n")
+			for _, v := range t.Values {
+				b.WriteString("  " + v + "\n")
+			}
+			b.WriteString("}\n")
+		case *Object:
+			description("", t.Description)
+			b.WriteString("type " + t.Name + " {\n")
+			for _, f := range t.Fields {
+				description("  ", f.Description)
+				b.WriteString("  " + f.Name + arguments(f.Args) + ": " + f.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		case *InputObject:
+			description("", t.Description)
+			b.WriteString("input " + t.Name + " {\n")
+			for _, f := range t.Fields {
+				description("  ", f.Description)
+				b.WriteString("  " + f.Name + ": " + f.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+
+	return b.String()
+}
+
+// Reflected builds the object and input types of Go structs.  Their
+// fields are named like the Go fields with a lower case first letter, e.g.
+// VoterId becomes voterId, and are keyed by the Go name, which is the key
+// of a struct without json tags in its JSON encoding.  The fields of an
+// object are nullable, so a field left out of the value, e.g. one the
+// caller is not allowed to see, is null rather than an error.
+type Reflected struct {
+	objects map[reflect.Type]*Object
+	inputs  map[reflect.Type]*InputObject
+}
+
+// NewReflected returns an empty set of reflected types
+func NewReflected() *Reflected {
+	return &Reflected{
+		objects: make(map[reflect.Type]*Object),
+		inputs:  make(map[reflect.Type]*InputObject),
+	}
+}
+
+// FieldName returns the GraphQL name of a Go field
+func FieldName(goName string) string {
+	runes := []rune(goName)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Object returns the object type of a struct type, named like the struct
+func (r *Reflected) Object(t reflect.Type) *Object {
+	if o, ok := r.objects[t]; ok {
+		return o
+	}
+
+	o := &Object{Name: t.Name()}
+	r.objects[t] = o
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, skip := jsonKey(field)
+		if skip {
+			continue
+		}
+		o.Fields = append(o.Fields, &Field{Name: FieldName(field.Name), Key: key, Type: nullable(r.output(field.Type))})
+	}
+	return o
+}
+
+// Input returns the input type of a struct type, named like the struct
+// with an Input suffix.  Every field is optional.
+func (r *Reflected) Input(t reflect.Type) *InputObject {
+	if in, ok := r.inputs[t]; ok {
+		return in
+	}
+
+	in := &InputObject{Name: t.Name() + "Input"}
+	r.inputs[t] = in
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, skip := jsonKey(field)
+		if skip {
+			continue
+		}
+		in.Fields = append(in.Fields, &Argument{Name: FieldName(field.Name), Key: key, Type: r.input(field.Type)})
+	}
+	return in
+}
+
+// jsonKey returns the key of a struct field in its JSON encoding
+func jsonKey(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, false
+	}
+	return field.Name, false
+}
+
+func (r *Reflected) output(t reflect.Type) Type {
+	switch {
+	case t == timeType:
+		return &NonNull{DateTime}
+	case t.Kind() == reflect.Pointer:
+		return nullable(r.output(t.Elem()))
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return String //Base64, as encoding/json writes it
+	case t.Kind() == reflect.Slice:
+		return &List{r.output(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		return &NonNull{r.Object(t)}
+	}
+	return &NonNull{scalarOf(t)}
+}
+
+func (r *Reflected) input(t reflect.Type) Type {
+	switch {
+	case t == timeType:
+		return DateTime
+	case t.Kind() == reflect.Pointer:
+		return r.input(t.Elem())
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return String
+	case t.Kind() == reflect.Slice:
+		return &List{&NonNull{nullable(r.input(t.Elem()))}}
+	case t.Kind() == reflect.Struct:
+		return r.Input(t)
+	}
+	return scalarOf(t)
+}
+
+// scalarOf returns the scalar of a Go kind
+func scalarOf(t reflect.Type) *Scalar {
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Int
+	case reflect.Float32, reflect.Float64:
+		return Float
+	case reflect.String:
+		return String
+	}
+	panic(fmt.Sprintf("graphql: no scalar for %s", t))
+}
+
+// nullable strips the NonNull of a type
+func nullable(t Type) Type {
+	if n, ok := t.(*NonNull); ok {
+		return n.Of
+	}
+	return t
+}
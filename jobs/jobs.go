@@ -0,0 +1,139 @@
+// Package jobs runs long operations in the background.  Handlers submit a
+// job and return its id straight away, clients then poll the job until it
+// is done and pick up the result from it.
+package jobs
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Func is the work done by a job, its result is stored on the job
+type Func func() (any, error)
+
+// Job is a unit of background work
+type Job struct {
+	JobId    int
+	Kind     string
+	Status   string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+	Result   any
+	Error    string
+}
+
+// Queue runs submitted jobs one at a time in the order they came in
+type Queue struct {
+	mu     sync.Mutex
+	jobs   map[int]Job
+	work   chan queued
+	nextId int
+}
+
+// queued is a job waiting for the worker
+type queued struct {
+	id int
+	fn Func
+}
+
+// constructor for Queue struct, it starts the worker goroutine.  size is
+// the number of jobs that can wait before Submit blocks.
+func NewQueue(size int) *Queue {
+	q := &Queue{
+		jobs:   make(map[int]Job),
+		work:   make(chan queued, size),
+		nextId: 1,
+	}
+	go q.run()
+
+	return q
+}
+
+// Submit queues a job and returns it
+func (q *Queue) Submit(kind string, fn Func) Job {
+	q.mu.Lock()
+	job := Job{
+		JobId:   q.nextId,
+		Kind:    kind,
+		Status:  StatusQueued,
+		Created: time.Now(),
+	}
+	q.nextId++
+	q.jobs[job.JobId] = job
+	q.mu.Unlock()
+
+	q.work <- queued{id: job.JobId, fn: fn}
+	return job
+}
+
+// Get returns a job by id
+func (q *Queue) Get(id int) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errors.New("job does not exist")
+	}
+
+	return job, nil
+}
+
+// GetAll returns all jobs ordered by id
+func (q *Queue) GetAll() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].JobId < jobs[j].JobId
+	})
+
+	return jobs
+}
+
+// update applies a change to a stored job
+func (q *Queue) update(id int, change func(job *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.jobs[id]
+	change(&job)
+	q.jobs[id] = job
+}
+
+// run is the worker loop
+func (q *Queue) run() {
+	for item := range q.work {
+		q.update(item.id, func(job *Job) {
+			job.Status = StatusRunning
+			job.Started = time.Now()
+		})
+
+		result, err := item.fn()
+
+		q.update(item.id, func(job *Job) {
+			job.Finished = time.Now()
+			job.Result = result
+			job.Status = StatusDone
+			if err != nil {
+				job.Status = StatusFailed
+				job.Error = err.Error()
+			}
+		})
+	}
+}
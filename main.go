@@ -5,18 +5,62 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/db/postgres"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag           string
+	portFlag           uint
+	ballotKeyFlag      string
+	segmentRefreshFlag time.Duration
+	accessConfigFlag   string
+	linkSecretFlag     string
+	archiveDirFlag     string
+	selfTestFlag       bool
+	capacityFlag       int
+	shadowDirFlag      string
+	slowThresholdFlag  time.Duration
+	siemDestFlag       string
+	siemFormatFlag     string
+	publishFlag        string
+	recordFlag         string
+	recordSampleFlag   float64
+	webauthnRPIDFlag   string
+	webauthnOriginFlag string
+	ipRulesFlag        string
+	sloConfigFlag      string
+	storageFlag        string
+	postgresFlag       string
+	boltFlag           string
+	mongoFlag          string
+	exportKeysFlag     string
+	exportSignFlag     string
+	putCreatesFlag     bool
+	immutableFlag      bool
+	rehearsalFlag      bool
+	reviewSLAFlag      time.Duration
+	historyQuotaFlag   int
+	historyWindowFlag  time.Duration
+	revisionLimitFlag  int
+	voteMaxAgeFlag     time.Duration
+	voteMaxAheadFlag   time.Duration
+	voteIdsFlag        string
+	pgMaxConnsFlag     int
+	pgMinConnsFlag     int
+	pgConnLifetimeFlag time.Duration
+	pgConnIdleFlag     time.Duration
+	pgQueryTimeoutFlag time.Duration
 )
 
 // processCmdLineFlags parses the command line flags for our CLI
@@ -50,6 +94,48 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//When a ballot key is given, ballot choices are encrypted with it before
+	//they are stored, the API never sees the private half of the key
+	flag.StringVar(&ballotKeyFlag, "ballotkey", "", "Election public key (PEM) for encrypted ballot storage")
+	flag.DurationVar(&segmentRefreshFlag, "segment-refresh", time.Minute, "How often saved segments are recomputed")
+	flag.IntVar(&capacityFlag, "capacity", api.DefaultCapacity, "Requests handled at the same time before low priority work is shed")
+	flag.DurationVar(&slowThresholdFlag, "slow-threshold", api.DefaultSlowThreshold, "Requests slower than this are kept at /admin/slow-requests, 0 turns it off")
+	flag.BoolVar(&selfTestFlag, "selftest", false, "Run the self test at startup and exit if it fails")
+	flag.StringVar(&siemDestFlag, "siem", "", "SIEM destination for security events, udp://host:port, tcp://host:port or file:///path")
+	flag.StringVar(&siemFormatFlag, "siem-format", "syslog", "SIEM record format, syslog (RFC 5424) or cef")
+	flag.StringVar(&publishFlag, "publish", "", "Broker to publish voter and vote changes to, nats://host:port/subject or kafka://host:port/topic")
+	flag.StringVar(&recordFlag, "record", "", "File the anonymized shape of the served traffic is recorded to, for voterctl replay")
+	flag.Float64Var(&recordSampleFlag, "record-sample", 1, "Share of requests recorded with -record, between 0 and 1")
+	flag.StringVar(&shadowDirFlag, "shadow", "", "Directory of a shadow store that gets every write and is compared on every read")
+	flag.StringVar(&storageFlag, "storage", os.Getenv("STORAGE"), "Voter store: memory, bolt, postgres or mongo, defaults to $STORAGE or the backend flag that is set")
+	flag.StringVar(&mongoFlag, "mongo", os.Getenv("MONGO_URI"), "MongoDB connection URI for the mongo voter store, defaults to $MONGO_URI")
+	flag.StringVar(&boltFlag, "bolt", "", "bbolt file to store voters in instead of memory, created if missing")
+	flag.StringVar(&postgresFlag, "postgres", "", "PostgreSQL connection string, stores voters in Postgres instead of memory")
+	flag.IntVar(&pgMaxConnsFlag, "pg-max-conns", 0, "Largest number of Postgres connections in the pool, 0 for the driver default")
+	flag.IntVar(&pgMinConnsFlag, "pg-min-conns", 0, "Postgres connections kept open when idle")
+	flag.DurationVar(&pgConnLifetimeFlag, "pg-conn-lifetime", 0, "Postgres connections are closed after this long, 0 for the driver default")
+	flag.DurationVar(&pgConnIdleFlag, "pg-conn-idle", 0, "Idle Postgres connections are closed after this long, 0 for the driver default")
+	flag.DurationVar(&pgQueryTimeoutFlag, "pg-query-timeout", postgres.DefaultQueryTimeout, "Bound on every Postgres call")
+	flag.StringVar(&archiveDirFlag, "archive", "", "Directory archived voters are moved to")
+	flag.StringVar(&webauthnRPIDFlag, "webauthn-rpid", "", "WebAuthn relying party id (the admin UI domain), enables security key login for admin users")
+	flag.StringVar(&webauthnOriginFlag, "webauthn-origin", "", "Comma separated origins the admin UI is served from, defaults to https://<rpid>")
+	flag.StringVar(&ipRulesFlag, "ip-rules", "", "Network filter config (JSON) with per route group CIDR allowlists and a starting denylist")
+	flag.StringVar(&sloConfigFlag, "slo", "", "SLO config (JSON) with per route objectives, tracked at /admin/slo")
+	flag.StringVar(&accessConfigFlag, "access", "", "Access control config (JSON) with API keys and role field visibility")
+	flag.StringVar(&linkSecretFlag, "link-secret", os.Getenv("LINK_SECRET"), "Key the opt-out and survey links sent to voters are signed with, defaults to $LINK_SECRET or a random key that changes on restart")
+	flag.StringVar(&exportKeysFlag, "export-recipients", "", "Armored OpenPGP public keys exports are encrypted to")
+	flag.StringVar(&exportSignFlag, "export-signing-key", "", "Armored OpenPGP private key exports are signed with, its passphrase is read from $EXPORT_SIGNING_PASSPHRASE")
+	flag.BoolVar(&putCreatesFlag, "put-creates", false, "PUT /voters/:id creates the voter when it does not exist instead of answering 404")
+	flag.BoolVar(&immutableFlag, "immutable-history", false, "Recorded votes can never be changed or deleted, mistakes are fixed with append only corrections")
+	flag.BoolVar(&rehearsalFlag, "rehearsal", false, "Run as an election rehearsal sandbox, voters are only kept in memory and nothing is sent to outside systems")
+	flag.IntVar(&historyQuotaFlag, "history-quota", db.DefaultHistoryQuota, "Changes to one voter's vote history allowed within -history-window before further changes are refused, 0 turns it off")
+	flag.DurationVar(&historyWindowFlag, "history-window", db.DefaultHistoryQuotaWindow, "Window the history quota is counted in")
+	flag.IntVar(&revisionLimitFlag, "revision-limit", db.DefaultRevisionLimit, "Voter revisions kept in memory for ?as_of= queries, the oldest are dropped beyond it, 0 keeps all")
+	flag.DurationVar(&voteMaxAgeFlag, "vote-max-age", db.DefaultVoteDateWindow.MaxAge, "Oldest vote date accepted in a vote history entry, counted back from now")
+	flag.DurationVar(&voteMaxAheadFlag, "vote-max-ahead", db.DefaultVoteDateWindow.MaxAhead, "How far in the future a vote date may be, allowing for device clocks that run fast")
+	flag.StringVar(&voteIdsFlag, "vote-ids", string(db.VoteIdsPerVoter), "What VoteIds are unique within, voter (each voter's votes numbered from 1) or global")
+	flag.DurationVar(&reviewSLAFlag, "review-sla", db.DefaultReviewSLA, "How long flagged items may wait in /admin/reviews before they count as overdue")
+
 	flag.Parse()
 }
 
@@ -59,9 +145,12 @@ func processCmdLineFlags() {
 func main() {
 	processCmdLineFlags()
 
-	app := fiber.New()
-	app.Use(cors.New())
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	//Browsers only let scripts read the paging and version headers when
+	//exposed
+	app.Use(cors.New(cors.Config{ExposeHeaders: "X-Total-Count, Link, API-Version"}))
 	app.Use(recover.New())
+	app.Use(requestid.New())
 
 	apiHandler, err := api.New()
 	if err != nil {
@@ -69,26 +158,425 @@ func main() {
 		os.Exit(1)
 	}
 
+	//A rehearsal is set up first, so every backend that would keep its
+	//data refuses to start
+	if rehearsalFlag {
+		if err := apiHandler.EnableRehearsal(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Rehearsal mode, voters are kept in memory only")
+	}
+
+	if ballotKeyFlag != "" {
+		if err := apiHandler.EnableBallotEncryption(ballotKeyFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Encrypted ballot storage enabled")
+	}
+
+	//The voter store is picked by -storage, or $STORAGE, or else by the
+	//backend flag that is set
+	storage := storageFlag
+	if storage == "" {
+		switch {
+		case boltFlag != "":
+			storage = "bolt"
+		case postgresFlag != "":
+			storage = "postgres"
+		default:
+			storage = "memory"
+		}
+	}
+	var storeErr error
+	switch storage {
+	case "memory":
+	case "bolt":
+		storeErr = apiHandler.EnableBolt(boltFlag)
+	case "postgres":
+		storeErr = apiHandler.EnablePostgres(postgres.Config{
+			DSN:             postgresFlag,
+			MaxConns:        int32(pgMaxConnsFlag),
+			MinConns:        int32(pgMinConnsFlag),
+			MaxConnLifetime: pgConnLifetimeFlag,
+			MaxConnIdleTime: pgConnIdleFlag,
+			QueryTimeout:    pgQueryTimeoutFlag,
+		})
+	case "mongo":
+		storeErr = apiHandler.EnableMongo(mongoFlag)
+	default:
+		storeErr = fmt.Errorf("unknown storage %q, use memory, bolt, postgres or mongo", storage)
+	}
+	if storeErr != nil {
+		fmt.Println(storeErr)
+		os.Exit(1)
+	}
+	log.Println("Voter store:", storage)
+
+	if archiveDirFlag != "" {
+		if err := apiHandler.EnableArchive(archiveDirFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Voter archive enabled")
+	}
+
+	if shadowDirFlag != "" {
+		if err := apiHandler.EnableShadow(shadowDirFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Shadow mode enabled")
+	}
+
+	if exportKeysFlag != "" || exportSignFlag != "" {
+		passphrase := []byte(os.Getenv("EXPORT_SIGNING_PASSPHRASE"))
+		if err := apiHandler.EnableExportSealing(exportKeysFlag, exportSignFlag, passphrase); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Export sealing enabled")
+	}
+
+	if siemDestFlag != "" {
+		if err := apiHandler.EnableSIEM(siemDestFlag, siemFormatFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("SIEM export enabled")
+	}
+
+	if publishFlag != "" {
+		if err := apiHandler.EnablePublisher(publishFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Change publishing enabled")
+	}
+
+	if recordFlag != "" {
+		if err := apiHandler.EnableRecording(recordFlag, recordSampleFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("Traffic recording enabled")
+	}
+
+	if accessConfigFlag != "" {
+		cfg, err := api.LoadAccessConfig(accessConfigFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		apiHandler.EnableAccessControl(cfg)
+		log.Println("Access control enabled")
+	}
+	apiHandler.SetLinkSecret(linkSecretFlag)
+
+	if ipRulesFlag != "" {
+		rules, err := api.LoadIPRules(ipRulesFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := apiHandler.EnableIPFilter(rules); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("IP allowlists enabled")
+	}
+
+	if sloConfigFlag != "" {
+		cfg, err := api.LoadSLOConfig(sloConfigFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := apiHandler.EnableSLOs(cfg); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("SLO tracking enabled")
+	}
+
+	if webauthnRPIDFlag != "" {
+		origins := []string{"https://" + webauthnRPIDFlag}
+		if webauthnOriginFlag != "" {
+			origins = strings.Split(webauthnOriginFlag, ",")
+		}
+		if err := apiHandler.EnableWebAuthn(webauthnRPIDFlag, origins); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		log.Println("WebAuthn admin login enabled")
+	}
+
+	if capacityFlag < 1 {
+		fmt.Println("capacity must be at least 1")
+		os.Exit(1)
+	}
+	if revisionLimitFlag < 0 {
+		fmt.Println("revision-limit can not be negative")
+		os.Exit(1)
+	}
+	if historyQuotaFlag > 0 && historyWindowFlag <= 0 {
+		fmt.Println("history-window must be positive")
+		os.Exit(1)
+	}
+	voteIdScope, err := db.ParseVoteIdScope(voteIdsFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	apiHandler.SetCapacity(capacityFlag)
+	apiHandler.SetSlowThreshold(slowThresholdFlag)
+	apiHandler.SetPutCreates(putCreatesFlag)
+	apiHandler.SetHistoryImmutable(immutableFlag)
+	apiHandler.SetReviewSLA(reviewSLAFlag)
+	apiHandler.SetHistoryQuota(historyQuotaFlag, historyWindowFlag)
+	apiHandler.SetRevisionLimit(revisionLimitFlag)
+	apiHandler.SetVoteDateWindow(db.VoteDateWindow{MaxAge: voteMaxAgeFlag, MaxAhead: voteMaxAheadFlag})
+	apiHandler.SetVoteIdScope(voteIdScope)
+	app.Use(apiHandler.NegotiateVersion)
+	app.Use(apiHandler.MarkRehearsal)
+	app.Use(apiHandler.RecordTraffic)
+	app.Use(apiHandler.TrackSLOs)
+	app.Use(apiHandler.SlowRequests)
+	app.Use(apiHandler.RateLimit)
+	app.Use(apiHandler.Prioritize)
+	app.Use(apiHandler.SecurityEvents)
+	app.Use(apiHandler.IPFilter)
+	app.Use(apiHandler.SealExports)
+	app.Use(apiHandler.BundleExports)
+	app.Use(apiHandler.AccessControl)
+	app.Use(apiHandler.UIActions)
+	app.Use(apiHandler.Maintenance)
+
+	if selfTestFlag {
+		for _, result := range apiHandler.SelfTest() {
+			log.Println("Self test", result.Component, "passed:", result.Passed, result.Error)
+			if !result.Passed {
+				os.Exit(1)
+			}
+		}
+	}
+
+	apiHandler.StartSegmentRefresh(segmentRefreshFlag)
+	apiHandler.EnableGraphQL(app)
+
 	//HTTP Standards for "REST" APIS
 	//GET - Read/Query
 	//POST - Create
 	//PUT - Update
 	//DELETE - Delete
 
-	app.Get("/voters", apiHandler.ListAllVoters)
-	app.Get("/voters/:id<int>", apiHandler.GetVoter)
+	app.Get("/voters", apiHandler.Coalesce(apiHandler.ListAllVoters))
+	app.Get("/voters/search", apiHandler.SearchVoters)
+	app.Get("/voters/export", apiHandler.ExportVoters)
+	app.Get("/voters/count", apiHandler.CountVoters)
+	app.Get("/voters/openapi.json", apiHandler.GetOpenAPI)
+	app.Get("/voters/docs", apiHandler.GetDocs)
+	app.Get("/voters/ws", apiHandler.WatchVoters)
+	app.Get("/voters/events", apiHandler.StreamVoterEvents)
+	app.Post("/voters/tags", apiHandler.BulkTagVoters)
+	app.Get("/voters/:id<int>", apiHandler.Coalesce(apiHandler.GetVoter))
+	app.Get("/voters/by-email/:email", apiHandler.GetVoterByEmail)
 	app.Post("/voters", apiHandler.PostVoter)
 	app.Get("/voters/:id<int>/polls", apiHandler.GetVoterPolls)
 	app.Get("/voters/:id<int>/polls/:pollid<int>", apiHandler.GetVoterPoll)
-	app.Post("/voters/:id<int>/polls/:pollid<int>", apiHandler.PostVoterPoll)
+	app.Post("/voters/:id<int>/polls/:pollid<int>", apiHandler.PollPartition, apiHandler.SerializeVoter, apiHandler.PostVoterPoll)
+	app.Get("/voters/:id<int>/polls/:pollid<int>/corrections", apiHandler.GetVoteCorrections)
+	app.Post("/voters/:id<int>/polls/:pollid<int>/corrections", apiHandler.PostVoteCorrection)
 
-	app.Put("/voters/:id<int>", apiHandler.UpdateVoter)
-	app.Delete("/voters", apiHandler.DeleteAllVoters)
-	app.Delete("/voters/:id<int>", apiHandler.DeleteVoter)
-	app.Put("/voters/:id<int>/polls/:pollid<int>", apiHandler.UpdateVoterPoll)
-	app.Delete("/voters/:id<int>/polls/:pollid<int>", apiHandler.DeleteVoterPoll)
+	app.Put("/voters/:id<int>", apiHandler.SerializeVoter, apiHandler.UpdateVoter)
+	app.Patch("/voters/:id<int>", apiHandler.SerializeVoter, apiHandler.PatchVoter)
+	app.Post("/voters/:id<int>/move", apiHandler.SerializeVoter, apiHandler.MoveVoter)
+	app.Get("/voters/:id<int>/polling-place", apiHandler.GetVoterPollingPlace)
+	app.Get("/voters/:id<int>/profile", apiHandler.GetVoterProfile)
+	app.Get("/voters/:id<int>/eligibility", apiHandler.GetVoterEligibility)
+	app.Post("/voters/:id<int>/opt-out", apiHandler.SerializeVoter, apiHandler.OptOutVoter)
+	app.Get("/voters/:id<int>/preferences", apiHandler.GetPreferences)
+	app.Get("/voters/:id<int>/messages", apiHandler.GetVoterMessages)
+	app.Put("/voters/:id<int>/preferences", apiHandler.SerializeVoter, apiHandler.UpdatePreferences)
+	app.Get("/voters/:id<int>/preferences/history", apiHandler.GetConsentHistory)
+	app.Put("/voters/:id<int>/legal-hold", apiHandler.SerializeVoter, apiHandler.SetLegalHold)
+	app.Get("/voters/:id<int>/access-log", apiHandler.GetAccessLog)
+	app.Get("/voters/:id<int>/data-export", apiHandler.ExportVoterData)
+	app.Delete("/voters", apiHandler.RequireStepUp, apiHandler.DeleteAllVoters)
+	app.Delete("/voters/:id<int>", apiHandler.SerializeVoter, apiHandler.DeleteVoter)
+	app.Put("/voters/:id<int>/polls/:pollid<int>", apiHandler.PollPartition, apiHandler.SerializeVoter, apiHandler.UpdateVoterPoll)
+	app.Delete("/voters/:id<int>/polls/:pollid<int>", apiHandler.PollPartition, apiHandler.SerializeVoter, apiHandler.DeleteVoterPoll)
+	app.Post("/voters/:id<int>/polls\\:sync", apiHandler.SerializeVoter, apiHandler.SyncVoterPolls)
+	app.Patch("/voters/:id<int>/polls", apiHandler.SerializeVoter, apiHandler.PatchVoterPolls)
 
 	app.Get("voters/health", apiHandler.HealthCheck)
+	app.Get("/about", apiHandler.About)
+
+	app.Get("/jurisdictions", apiHandler.ListJurisdictions)
+	app.Get("/jurisdictions/:id<int>", apiHandler.GetJurisdiction)
+	app.Post("/jurisdictions", apiHandler.PostJurisdiction)
+	app.Put("/jurisdictions/:id<int>", apiHandler.UpdateJurisdiction)
+	app.Delete("/jurisdictions/:id<int>", apiHandler.DeleteJurisdiction)
+	app.Get("/jurisdictions/:id<int>/polls", apiHandler.GetJurisdictionPolls)
+	app.Get("/jurisdictions/:id<int>/stats", apiHandler.GetJurisdictionStats)
+
+	app.Get("/polling-places", apiHandler.ListPollingPlaces)
+	app.Get("/polling-places/:id<int>", apiHandler.GetPollingPlace)
+	app.Post("/polling-places", apiHandler.PostPollingPlace)
+	app.Put("/polling-places/:id<int>", apiHandler.UpdatePollingPlace)
+	app.Delete("/polling-places/:id<int>", apiHandler.DeletePollingPlace)
+
+	app.Get("/precincts/:id<int>/manifest", apiHandler.GetPrecinctManifest)
+
+	app.Get("/elections", apiHandler.ListElections)
+	app.Get("/elections/:id<int>", apiHandler.GetElection)
+	app.Post("/elections", apiHandler.PostElection)
+	app.Put("/elections/:id<int>", apiHandler.UpdateElection)
+	app.Delete("/elections/:id<int>", apiHandler.DeleteElection)
+	app.Get("/elections/:id<int>/calendar.ics", apiHandler.GetElectionCalendar)
+	app.Post("/elections/:id<int>/transition", apiHandler.TransitionElection)
+
+	app.Get("/polls", apiHandler.ListPolls)
+	app.Get("/polls/:id<int>", apiHandler.GetPoll)
+	app.Post("/polls", apiHandler.PostPoll)
+	app.Put("/polls/:id<int>", apiHandler.UpdatePoll)
+	app.Delete("/polls/:id<int>", apiHandler.DeletePoll)
+	app.Put("/polls/:id<int>/translations/:locale", apiHandler.PutPollTranslation)
+	app.Delete("/polls/:id<int>/translations/:locale", apiHandler.DeletePollTranslation)
+	app.Post("/polls/:id<int>/survey", apiHandler.PostSurveyResponse)
+	app.Get("/polls/:id<int>/survey/results", apiHandler.GetSurveyResults)
+	app.Get("/polls/:id<int>/turnout", apiHandler.GetTurnout)
+	app.Post("/polls/:id<int>/extend", apiHandler.ExtendPoll)
+	app.Get("/polls/:id<int>/extensions", apiHandler.ListExtensions)
+	app.Post("/polls/:id<int>/extensions/:ext<int>/approve", apiHandler.ApproveExtension)
+	app.Post("/polls/:id<int>/extensions/:ext<int>/reject", apiHandler.RejectExtension)
+
+	app.Post("/segments", apiHandler.PostSegment)
+	app.Get("/segments", apiHandler.ListSegments)
+	app.Get("/segments/:name", apiHandler.GetSegment)
+	app.Delete("/segments/:name", apiHandler.DeleteSegment)
+	app.Get("/segments/:name/count", apiHandler.GetSegmentCount)
+	app.Get("/segments/:name/voters", apiHandler.ListSegmentVoters)
+	app.Get("/segments/:name/counts", apiHandler.GetSegmentCounts)
+
+	app.Get("/jobs", apiHandler.ListJobs)
+	app.Get("/jobs/:id<int>", apiHandler.GetJob)
+
+	app.Get("/admin/checksum", apiHandler.GetChecksum)
+	app.Get("/admin/checksum/buckets/:bucket<int>", apiHandler.GetChecksumBucket)
+	app.Get("/admin/export/ballots", apiHandler.ExportBallots)
+	app.Get("/admin/export/voters", apiHandler.ExportVoterBackup)
+	app.Post("/admin/import/voters", apiHandler.RequireStepUp, apiHandler.ImportVoters)
+	app.Post("/admin/archive", apiHandler.ArchiveVoters)
+	app.Post("/admin/selftest", apiHandler.RunSelfTest)
+	app.Get("/admin/voters/:id<int>/links", apiHandler.GetVoterLinks)
+	app.Get("/admin/maintenance", apiHandler.GetMaintenance)
+	app.Put("/admin/maintenance", apiHandler.SetMaintenance)
+	app.Get("/admin/partitions", apiHandler.GetPartitions)
+	app.Get("/admin/load", apiHandler.GetLoad)
+	app.Get("/admin/ratelimits", apiHandler.GetRateLimits)
+	app.Get("/admin/apikeys", apiHandler.ListAPIKeys)
+	app.Post("/admin/apikeys", apiHandler.PostAPIKey)
+	app.Get("/admin/apikeys/:name", apiHandler.GetAPIKey)
+	app.Put("/admin/apikeys/:name", apiHandler.UpdateAPIKey)
+	app.Delete("/admin/apikeys/:name", apiHandler.DeleteAPIKey)
+	app.Post("/admin/apikeys/:name/rotate", apiHandler.RequireStepUp, apiHandler.RotateAPIKey)
+	app.Post("/admin/apikeys/:name/revoke", apiHandler.RevokeAPIKey)
+	app.Get("/admin/webhooks", apiHandler.ListWebhooks)
+	app.Post("/admin/webhooks", apiHandler.PostWebhook)
+	app.Get("/admin/webhooks/:name", apiHandler.GetWebhook)
+	app.Put("/admin/webhooks/:name", apiHandler.UpdateWebhook)
+	app.Delete("/admin/webhooks/:name", apiHandler.DeleteWebhook)
+	app.Post("/admin/webhooks/:name/rotate", apiHandler.RequireStepUp, apiHandler.RotateWebhookSecret)
+	app.Get("/admin/webhooks/:name/deliveries", apiHandler.GetWebhookDeliveries)
+	app.Get("/admin/slow-requests", apiHandler.GetSlowRequests)
+	app.Get("/admin/slo", apiHandler.GetSLOs)
+	app.Get("/admin/history-quota", apiHandler.GetHistoryQuota)
+	app.Get("/admin/shadow", apiHandler.GetShadowStats)
+	app.Get("/admin/siem", apiHandler.GetSIEMStats)
+	app.Get("/admin/publisher", apiHandler.GetPublisherStats)
+	app.Get("/admin/rehearsal", apiHandler.GetRehearsal)
+	app.Post("/admin/rehearsal/seed", apiHandler.SeedRehearsal)
+	app.Post("/admin/rehearsal/traffic", apiHandler.StartRehearsalTraffic)
+	app.Delete("/admin/rehearsal/traffic", apiHandler.StopRehearsalTraffic)
+	app.Post("/admin/rehearsal/wipe", apiHandler.WipeRehearsal)
+	app.Get("/admin/recording", apiHandler.GetRecordingStats)
+	app.Get("/admin/denylist", apiHandler.GetDenyList)
+	app.Post("/admin/denylist", apiHandler.PostDenyEntry)
+	app.Delete("/admin/denylist/:id<int>", apiHandler.DeleteDenyEntry)
+	app.Post("/admin/decoys", apiHandler.PostDecoys)
+	app.Get("/admin/decoys", apiHandler.ListDecoys)
+	app.Get("/admin/decoys/alerts", apiHandler.GetTripwireAlerts)
+	app.Post("/admin/exclusions", apiHandler.PostExclusionList)
+	app.Post("/admin/exclusions/:id<int>/match", apiHandler.MatchExclusionList)
+	app.Get("/admin/exclusions/reports/:id<int>", apiHandler.GetExclusionReport)
+	app.Post("/admin/devices", apiHandler.PostDevice)
+	app.Get("/admin/devices", apiHandler.ListDevices)
+	app.Get("/admin/devices/skew", apiHandler.GetDeviceSkew)
+	app.Get("/admin/devices/:id<int>", apiHandler.GetDevice)
+	app.Put("/admin/devices/:id<int>", apiHandler.UpdateDevice)
+	app.Delete("/admin/devices/:id<int>", apiHandler.DeleteDevice)
+	app.Post("/admin/devices/:id<int>/expire", apiHandler.ExpireDevice)
+	app.Post("/admin/devices/:id<int>/revoke", apiHandler.RevokeDevice)
+	app.Post("/admin/campaigns", apiHandler.PostCampaign)
+	app.Get("/admin/campaigns", apiHandler.ListCampaigns)
+	app.Get("/admin/campaigns/:id<int>", apiHandler.GetCampaign)
+	app.Post("/admin/campaigns/:id<int>/events", apiHandler.PostCampaignEvents)
+	app.Get("/admin/campaigns/:id<int>/stats", apiHandler.GetCampaignStats)
+	app.Post("/admin/templates", apiHandler.PostTemplate)
+	app.Get("/admin/templates", apiHandler.ListTemplates)
+	app.Get("/admin/templates/:name", apiHandler.GetTemplate)
+	app.Put("/admin/templates/:name", apiHandler.UpdateTemplate)
+	app.Delete("/admin/templates/:name", apiHandler.DeleteTemplate)
+	app.Get("/admin/templates/:name/versions", apiHandler.GetTemplateVersions)
+	app.Post("/admin/templates/:name/preview", apiHandler.PreviewTemplate)
+	app.Post("/admin/legal-holds", apiHandler.SetLegalHoldByFilter)
+	app.Get("/admin/provenance", apiHandler.GetProvenanceRules)
+	app.Put("/admin/provenance", apiHandler.SetProvenanceRules)
+	app.Get("/admin/provenance/conflicts", apiHandler.GetProvenanceConflicts)
+	app.Get("/admin/reviews", apiHandler.GetReviews)
+	app.Get("/admin/reviews/metrics", apiHandler.GetReviewMetrics)
+	app.Get("/admin/reviews/:id<int>", apiHandler.GetReview)
+	app.Post("/admin/reviews/:id<int>/assign", apiHandler.AssignReview)
+	app.Post("/admin/reviews/:id<int>/resolve", apiHandler.ResolveReview)
+	app.Post("/admin/boundary-changes", apiHandler.PostBoundaryChange)
+	app.Get("/admin/audit", apiHandler.GetAuditLog)
+	app.Get("/admin/ui-actions", apiHandler.GetUIActions)
+
+	app.Post("/admin/users", apiHandler.PostUser)
+	app.Get("/admin/users", apiHandler.ListUsers)
+	app.Get("/admin/users/:id<int>", apiHandler.GetUser)
+	app.Get("/admin/users/:id<int>/credentials", apiHandler.ListCredentials)
+	app.Post("/admin/users/:id<int>/credentials/begin", apiHandler.BeginCredential)
+	app.Post("/admin/users/:id<int>/credentials/finish", apiHandler.FinishCredential)
+	app.Delete("/admin/users/:id<int>/credentials/:credid", apiHandler.DeleteCredential)
+
+	app.Post("/auth/step-up", apiHandler.StepUp)
+	app.Post("/auth/step-up/webauthn/begin", apiHandler.BeginStepUp)
+	app.Post("/auth/step-up/webauthn/finish", apiHandler.FinishStepUp)
+	app.Post("/auth/token", apiHandler.PostToken)
+	app.Get("/graphql", apiHandler.GetGraphQL)
+	app.Post("/graphql", apiHandler.PostGraphQL)
+	app.Get("/graphql/schema", apiHandler.GetGraphQLSchema)
+	app.Post("/auth/webauthn/login/begin", apiHandler.BeginLogin)
+	app.Post("/auth/webauthn/login/finish", apiHandler.FinishLogin)
+
+	//Field devices enroll with the one time token from /admin/devices and
+	//then authenticate every request with their device credential
+	app.Post("/devices/enroll", apiHandler.EnrollDevice)
+	devices := app.Group("/devices", apiHandler.DeviceAuth)
+	devices.Post("/heartbeat", apiHandler.DeviceHeartbeat)
+
+	//Kiosks get their own route group, every request has to carry a kiosk
+	//credential and nothing outside this group accepts one
+	kiosk := app.Group("/kiosk", apiHandler.DeviceAuth, api.KioskOnly)
+	kiosk.Get("/voters", apiHandler.KioskSearch)
+	kiosk.Post("/voters/:id<int>/checkin", apiHandler.KioskCheckIn)
+
+	app.Post("/precincts/:id<int>/queue-metrics", apiHandler.DeviceAuth, apiHandler.PostQueueMetrics)
+	app.Get("/precincts/wait-times", apiHandler.ListWaitTimes)
+	app.Get("/precincts/:id<int>/wait-time", apiHandler.GetWaitTime)
 
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	log.Println("Starting server on ", serverPath)
@@ -0,0 +1,46 @@
+// Package notify sends messages to voters.  The rest of the API only deals
+// with the Notifier interface, so the delivery provider (SMTP, an email
+// service, an SMS gateway) can be swapped without touching the handlers.
+package notify
+
+import (
+	"errors"
+	"log"
+)
+
+// Channels a message can be sent on
+const (
+	ChannelEmail = "email"
+)
+
+// Message is a single message to a single recipient
+type Message struct {
+	Channel string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// LogNotifier is a Notifier that writes messages to the log instead of
+// delivering them, it is the default until a real provider is configured
+type LogNotifier struct{}
+
+// constructor for LogNotifier struct
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+// Send logs the message
+func (n *LogNotifier) Send(msg Message) error {
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+
+	log.Printf("notify: %s to %s: %s", msg.Channel, msg.To, msg.Subject)
+	return nil
+}
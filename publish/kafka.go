@@ -0,0 +1,331 @@
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Kafka API keys and the versions spoken, see
+// https://kafka.apache.org/protocol
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3 //The first with record batches
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 1
+)
+
+// kafkaPartition is the partition every message goes to
+const kafkaPartition = 0
+
+// kafkaClientId names the publisher to the brokers
+const kafkaClientId = "voter-api"
+
+// kafkaAckTimeout is how long the leader waits for the replicas to
+// acknowledge a message
+const kafkaAckTimeout = 10 * time.Second
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaConn is a connection to the leader of the partition
+type kafkaConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	topic       string
+	correlation int32
+}
+
+// dialKafka asks the bootstrap broker for the leader of the partition and
+// connects to it
+func dialKafka(bootstrap, topic string) (*kafkaConn, error) {
+	if _, _, err := net.SplitHostPort(bootstrap); err != nil {
+		bootstrap = net.JoinHostPort(bootstrap, "9092")
+	}
+	k, err := openKafka(bootstrap, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	leader, err := k.leader()
+	if err != nil {
+		k.close()
+		return nil, err
+	}
+	if leader == bootstrap {
+		return k, nil
+	}
+
+	k.close()
+	return openKafka(leader, topic)
+}
+
+// openKafka connects to a broker
+func openKafka(addr, topic string) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{conn: conn, r: bufio.NewReader(conn), topic: topic}, nil
+}
+
+// leader returns the address of the leader of the partition.  A topic the
+// broker creates on first use has no leader for a moment, that is an error
+// the publisher retries.
+func (k *kafkaConn) leader() (string, error) {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(k.topic)
+
+	rsp, err := k.roundTrip(kafkaMetadata, kafkaMetadataVersion, req.buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	brokers := make(map[int32]string)
+	for n := rsp.int32(); n > 0 && rsp.err == nil; n-- {
+		id := rsp.int32()
+		host := rsp.string()
+		port := rsp.int32()
+		rsp.string() //rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	rsp.int32() //controller
+
+	for n := rsp.int32(); n > 0 && rsp.err == nil; n-- {
+		if code := rsp.int16(); code != 0 {
+			return "", kafkaError("metadata of topic "+k.topic, code)
+		}
+		rsp.string() //name
+		rsp.int8()   //internal
+		for p := rsp.int32(); p > 0 && rsp.err == nil; p-- {
+			code := rsp.int16()
+			partition := rsp.int32()
+			leader := rsp.int32()
+			rsp.skipInt32s() //replicas
+			rsp.skipInt32s() //in sync replicas
+			if partition != kafkaPartition {
+				continue
+			}
+			if code != 0 {
+				return "", kafkaError("metadata of topic "+k.topic, code)
+			}
+			if addr, ok := brokers[leader]; ok {
+				return addr, nil
+			}
+		}
+	}
+	if rsp.err != nil {
+		return "", rsp.err
+	}
+	return "", fmt.Errorf("kafka: no leader for partition %d of topic %s", kafkaPartition, k.topic)
+}
+
+// publish produces a message to the partition and waits until all in sync
+// replicas have it.  The event goes in an event header of the record.
+func (k *kafkaConn) publish(msg Message) error {
+	batch := recordBatch(msg, time.Now())
+
+	var req kafkaEncoder
+	req.int16(-1) //No transaction
+	req.int16(-1) //acks=all
+	req.int32(int32(kafkaAckTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.topic)
+	req.int32(1)
+	req.int32(kafkaPartition)
+	req.bytes(batch)
+
+	rsp, err := k.roundTrip(kafkaProduce, kafkaProduceVersion, req.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	for n := rsp.int32(); n > 0 && rsp.err == nil; n-- {
+		rsp.string() //topic
+		for p := rsp.int32(); p > 0 && rsp.err == nil; p-- {
+			rsp.int32() //partition
+			if code := rsp.int16(); code != 0 {
+				return kafkaError("produce to topic "+k.topic, code)
+			}
+			rsp.int64() //base offset
+			rsp.int64() //log append time
+		}
+	}
+	return rsp.err
+}
+
+// close closes the connection
+func (k *kafkaConn) close() {
+	k.conn.Close()
+}
+
+// roundTrip sends a request and returns the body of its response
+func (k *kafkaConn) roundTrip(apiKey, version int16, body []byte) (*kafkaDecoder, error) {
+	k.correlation++
+
+	var req kafkaEncoder
+	req.int32(0) //Size, set below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(k.correlation)
+	req.string(kafkaClientId)
+	req.buf.Write(body)
+	frame := req.buf.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	k.conn.SetDeadline(time.Now().Add(dialTimeout + kafkaAckTimeout))
+	defer k.conn.SetDeadline(time.Time{})
+	if _, err := k.conn.Write(frame); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(k.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, errors.New("kafka: short response")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(k.r, data); err != nil {
+		return nil, err
+	}
+
+	rsp := &kafkaDecoder{data: data}
+	if correlation := rsp.int32(); correlation != k.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", correlation, k.correlation)
+	}
+	return rsp, nil
+}
+
+// recordBatch encodes a message as a record batch of one record, magic 2
+func recordBatch(msg Message, at time.Time) []byte {
+	var record kafkaEncoder
+	record.int8(0)   //Attributes
+	record.varint(0) //Timestamp delta
+	record.varint(0) //Offset delta
+	record.varbytes([]byte(msg.Key))
+	record.varbytes(msg.Body)
+	record.varint(1) //Headers
+	record.varbytes([]byte("event"))
+	record.varbytes([]byte(msg.Event))
+
+	//Everything after the CRC, which covers it
+	var tail kafkaEncoder
+	tail.int16(0) //Attributes, no compression
+	tail.int32(0) //Last offset delta
+	tail.int64(at.UnixMilli())
+	tail.int64(at.UnixMilli())
+	tail.int64(-1) //Producer id
+	tail.int16(-1) //Producer epoch
+	tail.int32(-1) //Base sequence
+	tail.int32(1)
+	tail.varint(int64(record.buf.Len()))
+	tail.buf.Write(record.buf.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0) //Base offset, set by the broker
+	batch.int32(int32(4 + 1 + 4 + tail.buf.Len()))
+	batch.int32(-1) //Partition leader epoch
+	batch.int8(2)   //Magic
+	batch.int32(int32(crc32.Checksum(tail.buf.Bytes(), castagnoli)))
+	batch.buf.Write(tail.buf.Bytes())
+	return batch.buf.Bytes()
+}
+
+// kafkaError describes an error code of a response
+func kafkaError(what string, code int16) error {
+	names := map[int16]string{
+		3:  "UNKNOWN_TOPIC_OR_PARTITION",
+		5:  "LEADER_NOT_AVAILABLE",
+		6:  "NOT_LEADER_OR_FOLLOWER",
+		7:  "REQUEST_TIMED_OUT",
+		19: "NOT_ENOUGH_REPLICAS",
+		29: "TOPIC_AUTHORIZATION_FAILED",
+	}
+	name, ok := names[code]
+	if !ok {
+		name = "error code " + strconv.Itoa(int(code))
+	}
+	return fmt.Errorf("kafka: %s: %s", what, name)
+}
+
+// kafkaEncoder writes the big endian types of the Kafka protocol
+type kafkaEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf.WriteByte(byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { binary.Write(&e.buf, binary.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { binary.Write(&e.buf, binary.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { binary.Write(&e.buf, binary.BigEndian, v) }
+
+// string writes a string with an int16 length
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.buf.WriteString(v)
+}
+
+// bytes writes bytes with an int32 length
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.buf.Write(v)
+}
+
+// varint writes a zig-zag varint, as records use
+func (e *kafkaEncoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+// varbytes writes bytes with a varint length
+func (e *kafkaEncoder) varbytes(v []byte) {
+	e.varint(int64(len(v)))
+	e.buf.Write(v)
+}
+
+// kafkaDecoder reads the big endian types of the Kafka protocol, the first
+// read past the end sets err and every later read returns zero
+type kafkaDecoder struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.data) {
+		if d.err == nil {
+			d.err = errors.New("kafka: truncated response")
+		}
+		return make([]byte, max(n, 8))
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.next(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.next(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.next(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.next(8))) }
+
+// string reads a string with an int16 length, -1 is null
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// skipInt32s skips an array of int32
+func (d *kafkaDecoder) skipInt32s() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
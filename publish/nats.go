@@ -0,0 +1,137 @@
+package publish
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsConn is a connection to a NATS server speaking the core client
+// protocol, see https://docs.nats.io/reference/reference-protocols/nats-protocol
+type natsConn struct {
+	conn    net.Conn
+	subject string
+
+	mu     sync.Mutex //Serializes writes, the reader answers PINGs
+	failed error      //Set by the reader when the server refused something
+}
+
+// dialNATS connects to a NATS server, user and password or a token go in
+// the userinfo of the URL
+func dialNATS(dest *url.URL, subject string) (*natsConn, error) {
+	host := dest.Host
+	if dest.Port() == "" {
+		host = net.JoinHostPort(dest.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	r := bufio.NewReader(conn)
+
+	//The server starts with an INFO, the client answers with CONNECT and a
+	//PING, whose PONG confirms the server took the CONNECT
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("NATS server did not send INFO: %v", err)
+	}
+
+	options := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "voter-api",
+		"lang":     "go",
+		"version":  "1",
+		"protocol": 0,
+	}
+	if user := dest.User; user != nil {
+		if password, ok := user.Password(); ok {
+			options["user"] = user.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, errors.New("NATS server refused the connection: " + line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	n := &natsConn{conn: conn, subject: subject}
+	go n.read(r)
+	return n, nil
+}
+
+// read answers the server's PINGs and notes its errors, until the
+// connection closes
+func (n *natsConn) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			n.mu.Lock()
+			n.conn.Write([]byte("PONG\r\n"))
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Println("Error from NATS server: ", line)
+			n.mu.Lock()
+			n.failed = errors.New("NATS server error: " + line)
+			n.mu.Unlock()
+		}
+	}
+}
+
+// publish sends a message to <subject>.<event>.  Core NATS does not
+// acknowledge a message, a write that fails or an error the server sent
+// since the last message is what tells the publisher to reconnect.
+func (n *natsConn) publish(msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.failed != nil {
+		return n.failed
+	}
+
+	n.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	w := bufio.NewWriter(n.conn)
+	fmt.Fprintf(w, "PUB %s.%s %d\r\n", n.subject, msg.Event, len(msg.Body))
+	w.Write(msg.Body)
+	w.WriteString("\r\n")
+	return w.Flush()
+}
+
+// close closes the connection
+func (n *natsConn) close() {
+	n.conn.Close()
+}
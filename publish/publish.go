@@ -0,0 +1,158 @@
+// Package publish publishes the changes of the roll to a message broker,
+// so downstream systems such as analytics or the votes service consume
+// them without polling.  Two brokers are spoken natively, NATS, where a
+// change goes to <subject>.<event>, and Kafka, where it goes to partition
+// 0 of a topic so consumers see the changes in order.
+//
+// Publishing happens in the background through a bounded buffer, a slow
+// or unreachable broker never holds up a request.  A message the broker
+// fails is tried again on a new connection until it goes through, so a
+// consumer may see a message twice, messages that do not fit in the
+// buffer meanwhile are dropped and counted.
+package publish
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a change on its way to the broker
+type Message struct {
+	Event string //e.g. voter.created
+	Key   string //Kafka record key, the voter id
+	Body  []byte //JSON
+}
+
+// Stats are the counters of a publisher
+type Stats struct {
+	Queued    int64
+	Published int64
+	Retried   int64 //Attempts the broker failed, the message was tried again
+	Dropped   int64 //Messages that did not fit in the buffer
+}
+
+// broker is a connection to a broker
+type broker interface {
+	publish(msg Message) error
+	close()
+}
+
+// maxBackoff is the longest wait between reconnect attempts
+const maxBackoff = 30 * time.Second
+
+// dialTimeout bounds connecting to a broker and each of its answers
+const dialTimeout = 5 * time.Second
+
+// Publisher sends messages to the destination
+type Publisher struct {
+	dest     *url.URL
+	target   string //NATS subject or Kafka topic
+	messages chan Message
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// constructor for Publisher struct.  dest is nats://host:port/subject or
+// kafka://host:port/topic, the host of a Kafka destination is a bootstrap
+// broker.  buffer is the number of messages that may wait to be sent.  It
+// starts the sender goroutine.
+func NewPublisher(dest string, buffer int) (*Publisher, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" && u.Scheme != "kafka" {
+		return nil, errors.New("publish destination must be nats:// or kafka://")
+	}
+	target := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || target == "" || strings.ContainsAny(target, " \t\r\n/") {
+		return nil, errors.New("publish destination needs a host and a subject or topic, e.g. " + u.Scheme + "://localhost/voters")
+	}
+
+	p := &Publisher{
+		dest:     u,
+		target:   target,
+		messages: make(chan Message, buffer),
+	}
+	go p.run()
+
+	return p, nil
+}
+
+// Broker returns the kind of broker, nats or kafka
+func (p *Publisher) Broker() string {
+	return p.dest.Scheme
+}
+
+// Publish queues a message, it never blocks.  If the buffer is full the
+// message is dropped.
+func (p *Publisher) Publish(msg Message) {
+	select {
+	case p.messages <- msg:
+		p.count(func(s *Stats) { s.Queued++ })
+	default:
+		p.count(func(s *Stats) { s.Dropped++ })
+	}
+}
+
+// Stats returns the publisher counters
+func (p *Publisher) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// count updates the counters
+func (p *Publisher) count(change func(s *Stats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	change(&p.stats)
+}
+
+// open connects to the broker
+func (p *Publisher) open() (broker, error) {
+	if p.dest.Scheme == "nats" {
+		return dialNATS(p.dest, p.target)
+	}
+	return dialKafka(p.dest.Host, p.target)
+}
+
+// run sends the queued messages in order, reconnecting with backoff
+// whenever the broker fails
+func (p *Publisher) run() {
+	var conn broker
+	backoff := time.Second
+
+	for msg := range p.messages {
+		for {
+			for conn == nil {
+				c, err := p.open()
+				if err == nil {
+					conn = c
+					break
+				}
+				log.Println("Error connecting to broker: ", err)
+				time.Sleep(backoff)
+				backoff = min(backoff*2, maxBackoff)
+			}
+
+			err := conn.publish(msg)
+			if err == nil {
+				backoff = time.Second
+				break
+			}
+			log.Println("Error publishing to broker: ", err)
+			conn.close()
+			conn = nil
+			p.count(func(s *Stats) { s.Retried++ })
+		}
+		p.count(func(s *Stats) { s.Published++ })
+	}
+}
@@ -0,0 +1,371 @@
+// Package replay records the shape of the traffic an API serves and drives
+// it again against another instance, for capacity tests with a realistic
+// mix of routes, payload sizes and pacing, for example
+//
+//	_, requests, err := replay.ReadFile("traffic.jsonl")
+//	report, err := replay.Run(ctx, requests, replay.Options{Target: "https://staging.example.com", Speed: 2})
+//
+// A recording never holds voter data.  Ids are replaced with pseudonyms
+// under a key that is drawn for each recording and never written out, so
+// the same voter maps to the same pseudonym throughout a recording, which
+// keeps the cache and partition behaviour of the workload, but can not be
+// traced back.  Strings are replaced with pseudonyms of the same length,
+// timestamps with their distance from the time of the request, and
+// credentials are not recorded at all.
+//
+// A recording is a file of JSON lines, a Header followed by one Request per
+// line.
+package replay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the version of the recording format
+const Version = 1
+
+// timeMarker starts a string that stood for a timestamp, followed by its
+// distance from the time of the request, e.g. $now-72h0m0s
+const timeMarker = "$now"
+
+// maxPseudonymId bounds the pseudonyms of ids
+const maxPseudonymId = 1_000_000
+
+// Header is the first line of a recording
+type Header struct {
+	Version int
+	Started time.Time
+}
+
+// Request is one recorded request
+type Request struct {
+	Offset      time.Duration //Since the recording started
+	Method      string
+	Route       string            //Route pattern, e.g. /voters/:id<int>
+	Params      map[string]string //Route parameters, pseudonymized
+	Query       map[string]string //Pseudonymized unless the parameter is safe
+	ContentType string
+	Accept      string
+	Body        json.RawMessage //Anonymized JSON body, nil when the body is not JSON
+	BodySize    int             //Size of the original body
+	Status      int
+	Duration    time.Duration
+}
+
+// Capture is a request as the API saw it, before it is anonymized
+type Capture struct {
+	Time        time.Time
+	Method      string
+	Route       string
+	Params      map[string]string
+	Query       map[string]string
+	ContentType string
+	Accept      string
+	Body        []byte
+	Status      int
+	Duration    time.Duration
+}
+
+// Stats are the counters of a recorder
+type Stats struct {
+	Recorded int64
+	Dropped  int64 //Requests that did not fit in the buffer
+	Failed   int64 //Requests lost because the file could not be written
+}
+
+// Rules say which values of a request may be kept as they are
+type Rules struct {
+	SafeQuery  map[string]bool //Query parameters that never carry PII
+	SafeFields map[string]bool //JSON body fields that never carry PII, e.g. Status
+}
+
+// Recorder anonymizes captured requests and writes them to a recording in
+// the background.  A slow disk never holds up a request, requests that do
+// not fit in the buffer are dropped and counted.
+type Recorder struct {
+	path     string
+	started  time.Time
+	rules    Rules
+	sample   float64
+	key      []byte
+	requests chan Request
+	file     *os.File
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// constructor for Recorder struct.  The recording at path is created, or
+// truncated when it exists.  sample is the share of requests recorded,
+// between 0 and 1, buffer the number of requests that may wait to be
+// written.  It starts the writer goroutine.
+func NewRecorder(path string, rules Rules, sample float64, buffer int) (*Recorder, error) {
+	if sample <= 0 || sample > 1 {
+		return nil, errors.New("record sample must be above 0 and at most 1")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		path:     path,
+		started:  time.Now(),
+		rules:    rules,
+		sample:   sample,
+		key:      key,
+		requests: make(chan Request, buffer),
+		file:     f,
+	}
+	if err := r.write(Header{Version: Version, Started: r.started}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	go r.run()
+
+	return r, nil
+}
+
+// Path returns the file the recorder writes to
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Sampled tells whether the next request is to be recorded
+func (r *Recorder) Sampled() bool {
+	return r.sample >= 1 || mrand.Float64() < r.sample
+}
+
+// Record anonymizes a captured request and queues it, it never blocks.
+// Nothing of the capture is kept, so its buffers may be reused.
+func (r *Recorder) Record(capture Capture) {
+	req := Request{
+		Offset:      capture.Time.Sub(r.started),
+		Method:      strings.Clone(capture.Method),
+		Route:       strings.Clone(capture.Route),
+		Params:      make(map[string]string, len(capture.Params)),
+		Query:       make(map[string]string, len(capture.Query)),
+		ContentType: strings.Clone(capture.ContentType),
+		Accept:      strings.Clone(capture.Accept),
+		BodySize:    len(capture.Body),
+		Status:      capture.Status,
+		Duration:    capture.Duration,
+	}
+	for key, value := range capture.Params {
+		req.Params[strings.Clone(key)] = r.pseudonym(value)
+	}
+	for key, value := range capture.Query {
+		if !r.rules.SafeQuery[key] {
+			value = r.pseudonym(value)
+		}
+		req.Query[strings.Clone(key)] = strings.Clone(value)
+	}
+	if len(capture.Body) > 0 && strings.HasPrefix(req.ContentType, "application/json") {
+		req.Body = r.anonymizeBody(capture.Body, capture.Time)
+	}
+
+	select {
+	case r.requests <- req:
+	default:
+		r.count(func(s *Stats) { s.Dropped++ })
+	}
+}
+
+// Stats returns the recorder counters
+func (r *Recorder) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// count updates the counters
+func (r *Recorder) count(change func(s *Stats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	change(&r.stats)
+}
+
+// run writes the queued requests.  Every request is a single write, so a
+// recording cut short by a crash is only missing its last lines.
+func (r *Recorder) run() {
+	for req := range r.requests {
+		if err := r.write(req); err != nil {
+			log.Println("Error writing traffic recording: ", err)
+			r.count(func(s *Stats) { s.Failed++ })
+			continue
+		}
+		r.count(func(s *Stats) { s.Recorded++ })
+	}
+}
+
+// write appends a line to the recording
+func (r *Recorder) write(line any) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+// mac returns the keyed hash of a value
+func (r *Recorder) mac(kind, value string) []byte {
+	h := hmac.New(sha256.New, r.key)
+	h.Write([]byte(kind + ":" + value))
+	return h.Sum(nil)
+}
+
+// pseudonymId returns the pseudonym of an id.  0 is kept, it stands for
+// no id or for one the API assigns.
+func (r *Recorder) pseudonymId(id int64) int64 {
+	if id <= 0 {
+		return id
+	}
+	sum := r.mac("id", strconv.FormatInt(id, 10))
+	return int64(binary.BigEndian.Uint64(sum)%maxPseudonymId) + 1
+}
+
+// pseudonym returns the pseudonym of a string.  Ids stay ids and email
+// addresses stay addresses, anything else becomes hex digits of the same
+// length.
+func (r *Recorder) pseudonym(value string) string {
+	if value == "" {
+		return ""
+	}
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(r.pseudonymId(id), 10)
+	}
+	if local, _, ok := strings.Cut(value, "@"); ok && local != "" {
+		return r.filler(value, len(local)) + "@example.com"
+	}
+	return r.filler(value, len(value))
+}
+
+// filler returns n hex digits derived from a value
+func (r *Recorder) filler(value string, n int) string {
+	digits := hex.EncodeToString(r.mac("str", value))
+	return strings.Repeat(digits, n/len(digits)+1)[:n]
+}
+
+// anonymizeBody pseudonymizes every string of a JSON body and every number
+// of a field named like an id, e.g. VoterId.  Timestamps are written as
+// their distance from the time of the request, zero times are kept as
+// they are.  Field names are kept, as are the values of safe fields.  A
+// body that is not valid JSON is dropped.
+func (r *Recorder) anonymizeBody(body []byte, at time.Time) json.RawMessage {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+
+	var walk func(key string, v any) any
+	walk = func(key string, v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, item := range v {
+				v[k] = walk(k, item)
+			}
+		case []any:
+			for i, item := range v {
+				v[i] = walk(key, item)
+			}
+		case string:
+			if r.rules.SafeFields[key] {
+				return v
+			}
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				if t.IsZero() {
+					return v
+				}
+				offset := t.Sub(at).Round(time.Second)
+				if offset < 0 {
+					return timeMarker + offset.String()
+				}
+				return timeMarker + "+" + offset.String()
+			}
+			return r.pseudonym(v)
+		case float64:
+			if strings.HasSuffix(key, "Id") && v == math.Trunc(v) {
+				return r.pseudonymId(int64(v))
+			}
+		}
+		return v
+	}
+
+	data, err := json.Marshal(walk("", value))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Read reads a recording, its requests sorted by offset.  They are
+// written as they complete, so a slow request comes after faster ones
+// that started later.
+func Read(rd io.Reader) (Header, []Request, error) {
+	var header Header
+	dec := json.NewDecoder(rd)
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("reading recording header: %w", err)
+	}
+	if header.Version != Version {
+		return header, nil, fmt.Errorf("recording version %d is not supported", header.Version)
+	}
+
+	var requests []Request
+	for {
+		var req Request
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//A recorder that was killed may leave a partial last line
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return header, nil, fmt.Errorf("reading request %d: %w", len(requests)+1, err)
+		}
+		requests = append(requests, req)
+	}
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].Offset < requests[j].Offset
+	})
+	return header, requests, nil
+}
+
+// ReadFile reads the recording in a file
+func ReadFile(path string) (Header, []Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
@@ -0,0 +1,302 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options control how a recording is replayed
+type Options struct {
+	Target      string      //Base URL of the instance under test
+	Speed       float64     //1 replays at the recorded pace, 2 twice as fast, 0 as fast as possible
+	Concurrency int         //Requests in flight at most, 0 for no bound
+	Header      http.Header //Sent with every request, e.g. an API key
+	Client      *http.Client
+}
+
+// Latency sums up the durations of a set of requests
+type Latency struct {
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// RouteReport compares the replayed requests of a route with the recorded
+// ones
+type RouteReport struct {
+	Method   string
+	Route    string
+	Count    int
+	Failed   int //Requests that got no answer
+	Mismatch int //Answers whose status class differs from the recorded one
+	Recorded Latency
+	Replayed Latency
+}
+
+// Report is the outcome of a replay
+type Report struct {
+	Requests int
+	Failed   int
+	Mismatch int
+	Elapsed  time.Duration
+	Lag      time.Duration //Largest delay behind the schedule, the target or the concurrency bound could not keep up
+	Routes   []RouteReport //Busiest first
+}
+
+// result is the outcome of one replayed request
+type result struct {
+	req      *Request
+	status   int //0 when the request got no answer
+	duration time.Duration
+}
+
+// Run sends the recorded requests to the target at their recorded offsets,
+// scaled by the speed, and reports how the target kept up.  It returns
+// early with the context's error when the context is done.
+func Run(ctx context.Context, requests []Request, opts Options) (Report, error) {
+	target, err := url.Parse(opts.Target)
+	if err != nil {
+		return Report{}, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return Report{}, errors.New("replay target must be an http:// or https:// URL")
+	}
+	if opts.Speed < 0 {
+		return Report{}, errors.New("replay speed can not be negative")
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	slots := len(requests)
+	if opts.Concurrency > 0 {
+		slots = opts.Concurrency
+	}
+	sem := make(chan struct{}, max(slots, 1))
+
+	results := make([]result, len(requests))
+	var wg sync.WaitGroup
+	var lag time.Duration
+	start := time.Now()
+
+	for i := range requests {
+		req := &requests[i]
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(req.Offset) / opts.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				wg.Wait()
+				return Report{}, ctx.Err()
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return Report{}, ctx.Err()
+		}
+		if opts.Speed > 0 {
+			lag = max(lag, time.Since(start)-time.Duration(float64(req.Offset)/opts.Speed))
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = send(ctx, client, target, opts.Header, req)
+		}(i)
+	}
+	wg.Wait()
+
+	report := summarize(results)
+	report.Elapsed = time.Since(start)
+	report.Lag = lag
+	return report, nil
+}
+
+// send replays one request
+func send(ctx context.Context, client *http.Client, target *url.URL, header http.Header, req *Request) result {
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + Path(req.Route, req.Params)
+	query := url.Values{}
+	for key, value := range req.Query {
+		query.Set(key, value)
+	}
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	switch {
+	case req.Body != nil:
+		body = bytes.NewReader(materialize(req.Body, time.Now()))
+	case req.BodySize > 0:
+		body = bytes.NewReader(bytes.Repeat([]byte("x"), req.BodySize))
+	}
+
+	r := result{req: req}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), body)
+	if err != nil {
+		return r
+	}
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+	if req.ContentType != "" {
+		httpReq.Header.Set("Content-Type", req.ContentType)
+	}
+	if req.Accept != "" {
+		httpReq.Header.Set("Accept", req.Accept)
+	}
+
+	sent := time.Now()
+	rsp, err := client.Do(httpReq)
+	if err != nil {
+		return r
+	}
+	io.Copy(io.Discard, rsp.Body)
+	rsp.Body.Close()
+	r.status, r.duration = rsp.StatusCode, time.Since(sent)
+
+	return r
+}
+
+// Path fills the parameters into a route pattern, e.g. /voters/:id<int>
+// with id 7 becomes /voters/7
+func Path(route string, params map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(route); i++ {
+		switch {
+		case route[i] == '\\' && i+1 < len(route):
+			i++
+			b.WriteByte(route[i])
+		case route[i] == ':':
+			end := i + 1
+			for end < len(route) && strings.IndexByte("/<?.-\\", route[end]) < 0 {
+				end++
+			}
+			b.WriteString(url.PathEscape(params[route[i+1:end]]))
+			if end < len(route) && route[end] == '<' {
+				end += strings.IndexByte(route[end:], '>') + 1
+			}
+			if end < len(route) && route[end] == '?' {
+				end++
+			}
+			i = end - 1
+		case route[i] == '*' || route[i] == '+':
+			b.WriteString(params[string(route[i])+"1"])
+		default:
+			b.WriteByte(route[i])
+		}
+	}
+	return b.String()
+}
+
+// materialize turns the timestamps of an anonymized body back into times,
+// counted from now
+func materialize(body json.RawMessage, now time.Time) []byte {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body
+	}
+
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, item := range v {
+				v[k] = walk(item)
+			}
+		case []any:
+			for i, item := range v {
+				v[i] = walk(item)
+			}
+		case string:
+			if offset, ok := strings.CutPrefix(v, timeMarker); ok {
+				if d, err := time.ParseDuration(strings.TrimPrefix(offset, "+")); err == nil {
+					return now.Add(d).UTC().Format(time.RFC3339Nano)
+				}
+			}
+		}
+		return v
+	}
+
+	data, err := json.Marshal(walk(value))
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// summarize groups the results by route
+func summarize(results []result) Report {
+	type key struct{ method, route string }
+	type durations struct{ recorded, replayed []time.Duration }
+	routes := make(map[key]*RouteReport)
+	timings := make(map[key]*durations)
+
+	var report Report
+	for _, r := range results {
+		if r.req == nil {
+			continue
+		}
+		k := key{r.req.Method, r.req.Route}
+		route := routes[k]
+		if route == nil {
+			route = &RouteReport{Method: k.method, Route: k.route}
+			routes[k] = route
+			timings[k] = &durations{}
+		}
+
+		report.Requests++
+		route.Count++
+		timings[k].recorded = append(timings[k].recorded, r.req.Duration)
+		switch {
+		case r.status == 0:
+			route.Failed++
+			report.Failed++
+			continue
+		case r.status/100 != r.req.Status/100:
+			route.Mismatch++
+			report.Mismatch++
+		}
+		timings[k].replayed = append(timings[k].replayed, r.duration)
+	}
+
+	for k, route := range routes {
+		route.Recorded = latency(timings[k].recorded)
+		route.Replayed = latency(timings[k].replayed)
+		report.Routes = append(report.Routes, *route)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		a, b := report.Routes[i], report.Routes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Method+" "+a.Route < b.Method+" "+b.Route
+	})
+
+	return report
+}
+
+// latency returns the percentiles of a set of durations
+func latency(durations []time.Duration) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	at := func(p float64) time.Duration {
+		return durations[int(p*float64(len(durations)-1))]
+	}
+	return Latency{P50: at(0.5), P95: at(0.95), P99: at(0.99), Max: durations[len(durations)-1]}
+}
@@ -0,0 +1,183 @@
+// Package sealed wraps exports in OpenPGP packages before they leave the
+// server.  A package is encrypted to the configured recipient keys, signed
+// with the configured signing key, or both, and is ASCII armored so it can
+// travel as an email attachment.  The packages are plain OpenPGP messages,
+// gpg --decrypt opens and verifies them as well as voterctl verify does.
+package sealed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	//OpenPGP falls back to RIPEMD-160 for keys that state no hash
+	//preferences, it has to be registered for them to work
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// messageType is the armor block type of a package
+const messageType = "PGP MESSAGE"
+
+// Sealer seals exports with a fixed set of keys
+type Sealer struct {
+	recipients openpgp.EntityList
+	signer     *openpgp.Entity
+}
+
+// ReadKeyring reads the armored OpenPGP keys in the given files into one
+// keyring, public and private keys alike
+func ReadKeyring(files ...string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	return keyring, nil
+}
+
+// LoadSealer reads the recipient public keys and the signing private key
+// from armored key files, either file may be "" but not both.  A signing
+// key protected by a passphrase is unlocked with passphrase.
+func LoadSealer(recipientsFile, signingKeyFile string, passphrase []byte) (*Sealer, error) {
+	if recipientsFile == "" && signingKeyFile == "" {
+		return nil, errors.New("sealing exports needs recipient keys, a signing key or both")
+	}
+
+	s := &Sealer{}
+	if recipientsFile != "" {
+		recipients, err := ReadKeyring(recipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(recipients) == 0 {
+			return nil, errors.New(recipientsFile + " holds no keys")
+		}
+		s.recipients = recipients
+	}
+
+	if signingKeyFile != "" {
+		keyring, err := ReadKeyring(signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyring) != 1 || keyring[0].PrivateKey == nil {
+			return nil, errors.New(signingKeyFile + " has to hold exactly one private key")
+		}
+		signer := keyring[0]
+		if signer.PrivateKey.Encrypted {
+			if err := signer.PrivateKey.Decrypt(passphrase); err != nil {
+				return nil, fmt.Errorf("unlocking the signing key: %w", err)
+			}
+		}
+		s.signer = signer
+	}
+
+	return s, nil
+}
+
+// Encrypted reports whether packages are encrypted, not only signed
+func (s *Sealer) Encrypted() bool {
+	return len(s.recipients) > 0
+}
+
+// Seal writes data as an armored package to w.  The name is kept in the
+// package, so the file can be restored under it.
+func (s *Sealer) Seal(w io.Writer, name string, data []byte) error {
+	armored, err := armor.Encode(w, messageType, nil)
+	if err != nil {
+		return err
+	}
+
+	hints := &openpgp.FileHints{FileName: name, ModTime: time.Now()}
+	var plaintext io.WriteCloser
+	if s.Encrypted() {
+		plaintext, err = openpgp.Encrypt(armored, s.recipients, s.signer, hints, nil)
+	} else {
+		plaintext, err = openpgp.Sign(armored, s.signer, hints, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := plaintext.Write(data); err != nil {
+		return err
+	}
+	if err := plaintext.Close(); err != nil {
+		return err
+	}
+	return armored.Close()
+}
+
+// Package is the content of a package that was opened
+type Package struct {
+	Name      string
+	Data      []byte
+	Encrypted bool
+	Signed    bool
+	SignedBy  string //Primary identity of the signing key
+	KeyId     string //Id of the signing key, also set when it is unknown
+}
+
+// Open reads an armored package.  The keyring has to hold the private key
+// of a recipient for encrypted packages and the public key of the signer
+// for the signature to be checked.  A package signed by a key that is not
+// in the keyring, or whose signature does not match, is an error.
+func Open(r io.Reader, keyring openpgp.EntityList) (Package, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return Package{}, fmt.Errorf("not an armored package: %w", err)
+	}
+	if block.Type != messageType {
+		return Package{}, fmt.Errorf("armor type is %s, not %s", block.Type, messageType)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return Package{}, err
+	}
+
+	//The signature is only checked once all of the data has been read
+	var data bytes.Buffer
+	if _, err := io.Copy(&data, md.UnverifiedBody); err != nil {
+		return Package{}, err
+	}
+
+	pkg := Package{
+		Name:      md.LiteralData.FileName,
+		Data:      data.Bytes(),
+		Encrypted: md.IsEncrypted,
+		Signed:    md.IsSigned,
+	}
+	if md.IsSigned {
+		pkg.KeyId = fmt.Sprintf("%016X", md.SignedByKeyId)
+		if md.SignedBy == nil {
+			return Package{}, fmt.Errorf("signed by key %s, which is not in the keyring", pkg.KeyId)
+		}
+		if md.SignatureError != nil {
+			return Package{}, fmt.Errorf("bad signature: %w", md.SignatureError)
+		}
+		for name, identity := range md.SignedBy.Entity.Identities {
+			sig := identity.SelfSignature
+			if pkg.SignedBy == "" || sig != nil && sig.IsPrimaryId != nil && *sig.IsPrimaryId {
+				pkg.SignedBy = name
+			}
+		}
+	}
+
+	return pkg, nil
+}
@@ -0,0 +1,264 @@
+// Package siem ships security events to a SIEM.  Every mutation and every
+// authentication event is formatted as an RFC 5424 syslog message or a CEF
+// record and sent to a configured destination.  Sending happens in the
+// background through a bounded buffer, a slow or unreachable SIEM never
+// holds up a request, events that do not fit in the buffer are dropped
+// and counted.
+package siem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event kinds
+const (
+	KindMutation = "mutation"
+	KindAuth     = "auth"
+	KindTripwire = "tripwire" //A decoy record was read
+)
+
+// Record formats
+const (
+	FormatSyslog = "syslog"
+	FormatCEF    = "cef"
+)
+
+// Event is a single security relevant event
+type Event struct {
+	Time      time.Time
+	Kind      string
+	Action    string //e.g. "POST /voters/:id" or "apikey"
+	Outcome   string //"success" or "failure"
+	Principal string
+	SourceIP  string
+	Method    string
+	Path      string
+	Status    int
+	VoterId   int //0 when the event is not about a single voter
+}
+
+// Stats are the counters of an exporter
+type Stats struct {
+	Queued  int64
+	Sent    int64
+	Dropped int64 //Events that did not fit in the buffer
+	Failed  int64 //Events lost because the destination failed
+}
+
+// Exporter formats events and sends them to the destination
+type Exporter struct {
+	format string
+	dest   *url.URL
+	events chan Event
+	host   string
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// maxBackoff is the longest wait between reconnect attempts
+const maxBackoff = 30 * time.Second
+
+// constructor for Exporter struct.  dest is udp://host:port,
+// tcp://host:port or file:///path, buffer is the number of events that
+// may wait to be sent.  It starts the sender goroutine.
+func NewExporter(dest string, format string, buffer int) (*Exporter, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "file":
+	default:
+		return nil, errors.New("SIEM destination must be udp://, tcp:// or file://")
+	}
+	if format != FormatSyslog && format != FormatCEF {
+		return nil, errors.New("SIEM format must be syslog or cef")
+	}
+
+	host, _ := os.Hostname()
+	e := &Exporter{
+		format: format,
+		dest:   u,
+		events: make(chan Event, buffer),
+		host:   host,
+	}
+	go e.run()
+
+	return e, nil
+}
+
+// Export queues an event, it never blocks.  If the buffer is full the event
+// is dropped.
+func (e *Exporter) Export(event Event) {
+	select {
+	case e.events <- event:
+		e.count(func(s *Stats) { s.Queued++ })
+	default:
+		e.count(func(s *Stats) { s.Dropped++ })
+	}
+}
+
+// Stats returns the exporter counters
+func (e *Exporter) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.stats
+}
+
+// count updates the counters
+func (e *Exporter) count(change func(s *Stats)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	change(&e.stats)
+}
+
+// open connects to the destination
+func (e *Exporter) open() (io.WriteCloser, error) {
+	if e.dest.Scheme == "file" {
+		return os.OpenFile(e.dest.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	}
+	return net.DialTimeout(e.dest.Scheme, e.dest.Host, 5*time.Second)
+}
+
+// run sends the queued events, reconnecting with backoff whenever the
+// destination fails.  While it is down the buffer fills up and new events
+// are dropped rather than blocking the requests that produce them.
+func (e *Exporter) run() {
+	var conn io.WriteCloser
+	backoff := time.Second
+
+	for event := range e.events {
+		line := e.formatEvent(event)
+
+		for conn == nil {
+			c, err := e.open()
+			if err == nil {
+				conn, backoff = c, time.Second
+				break
+			}
+			log.Println("Error connecting to SIEM: ", err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		//TCP syslog uses octet counting framing (RFC 6587)
+		if e.dest.Scheme == "tcp" {
+			line = fmt.Sprintf("%d %s", len(line), line)
+		} else if e.dest.Scheme == "file" {
+			line += "\n"
+		}
+
+		if _, err := io.WriteString(conn, line); err != nil {
+			log.Println("Error sending to SIEM: ", err)
+			conn.Close()
+			conn = nil
+			e.count(func(s *Stats) { s.Failed++ })
+			continue
+		}
+		e.count(func(s *Stats) { s.Sent++ })
+	}
+}
+
+// formatEvent renders an event in the configured format
+func (e *Exporter) formatEvent(event Event) string {
+	if e.format == FormatCEF {
+		return CEF(event)
+	}
+	return Syslog(event, e.host)
+}
+
+// severity maps an event to a syslog severity, a tripped decoy is an
+// alert, failed authentication is a warning, everything else is a notice
+func severity(event Event) int {
+	if event.Kind == KindTripwire {
+		return 1
+	}
+	if event.Kind == KindAuth && event.Outcome != "success" {
+		return 4
+	}
+	return 5
+}
+
+// Syslog formats an event as an RFC 5424 message with the event fields as
+// structured data.  The facility is security/authorization (10).
+func Syslog(event Event, host string) string {
+	if host == "" {
+		host = "-"
+	}
+	pri := 10*8 + severity(event)
+
+	params := []string{
+		param("kind", event.Kind),
+		param("action", event.Action),
+		param("outcome", event.Outcome),
+		param("principal", event.Principal),
+		param("src", event.SourceIP),
+		param("method", event.Method),
+		param("path", event.Path),
+		param("status", fmt.Sprint(event.Status)),
+	}
+	if event.VoterId != 0 {
+		params = append(params, param("voter", fmt.Sprint(event.VoterId)))
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s voter-api - %s [audit@32473 %s] %s %s",
+		pri, event.Time.UTC().Format(time.RFC3339Nano), host, event.Kind,
+		strings.Join(params, " "), event.Action, event.Outcome)
+}
+
+// param renders one structured data parameter, escaping as RFC 5424
+// requires
+func param(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	return fmt.Sprintf(`%s="%s"`, name, value)
+}
+
+// CEF formats an event as an ArcSight Common Event Format record
+func CEF(event Event) string {
+	sev := 3
+	switch severity(event) {
+	case 1:
+		sev = 10
+	case 4:
+		sev = 7
+	}
+
+	ext := []string{
+		"rt=" + fmt.Sprint(event.Time.UnixMilli()),
+		"suser=" + cefValue(event.Principal),
+		"src=" + cefValue(event.SourceIP),
+		"requestMethod=" + cefValue(event.Method),
+		"request=" + cefValue(event.Path),
+		"outcome=" + cefValue(event.Outcome),
+		"cn1=" + fmt.Sprint(event.Status),
+		"cn1Label=status",
+	}
+	if event.VoterId != 0 {
+		ext = append(ext, "duid="+fmt.Sprint(event.VoterId))
+	}
+
+	return fmt.Sprintf("CEF:0|adllev|voter-api|1|%s|%s|%d|%s",
+		cefHeader(event.Kind), cefHeader(event.Action), sev, strings.Join(ext, " "))
+}
+
+// cefHeader escapes a CEF header field
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(value)
+}
+
+// cefValue escapes a CEF extension value
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
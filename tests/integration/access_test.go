@@ -0,0 +1,94 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_FieldAccess checks that a role sees and writes only its fields
+func Test_FieldAccess(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"Keys": [
+			{"Key": "root-secret", "Name": "root", "Role": "admin"},
+			{"Key": "worker-secret", "Name": "worker", "Role": "pollworker", "Scopes": ["read", "write"]}
+		],
+		"Roles": {"admin": ["*"], "pollworker": ["Name", "PrecinctId"]}
+	}`), 0o600))
+	s := startServer(t, "-access", config)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+	worker := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "worker-secret") }
+
+	address := db.Address{Street: "1 Main St", City: "Springfield", State: "IL", Zip: "62701"}
+	rsp, err := root().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith", Email: "jane@example.com", Address: address, PrecinctId: 1}).
+		Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	var seen map[string]any
+	rsp, err = worker().SetResult(&seen).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, map[string]any{"VoterId": float64(1), "Name": "Jane Smith", "PrecinctId": float64(1)}, seen)
+
+	//Fields the role can not see can not be written either
+	rsp, err = worker().SetBody(map[string]any{"Email": "someone@example.com"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = worker().SetBody(map[string]any{"email": "someone@example.com"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = worker().SetBody(db.Voter{VoterId: 1, Name: "Jane Doe", PrecinctId: 1}).Put(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = worker().SetBody(db.Voter{VoterId: 2, Name: "John Doe", Email: "john@example.com"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	rsp, err = worker().SetBody(map[string]any{"Name": "Jane Doe"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = worker().SetBody(db.Voter{VoterId: 2, Name: "John Doe", PrecinctId: 1}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	var stored db.Voter
+	rsp, err = root().SetResult(&stored).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, "Jane Doe", stored.Name)
+	assert.Equal(t, "jane@example.com", stored.Email)
+
+	//The label export is a CSV of names and addresses, only roles that
+	//see both get it
+	rsp, err = s.cli.R().Get(s.base + "/voters/export")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = worker().Get(s.base + "/voters/export")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = root().Get(s.base + "/voters/export")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "Jane Doe")
+
+	//and the labels it hands out are in the access log of each voter
+	var entries []db.AccessEntry
+	rsp, err = root().SetResult(&entries).Get(s.base + "/voters/1/access-log")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.NotEmpty(t, entries)
+	last := entries[len(entries)-1]
+	assert.Equal(t, "/voters/export", last.Path)
+	assert.Equal(t, "root", last.Principal)
+	assert.Equal(t, []string{"Name", "Address"}, last.Fields)
+}
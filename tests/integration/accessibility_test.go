@@ -0,0 +1,77 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_PollingPlaceAccessibility checks the accessibility features of
+// polling places, the filter of the public list and the features a voter
+// needs that their place lacks
+func Test_PollingPlaceAccessibility(t *testing.T) {
+	s := startServer(t)
+
+	places := []db.PollingPlace{
+		{PollingPlaceId: 1, Name: "Library", PrecinctIds: []int{1},
+			Accessibility: []string{db.AccessWheelchair, db.AccessAudioBallot, db.AccessCurbside}},
+		{PollingPlaceId: 2, Name: "Fire Hall", PrecinctIds: []int{2},
+			Accessibility: []string{db.AccessWheelchair, db.AccessLargePrint}},
+		{PollingPlaceId: 3, Name: "Church Basement", PrecinctIds: []int{3}},
+	}
+	for _, place := range places {
+		rsp, err := s.cli.R().SetBody(place).Post(s.base + "/polling-places")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, rsp.StatusCode(), rsp.String())
+	}
+
+	//Unknown and repeated features are refused
+	bad := db.PollingPlace{PollingPlaceId: 4, Name: "Gym", Accessibility: []string{"ramp"}}
+	rsp, err := s.cli.R().SetBody(bad).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+	bad.Accessibility = []string{db.AccessBraille, db.AccessBraille}
+	rsp, err = s.cli.R().SetBody(bad).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	ids := func(query string) []int {
+		var found []db.PollingPlace
+		rsp, err := s.cli.R().SetResult(&found).Get(s.base + "/polling-places" + query)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		list := []int{}
+		for _, place := range found {
+			list = append(list, place.PollingPlaceId)
+		}
+		return list
+	}
+	assert.Equal(t, []int{1, 2, 3}, ids(""))
+	assert.Equal(t, []int{1, 2}, ids("?accessibility=wheelchair"))
+	assert.Equal(t, []int{1}, ids("?accessibility=wheelchair,curbside"))
+	assert.Equal(t, []int{}, ids("?accessibility=braille"))
+	rsp, err = s.cli.R().Get(s.base + "/polling-places?accessibility=ramp")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	voter := db.Voter{VoterId: 1, Name: "Ada Lovelace", Email: "ada@example.com", PrecinctId: 2}
+	rsp, err = s.cli.R().SetBody(voter).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var lookup struct {
+		db.PollingPlace
+		Missing []string
+	}
+	rsp, err = s.cli.R().SetResult(&lookup).Get(s.base + "/voters/1/polling-place?needs=wheelchair,audio-ballot")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, 2, lookup.PollingPlaceId)
+	assert.Equal(t, []string{db.AccessWheelchair, db.AccessLargePrint}, lookup.Accessibility)
+	assert.Equal(t, []string{db.AccessAudioBallot}, lookup.Missing)
+}
@@ -0,0 +1,323 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issuedKey is the answer of the API key writes
+type issuedKey struct {
+	APIKey db.APIKey `json:"api_key"`
+	Secret string    `json:"secret"`
+}
+
+// startWithRootKey starts a server with access control and a key named
+// root that may do everything, and step up with rootTOTPSecret
+func startWithRootKey(t *testing.T, args ...string) *server {
+	config := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"Keys": [{"Key": "root-secret", "Name": "root", "Role": "admin", "TOTPSecret": "`+rootTOTPSecret+`"}],
+		"Roles": {"admin": ["*"]}
+	}`), 0o600))
+
+	return startServer(t, append([]string{"-access", config}, args...)...)
+}
+
+// Test_ManagedAPIKeys creates, scopes, rotates and revokes an API key
+// without touching the access config
+func Test_ManagedAPIKeys(t *testing.T) {
+	s := startWithRootKey(t)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	key := db.APIKey{Name: "field-app", Role: "admin", Scopes: []string{db.ScopeRead}}
+	var issued issuedKey
+	rsp, err := root().SetBody(key).SetResult(&issued).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, "/admin/apikeys/field-app", rsp.Header().Get("Location"))
+	require.NotEmpty(t, issued.Secret)
+	assert.Equal(t, issued.Secret[:8], issued.APIKey.Prefix)
+	secret := issued.Secret
+
+	issued = issuedKey{}
+	rsp, err = root().SetBody(key).SetResult(&issued).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Empty(t, issued.Secret)
+
+	rsp, err = root().SetBody(db.APIKey{Name: "field-app", Role: "pollworker"}).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	//A read scoped key can read the roll but not change it
+	rsp, err = s.cli.R().SetHeader("X-API-Key", secret).Get(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("X-API-Key", secret).SetBody(db.Voter{Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	var stored db.APIKey
+	rsp, err = root().SetResult(&stored).Get(s.base + "/admin/apikeys/field-app")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.False(t, stored.LastUsed.IsZero())
+	assert.True(t, stored.Managed)
+
+	//The old secret keeps working through the grace period
+	issued = issuedKey{}
+	rsp, err = root().SetHeader("X-Step-Up", stepUp(t, s)).SetResult(&issued).Post(s.base + "/admin/apikeys/field-app/rotate?grace=1h")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rotated := issued.Secret
+	assert.NotEqual(t, secret, rotated)
+
+	for _, secret := range []string{secret, rotated} {
+		rsp, err = s.cli.R().SetHeader("X-API-Key", secret).Get(s.base + "/voters")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+
+	rsp, err = root().Post(s.base + "/admin/apikeys/field-app/revoke")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	for _, secret := range []string{secret, rotated} {
+		rsp, err = s.cli.R().SetHeader("X-API-Key", secret).Get(s.base + "/voters")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+	}
+
+	var keys []db.APIKey
+	rsp, err = root().SetResult(&keys).Get(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "field-app", keys[0].Name)
+	assert.False(t, keys[0].Revoked.IsZero())
+	assert.Equal(t, "root", keys[1].Name)
+	assert.Empty(t, keys[1].Prefix)
+
+	rsp, err = root().Delete(s.base + "/admin/apikeys/field-app")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = root().Get(s.base + "/admin/apikeys/field-app")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+
+	var audit []db.AuditEntry
+	rsp, err = root().SetResult(&audit).Get(s.base + "/admin/audit")
+	require.NoError(t, err)
+	var actions []string
+	for _, entry := range audit {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{"apikey.created", "apikey.rotated", "apikey.revoked", "apikey.deleted"}, actions)
+}
+
+// Test_AnonymousCallers checks that with access control on a caller
+// without a key can neither reach the admin routes nor change the roll
+func Test_AnonymousCallers(t *testing.T) {
+	s := startWithRootKey(t)
+
+	rsp, err := s.cli.R().SetBody(db.APIKey{Name: "evil", Role: "admin"}).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+	assert.NotContains(t, rsp.String(), "secret")
+
+	for _, path := range []string{"/admin/apikeys", "/ADMIN/apikeys", "/admin/apikeys/"} {
+		rsp, err = s.cli.R().Get(s.base + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode(), path)
+	}
+
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+	rsp, err = s.cli.R().Delete(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "root-secret").
+		SetBody(db.Voter{VoterId: 1, Name: "Jane Smith", Email: "jane@example.com"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	//Reads stay open, the unsubscribe links need the token signed into
+	//them, see Test_VoterLinks
+	rsp, err = s.cli.R().Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().Post(s.base + "/voters/1/opt-out")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	var keys []db.APIKey
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "root-secret").SetResult(&keys).Get(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "root", keys[0].Name)
+}
+
+// Test_VoterLinks checks that the opt-out and survey links anonymous
+// voters follow only work with the token signed for their voter and the
+// campaign or poll they were sent for
+func Test_VoterLinks(t *testing.T) {
+	s := startWithRootKey(t)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	poll := db.Poll{PollId: 7, Title: "Library levy", Options: []string{"Yes", "No"},
+		Questions: []db.SurveyQuestion{{QuestionId: 1, Text: "How long did you wait?"}}}
+	rsp, err := root().SetBody(poll).Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	for _, voter := range []db.Voter{{VoterId: 1, Name: "Jane Smith", Email: "jane@example.com"}, {VoterId: 2, Name: "John Doe"}} {
+		voter.Preferences.EmailOk = true
+		rsp, err = root().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	rsp, err = root().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var links struct{ OptOut, Survey string }
+	rsp, err = root().SetResult(&links).Get(s.base + "/admin/voters/1/links?campaign=3&poll=7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.NotEmpty(t, links.OptOut)
+	require.NotEmpty(t, links.Survey)
+	token := links.OptOut[strings.Index(links.OptOut, "token=")+len("token="):]
+
+	rsp, err = s.cli.R().Get(s.base + "/admin/voters/1/links?campaign=3")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	//A bare id, the token of another voter or campaign, or a survey token
+	//do not opt anyone out
+	answers := map[string]any{"VoterId": 1, "Answers": []db.SurveyAnswer{{QuestionId: 1, Answer: "Ten minutes"}}}
+	surveyToken := links.Survey[strings.Index(links.Survey, "token=")+len("token="):]
+	for _, path := range []string{
+		"/voters/1/opt-out",
+		"/voters/1/opt-out?campaign=3&token=",
+		"/voters/2/opt-out?campaign=3&token=" + token,
+		"/voters/1/opt-out?campaign=4&token=" + token,
+		"/voters/1/opt-out?campaign=7&token=" + surveyToken,
+	} {
+		rsp, err = s.cli.R().Post(s.base + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode(), path)
+	}
+	rsp, err = s.cli.R().SetBody(answers).Post(s.base + "/polls/7/survey")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(map[string]any{"VoterId": 2, "Answers": answers["Answers"]}).Post(s.base + links.Survey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	var prefs db.Preferences
+	rsp, err = root().SetResult(&prefs).Get(s.base + "/voters/1/preferences")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.True(t, prefs.EmailOk)
+
+	//The links that were sent work
+	rsp, err = s.cli.R().Post(s.base + links.OptOut)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = root().SetResult(&prefs).Get(s.base + "/voters/1/preferences")
+	require.NoError(t, err)
+	assert.False(t, prefs.EmailOk)
+
+	rsp, err = s.cli.R().SetBody(answers).Post(s.base + links.Survey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	voted := rsp.String()
+
+	//A voter who did not vote, or already responded, is answered the same
+	//and nothing is recorded for them
+	rsp, err = root().SetResult(&links).Get(s.base + "/admin/voters/2/links?poll=7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().SetBody(map[string]any{"VoterId": 2, "Answers": answers["Answers"]}).Post(s.base + links.Survey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, voted, rsp.String())
+	rsp, err = s.cli.R().SetBody(answers).Post(s.base + "/polls/7/survey?token=" + surveyToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, voted, rsp.String())
+
+	var results []db.QuestionResult
+	rsp, err = root().SetResult(&results).Get(s.base + "/polls/7/survey/results")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Responses)
+
+	//Callers with a key still learn why a response was refused
+	rsp, err = root().SetBody(map[string]any{"VoterId": 2, "Answers": answers["Answers"]}).Post(s.base + "/polls/7/survey")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+}
+
+// Test_ManagedWebhooks manages a webhook the way a declarative tool does
+func Test_ManagedWebhooks(t *testing.T) {
+	s := startServer(t)
+
+	webhook := db.Webhook{Name: "analytics", URL: "https://example.com/hook", Events: []string{db.EventVoterCreated}}
+	rsp, err := s.cli.R().SetBody(webhook).Post(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	etag := rsp.Header().Get("ETag")
+
+	rsp, err = s.cli.R().SetBody(webhook).Post(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, etag, rsp.Header().Get("ETag"))
+
+	webhook.Events = []string{db.AllEvents}
+	rsp, err = s.cli.R().SetBody(webhook).Post(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).SetBody(webhook).Put(s.base + "/admin/webhooks/analytics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.Webhook{URL: "ftp://example.com", Events: []string{db.AllEvents}}).
+		Put(s.base + "/admin/webhooks/archive")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.Webhook{URL: "https://example.com/archive", Events: []string{db.EventVoterDeleted}}).
+		Put(s.base + "/admin/webhooks/archive")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).Delete(s.base + "/admin/webhooks/analytics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = s.cli.R().Delete(s.base + "/admin/webhooks/analytics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	var webhooks []db.Webhook
+	rsp, err = s.cli.R().SetResult(&webhooks).Get(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, "archive", webhooks[0].Name)
+}
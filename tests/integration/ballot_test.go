@@ -0,0 +1,192 @@
+//go:build integration
+
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePublicKey writes the PKIX PEM of a public key to a file and returns
+// its path
+func writePublicKey(t *testing.T, key any) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ballot.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	return path
+}
+
+// Test_EncryptedBallots records votes with encrypted ballot storage on and
+// checks that the choices are only kept sealed, that the trustees' private
+// key opens them again, from the voter and from the ballot export, and
+// that any other key does not
+func Test_EncryptedBallots(t *testing.T) {
+	trustees, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := startServer(t, "-ballotkey", writePublicKey(t, &trustees.PublicKey))
+
+	choices := map[int]string{1: "Yes", 2: "No"}
+	for id, choice := range choices {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Sealed Voter"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now(), Choice: choice}).
+			Post(fmt.Sprintf("%s/voters/%d/polls/1", s.base, id))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	open := func(key *rsa.PrivateKey, sealed []byte) (string, error) {
+		plain, err := rsa.DecryptOAEP(sha256.New(), nil, key, sealed, nil)
+		return string(plain), err
+	}
+
+	for id, choice := range choices {
+		var voter db.Voter
+		rsp, err := s.cli.R().SetResult(&voter).Get(fmt.Sprintf("%s/voters/%d", s.base, id))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		require.Len(t, voter.VoteHistory, 1)
+		vote := voter.VoteHistory[0]
+		assert.Empty(t, vote.Choice)
+		assert.NotContains(t, rsp.String(), `"`+choice+`"`)
+
+		plain, err := open(trustees, vote.EncryptedChoice)
+		require.NoError(t, err)
+		assert.Equal(t, choice, plain)
+		_, err = open(other, vote.EncryptedChoice)
+		assert.Error(t, err)
+	}
+
+	var export struct {
+		Ballots []db.EncryptedBallot
+	}
+	rsp, err := s.cli.R().SetResult(&export).Get(s.base + "/admin/export/ballots?poll=1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, export.Ballots, 2)
+	var opened []string
+	for _, ballot := range export.Ballots {
+		plain, err := open(trustees, ballot.Contests[0].Ciphertext)
+		require.NoError(t, err)
+		opened = append(opened, plain)
+		_, err = open(other, ballot.Contests[0].Ciphertext)
+		assert.Error(t, err)
+	}
+	assert.ElementsMatch(t, []string{"Yes", "No"}, opened)
+
+	//Only RSA keys can seal ballots, the server refuses to start with any
+	//other kind of key
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	out, err := exec.Command(serverBinary, "-ballotkey", writePublicKey(t, &ecKey.PublicKey)).CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(out), "ballot key must be an RSA public key")
+}
+
+// shape replaces every value of a decoded JSON document with the name of
+// its type, arrays keep the shape of their first element
+func shape(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		shaped := make(map[string]any, len(v))
+		for key, value := range v {
+			shaped[key] = shape(value)
+		}
+		return shaped
+	case []any:
+		if len(v) == 0 {
+			return []any{}
+		}
+		return []any{shape(v[0])}
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// Test_BallotExportSchema pins the shape of the ElectionGuard style ballot
+// export against a golden file, the values change with every run as the
+// ballots are sealed at random, and checks the hashes and the chain of
+// tracking codes an external tally system verifies
+func Test_BallotExportSchema(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := startServer(t, "-ballotkey", writePublicKey(t, &key.PublicKey))
+
+	for id := 1; id <= 3; id++ {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Sealed Voter"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now(), Choice: "Yes"}).
+			Post(fmt.Sprintf("%s/voters/%d/polls/1", s.base, id))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	rsp, err := s.cli.R().Get(s.base + "/admin/export/ballots?poll=1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var doc any
+	require.NoError(t, json.Unmarshal(rsp.Body(), &doc))
+	got, err := json.MarshalIndent(shape(doc), "", "  ")
+	require.NoError(t, err)
+	file := filepath.Join("testdata", "ballots_export.shape.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(file, got, 0o644))
+	} else {
+		want, err := os.ReadFile(file)
+		require.NoError(t, err, "run with -update to create the golden file")
+		assert.Equal(t, string(want), string(got))
+	}
+
+	var export struct {
+		Poll    int
+		Ballots []db.EncryptedBallot
+	}
+	require.NoError(t, json.Unmarshal(rsp.Body(), &export))
+	assert.Equal(t, 1, export.Poll)
+	require.Len(t, export.Ballots, 3)
+
+	hash := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	code := hash("poll-1")
+	for _, ballot := range export.Ballots {
+		require.Len(t, ballot.Contests, 1)
+		assert.Equal(t, "poll-1", ballot.StyleId)
+		assert.Equal(t, "poll-1", ballot.Contests[0].ObjectId)
+		assert.Equal(t, hash(string(ballot.Contests[0].Ciphertext)), ballot.CryptoHash)
+		assert.Equal(t, "ballot-"+ballot.CryptoHash[:16], ballot.ObjectId)
+		assert.Equal(t, code, ballot.CodeSeed)
+		assert.Equal(t, hash(ballot.CodeSeed+ballot.CryptoHash), ballot.Code)
+		code = ballot.Code
+	}
+}
@@ -0,0 +1,66 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_CoalescedReads reads one voter from many clients at once, some
+// conditionally and some in API version 2, and checks each gets the answer
+// to its own request rather than one shared with a different request
+func Test_CoalescedReads(t *testing.T) {
+	const clients = 48
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Popular Voter"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	etag := rsp.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	//What a conditional read gets on its own
+	rsp, err = s.cli.R().SetHeader("If-None-Match", etag).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	conditional := rsp.StatusCode()
+
+	var wg sync.WaitGroup
+	for round := 0; round < 10; round++ {
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func(kind int) {
+				defer wg.Done()
+				req := s.cli.R()
+				switch kind {
+				case 1:
+					req.SetHeader("If-None-Match", etag)
+				case 2:
+					req.SetHeader("API-Version", "2")
+				}
+				rsp, err := req.Get(s.base + "/voters/1")
+				if !assert.NoError(t, err) {
+					return
+				}
+				switch kind {
+				case 1:
+					assert.Equal(t, conditional, rsp.StatusCode())
+				case 2:
+					assert.Equal(t, http.StatusOK, rsp.StatusCode())
+					assert.True(t, strings.Contains(rsp.String(), `"voter_id"`), rsp.String())
+				default:
+					assert.Equal(t, http.StatusOK, rsp.StatusCode())
+					assert.True(t, strings.Contains(rsp.String(), `"VoterId"`), rsp.String())
+				}
+			}(i % 3)
+		}
+		wg.Wait()
+	}
+}
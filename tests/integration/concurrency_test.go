@@ -0,0 +1,91 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_ConcurrentVotes records votes for one voter from many clients at
+// once, through the routes, and checks that none of them is lost and no
+// poll gets two
+func Test_ConcurrentVotes(t *testing.T) {
+	const polls, clients = 1000, 64
+	s := startServer(t, "-history-quota", "0")
+
+	for _, id := range []int{1, 2} {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: fmt.Sprint("Voter ", id)}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+
+	//Every poll once, spread over the clients
+	next := make(chan int)
+	go func() {
+		for poll := 1; poll <= polls; poll++ {
+			next <- poll
+		}
+		close(next)
+	}()
+	var recorded atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for poll := range next {
+				rsp, err := s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).
+					Post(fmt.Sprintf("%s/voters/1/polls/%d", s.base, poll))
+				if assert.NoError(t, err) && assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String()) {
+					recorded.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var history []db.VoterHistory
+	rsp, err := s.cli.R().SetResult(&history).Get(s.base + "/voters/1/polls")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, int64(polls), recorded.Load())
+	assert.Len(t, history, polls)
+	voteIds := make(map[int]bool)
+	for _, vote := range history {
+		voteIds[vote.VoteId] = true
+	}
+	assert.Len(t, voteIds, polls)
+
+	//The same vote sent by every client at once is recorded once
+	statuses := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/2/polls/1")
+			if assert.NoError(t, err) {
+				statuses <- rsp.StatusCode()
+			}
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusConflict: clients - 1}, counts)
+
+	rsp, err = s.cli.R().SetResult(&history).Get(s.base + "/voters/2/polls")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
@@ -0,0 +1,75 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+)
+
+// container is a database server the tests run in docker.  The tests of a
+// backend that needs one are skipped where docker is not available.
+type container struct {
+	repository string
+	tag        string
+	env        []string
+	port       string   //Port in the container, published on a free local port
+	ready      []string //Command run in the container until it succeeds
+}
+
+// postgresContainer runs the PostgreSQL server of the postgres backend
+var postgresContainer = container{
+	repository: "postgres",
+	tag:        "16-alpine",
+	env:        []string{"POSTGRES_PASSWORD=voters"},
+	port:       "5432/tcp",
+	//Over TCP, the server the image runs while it initializes only
+	//listens on its socket
+	ready: []string{"pg_isready", "-h", "127.0.0.1", "-U", "postgres"},
+}
+
+// mongoContainer runs the MongoDB server of the mongo backend, the store
+// needs no replica set
+var mongoContainer = container{
+	repository: "mongo",
+	tag:        "7",
+	port:       "27017/tcp",
+	ready:      []string{"mongosh", "--quiet", "--eval", "db.runCommand({ping: 1})"},
+}
+
+// start runs the container for the rest of the test and returns the local
+// address of its port once it is ready
+func (c container) start(t *testing.T) string {
+	pool, err := dockertest.NewPool("")
+	if err != nil || pool.Client.Ping() != nil {
+		t.Skipf("%s needs docker", c.repository)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   c.repository,
+		Tag:          c.tag,
+		Env:          c.env,
+		ExposedPorts: []string{c.port},
+		PortBindings: map[docker.Port][]docker.PortBinding{docker.Port(c.port): {{HostIP: "127.0.0.1"}}},
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+	})
+	require.NoError(t, err, "starting %s:%s", c.repository, c.tag)
+	t.Cleanup(func() { pool.Purge(resource) })
+
+	pool.MaxWait = time.Minute
+	err = pool.Retry(func() error {
+		code, err := resource.Exec(c.ready, dockertest.ExecOptions{})
+		if err == nil && code != 0 {
+			err = fmt.Errorf("%v exited with %d", c.ready, code)
+		}
+		return err
+	})
+	require.NoError(t, err, "%s did not come up", c.repository)
+	return resource.GetHostPort(c.port)
+}
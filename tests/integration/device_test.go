@@ -0,0 +1,136 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerDevice registers a device and returns it with its enrollment token
+func registerDevice(t *testing.T, s *server, device db.Device) (db.Device, string) {
+	var registered struct {
+		Device          db.Device
+		EnrollmentToken string `json:"enrollment_token"`
+	}
+	rsp, err := s.cli.R().SetBody(device).SetResult(&registered).Post(s.base + "/admin/devices")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	return registered.Device, registered.EnrollmentToken
+}
+
+// enroll trades an enrollment token for the device credential
+func enroll(t *testing.T, s *server, token string) (int, string) {
+	var enrolled struct{ Token string }
+	rsp, err := s.cli.R().SetBody(map[string]string{"EnrollmentToken": token}).SetResult(&enrolled).
+		Post(s.base + "/devices/enroll")
+	require.NoError(t, err)
+	return rsp.StatusCode(), enrolled.Token
+}
+
+// asDevice is a request made with a device credential
+func asDevice(s *server, token string) *resty.Request {
+	return s.cli.R().SetHeader("Authorization", "Bearer "+token)
+}
+
+// Test_KioskAuth checks that only an enrolled kiosk gets into the /kiosk
+// routes: no credential, a made up one, a registered device of another
+// kind and a kiosk taken off the register are all turned away
+func Test_KioskAuth(t *testing.T) {
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Kiosk Voter", PrecinctId: 4}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	kiosk, enrollment := registerDevice(t, s, db.Device{Name: "Library kiosk", Kind: db.DeviceKiosk, PrecinctId: 4})
+	status, kioskToken := enroll(t, s, enrollment)
+	require.Equal(t, http.StatusOK, status)
+	_, enrollment = registerDevice(t, s, db.Device{Name: "Tablet", Kind: db.DeviceTablet, PrecinctId: 4})
+	status, tabletToken := enroll(t, s, enrollment)
+	require.Equal(t, http.StatusOK, status)
+
+	search := func(req *resty.Request) int {
+		rsp, err := req.Get(s.base + "/kiosk/voters?name=Kiosk")
+		require.NoError(t, err)
+		return rsp.StatusCode()
+	}
+	checkIn := func(req *resty.Request) int {
+		rsp, err := req.Post(s.base + "/kiosk/voters/1/checkin")
+		require.NoError(t, err)
+		return rsp.StatusCode()
+	}
+
+	for name, req := range map[string]func() *resty.Request{
+		"no credential":   s.cli.R,
+		"made up":         func() *resty.Request { return asDevice(s, "not-a-device-token") },
+		"enrollment used": func() *resty.Request { return asDevice(s, enrollment) },
+	} {
+		assert.Equal(t, http.StatusUnauthorized, search(req()), name)
+		assert.Equal(t, http.StatusUnauthorized, checkIn(req()), name)
+	}
+	assert.Equal(t, http.StatusForbidden, search(asDevice(s, tabletToken)))
+	assert.Equal(t, http.StatusForbidden, checkIn(asDevice(s, tabletToken)))
+
+	assert.Equal(t, http.StatusOK, search(asDevice(s, kioskToken)))
+
+	rsp, err = s.cli.R().Delete(fmt.Sprintf("%s/admin/devices/%d", s.base, kiosk.DeviceId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, http.StatusUnauthorized, search(asDevice(s, kioskToken)))
+	assert.Equal(t, http.StatusUnauthorized, checkIn(asDevice(s, kioskToken)))
+}
+
+// Test_DeviceAuth checks the device credential lifecycle on the heartbeat
+// route: unknown credentials and reused enrollment tokens are refused, an
+// expired credential stops working until the device enrolls again and a
+// revoked device is locked out for good
+func Test_DeviceAuth(t *testing.T) {
+	s := startServer(t)
+
+	heartbeat := func(token string) int {
+		rsp, err := asDevice(s, token).SetBody(db.DeviceReport{}).Post(s.base + "/devices/heartbeat")
+		require.NoError(t, err)
+		return rsp.StatusCode()
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, heartbeat("not-a-device-token"))
+
+	device, enrollment := registerDevice(t, s, db.Device{Name: "Tablet", Kind: db.DeviceTablet, PrecinctId: 4})
+	//Registered but not enrolled yet, the enrollment token is no credential
+	assert.Equal(t, http.StatusUnauthorized, heartbeat(enrollment))
+	status, token := enroll(t, s, enrollment)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, http.StatusOK, heartbeat(token))
+	status, _ = enroll(t, s, enrollment)
+	assert.Equal(t, http.StatusUnauthorized, status, "an enrollment token works once")
+
+	var expired struct {
+		EnrollmentToken string `json:"enrollment_token"`
+	}
+	rsp, err := s.cli.R().SetResult(&expired).Post(fmt.Sprintf("%s/admin/devices/%d/expire", s.base, device.DeviceId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, http.StatusUnauthorized, heartbeat(token))
+	status, token = enroll(t, s, expired.EnrollmentToken)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, http.StatusOK, heartbeat(token))
+
+	rsp, err = s.cli.R().Post(fmt.Sprintf("%s/admin/devices/%d/revoke", s.base, device.DeviceId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, http.StatusUnauthorized, heartbeat(token))
+
+	var stored db.Device
+	rsp, err = s.cli.R().SetResult(&stored).Get(fmt.Sprintf("%s/admin/devices/%d", s.base, device.DeviceId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.True(t, stored.Revoked)
+	assert.False(t, stored.LastSeen.IsZero())
+}
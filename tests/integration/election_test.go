@@ -0,0 +1,101 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_PollLock checks that once an election is counting, the votes of
+// its polls can not be added, changed or removed through any of the
+// routes that write a vote history, while the rest of a voter still can
+func Test_PollLock(t *testing.T) {
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Poll{PollId: 7, Title: "Library levy", Options: []string{"Yes", "No"}}).Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	for _, voter := range []db.Voter{{VoterId: 5, Name: "Jane Smith"}, {VoterId: 6, Name: "John Doe"}} {
+		rsp, err = s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	election := db.Election{ElectionId: 1, Name: "General", ElectionDay: time.Now().AddDate(0, 1, 0), PollIds: []int{7}}
+	rsp, err = s.cli.R().SetBody(election).Post(s.base + "/elections")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	transition := func(state db.ElectionState) {
+		t.Helper()
+		rsp, err := s.cli.R().SetBody(map[string]any{"State": state}).
+			Post(fmt.Sprintf("%s/elections/%d/transition", s.base, election.ElectionId))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	transition(db.StateRegistrationOpen)
+	transition(db.StateVoting)
+
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/5/polls/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	transition(db.StateCounting)
+
+	var voter db.Voter
+	rsp, err = s.cli.R().SetResult(&voter).Get(s.base + "/voters/5")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.Len(t, voter.VoteHistory, 1)
+
+	locked := func(rsp *resty.Response, err error, apiErr *client.Error) {
+		t.Helper()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, rsp.StatusCode(), rsp.String())
+		assert.Equal(t, client.CodePollLocked, apiErr.Code)
+	}
+	vote := []db.VoterHistory{{PollId: 7, VoteDate: time.Now()}}
+
+	var apiErr client.Error
+	rsp, err = s.cli.R().SetBody(vote[0]).SetError(&apiErr).Post(s.base + "/voters/6/polls/7")
+	locked(rsp, err, &apiErr)
+
+	apiErr = client.Error{}
+	rsp, err = s.cli.R().SetBody(db.Voter{Name: "John Doe", VoteHistory: vote}).SetError(&apiErr).Put(s.base + "/voters/6")
+	locked(rsp, err, &apiErr)
+
+	apiErr = client.Error{}
+	rsp, err = s.cli.R().SetBody(map[string]any{"VoteHistory": []any{}}).SetError(&apiErr).Patch(s.base + "/voters/5")
+	locked(rsp, err, &apiErr)
+
+	apiErr = client.Error{}
+	rsp, err = s.cli.R().SetBody(vote).SetError(&apiErr).Post(s.base + "/voters/6/polls:sync")
+	locked(rsp, err, &apiErr)
+
+	//Nothing was written
+	var history []db.VoterHistory
+	rsp, err = s.cli.R().SetResult(&history).Get(s.base + "/voters/6/polls")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	//Changes that leave the votes as they are still go through, a sync
+	//that only repeats a stored vote included
+	voter.Name = "Jane Doe"
+	rsp, err = s.cli.R().SetBody(voter).Put(s.base + "/voters/5")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().SetBody(map[string]any{"Email": "jane@example.com"}).Patch(s.base + "/voters/5")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().SetBody(vote).Post(s.base + "/voters/5/polls:sync")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+}
@@ -0,0 +1,104 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_IdempotentPolls manages a poll the way a declarative tool does:
+// replayed creates, upserts and writes conditional on the ETag read
+func Test_IdempotentPolls(t *testing.T) {
+	s := startServer(t)
+
+	poll := db.Poll{PollId: 7, Title: "Library levy", Options: []string{"Yes", "No"}}
+	rsp, err := s.cli.R().SetBody(poll).Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, "/polls/7", rsp.Header().Get("Location"))
+	etag := rsp.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rsp, err = s.cli.R().SetBody(poll).Post(s.base + "/polls")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, etag, rsp.Header().Get("ETag"))
+
+	changed := poll
+	changed.Title = "Park levy"
+	rsp, err = s.cli.R().SetBody(changed).Post(s.base + "/polls")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-None-Match", etag).Get(s.base + "/polls/7")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-None-Match", "*").SetBody(changed).Put(s.base + "/polls/7")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).SetBody(changed).Put(s.base + "/polls/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.NotEqual(t, etag, rsp.Header().Get("ETag"))
+
+	//The ETag read before the change is stale now
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).Delete(s.base + "/polls/7")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.Poll{Title: "School board", Options: []string{"A", "B"}}).Put(s.base + "/polls/8")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, "/polls/8", rsp.Header().Get("Location"))
+
+	rsp, err = s.cli.R().SetBody(db.Poll{Title: "School board", Options: []string{"A", "B"}}).Put(s.base + "/polls/8")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+}
+
+// Test_IdempotentPollingPlaces checks the same semantics for polling
+// places and the conditional writes of voters
+func Test_IdempotentPollingPlaces(t *testing.T) {
+	s := startServer(t)
+
+	place := db.PollingPlace{PollingPlaceId: 3, Name: "Fire Hall", PrecinctIds: []int{2}}
+	rsp, err := s.cli.R().SetBody(place).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(place).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	place.Name = "Town Hall"
+	rsp, err = s.cli.R().SetBody(place).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(place).Put(s.base + "/polling-places/3")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Conditional Voter"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	etag := rsp.Header().Get("ETag")
+
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).SetBody(map[string]any{"Email": "cv@example.com"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetHeader("If-Match", etag).Delete(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+}
@@ -0,0 +1,83 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_ExclusionMatch matches the roll against an uploaded list and checks
+// that close matches are reported and flagged for review while every
+// voter stays on the roll as they were
+func Test_ExclusionMatch(t *testing.T) {
+	s := startServer(t)
+
+	voters := []db.Voter{
+		{VoterId: 1, Name: "Jane Smith", Email: "jane@example.com"},
+		{VoterId: 2, Name: "Jon Doe", Email: "jon@example.com"},
+		{VoterId: 3, Name: "Alice Walker", Email: "alice@example.com"},
+	}
+	for _, voter := range voters {
+		rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	var list db.ExclusionList
+	rsp, err := s.cli.R().SetBody(db.ExclusionList{Name: "Death records", Entries: []db.ExclusionEntry{
+		{Name: "JANE SMITH", Reason: "deceased"},
+		{Name: "John Doe", Reason: "deceased"},
+	}}).SetResult(&list).Post(s.base + "/admin/exclusions")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var report db.ExclusionReport
+	rsp, err = s.cli.R().SetBody(map[string]any{"Keys": []string{"name"}, "Threshold": 0.8}).SetResult(&report).
+		Post(fmt.Sprintf("%s/admin/exclusions/%d/match", s.base, list.Id))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	//An exact match up to case scores highest, a near match follows
+	require.Len(t, report.Candidates, 2)
+	assert.Equal(t, 1, report.Candidates[0].VoterId)
+	assert.Equal(t, 1.0, report.Candidates[0].Score)
+	assert.Equal(t, 2, report.Candidates[1].VoterId)
+	assert.Equal(t, "John Doe", report.Candidates[1].Entry.Name)
+
+	var stored db.ExclusionReport
+	rsp, err = s.cli.R().SetResult(&stored).Get(fmt.Sprintf("%s/admin/exclusions/reports/%d", s.base, report.Id))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, report.Candidates, stored.Candidates)
+
+	var items []db.ReviewItem
+	rsp, err = s.cli.R().SetResult(&items).Get(s.base + "/admin/reviews?kind=" + db.ReviewDuplicate)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	var flagged []int
+	for _, item := range items {
+		flagged = append(flagged, item.VoterId)
+	}
+	assert.ElementsMatch(t, []int{1, 2}, flagged)
+
+	//Nobody is removed or changed by a match
+	for _, voter := range voters {
+		var got db.Voter
+		rsp, err = s.cli.R().SetResult(&got).Get(fmt.Sprintf("%s/voters/%d", s.base, voter.VoterId))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		assert.Equal(t, voter.Name, got.Name)
+		assert.Equal(t, voter.Email, got.Email)
+	}
+	var count struct{ Count int }
+	rsp, err = s.cli.R().SetResult(&count).Get(s.base + "/voters/count")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, len(voters), count.Count)
+}
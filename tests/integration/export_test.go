@@ -0,0 +1,82 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/adllev/voter-api/bundle"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportVoters is enough voters for an export to be split over several
+// workers
+const exportVoters = 5000
+
+// Test_ExportDeterministic imports voters in random order and checks that
+// the exports, encoded in parallel, come out in VoterId order and the
+// same on every run
+func Test_ExportDeterministic(t *testing.T) {
+	s := startServer(t)
+
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	for _, i := range rand.Perm(exportVoters) {
+		voter := db.Voter{
+			VoterId: i + 1,
+			Name:    fmt.Sprintf("Voter %d", i+1),
+			Address: db.Address{Street: fmt.Sprintf("%d Main St", i+1), City: "Springfield", State: "IL", Zip: "62701"},
+		}
+		require.NoError(t, enc.Encode(voter))
+	}
+	var pkg bytes.Buffer
+	require.NoError(t, bundle.Write(&pkg, bundle.File{Name: "voters.jsonl", Data: data.Bytes(), Records: exportVoters}))
+
+	var report db.ImportReport
+	rsp, err := s.cli.R().SetBody(pkg.Bytes()).SetResult(&report).Post(s.base + "/admin/import/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.Equal(t, exportVoters, report.Added)
+
+	//Backups carry a creation time in the manifest, compare the voters
+	var backups [2][]byte
+	for i := range backups {
+		rsp, err = s.cli.R().Get(s.base + "/admin/export/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+		_, files, err := bundle.Read(bytes.NewReader(rsp.Body()))
+		require.NoError(t, err)
+		backups[i] = files["voters.jsonl"]
+	}
+	assert.Equal(t, backups[0], backups[1])
+
+	dec := json.NewDecoder(bytes.NewReader(backups[0]))
+	for id := 1; id <= exportVoters; id++ {
+		var voter db.Voter
+		require.NoError(t, dec.Decode(&voter))
+		require.Equal(t, id, voter.VoterId)
+	}
+
+	var labels [2]string
+	for i := range labels {
+		rsp, err = s.cli.R().Get(s.base + "/voters/export")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+		labels[i] = rsp.String()
+	}
+	assert.Equal(t, labels[0], labels[1])
+
+	rows := strings.Split(strings.TrimSpace(labels[0]), "\n")
+	require.Len(t, rows, exportVoters+1)
+	assert.True(t, strings.HasPrefix(rows[1], "Voter 1,"))
+	assert.True(t, strings.HasPrefix(rows[exportVoters], fmt.Sprintf("Voter %d,", exportVoters)))
+}
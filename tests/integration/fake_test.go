@@ -0,0 +1,150 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_FakeContract runs the same calls against the API server, the fake
+// and the fake server, so the fakes integrators test against can not drift
+// from what the server really does
+func Test_FakeContract(t *testing.T) {
+	targets := []struct {
+		name  string
+		start func(t *testing.T) client.API
+	}{
+		{"server", func(t *testing.T) client.API {
+			cli := client.New(startServer(t).base)
+			cli.PageSize = 2
+			return cli
+		}},
+		{"fake", func(t *testing.T) client.API {
+			return client.NewFake()
+		}},
+		{"fake server", func(t *testing.T) client.API {
+			srv := client.NewFakeServer(client.NewFake())
+			t.Cleanup(srv.Close)
+			cli := client.New(srv.URL)
+			cli.PageSize = 2
+			return cli
+		}},
+	}
+
+	for _, target := range targets {
+		t.Run(target.name, func(t *testing.T) {
+			checkContract(t, target.start(t))
+		})
+	}
+}
+
+// checkContract checks the answers of an API that starts without voters
+func checkContract(t *testing.T, api client.API) {
+	ctx := context.Background()
+
+	voter, err := api.CreateVoter(ctx, db.Voter{Name: "Contract Voter"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, voter.VoterId)
+
+	_, err = api.CreateVoter(ctx, db.Voter{VoterId: 1, Name: "Contract Voter"})
+	assertAPIError(t, err, http.StatusConflict, client.CodeConflict)
+
+	_, err = api.CreateVoter(ctx, db.Voter{})
+	apiErr := assertAPIError(t, err, http.StatusBadRequest, client.CodeInvalidFields)
+	if assert.Len(t, apiErr.Fields, 1) {
+		assert.Equal(t, "Name", apiErr.Fields[0].Field)
+	}
+
+	_, err = api.GetVoter(ctx, 99)
+	assertAPIError(t, err, http.StatusNotFound, client.CodeVoterNotFound)
+
+	now := time.Now()
+	vote, err := api.AddVote(ctx, 1, 1, db.VoterHistory{VoteId: 42, VoteDate: now})
+	require.NoError(t, err)
+	assert.Equal(t, 1, vote.PollId)
+	assert.Equal(t, 1, vote.VoteId)
+	assert.WithinDuration(t, now, vote.ClientVoteDate, time.Second)
+
+	_, err = api.AddVote(ctx, 1, 1, db.VoterHistory{VoteDate: now})
+	assertAPIError(t, err, http.StatusConflict, client.CodeAlreadyVoted)
+
+	_, err = api.AddVote(ctx, 1, 2, db.VoterHistory{})
+	assertAPIError(t, err, http.StatusBadRequest, client.CodeInvalidVoteDate)
+
+	_, err = api.AddVote(ctx, 99, 1, db.VoterHistory{VoteDate: now})
+	assertAPIError(t, err, http.StatusNotFound, client.CodeVoterNotFound)
+
+	stored, err := api.GetVote(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, vote.VoteId, stored.VoteId)
+
+	_, err = api.GetVote(ctx, 1, 2)
+	assertAPIError(t, err, http.StatusNotFound, client.CodePollNotFound)
+
+	history, err := api.VoterHistory(ctx, 1).All()
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	updated, err := api.UpdateVoter(ctx, db.Voter{VoterId: 1, Name: "Renamed Voter", VoteHistory: history})
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed Voter", updated.Name)
+	if assert.Len(t, updated.VoteHistory, 1) {
+		assert.Equal(t, vote.VoteId, updated.VoteHistory[0].VoteId)
+	}
+
+	_, err = api.UpdateVoter(ctx, db.Voter{VoterId: 99, Name: "Contract Voter"})
+	assertAPIError(t, err, http.StatusNotFound, client.CodeVoterNotFound)
+
+	changed, err := api.UpdateVote(ctx, 1, 1, db.VoterHistory{VoteId: 42, VoteDate: now, Channel: "online"})
+	require.NoError(t, err)
+	assert.Equal(t, vote.VoteId, changed.VoteId)
+	assert.Equal(t, "online", changed.Channel)
+
+	_, err = api.UpdateVote(ctx, 1, 2, db.VoterHistory{VoteDate: now})
+	assertAPIError(t, err, http.StatusNotFound, client.CodePollNotFound)
+
+	require.NoError(t, api.DeleteVote(ctx, 1, 1))
+	assertAPIError(t, api.DeleteVote(ctx, 1, 1), http.StatusNotFound, client.CodePollNotFound)
+
+	health, err := api.Health(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", health.Status)
+
+	for id := 2; id <= 5; id++ {
+		_, err := api.CreateVoter(ctx, db.Voter{VoterId: id, Name: "Contract Voter"})
+		require.NoError(t, err)
+	}
+	require.NoError(t, api.DeleteVoter(ctx, 5))
+	assertAPIError(t, api.DeleteVoter(ctx, 5), http.StatusNotFound, client.CodeVoterNotFound)
+
+	voters, err := api.Voters(ctx, nil).All()
+	require.NoError(t, err)
+	ids := make([]int, len(voters))
+	for i, voter := range voters {
+		ids[i] = voter.VoterId
+	}
+	assert.Equal(t, []int{1, 2, 3, 4}, ids)
+}
+
+// assertAPIError checks that err is an *Error with a status and code and
+// returns it
+func assertAPIError(t *testing.T, err error, status int, code string) *client.Error {
+	t.Helper()
+
+	var apiErr *client.Error
+	if !assert.True(t, errors.As(err, &apiErr), "want an *Error, got %v", err) {
+		return &client.Error{}
+	}
+	assert.Equal(t, status, apiErr.Status)
+	assert.Equal(t, code, apiErr.Code)
+	return apiErr
+}
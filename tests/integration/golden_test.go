@@ -0,0 +1,91 @@
+//go:build integration
+
+package integration
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update rewrites the golden files instead of comparing against them
+//
+//	go test -tags integration ./tests/integration/ -run Golden -update
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// serverTime matches timestamps the server fills in itself.  Those always
+// carry fractional seconds, the fixtures never do, so fixture dates stay
+// in the golden files and only the volatile ones are masked.
+var serverTime = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d+(Z|[+-]\d{2}:\d{2})`)
+
+// goldenCase is one request whose response body is compared
+type goldenCase struct {
+	name string
+	path string
+}
+
+// goldenCases are the responses pinned per API version.  Add a case for
+// every new endpoint whose output clients rely on.
+var goldenCases = map[string][]goldenCase{
+	"v1": {
+		{"voter.json", "/voters/30"},
+		{"voters.json", "/voters"},
+		{"voter_polls.json", "/voters/30/polls"},
+		{"voter_poll.json", "/voters/30/polls/1"},
+		{"voters_count.json", "/voters/count"},
+		{"voters_export.csv", "/voters/export"},
+		{"voter_not_found.json", "/voters/999"},
+		{"polling_place.json", "/polling-places/1"},
+	},
+}
+
+func Test_Golden(t *testing.T) {
+	s := startServer(t)
+
+	voter := db.Voter{
+		VoterId: 30,
+		Name:    "Jane Smith",
+		Email:   "jane@example.com",
+		Address: db.Address{Street: "1 Main St", City: "Springfield", State: "PA", Zip: "19064"},
+		VoteHistory: []db.VoterHistory{
+			{PollId: 1, VoteId: 1, VoteDate: time.Date(2022, 11, 8, 9, 30, 0, 0, time.UTC)},
+		},
+	}
+	rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	place := db.PollingPlace{PollingPlaceId: 1, Name: "Springfield Library", PrecinctIds: []int{4}}
+	rsp, err = s.cli.R().SetBody(place).Post(s.base + "/polling-places")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	for version, cases := range goldenCases {
+		for _, gc := range cases {
+			t.Run(version+"/"+gc.name, func(t *testing.T) {
+				rsp, err := s.cli.R().Get(s.base + gc.path)
+				require.NoError(t, err)
+
+				got := serverTime.ReplaceAll(rsp.Body(), []byte("<time>"))
+				file := filepath.Join("testdata", version, gc.name+".golden")
+				if *update {
+					require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+					require.NoError(t, os.WriteFile(file, got, 0o644))
+					return
+				}
+
+				want, err := os.ReadFile(file)
+				require.NoError(t, err, "run with -update to create the golden file")
+				assert.Equal(t, string(want), string(got))
+			})
+		}
+	}
+}
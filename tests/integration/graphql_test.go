@@ -0,0 +1,157 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// graphqlResponse is the answer of /graphql
+type graphqlResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Path       []any          `json:"path"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// graphqlRequest posts a query with its variables to /graphql
+func graphqlRequest(t *testing.T, req *resty.Request, base, query string, variables map[string]any) (int, graphqlResponse) {
+	var rsp graphqlResponse
+	r, err := req.SetBody(map[string]any{"query": query, "variables": variables}).
+		SetResult(&rsp).SetError(&rsp).Post(base + "/graphql")
+	require.NoError(t, err)
+	return r.StatusCode(), rsp
+}
+
+// Test_GraphQL manages a voter and its votes through /graphql and reads
+// back only the fields it asks for
+func Test_GraphQL(t *testing.T) {
+	s := startServer(t)
+
+	status, rsp := graphqlRequest(t, s.cli.R(), s.base, `
+		mutation Add($voter: VoterInput!) {
+			createVoter(voter: $voter) { voterId name }
+		}`, map[string]any{"voter": map[string]any{
+		"name": "Jane Smith", "email": "jane@example.com", "address": map[string]any{"city": "Trenton"},
+	}})
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, rsp.Errors)
+	created := rsp.Data["createVoter"].(map[string]any)
+	assert.Equal(t, map[string]any{"voterId": float64(1), "name": "Jane Smith"}, created)
+
+	for _, poll := range []int{3, 4} {
+		status, rsp = graphqlRequest(t, s.cli.R(), s.base, `
+			mutation Vote($poll: Int!, $date: DateTime!) {
+				recordVote(voterId: 1, pollId: $poll, vote: {choice: "yes", voteDate: $date}) { pollId voteId }
+			}`, map[string]any{"poll": poll, "date": time.Now().UTC().Format(time.RFC3339)})
+		require.Equal(t, http.StatusOK, status)
+		require.Empty(t, rsp.Errors)
+	}
+
+	//Only the selected fields come back, the history narrowed to a poll
+	status, rsp = graphqlRequest(t, s.cli.R(), s.base, `
+		query {
+			voter(id: 1) {
+				name
+				city: address { city }
+				...history
+			}
+			missing: voter(id: 99) { name }
+			voterCount
+		}
+		fragment history on Voter {
+			voteHistory(pollId: 4) { pollId choice }
+		}`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, rsp.Errors)
+	assert.Equal(t, map[string]any{
+		"name": "Jane Smith",
+		"city": map[string]any{"city": "Trenton"},
+		"voteHistory": []any{
+			map[string]any{"pollId": float64(4), "choice": "yes"},
+		},
+	}, rsp.Data["voter"])
+	assert.Nil(t, rsp.Data["missing"])
+	assert.Equal(t, float64(1), rsp.Data["voterCount"])
+
+	status, rsp = graphqlRequest(t, s.cli.R(), s.base, `
+		mutation {
+			patchVoter(id: 1, voter: {email: null, tags: ["volunteer"]}) { email tags }
+			deleteVote(voterId: 1, pollId: 3)
+		}`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, rsp.Errors)
+	assert.Equal(t, map[string]any{"email": "", "tags": []any{"volunteer"}}, rsp.Data["patchVoter"])
+	assert.Equal(t, true, rsp.Data["deleteVote"])
+
+	status, rsp = graphqlRequest(t, s.cli.R(), s.base, `{ voters(tag: "volunteer", sort: NAME) { voterId voteHistory { pollId } } }`, nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []any{map[string]any{"voterId": float64(1), "voteHistory": []any{map[string]any{"pollId": float64(4)}}}},
+		rsp.Data["voters"])
+
+	//Errors of the REST route come back with their code
+	status, rsp = graphqlRequest(t, s.cli.R(), s.base, `mutation { updateVoter(id: 2, voter: {name: "John Doe"}) { voterId } }`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, rsp.Errors, 1)
+	assert.Equal(t, "VOTER_NOT_FOUND", rsp.Errors[0].Extensions["code"])
+	assert.Equal(t, []any{"updateVoter"}, rsp.Errors[0].Path)
+
+	status, rsp = graphqlRequest(t, s.cli.R(), s.base, `{ voter(id: 1) { ssn } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	require.Len(t, rsp.Errors, 1)
+	assert.Nil(t, rsp.Data)
+
+	r, err := s.cli.R().SetQueryParam("query", `mutation { deleteVoter(id: 1) { voterId } }`).Get(s.base + "/graphql")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, r.StatusCode())
+
+	r, err = s.cli.R().Get(s.base + "/graphql/schema")
+	require.NoError(t, err)
+	assert.Contains(t, r.String(), "voter(id: Int!, asOf: DateTime): Voter")
+}
+
+// Test_GraphQLAccessControl checks that a GraphQL caller sees and changes
+// no more than it could through the REST routes
+func Test_GraphQLAccessControl(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"Keys": [
+			{"Key": "root-secret", "Name": "root", "Role": "admin"},
+			{"Key": "clerk-secret", "Name": "clerk", "Role": "clerk", "Scopes": ["read"]}
+		],
+		"Roles": {"admin": ["*"], "clerk": ["VoterId", "Name"]}
+	}`), 0o600))
+	s := startServer(t, "-access", config)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+	clerk := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "clerk-secret") }
+
+	status, rsp := graphqlRequest(t, root(), s.base,
+		`mutation { createVoter(voter: {voterId: 1, name: "Jane Smith", email: "jane@example.com"}) { voterId } }`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, rsp.Errors)
+
+	//Fields the clerk's role may not see are null
+	status, rsp = graphqlRequest(t, clerk(), s.base, `{ voter(id: 1) { name email } }`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, rsp.Errors)
+	assert.Equal(t, map[string]any{"name": "Jane Smith", "email": nil}, rsp.Data["voter"])
+
+	//A read scoped key can query but not change the roll
+	status, rsp = graphqlRequest(t, clerk(), s.base, `mutation { deleteVoter(id: 1) { voterId } }`, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, rsp.Errors, 1)
+	assert.Equal(t, "FORBIDDEN", rsp.Errors[0].Extensions["code"])
+
+	status, _ = graphqlRequest(t, s.cli.R().SetHeader("X-API-Key", "wrong"), s.base, `{ voter(id: 1) { name } }`, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
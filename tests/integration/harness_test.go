@@ -0,0 +1,225 @@
+//go:build integration
+
+// Package integration runs the whole API as a separate process against
+// each storage backend and checks that data survives a restart where the
+// backend promises it.  Unlike the tests in the parent directory it needs
+// no server to be running, every test starts its own.  The backends with
+// a database server of their own start it in docker and are skipped where
+// docker is not available.
+//
+//	go test -tags integration ./tests/integration/
+package integration
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend is a storage configuration the API is tested against.  setup
+// returns the server flags for it, a backend that needs a database server
+// starts it in a container, see container.
+type backend struct {
+	name       string
+	persistent bool //Voters survive a restart of the server
+	archived   bool //Only archived voters do, the restart test archives first
+	setup      func(t *testing.T) []string
+}
+
+var backends = []backend{
+	{
+		name:  "memory",
+		setup: func(t *testing.T) []string { return nil },
+	},
+	{
+		//Voters only survive a restart once they are archived, that is
+		//exactly what the restart test does for persistent backends
+		name:       "memory+archive",
+		persistent: true,
+		archived:   true,
+		setup: func(t *testing.T) []string {
+			return []string{"-archive", filepath.Join(t.TempDir(), "archive")}
+		},
+	},
+	{
+		name:       "bolt",
+		persistent: true,
+		setup: func(t *testing.T) []string {
+			return []string{"-bolt", filepath.Join(t.TempDir(), "voters.db")}
+		},
+	},
+	{
+		name:       "postgres",
+		persistent: true,
+		setup: func(t *testing.T) []string {
+			addr := postgresContainer.start(t)
+			return []string{"-postgres", "postgres://postgres:voters@" + addr + "/postgres?sslmode=disable"}
+		},
+	},
+	{
+		name:       "mongo",
+		persistent: true,
+		setup: func(t *testing.T) []string {
+			addr := mongoContainer.start(t)
+			return []string{"-storage", "mongo", "-mongo", "mongodb://" + addr + "/voters"}
+		},
+	},
+}
+
+// serverBinary is the API binary built for the test run
+var serverBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "voter-api-integration")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serverBinary = filepath.Join(dir, "voter-api")
+	out, err := exec.Command("go", "build", "-o", serverBinary, "../..").CombinedOutput()
+	if err != nil {
+		log.Fatalf("error building server: %v\n%s", err, out)
+	}
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// server is a running API process
+type server struct {
+	cmd  *exec.Cmd
+	base string
+	cli  *resty.Client
+}
+
+// startServer starts the API on a free port with the given flags and waits
+// until it answers its health check
+func startServer(t *testing.T, args ...string) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	args = append([]string{"-h", "127.0.0.1", "-p", fmt.Sprint(port)}, args...)
+	cmd := exec.Command(serverBinary, args...)
+	require.NoError(t, cmd.Start())
+
+	s := &server{
+		cmd:  cmd,
+		base: fmt.Sprintf("http://127.0.0.1:%d", port),
+		cli:  resty.New(),
+	}
+	t.Cleanup(s.stop)
+
+	for i := 0; i < 50; i++ {
+		rsp, err := s.cli.R().Get(s.base + "/voters/health")
+		if err == nil && rsp.StatusCode() == http.StatusOK {
+			return s
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("server with %v did not come up", args)
+	return nil
+}
+
+// stop kills the server process
+func (s *server) stop() {
+	if s.cmd.ProcessState == nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+	}
+}
+
+func Test_Backends(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			s := startServer(t, b.setup(t)...)
+
+			voter := db.Voter{VoterId: 10, Name: "Jane Smith", Email: "jane@example.com"}
+			rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+			history := db.VoterHistory{VoteId: 1, VoteDate: time.Now()}
+			rsp, err = s.cli.R().SetBody(history).Post(s.base + "/voters/10/polls/1")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+			var got db.Voter
+			rsp, err = s.cli.R().SetResult(&got).Get(s.base + "/voters/10")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode())
+			assert.Equal(t, voter.Name, got.Name)
+			assert.Len(t, got.VoteHistory, 1)
+
+			//A voter sent without an id gets the one after the highest
+			rsp, err = s.cli.R().SetBody(db.Voter{Name: "John Doe"}).SetResult(&got).Post(s.base + "/voters")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+			assert.Equal(t, "/voters/11", rsp.Header().Get("Location"))
+			assert.Equal(t, 11, got.VoterId)
+
+			rsp, err = s.cli.R().Delete(s.base + "/voters/10")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+			rsp, err = s.cli.R().Get(s.base + "/voters/10")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+		})
+	}
+}
+
+func Test_PersistenceAcrossRestart(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			if !b.persistent {
+				t.Skip("backend does not persist voters")
+			}
+
+			args := b.setup(t)
+			s := startServer(t, args...)
+
+			voter := db.Voter{
+				VoterId:     20,
+				Name:        "John Doe",
+				VoteHistory: []db.VoterHistory{{PollId: 1, VoteId: 1, VoteDate: time.Date(2020, 11, 3, 0, 0, 0, 0, time.UTC)}},
+			}
+			rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+			if b.archived {
+				rsp, err = s.cli.R().SetBody(map[string]string{"Before": "2022-01-01"}).Post(s.base + "/admin/archive")
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, rsp.StatusCode())
+			}
+
+			s.stop()
+			s = startServer(t, args...)
+
+			var got db.Voter
+			rsp, err = s.cli.R().SetResult(&got).Get(s.base + "/voters/20")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rsp.StatusCode())
+			assert.Equal(t, voter.Name, got.Name)
+			assert.Len(t, got.VoteHistory, 1)
+			assert.Equal(t, b.archived, got.Archived)
+		})
+	}
+}
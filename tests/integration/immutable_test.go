@@ -0,0 +1,107 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_ImmutableHistory refuses every change to a recorded vote with
+// -immutable-history and fixes a mistake with corrections instead
+func Test_ImmutableHistory(t *testing.T) {
+	s := startServer(t, "-immutable-history")
+
+	for id := 1; id <= 2; id++ {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Jane Smith"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+	var vote db.VoterHistory
+	rsp, err := s.cli.R().SetResult(&vote).SetBody(db.VoterHistory{VoteDate: time.Now(), Channel: db.ChannelMail}).
+		Post(s.base + "/voters/1/polls/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	refused := []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodPost, "/voters/1/polls/3?override=true", db.VoterHistory{VoteDate: time.Now()}},
+		{http.MethodPut, "/voters/1/polls/3", db.VoterHistory{PollId: 3, VoteDate: time.Now()}},
+		{http.MethodDelete, "/voters/1/polls/3", nil},
+		{http.MethodPatch, "/voters/1/polls", []map[string]any{{"op": "remove", "path": "/0"}}},
+		{http.MethodPut, "/voters/1", db.Voter{Name: "Jane Smith", VoteHistory: []db.VoterHistory{}}},
+		{http.MethodPatch, "/voters/1", map[string]any{"VoteHistory": nil}},
+		{http.MethodDelete, "/voters/1", nil},
+	}
+	for _, req := range refused {
+		var apiErr client.Error
+		rsp, err := s.cli.R().SetError(&apiErr).SetBody(req.body).Execute(req.method, s.base+req.path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, rsp.StatusCode(), req.method+" "+req.path)
+		assert.Equal(t, client.CodeHistoryImmutable, apiErr.Code, req.method+" "+req.path)
+	}
+
+	//Changes that leave the recorded votes alone are fine
+	var voter db.Voter
+	rsp, err = s.cli.R().SetResult(&voter).SetBody(db.Voter{Name: "Jane Doe"}).Put(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, "Jane Doe", voter.Name)
+	require.Len(t, voter.VoteHistory, 1)
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/4")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().Delete(s.base + "/voters/2")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.VoteCorrection{Channel: db.ChannelOnline}).Post(s.base + "/voters/1/polls/3/corrections")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	var correction db.VoteCorrection
+	rsp, err = s.cli.R().SetResult(&correction).
+		SetBody(db.VoteCorrection{Reason: "keyed in as mail", Channel: db.ChannelOnline}).Post(s.base + "/voters/1/polls/3/corrections")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, 1, correction.CorrectionId)
+	assert.Equal(t, vote.VoteId, correction.VoteId)
+	rsp, err = s.cli.R().SetBody(db.VoteCorrection{Reason: "duplicate of a provisional ballot", Void: true}).
+		Post(s.base + "/voters/1/polls/3/corrections")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	var corrected struct {
+		Original    db.VoterHistory
+		Corrections []db.VoteCorrection
+		Effective   db.VoterHistory
+		Void        bool
+	}
+	rsp, err = s.cli.R().SetResult(&corrected).Get(s.base + "/voters/1/polls/3/corrections")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, db.ChannelMail, corrected.Original.Channel)
+	assert.Equal(t, db.ChannelOnline, corrected.Effective.Channel)
+	assert.True(t, corrected.Void)
+	require.Len(t, corrected.Corrections, 2)
+
+	rsp, err = s.cli.R().SetResult(&vote).Get(s.base + "/voters/1/polls/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, db.ChannelMail, vote.Channel)
+
+	var audit []db.AuditEntry
+	rsp, err = s.cli.R().SetResult(&audit).Get(s.base + "/admin/audit?voter=1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.Len(t, audit, 2)
+	assert.Equal(t, "vote.corrected", audit[0].Action)
+}
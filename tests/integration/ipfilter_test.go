@@ -0,0 +1,82 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_IPFilter checks that denylisted networks and networks outside a
+// route group's allowlist are refused before any credential is looked at,
+// while everyone else gets as far as authentication
+func Test_IPFilter(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "ip-rules.json")
+	require.NoError(t, os.WriteFile(rules, []byte(`{
+		"Allow": {"/admin/apikeys": ["10.0.0.0/8"], "/admin": ["127.0.0.1/32"]},
+		"Deny":  ["127.0.0.2/32"]
+	}`), 0o600))
+	s := startWithRootKey(t, "-ip-rules", rules)
+
+	//A second client that connects from 127.0.0.2, the denylisted address
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	denied := resty.New().SetTransport(&http.Transport{DialContext: dialer.DialContext})
+
+	blocked := func(rsp *resty.Response, err error, apiErr *client.Error) {
+		t.Helper()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode(), rsp.String())
+		assert.Equal(t, client.CodeNetworkBlocked, apiErr.Code)
+	}
+
+	//The denylisted network is refused with a wrong key and the right one
+	//alike, on every route
+	for _, key := range []string{"wrong-secret", "root-secret"} {
+		var apiErr client.Error
+		rsp, err := denied.R().SetHeader("X-API-Key", key).SetError(&apiErr).Get(s.base + "/voters")
+		blocked(rsp, err, &apiErr)
+	}
+
+	//The allowlist of the longest matching group wins, /admin/apikeys
+	//is only open to 10.0.0.0/8
+	var apiErr client.Error
+	rsp, err := s.cli.R().SetHeader("X-API-Key", "wrong-secret").SetError(&apiErr).Get(s.base + "/admin/apikeys")
+	blocked(rsp, err, &apiErr)
+
+	//Everyone else reaches authentication
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "wrong-secret").Get(s.base + "/admin/denylist")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	var entries []db.DenyEntry
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "root-secret").SetResult(&entries).Get(s.base + "/admin/denylist")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, entries, 1)
+	assert.Equal(t, "127.0.0.2/32", entries[0].CIDR)
+
+	//An admin can not denylist their own address
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "root-secret").
+		SetBody(map[string]any{"CIDR": "127.0.0.0/24", "Reason": "test"}).Post(s.base + "/admin/denylist")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	//Once the entry is deleted the address gets through again
+	rsp, err = s.cli.R().SetHeader("X-API-Key", "root-secret").
+		Delete(s.base + "/admin/denylist/" + fmt.Sprint(entries[0].Id))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = denied.R().SetHeader("X-API-Key", "root-secret").Get(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+}
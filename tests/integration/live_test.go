@@ -0,0 +1,128 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// watch opens /voters/ws with the given query and headers
+func watch(t *testing.T, s *server, query string, header http.Header) *websocket.Conn {
+	base := strings.Replace(s.base, "http://", "ws://", 1)
+	config, err := websocket.NewConfig(base+"/voters/ws"+query, s.base)
+	require.NoError(t, err)
+	for key, values := range header {
+		config.Header[key] = values
+	}
+	conn, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// nextEvent waits for the next live event
+func nextEvent(t *testing.T, conn *websocket.Conn) api.LiveEvent {
+	var event api.LiveEvent
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, websocket.JSON.Receive(conn, &event))
+	return event
+}
+
+// Test_LiveUpdates pushes every change to the roll to the clients of
+// /voters/ws, in order and narrowed to the events they asked for
+func Test_LiveUpdates(t *testing.T) {
+	s := startServer(t)
+	all := watch(t, s, "", nil)
+	votes := watch(t, s, "?events=vote.recorded,vote.deleted", nil)
+
+	voter := db.Voter{VoterId: 5, Name: "Jane Smith", Email: "jane@example.com"}
+	rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/5/polls/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	voter.Name = "Jane Doe"
+	rsp, err = s.cli.R().SetBody(voter).Put(s.base + "/voters/5")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().Delete(s.base + "/voters/5")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	event := nextEvent(t, all)
+	assert.Equal(t, int64(1), event.Seq)
+	assert.Equal(t, db.EventVoterCreated, event.Event)
+	assert.Equal(t, "jane@example.com", event.Voter["Email"])
+
+	event = nextEvent(t, all)
+	assert.Equal(t, db.EventVoteRecorded, event.Event)
+	assert.Equal(t, 3, event.PollId)
+	require.Len(t, event.Voter["VoteHistory"], 1)
+
+	//The PUT replaced the vote history too, so the vote is gone
+	event = nextEvent(t, all)
+	assert.Equal(t, db.EventVoterUpdated, event.Event)
+	assert.Equal(t, "Jane Doe", event.Voter["Name"])
+	event = nextEvent(t, all)
+	assert.Equal(t, db.EventVoteDeleted, event.Event)
+
+	event = nextEvent(t, all)
+	assert.Equal(t, int64(5), event.Seq)
+	assert.Equal(t, db.EventVoterDeleted, event.Event)
+	assert.Equal(t, map[string]any{"VoterId": float64(5)}, event.Voter)
+
+	for seq, name := range []string{db.EventVoteRecorded, db.EventVoteDeleted} {
+		event = nextEvent(t, votes)
+		assert.Equal(t, name, event.Event)
+		assert.Equal(t, int64(seq+1), event.Seq)
+	}
+
+	rsp, err = s.cli.R().Get(s.base + "/voters/ws")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUpgradeRequired, rsp.StatusCode())
+}
+
+// Test_LiveUpdatesAccessControl leaves out of live events the fields the
+// caller's role may not see
+func Test_LiveUpdatesAccessControl(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"Keys": [
+			{"Key": "root-secret", "Name": "root", "Role": "admin"},
+			{"Key": "clerk-secret", "Name": "clerk", "Role": "clerk", "Scopes": ["read"]}
+		],
+		"Roles": {"admin": ["*"], "clerk": ["VoterId", "Name"]}
+	}`), 0o600))
+	s := startServer(t, "-access", config)
+
+	anonymous := watch(t, s, "", nil)
+	clerk := watch(t, s, "", http.Header{"X-Api-Key": {"clerk-secret"}})
+	rsp, err := s.cli.R().SetHeader("X-API-Key", "root-secret").
+		SetBody(db.Voter{VoterId: 1, Name: "Jane Smith", Email: "jane@example.com"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	event := nextEvent(t, clerk)
+	assert.Equal(t, map[string]any{"VoterId": float64(1), "Name": "Jane Smith"}, event.Voter)
+	event = nextEvent(t, anonymous)
+	assert.Equal(t, map[string]any{"VoterId": float64(1)}, event.Voter)
+
+	//A key that is not valid is refused at the handshake
+	bad, err := websocket.NewConfig(strings.Replace(s.base, "http://", "ws://", 1)+"/voters/ws", s.base)
+	require.NoError(t, err)
+	bad.Header.Set("X-API-Key", "wrong")
+	_, err = websocket.DialConfig(bad)
+	assert.Error(t, err)
+}
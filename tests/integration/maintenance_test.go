@@ -0,0 +1,85 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_TransientFailures checks that read-only and maintenance mode, and
+// a cold store that can not be read, are answered with a 503 that carries
+// a Retry-After and the reason, and that the admin routes and the health
+// check stay up so the mode can be turned off again
+func Test_TransientFailures(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "archive")
+	s := startServer(t, "-archive", archive)
+
+	voter := db.Voter{VoterId: 1, Name: "Voter 1",
+		VoteHistory: []db.VoterHistory{{PollId: 1, VoteDate: time.Date(2020, 11, 3, 0, 0, 0, 0, time.UTC)}}}
+	rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	setMode := func(mode, reason string, retryAfter int) {
+		rsp, err := s.cli.R().SetBody(map[string]any{"Mode": mode, "Reason": reason, "RetryAfter": retryAfter}).
+			Put(s.base + "/admin/maintenance")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	unavailable := func(rsp *resty.Response, code, retryAfter string) *client.Error {
+		t.Helper()
+		assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode(), rsp.String())
+		assert.Equal(t, retryAfter, rsp.Header().Get("Retry-After"))
+		apiErr := rsp.Error().(*client.Error)
+		assert.Equal(t, code, apiErr.Code)
+		return apiErr
+	}
+	read := func(path string) *resty.Response {
+		rsp, err := s.cli.R().SetError(&client.Error{}).Get(s.base + path)
+		require.NoError(t, err)
+		return rsp
+	}
+	write := func() *resty.Response {
+		rsp, err := s.cli.R().SetError(&client.Error{}).SetBody(db.Voter{VoterId: 2, Name: "Voter 2"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		return rsp
+	}
+
+	setMode("read-only", "roll import", 300)
+	assert.Equal(t, http.StatusOK, read("/voters/1").StatusCode())
+	apiErr := unavailable(write(), client.CodeReadOnly, "300")
+	assert.Equal(t, "roll import", apiErr.Message)
+	assert.Equal(t, 300, apiErr.RetryAfter)
+
+	setMode("maintenance", "", 0)
+	apiErr = unavailable(read("/voters/1"), client.CodeMaintenance, "60")
+	assert.Contains(t, apiErr.Message, "maintenance")
+	unavailable(write(), client.CodeMaintenance, "60")
+	assert.Equal(t, http.StatusOK, read("/voters/health").StatusCode())
+	assert.Equal(t, http.StatusOK, read("/admin/maintenance").StatusCode())
+
+	setMode("off", "", 0)
+	assert.Equal(t, http.StatusOK, write().StatusCode())
+
+	//An archived voter whose record can not be read is an outage of the
+	//cold store, not a voter that does not exist
+	rsp, err = s.cli.R().SetBody(map[string]string{"Before": "2022-01-01"}).Post(s.base + "/admin/archive")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	record := filepath.Join(archive, "1.json")
+	require.FileExists(t, record)
+	require.NoError(t, os.Remove(record))
+	require.NoError(t, os.Mkdir(record, 0o755))
+	unavailable(read("/voters/1"), client.CodeStorageUnavailable, "30")
+	assert.Equal(t, http.StatusNotFound, read("/voters/999").StatusCode())
+}
@@ -0,0 +1,84 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_PriorityShedding saturates a server of small capacity with exports
+// and checks that the exports are shed with 503 OVERLOADED while health
+// checks, which are high priority, are all served
+func Test_PriorityShedding(t *testing.T) {
+	const voters, clients, rounds = 2000, 32, 10
+	s := startServer(t, "-capacity", "4")
+
+	for id := 1; id <= voters; id++ {
+		voter := db.Voter{VoterId: id, Name: fmt.Sprint("Voter ", id), Email: fmt.Sprintf("voter%d@example.com", id)}
+		rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	//With spare capacity low priority work is admitted like any other
+	rsp, err := s.cli.R().Get(s.base + "/voters/export")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var shed, healthFailed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				rsp, err := s.cli.R().SetError(&client.Error{}).Get(s.base + "/voters/export")
+				if !assert.NoError(t, err) {
+					return
+				}
+				if rsp.StatusCode() == http.StatusServiceUnavailable {
+					assert.Equal(t, client.CodeOverloaded, rsp.Error().(*client.Error).Code)
+					assert.Equal(t, "1", rsp.Header().Get("Retry-After"))
+					shed.Add(1)
+					continue
+				}
+				assert.Equal(t, http.StatusOK, rsp.StatusCode())
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < clients*rounds/4; round++ {
+			rsp, err := s.cli.R().Get(s.base + "/voters/health")
+			if err != nil || rsp.StatusCode() != http.StatusOK {
+				healthFailed.Add(1)
+			}
+		}
+	}()
+	wg.Wait()
+
+	assert.Positive(t, shed.Load(), "no export was shed")
+	assert.Zero(t, healthFailed.Load(), "health checks were shed")
+
+	var load struct{ Classes []api.PriorityStats }
+	rsp, err = s.cli.R().SetResult(&load).Get(s.base + "/admin/load")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	stats := make(map[string]api.PriorityStats)
+	for _, class := range load.Classes {
+		stats[class.Class] = class
+	}
+	assert.Equal(t, shed.Load(), stats[api.PriorityLow].Shed)
+	assert.Zero(t, stats[api.PriorityHigh].Shed)
+}
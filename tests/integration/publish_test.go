@@ -0,0 +1,262 @@
+//go:build integration
+
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// published is a message a fake broker got
+type published struct {
+	target string //NATS subject, Kafka topic
+	key    string
+	event  string //Kafka event header
+	body   []byte
+}
+
+// fakeNATS accepts NATS clients and hands the messages they publish to the
+// returned channel
+func fakeNATS(t *testing.T) (string, <-chan published) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan published, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch {
+					case len(fields) == 0:
+					case fields[0] == "PING":
+						fmt.Fprintf(conn, "PONG\r\n")
+					case fields[0] == "PUB" && len(fields) == 3:
+						n, _ := strconv.Atoi(fields[2])
+						body := make([]byte, n+2)
+						if _, err := io.ReadFull(r, body); err != nil {
+							return
+						}
+						messages <- published{target: fields[1], body: body[:n]}
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+// kafkaFrame reads the big endian types of a Kafka frame
+type kafkaFrame struct {
+	data []byte
+}
+
+func (f *kafkaFrame) next(n int) []byte {
+	b := f.data[:n]
+	f.data = f.data[n:]
+	return b
+}
+func (f *kafkaFrame) int16() int16 { return int16(binary.BigEndian.Uint16(f.next(2))) }
+func (f *kafkaFrame) int32() int32 { return int32(binary.BigEndian.Uint32(f.next(4))) }
+func (f *kafkaFrame) string() string {
+	return string(f.next(int(f.int16())))
+}
+func (f *kafkaFrame) varint() int {
+	v, n := binary.Varint(f.data)
+	f.next(n)
+	return int(v)
+}
+
+// fakeKafka is a single Kafka broker that leads partition 0 of every
+// topic, it checks the CRC of every record batch and hands the records to
+// the returned channel
+func fakeKafka(t *testing.T) (string, <-chan published) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	messages := make(chan published, 16)
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			var size int32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				return
+			}
+			req := &kafkaFrame{data: make([]byte, size)}
+			if _, err := io.ReadFull(conn, req.data); err != nil {
+				return
+			}
+			apiKey, _, correlation := req.int16(), req.int16(), req.int32()
+			req.string() //client id
+
+			var rsp bytes.Buffer
+			w := func(v any) { binary.Write(&rsp, binary.BigEndian, v) }
+			str := func(s string) { w(int16(len(s))); rsp.WriteString(s) }
+			w(correlation)
+			switch apiKey {
+			case 3: //Metadata
+				req.int32()
+				topic := req.string()
+				w(int32(1))
+				w(int32(0))
+				str("127.0.0.1")
+				w(int32(port))
+				w(int16(-1))
+				w(int32(0))
+				w(int32(1))
+				w(int16(0))
+				str(topic)
+				w(int8(0))
+				w(int32(1))
+				w(int16(0))
+				w(int32(0))
+				w(int32(0))
+				w(int32(1))
+				w(int32(0))
+				w(int32(1))
+				w(int32(0))
+			case 0: //Produce
+				req.int16() //transactional id
+				req.int16() //acks
+				req.int32() //timeout
+				req.int32()
+				topic := req.string()
+				req.int32()
+				req.int32() //partition
+				batch := req.next(int(req.int32()))
+				crc := binary.BigEndian.Uint32(batch[17:21])
+				if crc != crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) {
+					return
+				}
+				record := &kafkaFrame{data: batch[61:]}
+				record.varint() //length
+				record.next(1)  //attributes
+				record.varint() //timestamp delta
+				record.varint() //offset delta
+				msg := published{target: topic}
+				msg.key = string(record.next(record.varint()))
+				msg.body = record.next(record.varint())
+				record.varint() //headers
+				record.next(record.varint())
+				msg.event = string(record.next(record.varint()))
+				messages <- msg
+
+				w(int32(1))
+				str(topic)
+				w(int32(1))
+				w(int32(0))
+				w(int16(0))
+				w(int64(0))
+				w(int64(-1))
+				w(int32(0))
+			default:
+				return
+			}
+			binary.Write(conn, binary.BigEndian, int32(rsp.Len()))
+			conn.Write(rsp.Bytes())
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+// nextPublished waits for the next message a fake broker got
+func nextPublished(t *testing.T, messages <-chan published) published {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "nothing published within 10s")
+		return published{}
+	}
+}
+
+// Test_PublishChanges publishes the changes of the roll to a NATS subject
+// and to a Kafka topic
+func Test_PublishChanges(t *testing.T) {
+	natsAddr, natsMessages := fakeNATS(t)
+	kafkaAddr, kafkaMessages := fakeKafka(t)
+
+	for _, broker := range []struct {
+		dest     string
+		messages <-chan published
+		target   func(event string) string
+	}{
+		{"nats://" + natsAddr + "/voters", natsMessages, func(event string) string { return "voters." + event }},
+		{"kafka://" + kafkaAddr + "/voters", kafkaMessages, func(string) string { return "voters" }},
+	} {
+		s := startServer(t, "-publish", broker.dest)
+
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+		rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/3")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+		rsp, err = s.cli.R().Delete(s.base + "/voters/1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+		for i, event := range []string{db.EventVoterCreated, db.EventVoteRecorded, db.EventVoterDeleted} {
+			msg := nextPublished(t, broker.messages)
+			assert.Equal(t, broker.target(event), msg.target, broker.dest)
+			var live api.LiveEvent
+			require.NoError(t, json.Unmarshal(msg.body, &live))
+			assert.Equal(t, event, live.Event, broker.dest)
+			assert.Equal(t, int64(i+1), live.Seq, broker.dest)
+			assert.Equal(t, 1, live.VoterId, broker.dest)
+			if strings.HasPrefix(broker.dest, "kafka") {
+				assert.Equal(t, "1", msg.key)
+				assert.Equal(t, event, msg.event)
+			}
+		}
+
+		var stats struct {
+			Enabled bool
+			Broker  string
+		}
+		rsp, err = s.cli.R().SetResult(&stats).Get(s.base + "/admin/publisher")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+		assert.True(t, stats.Enabled)
+		assert.True(t, strings.HasPrefix(broker.dest, stats.Broker))
+	}
+}
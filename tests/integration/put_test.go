@@ -0,0 +1,67 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_PutCreatesAnswersStored checks that a PUT which creates a voter
+// answers with the voter as stored, with its VoteIds and Registered, not
+// with the body it was sent
+func Test_PutCreatesAnswersStored(t *testing.T) {
+	s := startServer(t, "-put-creates")
+
+	var created db.Voter
+	voter := db.Voter{Name: "New Voter", VoteHistory: []db.VoterHistory{{PollId: 1, VoteId: 99, VoteDate: time.Now().Add(-time.Hour)}}}
+	rsp, err := s.cli.R().SetBody(voter).SetResult(&created).Put(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, "/voters/7", rsp.Header().Get("Location"))
+
+	var stored db.Voter
+	rsp, err = s.cli.R().SetResult(&stored).Get(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	assert.Equal(t, 7, created.VoterId)
+	assert.False(t, created.Registered.IsZero())
+	assert.True(t, stored.Registered.Equal(created.Registered))
+	require.Len(t, created.VoteHistory, 1)
+	assert.Equal(t, 1, created.VoteHistory[0].VoteId)
+	assert.Equal(t, stored.VoteHistory[0].VoteId, created.VoteHistory[0].VoteId)
+}
+
+// Test_PutAnswersStored checks that a PUT which replaces a voter answers
+// with the voter as stored, Registered included, and with the ETag of it
+func Test_PutAnswersStored(t *testing.T) {
+	s := startServer(t)
+
+	var created db.Voter
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 7, Name: "Jane Smith"}).SetResult(&created).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.False(t, created.Registered.IsZero())
+
+	var updated db.Voter
+	rsp, err = s.cli.R().SetBody(db.Voter{Name: "Jane Doe"}).SetResult(&updated).Put(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	etag := rsp.Header().Get("ETag")
+
+	var stored db.Voter
+	rsp, err = s.cli.R().SetResult(&stored).Get(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	assert.Equal(t, "Jane Doe", updated.Name)
+	assert.False(t, updated.Registered.IsZero())
+	assert.True(t, stored.Registered.Equal(updated.Registered))
+	assert.Equal(t, rsp.Header().Get("ETag"), etag)
+}
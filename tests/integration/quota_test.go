@@ -0,0 +1,81 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_HistoryQuota changes one vote history until the quota refuses it
+// and checks that the breach is alerted once and other voters carry on
+func Test_HistoryQuota(t *testing.T) {
+	s := startServer(t, "-history-quota", "3")
+
+	for id := 1; id <= 2; id++ {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Quota Voter"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+
+	rsp, err := s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	for i := 0; i < 2; i++ {
+		rsp, err = s.cli.R().SetBody(db.VoterHistory{PollId: 1, Choice: "yes", VoteDate: time.Now()}).Put(s.base + "/voters/1/polls/1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+
+	for i := 0; i < 2; i++ {
+		var apiErr client.Error
+		rsp, err = s.cli.R().SetError(&apiErr).Delete(s.base + "/voters/1/polls/1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTooManyRequests, rsp.StatusCode())
+		assert.Equal(t, client.CodeHistoryQuota, apiErr.Code)
+		assert.NotEmpty(t, rsp.Header().Get("Retry-After"))
+	}
+
+	//Rewriting the history through the voter routes counts as well, other
+	//changes to the voter do not
+	var apiErr client.Error
+	history := []db.VoterHistory{{PollId: 2, VoteDate: time.Now()}}
+	rsp, err = s.cli.R().SetBody(db.Voter{Name: "Quota Voter", VoteHistory: history}).SetError(&apiErr).Put(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode())
+	assert.Equal(t, client.CodeHistoryQuota, apiErr.Code)
+	rsp, err = s.cli.R().SetBody(map[string]any{"VoteHistory": []any{}}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(map[string]any{"Name": "Quota Voter One"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/2/polls/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	var quota struct {
+		Limit    int
+		Breaches []db.QuotaBreach
+	}
+	rsp, err = s.cli.R().SetResult(&quota).Get(s.base + "/admin/history-quota")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, 3, quota.Limit)
+	require.Len(t, quota.Breaches, 1)
+	assert.Equal(t, 1, quota.Breaches[0].VoterId)
+	assert.Equal(t, 4, quota.Breaches[0].Rejected)
+
+	var alerts []db.ReviewItem
+	rsp, err = s.cli.R().SetResult(&alerts).Get(s.base + "/admin/reviews?kind=anomaly")
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 1, alerts[0].VoterId)
+}
@@ -0,0 +1,99 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rehearsalDashboard is the response of GET /admin/rehearsal
+type rehearsalDashboard struct {
+	Enabled  bool
+	Voters   int
+	Seeded   int
+	Votes    int
+	Turnout  map[int]int
+	Channels map[string]int
+	Traffic  *struct {
+		Running  bool
+		Sent     int64
+		Recorded int64
+	}
+}
+
+// Test_Rehearsal seeds a rehearsal, runs simulated votes against it and
+// wipes it
+func Test_Rehearsal(t *testing.T) {
+	s := startServer(t, "-rehearsal")
+
+	var seeded struct {
+		Seeded, First, Last int
+	}
+	rsp, err := s.cli.R().SetResult(&seeded).SetBody(map[string]int{"Voters": 20, "Precincts": 2}).
+		Post(s.base + "/admin/rehearsal/seed")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, "true", rsp.Header().Get("X-Rehearsal"))
+	assert.Equal(t, 1, seeded.First)
+	assert.Equal(t, 20, seeded.Last)
+
+	var voter db.Voter
+	rsp, err = s.cli.R().SetResult(&voter).Get(s.base + "/voters/2")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.NotEmpty(t, voter.Name)
+	assert.Equal(t, 2, voter.PrecinctId)
+
+	traffic := map[string]any{"Rate": 200, "Polls": []int{1, 2}}
+	rsp, err = s.cli.R().SetBody(traffic).Post(s.base + "/admin/rehearsal/traffic")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(traffic).Post(s.base + "/admin/rehearsal/traffic")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	//Every voter votes once in each poll, then the traffic ends
+	var dashboard rehearsalDashboard
+	require.Eventually(t, func() bool {
+		rsp, err = s.cli.R().SetResult(&dashboard).Get(s.base + "/admin/rehearsal")
+		return err == nil && rsp.StatusCode() == http.StatusOK && dashboard.Traffic != nil && !dashboard.Traffic.Running
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.True(t, dashboard.Enabled)
+	assert.Equal(t, 20, dashboard.Seeded)
+	assert.Equal(t, int64(40), dashboard.Traffic.Recorded)
+	assert.Equal(t, 40, dashboard.Votes)
+	assert.Equal(t, map[int]int{1: 20, 2: 20}, dashboard.Turnout)
+
+	var wiped struct {
+		Wiped int
+	}
+	rsp, err = s.cli.R().SetResult(&wiped).Post(s.base + "/admin/rehearsal/wipe")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, 20, wiped.Wiped)
+	rsp, err = s.cli.R().SetResult(&dashboard).Get(s.base + "/admin/rehearsal")
+	require.NoError(t, err)
+	assert.Equal(t, 0, dashboard.Voters)
+	assert.Equal(t, 0, dashboard.Votes)
+	assert.Nil(t, dashboard.Traffic)
+
+	//A server that is not rehearsing refuses to wipe
+	live := startServer(t)
+	rsp, err = live.cli.R().Post(live.base + "/admin/rehearsal/wipe")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+	assert.Empty(t, rsp.Header().Get("X-Rehearsal"))
+
+	//A rehearsal never keeps its voters anywhere but in memory
+	out, err := exec.Command(serverBinary, "-rehearsal", "-bolt", filepath.Join(t.TempDir(), "voters.db")).CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(out), "rehearsal mode")
+}
@@ -0,0 +1,85 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/replay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_TrafficReplay records traffic without voter data in it and replays
+// it against a fresh server, where the pseudonymized ids still line up
+func Test_TrafficReplay(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "traffic.jsonl")
+	s := startServer(t, "-record", recording)
+
+	voter := db.Voter{VoterId: 42, Name: "Jane Smith", Email: "jane@example.org", Status: "active"}
+	rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	history := db.VoterHistory{VoteId: 1, VoteDate: time.Now(), Choice: "yes"}
+	rsp, err = s.cli.R().SetBody(history).Post(s.base + "/voters/42/polls/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	for _, path := range []string{"/voters/42", "/voters/42/polls/7", "/voters/by-email/jane@example.org", "/voters/99"} {
+		_, err = s.cli.R().Get(s.base + path)
+		require.NoError(t, err)
+	}
+	_, err = s.cli.R().SetQueryParams(map[string]string{"name": "Jane", "limit": "5"}).Get(s.base + "/voters/search")
+	require.NoError(t, err)
+	_, err = s.cli.R().Get(s.base + "/admin/slow-requests")
+	require.NoError(t, err)
+
+	//The recorder writes in the background, the first request is the
+	//health check of startServer
+	var stats struct {
+		Enabled bool
+		Stats   replay.Stats
+	}
+	require.Eventually(t, func() bool {
+		_, err := s.cli.R().SetResult(&stats).Get(s.base + "/admin/recording")
+		return err == nil && stats.Stats.Recorded == 8
+	}, 5*time.Second, 50*time.Millisecond)
+
+	data, err := os.ReadFile(recording)
+	require.NoError(t, err)
+	for _, secret := range []string{"Jane", "Smith", "jane@example.org", "yes", "/admin"} {
+		assert.NotContains(t, string(data), secret)
+	}
+
+	_, requests, err := replay.ReadFile(recording)
+	require.NoError(t, err)
+	require.Len(t, requests, 8)
+	created, vote, get := requests[1], requests[2], requests[3]
+	assert.Equal(t, "/voters/:id<int>", get.Route)
+	assert.NotEqual(t, "42", get.Params["id"])
+	var body db.Voter
+	require.NoError(t, json.Unmarshal(created.Body, &body))
+	assert.Equal(t, get.Params["id"], strconv.Itoa(body.VoterId))
+	assert.Equal(t, "active", body.Status)
+	assert.Equal(t, "5", requests[7].Query["limit"])
+
+	target := startServer(t)
+	report, err := replay.Run(context.Background(), requests, replay.Options{Target: target.base, Speed: 0, Concurrency: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Requests)
+	assert.Zero(t, report.Failed)
+	assert.Zero(t, report.Mismatch, "%+v", report.Routes)
+
+	rsp, err = target.cli.R().Get(target.base + "/voters/" + get.Params["id"] + "/polls/" + vote.Params["pollid"])
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+}
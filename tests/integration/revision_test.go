@@ -0,0 +1,75 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_AsOfAfterRestart checks that ?as_of= still finds voters registered
+// before the server started, whose history begins with the load of the
+// store, and before the oldest revision kept under the limit
+func Test_AsOfAfterRestart(t *testing.T) {
+	args := []string{"-bolt", filepath.Join(t.TempDir(), "voters.db"), "-revision-limit", "8"}
+	s := startServer(t, args...)
+
+	registered := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	for id := 1; id <= 2; id++ {
+		voter := db.Voter{VoterId: id, Name: fmt.Sprint("Voter ", id), Registered: registered}
+		rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	s.stop()
+	s = startServer(t, args...)
+
+	asOf := func(id int, at string) (int, db.Voter) {
+		var voter db.Voter
+		rsp, err := s.cli.R().SetResult(&voter).Get(fmt.Sprintf("%s/voters/%d?as_of=%s", s.base, id, at))
+		require.NoError(t, err)
+		return rsp.StatusCode(), voter
+	}
+	count := func(at string) int {
+		var got struct{ Count int }
+		rsp, err := s.cli.R().SetResult(&got).Get(s.base + "/voters/count?as_of=" + at)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return got.Count
+	}
+
+	status, voter := asOf(1, "2021-01-01")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Voter 1", voter.Name)
+	status, _ = asOf(1, "2019-12-31")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, 2, count("2021-01-01"))
+	assert.Equal(t, 0, count("2019-12-31"))
+
+	//Enough changes to push the load out of the kept history, the voter
+	//is then taken as it was first recorded among what is left
+	for i := 0; i < 20; i++ {
+		rsp, err := s.cli.R().SetBody(map[string]any{"Name": fmt.Sprint("Voter 1 rev ", i)}).Patch(s.base + "/voters/1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	status, voter = asOf(1, "2021-01-01")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, voter.Name, "Voter 1")
+	status, voter = asOf(2, "2021-01-01")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Voter 2", voter.Name)
+	assert.Equal(t, 2, count("2021-01-01"))
+
+	status, voter = asOf(1, time.Now().UTC().Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Voter 1 rev 19", voter.Name)
+}
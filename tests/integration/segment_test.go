@@ -0,0 +1,85 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_Segments saves a segment and checks its precomputed count and
+// voters, that they are reported stale once the roll changes, and that
+// ?fresh=true recomputes them
+func Test_Segments(t *testing.T) {
+	s := startServer(t, "-segment-refresh", "1h")
+
+	for _, voter := range []db.Voter{
+		{VoterId: 1, Name: "Jane Smith", PrecinctId: 7},
+		{VoterId: 2, Name: "John Doe", PrecinctId: 7},
+		{VoterId: 3, Name: "Alice Walker", PrecinctId: 8},
+	} {
+		rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	rsp, err := s.cli.R().SetBody(db.Segment{Name: "precinct-7", Filter: db.VoterFilter{PrecinctId: 7}}).Post(s.base + "/segments")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var count struct {
+		Count int  `json:"count"`
+		Stale bool `json:"stale"`
+	}
+	rsp, err = s.cli.R().SetResult(&count).Get(s.base + "/segments/precinct-7/count")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, 2, count.Count)
+	assert.False(t, count.Stale)
+	assert.Equal(t, "false", rsp.Header().Get("X-Segment-Stale"))
+
+	var voters []db.Voter
+	rsp, err = s.cli.R().SetResult(&voters).Get(s.base + "/segments/precinct-7/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	var ids []int
+	for _, voter := range voters {
+		ids = append(ids, voter.VoterId)
+	}
+	assert.ElementsMatch(t, []int{1, 2}, ids)
+
+	//A new voter is not in the view until it is refreshed
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 4, Name: "Bob Jones", PrecinctId: 7}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	rsp, err = s.cli.R().SetResult(&count).Get(s.base + "/segments/precinct-7/count")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, 2, count.Count)
+	assert.True(t, count.Stale)
+
+	rsp, err = s.cli.R().SetResult(&count).Get(s.base + "/segments/precinct-7/count?fresh=true")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, 3, count.Count)
+	assert.False(t, count.Stale)
+
+	var counts []db.SegmentCount
+	rsp, err = s.cli.R().SetResult(&counts).Get(s.base + "/segments/precinct-7/counts")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, counts, 1)
+	assert.Equal(t, 3, counts[0].Count)
+
+	rsp, err = s.cli.R().Delete(s.base + "/segments/precinct-7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().Get(s.base + "/segments/precinct-7/count")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
@@ -0,0 +1,66 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_SelfTest runs the self test at startup and on demand and checks
+// every configured component passes, the rest are skipped, and that the
+// reserved voter it writes leaves no trace on the roll or in the change feed
+func Test_SelfTest(t *testing.T) {
+	s := startServer(t, "-selftest", "-archive", t.TempDir())
+
+	var report struct {
+		Passed  bool                 `json:"passed"`
+		Results []api.SelfTestResult `json:"results"`
+	}
+	for i := 0; i < 2; i++ {
+		rsp, err := s.cli.R().SetResult(&report).Post(s.base + "/admin/selftest")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		assert.True(t, report.Passed)
+		require.Len(t, report.Results, 3)
+		for _, result := range report.Results {
+			assert.True(t, result.Passed, result.Component)
+			assert.False(t, result.Skipped, result.Component)
+			assert.Empty(t, result.Error, result.Component)
+		}
+	}
+
+	var count struct{ Count int }
+	rsp, err := s.cli.R().SetResult(&count).Get(s.base + "/voters/count")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Zero(t, count.Count)
+
+	//The first change on the feed is the first real voter
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	event := nextSSE(t, stream(t, s, "", "0"))
+	assert.Equal(t, "1", event.Id)
+	assert.Equal(t, db.EventVoterCreated, event.Event)
+	var live api.LiveEvent
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &live))
+	assert.Equal(t, 1, live.VoterId)
+
+	//Without a cold store that part of the self test is skipped
+	s = startServer(t)
+	rsp, err = s.cli.R().SetResult(&report).Post(s.base + "/admin/selftest")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	skipped := make(map[string]bool)
+	for _, result := range report.Results {
+		skipped[result.Component] = result.Skipped
+	}
+	assert.Equal(t, map[string]bool{"store": false, "coldstore": true, "jobs": false}, skipped)
+}
@@ -0,0 +1,92 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_ShadowMode checks that in shadow mode writes reach the shadow
+// store, that reads are compared against it and divergences reported at
+// /admin/shadow, and that a diverging shadow never changes a response
+func Test_ShadowMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shadow")
+	s := startServer(t, "-shadow", dir)
+	record := filepath.Join(dir, "1.json")
+
+	stats := func() db.ShadowStats {
+		t.Helper()
+		var stats db.ShadowStats
+		rsp, err := s.cli.R().SetResult(&stats).Get(s.base + "/admin/shadow")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return stats
+	}
+	read := func() db.Voter {
+		t.Helper()
+		var voter db.Voter
+		rsp, err := s.cli.R().SetResult(&voter).Get(s.base + "/voters/1")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return voter
+	}
+	shadowed := func() db.Voter {
+		t.Helper()
+		data, err := os.ReadFile(record)
+		require.NoError(t, err)
+		var voter db.Voter
+		require.NoError(t, json.Unmarshal(data, &voter))
+		return voter
+	}
+
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Voter 1"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().SetBody(map[string]any{"Name": "Voter One"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	assert.Equal(t, "Voter One", shadowed().Name)
+	assert.Equal(t, "Voter One", read().Name)
+	got := stats()
+	assert.True(t, got.Enabled)
+	assert.GreaterOrEqual(t, got.Writes, int64(2))
+	assert.Positive(t, got.Reads)
+	assert.Zero(t, got.Mismatches)
+
+	//A shadow that disagrees is reported, the response stays the primary's
+	voter := shadowed()
+	voter.Name = "Somebody Else"
+	data, err := json.Marshal(voter)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(record, data, 0o644))
+	assert.Equal(t, "Voter One", read().Name)
+	got = stats()
+	assert.Equal(t, int64(1), got.Mismatches)
+	require.Len(t, got.Recent, 1)
+	assert.Equal(t, 1, got.Recent[0].VoterId)
+	assert.Equal(t, "records differ", got.Recent[0].Reason)
+
+	require.NoError(t, os.Remove(record))
+	assert.Equal(t, "Voter One", read().Name)
+	got = stats()
+	require.Len(t, got.Recent, 2)
+	assert.Equal(t, "missing from shadow", got.Recent[1].Reason)
+
+	rsp, err = s.cli.R().SetBody(map[string]any{"Name": "Voter Uno"}).Patch(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, "Voter Uno", shadowed().Name)
+	rsp, err = s.cli.R().Delete(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.NoFileExists(t, record)
+}
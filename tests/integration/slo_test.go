@@ -0,0 +1,72 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_SLOs counts requests against their route's SLOs and fires the burn
+// rate alerts of an SLO no request can meet
+func Test_SLOs(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "slo.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"SLOs": [
+			{"Name": "reads-fast", "Method": "GET", "Route": "/voters/:id", "Objective": 99, "Latency": "1ns"},
+			{"Name": "reads-up", "Method": "GET", "Route": "/voters/:id<int>", "Objective": 99.9},
+			{"Name": "writes", "Method": "POST", "Route": "/voters", "Objective": 99.5, "Window": "2h"}
+		]
+	}`), 0o600))
+	s := startServer(t, "-slo", config)
+
+	for _, path := range []string{"/voters/1", "/voters/2", "/voters/3", "/voters"} {
+		_, err := s.cli.R().Get(s.base + path)
+		require.NoError(t, err)
+	}
+
+	var dashboard struct {
+		Enabled bool
+		SLOs    []db.SLOStatus
+	}
+	rsp, err := s.cli.R().SetResult(&dashboard).Get(s.base + "/admin/slo")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.True(t, dashboard.Enabled)
+	require.Len(t, dashboard.SLOs, 3)
+
+	fast, up, writes := dashboard.SLOs[0], dashboard.SLOs[1], dashboard.SLOs[2]
+	assert.Equal(t, int64(3), fast.Requests)
+	assert.Equal(t, int64(3), fast.Bad)
+	assert.Equal(t, float64(0), fast.Compliance)
+	assert.InDelta(t, 100, fast.BurnRates["1h0m0s"], 0.001)
+	assert.Less(t, fast.BudgetRemaining, float64(0))
+	severities := []string{}
+	for _, alert := range fast.Alerts {
+		severities = append(severities, alert.Severity)
+	}
+	assert.ElementsMatch(t, []string{db.SeverityPage, db.SeverityPage, db.SeverityTicket}, severities)
+
+	//Not found answers are good requests
+	assert.Equal(t, int64(3), up.Requests)
+	assert.Equal(t, float64(100), up.Compliance)
+	assert.Empty(t, up.Alerts)
+
+	//Only POSTs count, and the three day burn rate is longer than the window
+	assert.Equal(t, int64(0), writes.Requests)
+	assert.Equal(t, float64(1), writes.BudgetRemaining)
+	assert.NotContains(t, writes.BurnRates, "72h0m0s")
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	require.NoError(t, os.WriteFile(bad, []byte(`{"SLOs": [{"Name": "x", "Route": "/voters", "Objective": 100}]}`), 0o600))
+	out, err := exec.Command(serverBinary, "-slo", bad).CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(out), "objective must be between 0 and 100")
+}
@@ -0,0 +1,125 @@
+//go:build integration
+
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is an event read from an SSE stream
+type sseEvent struct {
+	Id    string
+	Event string
+	Data  string
+}
+
+// stream opens /voters/events with the given query and Last-Event-ID and
+// hands its events to the returned channel
+func stream(t *testing.T, s *server, query, lastID string) <-chan sseEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+"/voters/events"+query, nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer rsp.Body.Close()
+		var event sseEvent
+		scanner := bufio.NewScanner(rsp.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.Id = value
+			case "event":
+				event.Event = value
+			case "data":
+				event.Data = value
+			case "":
+				if event.Data != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// nextSSE waits for the next event of a stream
+func nextSSE(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event within 5s")
+		return sseEvent{}
+	}
+}
+
+// Test_EventStream streams changes as Server-Sent Events and resumes a
+// stream from its Last-Event-ID
+func Test_EventStream(t *testing.T) {
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	//A new stream starts from now, the voter created before it is not sent
+	events := stream(t, s, "", "")
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	event := nextSSE(t, events)
+	assert.Equal(t, "2", event.Id)
+	assert.Equal(t, db.EventVoteRecorded, event.Event)
+	var live api.LiveEvent
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &live))
+	assert.Equal(t, int64(2), live.Seq)
+	assert.Equal(t, 3, live.PollId)
+
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 2, Name: "John Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	rsp, err = s.cli.R().Delete(s.base + "/voters/2")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	//Resuming sends what was missed, in order
+	resumed := stream(t, s, "", "2")
+	for _, want := range []sseEvent{{Id: "3", Event: db.EventVoterCreated}, {Id: "4", Event: db.EventVoterDeleted}} {
+		event = nextSSE(t, resumed)
+		assert.Equal(t, want.Id, event.Id)
+		assert.Equal(t, want.Event, event.Event)
+	}
+	deletes := stream(t, s, "?events=voter.deleted", "2")
+	event = nextSSE(t, deletes)
+	assert.Equal(t, "4", event.Id)
+
+	//An id the server never handed out can not be resumed from
+	event = nextSSE(t, stream(t, s, "", "999"))
+	assert.Equal(t, "reset", event.Event)
+
+	rsp, err = s.cli.R().SetHeader("Last-Event-ID", "soon").Get(s.base + "/voters/events")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+}
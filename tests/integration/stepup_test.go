@@ -0,0 +1,244 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/client"
+	"github.com/adllev/voter-api/db"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rootTOTPSecret is the TOTP secret of the root key of startWithRootKey
+const rootTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// totp returns the current TOTP code of a base32 secret
+func totp(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// stepUp exchanges a TOTP code of the root key for a step-up token
+func stepUp(t *testing.T, s *server) string {
+	var grant struct{ Token string }
+	rsp, err := s.cli.R().SetHeader("X-API-Key", "root-secret").
+		SetBody(map[string]string{"Code": totp(t, rootTOTPSecret)}).SetResult(&grant).
+		Post(s.base + "/auth/step-up")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	return grant.Token
+}
+
+// softKey is a security key in software, it answers WebAuthn ceremonies
+// for the relying party localhost
+type softKey struct {
+	id      []byte
+	key     *ecdsa.PrivateKey
+	counter uint32
+}
+
+func newSoftKey(t *testing.T) *softKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softKey{id: id, key: key}
+}
+
+var b64 = base64.RawURLEncoding
+
+// challenge returns the challenge of the options of a ceremony
+func challenge(t *testing.T, options []byte) string {
+	var opts struct {
+		PublicKey struct{ Challenge string } `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(options, &opts))
+	return opts.PublicKey.Challenge
+}
+
+// clientData is the client data the browser would sign
+func clientData(kind, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": kind, "challenge": challenge, "origin": "https://localhost"})
+	return data
+}
+
+// authData is the authenticator data, user present and verified, with the
+// attested credential when registering
+func (k *softKey) authData(attested []byte) []byte {
+	rpID := sha256.Sum256([]byte("localhost"))
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+	k.counter++
+	data := append(rpID[:], flags)
+	data = binary.BigEndian.AppendUint32(data, k.counter)
+	return append(data, attested...)
+}
+
+// create answers the options of POST .../credentials/begin
+func (k *softKey) create(t *testing.T, options []byte) []byte {
+	coseKey, err := cbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1,
+		-2: k.key.PublicKey.X.FillBytes(make([]byte, 32)), -3: k.key.PublicKey.Y.FillBytes(make([]byte, 32))})
+	require.NoError(t, err)
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(k.id)))
+	attested = append(append(attested, k.id...), coseKey...)
+
+	object, err := cbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": k.authData(attested)})
+	require.NoError(t, err)
+	body, _ := json.Marshal(map[string]any{
+		"id": b64.EncodeToString(k.id), "rawId": b64.EncodeToString(k.id), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData("webauthn.create", challenge(t, options))),
+			"attestationObject": b64.EncodeToString(object),
+		},
+	})
+	return body
+}
+
+// get answers the options of a login or step-up begin
+func (k *softKey) get(t *testing.T, options []byte) []byte {
+	data := clientData("webauthn.get", challenge(t, options))
+	auth := k.authData(nil)
+	dataHash := sha256.Sum256(data)
+	signed := sha256.Sum256(append(append([]byte(nil), auth...), dataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, k.key, signed[:])
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(map[string]any{
+		"id": b64.EncodeToString(k.id), "rawId": b64.EncodeToString(k.id), "type": "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(data),
+			"authenticatorData": b64.EncodeToString(auth),
+			"signature":         b64.EncodeToString(sig),
+		},
+	})
+	return body
+}
+
+// Test_StepUpRoutes checks that rotating keys and webhook secrets,
+// importing voters and certifying an election need a step-up token, and
+// that a security key gets one as well as a TOTP code
+func Test_StepUpRoutes(t *testing.T) {
+	s := startWithRootKey(t, "-webauthn-rpid", "localhost")
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	rsp, err := root().SetBody(db.APIKey{Name: "field-app", Role: "admin"}).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode(), rsp.String())
+	webhook := db.Webhook{Name: "crm", URL: "https://example.com/hook", Events: []string{db.AllEvents}}
+	rsp, err = root().SetBody(webhook).Post(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	rsp, err = root().SetBody(db.Poll{PollId: 1, Title: "Library levy", Options: []string{"Yes", "No"}}).Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	election := db.Election{ElectionId: 1, Name: "General", ElectionDay: time.Now().AddDate(0, 1, 0), PollIds: []int{1}}
+	rsp, err = root().SetBody(election).Post(s.base + "/elections")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	for _, state := range []db.ElectionState{db.StateRegistrationOpen, db.StateVoting, db.StateCounting} {
+		rsp, err = root().SetBody(map[string]any{"State": state}).
+			Post(fmt.Sprintf("%s/elections/%d/transition", s.base, election.ElectionId))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	rsp, err = root().Get(s.base + "/admin/export/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	backup := rsp.Body()
+
+	routes := []struct {
+		path string
+		body any
+	}{
+		{"/admin/apikeys/field-app/rotate", nil},
+		{"/admin/webhooks/crm/rotate", nil},
+		{"/admin/import/voters", backup},
+		{fmt.Sprintf("/elections/%d/transition", election.ElectionId), map[string]any{"State": db.StateCertified}},
+	}
+	for _, route := range routes {
+		for _, token := range []string{"", "not-a-token"} {
+			var apiErr client.Error
+			rsp, err = root().SetHeader("X-Step-Up", token).SetBody(route.body).SetError(&apiErr).Post(s.base + route.path)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode(), route.path)
+			assert.Equal(t, client.CodeStepUpRequired, apiErr.Code, route.path)
+		}
+	}
+
+	//A TOTP step-up opens the first half, a security key the rest
+	for _, route := range routes[:2] {
+		rsp, err = root().SetHeader("X-Step-Up", stepUp(t, s)).SetBody(route.body).Post(s.base + route.path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode(), route.path+": "+rsp.String())
+	}
+
+	//Without a registered key there is nothing to step up with
+	rsp, err = root().Post(s.base + "/auth/step-up/webauthn/begin")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	var user db.AdminUser
+	rsp, err = root().SetBody(db.AdminUser{Name: "root", Role: "admin"}).SetResult(&user).Post(s.base + "/admin/users")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	key := newSoftKey(t)
+	rsp, err = root().Post(fmt.Sprintf("%s/admin/users/%d/credentials/begin", s.base, user.UserId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = root().SetBody(key.create(t, rsp.Body())).Post(fmt.Sprintf("%s/admin/users/%d/credentials/finish", s.base, user.UserId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	webauthnStepUp := func(key *softKey) *resty.Response {
+		rsp, err := root().Post(s.base + "/auth/step-up/webauthn/begin")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		rsp, err = root().SetBody(key.get(t, rsp.Body())).Post(s.base + "/auth/step-up/webauthn/finish")
+		require.NoError(t, err)
+		return rsp
+	}
+
+	//A key that was never registered is refused
+	rsp = webauthnStepUp(newSoftKey(t))
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	for _, route := range routes[2:] {
+		var grant struct{ Token string }
+		rsp = webauthnStepUp(key)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		require.NoError(t, json.NewDecoder(bytes.NewReader(rsp.Body())).Decode(&grant))
+
+		rsp, err = root().SetHeader("X-Step-Up", grant.Token).SetBody(route.body).Post(s.base + route.path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode(), route.path+": "+rsp.String())
+	}
+}
@@ -0,0 +1,158 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/jobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_WritesReachTheStore runs every write route that changes voters on
+// the in-memory list on its own against a bolt store, and checks the
+// change is read back from bolt, where GET /voters/:id is served from, and
+// still there after a restart
+func Test_WritesReachTheStore(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-bolt", filepath.Join(dir, "voters.db"), "-archive", filepath.Join(dir, "archive")}
+	s := startServer(t, args...)
+
+	for id := 1; id <= 4; id++ {
+		voter := db.Voter{
+			VoterId:     id,
+			Name:        fmt.Sprint("Voter ", id),
+			Email:       fmt.Sprintf("voter%d@example.com", id),
+			PrecinctId:  1,
+			Preferences: db.Preferences{EmailOk: true},
+		}
+		rsp, err := s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	read := func(id int) db.Voter {
+		t.Helper()
+		var voter db.Voter
+		rsp, err := s.cli.R().SetResult(&voter).Get(fmt.Sprintf("%s/voters/%d", s.base, id))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return voter
+	}
+	post := func(path string, body any) {
+		t.Helper()
+		rsp, err := s.cli.R().SetBody(body).Post(s.base + path)
+		require.NoError(t, err)
+		require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	}
+	put := func(path string, body any) {
+		t.Helper()
+		rsp, err := s.cli.R().SetBody(body).Put(s.base + path)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	wait := func(path string, body any) {
+		t.Helper()
+		var job jobs.Job
+		rsp, err := s.cli.R().SetBody(body).SetResult(&job).Post(s.base + path)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, rsp.StatusCode(), rsp.String())
+		require.Eventually(t, func() bool {
+			s.cli.R().SetResult(&job).Get(fmt.Sprintf("%s/jobs/%d", s.base, job.JobId))
+			return job.Status == jobs.StatusDone || job.Status == jobs.StatusFailed
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, jobs.StatusDone, job.Status, job.Error)
+	}
+
+	address := db.Address{Street: "1 Main St", City: "Springfield", State: "IL", Zip: "62701"}
+	post("/voters/1/move", map[string]any{"Address": address, "PrecinctId": 2})
+	assert.Equal(t, address, read(1).Address)
+	assert.Equal(t, 2, read(1).PrecinctId)
+
+	post("/voters/1/polls:sync", []db.VoterHistory{{PollId: 1, VoteDate: time.Now().Add(-time.Hour)}})
+	assert.Len(t, read(1).VoteHistory, 1)
+
+	wait("/voters/tags", map[string]any{"Tag": "mailer", "Action": "add", "VoterIds": []int{1, 2}})
+	assert.Equal(t, []string{"mailer"}, read(1).Tags)
+	assert.Equal(t, []string{"mailer"}, read(2).Tags)
+
+	put("/voters/1/legal-hold", map[string]any{"Hold": true, "Reason": "litigation"})
+	assert.True(t, read(1).LegalHold)
+	assert.Equal(t, "litigation", read(1).LegalHoldReason)
+
+	post("/admin/legal-holds", map[string]any{"Hold": true, "Reason": "audit", "Filter": db.VoterFilter{Name: "Voter 2"}})
+	assert.True(t, read(2).LegalHold)
+
+	put("/voters/3/preferences", db.Preferences{EmailOk: true, Language: "es"})
+	assert.Equal(t, "es", read(3).Preferences.Language)
+
+	post("/voters/4/opt-out", nil)
+	assert.False(t, read(4).Preferences.EmailOk)
+
+	var campaign db.Campaign
+	rsp, err := s.cli.R().SetBody(db.Campaign{Subject: "Election day", Body: "Vote", Filter: db.VoterFilter{Name: "Voter 3"}}).
+		SetResult(&campaign).Post(s.base + "/admin/campaigns")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rsp.StatusCode(), rsp.String())
+	bounce := []db.DeliveryEvent{{VoterId: 3, Type: db.EventBounced, Bounce: db.BounceHard}}
+	require.Eventually(t, func() bool {
+		var result struct{ Recorded int }
+		s.cli.R().SetBody(bounce).SetResult(&result).Post(fmt.Sprintf("%s/admin/campaigns/%d/events", s.base, campaign.CampaignId))
+		return result.Recorded == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.True(t, read(3).EmailInvalid)
+
+	wait("/admin/boundary-changes?apply=true", db.BoundaryChange{Mappings: []db.PrecinctMapping{{From: 1, To: 5}}})
+	assert.Equal(t, 5, read(3).PrecinctId)
+	assert.Equal(t, 5, read(4).PrecinctId)
+
+	//A backup of voters taken elsewhere, imported over the roll
+	other := startServer(t)
+	backup := []db.Voter{
+		{VoterId: 4, Name: "Voter Four"},
+		{VoterId: 5, Name: "Voter 5",
+			VoteHistory: []db.VoterHistory{{PollId: 1, VoteId: 1, VoteDate: time.Date(2020, 11, 3, 0, 0, 0, 0, time.UTC)}}},
+	}
+	for _, voter := range backup {
+		rsp, err := other.cli.R().SetBody(voter).Post(other.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	rsp, err = other.cli.R().Get(other.base + "/admin/export/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	post("/admin/import/voters?strategy=overwrite", rsp.Body())
+	assert.Equal(t, "Voter Four", read(4).Name)
+	assert.Equal(t, "Voter 5", read(5).Name)
+
+	post("/admin/archive", map[string]string{"Before": "2022-01-01"})
+	assert.True(t, read(5).Archived)
+
+	//Decoys stay out of the store, the backend would serve them as real
+	//voters after a restart
+	post("/admin/decoys", []db.Voter{{VoterId: 100, Name: "Decoy Voter"}})
+	assert.Equal(t, "Decoy Voter", read(100).Name)
+
+	s.stop()
+	s = startServer(t, args...)
+
+	restarted := read(1)
+	assert.Equal(t, address, restarted.Address)
+	assert.Len(t, restarted.VoteHistory, 1)
+	assert.Equal(t, []string{"mailer"}, restarted.Tags)
+	assert.True(t, restarted.LegalHold)
+	assert.True(t, read(2).LegalHold)
+	assert.True(t, read(3).EmailInvalid)
+	assert.Equal(t, 5, read(3).PrecinctId)
+	assert.Equal(t, "Voter Four", read(4).Name)
+	assert.Equal(t, "Voter 5", read(5).Name)
+	rsp, err = s.cli.R().Get(s.base + "/voters/100")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
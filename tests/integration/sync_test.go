@@ -0,0 +1,102 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_SyncStampsServerTime checks that votes synced from an offline app
+// get the server time as VoteDate and keep the app's time as the
+// ClientVoteDate, which is what a later sync is compared against
+func Test_SyncStampsServerTime(t *testing.T) {
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Offline Voter"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	sync := func(history []db.VoterHistory) db.HistorySync {
+		t.Helper()
+		var result db.HistorySync
+		rsp, err := s.cli.R().SetBody(history).SetResult(&result).Post(s.base + "/voters/1/polls:sync")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return result
+	}
+
+	recorded := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Second)
+	result := sync([]db.VoterHistory{{PollId: 1, VoteDate: recorded}})
+	assert.Equal(t, []int{1}, result.Added)
+	require.Len(t, result.History, 1)
+	assert.WithinDuration(t, time.Now(), result.History[0].VoteDate, 5*time.Second)
+	assert.True(t, recorded.Equal(result.History[0].ClientVoteDate))
+
+	result = sync([]db.VoterHistory{{PollId: 1, VoteDate: recorded}})
+	assert.Equal(t, []int{1}, result.Unchanged)
+	assert.Empty(t, result.Conflicts)
+
+	result = sync([]db.VoterHistory{{PollId: 1, VoteDate: recorded.Add(time.Minute)}})
+	require.Len(t, result.Conflicts, 1)
+	assert.Equal(t, "vote date differs", result.Conflicts[0].Reason)
+}
+
+// Test_SyncExtensionByServerTime checks that a synced vote is flagged
+// with the poll extension open when it reaches the server, a device that
+// dates the vote before the regular close can not pass it off as cast in
+// the regular hours
+func Test_SyncExtensionByServerTime(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{
+		"Keys": [{"Key": "root-secret", "Name": "root", "Role": "admin"},
+			{"Key": "second-secret", "Name": "second", "Role": "admin"}],
+		"Roles": {"admin": ["*"]}
+	}`), 0o600))
+	s := startServer(t, "-access", config)
+	as := func(key string) *resty.Request { return s.cli.R().SetHeader("X-API-Key", key) }
+
+	closes := time.Now().Add(-time.Hour)
+	rsp, err := as("root-secret").SetBody(db.Poll{PollId: 1, Title: "Library levy", Options: []string{"Yes", "No"}, Closes: closes}).
+		Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+	rsp, err = as("root-secret").SetBody(db.Voter{VoterId: 1, Name: "Offline Voter"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var extension db.PollExtension
+	rsp, err = as("root-secret").SetResult(&extension).
+		SetBody(map[string]any{"Closes": time.Now().Add(time.Hour), "Justification": "Court order"}).
+		Post(s.base + "/polls/1/extend")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rsp.StatusCode(), rsp.String())
+	rsp, err = as("second-secret").Post(s.base + "/polls/1/extensions/" + fmt.Sprint(extension.ExtensionId) + "/approve")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var result db.HistorySync
+	rsp, err = as("root-secret").SetBody([]db.VoterHistory{{PollId: 1, VoteDate: closes.Add(-time.Hour)}}).
+		SetResult(&result).Post(s.base + "/voters/1/polls:sync")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, result.History, 1)
+	assert.Equal(t, extension.ExtensionId, result.History[0].ExtensionId)
+	assert.True(t, result.History[0].VoteDate.After(closes))
+
+	var stored db.Voter
+	rsp, err = as("root-secret").SetResult(&stored).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, stored.VoteHistory, 1)
+	assert.Equal(t, extension.ExtensionId, stored.VoteHistory[0].ExtensionId)
+}
@@ -0,0 +1,163 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scopedToken is the answer of POST /auth/token
+type scopedToken struct {
+	Token string `json:"token"`
+}
+
+// Test_ScopedTokens mints tokens from a managed key and checks they can do
+// no more than they were minted for
+func Test_ScopedTokens(t *testing.T) {
+	s := startWithRootKey(t)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	address := db.Address{Street: "1 Main St", City: "Springfield", State: "IL", Zip: "62701"}
+	for _, voter := range []db.Voter{{VoterId: 1, Name: "Jane Smith", Address: address}, {VoterId: 2, Name: "John Doe", Address: address}} {
+		rsp, err := root().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+
+	var issued issuedKey
+	rsp, err := root().SetBody(db.APIKey{Name: "portal", Role: "admin"}).SetResult(&issued).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	portal := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", issued.Secret) }
+
+	rsp, err = s.cli.R().SetBody(map[string]any{"ReadOnly": true}).Post(s.base + "/auth/token")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	for _, ttl := range []string{"soon", "-5m", "48h"} {
+		rsp, err = portal().SetBody(map[string]any{"TTL": ttl}).Post(s.base + "/auth/token")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode(), ttl)
+	}
+
+	var token scopedToken
+	rsp, err = portal().SetBody(map[string]any{"VoterIds": []int{1}, "ReadOnly": true, "TTL": "5m"}).
+		SetResult(&token).Post(s.base + "/auth/token")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.NotEmpty(t, token.Token)
+	bearer := func() *resty.Request { return s.cli.R().SetAuthToken(token.Token) }
+
+	//The token sees its voter and nothing else
+	rsp, err = bearer().Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = bearer().Get(s.base + "/voters/2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+
+	var voters []db.Voter
+	rsp, err = bearer().SetResult(&voters).Get(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	require.Len(t, voters, 1)
+	assert.Equal(t, 1, voters[0].VoterId)
+
+	//The label export is a CSV the JSON filters never see
+	rsp, err = bearer().Get(s.base + "/voters/export")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Contains(t, rsp.String(), "Jane Smith")
+	assert.NotContains(t, rsp.String(), "John Doe")
+
+	rsp, err = bearer().SetBody(db.Voter{VoterId: 1, Name: "Jane Doe"}).Put(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	rsp, err = bearer().SetBody(map[string]any{}).Post(s.base + "/auth/token")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	//A writable token still can not add voters
+	var writer scopedToken
+	rsp, err = portal().SetBody(map[string]any{"VoterIds": []int{2}}).SetResult(&writer).Post(s.base + "/auth/token")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetAuthToken(writer.Token).SetBody(db.Voter{VoterId: 2, Name: "John Smith"}).Put(s.base + "/voters/2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	rsp, err = s.cli.R().SetAuthToken(writer.Token).SetBody(db.Voter{VoterId: 3, Name: "Ann Lee"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode())
+
+	//Revoking the key ends its tokens
+	rsp, err = root().Post(s.base + "/admin/apikeys/portal/revoke")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	rsp, err = bearer().Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	rsp, err = s.cli.R().SetAuthToken("not-a-token").Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+}
+
+// Test_TokenRateLimit checks that requests made with a scoped token are
+// charged to the rate limit of the key that minted it
+func Test_TokenRateLimit(t *testing.T) {
+	s := startWithRootKey(t)
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	rsp, err := root().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var issued issuedKey
+	rsp, err = root().SetBody(db.APIKey{Name: "portal", Role: "admin", RateLimit: 0.1, Burst: 3}).
+		SetResult(&issued).Post(s.base + "/admin/apikeys")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode(), rsp.String())
+
+	var token scopedToken
+	rsp, err = s.cli.R().SetHeader("X-API-Key", issued.Secret).SetBody(map[string]any{"TTL": "5m"}).
+		SetResult(&token).Post(s.base + "/auth/token")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	//The mint took one request of the burst, the token gets the rest
+	for i := 0; i < 2; i++ {
+		rsp, err = s.cli.R().SetAuthToken(token.Token).Get(s.base + "/voters/1")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	rsp, err = s.cli.R().SetAuthToken(token.Token).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode())
+	rsp, err = s.cli.R().SetHeader("X-API-Key", issued.Secret).Get(s.base + "/voters/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode())
+
+	var stats []struct {
+		Key      string
+		Allowed  int64
+		Rejected int64
+	}
+	rsp, err = root().SetResult(&stats).Get(s.base + "/admin/ratelimits")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, stats, 1)
+	assert.Equal(t, "portal", stats[0].Key)
+	assert.Equal(t, int64(3), stats[0].Allowed)
+	assert.Equal(t, int64(2), stats[0].Rejected)
+}
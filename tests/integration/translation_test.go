@@ -0,0 +1,94 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_Translations checks that polls are served in the language a voter
+// prefers or the request accepts, falling back to the original text, and
+// that campaign recipients are each sent the translation of their language
+func Test_Translations(t *testing.T) {
+	s := startServer(t)
+
+	rsp, err := s.cli.R().SetBody(db.Poll{PollId: 1, Title: "Library levy", Options: []string{"Yes", "No"}}).Post(s.base + "/polls")
+	require.NoError(t, err)
+	require.Less(t, rsp.StatusCode(), 300, rsp.String())
+
+	var poll db.Poll
+	rsp, err = s.cli.R().SetBody(db.PollTranslation{Title: "Impuesto de biblioteca", Options: []string{"Sí", "No"}}).
+		SetResult(&poll).Put(s.base + "/polls/1/translations/es_mx")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Contains(t, poll.Translations, "es-MX")
+
+	//A translation that does not line up with the poll, or a made up
+	//locale, is refused
+	rsp, err = s.cli.R().SetBody(db.PollTranslation{Options: []string{"Oui"}}).Put(s.base + "/polls/1/translations/fr")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(db.PollTranslation{Title: "Levy"}).Put(s.base + "/polls/1/translations/not!a!locale")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	get := func(query, accept string) (db.Poll, *http.Response) {
+		t.Helper()
+		var poll db.Poll
+		rsp, err := s.cli.R().SetHeader("Accept-Language", accept).SetResult(&poll).Get(s.base + "/polls/1" + query)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		return poll, rsp.RawResponse
+	}
+
+	//es is served es-MX, the best language that has a translation wins
+	poll, raw := get("", "fr;q=1, es;q=0.5")
+	assert.Equal(t, "Impuesto de biblioteca", poll.Title)
+	assert.Equal(t, []string{"Sí", "No"}, poll.Options)
+	assert.Equal(t, "es-MX", poll.Locale)
+	assert.Equal(t, "es-MX", raw.Header.Get("Content-Language"))
+	assert.Contains(t, raw.Header.Get("Vary"), "Accept-Language")
+
+	poll, raw = get("", "fr")
+	assert.Equal(t, "Library levy", poll.Title)
+	assert.Empty(t, poll.Locale)
+	assert.Empty(t, raw.Header.Get("Content-Language"))
+
+	//The language preference of the voter comes before the header
+	voters := []db.Voter{
+		{VoterId: 1, Name: "Ana Garcia", Email: "ana@example.com", Preferences: db.Preferences{EmailOk: true, Language: "es"}},
+		{VoterId: 2, Name: "John Doe", Email: "john@example.com", Preferences: db.Preferences{EmailOk: true, Language: "fr"}},
+	}
+	for _, voter := range voters {
+		rsp, err = s.cli.R().SetBody(voter).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	}
+	poll, _ = get("?voter=1", "en")
+	assert.Equal(t, "Impuesto de biblioteca", poll.Title)
+
+	var campaign db.Campaign
+	rsp, err = s.cli.R().SetBody(db.Campaign{
+		Subject: "Election day", Body: "Polls are open until 8pm",
+		Translations: map[string]db.MessageTranslation{"es": {Subject: "Día de elección", Body: "Las urnas abren hasta las 8pm"}},
+	}).SetResult(&campaign).Post(s.base + "/admin/campaigns")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rsp.StatusCode(), rsp.String())
+	locales := make(map[int]string)
+	for _, delivery := range campaign.Deliveries {
+		locales[delivery.VoterId] = delivery.Locale
+	}
+	assert.Equal(t, map[int]string{1: "es", 2: ""}, locales)
+
+	//Without the translation the original text is served again
+	rsp, err = s.cli.R().Delete(s.base + "/polls/1/translations/es-MX")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	poll, _ = get("", "es")
+	assert.Equal(t, "Library levy", poll.Title)
+}
@@ -0,0 +1,67 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_VersionNegotiation reads and writes the same voter with the field
+// names of both API versions
+func Test_VersionNegotiation(t *testing.T) {
+	s := startServer(t)
+	v2 := map[string]string{"API-Version": "2"}
+
+	var created map[string]any
+	rsp, err := s.cli.R().SetHeaders(v2).SetResult(&created).
+		SetBody(map[string]any{"voter_id": 7, "name": "Jane Smith", "address": map[string]any{"zip": "19104"}}).
+		Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, "2", rsp.Header().Get("API-Version"))
+	assert.Equal(t, float64(7), created["voter_id"])
+	assert.Equal(t, "19104", created["address"].(map[string]any)["zip"])
+	assert.NotContains(t, created, "VoterId")
+
+	//Version 1 is the default and sees the same voter by the old names
+	var legacy map[string]any
+	rsp, err = s.cli.R().SetResult(&legacy).Get(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.Equal(t, "1", rsp.Header().Get("API-Version"))
+	assert.Equal(t, "Jane Smith", legacy["Name"])
+	assert.Equal(t, "19104", legacy["Address"].(map[string]any)["Zip"])
+
+	var history []map[string]any
+	rsp, err = s.cli.R().SetHeaders(v2).SetResult(&history).
+		SetBody([]map[string]any{{"op": "add", "path": "/-", "value": map[string]any{"poll_id": 3, "vote_date": time.Now()}}}).
+		Patch(s.base + "/voters/7/polls")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.Len(t, history, 1)
+	assert.Equal(t, float64(3), history[0]["poll_id"])
+	rsp, err = s.cli.R().SetHeaders(v2).SetResult(&history).
+		SetBody([]map[string]any{{"op": "replace", "path": "/0/channel", "value": "mail"}}).
+		Patch(s.base + "/voters/7/polls")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	assert.Equal(t, "mail", history[0]["channel"])
+
+	rsp, err = s.cli.R().SetHeaders(v2).SetBody(map[string]any{"email": "jane@example.com"}).Patch(s.base + "/voters/7")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	var patched map[string]any
+	require.NoError(t, json.Unmarshal(rsp.Body(), &patched))
+	assert.Equal(t, "jane@example.com", patched["email"])
+	assert.Len(t, patched["vote_history"], 1)
+
+	rsp, err = s.cli.R().SetHeader("API-Version", "3").Get(s.base + "/voters/7")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+}
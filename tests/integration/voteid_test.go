@@ -0,0 +1,100 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_VoteIdsGlobal records votes with one VoteId sequence for all voters
+// and checks that the ids clients send are ignored, that a corrected vote
+// keeps its id and that a deleted vote's id is not handed out again
+func Test_VoteIdsGlobal(t *testing.T) {
+	s := startServer(t, "-vote-ids", "global")
+
+	for id := 1; id <= 2; id++ {
+		rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Sequence Voter"}).Post(s.base + "/voters")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+	}
+
+	vote := func(voterID, pollID int, query string) db.VoterHistory {
+		var history db.VoterHistory
+		rsp, err := s.cli.R().
+			SetBody(db.VoterHistory{VoteId: 99, VoteDate: time.Now()}).
+			SetResult(&history).
+			Post(fmt.Sprintf("%s/voters/%d/polls/%d%s", s.base, voterID, pollID, query))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode())
+		return history
+	}
+
+	assert.Equal(t, 1, vote(1, 1, "").VoteId)
+	assert.Equal(t, 2, vote(2, 1, "").VoteId)
+	assert.Equal(t, 1, vote(1, 1, "?override=true").VoteId)
+
+	rsp, err := s.cli.R().Delete(s.base + "/voters/2/polls/1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	assert.Equal(t, 3, vote(2, 1, "").VoteId)
+	assert.Equal(t, 4, vote(1, 2, "").VoteId)
+
+	var polls []db.VoterHistory
+	rsp, err = s.cli.R().SetResult(&polls).Get(s.base + "/voters/1/polls")
+	require.NoError(t, err)
+	require.Len(t, polls, 2)
+	assert.Equal(t, 1, polls[0].VoteId)
+	assert.Equal(t, 4, polls[1].VoteId)
+}
+
+// Test_VoteIdsAfterRestart checks that a restart against a bolt store
+// does not hand out the id of a vote that was deleted before it, in
+// either scope
+func Test_VoteIdsAfterRestart(t *testing.T) {
+	for _, scope := range []db.VoteIdScope{db.VoteIdsGlobal, db.VoteIdsPerVoter} {
+		t.Run(string(scope), func(t *testing.T) {
+			args := []string{"-bolt", filepath.Join(t.TempDir(), "voters.db"), "-vote-ids", string(scope)}
+			s := startServer(t, args...)
+
+			for id := 1; id <= 2; id++ {
+				rsp, err := s.cli.R().SetBody(db.Voter{VoterId: id, Name: "Sequence Voter"}).Post(s.base + "/voters")
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, rsp.StatusCode())
+			}
+			vote := func(voterID, pollID int) int {
+				t.Helper()
+				var history db.VoterHistory
+				rsp, err := s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).SetResult(&history).
+					Post(fmt.Sprintf("%s/voters/%d/polls/%d", s.base, voterID, pollID))
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+				return history.VoteId
+			}
+
+			first := vote(1, 1)
+			latest := vote(1, 2)
+			rsp, err := s.cli.R().Delete(s.base + "/voters/1/polls/2")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+			s.stop()
+			s = startServer(t, args...)
+
+			next := vote(1, 3)
+			assert.Greater(t, next, latest)
+			assert.NotEqual(t, first, next)
+			if scope == db.VoteIdsGlobal {
+				assert.Greater(t, vote(2, 1), next)
+			}
+		})
+	}
+}
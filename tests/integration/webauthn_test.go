@@ -0,0 +1,84 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_WebAuthnLogin logs an admin user in with a security key and checks
+// the session acts as the user, and that neither an unknown user nor an
+// unregistered key gets a session
+func Test_WebAuthnLogin(t *testing.T) {
+	s := startWithRootKey(t, "-webauthn-rpid", "localhost")
+	root := func() *resty.Request { return s.cli.R().SetHeader("X-API-Key", "root-secret") }
+
+	var user db.AdminUser
+	rsp, err := root().SetBody(db.AdminUser{Name: "jdoe", Role: "admin"}).SetResult(&user).Post(s.base + "/admin/users")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	//Unknown users and users without a key get the same answer
+	for _, name := range []string{"nobody", "jdoe"} {
+		rsp, err = s.cli.R().SetBody(map[string]string{"Name": name}).Post(s.base + "/auth/webauthn/login/begin")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode(), name)
+	}
+
+	key := newSoftKey(t)
+	rsp, err = root().Post(fmt.Sprintf("%s/admin/users/%d/credentials/begin", s.base, user.UserId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = root().SetBody(key.create(t, rsp.Body())).Post(fmt.Sprintf("%s/admin/users/%d/credentials/finish", s.base, user.UserId))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	var session struct {
+		Token string `json:"token"`
+		User  string `json:"user"`
+		Role  string `json:"role"`
+	}
+	login := func(key *softKey) *resty.Response {
+		t.Helper()
+		rsp, err := s.cli.R().SetBody(map[string]string{"Name": "jdoe"}).Post(s.base + "/auth/webauthn/login/begin")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+		rsp, err = s.cli.R().SetBody(key.get(t, rsp.Body())).SetResult(&session).
+			Post(s.base + "/auth/webauthn/login/finish?name=jdoe")
+		require.NoError(t, err)
+		return rsp
+	}
+
+	rsp = login(newSoftKey(t))
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+
+	rsp = login(key)
+	require.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	require.NotEmpty(t, session.Token)
+	assert.Equal(t, "jdoe", session.User)
+	assert.Equal(t, "admin", session.Role)
+
+	//The session writes and reaches the admin routes, a made up one does
+	//not, and neither does a caller without one
+	rsp, err = s.cli.R().SetHeader("X-Session-Token", session.Token).
+		SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+	rsp, err = s.cli.R().SetHeader("X-Session-Token", session.Token).Get(s.base + "/admin/users")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode(), rsp.String())
+
+	rsp, err = s.cli.R().SetHeader("X-Session-Token", "not-a-session").Get(s.base + "/admin/users")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 2, Name: "John Doe"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode())
+}
@@ -0,0 +1,130 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/api"
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received is a delivery a receiver got
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// Test_WebhookDelivery delivers signed changes to a webhook, retries a
+// delivery the receiver failed and rotates the secret
+func Test_WebhookDelivery(t *testing.T) {
+	s := startServer(t)
+
+	var mu sync.Mutex
+	failNext := false
+	deliveries := make(chan received, 16)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		fail := failNext
+		failNext = false
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		deliveries <- received{header: r.Header, body: body}
+	}))
+	t.Cleanup(receiver.Close)
+	next := func() received {
+		select {
+		case delivery := <-deliveries:
+			return delivery
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "no delivery within 10s")
+			return received{}
+		}
+	}
+
+	var created struct {
+		db.Webhook
+		Secret string
+	}
+	rsp, err := s.cli.R().SetResult(&created).
+		SetBody(db.Webhook{Name: "crm", URL: receiver.URL, Events: []string{db.EventVoterCreated, db.EventVoteRecorded}}).
+		Post(s.base + "/admin/webhooks")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rsp.StatusCode())
+	require.NotEmpty(t, created.Secret)
+
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 1, Name: "Jane Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+
+	delivery := next()
+	assert.Equal(t, db.EventVoterCreated, delivery.header.Get(webhook.HeaderEvent))
+	assert.NotEmpty(t, delivery.header.Get(webhook.HeaderDelivery))
+	require.NoError(t, webhook.Verify(created.Secret, delivery.header.Get(webhook.HeaderSignature), delivery.body, time.Minute, time.Now()))
+	var payload api.WebhookPayload
+	require.NoError(t, json.Unmarshal(delivery.body, &payload))
+	assert.Equal(t, "crm", payload.Webhook)
+	assert.Equal(t, 1, payload.VoterId)
+	require.NotNil(t, payload.Voter)
+	assert.Equal(t, "Jane Smith", payload.Voter["Name"])
+
+	//A delivery the receiver fails is tried again with the same id
+	mu.Lock()
+	failNext = true
+	mu.Unlock()
+	rsp, err = s.cli.R().SetBody(db.VoterHistory{VoteDate: time.Now()}).Post(s.base + "/voters/1/polls/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	delivery = next()
+	assert.Equal(t, db.EventVoteRecorded, delivery.header.Get(webhook.HeaderEvent))
+
+	//The attempt is recorded once the receiver has answered
+	var log struct {
+		Attempts []webhook.Attempt
+		Stats    webhook.Stats
+	}
+	require.Eventually(t, func() bool {
+		rsp, err = s.cli.R().SetResult(&log).Get(s.base + "/admin/webhooks/crm/deliveries")
+		return err == nil && rsp.StatusCode() == http.StatusOK && len(log.Attempts) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 2, log.Attempts[0].Attempt)
+	assert.Equal(t, http.StatusInternalServerError, log.Attempts[1].Status)
+	assert.False(t, log.Attempts[1].Final)
+	assert.Equal(t, log.Attempts[0].Delivery, log.Attempts[1].Delivery)
+	assert.Equal(t, delivery.header.Get(webhook.HeaderDelivery), log.Attempts[0].Delivery)
+	assert.Equal(t, webhook.Stats{Queued: 2, Delivered: 2, Retried: 1}, log.Stats)
+
+	//Deliveries are signed with the rotated secret from then on
+	var rotated struct {
+		Secret string
+	}
+	rsp, err = s.cli.R().SetResult(&rotated).Post(s.base + "/admin/webhooks/crm/rotate")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	assert.NotEqual(t, created.Secret, rotated.Secret)
+
+	rsp, err = s.cli.R().SetBody(db.Voter{VoterId: 2, Name: "John Smith"}).Post(s.base + "/voters")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode())
+	delivery = next()
+	signature := delivery.header.Get(webhook.HeaderSignature)
+	assert.NoError(t, webhook.Verify(rotated.Secret, signature, delivery.body, time.Minute, time.Now()))
+	assert.Error(t, webhook.Verify(created.Secret, signature, delivery.body, time.Minute, time.Now()))
+
+	rsp, err = s.cli.R().Get(s.base + "/admin/webhooks/nope/deliveries")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
@@ -0,0 +1,185 @@
+package properties
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+)
+
+// These tests hammer one VoterList from many goroutines, the way
+// concurrent requests do.  Run them with -race, a data race fails them
+// even when the final state happens to come out right.
+
+const (
+	workers          = 16
+	votersPerWorker  = 50
+	pollsPerVoter    = 5
+	readersPerWorker = 2
+)
+
+// Test_ConcurrentVoterWrites adds, updates and deletes voters from many
+// goroutines while others read, and checks that no write was lost
+func Test_ConcurrentVoterWrites(t *testing.T) {
+	list, err := db.NewVoterList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	for r := 0; r < workers*readersPerWorker; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				list.GetAllVoters()
+				list.GetVoter(r)
+				list.FindVoters(db.VoterFilter{})
+				list.Checksum()
+				list.GetTurnout(1)
+			}
+		}(r)
+	}
+
+	for w := 0; w < workers; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < votersPerWorker; i++ {
+				id := w*votersPerWorker + i + 1
+				if err := list.AddVoter(db.Voter{VoterId: id, Name: fmt.Sprint("voter ", id)}); err != nil {
+					t.Errorf("AddVoter(%d): %v", id, err)
+					return
+				}
+
+				voter, err := list.GetVoter(id)
+				if err != nil {
+					t.Errorf("GetVoter(%d): %v", id, err)
+					return
+				}
+				voter.Name = fmt.Sprint("renamed ", id)
+				if err := list.UpdateVoter(voter); err != nil {
+					t.Errorf("UpdateVoter(%d): %v", id, err)
+					return
+				}
+
+				//Every third voter is removed again
+				if i%3 == 0 {
+					if err := list.DeleteVoter(id); err != nil {
+						t.Errorf("DeleteVoter(%d): %v", id, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	voters, err := list.GetAllVoters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := workers * (votersPerWorker - (votersPerWorker+2)/3)
+	if len(voters) != want {
+		t.Fatalf("have %d voters, want %d", len(voters), want)
+	}
+	for _, voter := range voters {
+		if voter.Name != fmt.Sprint("renamed ", voter.VoterId) {
+			t.Fatalf("voter %d lost its update, name is %q", voter.VoterId, voter.Name)
+		}
+	}
+}
+
+// Test_ConcurrentPollWrites records votes for the same voters from many
+// goroutines.  Each vote is a read, modify and write of the voter, so
+// without locking votes overwrite each other.
+func Test_ConcurrentPollWrites(t *testing.T) {
+	list, err := db.NewVoterList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= votersPerWorker; id++ {
+		if err := list.AddVoter(db.Voter{VoterId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for id := 1; id <= votersPerWorker; id++ {
+				for p := 0; p < pollsPerVoter; p++ {
+					pollID := w*pollsPerVoter + p + 1
+					if _, err := list.AddVoterPoll(id, pollID, db.VoterHistory{VoteDate: time.Now()}); err != nil {
+						t.Errorf("AddVoterPoll(%d, %d): %v", id, pollID, err)
+						return
+					}
+				}
+				list.TagVoters([]int{id}, fmt.Sprint("worker ", w), true)
+				list.SyncVoterPolls(id, nil)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for id := 1; id <= votersPerWorker; id++ {
+		history, err := list.GetVoterPolls(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != workers*pollsPerVoter {
+			t.Fatalf("voter %d has %d votes, want %d", id, len(history), workers*pollsPerVoter)
+		}
+
+		voter, err := list.GetVoter(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(voter.Tags) != workers {
+			t.Fatalf("voter %d has %d tags, want %d", id, len(voter.Tags), workers)
+		}
+	}
+}
+
+// Test_ReadsAreCopies checks that changing a voter that was read does not
+// change the stored voter, which readers in other goroutines share
+func Test_ReadsAreCopies(t *testing.T) {
+	list, err := db.NewVoterList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.AddVoter(db.Voter{VoterId: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.AddVoterPoll(1, 1, db.VoterHistory{VoteDate: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	voter, err := list.GetVoter(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	voter.VoteHistory[0].PollId = 99
+
+	history, err := list.GetVoterPoll(1, 1)
+	if err != nil {
+		t.Fatalf("stored history changed through a read: %v", err)
+	}
+	if history.PollId != 1 {
+		t.Fatalf("stored poll id is %d, want 1", history.PollId)
+	}
+}
@@ -0,0 +1,311 @@
+// Package properties checks invariants of the voter store over random
+// sequences of operations.  Unlike the tests in the parent directory they
+// use the db package directly and need no running server.
+package properties
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/adllev/voter-api/db"
+	"github.com/adllev/voter-api/db/boltdb"
+	"pgregory.net/rapid"
+)
+
+// storeMachine runs random operations against a VoterStore and keeps a
+// model of what it should hold, voter id -> poll ids voted in
+type storeMachine struct {
+	list  db.VoterStore
+	model map[int][]int
+}
+
+func newStoreMachine(t *rapid.T) *storeMachine {
+	list, err := db.NewVoterList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &storeMachine{list: list, model: make(map[int][]int)}
+}
+
+// voterID draws from a small range so operations hit the same voters
+func voterID(t *rapid.T) int {
+	return rapid.IntRange(1, 10).Draw(t, "voter")
+}
+
+func pollID(t *rapid.T) int {
+	return rapid.IntRange(1, 5).Draw(t, "poll")
+}
+
+func (m *storeMachine) AddVoter(t *rapid.T) {
+	id := voterID(t)
+	err := m.list.AddVoter(db.Voter{VoterId: id, Name: fmt.Sprint("voter ", id)})
+
+	_, exists := m.model[id]
+	if exists != (err != nil) {
+		t.Fatalf("AddVoter(%d) exists=%v err=%v", id, exists, err)
+	}
+	if !exists {
+		m.model[id] = []int{}
+	}
+}
+
+// CreateVoter must hand out an id that is not in use, whatever id the
+// voter carries
+func (m *storeMachine) CreateVoter(t *rapid.T) {
+	id, err := m.list.CreateVoter(db.Voter{VoterId: voterID(t), Name: "created"})
+	if err != nil {
+		t.Fatalf("CreateVoter: %v", err)
+	}
+	if _, exists := m.model[id]; exists || id <= 0 {
+		t.Fatalf("CreateVoter assigned id %d, which is in use or invalid", id)
+	}
+	m.model[id] = []int{}
+}
+
+func (m *storeMachine) DeleteVoter(t *rapid.T) {
+	id := voterID(t)
+	err := m.list.DeleteVoter(id)
+	if _, exists := m.model[id]; !exists {
+		if !errors.Is(err, db.ErrNotFound) {
+			t.Fatalf("DeleteVoter(%d) of a missing voter: %v", id, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("DeleteVoter(%d): %v", id, err)
+	}
+	delete(m.model, id)
+}
+
+// RenameVoter is an update that does not touch the history, the history
+// must come through it unchanged
+func (m *storeMachine) RenameVoter(t *rapid.T) {
+	id := voterID(t)
+	voter, err := m.list.GetVoter(id)
+	if _, exists := m.model[id]; !exists {
+		if err == nil {
+			t.Fatalf("GetVoter(%d) found a deleted voter", id)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("GetVoter(%d): %v", id, err)
+	}
+
+	voter.Name = rapid.StringN(1, 20, -1).Draw(t, "name")
+	if err := m.list.UpdateVoter(voter); err != nil {
+		t.Fatalf("UpdateVoter(%d): %v", id, err)
+	}
+}
+
+func (m *storeMachine) AddVoterPoll(t *rapid.T) {
+	id, poll := voterID(t), pollID(t)
+	polls, exists := m.model[id]
+	if !exists {
+		t.Skip("voter does not exist")
+	}
+
+	_, err := m.list.AddVoterPoll(id, poll, db.VoterHistory{VoteDate: time.Now()})
+	if contains(polls, poll) {
+		if !errors.Is(err, db.ErrAlreadyExists) {
+			t.Fatalf("AddVoterPoll(%d, %d) of a second vote in the poll: %v", id, poll, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("AddVoterPoll(%d, %d): %v", id, poll, err)
+	}
+	m.model[id] = append(polls, poll)
+}
+
+func (m *storeMachine) DeleteVoterPoll(t *rapid.T) {
+	id, poll := voterID(t), pollID(t)
+	polls, exists := m.model[id]
+	err := m.list.DeleteVoterPoll(id, poll)
+	if !exists || !contains(polls, poll) {
+		if err == nil {
+			t.Fatalf("DeleteVoterPoll(%d, %d) deleted a vote that does not exist", id, poll)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("DeleteVoterPoll(%d, %d): %v", id, poll, err)
+	}
+
+	remaining := make([]int, 0, len(polls))
+	for _, p := range polls {
+		if p != poll {
+			remaining = append(remaining, p)
+		}
+	}
+	m.model[id] = remaining
+}
+
+// Check runs after every operation
+func (m *storeMachine) Check(t *rapid.T) {
+	voters, err := m.list.GetAllVoters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//GetAll count equals successful adds minus deletes
+	if len(voters) != len(m.model) {
+		t.Fatalf("store holds %d voters, expected %d", len(voters), len(m.model))
+	}
+
+	//Every voter holds exactly the votes that were recorded for it, so
+	//the history survives renames and changes to other voters
+	for _, voter := range voters {
+		want, ok := m.model[voter.VoterId]
+		if !ok {
+			t.Fatalf("store holds unexpected voter %d", voter.VoterId)
+		}
+
+		got := make([]int, 0, len(voter.VoteHistory))
+		for _, history := range voter.VoteHistory {
+			got = append(got, history.PollId)
+		}
+		if !samePolls(got, want) {
+			t.Fatalf("voter %d has polls %v, expected %v", voter.VoterId, got, want)
+		}
+	}
+}
+
+func Test_StoreInvariants(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		m := newStoreMachine(t)
+		t.Repeat(rapid.StateMachineActions(m))
+	})
+}
+
+// Test_BoltStoreInvariants runs the same operations against the bbolt
+// store, each check in a fresh file
+func Test_BoltStoreInvariants(t *testing.T) {
+	dir := t.TempDir()
+	runs := 0
+	rapid.Check(t, func(rt *rapid.T) {
+		runs++
+		store, err := boltdb.Open(filepath.Join(dir, fmt.Sprintf("voters-%d.db", runs)))
+		if err != nil {
+			rt.Fatal(err)
+		}
+		defer store.Close()
+
+		m := &storeMachine{list: store, model: make(map[int][]int)}
+		rt.Repeat(rapid.StateMachineActions(m))
+	})
+}
+
+// Test_BoltLegalHold checks that the bbolt store never deletes a voter
+// under legal hold, and that it says so with a legal hold error
+func Test_BoltLegalHold(t *testing.T) {
+	dir := t.TempDir()
+	runs := 0
+	rapid.Check(t, func(rt *rapid.T) {
+		runs++
+		store, err := boltdb.Open(filepath.Join(dir, fmt.Sprintf("holds-%d.db", runs)))
+		if err != nil {
+			rt.Fatal(err)
+		}
+		defer store.Close()
+
+		held := make(map[int]bool)
+		for id := 1; id <= 5; id++ {
+			store.AddVoter(db.Voter{VoterId: id})
+			if rapid.Bool().Draw(rt, "hold") {
+				if err := store.SetLegalHold(id, true, "litigation"); err != nil {
+					rt.Fatal(err)
+				}
+				held[id] = true
+			}
+		}
+
+		if err := store.DeleteAll(); len(held) > 0 && !db.IsLegalHold(err) {
+			rt.Fatalf("DeleteAll with %d held voters returned %v", len(held), err)
+		}
+		for id := 1; id <= 5; id++ {
+			err := store.DeleteVoter(id)
+			if held[id] && !db.IsLegalHold(err) {
+				rt.Fatalf("deleting held voter %d returned %v", id, err)
+			}
+			if _, err := store.GetVoter(id); held[id] && err != nil {
+				rt.Fatalf("held voter %d is gone: %v", id, err)
+			}
+		}
+	})
+}
+
+// Test_UniqueVoterPoll checks that a voter can only have one vote per poll
+func Test_UniqueVoterPoll(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		list, _ := db.NewVoterList()
+		list.AddVoter(db.Voter{VoterId: 1})
+
+		polls := rapid.SliceOfN(rapid.IntRange(1, 5), 1, 20).Draw(t, "polls")
+		for _, poll := range polls {
+			list.AddVoterPoll(1, poll, db.VoterHistory{VoteDate: time.Now()})
+		}
+
+		history, _ := list.GetVoterPolls(1)
+		seen := make(map[int]bool)
+		for _, h := range history {
+			if seen[h.PollId] {
+				t.Fatalf("voter has two votes in poll %d", h.PollId)
+			}
+			seen[h.PollId] = true
+		}
+	})
+}
+
+// Test_VoteIdsNeverReused checks that a VoteId is not handed out twice,
+// not even after the vote that held it was deleted
+func Test_VoteIdsNeverReused(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		list, _ := db.NewVoterList()
+		list.AddVoter(db.Voter{VoterId: 1})
+
+		used := make(map[int]bool)
+		polls := rapid.IntRange(2, 10).Draw(t, "polls")
+		for poll := 1; poll <= polls; poll++ {
+			list.AddVoterPoll(1, poll, db.VoterHistory{VoteDate: time.Now()})
+			history, _ := list.GetVoterPoll(1, poll)
+			if used[history.VoteId] {
+				t.Fatalf("VoteId %d was reused", history.VoteId)
+			}
+			used[history.VoteId] = true
+
+			if rapid.Bool().Draw(t, "delete") {
+				list.DeleteVoterPoll(1, poll)
+			}
+		}
+	})
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func samePolls(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]int(nil), a...)
+	b = append([]int(nil), b...)
+	sort.Ints(a)
+	sort.Ints(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
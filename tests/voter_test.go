@@ -1,11 +1,17 @@
 package tests
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"log"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/adllev/voter-api/bundle"
+	"github.com/adllev/voter-api/client"
 	"github.com/adllev/voter-api/db"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
@@ -15,14 +21,13 @@ var (
 	BASE_API = "http://localhost:1080"
 
 	cli = resty.New()
+	api = client.New(BASE_API)
 )
 
 func TestMain(m *testing.M) {
 
 	//SETUP GOES FIRST
-	rsp, err := cli.R().Delete(BASE_API + "/voters")
-
-	if rsp.StatusCode() != 200 {
+	if err := api.DeleteAllVoters(context.Background()); err != nil {
 		log.Printf("error clearing database, %v", err)
 		os.Exit(1)
 	}
@@ -43,13 +48,10 @@ func Test_AddSingleVoter(t *testing.T) {
 		VoteHistory: nil,
 	}
 
-	rsp, err := cli.R().
-		SetBody(newVoter).
-		SetResult(&newVoter).
-		Post(BASE_API + "/voters")
+	voter, err := api.CreateVoter(context.Background(), newVoter)
 
 	assert.Nil(t, err)
-	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, voter.VoterId)
 }
 
 func Test_AddSingleVoterPoll(t *testing.T) {
@@ -59,17 +61,87 @@ func Test_AddSingleVoterPoll(t *testing.T) {
 		VoteDate: time.Now(),
 	}
 
-	rsp, err := cli.R().
-		SetBody(newVoterPoll).
-		SetResult(&newVoterPoll).
-		Post(BASE_API + "/voters/1/polls/1")
+	vote, err := api.AddVote(context.Background(), 1, 1, newVoterPoll)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, vote.VoteId)
+}
+
+func Test_AddDuplicateVoterPoll(t *testing.T) {
+	vote := db.VoterHistory{PollId: 1, VoteId: 1, VoteDate: time.Now(), Choice: "yes"}
+
+	_, err := api.AddVote(context.Background(), 1, 1, vote)
+
+	assert.Equal(t, 409, err.(*client.Error).Status)
+	assert.Equal(t, client.CodeAlreadyVoted, err.(*client.Error).Code)
+
+	rsp, err := cli.R().SetBody(vote).Post(BASE_API + "/voters/1/polls/1?override=true")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
 
+	history, err := api.VoterHistory(context.Background(), 1).All()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "yes", history[0].Choice)
+}
+
+func Test_AddVoterPollBadDate(t *testing.T) {
+	for _, date := range []time.Time{{}, time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)} {
+		var rspErr client.Error
+		rsp, err := cli.R().
+			SetBody(db.VoterHistory{PollId: 1, VoteId: 1, VoteDate: date}).
+			SetError(&rspErr).
+			Put(BASE_API + "/voters/1/polls/1")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 400, rsp.StatusCode())
+		assert.Equal(t, client.CodeInvalidVoteDate, rspErr.Code)
+	}
+
+	rsp, err := cli.R().SetBody(db.VoterHistory{}).Post(BASE_API + "/voters/1/polls/2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
+func Test_AddDuplicateVoter(t *testing.T) {
+	duplicate := db.Voter{
+		VoterId: 1,
+		Name:    "Jane Smith",
+		Email:   "jane@example.com",
+	}
+
+	_, err := api.CreateVoter(context.Background(), duplicate)
+
+	assert.Equal(t, 409, err.(*client.Error).Status)
+
+	duplicate.VoterId = 99
+	_, err = api.UpdateVoter(context.Background(), duplicate)
+
+	assert.Equal(t, 404, err.(*client.Error).Status)
 }
 
 
+func Test_AddInvalidVoter(t *testing.T) {
+	invalid := db.Voter{
+		VoterId: -1,
+		Email:   "Jane <jane@example.com>",
+	}
+	var rspErr client.Error
+
+	rsp, err := cli.R().SetBody(invalid).SetError(&rspErr).Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	assert.Equal(t, client.CodeInvalidFields, rspErr.Code)
+	assert.Equal(t, 3, len(rspErr.Fields))
+	assert.Equal(t, "VoterId", rspErr.Fields[0].Field)
+	assert.Equal(t, "required", rspErr.Fields[1].Rule)
+	assert.Equal(t, "email", rspErr.Fields[2].Rule)
+}
+
 func Test_GetAllVoters(t *testing.T) {
 	var items []db.Voter
 
@@ -81,43 +153,383 @@ func Test_GetAllVoters(t *testing.T) {
 	assert.Equal(t, 1, len(items))
 }
 
-func Test_GetSingleVoter(t *testing.T) {
-	var voter db.Voter
+func Test_GetVotersPage(t *testing.T) {
+	var items []db.Voter
 
-	rsp, err := cli.R().SetResult(&voter).Get(BASE_API + "/voters/1")
+	rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/voters?limit=1&offset=1")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
 
+	assert.Equal(t, 0, len(items))
+	assert.Equal(t, "1", rsp.Header().Get("X-Total-Count"))
+	assert.Contains(t, rsp.Header().Get("Link"), `rel="prev"`)
+}
+
+func Test_GetSingleVoter(t *testing.T) {
+	voter, err := api.GetVoter(context.Background(), 1)
+
+	assert.Nil(t, err)
+
 	assert.Equal(t, 1, voter.VoterId)
 	assert.Equal(t, "Jane Smith", voter.Name)
 	assert.Equal(t, "jane@example.com", voter.Email)
 }
 
-func Test_GetVoterPolls(t *testing.T) {
-	var voterHistory []db.VoterHistory
+func Test_GetVoterByEmail(t *testing.T) {
+	var voter db.Voter
 
-	rsp, err := cli.R().SetResult(&voterHistory).Get(BASE_API + "/voters/1/polls")
+	rsp, err := cli.R().SetResult(&voter).Get(BASE_API + "/voters/by-email/Jane@Example.com")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, voter.VoterId)
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/by-email/nobody@example.com")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+
+	taken := db.Voter{VoterId: 2, Name: "John Smith", Email: "JANE@example.com"}
+	rsp, err = cli.R().SetBody(taken).Post(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
 }
 
-func Test_GetSingleVoterPoll(t *testing.T) {
-	var voterPoll db.VoterHistory
+func Test_GetVoterPolls(t *testing.T) {
+	voterHistory, err := api.VoterHistory(context.Background(), 1).All()
 
-	rsp, err := cli.R().SetResult(&voterPoll).Get(BASE_API + "/voters/1/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(voterHistory))
+}
+
+func Test_GetSingleVoterPoll(t *testing.T) {
+	voterPoll, err := api.GetVote(context.Background(), 1, 1)
 
 	assert.Nil(t, err)
-	assert.Equal(t, 200, rsp.StatusCode())
 
 	assert.Equal(t, 1, voterPoll.PollId)
 	assert.Equal(t, 1, voterPoll.VoteId)
 }
 
 func Test_GetVotersHealth(t *testing.T) {
-	rsp, err := cli.R().Get(BASE_API + "/voters/health")
+	health, err := api.Health(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", health.Status)
+}
+func Test_GetChecksum(t *testing.T) {
+	var checksum struct {
+		Root  string
+		Count int
+		Nodes []string
+	}
+
+	rsp, err := cli.R().SetResult(&checksum).Get(BASE_API + "/admin/checksum?depth=1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	assert.NotEmpty(t, checksum.Root)
+	assert.Equal(t, 1, checksum.Count)
+	assert.Equal(t, 2, len(checksum.Nodes))
+}
+
+func Test_GetVotersSorted(t *testing.T) {
+	var items []db.Voter
+
+	rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/voters?sort=name&order=desc")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(items))
+
+	rsp, err = cli.R().Get(BASE_API + "/voters?sort=age")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
+func Test_PatchVoter(t *testing.T) {
+	voter, err := api.PatchVoter(context.Background(), 1, map[string]any{"Name": "Jane Q. Smith"})
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Jane Q. Smith", voter.Name)
+	assert.Equal(t, "jane@example.com", voter.Email)
+	assert.Equal(t, 1, len(voter.VoteHistory))
+}
+
+func Test_PatchVoterPolls(t *testing.T) {
+	var history []db.VoterHistory
+
+	rsp, err := cli.R().
+		SetHeader("Content-Type", "application/json-patch+json").
+		SetBody(`[{"op": "test", "path": "/0/PollId", "value": 1},
+			{"op": "replace", "path": "/0/Channel", "value": "online"}]`).
+		SetResult(&history).
+		Patch(BASE_API + "/voters/1/polls")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "online", history[0].Channel)
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json-patch+json").
+		SetBody(`[{"op": "remove", "path": "/0/Channel"}, {"op": "test", "path": "/0/PollId", "value": 2}]`).
+		Patch(BASE_API + "/voters/1/polls")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+}
+
+func Test_ProvenanceRules(t *testing.T) {
+	rsp, err := cli.R().SetBody(`{"Address": "state-feed"}`).SetHeader("Content-Type", "application/json").
+		Put(BASE_API + "/admin/provenance")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
-}
\ No newline at end of file
+
+	var voter db.Voter
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"Address": {"Street": "1 Elm St"}, "Tags": ["moved"]}`).
+		SetResult(&voter).
+		Patch(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Address", rsp.Header().Get("X-Provenance-Held"))
+	assert.Equal(t, "", voter.Address.Street)
+	assert.Equal(t, []string{"moved"}, voter.Tags)
+
+	var conflicts []db.ProvenanceConflict
+	rsp, err = cli.R().SetResult(&conflicts).Get(BASE_API + "/admin/provenance/conflicts")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(conflicts))
+	assert.Equal(t, "state-feed", conflicts[0].Owner)
+	assert.Equal(t, "anonymous", conflicts[0].Source)
+
+	rsp, err = cli.R().SetBody(`{}`).SetHeader("Content-Type", "application/json").Put(BASE_API + "/admin/provenance")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_ReviewQueue(t *testing.T) {
+	var items []db.ReviewItem
+	rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/admin/reviews?kind=provenance&status=open")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, 1, items[0].VoterId)
+	id := items[0].Id
+
+	var item db.ReviewItem
+	rsp, err = cli.R().SetBody(`{"Assignee": "clerk"}`).SetHeader("Content-Type", "application/json").
+		SetResult(&item).SetPathParam("id", strconv.Itoa(id)).Post(BASE_API + "/admin/reviews/{id}/assign")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.ReviewAssigned, item.Status)
+	assert.Equal(t, "clerk", item.Assignee)
+
+	rsp, err = cli.R().SetBody(`{"Resolution": "accept", "Note": "confirmed by phone"}`).
+		SetHeader("Content-Type", "application/json").
+		SetResult(&item).SetPathParam("id", strconv.Itoa(id)).Post(BASE_API + "/admin/reviews/{id}/resolve")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, db.ReviewResolved, item.Status)
+
+	var voter db.Voter
+	rsp, err = cli.R().SetResult(&voter).Get(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1 Elm St", voter.Address.Street)
+
+	rsp, err = cli.R().SetBody(`{"Resolution": "reject"}`).SetHeader("Content-Type", "application/json").
+		SetPathParam("id", strconv.Itoa(id)).Post(BASE_API + "/admin/reviews/{id}/resolve")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 409, rsp.StatusCode())
+
+	var metrics db.ReviewMetrics
+	rsp, err = cli.R().SetResult(&metrics).Get(BASE_API + "/admin/reviews/metrics")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, metrics.Resolved)
+	assert.Equal(t, 0, metrics.OpenByKind["provenance"])
+
+	rsp, err = cli.R().Get(BASE_API + "/admin/reviews/999")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 404, rsp.StatusCode())
+}
+
+func Test_BackupAndImport(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/admin/export/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	backup := rsp.Body()
+	manifest, files, err := bundle.Read(bytes.NewReader(backup))
+	assert.Nil(t, err)
+	entry, ok := manifest.Entry("voters.jsonl")
+	assert.True(t, ok)
+	assert.Equal(t, 1, entry.Records)
+	assert.NotEmpty(t, files["voters.jsonl"])
+
+	var report db.ImportReport
+	rsp, err = cli.R().SetBody(backup).SetResult(&report).Post(BASE_API + "/admin/import/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, report.Skipped)
+
+	rsp, err = cli.R().SetBody(backup[:len(backup)-600]).Post(BASE_API + "/admin/import/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
+func Test_ImportStrategies(t *testing.T) {
+	data, _ := json.Marshal(db.Voter{VoterId: 1, Tags: []string{"imported"}})
+	var pkg bytes.Buffer
+	assert.Nil(t, bundle.Write(&pkg, bundle.File{Name: "voters.jsonl", Data: append(data, '\n'), Records: 1}))
+
+	var report db.ImportReport
+	rsp, err := cli.R().SetBody(pkg.Bytes()).SetResult(&report).Post(BASE_API + "/admin/import/voters?strategy=merge-fields")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, report.Merged)
+
+	var voter db.Voter
+	rsp, err = cli.R().SetResult(&voter).Get(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane Q. Smith", voter.Name)
+	assert.Equal(t, []string{"imported"}, voter.Tags)
+	assert.Equal(t, 1, len(voter.VoteHistory))
+
+	report = db.ImportReport{}
+	rsp, err = cli.R().SetBody(pkg.Bytes()).SetResult(&report).Post(BASE_API + "/admin/import/voters?strategy=fail")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, db.ImportFailed, report.Outcomes[0].Outcome)
+
+	rsp, err = cli.R().SetBody(pkg.Bytes()).Post(BASE_API + "/admin/import/voters?strategy=replace")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+}
+
+func Test_UpdateVoterIdMismatch(t *testing.T) {
+	voter := db.Voter{
+		VoterId: 2,
+		Name:    "Jane Smith",
+		Email:   "jane@example.com",
+	}
+
+	rsp, err := cli.R().SetBody(voter).Put(BASE_API + "/voters/1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 400, rsp.StatusCode())
+	assert.Contains(t, string(rsp.Body()), "ID_MISMATCH")
+}
+
+func Test_DeleteMissingVoter(t *testing.T) {
+	err := api.DeleteVoter(context.Background(), 99)
+
+	assert.Equal(t, 404, err.(*client.Error).Status)
+	assert.Equal(t, client.CodeVoterNotFound, err.(*client.Error).Code)
+}
+
+func Test_ClientIterators(t *testing.T) {
+	var all []db.Voter
+	rsp, err := cli.R().SetResult(&all).Get(BASE_API + "/voters")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	paged := client.New(BASE_API)
+	paged.PageSize = 1
+
+	voters := paged.Voters(context.Background(), nil)
+	var ids []int
+	for voters.Next() {
+		ids = append(ids, voters.Item().VoterId)
+	}
+	assert.Nil(t, voters.Err())
+	assert.Equal(t, len(all), voters.Total())
+	assert.Equal(t, len(all), len(ids))
+
+	history, err := paged.VoterHistory(context.Background(), 1).All()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+
+	var unpaged []db.AuditEntry
+	rsp, err = cli.R().SetResult(&unpaged).Get(BASE_API + "/admin/audit?voter=1")
+
+	assert.Nil(t, err)
+	assert.Less(t, 1, len(unpaged))
+
+	entries, err := paged.AuditEntries(context.Background(), 1).All()
+
+	assert.Nil(t, err)
+	assert.Equal(t, unpaged, entries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := paged.Voters(ctx, nil)
+
+	assert.False(t, canceled.Next())
+	assert.ErrorIs(t, canceled.Err(), context.Canceled)
+
+	_, err = paged.VoterHistory(context.Background(), 99).All()
+
+	assert.Equal(t, client.CodeVoterNotFound, err.(*client.Error).Code)
+}
+
+func Test_OpenAPI(t *testing.T) {
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths map[string]map[string]struct {
+			OperationId string `json:"operationId"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required []string `json:"required"`
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	rsp, err := cli.R().SetResult(&doc).Get(BASE_API + "/voters/openapi.json")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Equal(t, "addVote", doc.Paths["/voters/{id}/polls/{pollid}"]["post"].OperationId)
+	assert.Equal(t, "listVoters", doc.Paths["/voters"]["get"].OperationId)
+	assert.Equal(t, []string{"Name"}, doc.Components.Schemas["Voter"].Required)
+	assert.Equal(t, float64(100), doc.Components.Schemas["Voter"].Properties["Name"]["maxLength"])
+	assert.Contains(t, doc.Components.Schemas["Error"].Properties, "code")
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/docs")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Contains(t, rsp.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rsp.String(), "/voters/openapi.json")
+}
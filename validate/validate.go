@@ -0,0 +1,166 @@
+// Package validate checks request payloads against the rules in the
+// validate tags of their fields, for example
+//
+//	Name  string `validate:"required,max=100"`
+//	Email string `validate:"omitempty,email,max=254"`
+//
+// The rules are required, omitempty, min=N and max=N, which bound the
+// value of a number, the length of a string in characters and the length
+// of a list, and email, an RFC 5322 address without a display name.
+// Structs inside a payload and lists of them are checked as well, their
+// fields are named by their path, e.g. Address.Zip or VoteHistory[2].PollId.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a field that broke one of its rules
+type FieldError struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// Errors are every field of a payload that broke a rule, in field order
+type Errors []FieldError
+
+// Error lists the messages of the fields
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Struct checks a struct, or a pointer to one, against its validate tags.
+// It returns Errors when a field breaks a rule and panics on a tag it can
+// not read, which is a bug in the struct and not in the payload.
+func Struct(v any) error {
+	var errs Errors
+	check(reflect.Indirect(reflect.ValueOf(v)), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check walks the fields of a struct and the structs inside it
+func check(value reflect.Value, path string, errs *Errors) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			if tag := field.Tag.Get("validate"); tag != "" {
+				checkField(value.Field(i), name, tag, errs)
+			}
+			check(value.Field(i), name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if kind := value.Type().Elem().Kind(); kind != reflect.Struct && kind != reflect.Pointer {
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			check(value.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Pointer:
+		if !value.IsNil() {
+			check(value.Elem(), path, errs)
+		}
+	}
+}
+
+// checkField applies the rules of a tag to a field, only the first rule a
+// field breaks is reported
+func checkField(value reflect.Value, name, tag string, errs *Errors) {
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(rule, "=")
+
+		var message string
+		switch rule {
+		case "omitempty":
+			if value.IsZero() {
+				return
+			}
+		case "required":
+			if value.IsZero() {
+				message = name + " is required"
+			}
+		case "min":
+			if size(value, rule) < bound(arg, name, rule) {
+				message = fmt.Sprintf("%s must be at least %s%s", name, arg, unit(value))
+			}
+		case "max":
+			if size(value, rule) > bound(arg, name, rule) {
+				message = fmt.Sprintf("%s must be at most %s%s", name, arg, unit(value))
+			}
+		case "email":
+			if !isEmail(value.String()) {
+				message = name + " is not a valid email address"
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+
+		if message != "" {
+			*errs = append(*errs, FieldError{Field: name, Rule: rule, Message: message})
+			return
+		}
+	}
+}
+
+// size is what min and max bound: the value of a number, the length of a
+// string in characters or the length of a list
+func size(value reflect.Value, rule string) int64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	case reflect.String:
+		return int64(utf8.RuneCountInString(value.String()))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return int64(value.Len())
+	}
+	panic(fmt.Sprintf("validate: %s does not apply to %s", rule, value.Type()))
+}
+
+// unit names what a bound counts in messages
+func unit(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " entries"
+	}
+	return ""
+}
+
+// bound parses the argument of min or max
+func bound(arg, name, rule string) int64 {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: %s on %s needs a number, not %q", rule, name, arg))
+	}
+	return n
+}
+
+// isEmail reports whether s is a bare RFC 5322 address, jane@example.com
+// but not Jane <jane@example.com>
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s && strings.Contains(s, "@")
+}
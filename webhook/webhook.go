@@ -0,0 +1,289 @@
+// Package webhook delivers events to the URLs of webhooks as signed JSON
+// POSTs.  Delivery happens in the background through a bounded buffer, a
+// slow or failing receiver never holds up a request.  A delivery that
+// fails with a network error, a 429 or a 5xx is retried with exponential
+// backoff up to a number of attempts, other 4xx answers are final.  Every
+// attempt of a delivery carries the same X-Webhook-Delivery id, so a
+// receiver can drop the duplicates a retry may cause.
+//
+// Deliveries are signed with the webhook's secret.  X-Webhook-Signature
+// holds t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">, see
+// Verify for what a receiver checks.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// recentAttempts is the number of attempts kept for Attempts
+const recentAttempts = 100
+
+// maxBackoff is the longest wait between two attempts of a delivery
+const maxBackoff = 5 * time.Minute
+
+// Delivery is an event on its way to a webhook
+type Delivery struct {
+	Id      string //Set by Send
+	Webhook string //Name of the webhook
+	URL     string
+	Secret  string
+	Event   string
+	Body    []byte //JSON
+
+	attempt int
+}
+
+// Attempt is the outcome of one attempt of a delivery
+type Attempt struct {
+	Delivery string
+	Webhook  string
+	Event    string
+	Attempt  int //Counts from 1
+	Time     time.Time
+	Status   int //HTTP status, 0 when no response came back
+	Error    string
+	Final    bool //No further attempt follows
+}
+
+// Stats are the counters of a sender
+type Stats struct {
+	Queued    int64
+	Delivered int64
+	Retried   int64 //Attempts that failed and were tried again
+	Failed    int64 //Deliveries given up on
+	Dropped   int64 //Deliveries that did not fit in the buffer
+}
+
+// Sender delivers to webhooks
+type Sender struct {
+	client   *http.Client
+	queue    chan Delivery
+	attempts int
+	backoff  time.Duration
+
+	mu     sync.Mutex
+	stats  Stats
+	recent []Attempt
+}
+
+// constructor for Sender struct.  workers deliver at the same time,
+// buffer deliveries may wait for one, a delivery is attempted up to
+// attempts times and backoff is the wait before the first retry, it
+// doubles with every further one.  It starts the workers.
+func NewSender(client *http.Client, workers, buffer, attempts int, backoff time.Duration) *Sender {
+	s := &Sender{
+		client:   client,
+		queue:    make(chan Delivery, buffer),
+		attempts: max(attempts, 1),
+		backoff:  backoff,
+	}
+	for i := 0; i < workers; i++ {
+		go s.run()
+	}
+
+	return s
+}
+
+// Send queues a delivery and returns its id, it never blocks.  If the
+// buffer is full the delivery is dropped.
+func (s *Sender) Send(delivery Delivery) string {
+	delivery.Id = newId()
+	delivery.attempt = 1
+	if s.enqueue(delivery) {
+		s.count(func(st *Stats) { st.Queued++ })
+	}
+	return delivery.Id
+}
+
+// enqueue puts a delivery in the buffer, or counts it as dropped
+func (s *Sender) enqueue(delivery Delivery) bool {
+	select {
+	case s.queue <- delivery:
+		return true
+	default:
+		s.count(func(st *Stats) { st.Dropped++ })
+		return false
+	}
+}
+
+// Stats returns the sender counters
+func (s *Sender) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// Attempts returns the latest attempts, newest first
+func (s *Sender) Attempts() []Attempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := make([]Attempt, len(s.recent))
+	for i, attempt := range s.recent {
+		attempts[len(s.recent)-1-i] = attempt
+	}
+	return attempts
+}
+
+// count updates the counters
+func (s *Sender) count(change func(st *Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change(&s.stats)
+}
+
+// run delivers queued deliveries.  A retry waits on a timer rather than
+// in the worker, so one failing receiver does not hold up the others.
+func (s *Sender) run() {
+	for delivery := range s.queue {
+		status, err := s.deliver(delivery)
+
+		attempt := Attempt{
+			Delivery: delivery.Id,
+			Webhook:  delivery.Webhook,
+			Event:    delivery.Event,
+			Attempt:  delivery.attempt,
+			Time:     time.Now(),
+			Status:   status,
+			Final:    true,
+		}
+		retry := false
+		if err != nil {
+			attempt.Error = err.Error()
+			retry = retryable(status) && delivery.attempt < s.attempts
+			attempt.Final = !retry
+		}
+		s.record(attempt, err == nil, retry)
+
+		if retry {
+			log.Printf("Error delivering %s to webhook %s, attempt %d: %v", delivery.Event, delivery.Webhook, delivery.attempt, err)
+			wait := min(s.backoff<<(delivery.attempt-1), maxBackoff)
+			delivery.attempt++
+			time.AfterFunc(wait, func() { s.enqueue(delivery) })
+		} else if err != nil {
+			log.Printf("Error delivering %s to webhook %s, giving up: %v", delivery.Event, delivery.Webhook, err)
+		}
+	}
+}
+
+// record keeps an attempt and counts its outcome
+func (s *Sender) record(attempt Attempt, delivered, retry bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recent = append(s.recent, attempt)
+	if len(s.recent) > recentAttempts {
+		s.recent = s.recent[1:]
+	}
+	switch {
+	case delivered:
+		s.stats.Delivered++
+	case retry:
+		s.stats.Retried++
+	default:
+		s.stats.Failed++
+	}
+}
+
+// retryable reports whether an attempt that ended with a status is worth
+// repeating, no status at all is a network error
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// deliver makes one attempt of a delivery, any status but a 2xx is an
+// error
+func (s *Sender) deliver(delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "voter-api-webhook")
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, time.Now(), delivery.Body))
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 1<<16))
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return rsp.StatusCode, fmt.Errorf("receiver answered %s", rsp.Status)
+	}
+	return rsp.StatusCode, nil
+}
+
+// Sign returns the X-Webhook-Signature of a body sent at a time
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// signature is the hex HMAC-SHA256 of the timestamp and the body
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the X-Webhook-Signature of a received body, the way a
+// receiver should.  A signature more than tolerance away from now is
+// refused, so a captured delivery can not be replayed later.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return errors.New("webhook: malformed signature")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return errors.New("webhook: signature timestamp out of tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return errors.New("webhook: signature does not match")
+	}
+	return nil
+}
+
+// newId returns a random delivery id
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,326 @@
+// Package websocket serves WebSocket connections (RFC 6455) from fasthttp
+// handlers, for pushing events to clients, for example
+//
+//	if !websocket.IsUpgrade(ctx) {
+//		ctx.Error("expected a WebSocket handshake", fasthttp.StatusUpgradeRequired)
+//		return
+//	}
+//	websocket.Upgrade(ctx, func(conn *websocket.Conn) {
+//		defer conn.Close(websocket.CloseNormal, "")
+//		conn.WriteJSON(event)
+//	})
+//
+// It covers what a server that mostly writes needs: text and binary
+// messages, fragmented messages from the client, ping, pong and the
+// closing handshake.  Extensions such as compression are not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// acceptGUID is appended to the client's key to prove the handshake was
+// understood, see RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize is the largest message a client may send
+const MaxMessageSize = 1 << 20
+
+// writeTimeout bounds every write, a client that stops reading is
+// disconnected rather than holding up its sender
+const writeTimeout = 10 * time.Second
+
+// Message types
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseTryAgainLater   = 1013
+)
+
+// ErrClosed is returned by ReadMessage once the client closed the
+// connection
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is a close frame received from the client
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Reason)
+}
+
+func (e *CloseError) Is(target error) bool {
+	return target == ErrClosed
+}
+
+// Conn is an upgraded connection.  Writes may come from any goroutine,
+// reads from one at a time.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu     sync.Mutex //Serializes writes
+	closed bool       //A close frame was sent
+}
+
+// IsUpgrade reports whether a request is a WebSocket handshake
+func IsUpgrade(ctx *fasthttp.RequestCtx) bool {
+	return ctx.IsGet() &&
+		headerHas(ctx, "Connection", "upgrade") &&
+		headerHas(ctx, "Upgrade", "websocket")
+}
+
+// headerHas reports whether a comma separated header holds a token,
+// ignoring case
+func headerHas(ctx *fasthttp.RequestCtx, header, token string) bool {
+	for _, value := range strings.Split(string(ctx.Request.Header.Peek(header)), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upgrade answers a WebSocket handshake and runs handler on the
+// connection once the response is sent.  The connection is closed when
+// handler returns.  A request that is not a valid handshake is refused
+// with an error and handler is not called.
+func Upgrade(ctx *fasthttp.RequestCtx, handler func(conn *Conn)) error {
+	if !IsUpgrade(ctx) {
+		return errors.New("websocket: not a WebSocket handshake")
+	}
+	if string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		return errors.New("websocket: unsupported version")
+	}
+	key := ctx.Request.Header.Peek("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(string(key)); err != nil || len(decoded) != 16 {
+		return errors.New("websocket: bad Sec-WebSocket-Key")
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Upgrade", "websocket")
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", acceptKey(string(key)))
+	ctx.Response.ResetBody()
+
+	ctx.Hijack(func(c net.Conn) {
+		//The server's read and idle timeouts are for HTTP requests
+		c.SetDeadline(time.Time{})
+		conn := &Conn{conn: c, br: bufio.NewReader(c)}
+		defer conn.conn.Close()
+		handler(conn)
+	})
+	return nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a client key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// RemoteAddr returns the address of the client
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// WriteMessage sends a text or binary message in a single frame
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: unknown message type")
+	}
+	return c.writeFrame(byte(messageType), data)
+}
+
+// WriteJSON sends a value as a JSON text message
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, data)
+}
+
+// Ping sends a ping, the client answers with a pong that ReadMessage
+// consumes.  Pings keep proxies from closing an idle connection and find
+// clients that went away without closing.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with a code and a reason.  The connection
+// itself is closed when the handler returns.
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(opClose, payload)
+}
+
+// writeFrame sends a single unmasked frame, servers never mask
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode //FIN
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadMessage returns the next text or binary message of the client.
+// Pings are answered and pongs dropped on the way.  Once the client
+// closes, its close frame is echoed and the error is a *CloseError, which
+// matches ErrClosed.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+		case opPong:
+		case opClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(closeErr.Code, "")
+			return 0, nil, closeErr
+		case opText, opBinary:
+			data = payload
+			for !fin {
+				var next byte
+				fin, next, payload, err = c.readFrame()
+				if err != nil {
+					return 0, nil, err
+				}
+				if next != opContinuation {
+					c.Close(CloseProtocolError, "expected a continuation frame")
+					return 0, nil, errors.New("websocket: expected a continuation frame")
+				}
+				if len(data)+len(payload) > MaxMessageSize {
+					c.Close(CloseTooBig, "")
+					return 0, nil, errors.New("websocket: message too big")
+				}
+				data = append(data, payload...)
+			}
+			return int(opcode), data, nil
+		default:
+			c.Close(CloseProtocolError, "unexpected opcode")
+			return 0, nil, fmt.Errorf("websocket: unexpected opcode %d", opcode)
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks it, every client frame has
+// to be masked
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		c.Close(CloseProtocolError, "client frames must be masked")
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > MaxMessageSize {
+		c.Close(CloseTooBig, "")
+		return false, 0, nil, errors.New("websocket: frame too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}